@@ -0,0 +1,612 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+var aclCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "Export or import access control list",
+	Long: `Export or import access control list.
+
+The access control list (ACL) is a YAML document that has three sections.
+
+  prefixes:  Operations allowed under the prefix. This limits tokens even if the token allows the operation.
+  tokens:    Names and comments for tokens, identified by fingerprint. Revoked tokens can not be used anymore.
+  quotas:    Maximum total size and number of keys under the prefix.
+             Publishing beyond soft-max-size or soft-max-keys is accepted with a warning.
+
+Import is idempotent, so you can manage ACL in Git and apply it by CI every time.
+The server has to be started with --acl flag to import ACL.
+
+These commands require admin token. See also 'artistore help token'.`,
+	Example: `  $ export ARTISTORE_TOKEN=$(artistore token --admin)
+  $ artistore acl export > acl.yaml
+  $ vim acl.yaml
+  $ artistore acl import --dry-run acl.yaml
+  $ artistore acl import acl.yaml
+
+  # Get fingerprint to write the token into ACL.
+  $ artistore acl fingerprint t2:XXXXXXXX
+
+  # Example of ACL.
+  prefixes:
+  - prefix: release/
+    scopes: [publish, tag]
+    comment: Nobody can delete releases.
+  tokens:
+  - name: ci
+    fingerprint: 0123456789abcdef0123456789abcdef
+    prefix: release/
+  - name: leaked
+    fingerprint: fedcba9876543210fedcba9876543210
+    revoked: true
+  quotas:
+  - prefix: nightly/
+    max-size: 100g
+    max-keys: 1000
+    soft-max-size: 80g`,
+}
+
+var aclExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export access control list of the server",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		client, token, u := aclClient()
+
+		resp, err := client.Do(func() (*http.Request, error) {
+			req, err := http.NewRequest("GET", u, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "bearer "+token.String())
+			return req, nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(resp.Body)
+			fmt.Fprint(os.Stderr, string(msg))
+			os.Exit(1)
+		}
+		io.Copy(os.Stdout, resp.Body)
+	},
+}
+
+var aclImportCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "Import access control list to the server",
+	Long: `Import access control list to the server.
+
+The current ACL is replaced entirely by the FILE. Use "-" as FILE to read from stdin.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var data []byte
+		var err error
+		if args[0] == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(args[0])
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		if _, err := ParseACL(data); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		client, token, u := aclClient()
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			u += "?dry-run=1"
+		}
+
+		resp, err := client.Do(func() (*http.Request, error) {
+			req, err := http.NewRequest("PUT", u, bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "application/yaml")
+			req.Header.Set("Authorization", "bearer "+token.String())
+			return req, nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(resp.Body)
+			fmt.Fprint(os.Stderr, string(msg))
+			os.Exit(1)
+		}
+
+		var result ACLImportResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		switch {
+		case !result.Changed:
+			fmt.Println("ACL is up to date.")
+		case result.DryRun:
+			fmt.Println("ACL will be changed. (dry run)")
+		default:
+			fmt.Println("ACL has been updated.")
+		}
+	},
+}
+
+var aclFingerprintCmd = &cobra.Command{
+	Use:   "fingerprint [TOKEN]",
+	Short: "Show fingerprint of token to write it into access control list",
+	Long: `Show fingerprint of token to write it into access control list.
+
+If TOKEN is omitted, the token in ARTISTORE_TOKEN environment variable is used.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		raw := viper.GetString("token")
+		if len(args) > 0 {
+			raw = args[0]
+		}
+
+		token, err := ParseToken(strings.TrimSpace(raw))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		fmt.Println(token.Fingerprint())
+	},
+}
+
+func aclClient() (*Client, Token, string) {
+	t, err := NewTokenHandler()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	token, err := t.TokenFor(APIPrefix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	u, err := GetAPIURL("v1/acl")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	client, err := NewClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	return client, token, u.String()
+}
+
+func init() {
+	cmd.AddCommand(aclCmd)
+	aclCmd.AddCommand(aclExportCmd)
+	aclCmd.AddCommand(aclImportCmd)
+	aclCmd.AddCommand(aclFingerprintCmd)
+
+	for _, c := range []*cobra.Command{aclExportCmd, aclImportCmd} {
+		c.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+		c.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+		c.Flags().String("token", "", "Admin token. See also 'artistore help token'.")
+	}
+	aclImportCmd.Flags().Bool("dry-run", false, "Only check whether the ACL will be changed.")
+}
+
+var (
+	ErrQuotaExceeded = errors.New("Quota exceeded.")
+	ErrTokenRevoked  = errors.New("This token has been revoked.")
+)
+
+// ACL is the access policy of the server that can be exported and imported as a YAML document.
+type ACL struct {
+	Prefixes []ACLPrefix `yaml:"prefixes" json:"prefixes"`
+	Tokens   []ACLToken  `yaml:"tokens" json:"tokens"`
+	Quotas   []ACLQuota  `yaml:"quotas" json:"quotas"`
+}
+
+// ACLPrefix limits operations under the prefix even if the token allows them.
+// The rule with the longest prefix is used if some rules matched.
+type ACLPrefix struct {
+	Prefix  string   `yaml:"prefix" json:"prefix"`
+	Scopes  []string `yaml:"scopes" json:"scopes"`
+	Comment string   `yaml:"comment,omitempty" json:"comment,omitempty"`
+}
+
+// ACLToken records who has the token.
+// Tokens are not stored in the server, so they are identified by fingerprint. See also Token.Fingerprint.
+type ACLToken struct {
+	Name        string `yaml:"name" json:"name"`
+	Fingerprint string `yaml:"fingerprint" json:"fingerprint"`
+	Prefix      string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	Revoked     bool   `yaml:"revoked,omitempty" json:"revoked,omitempty"`
+	Comment     string `yaml:"comment,omitempty" json:"comment,omitempty"`
+}
+
+// ACLQuota limits total size of all revisions, and number of keys under the prefix.
+//
+// Publishing beyond the soft limits is accepted, but the publisher receives a warning,
+// so that the team can clean up before reaching the hard limits.
+type ACLQuota struct {
+	Prefix      string `yaml:"prefix" json:"prefix"`
+	MaxSize     string `yaml:"max-size,omitempty" json:"max-size,omitempty"`
+	MaxKeys     int    `yaml:"max-keys,omitempty" json:"max-keys,omitempty"`
+	SoftMaxSize string `yaml:"soft-max-size,omitempty" json:"soft-max-size,omitempty"`
+	SoftMaxKeys int    `yaml:"soft-max-keys,omitempty" json:"soft-max-keys,omitempty"`
+}
+
+func ParseACL(data []byte) (ACL, error) {
+	var acl ACL
+	if err := yaml.UnmarshalStrict(data, &acl); err != nil {
+		return ACL{}, fmt.Errorf("Invalid ACL: %s", err)
+	}
+	return acl.Normalize()
+}
+
+func verifyACLPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	err := VerifyKey(prefix)
+	if err == ErrSlashKey && prefix[0] != '/' {
+		return nil
+	}
+	return err
+}
+
+// Normalize validates the ACL, and sorts entries so that the same policy always has the same representation.
+func (a ACL) Normalize() (ACL, error) {
+	var n ACL
+
+	prefixes := make(map[string]bool)
+	for _, p := range a.Prefixes {
+		if err := verifyACLPrefix(p.Prefix); err != nil {
+			return ACL{}, fmt.Errorf("Invalid ACL: prefix %q: %s", p.Prefix, err)
+		}
+		if prefixes[p.Prefix] {
+			return ACL{}, fmt.Errorf("Invalid ACL: prefix %q is duplicated.", p.Prefix)
+		}
+		prefixes[p.Prefix] = true
+
+		scopes := []string{}
+		if len(p.Scopes) > 0 {
+			scope, err := ParseScope(strings.Join(p.Scopes, ","))
+			if err != nil {
+				return ACL{}, fmt.Errorf("Invalid ACL: prefix %q: %s", p.Prefix, err)
+			}
+			scopes = strings.Split(scope.String(), ",")
+		}
+		n.Prefixes = append(n.Prefixes, ACLPrefix{p.Prefix, scopes, p.Comment})
+	}
+	sort.Slice(n.Prefixes, func(i, j int) bool {
+		return n.Prefixes[i].Prefix < n.Prefixes[j].Prefix
+	})
+
+	fingerprints := make(map[string]bool)
+	for _, t := range a.Tokens {
+		t.Fingerprint = strings.ToLower(strings.TrimSpace(t.Fingerprint))
+		if b, err := hex.DecodeString(t.Fingerprint); err != nil || len(b) != 16 {
+			return ACL{}, fmt.Errorf("Invalid ACL: token %q: fingerprint should be 32 hex characters.", t.Name)
+		}
+		if fingerprints[t.Fingerprint] {
+			return ACL{}, fmt.Errorf("Invalid ACL: token %q: fingerprint is duplicated.", t.Name)
+		}
+		fingerprints[t.Fingerprint] = true
+
+		if err := verifyACLPrefix(t.Prefix); err != nil {
+			return ACL{}, fmt.Errorf("Invalid ACL: token %q: %s", t.Name, err)
+		}
+		n.Tokens = append(n.Tokens, t)
+	}
+	sort.Slice(n.Tokens, func(i, j int) bool {
+		if n.Tokens[i].Name != n.Tokens[j].Name {
+			return n.Tokens[i].Name < n.Tokens[j].Name
+		}
+		return n.Tokens[i].Fingerprint < n.Tokens[j].Fingerprint
+	})
+
+	prefixes = make(map[string]bool)
+	for _, q := range a.Quotas {
+		if err := verifyACLPrefix(q.Prefix); err != nil {
+			return ACL{}, fmt.Errorf("Invalid ACL: quota %q: %s", q.Prefix, err)
+		}
+		if prefixes[q.Prefix] {
+			return ACL{}, fmt.Errorf("Invalid ACL: quota %q is duplicated.", q.Prefix)
+		}
+		prefixes[q.Prefix] = true
+
+		if q.MaxSize != "" {
+			if _, err := ParseSize(q.MaxSize); err != nil {
+				return ACL{}, fmt.Errorf("Invalid ACL: quota %q: %s", q.Prefix, err)
+			}
+		}
+		if q.MaxKeys < 0 {
+			return ACL{}, fmt.Errorf("Invalid ACL: quota %q: max-keys can not be negative.", q.Prefix)
+		}
+		if q.SoftMaxSize != "" {
+			soft, err := ParseSize(q.SoftMaxSize)
+			if err != nil {
+				return ACL{}, fmt.Errorf("Invalid ACL: quota %q: %s", q.Prefix, err)
+			}
+			if max, _ := ParseSize(q.MaxSize); q.MaxSize != "" && soft > max {
+				return ACL{}, fmt.Errorf("Invalid ACL: quota %q: soft-max-size can not be bigger than max-size.", q.Prefix)
+			}
+		}
+		if q.SoftMaxKeys < 0 {
+			return ACL{}, fmt.Errorf("Invalid ACL: quota %q: soft-max-keys can not be negative.", q.Prefix)
+		}
+		if q.MaxKeys > 0 && q.SoftMaxKeys > q.MaxKeys {
+			return ACL{}, fmt.Errorf("Invalid ACL: quota %q: soft-max-keys can not be bigger than max-keys.", q.Prefix)
+		}
+		n.Quotas = append(n.Quotas, q)
+	}
+	sort.Slice(n.Quotas, func(i, j int) bool {
+		return n.Quotas[i].Prefix < n.Quotas[j].Prefix
+	})
+
+	return n, nil
+}
+
+func (a ACL) Marshal() ([]byte, error) {
+	return yaml.Marshal(a)
+}
+
+func (a ACL) prefixRule(key string) (ACLPrefix, bool) {
+	var found ACLPrefix
+	ok := false
+	for _, p := range a.Prefixes {
+		if strings.HasPrefix(key, p.Prefix) && (!ok || len(p.Prefix) > len(found.Prefix)) {
+			found = p
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// Allow checks whether the token can do the operation on the key.
+// The token should be verified before call this method.
+func (a ACL) Allow(token Token, key string, scope Scope) error {
+	fingerprint := token.Fingerprint()
+	for _, t := range a.Tokens {
+		if t.Fingerprint == fingerprint && t.Revoked {
+			return ErrTokenRevoked
+		}
+	}
+
+	// Administration APIs are not limited by prefix rules, to prevent locking out administrators by mistake.
+	if strings.HasPrefix(key, APIPrefix) {
+		return nil
+	}
+
+	if p, ok := a.prefixRule(key); ok {
+		allowed, _ := ParseScope(strings.Join(p.Scopes, ","))
+		if !allowed.Has(scope) {
+			return fmt.Errorf("The operation %s is not allowed under %q by ACL.", scope, p.Prefix)
+		}
+	}
+
+	return nil
+}
+
+// CheckQuota checks whether the store can accept a new revision of the key in size bytes.
+// The returned warnings are messages for soft limits that the new revision exceeds.
+func (a ACL) CheckQuota(store Store, key string, size int) (warnings []string, err error) {
+	for _, q := range a.Quotas {
+		if !strings.HasPrefix(key, q.Prefix) {
+			continue
+		}
+
+		keys, err := store.List(q.Prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		exists := false
+		for _, k := range keys {
+			if k == key {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			if q.MaxKeys > 0 && len(keys) >= q.MaxKeys {
+				return nil, fmt.Errorf("%w %q can have only %d keys.", ErrQuotaExceeded, q.Prefix, q.MaxKeys)
+			}
+			if q.SoftMaxKeys > 0 && len(keys) >= q.SoftMaxKeys {
+				warnings = append(warnings, fmt.Sprintf("%q has %d keys, that exceeds the soft limit of %d keys.", q.Prefix, len(keys)+1, q.SoftMaxKeys))
+			}
+		}
+
+		if q.MaxSize != "" || q.SoftMaxSize != "" {
+			total := int64(size)
+			for _, k := range keys {
+				metas, err := store.Revisions(k)
+				if err != nil && err != ErrNoSuchArtifact {
+					return nil, err
+				}
+				for _, m := range metas {
+					total += int64(m.Size)
+				}
+			}
+
+			if max, _ := ParseSize(q.MaxSize); q.MaxSize != "" && total > max {
+				return nil, fmt.Errorf("%w %q can store only %s.", ErrQuotaExceeded, q.Prefix, q.MaxSize)
+			}
+			if soft, _ := ParseSize(q.SoftMaxSize); q.SoftMaxSize != "" && total > soft {
+				warnings = append(warnings, fmt.Sprintf("%q uses %d bytes, that exceeds the soft limit of %s.", q.Prefix, total, q.SoftMaxSize))
+			}
+		}
+	}
+
+	return warnings, nil
+}
+
+// ACLStore keeps ACL in a YAML file.
+type ACLStore struct {
+	Path string
+
+	lock sync.RWMutex
+	acl  ACL
+}
+
+func LoadACLStore(path string) (*ACLStore, error) {
+	s := &ACLStore{Path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	s.acl, err = ParseACL(data)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ACLStore) Get() ACL {
+	if s == nil {
+		return ACL{}
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.acl
+}
+
+// Set replaces ACL, and saves it to the file.
+// It does nothing if the new ACL is the same as current one, so that it is safe to apply the same document many times.
+func (s *ACLStore) Set(acl ACL, dryRun bool) (changed bool, err error) {
+	acl, err = acl.Normalize()
+	if err != nil {
+		return false, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if reflect.DeepEqual(acl, s.acl) {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+
+	data, err := acl.Marshal()
+	if err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(s.Path+".tmp", data, 0600); err != nil {
+		return false, err
+	}
+	if err := os.Rename(s.Path+".tmp", s.Path); err != nil {
+		return false, err
+	}
+
+	s.acl = acl
+	return true, nil
+}
+
+type ACLImportResult struct {
+	Changed bool `json:"changed"`
+	DryRun  bool `json:"dry_run,omitempty"`
+}
+
+func (s Server) ServeACL(path string, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+		if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+			return
+		}
+
+		data, err := s.ACL.Get().Marshal()
+		if err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(data)
+	case "PUT":
+		if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+			return
+		}
+
+		if s.ACL == nil {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintln(w, "ACL is not enabled on this server. Please start server with --acl flag.")
+			return
+		}
+
+		defer r.Body.Close()
+		data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid request body: %s\n", err)
+			return
+		}
+
+		acl, err := ParseACL(data)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
+			return
+		}
+
+		dryRun := r.URL.Query().Get("dry-run") == "1"
+		changed, err := s.ACL.Set(acl, dryRun)
+		if err != nil {
+			PrintErr("ERROR", "failed to save ACL: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+		if changed && !dryRun {
+			PrintImportant("ACL", "updated by %s", r.RemoteAddr)
+		}
+
+		writeJSON(w, http.StatusOK, ACLImportResult{changed, dryRun})
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, HEAD, PUT, OPTIONS")
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestACLAllow(t *testing.T) {
+	secret := Secret("hello")
+	token, _ := NewScopedToken(secret, "release/", ScopePublish|ScopeDelete)
+	revoked, _ := NewToken(secret, "release/")
+
+	acl, err := ParseACL([]byte(`
+prefixes:
+- prefix: release/
+  scopes: [publish]
+- prefix: release/tmp/
+  scopes: [publish, delete]
+tokens:
+- name: revoked
+  fingerprint: ` + revoked.Fingerprint() + `
+  revoked: true
+`))
+	if err != nil {
+		t.Fatalf("failed to parse ACL: %s", err)
+	}
+
+	tests := []struct {
+		Token Token
+		Key   string
+		Scope Scope
+		OK    bool
+	}{
+		{token, "release/hello", ScopePublish, true},
+		{token, "release/hello", ScopeDelete, false},
+		{token, "release/tmp/hello", ScopeDelete, true},
+		{token, "nightly/hello", ScopeDelete, true},
+		{revoked, "release/hello", ScopePublish, false},
+	}
+
+	for _, tt := range tests {
+		err := acl.Allow(tt.Token, tt.Key, tt.Scope)
+		if tt.OK && err != nil {
+			t.Errorf("%s %s: expected allowed but got %s", tt.Scope, tt.Key, err)
+		} else if !tt.OK && err == nil {
+			t.Errorf("%s %s: expected rejected but allowed", tt.Scope, tt.Key)
+		}
+	}
+}
+
+func TestACLNormalize(t *testing.T) {
+	tests := []struct {
+		Input string
+		Error bool
+	}{
+		{"prefixes: [{prefix: a/, scopes: [tag, publish]}]", false},
+		{"prefixes: [{prefix: a/, scopes: [unknown]}]", true},
+		{"prefixes: [{prefix: a/}, {prefix: a/}]", true},
+		{"prefixes: [{prefix: /a}]", true},
+		{"tokens: [{name: a, fingerprint: xyz}]", true},
+		{"quotas: [{prefix: a/, max-size: 10g}]", false},
+		{"quotas: [{prefix: a/, max-size: big}]", true},
+		{"unknown: field", true},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseACL([]byte(tt.Input))
+		if tt.Error && err == nil {
+			t.Errorf("%s: expected error but got nil", tt.Input)
+		} else if !tt.Error && err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.Input, err)
+		}
+	}
+
+	a, _ := ParseACL([]byte("prefixes: [{prefix: b/, scopes: [tag, publish]}, {prefix: a/}]"))
+	b, _ := ParseACL([]byte("prefixes: [{prefix: a/, scopes: []}, {prefix: b/, scopes: [publish, tag]}]"))
+	x, _ := a.Marshal()
+	y, _ := b.Marshal()
+	if !bytes.Equal(x, y) {
+		t.Errorf("the same policy should be marshaled in the same way\n%s\n%s", x, y)
+	}
+}
+
+func TestACLQuota(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	for _, key := range []string{"a/hello", "a/world"} {
+		if _, err := store.Put(key, bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	acl, err := ParseACL([]byte("quotas: [{prefix: a/, max-size: 30, max-keys: 2}]"))
+	if err != nil {
+		t.Fatalf("failed to parse ACL: %s", err)
+	}
+
+	tests := []struct {
+		Key  string
+		Size int
+		OK   bool
+	}{
+		{"a/hello", 8, true},
+		{"a/hello", 9, false},
+		{"a/new", 1, false},
+		{"b/new", 100, true},
+	}
+
+	for _, tt := range tests {
+		_, err := acl.CheckQuota(store, tt.Key, tt.Size)
+		if tt.OK && err != nil {
+			t.Errorf("%s %d bytes: expected allowed but got %s", tt.Key, tt.Size, err)
+		} else if !tt.OK && !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("%s %d bytes: expected quota exceeded but got %v", tt.Key, tt.Size, err)
+		}
+	}
+}
+
+func TestACLStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.yaml")
+
+	s, err := LoadACLStore(path)
+	if err != nil {
+		t.Fatalf("failed to load ACL: %s", err)
+	}
+
+	acl, _ := ParseACL([]byte("prefixes: [{prefix: a/, scopes: [publish]}]"))
+
+	if changed, err := s.Set(acl, true); err != nil || !changed {
+		t.Fatalf("dry run should report change: changed=%v error=%v", changed, err)
+	}
+	if len(s.Get().Prefixes) != 0 {
+		t.Fatalf("dry run should not change ACL")
+	}
+
+	if changed, err := s.Set(acl, false); err != nil || !changed {
+		t.Fatalf("failed to set ACL: changed=%v error=%v", changed, err)
+	}
+	if changed, err := s.Set(acl, false); err != nil || changed {
+		t.Fatalf("setting the same ACL should be no-op: changed=%v error=%v", changed, err)
+	}
+
+	s, err = LoadACLStore(path)
+	if err != nil {
+		t.Fatalf("failed to reload ACL: %s", err)
+	}
+	if got := s.Get(); len(got.Prefixes) != 1 || got.Prefixes[0].Prefix != "a/" {
+		t.Errorf("unexpected ACL after reload: %#v", got)
+	}
+}
+
+func TestACLSoftQuota(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	for _, key := range []string{"a/hello", "a/world"} {
+		if _, err := store.Put(key, bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	acl, err := ParseACL([]byte("quotas: [{prefix: a/, max-size: 100, soft-max-size: 30, soft-max-keys: 2}]"))
+	if err != nil {
+		t.Fatalf("failed to parse ACL: %s", err)
+	}
+
+	tests := []struct {
+		Key      string
+		Size     int
+		Warnings int
+	}{
+		{"a/hello", 8, 0},
+		{"a/hello", 9, 1},
+		{"a/new", 1, 1},
+		{"a/new", 50, 2},
+		{"b/new", 100, 0},
+	}
+
+	for _, tt := range tests {
+		warnings, err := acl.CheckQuota(store, tt.Key, tt.Size)
+		if err != nil {
+			t.Errorf("%s %d bytes: soft limit should not reject: %s", tt.Key, tt.Size, err)
+		} else if len(warnings) != tt.Warnings {
+			t.Errorf("%s %d bytes: expected %d warnings but got %v", tt.Key, tt.Size, tt.Warnings, warnings)
+		}
+	}
+
+	if _, err := ParseACL([]byte("quotas: [{prefix: a/, max-size: 10, soft-max-size: 30}]")); err == nil {
+		t.Errorf("soft-max-size bigger than max-size should be rejected")
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	APIPrefix = "_api/"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+	return json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(v)
+}
+
+func (s Server) ServeAPI(path string, w http.ResponseWriter, r *http.Request) {
+	path = strings.TrimPrefix(path, APIPrefix)
+
+	switch {
+	case path == "v1/status":
+		s.Status(w, r)
+	case path == "v1/retention":
+		s.Retention(path, w, r)
+	case path == "v1/retention/pause":
+		s.PauseRetention(path, true, w, r)
+	case path == "v1/retention/resume":
+		s.PauseRetention(path, false, w, r)
+	case path == "v1/sweep":
+		s.Sweep(path, w, r)
+	case path == "v1/fsck":
+		s.Fsck(path, w, r)
+	case path == "v1/acl":
+		s.ServeACL(path, w, r)
+	case path == "v1/uploads" || strings.HasPrefix(path, "v1/uploads/"):
+		s.ServeUploads(path, w, r)
+	case path == "v1/metrics":
+		s.ServeMetrics(path, w, r)
+	case strings.HasPrefix(path, "v1/webhooks/"):
+		s.ServeWebhooks(path, w, r)
+	case path == "v1/transactions" || strings.HasPrefix(path, "v1/transactions/"):
+		s.ServeTransactions(path, w, r)
+	case path == "v1/grep":
+		s.Grep(path, w, r)
+	case path == "v1/observability":
+		s.ServeObservability(path, w, r)
+	case path == "v1/bandwidth":
+		s.ServeBandwidth(path, w, r)
+	case path == "v1/sha256sums":
+		s.SHA256Sums(w, r)
+	case path == "v1/keys":
+		s.Keys(w, r)
+	case path == "v1/search":
+		s.Search(w, r)
+	case path == "v1/tokens/exchange":
+		s.ExchangeToken(w, r)
+	case path == "v1/usage":
+		s.Usage(path, w, r)
+	case strings.HasPrefix(path, "v1/revisions/"):
+		s.Revisions(strings.TrimPrefix(path, "v1/revisions/"), w, r)
+	case strings.HasPrefix(path, "v1/signatures/"):
+		s.Signature(strings.TrimPrefix(path, "v1/signatures/"), w, r)
+	case strings.HasPrefix(path, "v1/expectations/"):
+		s.Expectation(strings.TrimPrefix(path, "v1/expectations/"), w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "No such API.")
+	}
+}
+
+func (s Server) Expectation(key string, w http.ResponseWriter, r *http.Request) {
+	if err := VerifyKey(key); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		if !s.authorize(key, ScopePublish, w, r) {
+			return
+		}
+
+		if e, ok := s.Expectations.Get(key); ok {
+			writeJSON(w, http.StatusOK, e)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, ErrNoSuchExpectation)
+		}
+	case "PUT", "POST":
+		if !s.authorize(key, ScopePublish, w, r) {
+			return
+		}
+
+		var e Expectation
+		if err := readJSON(r, &e); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid request body: %s\n", err)
+			return
+		}
+
+		e, err := e.Normalize()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
+			return
+		}
+
+		s.Expectations.Set(key, e)
+		PrintImportant("EXPECT", "%s md5=%s sha256=%s", key, e.MD5, e.SHA256)
+
+		writeJSON(w, http.StatusOK, e)
+	case "DELETE":
+		if !s.authorize(key, ScopePublish, w, r) {
+			return
+		}
+
+		if s.Expectations.Delete(key) {
+			PrintImportant("UNEXPECT", "%s", key)
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, ErrNoSuchExpectation)
+		}
+	case "OPTIONS":
+		if s.ReadOnly {
+			w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		} else {
+			w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE, OPTIONS")
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+	}
+}
+
+type ArtifactInfo struct {
+	Key string `json:"key"`
+	Metadata
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func NewArtifactInfo(meta Metadata) ArtifactInfo {
+	return ArtifactInfo{meta.Key, meta, meta.Timestamp}
+}
+
+type KeyList struct {
+	Keys       []string `json:"keys"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// Keys lists keys that have revisions.
+// With deleted=true, it lists keys whose revisions are all removed instead, that are never reused for new revision numbers.
+func (s Server) Keys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	query := r.URL.Query()
+	q, err := ParseQuery(query, []string{"key", "size", "timestamp"}, true)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	deleted := false
+	if v := query.Get("deleted"); v != "" {
+		if deleted, err = strconv.ParseBool(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid deleted: it should be true or false.")
+			return
+		}
+	}
+	if deleted && q.NeedsMetadata() {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Deleted keys can not be sorted or filtered by metadata.")
+		return
+	}
+
+	var keys []string
+	if deleted {
+		keys, err = listDeleted(s.Store, query.Get("prefix"))
+		if err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+		if q.Desc {
+			sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+		}
+	} else if q.NeedsMetadata() {
+		metas, err := ListLatest(s.Store, query.Get("prefix"))
+		if err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+
+		metas = q.Filter(metas)
+		q.SortMetadata(metas)
+
+		keys = make([]string, len(metas))
+		for i, meta := range metas {
+			keys[i] = meta.Key
+		}
+	} else {
+		keys, err = s.Store.List(query.Get("prefix"))
+		if err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+		if q.Desc {
+			sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+		}
+	}
+
+	keys = s.publicKeys(keys)
+
+	start, end, next := q.Page(len(keys))
+	writeJSON(w, http.StatusOK, KeyList{keys[start:end], next})
+}
+
+// listDeleted returns deleted keys under prefix, using DeletedLister if store implements it.
+// Stores that do not remember deleted keys have no deleted key.
+func listDeleted(store Store, prefix string) ([]string, error) {
+	for _, l := range storeLayers(store) {
+		if d, ok := l.(DeletedLister); ok {
+			return d.ListDeleted(prefix)
+		}
+	}
+	return []string{}, nil
+}
+
+// publicKeys removes private keys, because listing APIs do not take token.
+func (s Server) publicKeys(keys []string) []string {
+	if len(s.Private) == 0 && len(s.Terraform) == 0 {
+		return keys
+	}
+
+	xs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if !s.isPrivate(k) {
+			xs = append(xs, k)
+		}
+	}
+	return xs
+}
+
+// RevisionList is the response of the revisions API.
+// Revisions are sorted by the revision number as integer unless the sort query is given, so that clients never have to sort "10" after "2" themselves.
+type RevisionList struct {
+	Key        string         `json:"key"`
+	Latest     int            `json:"latest"`
+	Revisions  []ArtifactInfo `json:"revisions"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+func (s Server) Revisions(key string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	if !s.authorizeRead(key, w, r) {
+		return
+	}
+
+	q, err := ParseQuery(r.URL.Query(), []string{"revision", "size", "timestamp"}, true)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	latest, err := s.Store.Latest(key)
+	if err == ErrNoSuchArtifact {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, err)
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	metas, err := s.Store.Revisions(key)
+	if err == ErrNoSuchArtifact {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, err)
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	metas = q.Filter(metas)
+	q.SortMetadata(metas)
+	start, end, next := q.Page(len(metas))
+	metas = metas[start:end]
+
+	list := RevisionList{Key: key, Latest: latest, Revisions: make([]ArtifactInfo, len(metas)), NextCursor: next}
+	for i, meta := range metas {
+		list.Revisions[i] = NewArtifactInfo(meta)
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+type ServerStatus struct {
+	Version         string         `json:"version"`
+	ReadOnly        bool           `json:"read_only"`
+	RetentionPaused bool           `json:"retention_paused"`
+	Cache           *CacheStats    `json:"cache,omitempty"`
+	Breaker         string         `json:"breaker,omitempty"`
+	HashPool        *HashPoolStats `json:"hash_pool,omitempty"`
+}
+
+func (s Server) Status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	status := ServerStatus{
+		Version:         version + " (" + commit + ")",
+		ReadOnly:        s.ReadOnly,
+		RetentionPaused: s.Store.RetentionPaused(),
+	}
+	if s.Cache != nil {
+		stats := s.Cache.Stats()
+		status.Cache = &stats
+	}
+	if s.Breaker != nil {
+		status.Breaker = string(s.Breaker.State())
+	}
+	if s.HashPool != nil {
+		stats := s.HashPool.Stats()
+		status.HashPool = &stats
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+type RetentionStatus struct {
+	Paused bool `json:"paused"`
+}
+
+func (s Server) Retention(path string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, RetentionStatus{s.Store.RetentionPaused()})
+}
+
+func (s Server) PauseRetention(path string, paused bool, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+		return
+	}
+
+	s.Store.PauseRetention(paused)
+	if paused {
+		PrintImportant("RETENTION", "paused by %s", r.RemoteAddr)
+	} else {
+		PrintImportant("RETENTION", "resumed by %s", r.RemoteAddr)
+	}
+
+	writeJSON(w, http.StatusOK, RetentionStatus{paused})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrAppendRedirect = errors.New("Redirects can not be appended.")
+)
+
+// appendBase opens the latest revision of key as the head of an appending publish by "POST /KEY?append".
+// The returned reader is nil if the key does not exist yet, and the new revision will be the uploaded content only.
+//
+// The returned precondition makes the publish fail if someone else published between, otherwise the appended content would be lost.
+func (s Server) appendBase(key string, precondition PublishPrecondition, w http.ResponseWriter, r *http.Request) (io.ReadSeekCloser, Metadata, PublishPrecondition, bool) {
+	rev := precondition.Latest
+	if !precondition.Conditional {
+		var err error
+		rev, err = s.Store.Latest(key)
+		if err == ErrNoSuchArtifact {
+			rev = 0
+		} else if err == ErrCircuitOpen {
+			s.unavailable(w)
+			return nil, Metadata{}, precondition, false
+		} else if err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return nil, Metadata{}, precondition, false
+		}
+	}
+	precondition = PublishPrecondition{Conditional: true, Latest: rev}
+
+	if rev == 0 {
+		return nil, Metadata{}, precondition, true
+	}
+
+	f, meta, err := s.Store.Get(key, rev)
+	if err == ErrCircuitOpen {
+		s.unavailable(w)
+		return nil, Metadata{}, precondition, false
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return nil, Metadata{}, precondition, false
+	}
+	if meta.Type == RedirectType {
+		f.Close()
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, ErrAppendRedirect)
+		return nil, Metadata{}, precondition, false
+	}
+
+	PrintLog("APPEND", "%s: append to revision %d (%d bytes) from %s", key, rev, meta.Size, r.RemoteAddr)
+	return f, meta, precondition, true
+}
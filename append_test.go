@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAppendPublish(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewToken(sec, "logs/")
+	store := &LocalStore{Path: t.TempDir()}
+	redirects, _ := ParseRedirectAllowlist([]string{"https://example.com/"})
+	s := Server{Secret: sec, Store: store, Expectations: NewExpectationStore(), Uploads: NewUploadTracker(), Redirects: redirects}
+
+	publish := func(path, body string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Authorization", "bearer "+token.String())
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	content := func(rev int) string {
+		f, _, err := store.Get("logs/build.log", rev)
+		if err != nil {
+			t.Fatalf("failed to get revision %d: %s", rev, err)
+		}
+		defer f.Close()
+		data, _ := io.ReadAll(f)
+		return string(data)
+	}
+
+	if w := publish("/logs/build.log?append", "line 1\n", nil); w.Code != http.StatusCreated {
+		t.Fatalf("append to new key should create it: %d: %s", w.Code, w.Body.String())
+	}
+	if w := publish("/logs/build.log?append", "line 2\n", nil); w.Code != http.StatusCreated {
+		t.Fatalf("failed to append: %d: %s", w.Code, w.Body.String())
+	}
+	if w := publish("/logs/build.log?append=1", "line 3\n", nil); w.Code != http.StatusCreated {
+		t.Fatalf("failed to append: %d: %s", w.Code, w.Body.String())
+	}
+
+	if c := content(1); c != "line 1\n" {
+		t.Errorf("unexpected content of revision 1: %q", c)
+	}
+	if c := content(3); c != "line 1\nline 2\nline 3\n" {
+		t.Errorf("unexpected content of revision 3: %q", c)
+	}
+
+	if w := publish("/logs/build.log?append", "line 4\n", map[string]string{"If-Match": `"outdated"`}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("append should respect If-Match: %d", w.Code)
+	}
+
+	if w := publish("/logs/redirect", "https://example.com/build.log", map[string]string{"Content-Type": RedirectType}); w.Code != http.StatusCreated {
+		t.Fatalf("failed to publish redirect: %d: %s", w.Code, w.Body.String())
+	}
+	if w := publish("/logs/redirect?append", "more", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("redirect should not be appended: %d", w.Code)
+	}
+}
+
+func TestAppendBaseRace(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	if _, err := store.Put("app.log", strings.NewReader("hello\n"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	s := Server{Store: store}
+
+	w := httptest.NewRecorder()
+	f, _, precondition, ok := s.appendBase("app.log", PublishPrecondition{}, w, httptest.NewRequest("POST", "/app.log?append", nil))
+	if !ok {
+		t.Fatalf("failed to open base: %d", w.Code)
+	}
+	f.Close()
+
+	if _, err := store.Put("app.log", strings.NewReader("other\n"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	_, err := store.Put("app.log", strings.NewReader("hello\nworld\n"), PutOptions{
+		Verify: func(meta Metadata) error {
+			return precondition.Check(meta.Revision)
+		},
+	})
+	if err != ErrPreconditionFailed {
+		t.Errorf("append should fail if someone else published between: %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveIndexSuffix is the suffix of files that store the entry index of tar or zip revisions.
+const archiveIndexSuffix = ".entries"
+
+var (
+	ErrNotArchive      = errors.New("This artifact is not a tar or zip archive.")
+	ErrNoSuchEntry     = errors.New("No such entry in the archive.")
+	ErrNoArchiveIndex  = errors.New("The archive has no entry index.")
+	ErrUnsupportedZip  = errors.New("The entry uses unsupported compression method.")
+	ErrInvalidEntryKey = errors.New("Invalid entry: it should be a relative path in the archive.")
+)
+
+const (
+	ArchiveTar = "tar"
+	ArchiveZip = "zip"
+)
+
+// ArchiveEntry is a regular file in a tar or zip archive.
+type ArchiveEntry struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+
+	// Offset is the position of the entry data in the archive.
+	Offset int64 `json:"offset"`
+
+	// Method and CompressedSize are only for zip. The data is stored as is if Method is zip.Store.
+	Method         uint16 `json:"method,omitempty"`
+	CompressedSize int64  `json:"compressed_size,omitempty"`
+}
+
+// ArchiveIndex is the list of entries in an archive, so that an entry can be read without reading whole archive.
+type ArchiveIndex struct {
+	Format  string         `json:"format"`
+	Entries []ArchiveEntry `json:"entries"`
+}
+
+// ArchiveIndexStore is implemented by stores that can keep the entry index of archive revisions.
+// The index is made at publish if the store implements it, otherwise it is made every time an entry is requested.
+type ArchiveIndexStore interface {
+	// ReadArchiveIndex returns ErrNoArchiveIndex if the revision has no index.
+	ReadArchiveIndex(key string, revision int) (ArchiveIndex, error)
+	WriteArchiveIndex(key string, revision int, idx ArchiveIndex) error
+}
+
+// archiveFormat returns the archive format of the artifact, or an empty string if it is not an archive.
+func archiveFormat(meta Metadata) string {
+	switch {
+	case meta.Type == "application/x-tar" || strings.HasSuffix(meta.Key, ".tar"):
+		return ArchiveTar
+	case meta.Type == "application/zip" || strings.HasSuffix(meta.Key, ".zip"):
+		return ArchiveZip
+	default:
+		return ""
+	}
+}
+
+// Find returns the entry that has the name.
+func (idx ArchiveIndex) Find(name string) (ArchiveEntry, bool) {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return idx.Entries[i].Name >= name
+	})
+	if i < len(idx.Entries) && idx.Entries[i].Name == name {
+		return idx.Entries[i], true
+	}
+	return ArchiveEntry{}, false
+}
+
+// countingReader counts bytes read from the upstream.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// readSeekerAt reads a io.ReadSeeker as io.ReaderAt. It is not safe for concurrent use.
+type readSeekerAt struct {
+	r io.ReadSeeker
+}
+
+func (r readSeekerAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(r.r, p)
+}
+
+// BuildArchiveIndex reads the whole archive and makes the entry index.
+func BuildArchiveIndex(format string, r io.ReadSeeker, size int64) (ArchiveIndex, error) {
+	idx := ArchiveIndex{Format: format, Entries: []ArchiveEntry{}}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return idx, err
+	}
+
+	switch format {
+	case ArchiveTar:
+		cr := &countingReader{r: r}
+		tr := tar.NewReader(cr)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return idx, err
+			}
+			if h.Typeflag != tar.TypeReg {
+				continue
+			}
+			idx.Entries = append(idx.Entries, ArchiveEntry{
+				Name:     cleanEntryName(h.Name),
+				Size:     h.Size,
+				Modified: h.ModTime,
+				Offset:   cr.n,
+			})
+		}
+	case ArchiveZip:
+		zr, err := zip.NewReader(readSeekerAt{r}, size)
+		if err != nil {
+			return idx, err
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			offset, err := f.DataOffset()
+			if err != nil {
+				return idx, err
+			}
+			idx.Entries = append(idx.Entries, ArchiveEntry{
+				Name:           cleanEntryName(f.Name),
+				Size:           int64(f.UncompressedSize64),
+				Modified:       f.Modified,
+				Offset:         offset,
+				Method:         f.Method,
+				CompressedSize: int64(f.CompressedSize64),
+			})
+		}
+	default:
+		return idx, ErrNotArchive
+	}
+
+	sort.SliceStable(idx.Entries, func(i, j int) bool {
+		return idx.Entries[i].Name < idx.Entries[j].Name
+	})
+	return idx, nil
+}
+
+func cleanEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (s *LocalStore) archiveIndexPath(key string, revision int) string {
+	return filepath.Join(s.keyDir(key), revisionFileName(revision)+archiveIndexSuffix)
+}
+
+func (s *LocalStore) ReadArchiveIndex(key string, revision int) (ArchiveIndex, error) {
+	var idx ArchiveIndex
+
+	data, err := os.ReadFile(s.archiveIndexPath(key, revision))
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, ErrNoArchiveIndex
+	} else if err != nil {
+		return idx, err
+	}
+
+	err = json.Unmarshal(data, &idx)
+	return idx, err
+}
+
+func (s *LocalStore) WriteArchiveIndex(key string, revision int, idx ArchiveIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	fname := s.archiveIndexPath(key, revision)
+	if err := os.WriteFile(fname+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(fname+".tmp", fname)
+}
+
+// indexArchive makes the entry index of the published revision if it is an archive and the store can keep the index.
+func (s Server) indexArchive(meta Metadata) {
+	format := archiveFormat(meta)
+	if format == "" {
+		return
+	}
+
+	for _, l := range storeLayers(s.Store) {
+		is, ok := l.(ArchiveIndexStore)
+		if !ok {
+			continue
+		}
+
+		f, _, err := s.Store.Get(meta.Key, meta.Revision)
+		if err != nil {
+			PrintWarn("ARCHIVE", "%s#%d: %s", meta.Key, meta.Revision, err)
+			return
+		}
+		defer f.Close()
+
+		idx, err := BuildArchiveIndex(format, f, int64(meta.Size))
+		if err == nil {
+			err = is.WriteArchiveIndex(meta.Key, meta.Revision, idx)
+		}
+		if err != nil {
+			PrintWarn("ARCHIVE", "%s#%d: failed to make entry index: %s", meta.Key, meta.Revision, err)
+		}
+		return
+	}
+}
+
+// archiveIndex returns the entry index of the revision, making it from f if the store has no index.
+func (s Server) archiveIndex(meta Metadata, f io.ReadSeeker) (ArchiveIndex, error) {
+	format := archiveFormat(meta)
+	if format == "" {
+		return ArchiveIndex{}, ErrNotArchive
+	}
+
+	for _, l := range storeLayers(s.Store) {
+		if is, ok := l.(ArchiveIndexStore); ok {
+			idx, err := is.ReadArchiveIndex(meta.Key, meta.Revision)
+			if err != ErrNoArchiveIndex {
+				return idx, err
+			}
+			break
+		}
+	}
+
+	return BuildArchiveIndex(format, f, int64(meta.Size))
+}
+
+type ArchiveEntryList struct {
+	Format  string         `json:"format"`
+	Entries []ArchiveEntry `json:"entries"`
+}
+
+// serveEntry sends a file in the archive, or the list of entries if the entry name is empty or ends with "/".
+func (s Server) serveEntry(meta Metadata, f io.ReadSeeker, trace *Trace, w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("entry")
+	if n := strings.TrimSuffix(name, "/"); n != "" && cleanEntryName(n) != n {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, ErrInvalidEntryKey)
+		return
+	}
+
+	idx, err := s.archiveIndex(meta, f)
+	if err == ErrNotArchive {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "%s#%d: %s", meta.Key, meta.Revision, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	if name == "" || strings.HasSuffix(name, "/") {
+		trace.Printf("list entries in %q of the %s archive", name, idx.Format)
+		list := ArchiveEntryList{Format: idx.Format, Entries: []ArchiveEntry{}}
+		for _, e := range idx.Entries {
+			if strings.HasPrefix(e.Name, name) {
+				list.Entries = append(list.Entries, e)
+			}
+		}
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+
+	entry, ok := idx.Find(name)
+	if !ok {
+		trace.Printf("entry %q is not in the archive; 404 Not Found", name)
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, ErrNoSuchEntry)
+		return
+	}
+
+	// The ETag of the entry is derived from the archive, so that it changes only when the archive changes.
+	h := md5.Sum([]byte(meta.Hash + "/" + entry.Name))
+	etag := s.ETagFormat.ETag(hex.EncodeToString(h[:]))
+	w.Header().Set("Etag", etag)
+	trace.Conditional(r, etag, entry.Modified)
+
+	if typ := mime.TypeByExtension(path.Ext(entry.Name)); typ != "" {
+		w.Header().Set("Content-Type", typ)
+	} else {
+		w.Header().Del("Content-Type")
+	}
+
+	section := io.NewSectionReader(readSeekerAt{f}, entry.Offset, entry.Size)
+
+	switch {
+	case idx.Format == ArchiveTar || entry.Method == zip.Store:
+		trace.Printf("serve entry %q at %d in the %s archive", entry.Name, entry.Offset, idx.Format)
+		fixRangeHeader(r, entry.Size)
+		http.ServeContent(w, r, entry.Name, entry.Modified, section)
+	case entry.Method == zip.Deflate:
+		trace.Printf("serve deflated entry %q at %d in the zip archive; Range is not supported", entry.Name, entry.Offset)
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != "HEAD" {
+			z := flate.NewReader(io.NewSectionReader(readSeekerAt{f}, entry.Offset, entry.CompressedSize))
+			defer z.Close()
+			io.CopyN(w, z, entry.Size)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, ErrUnsupportedZip)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+)
+
+// sortedNames returns names of files in the order to write into archives.
+func sortedNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func makeTar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	dirs := make(map[string]bool)
+	for _, name := range sortedNames(files) {
+		if dir := path.Dir(name); dir != "." && !dirs[dir] {
+			dirs[dir] = true
+			w.WriteHeader(&tar.Header{Name: "./" + dir + "/", Typeflag: tar.TypeDir, Mode: 0755})
+		}
+		w.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(files[name])), ModTime: time.Unix(1600000000, 0)})
+		w.Write([]byte(files[name]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeTarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(makeTar(t, files))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i, name := range sortedNames(files) {
+		method := zip.Deflate
+		if i%2 == 0 {
+			method = zip.Store
+		}
+		f, _ := w.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Unix(1600000000, 0)})
+		f.Write([]byte(files[name]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestServeEntry(t *testing.T) {
+	files := map[string]string{
+		"docs/index.html": "<h1>hello</h1>",
+		"docs/app.js":     "console.log('hello world')",
+		"README":          "read me",
+	}
+
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+
+	store := &LocalStore{Path: t.TempDir()}
+	s := Server{
+		Secret:       sec,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+	}
+
+	request := func(method, path string, body []byte, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, bytes.NewReader(body))
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		token, _ := NewToken(sec, r.URL.Path[1:])
+		r.Header.Set("Authorization", "bearer "+token.String())
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	archives := map[string][]byte{
+		"bundle.tar": makeTar(t, files),
+		"bundle.zip": makeZip(t, files),
+	}
+
+	for key, data := range archives {
+		t.Run(key, func(t *testing.T) {
+			if w := request("POST", "/"+key, data, nil); w.Code != http.StatusCreated {
+				t.Fatalf("failed to publish: %d: %s", w.Code, w.Body.String())
+			}
+			if _, err := store.ReadArchiveIndex(key, 1); err != nil {
+				t.Errorf("entry index should be made at publish: %s", err)
+			}
+
+			for name, content := range files {
+				w := request("GET", "/"+key+"?rev=1&entry="+name, nil, nil)
+				if w.Code != http.StatusOK || w.Body.String() != content {
+					t.Errorf("%s: unexpected response: %d: %q", name, w.Code, w.Body.String())
+				}
+			}
+
+			if w := request("GET", "/"+key+"?rev=1&entry=docs/index.html", nil, nil); w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+				t.Errorf("unexpected content type: %s", w.Header().Get("Content-Type"))
+			}
+
+			if w := request("GET", "/"+key+"?rev=1&entry=README", nil, http.Header{"Range": {"bytes=5-"}}); w.Code != http.StatusPartialContent || w.Body.String() != "me" {
+				t.Errorf("unexpected range response: %d: %q", w.Code, w.Body.String())
+			}
+
+			if w := request("GET", "/"+key+"?rev=1&entry=missing.txt", nil, nil); w.Code != http.StatusNotFound {
+				t.Errorf("expected 404 for missing entry but got %d", w.Code)
+			}
+			if w := request("GET", "/"+key+"?rev=1&entry=../README", nil, nil); w.Code != http.StatusBadRequest {
+				t.Errorf("expected 400 for invalid entry but got %d", w.Code)
+			}
+
+			w := request("GET", "/"+key+"?rev=1&entry=docs/", nil, nil)
+			var list ArchiveEntryList
+			if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Entries) != 2 || list.Entries[0].Name != "docs/app.js" {
+				t.Errorf("unexpected list: %s: %v", w.Body.String(), err)
+			}
+
+			// Entries can be served even without index, such as revisions published before the index is introduced.
+			os.Remove(store.archiveIndexPath(key, 1))
+			if w := request("GET", "/"+key+"?rev=1&entry=docs/app.js", nil, nil); w.Code != http.StatusOK || w.Body.String() != files["docs/app.js"] {
+				t.Errorf("unexpected response without index: %d: %q", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	request("POST", "/hello.txt", []byte("hello"), nil)
+	if w := request("GET", "/hello.txt?rev=1&entry=a.txt", nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for non-archive but got %d", w.Code)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AnonymousToken is the token label of requests without a valid token.
+const AnonymousToken = "anonymous"
+
+type bandwidthLabels struct {
+	Token  string
+	Prefix string
+}
+
+// Bandwidth is the traffic of a token for a prefix.
+type Bandwidth struct {
+	Token   string `json:"token"`
+	Prefix  string `json:"prefix"`
+	Ingress int64  `json:"ingress_bytes"`
+	Egress  int64  `json:"egress_bytes"`
+}
+
+// tokenLabel returns the fingerprint of the token in the request if it is valid for key, or AnonymousToken.
+// Invalid tokens are not used as labels, so that nobody can add labels unlimitedly.
+func (s Server) tokenLabel(key string, r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "bearer ") {
+		return AnonymousToken
+	}
+	token, err := ParseToken(strings.TrimSpace(auth[len("bearer "):]))
+	if err != nil || !IsCorrentToken(s.secretFor(key), token, key) {
+		return AnonymousToken
+	}
+	return token.Fingerprint()
+}
+
+// ObserveIngress records n bytes received from token for key.
+func (m *Metrics) ObserveIngress(token, key string, n int64) {
+	m.addBandwidth(token, key, n, 0)
+}
+
+// ObserveEgress records n bytes sent to token for key.
+func (m *Metrics) ObserveEgress(token, key string, n int64) {
+	m.addBandwidth(token, key, 0, n)
+}
+
+func (m *Metrics) addBandwidth(token, key string, ingress, egress int64) {
+	if m == nil || (ingress == 0 && egress == 0) {
+		return
+	}
+
+	l := bandwidthLabels{token, metricPrefix(key)}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	b, ok := m.bandwidth[l]
+	if !ok {
+		b = &Bandwidth{Token: l.Token, Prefix: l.Prefix}
+		m.bandwidth[l] = b
+	}
+	b.Ingress += ingress
+	b.Egress += egress
+}
+
+// Bandwidth returns traffic of each token and prefix, sorted by token and prefix.
+// Empty token or prefix matches everything.
+func (m *Metrics) Bandwidth(token, prefix string) []Bandwidth {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	bs := sortedBandwidth(m.bandwidth)
+	result := make([]Bandwidth, 0, len(bs))
+	for _, b := range bs {
+		if (token == "" || b.Token == token) && (prefix == "" || b.Prefix == prefix) {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+func sortedBandwidth(m map[bandwidthLabels]*Bandwidth) []Bandwidth {
+	bs := make([]Bandwidth, 0, len(m))
+	for _, b := range m {
+		bs = append(bs, *b)
+	}
+	sort.Slice(bs, func(i, j int) bool {
+		if bs[i].Token != bs[j].Token {
+			return bs[i].Token < bs[j].Token
+		}
+		return bs[i].Prefix < bs[j].Prefix
+	})
+	return bs
+}
+
+func writeBandwidth(sb *strings.Builder, m map[bandwidthLabels]*Bandwidth) {
+	bs := sortedBandwidth(m)
+
+	for _, c := range []struct {
+		Name  string
+		Help  string
+		Value func(Bandwidth) int64
+	}{
+		{MetricIngress, "Bytes of artifacts received from clients.", func(b Bandwidth) int64 { return b.Ingress }},
+		{MetricEgress, "Bytes of artifacts sent to clients.", func(b Bandwidth) int64 { return b.Egress }},
+	} {
+		fmt.Fprintf(sb, "# HELP %s %s\n", c.Name, c.Help)
+		fmt.Fprintf(sb, "# TYPE %s counter\n", c.Name)
+		for _, b := range bs {
+			fmt.Fprintf(sb, "%s{token=%s,prefix=%s} %d\n", c.Name, strconv.Quote(b.Token), strconv.Quote(b.Prefix), c.Value(b))
+		}
+	}
+}
+
+type BandwidthList struct {
+	Bandwidth []Bandwidth `json:"bandwidth"`
+}
+
+func (s Server) ServeBandwidth(path string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+		return
+	}
+
+	if s.Metrics == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "Metrics are not enabled on this server.")
+		return
+	}
+
+	query := r.URL.Query()
+	writeJSON(w, http.StatusOK, BandwidthList{s.Metrics.Bandwidth(query.Get("token"), query.Get("prefix"))})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMetricsBandwidth(t *testing.T) {
+	m := NewMetrics()
+	m.ObserveIngress("a", "release/app.zip", 100)
+	m.ObserveIngress("a", "release/1.0/app.zip", 50)
+	m.ObserveEgress("a", "release/app.zip", 30)
+	m.ObserveEgress("b", "release/app.zip", 20)
+	m.ObserveEgress("b", "README", 5)
+	m.ObserveIngress("c", "release/app.zip", 0)
+	m.ObserveEgress("c", "release/app.zip", 0)
+
+	var nilMetrics *Metrics
+	nilMetrics.ObserveIngress("a", "release/app.zip", 100)
+
+	tests := []struct {
+		Token  string
+		Prefix string
+		Expect []Bandwidth
+	}{
+		{"", "", []Bandwidth{
+			{Token: "a", Prefix: "release/", Ingress: 150, Egress: 30},
+			{Token: "b", Prefix: "/", Egress: 5},
+			{Token: "b", Prefix: "release/", Egress: 20},
+		}},
+		{"b", "", []Bandwidth{
+			{Token: "b", Prefix: "/", Egress: 5},
+			{Token: "b", Prefix: "release/", Egress: 20},
+		}},
+		{"", "release/", []Bandwidth{
+			{Token: "a", Prefix: "release/", Ingress: 150, Egress: 30},
+			{Token: "b", Prefix: "release/", Egress: 20},
+		}},
+		{"a", "/", []Bandwidth{}},
+		{"c", "", []Bandwidth{}},
+	}
+
+	for _, tt := range tests {
+		if bs := m.Bandwidth(tt.Token, tt.Prefix); !reflect.DeepEqual(bs, tt.Expect) {
+			t.Errorf("token=%q prefix=%q: unexpected bandwidth: %v", tt.Token, tt.Prefix, bs)
+		}
+	}
+}
+
+func TestTokenLabel(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewToken(sec, "release/")
+	other, _ := NewToken(sec, "other/")
+	s := Server{Secret: sec}
+
+	tests := []struct {
+		Auth   string
+		Expect string
+	}{
+		{"", AnonymousToken},
+		{"bearer " + token.String(), token.Fingerprint()},
+		{"bearer " + other.String(), AnonymousToken},
+		{"bearer broken", AnonymousToken},
+		{"basic " + token.String(), AnonymousToken},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/release/app.zip", nil)
+		if tt.Auth != "" {
+			r.Header.Set("Authorization", tt.Auth)
+		}
+		if label := s.tokenLabel("release/app.zip", r); label != tt.Expect {
+			t.Errorf("%q: expected %q but got %q", tt.Auth, tt.Expect, label)
+		}
+	}
+}
+
+func TestServeBandwidthAuthorization(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	v1, _ := NewToken(sec, APIPrefix)
+	admin, _ := NewScopedToken(sec, APIPrefix, ScopeAdmin)
+	publish, _ := NewScopedToken(sec, APIPrefix, ScopePublish)
+	read, _ := NewScopedToken(sec, APIPrefix, ScopeRead)
+	artifact, _ := NewToken(sec, "release/")
+
+	s := Server{Secret: sec, Store: &LocalStore{Path: t.TempDir()}, Metrics: NewMetrics()}
+	disabled := Server{Secret: sec, Store: &LocalStore{Path: t.TempDir()}}
+
+	tests := []struct {
+		Name   string
+		Server Server
+		Method string
+		Token  Token
+		Status int
+	}{
+		{"anonymous", s, "GET", nil, http.StatusForbidden},
+		{"artifact token", s, "GET", artifact, http.StatusForbidden},
+		{"publish scope", s, "GET", publish, http.StatusForbidden},
+		{"read scope", s, "GET", read, http.StatusForbidden},
+		{"admin scope", s, "GET", admin, http.StatusOK},
+		{"v1 token", s, "GET", v1, http.StatusOK},
+		{"post", s, "POST", admin, http.StatusMethodNotAllowed},
+		{"metrics disabled", disabled, "GET", admin, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.Method, "/_api/v1/bandwidth", nil)
+		if tt.Token != nil {
+			r.Header.Set("Authorization", "bearer "+tt.Token.String())
+		}
+		w := httptest.NewRecorder()
+		tt.Server.ServeHTTP(w, r)
+
+		if w.Code != tt.Status {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.Name, tt.Status, w.Code, w.Body)
+		}
+	}
+}
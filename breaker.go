@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCircuitOpen = errors.New("The store is unavailable now. Please retry later.")
+)
+
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// CircuitBreaker stops calling the store for a while after consecutive failures, so that a failing or slow backend does not tie up every handler.
+//
+// After Cooldown, one call is allowed as a trial. The circuit is closed if it succeeded, otherwise it is opened again.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failures to open the circuit.
+	Threshold int
+
+	// Cooldown is the duration to reject calls after the circuit is opened.
+	Cooldown time.Duration
+
+	// Slow is the duration to treat a call as failed even if it succeeded. Zero means no limit.
+	Slow time.Duration
+
+	lock     sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func NewCircuitBreaker(threshold int, cooldown, slow time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		Slow:      slow,
+		state:     CircuitClosed,
+	}
+}
+
+// Allow reports whether a call can be made. The caller have to call Done with the result if it returned nil.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = CircuitHalfOpen
+		b.trial = true
+		return nil
+	case CircuitHalfOpen:
+		if b.trial {
+			return ErrCircuitOpen
+		}
+		b.trial = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Done records the result of a call that was allowed by Allow.
+func (b *CircuitBreaker) Done(err error, took time.Duration) {
+	if b == nil {
+		return
+	}
+
+	failed := isBackendFailure(err)
+	if !failed && b.Slow > 0 && took > b.Slow {
+		failed = true
+		err = fmt.Errorf("took %s", took.Round(time.Millisecond))
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == CircuitHalfOpen {
+		b.trial = false
+		if failed {
+			b.open(err)
+		} else {
+			PrintImportant("BREAKER", "store is recovered. close circuit")
+			b.state = CircuitClosed
+			b.failures = 0
+		}
+		return
+	}
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitClosed && b.failures >= b.Threshold {
+		b.open(err)
+	}
+}
+
+func (b *CircuitBreaker) open(err error) {
+	PrintErr("BREAKER", "store failed %d times. open circuit for %s: %s", b.failures, b.Cooldown, err)
+	b.state = CircuitOpen
+	b.openedAt = time.Now()
+}
+
+// State returns the current state without changing it.
+func (b *CircuitBreaker) State() CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.Cooldown {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// RetryAfter returns the duration until the next trial call.
+func (b *CircuitBreaker) RetryAfter() time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state != CircuitOpen {
+		return 0
+	}
+	if d := b.Cooldown - time.Since(b.openedAt); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// isBackendFailure reports whether err means the backend is unhealthy.
+// Errors that are caused by requests, such as not found or rejected uploads, are not failures of the backend.
+func isBackendFailure(err error) bool {
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	var syscallErr *os.SyscallError
+	return errors.As(err, &pathErr) && !errors.Is(err, fs.ErrNotExist) || errors.As(err, &linkErr) || errors.As(err, &syscallErr)
+}
+
+// BreakerStore calls the underlying store through CircuitBreaker.
+//
+// Durations of Put, Check, and Sweep are not checked by Slow, because they depend on the size of uploads or the whole store.
+type BreakerStore struct {
+	Store
+	Breaker *CircuitBreaker
+}
+
+func (s BreakerStore) Unwrap() Store {
+	return s.Store
+}
+
+func (s BreakerStore) call(checkSlow bool, f func() error) error {
+	if err := s.Breaker.Allow(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err := f()
+	took := time.Since(start)
+	if !checkSlow {
+		took = 0
+	}
+	s.Breaker.Done(err, took)
+	return err
+}
+
+func (s BreakerStore) Latest(key string) (revision int, err error) {
+	err = s.call(true, func() error {
+		revision, err = s.Store.Latest(key)
+		return err
+	})
+	return
+}
+
+func (s BreakerStore) Metadata(key string, revision int) (meta Metadata, err error) {
+	err = s.call(true, func() error {
+		meta, err = s.Store.Metadata(key, revision)
+		return err
+	})
+	return
+}
+
+func (s BreakerStore) Get(key string, revision int) (f io.ReadSeekCloser, meta Metadata, err error) {
+	err = s.call(true, func() error {
+		f, meta, err = s.Store.Get(key, revision)
+		return err
+	})
+	return
+}
+
+func (s BreakerStore) Put(key string, r io.Reader, opts PutOptions) (revision int, err error) {
+	err = s.call(false, func() error {
+		revision, err = s.Store.Put(key, r, opts)
+		return err
+	})
+	return
+}
+
+func (s BreakerStore) List(prefix string) (keys []string, err error) {
+	err = s.call(true, func() error {
+		keys, err = s.Store.List(prefix)
+		return err
+	})
+	return
+}
+
+func (s BreakerStore) Revisions(key string) (revs []Metadata, err error) {
+	err = s.call(true, func() error {
+		revs, err = s.Store.Revisions(key)
+		return err
+	})
+	return
+}
+
+func (s BreakerStore) Delete(key string, revision int) error {
+	return s.call(true, func() error {
+		return s.Store.Delete(key, revision)
+	})
+}
+
+func (s BreakerStore) Patch(key string, revision int, patch MetadataPatch) (meta Metadata, err error) {
+	err = s.call(true, func() error {
+		meta, err = s.Store.Patch(key, revision, patch)
+		return err
+	})
+	return
+}
+
+func (s BreakerStore) Check(quarantine bool, callback func(CheckResult)) (report CheckReport, err error) {
+	err = s.call(false, func() error {
+		report, err = s.Store.Check(quarantine, callback)
+		return err
+	})
+	return
+}
+
+func (s BreakerStore) Sweep(opts SweepOptions) (report SweepReport, err error) {
+	err = s.call(false, func() error {
+		report, err = s.Store.Sweep(opts)
+		return err
+	})
+	return
+}
+
+// unavailable responds 503 with Retry-After while the circuit is open.
+func (s Server) unavailable(w http.ResponseWriter) {
+	retry := int(math.Ceil(s.Breaker.RetryAfter().Seconds()))
+	if retry < 1 {
+		retry = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintln(w, ErrCircuitOpen)
+}
+
+// replicaFallback redirects the request of the latest revision to a replica while the circuit is open.
+// Revisions are not redirected, because replicas assign their own revision numbers.
+func (s Server) replicaFallback(key string, w http.ResponseWriter, r *http.Request) bool {
+	if len(s.Replicators) == 0 {
+		return false
+	}
+
+	u := *s.Replicators[0].Target
+	u.Path = "/" + key
+	u.RawPath = ""
+	u.RawQuery = ""
+
+	PrintWarn("BREAKER", "%s %s: redirect to replica %s", key, r.RemoteAddr, s.Replicators[0].Target)
+	w.Header().Set("Location", u.String())
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusTemporaryRedirect)
+	fmt.Fprintln(w, u.String())
+	return true
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker(2, 50*time.Millisecond, 0)
+	ioErr := &fs.PathError{Op: "read", Path: "x", Err: syscall.EIO}
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("%d: circuit should be closed: %s", i, err)
+		}
+		b.Done(ErrNoSuchArtifact, 0)
+	}
+	if s := b.State(); s != CircuitClosed {
+		t.Fatalf("errors caused by requests should not open circuit: %s", s)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("%d: circuit should be closed: %s", i, err)
+		}
+		b.Done(ioErr, 0)
+	}
+	if s := b.State(); s != CircuitOpen {
+		t.Fatalf("circuit should be open: %s", s)
+	}
+	if err := b.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("calls should be rejected: %v", err)
+	}
+	if d := b.RetryAfter(); d <= 0 || d > 50*time.Millisecond {
+		t.Fatalf("unexpected retry after: %s", d)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if err := b.Allow(); err != nil {
+		t.Fatalf("trial call should be allowed: %s", err)
+	}
+	if err := b.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("only one trial call should be allowed: %v", err)
+	}
+	b.Done(ioErr, 0)
+	if s := b.State(); s != CircuitOpen {
+		t.Fatalf("failed trial should open circuit again: %s", s)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if err := b.Allow(); err != nil {
+		t.Fatalf("trial call should be allowed: %s", err)
+	}
+	b.Done(nil, 0)
+	if s := b.State(); s != CircuitClosed {
+		t.Fatalf("succeeded trial should close circuit: %s", s)
+	}
+}
+
+func TestCircuitBreaker_Slow(t *testing.T) {
+	b := NewCircuitBreaker(1, time.Minute, 10*time.Millisecond)
+
+	b.Allow()
+	b.Done(nil, 5*time.Millisecond)
+	if s := b.State(); s != CircuitClosed {
+		t.Fatalf("fast call should not open circuit: %s", s)
+	}
+
+	b.Allow()
+	b.Done(nil, 20*time.Millisecond)
+	if s := b.State(); s != CircuitOpen {
+		t.Fatalf("slow call should open circuit: %s", s)
+	}
+}
+
+// ioErrorStore is a store that fails with I/O errors while fail is true.
+type ioErrorStore struct {
+	Store
+	fail bool
+}
+
+func (s *ioErrorStore) err() error {
+	return &fs.PathError{Op: "read", Path: "store", Err: syscall.EIO}
+}
+
+func (s *ioErrorStore) Latest(key string) (int, error) {
+	if s.fail {
+		return 0, s.err()
+	}
+	return s.Store.Latest(key)
+}
+
+func (s *ioErrorStore) Metadata(key string, revision int) (Metadata, error) {
+	if s.fail {
+		return Metadata{}, s.err()
+	}
+	return s.Store.Metadata(key, revision)
+}
+
+func (s *ioErrorStore) Get(key string, revision int) (io.ReadSeekCloser, Metadata, error) {
+	if s.fail {
+		return nil, Metadata{}, s.err()
+	}
+	return s.Store.Get(key, revision)
+}
+
+func TestBreakerStore(t *testing.T) {
+	sec, _ := NewSecret()
+	store := &ioErrorStore{Store: &LocalStore{Path: t.TempDir()}}
+	for _, key := range []string{"hot.txt", "cold.txt"} {
+		if _, err := store.Put(key, strings.NewReader("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	breaker := NewCircuitBreaker(1, time.Minute, 0)
+	cache := NewArtifactCache(1 << 20)
+	s := Server{
+		Secret:  sec,
+		Store:   CachedStore{BreakerStore{store, breaker}, cache},
+		Cache:   cache,
+		Breaker: breaker,
+	}
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader("hello"))
+		token, _ := NewToken(sec, strings.TrimPrefix(r.URL.Path, "/"))
+		r.Header.Set("Authorization", "bearer "+token.String())
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request("GET", "/hot.txt?rev=1"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected response: %d", w.Code)
+	}
+
+	store.fail = true
+
+	if w := request("GET", "/cold.txt?rev=1"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("the first failure should be responded as 500: %d", w.Code)
+	}
+	if s := breaker.State(); s != CircuitOpen {
+		t.Fatalf("circuit should be open: %s", s)
+	}
+
+	if w := request("GET", "/hot.txt?rev=1"); w.Code != http.StatusOK || w.Body.String() != "hello world" {
+		t.Errorf("cached copy should be served while circuit is open: %d %q", w.Code, w.Body.String())
+	} else if h := w.Header().Get("Warning"); !strings.HasPrefix(h, "110 ") {
+		t.Errorf("unexpected Warning header: %q", h)
+	}
+
+	for _, path := range []string{"/cold.txt?rev=1", "/cold.txt"} {
+		w := request("GET", path)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s: unexpected status: %d", path, w.Code)
+		}
+		if h := w.Header().Get("Retry-After"); h == "" {
+			t.Errorf("GET %s: Retry-After header is required", path)
+		}
+	}
+
+	if w := request("POST", "/new.txt"); w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("writes should be rejected while circuit is open: %d %v", w.Code, w.Header())
+	}
+
+	s.Replicators = []*Replicator{{Target: &url.URL{Scheme: "http", Host: "replica:3000", Path: "/"}}}
+	if w := request("GET", "/cold.txt"); w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "http://replica:3000/cold.txt" {
+		t.Errorf("latest should be redirected to replica: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestIsBackendFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{ErrNoSuchArtifact, false},
+		{ErrDigestMismatch, false},
+		{errors.New("unknown"), false},
+		{&fs.PathError{Op: "open", Path: "x", Err: fs.ErrNotExist}, false},
+		{&fs.PathError{Op: "read", Path: "x", Err: syscall.EIO}, true},
+	}
+
+	for _, tt := range tests {
+		if got := isBackendFailure(tt.err); got != tt.want {
+			t.Errorf("%v: expected %v but got %v", tt.err, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var ErrS3ChecksumMismatch = errors.New("ETag of the uploaded object does not match to MD5 of the artifact.")
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch URL",
+	Short: "Publish an artifact from HTTP or S3",
+	Long: `Publish an artifact from HTTP or S3.
+
+URL can be http://, https://, or s3://BUCKET/KEY.
+The content is streamed into Artistore in one pass, without saving it to the local disk.
+The key is the base name of URL if --key is not set.
+
+S3 is accessed using AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, and AWS_REGION environment variables.
+Use --s3-endpoint or AWS_ENDPOINT_URL environment variable for S3 compatible storages such as MinIO.`,
+	Example: `  $ artistore fetch https://example.com/downloads/app-1.2.3.tar.gz --key vendor/app-1.2.3.tar.gz
+  $ artistore fetch s3://build-outputs/app/latest.zip --key app/latest.zip`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		key, _ := cmd.Flags().GetString("key")
+		if key == "" {
+			u, err := url.Parse(args[0])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Invalid URL:", err)
+				os.Exit(2)
+			}
+			key = path.Base(u.Path)
+		}
+		if err := VerifyKey(key); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		newRequest, err := sourceRequest(cmd, args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		t, err := NewTokenHandler()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		result, err := FetchArtifact(client, t, newRequest, key)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to fetch:", err)
+			os.Exit(1)
+		}
+		printResults(format, []ArtifactResult{result})
+	},
+}
+
+var pushS3Cmd = &cobra.Command{
+	Use:   "push-s3 S3_URL",
+	Short: "Copy an artifact to S3",
+	Long: `Copy an artifact to S3.
+
+The artifact of --key is streamed into s3://BUCKET/KEY in one pass, without saving it to the local disk.
+The ETag that S3 returned is verified by MD5 of the artifact.
+
+S3 is accessed using AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, and AWS_REGION environment variables.
+Use --s3-endpoint or AWS_ENDPOINT_URL environment variable for S3 compatible storages such as MinIO.`,
+	Example: `  $ artistore push-s3 s3://backup/library.js --key library.js
+  $ artistore push-s3 s3://backup/library-3.js --key library.js --revision 3`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		loc, err := ParseS3URL(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		key, _ := cmd.Flags().GetString("key")
+		u, err := GetURL(key)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if rev, _ := cmd.Flags().GetInt("revision"); rev > 0 {
+			q := u.Query()
+			q.Set("rev", strconv.Itoa(rev))
+			u.RawQuery = q.Encode()
+		}
+
+		endpoint, _ := cmd.Flags().GetString("s3-endpoint")
+		s3, err := S3ConfigFromEnv(endpoint)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		result, err := PushS3(client, u, s3, loc)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to push:", err)
+			os.Exit(1)
+		}
+		result.Key = key
+		printResults(format, []ArtifactResult{result})
+	},
+}
+
+func init() {
+	cmd.AddCommand(fetchCmd)
+	cmd.AddCommand(pushS3Cmd)
+
+	fetchCmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	fetchCmd.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+	fetchCmd.Flags().String("token", "", "Client token. See also 'artistore help token'.")
+	fetchCmd.Flags().String("key", "", "Key to publish. (default the base name of URL)")
+	fetchCmd.Flags().String("s3-endpoint", "", "URL of S3 compatible storage. (default AWS)")
+	addOutputFlags(fetchCmd)
+	addRetryFlags(fetchCmd)
+
+	pushS3Cmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	pushS3Cmd.Flags().String("key", "", "Key of the artifact to push.")
+	pushS3Cmd.MarkFlagRequired("key")
+	pushS3Cmd.Flags().IntP("revision", "r", 0, "Revision of the artifact. (default latest)")
+	pushS3Cmd.Flags().String("s3-endpoint", "", "URL of S3 compatible storage. (default AWS)")
+	addOutputFlags(pushS3Cmd)
+	addRetryFlags(pushS3Cmd)
+}
+
+// sourceRequest returns a function that makes GET request to the source URL of fetch command.
+func sourceRequest(cmd *cobra.Command, raw string) (func() (*http.Request, error), error) {
+	if strings.HasPrefix(raw, "s3://") {
+		loc, err := ParseS3URL(raw)
+		if err != nil {
+			return nil, err
+		}
+		endpoint, _ := cmd.Flags().GetString("s3-endpoint")
+		s3, err := S3ConfigFromEnv(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return func() (*http.Request, error) {
+			return s3.NewRequest("GET", loc, nil, emptyPayloadHash)
+		}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid URL: %s: URL should be http://, https://, or s3://", raw)
+	}
+	return func() (*http.Request, error) {
+		return http.NewRequest("GET", u.String(), nil)
+	}, nil
+}
+
+// FetchArtifact streams the response of newRequest into key.
+func FetchArtifact(client *Client, t TokenHandler, newRequest func() (*http.Request, error), key string) (ArtifactResult, error) {
+	result := ArtifactResult{Key: key}
+
+	u, err := GetURL(key)
+	if err != nil {
+		return result, err
+	}
+	token, err := t.TokenFor(key)
+	if err != nil {
+		return result, err
+	}
+
+	resp, err := client.Do(newRequest)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return result, HTTPError{resp.StatusCode, fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))}
+	}
+
+	h := md5.New()
+	result.URL, err = client.PostArtifact(u, token, io.TeeReader(resp.Body, h))
+	if err != nil {
+		return result, err
+	}
+	result.Hash = hex.EncodeToString(h.Sum(nil))
+
+	result.Revision, err = locationRevision(result.URL)
+	return result, err
+}
+
+// PushS3 streams the artifact at u into the S3 object.
+func PushS3(client *Client, u *url.URL, s3 S3Config, loc S3Location) (ArtifactResult, error) {
+	result := ArtifactResult{URL: loc.String()}
+
+	resp, err := client.Do(func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		// S3 requires Content-Length, that is unknown if the response is compressed.
+		req.Header.Set("Accept-Encoding", "identity")
+		return req, nil
+	})
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return result, HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	if resp.ContentLength < 0 {
+		return result, errors.New("The server did not send Content-Length of the artifact.")
+	}
+	result.Revision, _ = strconv.Atoi(resp.Header.Get("X-Artistore-Revision"))
+
+	h := md5.New()
+	req, err := s3.NewRequest("PUT", loc, io.TeeReader(resp.Body, h), unsignedPayload)
+	if err != nil {
+		return result, err
+	}
+	req.ContentLength = resp.ContentLength
+	if typ := resp.Header.Get("Content-Type"); typ != "" {
+		req.Header.Set("Content-Type", typ)
+	}
+
+	// The body is a stream, so the request can not be retried.
+	put, err := client.HTTP.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer put.Body.Close()
+
+	if put.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(put.Body)
+		return result, HTTPError{put.StatusCode, fmt.Sprintf("%s: %s", put.Status, strings.TrimSpace(string(msg)))}
+	}
+
+	result.Hash = hex.EncodeToString(h.Sum(nil))
+	if etag := strings.Trim(put.Header.Get("Etag"), `"`); len(etag) == md5.Size*2 && etag != result.Hash {
+		return result, ErrS3ChecksumMismatch
+	}
+	return result, nil
+}
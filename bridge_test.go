@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// fakeS3 is a minimal S3 that keeps objects in memory.
+type fakeS3 struct {
+	Objects map[string][]byte
+	ETag    string
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		data, ok := s.Objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case "PUT":
+		if r.ContentLength < 0 {
+			w.WriteHeader(http.StatusLengthRequired)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		s.Objects[r.URL.Path] = data
+		etag := s.ETag
+		if etag == "" {
+			etag = md5Hex(data)
+		}
+		w.Header().Set("Etag", `"`+etag+`"`)
+	}
+}
+
+func TestFetchAndPushS3(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	store := &LocalStore{Path: t.TempDir()}
+	ts := httptest.NewServer(Server{
+		Secret:       sec,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+	})
+	defer ts.Close()
+
+	viper.Set("server", ts.URL)
+	defer viper.Set("server", "")
+
+	s3 := &fakeS3{Objects: map[string][]byte{"/bucket/path/to/app.zip": []byte("content of app")}}
+	s3ts := httptest.NewServer(s3)
+	defer s3ts.Close()
+
+	endpoint, _ := url.Parse(s3ts.URL)
+	conf := S3Config{AccessKey: "AKID", SecretKey: "secret", Region: "us-east-1", Endpoint: endpoint}
+	client := &Client{HTTP: &http.Client{}, Retry: RetryPolicy{MaxAttempts: 1}}
+
+	result, err := FetchArtifact(client, TokenHandler{Secret: sec}, func() (*http.Request, error) {
+		return conf.NewRequest("GET", S3Location{"bucket", "path/to/app.zip"}, nil, emptyPayloadHash)
+	}, "app.zip")
+	if err != nil {
+		t.Fatalf("failed to fetch: %s", err)
+	}
+	if result.Revision != 1 || result.Hash != md5Hex([]byte("content of app")) {
+		t.Errorf("unexpected result: %v", result)
+	}
+
+	_, err = FetchArtifact(client, TokenHandler{Secret: sec}, func() (*http.Request, error) {
+		return conf.NewRequest("GET", S3Location{"bucket", "missing"}, nil, emptyPayloadHash)
+	}, "missing")
+	if err == nil {
+		t.Errorf("expected error for missing object")
+	}
+
+	u, _ := GetURL("app.zip")
+	result, err = PushS3(client, u, conf, S3Location{"backup", "app.zip"})
+	if err != nil {
+		t.Fatalf("failed to push: %s", err)
+	}
+	if string(s3.Objects["/backup/app.zip"]) != "content of app" {
+		t.Errorf("unexpected object: %q", s3.Objects["/backup/app.zip"])
+	}
+	if result.URL != "s3://backup/app.zip" || result.Revision != 1 {
+		t.Errorf("unexpected result: %v", result)
+	}
+
+	s3.ETag = md5Hex([]byte("something else"))
+	if _, err := PushS3(client, u, conf, S3Location{"backup", "app.zip"}); err != ErrS3ChecksumMismatch {
+		t.Errorf("expected checksum mismatch but got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"sync"
+)
+
+// cacheEntryRatio limits size of each entry to 1/cacheEntryRatio of the cache capacity, so that a big artifact does not flush all hot artifacts.
+const cacheEntryRatio = 8
+
+type cacheKey struct {
+	Key      string
+	Revision int
+}
+
+type cacheEntry struct {
+	key  cacheKey
+	meta Metadata
+	data []byte
+}
+
+// CacheStats is the statistics of ArtifactCache.
+type CacheStats struct {
+	Capacity  int64   `json:"capacity"`
+	Bytes     int64   `json:"bytes"`
+	Entries   int     `json:"entries"`
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Evictions uint64  `json:"evictions"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+// ArtifactCache keeps decompressed content of hot revisions in memory in LRU order.
+type ArtifactCache struct {
+	lock     sync.Mutex
+	capacity int64
+	size     int64
+	ll       *list.List
+	m        map[cacheKey]*list.Element
+
+	hits, misses, evictions uint64
+}
+
+func NewArtifactCache(capacity int64) *ArtifactCache {
+	return &ArtifactCache{
+		capacity: capacity,
+		ll:       list.New(),
+		m:        make(map[cacheKey]*list.Element),
+	}
+}
+
+// Cacheable checks if the content in the size can be cached.
+func (c *ArtifactCache) Cacheable(size int64) bool {
+	return size <= c.capacity/cacheEntryRatio
+}
+
+func (c *ArtifactCache) Get(key string, revision int) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.m[cacheKey{key, revision}]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.ll.MoveToFront(e)
+	return e.Value.(*cacheEntry).data, true
+}
+
+// GetStale returns the cached content and metadata without checking the underlying store, to serve it when the store is failing.
+// The metadata may be older than the store if the revision was patched after cached.
+func (c *ArtifactCache) GetStale(key string, revision int) ([]byte, Metadata, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.m[cacheKey{key, revision}]
+	if !ok || e.Value.(*cacheEntry).meta.Hash == "" {
+		return nil, Metadata{}, false
+	}
+
+	entry := e.Value.(*cacheEntry)
+	return entry.data, entry.meta, true
+}
+
+func (c *ArtifactCache) Add(key string, revision int, data []byte) {
+	c.AddWithMetadata(Metadata{Key: key, Revision: revision}, data)
+}
+
+// AddWithMetadata caches the content with its metadata, so that GetStale can serve it.
+func (c *ArtifactCache) AddWithMetadata(meta Metadata, data []byte) {
+	if !c.Cacheable(int64(len(data))) {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	k := cacheKey{meta.Key, meta.Revision}
+	if _, ok := c.m[k]; ok {
+		return
+	}
+
+	c.m[k] = c.ll.PushFront(&cacheEntry{k, meta, data})
+	c.size += int64(len(data))
+
+	for c.size > c.capacity {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+func (c *ArtifactCache) Remove(key string, revision int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.m[cacheKey{key, revision}]; ok {
+		c.removeElement(e)
+	}
+}
+
+func (c *ArtifactCache) removeElement(e *list.Element) {
+	entry := c.ll.Remove(e).(*cacheEntry)
+	delete(c.m, entry.key)
+	c.size -= int64(len(entry.data))
+}
+
+func (c *ArtifactCache) Stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s := CacheStats{
+		Capacity:  c.capacity,
+		Bytes:     c.size,
+		Entries:   c.ll.Len(),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		s.HitRate = float64(c.hits) / float64(total)
+	}
+	return s
+}
+
+type cachedReader struct {
+	*bytes.Reader
+}
+
+func (r cachedReader) Close() error {
+	return nil
+}
+
+// CachedStore serves hot revisions from ArtifactCache.
+//
+// Metadata is always read from the underlying store, so that deleted or patched revisions are never served from stale cache.
+type CachedStore struct {
+	Store
+	Cache *ArtifactCache
+}
+
+func (s CachedStore) Unwrap() Store {
+	return s.Store
+}
+
+func (s CachedStore) Get(key string, revision int) (io.ReadSeekCloser, Metadata, error) {
+	meta, err := s.Store.Metadata(key, revision)
+	if err != nil {
+		// Get of the underlying store reports the error in the same way as without cache.
+		return s.Store.Get(key, revision)
+	}
+
+	if data, ok := s.Cache.Get(key, revision); ok {
+		return cachedReader{bytes.NewReader(data)}, meta, nil
+	}
+
+	if !s.Cache.Cacheable(int64(meta.Size)) {
+		return s.Store.Get(key, revision)
+	}
+
+	f, meta, err := s.Store.Get(key, revision)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	s.Cache.AddWithMetadata(meta, data)
+
+	return cachedReader{bytes.NewReader(data)}, meta, nil
+}
+
+func (s CachedStore) Delete(key string, revision int) error {
+	s.Cache.Remove(key, revision)
+	return s.Store.Delete(key, revision)
+}
+
+// staleCopy returns the cached copy of the revision if --stale-if-error is enabled or the circuit breaker is open, when the store failed with err.
+func (s Server) staleCopy(key string, revision int, err error, trace *Trace) (io.ReadSeekCloser, Metadata, bool) {
+	if !s.StaleIfError && err != ErrCircuitOpen || s.Cache == nil {
+		return nil, Metadata{}, false
+	}
+
+	data, meta, ok := s.Cache.GetStale(key, revision)
+	if !ok {
+		return nil, Metadata{}, false
+	}
+
+	PrintWarn("STALE", "%s#%d: serve cached copy because of error: %s", key, revision, err)
+	trace.Printf("failed to read revision %d from the store; serve stale cached copy: %s", revision, err)
+	return cachedReader{bytes.NewReader(data)}, meta, true
+}
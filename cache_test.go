@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestArtifactCache(t *testing.T) {
+	c := NewArtifactCache(80)
+
+	c.Add("a", 1, []byte("0123456789"))
+	c.Add("b", 1, []byte("0123456789"))
+	c.Add("too-big", 1, []byte("01234567890"))
+
+	if _, ok := c.Get("too-big", 1); ok {
+		t.Errorf("too big entry should not be cached")
+	}
+	if data, ok := c.Get("a", 1); !ok || string(data) != "0123456789" {
+		t.Errorf("unexpected cache of a#1: %q (found=%v)", data, ok)
+	}
+
+	for i := 2; i <= 8; i++ {
+		c.Add("a", i, []byte("0123456789"))
+	}
+
+	if _, ok := c.Get("b", 1); ok {
+		t.Errorf("least recently used entry should be evicted")
+	}
+	if _, ok := c.Get("a", 1); !ok {
+		t.Errorf("recently used entry should be kept")
+	}
+
+	c.Remove("a", 1)
+	if _, ok := c.Get("a", 1); ok {
+		t.Errorf("removed entry should not be found")
+	}
+
+	stats := c.Stats()
+	expect := CacheStats{Capacity: 80, Bytes: 70, Entries: 7, Hits: 2, Misses: 3, Evictions: 1, HitRate: 0.4}
+	if stats != expect {
+		t.Errorf("unexpected stats\nexpected: %#v\n but got: %#v", expect, stats)
+	}
+}
+
+func TestCachedStore(t *testing.T) {
+	local := &LocalStore{Path: t.TempDir()}
+	store := CachedStore{local, NewArtifactCache(1 << 20)}
+
+	for _, body := range []string{"hello world", "hello again"} {
+		if _, err := store.Put("hello.txt", strings.NewReader(body), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		f, meta, err := store.Get("hello.txt", 1)
+		if err != nil {
+			t.Fatalf("failed to get: %s", err)
+		}
+		var buf bytes.Buffer
+		io.Copy(&buf, f)
+		f.Close()
+		if buf.String() != "hello world" || meta.Revision != 1 {
+			t.Errorf("unexpected artifact: %q %#v", buf.String(), meta)
+		}
+	}
+	if stats := store.Cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("unexpected stats: %#v", stats)
+	}
+
+	if err := store.Delete("hello.txt", 1); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+	if _, _, err := store.Get("hello.txt", 1); err != ErrRevisionDeleted {
+		t.Errorf("expected ErrRevisionDeleted but got %v", err)
+	}
+
+	f, _, err := store.Get("hello.txt", 2)
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+	f.Close()
+	local.remove("hello.txt", 2)
+	if _, _, err := store.Get("hello.txt", 2); err != ErrNoSuchArtifact {
+		t.Errorf("revision removed from underlying store should not be served from cache: %v", err)
+	}
+}
+
+// brokenStore is a store that fails to read while fail is true.
+type brokenStore struct {
+	Store
+	fail bool
+}
+
+func (s *brokenStore) Metadata(key string, revision int) (Metadata, error) {
+	if s.fail {
+		return Metadata{}, errors.New("disk is broken")
+	}
+	return s.Store.Metadata(key, revision)
+}
+
+func (s *brokenStore) Get(key string, revision int) (io.ReadSeekCloser, Metadata, error) {
+	if s.fail {
+		return nil, Metadata{}, errors.New("disk is broken")
+	}
+	return s.Store.Get(key, revision)
+}
+
+func TestStaleIfError(t *testing.T) {
+	store := &brokenStore{Store: &LocalStore{Path: t.TempDir()}}
+	for _, key := range []string{"hot.txt", "cold.txt"} {
+		if _, err := store.Put(key, strings.NewReader("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	cache := NewArtifactCache(1 << 20)
+	get := func(staleIfError bool, key string) *httptest.ResponseRecorder {
+		s := Server{Store: CachedStore{store, cache}, Cache: cache, StaleIfError: staleIfError}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/"+key+"?rev=1", nil))
+		return w
+	}
+
+	if w := get(true, "hot.txt"); w.Code != http.StatusOK || w.Header().Get("Warning") != "" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+
+	store.fail = true
+
+	w := get(true, "hot.txt")
+	if w.Code != http.StatusOK || w.Body.String() != "hello world" {
+		t.Errorf("cached copy should be served: %d %q", w.Code, w.Body.String())
+	}
+	if h := w.Header().Get("Warning"); !strings.HasPrefix(h, "110 ") {
+		t.Errorf("unexpected Warning header: %q", h)
+	}
+	if h := w.Header().Get("Content-Type"); h != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected Content-Type: %q", h)
+	}
+
+	if w := get(true, "cold.txt"); w.Code != http.StatusInternalServerError {
+		t.Errorf("uncached revision should fail: %d", w.Code)
+	}
+	if w := get(false, "hot.txt"); w.Code != http.StatusInternalServerError {
+		t.Errorf("cached copy should not be served without --stale-if-error: %d", w.Code)
+	}
+}
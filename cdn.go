@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	PurgeMaxAttempts = 5
+	PurgeRetryWait   = time.Second
+)
+
+// surrogateTag escapes characters that can not be in a surrogate key or a cache tag, such as space and comma.
+func surrogateTag(kind, value string) string {
+	return kind + ":" + strings.ReplaceAll(url.PathEscape(value), "%2F", "/")
+}
+
+// SurrogateKeys returns tags of the key for CDNs that support tag-based purge.
+//
+//	key:KEY          all responses of the key, including the latest revision and redirects to it.
+//	rev:KEY#REV      the revision. It is omitted if rev is 0.
+//	prefix:PREFIX/   all keys under the directory, for each parent directory.
+func SurrogateKeys(key string, rev int) []string {
+	tags := []string{surrogateTag("key", key)}
+	if rev > 0 {
+		tags = append(tags, surrogateTag("rev", key)+"#"+strconv.Itoa(rev))
+	}
+	for i, c := range key {
+		if c == '/' {
+			tags = append(tags, surrogateTag("prefix", key[:i+1]))
+		}
+	}
+	return tags
+}
+
+// setSurrogateKeys sets Surrogate-Key for Fastly and Cache-Tag for Cloudflare, if --surrogate-keys is enabled.
+func (s Server) setSurrogateKeys(w http.ResponseWriter, key string, rev int) {
+	if !s.SurrogateKeys {
+		return
+	}
+
+	tags := SurrogateKeys(key, rev)
+	w.Header().Set("Surrogate-Key", strings.Join(tags, " "))
+	w.Header().Set("Cache-Tag", strings.Join(tags, ","))
+}
+
+// PurgeStyle is the request format of the purge hook.
+type PurgeStyle string
+
+const (
+	// PurgeFastly sends tags in Surrogate-Key header, for the purge API of Fastly.
+	PurgeFastly PurgeStyle = "fastly"
+
+	// PurgeCloudflare sends tags in JSON as {"tags": [...]}, for the purge API of Cloudflare.
+	PurgeCloudflare PurgeStyle = "cloudflare"
+
+	// PurgeJSON sends PurgeRequest in JSON, for custom purge services.
+	PurgeJSON PurgeStyle = "json"
+)
+
+func ParsePurgeStyle(s string) (PurgeStyle, error) {
+	switch PurgeStyle(strings.ToLower(s)) {
+	case PurgeFastly:
+		return PurgeFastly, nil
+	case PurgeCloudflare:
+		return PurgeCloudflare, nil
+	case PurgeJSON:
+		return PurgeJSON, nil
+	default:
+		return "", fmt.Errorf("Invalid purge style: %q: it should be fastly, cloudflare, or json.", s)
+	}
+}
+
+// PurgeRequest is the payload of the purge hook in json style.
+type PurgeRequest struct {
+	Event    string   `json:"event"`
+	Key      string   `json:"key"`
+	Revision int      `json:"revision"`
+	Tags     []string `json:"tags"`
+}
+
+// PurgeHook asks a CDN to purge cached responses by surrogate keys when the latest revision is changed.
+// It is a best-effort: purges are sent asynchronously, and dropped after PurgeMaxAttempts attempts.
+type PurgeHook struct {
+	URL    string
+	Style  PurgeStyle
+	Header http.Header
+	Client *http.Client
+}
+
+// NewPurgeHook makes PurgeHook. Each header is "Name: value", such as "Fastly-Key: xxx".
+func NewPurgeHook(u string, style PurgeStyle, headers []string) (*PurgeHook, error) {
+	if _, err := url.ParseRequestURI(u); err != nil {
+		return nil, fmt.Errorf("Invalid purge URL: %s", err)
+	}
+
+	h := &PurgeHook{
+		URL:    u,
+		Style:  style,
+		Header: make(http.Header),
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, x := range headers {
+		xs := strings.SplitN(x, ":", 2)
+		if len(xs) != 2 || strings.TrimSpace(xs[0]) == "" {
+			return nil, fmt.Errorf("Invalid purge header: %q: it should be \"Name: value\".", x)
+		}
+		h.Header.Add(strings.TrimSpace(xs[0]), strings.TrimSpace(xs[1]))
+	}
+	return h, nil
+}
+
+// Published purges the latest pointer of the key, that is redirects and direct responses of the latest revision.
+// Responses of revisions are immutable, but they are purged too because they are tagged with the key.
+func (h *PurgeHook) Published(key string, rev int) {
+	if h == nil {
+		return
+	}
+	go h.purge(PurgeRequest{"publish", key, rev, []string{surrogateTag("key", key)}})
+}
+
+// Deleted purges the deleted revision.
+func (h *PurgeHook) Deleted(key string, rev int) {
+	if h == nil {
+		return
+	}
+	go h.purge(PurgeRequest{"delete", key, rev, []string{surrogateTag("rev", key) + "#" + strconv.Itoa(rev)}})
+}
+
+func (h *PurgeHook) purge(p PurgeRequest) {
+	wait := PurgeRetryWait
+	for attempt := 1; ; attempt++ {
+		err := h.send(p)
+		if err == nil {
+			PrintLog("PURGE", "%s %s", h.URL, strings.Join(p.Tags, " "))
+			return
+		}
+		if attempt >= PurgeMaxAttempts {
+			PrintErr("PURGE", "%s %s: %s (give up after %d attempts)", h.URL, strings.Join(p.Tags, " "), err, attempt)
+			return
+		}
+		PrintWarn("PURGE", "%s %s: %s (retry after %s)", h.URL, strings.Join(p.Tags, " "), err, wait)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (h *PurgeHook) send(p PurgeRequest) error {
+	var body []byte
+	var err error
+	switch h.Style {
+	case PurgeFastly:
+		body = nil
+	case PurgeCloudflare:
+		body, err = json.Marshal(map[string][]string{"tags": p.Tags})
+	default:
+		body, err = json.Marshal(p)
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range h.Header {
+		req.Header[name] = values
+	}
+	if h.Style == PurgeFastly {
+		req.Header.Set("Surrogate-Key", strings.Join(p.Tags, " "))
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
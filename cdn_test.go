@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSurrogateKeys(t *testing.T) {
+	tests := []struct {
+		Key      string
+		Rev      int
+		Expected []string
+	}{
+		{"hello.txt", 0, []string{"key:hello.txt"}},
+		{"release/1.0/app.js", 3, []string{"key:release/1.0/app.js", "rev:release/1.0/app.js#3", "prefix:release/", "prefix:release/1.0/"}},
+		{"my dir/a,b.txt", 1, []string{"key:my%20dir/a%2Cb.txt", "rev:my%20dir/a%2Cb.txt#1", "prefix:my%20dir/"}},
+	}
+
+	for _, tt := range tests {
+		if actual := SurrogateKeys(tt.Key, tt.Rev); !reflect.DeepEqual(actual, tt.Expected) {
+			t.Errorf("%s#%d: expected %v but got %v", tt.Key, tt.Rev, tt.Expected, actual)
+		}
+	}
+}
+
+func TestServeSurrogateKeys(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	if _, err := store.Put("release/app.js", bytes.NewBufferString("hello"), PutOptions{}); err != nil {
+		t.Fatalf("failed to put: %s", err)
+	}
+
+	tests := []struct {
+		Enabled bool
+		Path    string
+		Status  int
+		Fastly  string
+		Cloud   string
+	}{
+		{true, "/release/app.js?rev=1", http.StatusOK, "key:release/app.js rev:release/app.js#1 prefix:release/", "key:release/app.js,rev:release/app.js#1,prefix:release/"},
+		{true, "/release/app.js", http.StatusSeeOther, "key:release/app.js prefix:release/", "key:release/app.js,prefix:release/"},
+		{true, "/release/missing.js", http.StatusNotFound, "key:release/missing.js prefix:release/", "key:release/missing.js,prefix:release/"},
+		{false, "/release/app.js?rev=1", http.StatusOK, "", ""},
+	}
+
+	for _, tt := range tests {
+		s := Server{Store: store, SurrogateKeys: tt.Enabled}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", tt.Path, nil))
+
+		if w.Code != tt.Status {
+			t.Errorf("%s: unexpected status: %d", tt.Path, w.Code)
+		}
+		if h := w.Header().Get("Surrogate-Key"); h != tt.Fastly {
+			t.Errorf("%s: unexpected Surrogate-Key: %q", tt.Path, h)
+		}
+		if h := w.Header().Get("Cache-Tag"); h != tt.Cloud {
+			t.Errorf("%s: unexpected Cache-Tag: %q", tt.Path, h)
+		}
+	}
+}
+
+func TestPurgeHook(t *testing.T) {
+	type received struct {
+		Header http.Header
+		Body   string
+	}
+	ch := make(chan received, 10)
+	failed := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if failed > 0 {
+			failed--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		ch <- received{r.Header, string(body)}
+	}))
+	defer srv.Close()
+
+	wait := PurgeRetryWait
+	PurgeRetryWait = time.Millisecond
+	defer func() { PurgeRetryWait = wait }()
+
+	receive := func() received {
+		select {
+		case r := <-ch:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatalf("purge request was not sent")
+			return received{}
+		}
+	}
+
+	fastly, err := NewPurgeHook(srv.URL, PurgeFastly, []string{"Fastly-Key: secret"})
+	if err != nil {
+		t.Fatalf("failed to make purge hook: %s", err)
+	}
+	fastly.Published("release/app.js", 2)
+	if r := receive(); r.Header.Get("Surrogate-Key") != "key:release/app.js" || r.Header.Get("Fastly-Key") != "secret" {
+		t.Errorf("unexpected fastly request: %v", r.Header)
+	}
+
+	cloudflare, _ := NewPurgeHook(srv.URL, PurgeCloudflare, nil)
+	cloudflare.Deleted("release/app.js", 1)
+	if r := receive(); r.Body != `{"tags":["rev:release/app.js#1"]}` {
+		t.Errorf("unexpected cloudflare request: %s", r.Body)
+	}
+
+	custom, _ := NewPurgeHook(srv.URL, PurgeJSON, nil)
+	custom.Published("hello.txt", 3)
+	var req PurgeRequest
+	if err := json.Unmarshal([]byte(receive().Body), &req); err != nil {
+		t.Fatalf("failed to parse request: %s", err)
+	}
+	if !reflect.DeepEqual(req, PurgeRequest{"publish", "hello.txt", 3, []string{"key:hello.txt"}}) {
+		t.Errorf("unexpected json request: %#v", req)
+	}
+
+	if _, err := NewPurgeHook(srv.URL, PurgeJSON, []string{"invalid"}); err == nil {
+		t.Errorf("invalid header should be rejected")
+	}
+	if _, err := ParsePurgeStyle("akamai"); err == nil {
+		t.Errorf("unknown style should be rejected")
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+type HTTPError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e HTTPError) Error() string {
+	return e.Message
+}
+
+type Client struct {
+	HTTP  *http.Client
+	Retry RetryPolicy
+
+	// ReadToken is sent with GET and HEAD requests that have no Authorization header, to read private artifacts.
+	ReadToken Token
+
+	// Delta makes PublishArtifact upload only the difference from the latest revision if it is small enough.
+	Delta bool
+
+	// Context aborts requests and waits for retries when it is canceled. context.Background is used if nil.
+	Context context.Context
+}
+
+// NewClient makes a client for CLI commands using flags or environment variables.
+func NewClient() (*Client, error) {
+	retry, err := GetRetryPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := viper.GetDuration("timeout")
+	if timeout < 0 {
+		return nil, errors.New("Invalid --timeout: it should be 0 or more.")
+	}
+
+	transport, err := newTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	limiter, err := getRateLimiter()
+	if err != nil {
+		return nil, err
+	}
+	if limiter != nil {
+		transport = rateLimitTransport{transport, limiter}
+	}
+
+	return &Client{
+		HTTP:  &http.Client{Transport: transport, Timeout: timeout},
+		Retry: retry,
+	}, nil
+}
+
+// Do sends a request made by newRequest, and retries it according to the retry policy.
+//
+// POST requests are retried only when the server responded an error status, because the artifact might be already published if the connection is lost.
+func (c *Client) Do(newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		if c.ReadToken != nil && (req.Method == "GET" || req.Method == "HEAD") && req.Header.Get("Authorization") == "" {
+			req.Header.Set("Authorization", "bearer "+c.ReadToken.String())
+		}
+
+		resp, err := c.HTTP.Do(req.WithContext(c.context()))
+		if c.context().Err() != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, ErrInterrupted
+		} else if err != nil {
+			if attempt >= c.Retry.MaxAttempts || req.Method == "POST" {
+				return nil, err
+			}
+
+			wait := c.Retry.Backoff(attempt)
+			PrintWarn("RETRY", "%s %s: %s (retry after %s)", req.Method, req.URL, err, wait.Round(time.Millisecond))
+			if err := sleepContext(c.context(), wait); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if attempt >= c.Retry.MaxAttempts || !c.Retry.RetryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+
+		wait, ok := retryAfter(resp)
+		if !ok || wait < 0 {
+			wait = c.Retry.Backoff(attempt)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		PrintWarn("RETRY", "%s %s: %s (retry after %s)", req.Method, req.URL, resp.Status, wait.Round(time.Millisecond))
+		if err := sleepContext(c.context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) context() context.Context {
+	if c.Context == nil {
+		return context.Background()
+	}
+	return c.Context
+}
+
+// PostArtifact publishes body to u.
+// It retries only if body implements io.Seeker, because the body have to be sent again from the beginning.
+//
+// If the connection is lost, it asks the server about the upload by the Idempotency-Key before sending again.
+// The request is sent again only if the server confirms that no revision was published; otherwise the existing revision is returned.
+func (c *Client) PostArtifact(u *url.URL, token Token, body io.Reader) (location string, err error) {
+	return c.postArtifact(u, token, body, "application/octet-stream")
+}
+
+// PostRedirect publishes a redirect artifact to u, that redirects to target.
+func (c *Client) PostRedirect(u *url.URL, token Token, target string) (location string, err error) {
+	return c.postArtifact(u, token, strings.NewReader(target), RedirectType)
+}
+
+func (c *Client) postArtifact(u *url.URL, token Token, body io.Reader, contentType string) (location string, err error) {
+	client := *c
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		client.Retry.MaxAttempts = 1
+	}
+
+	idempotencyKey := NewID()
+
+	first := true
+	newRequest := func() (*http.Request, error) {
+		if !first {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		first = false
+
+		req, err := http.NewRequest("POST", u.String(), body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "bearer "+token.String())
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+		return req, nil
+	}
+
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		resp, err = client.Do(newRequest)
+		if err == nil {
+			break
+		}
+		if attempt >= client.Retry.MaxAttempts {
+			return "", err
+		}
+
+		wait := client.Retry.Backoff(attempt)
+		PrintWarn("RETRY", "POST %s: %s (check the upload after %s)", u, err, wait.Round(time.Millisecond))
+		if err := sleepContext(client.context(), wait); err != nil {
+			return "", err
+		}
+
+		published, cerr := client.uploadResult(u, token, idempotencyKey)
+		if cerr != nil {
+			PrintWarn("RETRY", "POST %s: give up because it can not be confirmed that the artifact was not published: %s", u, cerr)
+			return "", err
+		} else if published != "" {
+			return published, nil
+		}
+	}
+	defer resp.Body.Close()
+
+	msg, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", HTTPError{resp.StatusCode, string(msg)}
+	}
+
+	for _, v := range resp.Header.Values("Warning") {
+		PrintWarn("WARN", "%s: %s", u.Path, warningText(v))
+	}
+
+	return strings.TrimSpace(string(msg)), nil
+}
+
+// StageArtifact uploads body into the transaction at u.
+// Staging can be retried safely because it replaces the previous upload of the same key.
+func (c *Client) StageArtifact(u *url.URL, token Token, body io.ReadSeeker) error {
+	resp, err := c.Do(func() (*http.Request, error) {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest("PUT", u.String(), io.NopCloser(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Authorization", "bearer "+token.String())
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(resp.Body)
+		return HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
+
+// uploadResult asks the server about the upload to u, and returns the location if it has been published.
+// The location is empty if the server confirmed that nothing was published.
+func (c *Client) uploadResult(u *url.URL, token Token, id string) (location string, err error) {
+	api, err := u.Parse("/" + APIPrefix + "v1/uploads/" + id)
+	if err != nil {
+		return "", err
+	}
+
+	var status UploadStatus
+	err = c.CallAPI("GET", api, token, nil, &status)
+	var herr HTTPError
+	if errors.As(err, &herr) && herr.StatusCode == http.StatusNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	switch {
+	case status.State == "failed":
+		return "", nil
+	case status.State == "done" && status.Revision > 0:
+		loc := *u
+		q := loc.Query()
+		q.Set("rev", strconv.Itoa(status.Revision))
+		loc.RawQuery = q.Encode()
+		return loc.String(), nil
+	default:
+		return "", fmt.Errorf("The upload is still %s.", status.State)
+	}
+}
+
+// warningText extracts the message from Warning header such as `199 Artistore "message"`.
+func warningText(header string) string {
+	xs := strings.SplitN(header, " ", 3)
+	if len(xs) != 3 {
+		return header
+	}
+	if s, err := strconv.Unquote(xs[2]); err == nil {
+		return s
+	}
+	return xs[2]
+}
+
+func (c *Client) Get(u *url.URL) (*http.Response, error) {
+	return c.Do(func() (*http.Request, error) {
+		return http.NewRequest("GET", u.String(), nil)
+	})
+}
+
+func (c *Client) CallAPI(method string, u *url.URL, token Token, in, out interface{}) error {
+	var payload []byte
+	if in != nil {
+		var err error
+		payload, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.Do(func() (*http.Request, error) {
+		var body io.Reader
+		if in != nil {
+			body = bytes.NewReader(payload)
+		}
+
+		req, err := http.NewRequest(method, u.String(), body)
+		if err != nil {
+			return nil, err
+		}
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if token != nil {
+			req.Header.Set("Authorization", "bearer "+token.String())
+		}
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestClientPostArtifactRetry(t *testing.T) {
+	var attempts int
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "hello world" {
+			t.Errorf("expected body %q but got %q", "hello world", body)
+		}
+
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintln(w, "http://example.com/hello.txt?rev=1")
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/hello.txt")
+	c := &Client{
+		HTTP:  &http.Client{},
+		Retry: RetryPolicy{MaxAttempts: 3, RetryOn: []int{http.StatusServiceUnavailable}},
+	}
+	token, err := NewToken(Secret("hello"), "hello.txt")
+	if err != nil {
+		t.Fatalf("failed to generate token: %s", err)
+	}
+
+	location, err := c.PostArtifact(u, token, bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	if location != "http://example.com/hello.txt?rev=1" {
+		t.Errorf("unexpected location: %s", location)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts but got %d", attempts)
+	}
+	if keys[0] == "" || keys[0] != keys[1] || keys[1] != keys[2] {
+		t.Errorf("expected the same idempotency key for all attempts but got %v", keys)
+	}
+
+	attempts = 0
+	_, err = c.PostArtifact(u, token, io.LimitReader(strings.NewReader("hello world"), 100))
+	if herr, ok := err.(HTTPError); !ok || herr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 error but got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("non-seekable body should not be retried but got %d attempts", attempts)
+	}
+}
+
+func TestClientPostArtifactConnectionLost(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewToken(sec, "hello.txt")
+
+	store := &LocalStore{Path: t.TempDir()}
+	s := Server{Secret: sec, Store: store, Expectations: NewExpectationStore(), Uploads: NewUploadTracker()}
+
+	var posts int
+	var lost func(w http.ResponseWriter, r *http.Request)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			posts++
+			if posts == 1 {
+				lost(w, r)
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/hello.txt")
+	c := &Client{HTTP: &http.Client{}, Retry: RetryPolicy{MaxAttempts: 3}}
+
+	tests := []struct {
+		Name     string
+		Lost     func(w http.ResponseWriter, r *http.Request)
+		Posts    int
+		Revision string
+	}{
+		{"response lost", func(w http.ResponseWriter, r *http.Request) { s.ServeHTTP(httptest.NewRecorder(), r) }, 1, "1"},
+		{"request lost", func(w http.ResponseWriter, r *http.Request) { io.Copy(io.Discard, r.Body) }, 2, "2"},
+	}
+
+	for _, tt := range tests {
+		posts = 0
+		lost = tt.Lost
+
+		location, err := c.PostArtifact(u, token, bytes.NewReader([]byte("hello world")))
+		if err != nil {
+			t.Errorf("%s: failed to post: %s", tt.Name, err)
+			continue
+		}
+		if posts != tt.Posts {
+			t.Errorf("%s: expected %d posts but got %d", tt.Name, tt.Posts, posts)
+		}
+		if loc, _ := url.Parse(location); loc == nil || loc.Query().Get("rev") != tt.Revision {
+			t.Errorf("%s: expected revision %s but got %s", tt.Name, tt.Revision, location)
+		}
+	}
+
+	if latest, _ := store.Latest("hello.txt"); latest != 2 {
+		t.Errorf("expected no duplicated revisions but latest is %d", latest)
+	}
+}
@@ -9,7 +9,7 @@ import (
 	"github.com/spf13/viper"
 )
 
-func GetURL(key string) (*url.URL, error) {
+func getServerURL(path string) (*url.URL, error) {
 	server := strings.TrimSpace(viper.GetString("server"))
 	if server == "" {
 		return nil, errors.New("Server address is required.\nPlease set --server flag or ARTISTORE_SERVER environment variable.")
@@ -20,14 +20,22 @@ func GetURL(key string) (*url.URL, error) {
 		return nil, fmt.Errorf("Invalid server address: %s", err)
 	}
 
-	if err := VerifyKey(key); err != nil {
-		return nil, err
-	}
-
-	u, err = u.Parse("/" + key)
+	u, err = u.Parse("/" + path)
 	if err != nil {
 		return nil, fmt.Errorf("Invalid server address: %s", err)
 	}
 
 	return u, nil
 }
+
+func GetURL(key string) (*url.URL, error) {
+	if err := VerifyKey(key); err != nil {
+		return nil, err
+	}
+
+	return getServerURL(key)
+}
+
+func GetAPIURL(path string) (*url.URL, error) {
+	return getServerURL(APIPrefix + path)
+}
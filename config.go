@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// ClientConfig is the client-side config file, that is "~/.config/artistore/config.yaml" by default.
+//
+//	current-profile: prod
+//	profiles:
+//	  prod:
+//	    server: https://artifacts.example.com
+//	    token: t2:...
+//	  local:
+//	    server: http://localhost:3000
+//	    secret: ...
+type ClientConfig struct {
+	CurrentProfile string                   `yaml:"current-profile,omitempty"`
+	Profiles       map[string]ClientProfile `yaml:"profiles"`
+}
+
+// ClientProfile is a set of settings for a server.
+// They are used as defaults of the flags, so flags and environment variables still take precedence.
+type ClientProfile struct {
+	Server string `yaml:"server,omitempty"`
+	Token  string `yaml:"token,omitempty"`
+	Secret string `yaml:"secret,omitempty"`
+}
+
+// configPath returns the path to the client config file.
+func configPath(v *viper.Viper) (string, error) {
+	if p := v.GetString("config"); p != "" {
+		return p, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "artistore", "config.yaml"), nil
+}
+
+// LoadClientConfig reads the config file. It returns an empty config if the file does not exist.
+func LoadClientConfig(path string) (ClientConfig, error) {
+	var c ClientConfig
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return c, err
+	}
+
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return c, fmt.Errorf("Invalid config file %s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the config file.
+func (c ClientConfig) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// The file may have tokens or secrets.
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Profile returns the profile of the name, or the current profile if name is empty.
+// The second value is false if no profile is selected.
+func (c ClientConfig) Profile(name string) (ClientProfile, bool, error) {
+	if name == "" {
+		name = c.CurrentProfile
+	}
+	if name == "" {
+		return ClientProfile{}, false, nil
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return ClientProfile{}, false, fmt.Errorf("No such profile: %s", name)
+	}
+	return p, true, nil
+}
+
+// applyProfile loads the profile that selected by --profile or the config file, and uses it as defaults of flags in v.
+func applyProfile(v *viper.Viper) error {
+	path, err := configPath(v)
+	if err != nil {
+		// There is no config file if the home directory is unknown.
+		return nil
+	}
+
+	c, err := LoadClientConfig(path)
+	if err != nil {
+		return err
+	}
+
+	p, ok, err := c.Profile(v.GetString("profile"))
+	if err != nil || !ok {
+		return err
+	}
+
+	values := make(map[string]interface{})
+	if p.Server != "" {
+		values["server"] = p.Server
+	}
+	if p.Token != "" {
+		values["token"] = p.Token
+	}
+	if p.Secret != "" {
+		values["secret"] = p.Secret
+	}
+	return v.MergeConfigMap(values)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage client config file",
+	Long: `Manage client config file.
+
+The config file has named profiles of the server URL and the token or the secret, so that you can switch servers without environment variables.
+The profile is selected by --profile flag, ARTISTORE_PROFILE environment variable, or "artistore config use-context".
+Flags and environment variables such as --server and ARTISTORE_TOKEN take precedence over the profile.
+
+The config file is "artistore/config.yaml" in the user config directory, such as "~/.config/artistore/config.yaml".
+Use --config flag or ARTISTORE_CONFIG environment variable to use another file.
+
+    current-profile: prod
+    profiles:
+      prod:
+        server: https://artifacts.example.com
+        token: t2:...
+      local:
+        server: http://localhost:3000
+        secret: ...`,
+}
+
+var configUseContextCmd = &cobra.Command{
+	Use:   "use-context PROFILE",
+	Short: "Set the current profile",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := configPath(viper.GetViper())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		c, err := LoadClientConfig(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if _, _, err := c.Profile(args[0]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		c.CurrentProfile = args[0]
+		if err := c.Save(path); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to save config file:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var configGetContextsCmd = &cobra.Command{
+	Use:   "get-contexts",
+	Short: "List profiles",
+	Long: `List profiles in the config file.
+
+The current profile is marked with "*".`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := configPath(viper.GetViper())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		c, err := LoadClientConfig(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		names := make([]string, 0, len(c.Profiles))
+		for name := range c.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		current := viper.GetString("profile")
+		if current == "" {
+			current = c.CurrentProfile
+		}
+		for _, name := range names {
+			mark := " "
+			if name == current {
+				mark = "*"
+			}
+			fmt.Printf("%s %s\t%s\n", mark, name, c.Profiles[name].Server)
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(configCmd)
+	configCmd.AddCommand(configUseContextCmd)
+	configCmd.AddCommand(configGetContextsCmd)
+
+	cmd.PersistentFlags().String("profile", "", "Profile in the config file to use. See also 'artistore help config'.")
+	viper.BindPFlag("profile", cmd.PersistentFlags().Lookup("profile"))
+
+	cmd.PersistentFlags().String("config", "", "Path to the client config file. (default \"artistore/config.yaml\" in the user config directory)")
+	viper.BindPFlag("config", cmd.PersistentFlags().Lookup("config"))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestClientConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artistore", "config.yaml")
+
+	c, err := LoadClientConfig(path)
+	if err != nil {
+		t.Fatalf("missing config file should be empty: %s", err)
+	}
+	if _, ok, err := c.Profile(""); ok || err != nil {
+		t.Errorf("empty config should have no profile: %v", err)
+	}
+
+	c.Profiles = map[string]ClientProfile{
+		"prod":  {Server: "https://artifacts.example.com", Token: "t1:prod"},
+		"local": {Server: "http://localhost:3000"},
+	}
+	c.CurrentProfile = "prod"
+	if err := c.Save(path); err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("config file should be private: %v", err)
+	}
+
+	c, err = LoadClientConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	if p, ok, err := c.Profile(""); !ok || err != nil || p.Token != "t1:prod" {
+		t.Errorf("unexpected current profile: %v (ok=%v error=%v)", p, ok, err)
+	}
+	if p, ok, err := c.Profile("local"); !ok || err != nil || p.Server != "http://localhost:3000" {
+		t.Errorf("unexpected local profile: %v (ok=%v error=%v)", p, ok, err)
+	}
+	if _, _, err := c.Profile("staging"); err == nil {
+		t.Errorf("unknown profile should be an error")
+	}
+
+	if err := os.WriteFile(path, []byte("profile:\n  prod: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadClientConfig(path); err == nil {
+		t.Errorf("unknown field should be an error")
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	c := ClientConfig{
+		CurrentProfile: "prod",
+		Profiles: map[string]ClientProfile{
+			"prod":  {Server: "https://artifacts.example.com", Token: "t1:prod"},
+			"local": {Server: "http://localhost:3000", Secret: "local-secret"},
+		},
+	}
+	if err := c.Save(path); err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+
+	v := viper.New()
+	v.Set("config", path)
+
+	if err := applyProfile(v); err != nil {
+		t.Fatalf("failed to apply profile: %s", err)
+	}
+	if s := v.GetString("server"); s != "https://artifacts.example.com" {
+		t.Errorf("unexpected server: %s", s)
+	}
+
+	v.Set("profile", "local")
+	if err := applyProfile(v); err != nil {
+		t.Fatalf("failed to apply profile: %s", err)
+	}
+	if s := v.GetString("server"); s != "http://localhost:3000" {
+		t.Errorf("unexpected server: %s", s)
+	}
+	if s := v.GetString("secret"); s != "local-secret" {
+		t.Errorf("unexpected secret: %s", s)
+	}
+
+	v.Set("profile", "staging")
+	if err := applyProfile(v); err == nil {
+		t.Errorf("unknown profile should be an error")
+	}
+}
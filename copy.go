@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// copyRevision publishes a new revision of key with the content of another revision in the server, for "POST /KEY?copy-from=SRC&rev=N".
+// The revision is the latest if rev is omitted.
+//
+// The token has to be allowed to publish key, and to read SRC if it is private.
+// Type and labels are copied from the source, and labels in headers override them.
+// Validators and scanners are not run again, because the content has been checked when it was published.
+func (s Server) copyRevision(key string, w http.ResponseWriter, r *http.Request) {
+	src := r.URL.Query().Get("copy-from")
+	if err := VerifyKey(src); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid copy-from: %s\n", err)
+		return
+	}
+
+	if !s.authorize(key, ScopePublish, w, r) || !s.authorizeRead(src, w, r) {
+		return
+	}
+
+	var rev int
+	var err error
+	if raw := r.URL.Query().Get("rev"); raw == "" || raw == "latest" {
+		rev, err = s.latest(src)
+	} else if rev, err = strconv.Atoi(raw); err != nil || rev <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Invalid revision.")
+		return
+	}
+	if err == ErrNoSuchArtifact {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, err)
+		return
+	} else if err == ErrCircuitOpen {
+		s.unavailable(w)
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	f, meta, err := s.Store.Get(src, rev)
+	if err == ErrNoSuchArtifact {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, err)
+		return
+	} else if err == ErrRevisionDeleted {
+		w.WriteHeader(http.StatusGone)
+		fmt.Fprintln(w, err)
+		return
+	} else if err == ErrCircuitOpen {
+		s.unavailable(w)
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+	defer f.Close()
+
+	labels, err := parseLabelHeaders(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+	if len(meta.Labels) > 0 {
+		merged := make(map[string]string)
+		for k, v := range meta.Labels {
+			merged[k] = v
+		}
+		for k, v := range labels {
+			merged[k] = v
+		}
+		labels = merged
+	}
+	if !s.checkPolicy(key, labels, int64(meta.Size), w, r) {
+		return
+	}
+
+	precondition, ok := s.checkPublishPrecondition(key, w, r)
+	if !ok {
+		return
+	}
+
+	acl := s.ACL.Get()
+	var warnings []string
+	var putMeta Metadata
+	opts := PutOptions{
+		Type:   meta.Type,
+		Labels: labels,
+		Verify: func(m Metadata) (err error) {
+			putMeta = m
+			if err := precondition.Check(m.Revision); err != nil {
+				return err
+			}
+			if m.Hash != meta.Hash {
+				return ErrDigestMismatch
+			}
+			if err := s.Policy.CheckContent(m); err != nil {
+				return err
+			}
+			warnings, err = s.checkQuota(acl, key, m.Size)
+			return err
+		},
+	}
+
+	published, err := s.Store.Put(key, f, opts)
+	var verr ValidationError
+	if errors.As(err, &verr) {
+		PrintWarn("REJECT", "%s %s: %s", key, r.RemoteAddr, verr.Message)
+		w.WriteHeader(verr.Status)
+		fmt.Fprintln(w, verr.Message)
+		return
+	} else if err == ErrPreconditionFailed {
+		PrintWarn("PRECONDITION", "%s %s: %s", key, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		fmt.Fprintln(w, err)
+		return
+	} else if errors.Is(err, ErrQuotaExceeded) {
+		PrintWarn("QUOTA", "%s %s: %s", key, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusInsufficientStorage)
+		fmt.Fprintln(w, err)
+		return
+	} else if err == ErrCircuitOpen {
+		s.unavailable(w)
+		return
+	} else if err != nil {
+		// ErrDigestMismatch also means that the source is broken, because it is read from the store.
+		PrintErr("ERROR", "copy %s#%d to %s: %s", src, rev, key, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	PrintImportant("PUBLISH", "%s#%d (copied from %s#%d)", key, published, src, rev)
+	putMeta.Revision = published
+	s.afterPublish(putMeta)
+
+	for _, msg := range warnings {
+		PrintWarn("QUOTA", "%s#%d %s: %s", key, published, r.RemoteAddr, msg)
+		w.Header().Add("Warning", "199 Artistore "+strconv.Quote(msg))
+	}
+
+	w.Header().Set("Location", s.pathTo(key, published))
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, "http://"+r.Host+s.pathTo(key, published))
+}
+
+// CopyRevision asks the server of u to publish srcKey#rev as a new revision of the key of u, without downloading and uploading the content.
+func (c *Client) CopyRevision(u *url.URL, token Token, srcKey string, rev int) (location string, err error) {
+	cu := *u
+	cu.RawQuery = url.Values{"copy-from": {srcKey}, "rev": {strconv.Itoa(rev)}}.Encode()
+
+	resp, err := c.Do(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", cu.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "bearer "+token.String())
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusCreated {
+		return "", HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	return strings.TrimSpace(string(msg)), nil
+}
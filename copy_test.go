@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCopyRevision(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	for _, body := range []string{"build 122", "build 123"} {
+		opts := PutOptions{Labels: map[string]string{"build": strings.TrimPrefix(body, "build "), "channel": "nightly"}}
+		if _, err := store.Put("nightly/foo.txt", strings.NewReader(body), opts); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	if _, err := store.Put("secret/foo.txt", strings.NewReader("secret"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewToken(sec, "release/")
+
+	s := Server{Secret: sec, Store: store, Private: []string{"secret/"}, Expectations: NewExpectationStore(), Uploads: NewUploadTracker()}
+	request := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, nil)
+		r.Header.Set("Authorization", "bearer "+token.String())
+		r.Header.Set(LabelHeader, "channel=stable")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		Path     string
+		Status   int
+		Location string
+	}{
+		{"/release/foo.txt?copy-from=nightly/foo.txt&rev=1", http.StatusCreated, "/release/foo.txt?rev=1"},
+		{"/release/foo.txt?copy-from=nightly/foo.txt", http.StatusCreated, "/release/foo.txt?rev=2"},
+		{"/release/foo.txt?copy-from=nightly/foo.txt&rev=latest", http.StatusCreated, "/release/foo.txt?rev=3"},
+		{"/release/foo.txt?copy-from=nightly/foo.txt&rev=9", http.StatusNotFound, ""},
+		{"/release/foo.txt?copy-from=nightly/foo.txt&rev=abc", http.StatusBadRequest, ""},
+		{"/release/foo.txt?copy-from=nightly/missing.txt", http.StatusNotFound, ""},
+		{"/release/foo.txt?copy-from=/nightly/foo.txt", http.StatusBadRequest, ""},
+		{"/release/foo.txt?copy-from=secret/foo.txt", http.StatusForbidden, ""},
+		{"/other/foo.txt?copy-from=nightly/foo.txt", http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		w := request(tt.Path)
+		if w.Code != tt.Status {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.Path, tt.Status, w.Code, w.Body)
+			continue
+		}
+		if loc := w.Header().Get("Location"); loc != tt.Location {
+			t.Errorf("%s: unexpected location: %q", tt.Path, loc)
+		}
+	}
+
+	f, meta, err := store.Get("release/foo.txt", 1)
+	if err != nil {
+		t.Fatalf("failed to get copied revision: %s", err)
+	}
+	defer f.Close()
+	body, _ := io.ReadAll(f)
+	if string(body) != "build 122" {
+		t.Errorf("unexpected content: %q", body)
+	}
+	if !reflect.DeepEqual(meta.Labels, map[string]string{"build": "122", "channel": "stable"}) {
+		t.Errorf("unexpected labels: %v", meta.Labels)
+	}
+
+	src, _ := store.Metadata("nightly/foo.txt", 1)
+	if meta.Type != src.Type || meta.Hash != src.Hash {
+		t.Errorf("unexpected metadata: %#v", meta)
+	}
+
+	if latest, _ := store.Latest("release/foo.txt"); latest != 3 {
+		t.Errorf("unexpected latest revision: %d", latest)
+	}
+}
+
+func TestCopyRevisionScope(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	if _, err := store.Put("secret/foo.txt", strings.NewReader("secret"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	s := Server{Secret: sec, Store: store, Private: []string{"secret/"}, Expectations: NewExpectationStore(), Uploads: NewUploadTracker()}
+
+	v1, _ := NewToken(sec, "secret/")
+	publish, _ := NewScopedToken(sec, "secret/", ScopePublish)
+	read, _ := NewScopedToken(sec, "secret/", ScopeRead)
+	both, _ := NewScopedToken(sec, "secret/", ScopePublish|ScopeRead)
+	other, _ := NewScopedToken(sec, "other/", ScopePublish|ScopeRead)
+
+	tests := []struct {
+		Name   string
+		Token  Token
+		Status int
+	}{
+		{"none", nil, http.StatusForbidden},
+		{"v1", v1, http.StatusForbidden},
+		{"publish", publish, http.StatusForbidden},
+		{"read", read, http.StatusForbidden},
+		{"other prefix", other, http.StatusForbidden},
+		{"publish and read", both, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/secret/copy.txt?copy-from=secret/foo.txt", nil)
+		if tt.Token != nil {
+			r.Header.Set("Authorization", "bearer "+tt.Token.String())
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != tt.Status {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.Name, tt.Status, w.Code, w.Body)
+		}
+	}
+
+	if latest, _ := store.Latest("secret/copy.txt"); latest != 1 {
+		t.Errorf("unexpected latest revision: %d", latest)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var cpCmd = &cobra.Command{
+	Use:   "cp SRC_URL DST_URL",
+	Short: "Copy an artifact from a server to another server",
+	Long: `Copy an artifact from a server to another server.
+
+By default, only the latest revision is copied.
+Revision numbers in the destination server are not kept, because the destination server assigns new revisions.
+
+The token or secret is required only for the destination server.
+
+With --server-side, the server copies the revisions without downloading and uploading them.
+It requires both URLs on the same server, and the token has to be allowed to read the source if it is under --private prefixes of the server.`,
+	Example: `  # Promote the latest library.js from staging to production.
+  $ artistore cp http://staging:3000/library.js https://artifacts.example.com/library.js
+
+  # Promote a nightly build to a release in the same server.
+  $ artistore cp --server-side -r 123 https://artifacts.example.com/nightly/foo.zip https://artifacts.example.com/releases/foo.zip
+
+  # Copy all revisions.
+  $ artistore cp --all http://staging:3000/library.js http://backup:3000/library.js`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		src, srcKey, err := ParseArtifactURL(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		dst, dstKey, err := ParseArtifactURL(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		serverSide, _ := cmd.Flags().GetBool("server-side")
+		if serverSide && (src.Scheme != dst.Scheme || src.Host != dst.Host) {
+			fmt.Fprintln(os.Stderr, "--server-side requires SRC_URL and DST_URL on the same server.")
+			os.Exit(2)
+		}
+
+		t, err := NewTokenHandler()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		token, err := t.TokenFor(dstKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		var revs []int
+		if rev, _ := cmd.Flags().GetInt("revision"); rev > 0 {
+			revs = []int{rev}
+		} else {
+			list, err := FetchRevisions(client, src, srcKey)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to fetch revisions:", err)
+				os.Exit(1)
+			}
+			if len(list.Revisions) == 0 {
+				fmt.Fprintln(os.Stderr, "No revision to copy.")
+				os.Exit(1)
+			}
+
+			if all, _ := cmd.Flags().GetBool("all"); all {
+				for _, r := range list.Revisions {
+					revs = append(revs, r.Revision)
+				}
+			} else {
+				revs = []int{list.Revisions[len(list.Revisions)-1].Revision}
+			}
+		}
+
+		u, err := dst.Parse("/" + dstKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		for _, rev := range revs {
+			var location string
+			if serverSide {
+				location, err = client.CopyRevision(u, token, srcKey, rev)
+			} else {
+				location, err = CopyArtifact(client, src, srcKey, rev, u, token)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s#%d: %s\n", srcKey, rev, strings.TrimSpace(err.Error()))
+				os.Exit(1)
+			}
+			fmt.Printf("%s#%d -> %s\n", srcKey, rev, location)
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(cpCmd)
+
+	cpCmd.Flags().IntP("revision", "r", 0, "Revision to copy. (default latest)")
+	cpCmd.Flags().Bool("all", false, "Copy all revisions.")
+	cpCmd.Flags().Bool("server-side", false, "Let the server copy revisions without transferring them. Both URLs have to be on the same server.")
+	cpCmd.Flags().String("secret", "", "Secret of the destination server. See also 'artistore help secret'.")
+	cpCmd.Flags().String("token", "", "Client token for the destination server. See also 'artistore help token'.")
+
+	addRetryFlags(cpCmd)
+}
+
+func ParseArtifactURL(raw string) (server *url.URL, key string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("Invalid URL: %s", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, "", fmt.Errorf("Invalid URL: %s: URL should be like http://example.com/key", raw)
+	}
+
+	key = strings.TrimPrefix(u.Path, "/")
+	if err := VerifyKey(key); err != nil {
+		return nil, "", err
+	}
+
+	return &url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host, Path: "/"}, key, nil
+}
+
+func FetchRevisions(client *Client, server *url.URL, key string) (RevisionList, error) {
+	var list RevisionList
+
+	u, err := server.Parse("/" + APIPrefix + "v1/revisions/" + key)
+	if err != nil {
+		return list, err
+	}
+
+	err = client.CallAPI("GET", u, nil, nil, &list)
+	return list, err
+}
+
+func CopyArtifact(client *Client, src *url.URL, srcKey string, rev int, dst *url.URL, token Token) (location string, err error) {
+	u, err := src.Parse("/" + srcKey + "?rev=" + strconv.Itoa(rev))
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return "", HTTPError{resp.StatusCode, string(msg)}
+	}
+
+	return client.PostArtifact(dst, token, resp.Body)
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon --stdio",
+	Short: "Serve client operations over JSON-RPC",
+	Long: `Serve client operations over JSON-RPC 2.0.
+
+This is for editor plugins and build tools, to use Artistore without spawning a process per operation.
+Each request and response is a JSON object in a line on stdin and stdout.
+
+Methods:
+  publish {"key": KEY, "file": PATH}                       => {"location": URL}
+  publish {"key": KEY, "data": BASE64}                     => {"location": URL}
+  get     {"key": KEY, "revision": REV, "output": PATH}    => {"key": KEY, "revision": REV, "size": SIZE}
+  get     {"key": KEY, "revision": REV}                    => {"key": KEY, "revision": REV, "size": SIZE, "data": BASE64}
+  list    {"prefix": PREFIX}                               => {"keys": [KEY...]}
+  stat    {"key": KEY, "revision": REV}                    => metadata of the revision
+
+"revision" is optional and defaults to the latest revision.`,
+	Example: `  $ echo '{"jsonrpc": "2.0", "id": 1, "method": "list", "params": {"prefix": "library/"}}' | artistore daemon --stdio`,
+	Args:    cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if stdio, _ := cmd.Flags().GetBool("stdio"); !stdio {
+			fmt.Fprintln(os.Stderr, "--stdio is required. Other transports are not supported yet.")
+			os.Exit(2)
+		}
+
+		server, err := getServerURL("")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		d := &Daemon{Server: server, Client: client}
+		d.Tokens, d.TokensErr = NewTokenHandler()
+
+		if err := d.Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().Bool("stdio", false, "Use stdin and stdout for JSON-RPC.")
+
+	daemonCmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	viper.BindPFlag("server", daemonCmd.Flags().Lookup("server"))
+
+	daemonCmd.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+	viper.BindPFlag("secret", daemonCmd.Flags().Lookup("secret"))
+
+	daemonCmd.Flags().String("token", "", "Client token. See also 'artistore help token'.")
+	viper.BindPFlag("token", daemonCmd.Flags().Lookup("token"))
+
+	addRetryFlags(daemonCmd)
+}
+
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+type rpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is an error object of JSON-RPC.
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+func rpcError(code int, err error) *RPCError {
+	e := &RPCError{Code: code, Message: err.Error()}
+
+	var herr HTTPError
+	if errors.As(err, &herr) {
+		e.Data = map[string]int{"status": herr.StatusCode}
+	}
+
+	return e
+}
+
+// Daemon serves client operations over JSON-RPC.
+type Daemon struct {
+	Server *url.URL
+	Client *Client
+
+	// Tokens is used for publish. TokensErr is reported when publish is called without token or secret.
+	Tokens    TokenHandler
+	TokensErr error
+
+	lock sync.Mutex
+}
+
+// Serve reads requests from r until EOF, and writes responses to w.
+// Requests are handled concurrently, so responses can be out of order.
+func (d *Daemon) Serve(r io.Reader, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	write := func(resp rpcResponse) {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		enc.Encode(resp)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var req rpcRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			write(rpcResponse{Version: "2.0", ID: json.RawMessage("null"), Error: rpcError(rpcParseError, err)})
+			continue
+		}
+		if req.Version != "2.0" || req.Method == "" {
+			id := req.ID
+			if id == nil {
+				id = json.RawMessage("null")
+			}
+			write(rpcResponse{Version: "2.0", ID: id, Error: &RPCError{Code: rpcInvalidRequest, Message: "Invalid JSON-RPC 2.0 request."}})
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result, err := d.Call(req.Method, req.Params)
+			if req.ID == nil {
+				return // Notification does not need response.
+			}
+
+			resp := rpcResponse{Version: "2.0", ID: req.ID, Result: result}
+			if err != nil {
+				rerr, ok := err.(*RPCError)
+				if !ok {
+					rerr = rpcError(rpcServerError, err)
+				}
+				resp.Result = nil
+				resp.Error = rerr
+			}
+			write(resp)
+		}()
+	}
+
+	return scanner.Err()
+}
+
+// Call handles a method call.
+func (d *Daemon) Call(method string, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Key      string `json:"key"`
+		Revision int    `json:"revision"`
+		File     string `json:"file"`
+		Data     []byte `json:"data"`
+		Output   string `json:"output"`
+		Prefix   string `json:"prefix"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, rpcError(rpcInvalidParams, err)
+		}
+	}
+
+	switch method {
+	case "publish":
+		return d.publish(p.Key, p.File, p.Data)
+	case "get":
+		return d.get(p.Key, p.Revision, p.Output)
+	case "list":
+		return d.list(p.Prefix)
+	case "stat":
+		return d.stat(p.Key, p.Revision)
+	default:
+		return nil, &RPCError{Code: rpcMethodNotFound, Message: fmt.Sprintf("No such method: %s", method)}
+	}
+}
+
+func (d *Daemon) keyURL(key string, revision int) (*url.URL, error) {
+	if err := VerifyKey(key); err != nil {
+		return nil, rpcError(rpcInvalidParams, err)
+	}
+
+	u, err := d.Server.Parse("/" + key)
+	if err != nil {
+		return nil, err
+	}
+	if revision > 0 {
+		u.RawQuery = "rev=" + strconv.Itoa(revision)
+	}
+	return u, nil
+}
+
+func (d *Daemon) publish(key, file string, data []byte) (interface{}, error) {
+	if key == "" {
+		key = file
+	}
+	u, err := d.keyURL(key, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.TokensErr != nil {
+		return nil, d.TokensErr
+	}
+	token, err := d.Tokens.TokenFor(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.ReadSeeker
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		body = f
+	} else if data != nil {
+		body = bytes.NewReader(data)
+	} else {
+		return nil, &RPCError{Code: rpcInvalidParams, Message: "Either file or data is required."}
+	}
+
+	location, err := d.Client.PostArtifact(u, token, body)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"location": location}, nil
+}
+
+type getResult struct {
+	Key      string `json:"key"`
+	Revision int    `json:"revision"`
+	Size     int64  `json:"size"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+func (d *Daemon) get(key string, revision int, output string) (interface{}, error) {
+	u, err := d.keyURL(key, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.Client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, HTTPError{resp.StatusCode, string(msg)}
+	}
+
+	result := getResult{Key: key}
+	result.Revision, _ = strconv.Atoi(resp.Header.Get("X-Artistore-Revision"))
+
+	if output == "" {
+		result.Data, err = io.ReadAll(resp.Body)
+		result.Size = int64(len(result.Data))
+		return result, err
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result.Size, err = io.Copy(f, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return result, f.Close()
+}
+
+func (d *Daemon) list(prefix string) (interface{}, error) {
+	u, err := d.Server.Parse("/" + APIPrefix + "v1/keys")
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = url.Values{"prefix": {prefix}}.Encode()
+
+	var list KeyList
+	err = d.Client.CallAPI("GET", u, nil, nil, &list)
+	return list, err
+}
+
+func (d *Daemon) stat(key string, revision int) (interface{}, error) {
+	if err := VerifyKey(key); err != nil {
+		return nil, rpcError(rpcInvalidParams, err)
+	}
+
+	list, err := FetchRevisions(d.Client, d.Server, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if revision <= 0 {
+		revision = list.Latest
+	}
+	for _, info := range list.Revisions {
+		if info.Revision == revision {
+			return info, nil
+		}
+	}
+	if revision < list.Latest {
+		return nil, HTTPError{http.StatusGone, ErrRevisionDeleted.Error()}
+	}
+	return nil, HTTPError{http.StatusNotFound, ErrNoSuchArtifact.Error()}
+}
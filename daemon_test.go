@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDaemon(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+
+	ts := httptest.NewServer(Server{
+		Secret:       sec,
+		Store:        &LocalStore{Path: t.TempDir()},
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+	})
+	defer ts.Close()
+
+	server, _ := url.Parse(ts.URL)
+	client, _ := NewClient()
+	d := &Daemon{Server: server, Client: client, Tokens: TokenHandler{Secret: sec}}
+
+	call := func(input string) map[string]map[string]interface{} {
+		t.Helper()
+
+		var out strings.Builder
+		if err := d.Serve(strings.NewReader(input), &out); err != nil {
+			t.Fatalf("failed to serve: %s", err)
+		}
+
+		responses := make(map[string]map[string]interface{})
+		scanner := bufio.NewScanner(strings.NewReader(out.String()))
+		for scanner.Scan() {
+			var resp map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to parse response: %s: %s", err, scanner.Text())
+			}
+			id, _ := json.Marshal(resp["id"])
+			responses[string(id)] = resp
+		}
+		return responses
+	}
+
+	resp := call(`{"jsonrpc": "2.0", "id": 1, "method": "publish", "params": {"key": "hello.txt", "data": "aGVsbG8gd29ybGQ="}}
+{"jsonrpc": "2.0", "id": 2, "method": "publish", "params": {"key": "world.txt", "data": "d29ybGQ="}}
+`)
+	for _, id := range []string{"1", "2"} {
+		if result, ok := resp[id]["result"].(map[string]interface{}); !ok || result["location"] == "" {
+			t.Errorf("failed to publish: %v", resp[id])
+		}
+	}
+
+	resp = call(`{"jsonrpc": "2.0", "id": "list", "method": "list", "params": {"prefix": "hello"}}
+{"jsonrpc": "2.0", "id": "stat", "method": "stat", "params": {"key": "hello.txt"}}
+{"jsonrpc": "2.0", "id": "get", "method": "get", "params": {"key": "hello.txt"}}
+{"jsonrpc": "2.0", "id": "missing", "method": "get", "params": {"key": "missing.txt"}}
+{"jsonrpc": "2.0", "id": "unknown", "method": "unknown"}
+{"jsonrpc": "2.0", "method": "list"}
+{broken
+`)
+
+	if len(resp) != 6 {
+		t.Errorf("expected 6 responses but got %d: %v", len(resp), resp)
+	}
+
+	if keys := resp[`"list"`]["result"].(map[string]interface{})["keys"].([]interface{}); len(keys) != 1 || keys[0] != "hello.txt" {
+		t.Errorf("unexpected list result: %v", resp[`"list"`])
+	}
+
+	if stat := resp[`"stat"`]["result"].(map[string]interface{}); stat["revision"] != 1.0 || stat["size"] != 11.0 {
+		t.Errorf("unexpected stat result: %v", stat)
+	}
+
+	if get := resp[`"get"`]["result"].(map[string]interface{}); get["data"] != "aGVsbG8gd29ybGQ=" || get["revision"] != 1.0 {
+		t.Errorf("unexpected get result: %v", get)
+	}
+
+	errorCode := func(id string) interface{} {
+		if e, ok := resp[id]["error"].(map[string]interface{}); ok {
+			return e["code"]
+		}
+		return nil
+	}
+	if code := errorCode(`"missing"`); code != float64(rpcServerError) {
+		t.Errorf("expected server error for missing artifact but got %v", resp[`"missing"`])
+	}
+	if code := errorCode(`"unknown"`); code != float64(rpcMethodNotFound) {
+		t.Errorf("expected method not found but got %v", resp[`"unknown"`])
+	}
+	if code := errorCode("null"); code != float64(rpcParseError) {
+		t.Errorf("expected parse error but got %v", resp["null"])
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DebugHeader = "X-Artistore-Debug"
+
+// Trace annotates the response with DebugHeader to explain why the server responded so.
+// All methods are no-op if Trace is nil, so that callers do not have to check whether debug mode is enabled.
+type Trace struct {
+	header http.Header
+}
+
+// debugTrace returns Trace if the request has "?debug=1" and an admin token.
+// The second value will be false if the request wants debug mode but the authorization failed.
+func (s Server) debugTrace(w http.ResponseWriter, r *http.Request) (*Trace, bool) {
+	if r.URL.Query().Get("debug") != "1" {
+		return nil, true
+	}
+	if !s.authorize(APIPrefix, ScopeAdmin, w, r) {
+		return nil, false
+	}
+	return &Trace{w.Header()}, true
+}
+
+func (t *Trace) Printf(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	t.header.Add(DebugHeader, fmt.Sprintf(format, args...))
+}
+
+func (t *Trace) Enabled() bool {
+	return t != nil
+}
+
+type etagMatcher func(a, b string) bool
+
+func strongETagMatch(a, b string) bool {
+	return a == b && a != "" && !strings.HasPrefix(a, "W/")
+}
+
+func weakETagMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// matchETag checks if the etag is listed in a If-Match or If-None-Match header value.
+func matchETag(header, etag string, match etagMatcher) (matched string, ok bool) {
+	for _, x := range strings.Split(header, ",") {
+		x = strings.TrimSpace(x)
+		if x == "*" || match(x, etag) {
+			return x, true
+		}
+	}
+	return "", false
+}
+
+func modifiedSince(modtime time.Time, header string) (modified bool, ok bool) {
+	t, err := http.ParseTime(header)
+	if err != nil {
+		return false, false
+	}
+	return modtime.Truncate(time.Second).After(t), true
+}
+
+// Conditional explains how http.ServeContent handles conditional and range headers.
+// It does not change the response, only writes the decisions.
+func (t *Trace) Conditional(r *http.Request, etag string, modtime time.Time) {
+	if t == nil {
+		return
+	}
+
+	if r.Method == "HEAD" {
+		t.Printf("HEAD request: conditional and range headers are not evaluated; 200 OK")
+		return
+	}
+
+	if v := r.Header.Get("If-Match"); v != "" {
+		if m, ok := matchETag(v, etag, strongETagMatch); ok {
+			t.Printf("If-Match: %s matched Etag %s", m, etag)
+		} else {
+			t.Printf("If-Match: %s did not match Etag %s; 412 Precondition Failed", v, etag)
+			return
+		}
+	} else if v := r.Header.Get("If-Unmodified-Since"); v != "" {
+		if modified, ok := modifiedSince(modtime, v); !ok {
+			t.Printf("If-Unmodified-Since: %s is invalid; ignored", v)
+		} else if modified {
+			t.Printf("If-Unmodified-Since: modified at %s; 412 Precondition Failed", modtime.UTC().Format(http.TimeFormat))
+			return
+		} else {
+			t.Printf("If-Unmodified-Since: not modified since %s", v)
+		}
+	}
+
+	if v := r.Header.Get("If-None-Match"); v != "" {
+		if m, ok := matchETag(v, etag, weakETagMatch); ok {
+			t.Printf("If-None-Match: %s matched Etag %s; 304 Not Modified", m, etag)
+			return
+		}
+		t.Printf("If-None-Match: %s did not match Etag %s", v, etag)
+	} else if v := r.Header.Get("If-Modified-Since"); v != "" {
+		if modified, ok := modifiedSince(modtime, v); !ok {
+			t.Printf("If-Modified-Since: %s is invalid; ignored", v)
+		} else if !modified {
+			t.Printf("If-Modified-Since: not modified since %s; 304 Not Modified", v)
+			return
+		} else {
+			t.Printf("If-Modified-Since: modified at %s", modtime.UTC().Format(http.TimeFormat))
+		}
+	}
+
+	rng := r.Header.Get("Range")
+	if rng == "" {
+		t.Printf("no Range header; 200 OK with full content")
+		return
+	}
+
+	if v := r.Header.Get("If-Range"); v != "" {
+		if strings.HasPrefix(v, `"`) || strings.HasPrefix(v, "W/") {
+			if !strongETagMatch(v, etag) {
+				t.Printf("If-Range: %s did not match Etag %s; Range ignored, 200 OK with full content", v, etag)
+				return
+			}
+			t.Printf("If-Range: %s matched Etag %s", v, etag)
+		} else if it, err := http.ParseTime(v); err != nil || !it.Equal(modtime.Truncate(time.Second)) {
+			t.Printf("If-Range: %s did not match Last-Modified; Range ignored, 200 OK with full content", v)
+			return
+		} else {
+			t.Printf("If-Range: %s matched Last-Modified", v)
+		}
+	}
+
+	t.Printf("Range: %s; 206 Partial Content if satisfiable, otherwise 416 Range Not Satisfiable", rng)
+}
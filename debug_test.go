@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceConditional(t *testing.T) {
+	etag := `"5eb63bbbe01eeed093cb22bb8f5acdc3"`
+	modtime := time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC)
+	before := modtime.Add(-time.Hour).Format(http.TimeFormat)
+	after := modtime.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		Header map[string]string
+		Status int
+	}{
+		{map[string]string{}, http.StatusOK},
+		{map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{map[string]string{"If-None-Match": `W/` + etag}, http.StatusNotModified},
+		{map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{map[string]string{"If-Modified-Since": after}, http.StatusNotModified},
+		{map[string]string{"If-Modified-Since": before}, http.StatusOK},
+		{map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed},
+		{map[string]string{"If-Match": etag, "If-None-Match": etag}, http.StatusNotModified},
+		{map[string]string{"If-Unmodified-Since": before}, http.StatusPreconditionFailed},
+		{map[string]string{"Range": "bytes=0-4"}, http.StatusPartialContent},
+		{map[string]string{"Range": "bytes=0-4", "If-Range": etag}, http.StatusPartialContent},
+		{map[string]string{"Range": "bytes=0-4", "If-Range": `"other"`}, http.StatusOK},
+		{map[string]string{"Range": "bytes=0-4", "If-Range": before}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/hello.txt", nil)
+		for k, v := range tt.Header {
+			r.Header.Set(k, v)
+		}
+
+		w := httptest.NewRecorder()
+		w.Header().Set("Etag", etag)
+
+		trace := &Trace{w.Header()}
+		trace.Conditional(r, etag, modtime)
+		http.ServeContent(w, r, "hello.txt", modtime, strings.NewReader("hello world"))
+
+		if w.Code != tt.Status {
+			t.Errorf("%v: expected status %d but got %d", tt.Header, tt.Status, w.Code)
+		}
+
+		lines := w.Header().Values(DebugHeader)
+		if len(lines) == 0 {
+			t.Errorf("%v: no debug header", tt.Header)
+			continue
+		}
+		if last := lines[len(lines)-1]; !strings.Contains(last, strconv.Itoa(tt.Status)) {
+			t.Errorf("%v: debug header does not explain status %d: %q", tt.Header, tt.Status, lines)
+		}
+	}
+}
+
+func TestTraceNil(t *testing.T) {
+	var trace *Trace
+	trace.Printf("hello %s", "world")
+	trace.Conditional(httptest.NewRequest("GET", "/", nil), `"etag"`, time.Now())
+
+	if trace.Enabled() {
+		t.Errorf("nil trace should be disabled")
+	}
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidDelta     = errors.New("Invalid delta.")
+	ErrNoSuchDeltaBase  = errors.New("The base revision of the delta does not exist. Please upload the whole file.")
+	ErrInvalidBlockSize = fmt.Errorf("Invalid block size: it should be between %d and %d.", MinDeltaBlockSize, MaxDeltaBlockSize)
+)
+
+// DeltaType is the content type of a delta upload, that is the difference from a revision on the server.
+const DeltaType = "application/vnd.artistore.delta"
+
+const (
+	DefaultDeltaBlockSize = 64 * 1024
+	MinDeltaBlockSize     = 512
+	MaxDeltaBlockSize     = 16 * 1024 * 1024
+
+	// MaxDeltaBlocks limits the number of blocks in a signature. The block size is enlarged for huge artifacts.
+	MaxDeltaBlocks = 64 * 1024
+
+	// maxDeltaLiteral is the maximum length of a literal in a delta, to limit the memory to make a delta.
+	maxDeltaLiteral = 1024 * 1024
+)
+
+// deltaMagic is the header of delta. It is followed by the block size in uvarint, and the operations:
+//
+//	'C' uvarint(block) uvarint(count)   copy count blocks from the block of the base revision.
+//	'L' uvarint(length) bytes           literal bytes.
+//	'E'                                 end of the delta.
+const deltaMagic = "ARTISTORE-DELTA1"
+
+// rollsum is the rolling checksum of rsync.
+type rollsum struct {
+	a, b uint32
+	n    uint32
+}
+
+func newRollsum(p []byte) rollsum {
+	var r rollsum
+	r.n = uint32(len(p))
+	for i, c := range p {
+		r.a += uint32(c)
+		r.b += uint32(len(p)-i) * uint32(c)
+	}
+	return r
+}
+
+// roll removes out from the head of the window, and adds in to the tail.
+func (r *rollsum) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+func (r rollsum) Sum() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+type BlockSignature struct {
+	Weak   uint32 `json:"weak"`
+	Strong string `json:"strong"`
+}
+
+// DeltaSignature is checksums of blocks of a revision, to make a delta from it on the client side.
+type DeltaSignature struct {
+	Key       string           `json:"key"`
+	Revision  int              `json:"revision"`
+	Size      int64            `json:"size"`
+	BlockSize int              `json:"block_size"`
+	Blocks    []BlockSignature `json:"blocks"`
+}
+
+// deltaBlockSize returns the block size for the content size, so that the number of blocks does not exceed MaxDeltaBlocks.
+func deltaBlockSize(size int64) int {
+	bs := DefaultDeltaBlockSize
+	for size/int64(bs) >= MaxDeltaBlocks && bs < MaxDeltaBlockSize {
+		bs *= 2
+	}
+	return bs
+}
+
+func strongBlockSum(p []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(p))
+}
+
+// ComputeBlockSignatures calculates checksums of each block of r.
+func ComputeBlockSignatures(r io.Reader, blockSize int) ([]BlockSignature, error) {
+	blocks := []BlockSignature{}
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			blocks = append(blocks, BlockSignature{newRollsum(buf[:n]).Sum(), strongBlockSum(buf[:n])})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return blocks, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+type DeltaStats struct {
+	Literal int64
+	Copied  int64
+	Size    int64
+}
+
+type deltaWriter struct {
+	w     *bufio.Writer
+	stats DeltaStats
+
+	copying    bool
+	copyStart  uint64
+	copyCount  uint64
+	copyLength int64
+}
+
+func (d *deltaWriter) write(p []byte) {
+	d.w.Write(p)
+	d.stats.Size += int64(len(p))
+}
+
+func (d *deltaWriter) op(code byte, args ...uint64) {
+	buf := []byte{code}
+	for _, x := range args {
+		buf = appendUvarint(buf, x)
+	}
+	d.write(buf)
+}
+
+// appendUvarint is binary.AppendUvarint, that is not available before Go 1.19.
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
+
+func (d *deltaWriter) flushCopy() {
+	if d.copying {
+		d.op('C', d.copyStart, d.copyCount)
+		d.stats.Copied += d.copyLength
+		d.copying = false
+	}
+}
+
+func (d *deltaWriter) copy(block uint64, length int) {
+	if d.copying && d.copyStart+d.copyCount == block {
+		d.copyCount++
+		d.copyLength += int64(length)
+		return
+	}
+	d.flushCopy()
+	d.copying, d.copyStart, d.copyCount, d.copyLength = true, block, 1, int64(length)
+}
+
+func (d *deltaWriter) literal(p []byte) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxDeltaLiteral {
+			chunk = chunk[:maxDeltaLiteral]
+		}
+		p = p[len(chunk):]
+
+		d.flushCopy()
+		d.op('L', uint64(len(chunk)))
+		d.write(chunk)
+		d.stats.Literal += int64(len(chunk))
+	}
+}
+
+// WriteDelta writes the difference of r from the revision of sig into w.
+// It reads r only once, and uses memory about maxDeltaLiteral and the block size.
+func WriteDelta(sig DeltaSignature, r io.Reader, w io.Writer) (DeltaStats, error) {
+	bs := sig.BlockSize
+	if bs < MinDeltaBlockSize || bs > MaxDeltaBlockSize {
+		return DeltaStats{}, ErrInvalidBlockSize
+	}
+
+	// Only full blocks can be found by the rolling checksum. The last block is checked only at the end of r.
+	index := make(map[uint32][]int)
+	last := -1
+	for i, b := range sig.Blocks {
+		if int64(i+1)*int64(bs) <= sig.Size {
+			index[b.Weak] = append(index[b.Weak], i)
+		} else {
+			last = i
+		}
+	}
+
+	dw := &deltaWriter{w: bufio.NewWriter(w)}
+	dw.write([]byte(deltaMagic))
+	dw.write(appendUvarint(nil, uint64(bs)))
+
+	br := bufio.NewReader(r)
+
+	// buf[:start] is the pending literal, and buf[start:] is the window.
+	buf := make([]byte, 0, maxDeltaLiteral+bs+1)
+	start := 0
+
+	fill := func() error {
+		n, err := io.ReadFull(br, buf[len(buf):len(buf)+bs])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		return err
+	}
+
+	if err := fill(); err != nil {
+		return DeltaStats{}, err
+	}
+	sum := newRollsum(buf[start:])
+
+	for len(buf)-start == bs {
+		matched := -1
+		if candidates, ok := index[sum.Sum()]; ok {
+			strong := strongBlockSum(buf[start:])
+			for _, i := range candidates {
+				if sig.Blocks[i].Strong == strong {
+					matched = i
+					break
+				}
+			}
+		}
+
+		if matched >= 0 {
+			dw.literal(buf[:start])
+			dw.copy(uint64(matched), bs)
+			buf, start = buf[:0], 0
+			if err := fill(); err != nil {
+				return DeltaStats{}, err
+			}
+			sum = newRollsum(buf)
+			continue
+		}
+
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return DeltaStats{}, err
+		}
+		out := buf[start]
+		buf = append(buf, c)
+		start++
+		sum.roll(out, c)
+
+		if start >= maxDeltaLiteral {
+			dw.literal(buf[:start])
+			buf = buf[:copy(buf, buf[start:])]
+			start = 0
+		}
+	}
+
+	tail := buf[start:]
+	if last >= 0 && len(tail) > 0 && int64(len(tail)) == sig.Size-int64(last)*int64(bs) && newRollsum(tail).Sum() == sig.Blocks[last].Weak && strongBlockSum(tail) == sig.Blocks[last].Strong {
+		dw.literal(buf[:start])
+		dw.copy(uint64(last), len(tail))
+	} else {
+		dw.literal(buf)
+	}
+	dw.flushCopy()
+	dw.op('E')
+
+	return dw.stats, dw.w.Flush()
+}
+
+// deltaReader applies a delta to the base revision.
+type deltaReader struct {
+	base      io.ReadSeeker
+	baseSize  int64
+	blockSize int64
+	delta     *bufio.Reader
+
+	remain   int64
+	fromBase bool
+	done     bool
+}
+
+// NewDeltaReader returns a reader of the content that the delta is applied to the base.
+// Reading returns an error that wraps ErrInvalidDelta if the delta is broken.
+func NewDeltaReader(base io.ReadSeeker, baseSize int64, delta io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(delta)
+
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != deltaMagic {
+		return nil, ErrInvalidDelta
+	}
+	bs, err := binary.ReadUvarint(br)
+	if err != nil || bs < MinDeltaBlockSize || bs > MaxDeltaBlockSize {
+		return nil, ErrInvalidDelta
+	}
+
+	return &deltaReader{base: base, baseSize: baseSize, blockSize: int64(bs), delta: br}, nil
+}
+
+func (d *deltaReader) next() error {
+	op, err := d.delta.ReadByte()
+	if err != nil {
+		return fmt.Errorf("%w: unexpected end of delta", ErrInvalidDelta)
+	}
+
+	switch op {
+	case 'C':
+		block, err1 := binary.ReadUvarint(d.delta)
+		count, err2 := binary.ReadUvarint(d.delta)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("%w: broken copy operation", ErrInvalidDelta)
+		}
+		blocks := uint64((d.baseSize + d.blockSize - 1) / d.blockSize)
+		if count == 0 || block >= blocks || count > blocks-block {
+			return fmt.Errorf("%w: block %d+%d is out of the base revision", ErrInvalidDelta, block, count)
+		}
+
+		offset := int64(block) * d.blockSize
+		if _, err := d.base.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		d.remain = int64(count) * d.blockSize
+		if offset+d.remain > d.baseSize {
+			d.remain = d.baseSize - offset
+		}
+		d.fromBase = true
+	case 'L':
+		length, err := binary.ReadUvarint(d.delta)
+		if err != nil || length == 0 || length > maxDeltaLiteral {
+			return fmt.Errorf("%w: broken literal operation", ErrInvalidDelta)
+		}
+		d.remain = int64(length)
+		d.fromBase = false
+	case 'E':
+		d.done = true
+	default:
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidDelta, op)
+	}
+	return nil
+}
+
+func (d *deltaReader) Read(p []byte) (int, error) {
+	for d.remain == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > d.remain {
+		p = p[:d.remain]
+	}
+
+	var n int
+	var err error
+	if d.fromBase {
+		n, err = d.base.Read(p)
+	} else {
+		n, err = d.delta.Read(p)
+	}
+	d.remain -= int64(n)
+
+	if err == io.EOF {
+		if d.remain > 0 {
+			return n, fmt.Errorf("%w: unexpected end of data", ErrInvalidDelta)
+		}
+		err = nil
+	}
+	return n, err
+}
+
+// deltaBase opens the base revision of a delta upload by "POST /KEY?delta=REV", and returns the reader of the applied content.
+func (s Server) deltaBase(key string, w http.ResponseWriter, r *http.Request) (io.Reader, io.Closer, bool) {
+	rev, err := strconv.Atoi(r.URL.Query().Get("delta"))
+	if err != nil || rev <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Invalid base revision of delta.")
+		return nil, nil, false
+	}
+
+	f, meta, err := s.Store.Get(key, rev)
+	if err == ErrNoSuchArtifact || err == ErrRevisionDeleted {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(w, ErrNoSuchDeltaBase)
+		return nil, nil, false
+	} else if err == ErrCircuitOpen {
+		s.unavailable(w)
+		return nil, nil, false
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return nil, nil, false
+	}
+	if meta.Type == RedirectType {
+		f.Close()
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(w, ErrNoSuchDeltaBase)
+		return nil, nil, false
+	}
+
+	applied, err := NewDeltaReader(f, int64(meta.Size), r.Body)
+	if err != nil {
+		f.Close()
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return nil, nil, false
+	}
+
+	PrintLog("DELTA", "%s: apply delta to revision %d from %s", key, rev, r.RemoteAddr)
+	return applied, f, true
+}
+
+// Signature serves checksums of blocks of a revision for delta uploads, at "/_api/v1/signatures/KEY?rev=REV&block=SIZE".
+// The latest revision is used if rev is omitted.
+func (s Server) Signature(key string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+	if err := VerifyKey(key); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+	if !s.authorize(key, ScopePublish, w, r) {
+		return
+	}
+
+	var rev int
+	var err error
+	if r.URL.Query().Has("rev") {
+		rev, err = strconv.Atoi(r.URL.Query().Get("rev"))
+		if err != nil || rev <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid revision.")
+			return
+		}
+	} else {
+		rev, err = s.Store.Latest(key)
+	}
+
+	var meta Metadata
+	if err == nil {
+		meta, err = s.Store.Metadata(key, rev)
+	}
+	if err == nil && meta.Type == RedirectType {
+		err = ErrNoSuchArtifact
+	}
+	if err == ErrNoSuchArtifact || err == ErrRevisionDeleted {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, err)
+		return
+	} else if err == ErrCircuitOpen {
+		s.unavailable(w)
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	bs := deltaBlockSize(int64(meta.Size))
+	if r.URL.Query().Has("block") {
+		bs, err = strconv.Atoi(r.URL.Query().Get("block"))
+		if err != nil || bs < MinDeltaBlockSize || bs > MaxDeltaBlockSize {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, ErrInvalidBlockSize)
+			return
+		}
+	}
+
+	sig := DeltaSignature{Key: key, Revision: rev, Size: int64(meta.Size), BlockSize: bs}
+	err = s.HashPool.Do(HashForeground, func() error {
+		f, _, err := s.Store.Get(key, rev)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		sig.Blocks, err = ComputeBlockSignatures(f, bs)
+		return err
+	})
+	if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sig)
+}
+
+// publishDelta uploads f as a delta from the latest revision at u.
+// ok is false if the delta is not available or not smaller enough than f, then the caller should upload the whole file.
+func publishDelta(client *Client, token Token, u *url.URL, f *os.File, size int64, progress func(current, total int64)) (location string, ok bool, err error) {
+	api, err := u.Parse("/" + APIPrefix + "v1/signatures/" + strings.TrimLeft(u.Path, "/"))
+	if err != nil {
+		return "", false, err
+	}
+
+	var sig DeltaSignature
+	if err := client.CallAPI("GET", api, token, nil, &sig); err != nil {
+		var herr HTTPError
+		if !errors.As(err, &herr) || herr.StatusCode != http.StatusNotFound {
+			PrintWarn("DELTA", "%s: failed to get signature, upload whole file: %s", u.Path, err)
+		}
+		return "", false, nil
+	}
+
+	tmp, err := os.CreateTemp("", "artistore-delta-")
+	if err != nil {
+		return "", false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	stats, err := WriteDelta(sig, f, tmp)
+	if err != nil {
+		return "", false, err
+	}
+	if stats.Size >= size*9/10 {
+		PrintLog("DELTA", "%s: delta is %s of %s, upload whole file", u.Path, FormatSize(stats.Size), FormatSize(size))
+		return "", false, nil
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", false, err
+	}
+
+	du := *u
+	q := du.Query()
+	q.Set("delta", strconv.Itoa(sig.Revision))
+	du.RawQuery = q.Encode()
+
+	r := &ProgressRecorder{Upstream: tmp, Total: stats.Size, Report: progress}
+	location, err = client.postArtifact(&du, token, r, DeltaType)
+	var herr HTTPError
+	if errors.As(err, &herr) && herr.StatusCode == http.StatusConflict {
+		PrintWarn("DELTA", "%s: %s", u.Path, strings.TrimSpace(herr.Message))
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	PrintLog("DELTA", "%s: uploaded %s delta (%s copied from revision %d)", u.Path, FormatSize(stats.Size), FormatSize(stats.Copied), sig.Revision)
+	return location, true, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func applyDelta(t *testing.T, base, delta []byte) []byte {
+	t.Helper()
+	r, err := NewDeltaReader(bytes.NewReader(base), int64(len(base)), bytes.NewReader(delta))
+	if err != nil {
+		t.Fatalf("failed to read delta: %s", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to apply delta: %s", err)
+	}
+	return out
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		rnd.Read(b)
+		return b
+	}
+	join := func(xs ...[]byte) []byte {
+		return bytes.Join(xs, nil)
+	}
+
+	base := random(10*1024 + 100)
+
+	tests := []struct {
+		Name       string
+		New        []byte
+		MaxLiteral int64
+	}{
+		{"unchanged", base, 0},
+		{"append", join(base, random(300)), 100 + 300}, // the last partial block of base can not be reused in the middle.
+		{"insert", join(base[:3000], random(10), base[3000:]), 10 + 2*1024},
+		{"delete", join(base[:3000], base[3100:]), 2 * 1024},
+		{"replace", join(base[:5000], random(100), base[5100:]), 100 + 2*1024},
+		{"truncate", base[:4096], 0},
+		{"empty", []byte{}, 0},
+		{"different", random(5000), 5000},
+		{"huge literal", random(maxDeltaLiteral*2 + 100), maxDeltaLiteral*2 + 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			blocks, err := ComputeBlockSignatures(bytes.NewReader(base), 1024)
+			if err != nil {
+				t.Fatalf("failed to compute signature: %s", err)
+			}
+			sig := DeltaSignature{Size: int64(len(base)), BlockSize: 1024, Blocks: blocks}
+
+			var delta bytes.Buffer
+			stats, err := WriteDelta(sig, bytes.NewReader(tt.New), &delta)
+			if err != nil {
+				t.Fatalf("failed to make delta: %s", err)
+			}
+			if stats.Literal > tt.MaxLiteral {
+				t.Errorf("too large literal: %d > %d", stats.Literal, tt.MaxLiteral)
+			}
+			if stats.Literal+stats.Copied != int64(len(tt.New)) {
+				t.Errorf("unexpected stats: %#v", stats)
+			}
+
+			if out := applyDelta(t, base, delta.Bytes()); !bytes.Equal(out, tt.New) {
+				t.Errorf("applied content does not match: %d bytes != %d bytes", len(out), len(tt.New))
+			}
+		})
+	}
+}
+
+func TestDeltaRollsum(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	sum := newRollsum(data[:16])
+	for i := 16; i < len(data); i++ {
+		sum.roll(data[i-16], data[i])
+		if expected := newRollsum(data[i-15 : i+1]).Sum(); sum.Sum() != expected {
+			t.Fatalf("%d: rolling checksum %08x does not match to %08x", i, sum.Sum(), expected)
+		}
+	}
+}
+
+func TestInvalidDelta(t *testing.T) {
+	base := []byte(strings.Repeat("a", 2048))
+	header := deltaMagic + "\x80\x08" // block size 1024
+
+	tests := []struct {
+		Name  string
+		Delta string
+	}{
+		{"no end", header + "L\x01x"},
+		{"copy out of base", header + "C\x02\x01E"},
+		{"copy too many", header + "C\x01\x02E"},
+		{"short literal", header + "L\x05abc"},
+		{"unknown operation", header + "XE"},
+	}
+
+	for _, tt := range tests {
+		r, err := NewDeltaReader(bytes.NewReader(base), int64(len(base)), strings.NewReader(tt.Delta))
+		if err != nil {
+			t.Errorf("%s: failed to read header: %s", tt.Name, err)
+			continue
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Errorf("%s: broken delta should be rejected", tt.Name)
+		}
+	}
+
+	if _, err := NewDeltaReader(bytes.NewReader(base), int64(len(base)), strings.NewReader("hello world")); err != ErrInvalidDelta {
+		t.Errorf("delta without header should be rejected: %v", err)
+	}
+}
+
+func TestPublishDelta(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewToken(sec, "big.bin")
+
+	store := &LocalStore{Path: t.TempDir()}
+	s := Server{Secret: sec, Store: store, Expectations: NewExpectationStore(), Uploads: NewUploadTracker()}
+
+	var received int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			body, _ := io.ReadAll(r.Body)
+			received += int64(len(body))
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/big.bin")
+	c := &Client{HTTP: &http.Client{}, Retry: RetryPolicy{MaxAttempts: 1}}
+
+	rnd := rand.New(rand.NewSource(2))
+	first := make([]byte, 512*1024)
+	rnd.Read(first)
+	second := append(append([]byte{}, first...), []byte("appended")...)
+	copy(second[100000:], "modified")
+
+	path := filepath.Join(t.TempDir(), "big.bin")
+	publish := func(content []byte) (string, bool) {
+		os.WriteFile(path, content, 0644)
+		f, _ := os.Open(path)
+		defer f.Close()
+
+		loc, ok, err := publishDelta(c, token, u, f, int64(len(content)), func(current, total int64) {})
+		if err != nil {
+			t.Fatalf("failed to publish delta: %s", err)
+		}
+		return loc, ok
+	}
+
+	if _, ok, err := publishDelta(c, token, u, nil, 0, nil); ok || err != nil {
+		t.Fatalf("delta should not be used for a new key: %v", err)
+	}
+	if _, err := store.Put("big.bin", bytes.NewReader(first), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	received = 0
+	loc, ok := publish(second)
+	if !ok {
+		t.Fatalf("delta should be used")
+	}
+	if !strings.HasSuffix(loc, "?rev=2") {
+		t.Errorf("unexpected location: %s", loc)
+	}
+	if received > 100*1024 {
+		t.Errorf("too large upload: %d bytes", received)
+	}
+
+	f, meta, err := store.Get("big.bin", 2)
+	if err != nil {
+		t.Fatalf("failed to get published revision: %s", err)
+	}
+	defer f.Close()
+	got, _ := io.ReadAll(f)
+	d := NewDigester()
+	d.Write(second)
+	if !bytes.Equal(got, second) || meta.Hash != d.Hash() {
+		t.Errorf("published content does not match")
+	}
+
+	different := make([]byte, 512*1024)
+	rnd.Read(different)
+	if _, ok := publish(different); ok {
+		t.Errorf("delta should not be used if it is not small enough")
+	}
+
+	r := httptest.NewRequest("POST", "/big.bin?delta=9", strings.NewReader(deltaMagic+"\x80\x08E"))
+	r.Header.Set("Authorization", "bearer "+token.String())
+	r.Header.Set("Content-Type", DeltaType)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusConflict {
+		t.Errorf("delta from missing revision should be rejected: %d", w.Code)
+	}
+
+	r = httptest.NewRequest("POST", "/big.bin?delta=1", strings.NewReader(deltaMagic+"\x80\x08C\xff\x01E"))
+	r.Header.Set("Authorization", "bearer "+token.String())
+	r.Header.Set("Content-Type", DeltaType)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("broken delta should be rejected: %d: %s", w.Code, w.Body.String())
+	}
+}
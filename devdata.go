@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var devdataCmd = &cobra.Command{
+	Use:   "devdata",
+	Short: "Populate a store with synthetic artifacts",
+	Long: `Populate a store with synthetic artifacts.
+
+This command writes random artifacts directly into the data directory.
+It is intended for load-testing retention, listing, and so on. Do not use it against production store.`,
+	Example: `  $ artistore devdata --store ./testdata --keys 1000 --sizes 1k-50m`,
+	Args:    cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		keys, _ := cmd.Flags().GetInt("keys")
+		prefix, _ := cmd.Flags().GetString("prefix")
+		seed, _ := cmd.Flags().GetInt64("seed")
+
+		sizes, _ := cmd.Flags().GetString("sizes")
+		minSize, maxSize, err := ParseSizeRange(sizes)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if minSize <= 0 {
+			fmt.Fprintln(os.Stderr, "Invalid --sizes: sizes should be greater than 0.")
+			os.Exit(2)
+		}
+
+		revs, _ := cmd.Flags().GetInt("revisions")
+		if keys <= 0 || revs <= 0 {
+			fmt.Fprintln(os.Stderr, "Number of keys and revisions should be greater than 0.")
+			os.Exit(2)
+		}
+
+		store, err := NewLocalStore(viper.GetString("store"), RetainPolicy{})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		g := DevDataGenerator{
+			Store:        store,
+			Prefix:       prefix,
+			MinSize:      minSize,
+			MaxSize:      maxSize,
+			MaxRevisions: revs,
+			Rand:         rand.New(rand.NewSource(seed)),
+		}
+
+		PrintLog("INFO", "Generating %d keys into %s", keys, viper.GetString("store"))
+
+		total, err := g.Generate(keys)
+		if err != nil {
+			PrintErr("ERROR", "%s", err)
+			os.Exit(1)
+		}
+
+		PrintLog("INFO", "Generated %d keys, %s in total", keys, FormatSize(total))
+	},
+}
+
+func init() {
+	cmd.AddCommand(devdataCmd)
+
+	devdataCmd.Flags().String("store", "/var/lib/artistore", "Path to data directory.")
+	viper.BindPFlag("store", devdataCmd.Flags().Lookup("store"))
+
+	devdataCmd.Flags().Int("keys", 100, "Number of keys to generate.")
+	devdataCmd.Flags().String("sizes", "1k-1m", "Size or range of sizes of each artifact.")
+	devdataCmd.Flags().Int("revisions", 1, "Maximum number of revisions for each key.")
+	devdataCmd.Flags().String("prefix", "devdata/", "Prefix for generated keys.")
+	devdataCmd.Flags().Int64("seed", time.Now().UnixNano(), "Seed for random generator.")
+}
+
+type DevDataGenerator struct {
+	Store        Store
+	Prefix       string
+	MinSize      int64
+	MaxSize      int64
+	MaxRevisions int
+	Rand         *rand.Rand
+}
+
+var devdataExts = []string{".bin", ".txt", ".js", ".json", ".css", ".tar.gz"}
+
+func (g DevDataGenerator) size() int64 {
+	if g.MinSize == g.MaxSize {
+		return g.MinSize
+	}
+
+	// Use log-uniform distribution because real artifacts are mostly small and sometimes huge.
+	lo := math.Log(float64(g.MinSize + 1))
+	hi := math.Log(float64(g.MaxSize + 1))
+	return int64(math.Exp(lo+g.Rand.Float64()*(hi-lo))) - 1
+}
+
+func (g DevDataGenerator) Generate(keys int) (total int64, err error) {
+	for i := 0; i < keys; i++ {
+		ext := devdataExts[g.Rand.Intn(len(devdataExts))]
+		key := path.Join(g.Prefix, fmt.Sprintf("%04d/artifact-%08x%s", i/100, g.Rand.Uint32(), ext))
+
+		revs := 1 + g.Rand.Intn(g.MaxRevisions)
+		for j := 0; j < revs; j++ {
+			size := g.size()
+
+			var r io.Reader
+			if ext == ".bin" || ext == ".tar.gz" {
+				r = io.LimitReader(g.Rand, size)
+			} else {
+				r = io.LimitReader(&randomTextReader{g.Rand}, size)
+			}
+
+			if _, err := g.Store.Put(key, r, PutOptions{}); err != nil {
+				return total, fmt.Errorf("failed to put %s: %s", key, err)
+			}
+			total += size
+		}
+
+		if (i+1)%100 == 0 {
+			PrintLog("DEVDATA", "%d/%d keys", i+1, keys)
+		}
+	}
+
+	return total, nil
+}
+
+var devdataWords = []string{"artifact", "store", "hello", "world", "build", "release", "bundle", "library", "function", "return", "const", "value"}
+
+type randomTextReader struct {
+	rand *rand.Rand
+}
+
+func (r *randomTextReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		w := devdataWords[r.rand.Intn(len(devdataWords))] + " "
+		if r.rand.Intn(10) == 0 {
+			w += "\n"
+		}
+		n += copy(p[n:], w)
+	}
+	return n, nil
+}
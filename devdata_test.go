@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDevDataGeneratorSize(t *testing.T) {
+	tests := []struct {
+		Min int64
+		Max int64
+	}{
+		{1, 1},
+		{1024, 1024},
+		{1, 1 << 10},
+		{1 << 10, 50 << 20},
+	}
+
+	for _, tt := range tests {
+		g := DevDataGenerator{MinSize: tt.Min, MaxSize: tt.Max, Rand: rand.New(rand.NewSource(1))}
+		for i := 0; i < 1000; i++ {
+			if size := g.size(); size < tt.Min || size > tt.Max {
+				t.Fatalf("%d-%d: size out of range: %d", tt.Min, tt.Max, size)
+			}
+		}
+	}
+}
+
+func TestDevDataGeneratorGenerate(t *testing.T) {
+	generate := func() (*LocalStore, int64) {
+		store := &LocalStore{Path: t.TempDir()}
+		g := DevDataGenerator{
+			Store:        store,
+			Prefix:       "devdata/",
+			MinSize:      1,
+			MaxSize:      4 << 10,
+			MaxRevisions: 3,
+			Rand:         rand.New(rand.NewSource(42)),
+		}
+		total, err := g.Generate(20)
+		if err != nil {
+			t.Fatalf("failed to generate: %s", err)
+		}
+		return store, total
+	}
+
+	store, total := generate()
+
+	keys, err := store.List("")
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+	if len(keys) != 20 {
+		t.Fatalf("unexpected number of keys: %d", len(keys))
+	}
+
+	var sum int64
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "devdata/0000/artifact-") {
+			t.Errorf("unexpected key: %s", key)
+		}
+
+		revs, err := store.Revisions(key)
+		if err != nil {
+			t.Fatalf("%s: failed to get revisions: %s", key, err)
+		}
+		if len(revs) < 1 || len(revs) > 3 {
+			t.Errorf("%s: unexpected number of revisions: %d", key, len(revs))
+		}
+		for _, r := range revs {
+			sum += int64(r.Size)
+		}
+	}
+	if sum != total {
+		t.Errorf("total is %d but artifacts are %d bytes", total, sum)
+	}
+
+	// The same seed generates the same data set, so that load tests can be reproduced.
+	again, _ := generate()
+	if keys2, _ := again.List(""); !reflect.DeepEqual(keys, keys2) {
+		t.Errorf("different keys are generated by the same seed:\n%v\n%v", keys, keys2)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff KEY",
+	Short: "Show changes between revisions of an artifact",
+	Long: `Show changes between revisions of an artifact in the unified diff format.
+
+With two --rev flags, the changes from the first revision to the second revision are shown.
+With one --rev flag, the revision is compared with the latest revision.
+Without --rev, the previous revision is compared with the latest revision.
+
+Text artifacts are compared by the server if it supports. Otherwise, both revisions are downloaded and compared locally.
+Binary artifacts are only reported whether they differ.
+
+The exit status is 0 if the revisions are the same, 1 if they differ, and 2 if failed to compare.`,
+	Example: `  $ artistore diff config.yaml --rev 4 --rev 7
+  $ artistore diff config.yaml --rev 4
+  $ artistore diff config.yaml`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key := args[0]
+
+		revs, _ := cmd.Flags().GetIntSlice("rev")
+		if len(revs) > 2 {
+			fmt.Fprintln(os.Stderr, "--rev can be specified at most twice.")
+			os.Exit(2)
+		}
+		for _, rev := range revs {
+			if rev <= 0 {
+				fmt.Fprintf(os.Stderr, "Invalid --rev: %d\n", rev)
+				os.Exit(2)
+			}
+		}
+
+		context, _ := cmd.Flags().GetInt("context")
+		if context < 0 {
+			fmt.Fprintln(os.Stderr, "Invalid --context: it should be 0 or more.")
+			os.Exit(2)
+		}
+
+		u, err := GetURL(key)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		client.Context = interruptContext()
+
+		// Token is optional because only keys under --private prefixes of the server require it.
+		if t, err := NewTokenHandler(); err == nil {
+			client.ReadToken, err = t.ScopedTokenFor(key, ScopeRead)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		} else if err != ErrNoClientCredential {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		var a, b Revision
+		switch len(revs) {
+		case 2:
+			a, b = Revision{Number: revs[0]}, Revision{Number: revs[1]}
+		case 1:
+			a, b = Revision{Number: revs[0]}, Revision{}
+		default:
+			b.Number, err = LatestRevision(client, u)
+			if err == nil && b.Number <= 1 {
+				err = fmt.Errorf("%s has no previous revision.", key)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to fetch:", err)
+				os.Exit(2)
+			}
+			a = Revision{Number: b.Number - 1}
+		}
+
+		// Text artifacts are compared by the server to avoid downloading both revisions.
+		diff, err := FetchDiff(client, u, a.spec(), b.spec(), context)
+		if err == nil {
+			if diff == "" {
+				return
+			}
+			fmt.Print(diff)
+			os.Exit(1)
+		} else if err != ErrDiffNotAcceptable {
+			fmt.Fprintln(os.Stderr, "Failed to compare:", err)
+			os.Exit(2)
+		}
+
+		for _, r := range []*Revision{&a, &b} {
+			if *r, err = FetchRevision(client, u, *r); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to fetch:", err)
+				os.Exit(2)
+			}
+		}
+
+		nameA := fmt.Sprintf("%s#%d", key, a.Number)
+		nameB := fmt.Sprintf("%s#%d", key, b.Number)
+
+		if string(a.Content) == string(b.Content) {
+			return
+		}
+		if !IsText(a.Content) || !IsText(b.Content) {
+			fmt.Printf("Binary revisions %s and %s differ\n", nameA, nameB)
+		} else {
+			fmt.Print(UnifiedDiff(nameA, nameB, string(a.Content), string(b.Content), context))
+		}
+		os.Exit(1)
+	},
+}
+
+func init() {
+	cmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	viper.BindPFlag("server", diffCmd.Flags().Lookup("server"))
+
+	diffCmd.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+	diffCmd.Flags().String("token", "", "Client token with read scope, to compare artifacts under --private prefixes of the server. See also 'artistore help token'.")
+
+	diffCmd.Flags().IntSliceP("rev", "r", nil, "Revision to compare. Specify twice to compare two revisions. (default previous and latest)")
+	diffCmd.Flags().IntP("context", "U", 3, "Number of unchanged lines to show around changes.")
+
+	addTimeoutFlags(diffCmd)
+	addTLSFlags(diffCmd)
+	addRetryFlags(diffCmd)
+}
+
+// Revision is the content of a revision of an artifact.
+type Revision struct {
+	// Number is the revision number. It is 0 for the latest revision before fetching.
+	Number  int
+	Content []byte
+}
+
+func (r Revision) spec() string {
+	if r.Number == 0 {
+		return "latest"
+	}
+	return strconv.Itoa(r.Number)
+}
+
+// LatestRevision returns the latest revision number of the key at u without downloading it.
+func LatestRevision(client *Client, u *url.URL) (int, error) {
+	ru := *u
+	q := ru.Query()
+	q.Set("rev", "latest")
+	ru.RawQuery = q.Encode()
+
+	resp, err := client.Do(func() (*http.Request, error) {
+		return http.NewRequest("HEAD", ru.String(), nil)
+	})
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, HTTPError{resp.StatusCode, http.StatusText(resp.StatusCode)}
+	}
+	rev, err := strconv.Atoi(resp.Header.Get("X-Artistore-Revision"))
+	if err != nil {
+		return 0, errors.New("The server did not respond the revision number of the latest revision.")
+	}
+	return rev, nil
+}
+
+// FetchRevision downloads the content of the revision at u, the URL of the key.
+// The latest revision is downloaded if rev.Number is 0, and the number is filled by the response.
+func FetchRevision(client *Client, u *url.URL, rev Revision) (Revision, error) {
+	ru := *u
+	q := ru.Query()
+	if rev.Number > 0 {
+		q.Set("rev", strconv.Itoa(rev.Number))
+	} else {
+		q.Set("rev", "latest")
+	}
+	ru.RawQuery = q.Encode()
+
+	resp, err := client.Get(&ru)
+	if err != nil {
+		return rev, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return rev, HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	if resp.ContentLength > MaxTextDiffSize {
+		return rev, fmt.Errorf("The revision is too large to compare: it should be %s or smaller.", FormatSize(MaxTextDiffSize))
+	}
+
+	if rev.Number == 0 {
+		rev.Number, err = strconv.Atoi(resp.Header.Get("X-Artistore-Revision"))
+		if err != nil {
+			return rev, errors.New("The server did not respond the revision number of the latest revision.")
+		}
+	}
+
+	rev.Content, err = io.ReadAll(io.LimitReader(resp.Body, MaxTextDiffSize+1))
+	if err == nil && int64(len(rev.Content)) > MaxTextDiffSize {
+		err = fmt.Errorf("The revision is too large to compare: it should be %s or smaller.", FormatSize(MaxTextDiffSize))
+	}
+	return rev, err
+}
+
+// DiffContentType is the content type of responses of the diff API.
+const DiffContentType = "text/x-diff; charset=utf-8"
+
+// ErrDiffNotAcceptable means the server can not compare the revisions as text, so that the client has to download them.
+var ErrDiffNotAcceptable = errors.New("The server can not compare these revisions as text.")
+
+// parseDiffRange parses "4..7" of "?diff=4..7". Each side is a revision number or "latest".
+func (s Server) parseDiffRange(key, spec string) (from, to int, immutable bool, err error) {
+	xs := strings.SplitN(spec, "..", 2)
+	if len(xs) != 2 {
+		return 0, 0, false, errors.New("Invalid diff range: it should be like 4..7.")
+	}
+
+	immutable = true
+	revs := make([]int, 2)
+	for i, x := range xs {
+		if x == "latest" {
+			immutable = false
+			if revs[i], err = s.latest(key); err != nil {
+				return 0, 0, false, err
+			}
+		} else if revs[i], err = strconv.Atoi(x); err != nil || revs[i] <= 0 {
+			return 0, 0, false, errors.New("Invalid diff range: it should be like 4..7.")
+		}
+	}
+	return revs[0], revs[1], immutable, nil
+}
+
+// readDiffRevision reads the revision to compare as text.
+// It returns ErrDiffNotAcceptable if the revision is not a text or larger than MaxTextDiffSize.
+func (s Server) readDiffRevision(key string, rev int) (string, error) {
+	meta, err := s.Store.Metadata(key, rev)
+	if err != nil {
+		return "", err
+	}
+	if !isSearchableType(meta.Type) || int64(meta.Size) > MaxTextDiffSize {
+		return "", ErrDiffNotAcceptable
+	}
+
+	f, _, err := s.Store.Get(key, rev)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, MaxTextDiffSize+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > MaxTextDiffSize || !IsText(data) {
+		return "", ErrDiffNotAcceptable
+	}
+	return string(data), nil
+}
+
+// serveDiff responds the unified diff between two revisions for "GET /KEY?diff=4..7".
+// Clients can set the number of context lines by "?context=N".
+func (s Server) serveDiff(key string, w http.ResponseWriter, r *http.Request) {
+	context := 3
+	if c := r.URL.Query().Get("context"); c != "" {
+		var err error
+		if context, err = strconv.Atoi(c); err != nil || context < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid context: it should be 0 or more.")
+			return
+		}
+	}
+
+	from, to, immutable, err := s.parseDiffRange(key, r.URL.Query().Get("diff"))
+	if err == ErrNoSuchArtifact {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, err)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	texts := make([]string, 2)
+	for i, rev := range []int{from, to} {
+		texts[i], err = s.readDiffRevision(key, rev)
+		switch {
+		case err == ErrNoSuchArtifact:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "No such revision: %d\n", rev)
+			return
+		case err == ErrRevisionDeleted:
+			w.WriteHeader(http.StatusGone)
+			fmt.Fprintf(w, "Revision %d has been deleted.\n", rev)
+			return
+		case err == ErrDiffNotAcceptable:
+			w.WriteHeader(http.StatusNotAcceptable)
+			fmt.Fprintln(w, err)
+			return
+		case err == ErrCircuitOpen:
+			s.unavailable(w)
+			return
+		case err != nil:
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+	}
+
+	visibility := "public"
+	if s.isPrivate(key) {
+		visibility = "private"
+	}
+	if immutable {
+		w.Header().Set("Cache-Control", visibility+", max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", visibility+", no-cache")
+	}
+	w.Header().Set("Content-Type", DiffContentType)
+
+	diff := UnifiedDiff(fmt.Sprintf("%s#%d", key, from), fmt.Sprintf("%s#%d", key, to), texts[0], texts[1], context)
+	w.Header().Set("Content-Length", strconv.Itoa(len(diff)))
+	if r.Method != "HEAD" {
+		io.WriteString(w, diff)
+	}
+}
+
+// FetchDiff asks the server to compare two revisions of the key at u.
+// Revisions are a number or "latest". It returns ErrDiffNotAcceptable if the server can not compare them as text, including servers without the diff API.
+func FetchDiff(client *Client, u *url.URL, from, to string, context int) (string, error) {
+	du := *u
+	q := du.Query()
+	q.Set("diff", from+".."+to)
+	q.Set("context", strconv.Itoa(context))
+	du.RawQuery = q.Encode()
+
+	resp, err := client.Get(&du)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotAcceptable:
+		return "", ErrDiffNotAcceptable
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	case !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/x-diff"):
+		// Old servers ignore ?diff and respond the artifact itself.
+		return "", ErrDiffNotAcceptable
+	}
+
+	diff, err := io.ReadAll(resp.Body)
+	return string(diff), err
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestFetchRevision(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	for _, body := range []string{"first\n", "second\n"} {
+		if _, err := store.Put("hello.txt", strings.NewReader(body), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	ts := httptest.NewServer(Server{Store: store})
+	defer ts.Close()
+
+	viper.Set("server", ts.URL)
+	defer viper.Set("server", "")
+
+	client, _ := NewClient()
+	u, _ := GetURL("hello.txt")
+
+	latest, err := FetchRevision(client, u, Revision{})
+	if err != nil {
+		t.Fatalf("failed to fetch latest: %s", err)
+	}
+	if latest.Number != 2 || string(latest.Content) != "second\n" {
+		t.Errorf("unexpected latest revision: %d %q", latest.Number, latest.Content)
+	}
+
+	first, err := FetchRevision(client, u, Revision{Number: 1})
+	if err != nil {
+		t.Fatalf("failed to fetch revision 1: %s", err)
+	}
+	if first.Number != 1 || string(first.Content) != "first\n" {
+		t.Errorf("unexpected revision 1: %d %q", first.Number, first.Content)
+	}
+
+	if _, err := FetchRevision(client, u, Revision{Number: 3}); err == nil {
+		t.Errorf("expected error for missing revision")
+	}
+}
+
+func TestServeDiff(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	for _, body := range []string{"a\nb\nc\n", "a\nB\nc\n", "a\nB\nc\n"} {
+		if _, err := store.Put("hello.txt", strings.NewReader(body), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	if _, err := store.Put("image.png", strings.NewReader("\x89PNG\r\n"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if _, err := store.Put("image.png", strings.NewReader("\x89PNG\r\n\x00"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	s := Server{Store: store}
+
+	tests := []struct {
+		Path   string
+		Status int
+		Cache  string
+		Body   string
+	}{
+		{"/hello.txt?diff=1..2", http.StatusOK, "public, max-age=31536000, immutable", "--- hello.txt#1\n+++ hello.txt#2\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"/hello.txt?diff=1..latest&context=0", http.StatusOK, "public, no-cache", "--- hello.txt#1\n+++ hello.txt#3\n@@ -2 +2 @@\n-b\n+B\n"},
+		{"/hello.txt?diff=2..3", http.StatusOK, "public, max-age=31536000, immutable", ""},
+		{"/hello.txt?diff=1..4", http.StatusNotFound, "", ""},
+		{"/hello.txt?diff=1", http.StatusBadRequest, "", ""},
+		{"/hello.txt?diff=0..1", http.StatusBadRequest, "", ""},
+		{"/hello.txt?diff=1..2&context=-1", http.StatusBadRequest, "", ""},
+		{"/missing.txt?diff=1..latest", http.StatusNotFound, "", ""},
+		{"/image.png?diff=1..2", http.StatusNotAcceptable, "", ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", tt.Path, nil))
+
+		if w.Code != tt.Status {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.Path, tt.Status, w.Code, w.Body)
+			continue
+		}
+		if tt.Status != http.StatusOK {
+			continue
+		}
+		if typ := w.Header().Get("Content-Type"); typ != DiffContentType {
+			t.Errorf("%s: unexpected Content-Type: %q", tt.Path, typ)
+		}
+		if cache := w.Header().Get("Cache-Control"); cache != tt.Cache {
+			t.Errorf("%s: unexpected Cache-Control: %q", tt.Path, cache)
+		}
+		if w.Body.String() != tt.Body {
+			t.Errorf("%s: unexpected body:\n%s", tt.Path, w.Body)
+		}
+	}
+}
+
+func TestFetchDiff(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	for _, body := range []string{"first\n", "second\n"} {
+		if _, err := store.Put("hello.txt", strings.NewReader(body), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+		if _, err := store.Put("hello.bin", strings.NewReader("\x00"+body), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	ts := httptest.NewServer(Server{Store: store})
+	defer ts.Close()
+
+	viper.Set("server", ts.URL)
+	defer viper.Set("server", "")
+
+	client, _ := NewClient()
+	u, _ := GetURL("hello.txt")
+
+	if rev, err := LatestRevision(client, u); err != nil || rev != 2 {
+		t.Errorf("unexpected latest revision: %d: %v", rev, err)
+	}
+
+	diff, err := FetchDiff(client, u, "1", "latest", 3)
+	if err != nil {
+		t.Fatalf("failed to fetch diff: %s", err)
+	}
+	if diff != "--- hello.txt#1\n+++ hello.txt#2\n@@ -1 +1 @@\n-first\n+second\n" {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+
+	bin, _ := GetURL("hello.bin")
+	if _, err := FetchDiff(client, bin, "1", "2", 3); err != ErrDiffNotAcceptable {
+		t.Errorf("expected ErrDiffNotAcceptable but got %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+var diffServersCmd = &cobra.Command{
+	Use:   "diff-servers SERVER_A SERVER_B",
+	Short: "Compare artifacts between two servers",
+	Long: `Compare artifacts between two servers, such as a primary server and its replica.
+
+Keys and SHA256 digests of the latest revisions are compared by the sha256sums API.
+With --all-revisions, contents of all revisions that are kept on the servers are compared as well.
+Revision numbers are not compared, because replicas assign their own revision numbers.
+
+Private keys are not compared, because they are not listed by the servers.
+With --quiet, only the keys that differ are printed.
+
+The exit status is 0 if the servers have the same artifacts, 1 if some artifacts are missing or divergent, and 2 if failed to compare.`,
+	Example: `  $ artistore diff-servers http://primary:3000 http://replica:3000
+
+  # Compare all revisions under release/.
+  $ artistore diff-servers --prefix release/ --all-revisions http://primary:3000 http://replica:3000`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var servers [2]*url.URL
+		for i, raw := range args {
+			u, err := url.Parse(raw)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				fmt.Fprintf(os.Stderr, "Invalid server address: %s\n", raw)
+				os.Exit(2)
+			}
+			servers[i] = &url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host, Path: "/"}
+		}
+
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		prefix, _ := cmd.Flags().GetString("prefix")
+		allRevisions, _ := cmd.Flags().GetBool("all-revisions")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+
+		diffs, err := DiffServers(client, servers[0], servers[1], prefix, allRevisions, concurrency)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to compare servers:", err)
+			os.Exit(2)
+		}
+
+		switch format {
+		case OutputJSON:
+			printJSON(diffs)
+		case OutputQuiet:
+			for _, d := range diffs {
+				fmt.Println(d.Key)
+			}
+		default:
+			for _, d := range diffs {
+				fmt.Println(d.Text(servers[0], servers[1]))
+			}
+		}
+
+		if len(diffs) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(diffServersCmd)
+
+	diffServersCmd.Flags().String("prefix", "", "Compare only keys that start with the prefix.")
+	diffServersCmd.Flags().Bool("all-revisions", false, "Compare all revisions instead of only the latest revisions.")
+	diffServersCmd.Flags().IntP("concurrency", "j", 4, "Number of keys to compare revisions in parallel.")
+	addOutputFlags(diffServersCmd)
+
+	addRetryFlags(diffServersCmd)
+}
+
+type ArtifactDiffKind string
+
+const (
+	DiffMissingInA ArtifactDiffKind = "missing_in_a"
+	DiffMissingInB ArtifactDiffKind = "missing_in_b"
+	DiffDivergent  ArtifactDiffKind = "divergent"
+	DiffRevisions  ArtifactDiffKind = "revisions"
+)
+
+// ArtifactDiff is a difference of a key between two servers.
+type ArtifactDiff struct {
+	Key  string           `json:"key"`
+	Kind ArtifactDiffKind `json:"kind"`
+
+	// A and B are SHA256 digests of the latest revisions on the servers.
+	A string `json:"a,omitempty"`
+	B string `json:"b,omitempty"`
+
+	// OnlyInA and OnlyInB are revisions that the other server does not have the same content.
+	OnlyInA []int `json:"only_in_a,omitempty"`
+	OnlyInB []int `json:"only_in_b,omitempty"`
+}
+
+func (d ArtifactDiff) Text(a, b *url.URL) string {
+	switch d.Kind {
+	case DiffMissingInA:
+		return fmt.Sprintf("missing in %s: %s", a, d.Key)
+	case DiffMissingInB:
+		return fmt.Sprintf("missing in %s: %s", b, d.Key)
+	case DiffDivergent:
+		return fmt.Sprintf("divergent: %s: %s has %s but %s has %s", d.Key, a, d.A, b, d.B)
+	default:
+		return fmt.Sprintf("revisions differ: %s: only in %s: %s, only in %s: %s", d.Key, a, formatRevisions(d.OnlyInA), b, formatRevisions(d.OnlyInB))
+	}
+}
+
+func formatRevisions(revs []int) string {
+	if len(revs) == 0 {
+		return "none"
+	}
+	xs := make([]string, len(revs))
+	for i, r := range revs {
+		xs[i] = fmt.Sprintf("#%d", r)
+	}
+	return strings.Join(xs, " ")
+}
+
+// FetchSHA256Sums returns SHA256 digests of the latest revisions under the prefix.
+func FetchSHA256Sums(client *Client, server *url.URL, prefix string) (map[string]string, error) {
+	u, err := server.Parse("/" + APIPrefix + "v1/sha256sums")
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = url.Values{"prefix": {prefix}}.Encode()
+
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		xs := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(xs) != 2 {
+			return nil, fmt.Errorf("%s: unexpected line: %q", u, scanner.Text())
+		}
+		sums[dir+xs[1]] = xs[0]
+	}
+	return sums, scanner.Err()
+}
+
+// DiffServers compares artifacts under the prefix between server a and b.
+// The result is sorted by key.
+func DiffServers(client *Client, a, b *url.URL, prefix string, allRevisions bool, concurrency int) ([]ArtifactDiff, error) {
+	var sums [2]map[string]string
+	for i, server := range []*url.URL{a, b} {
+		var err error
+		sums[i], err = FetchSHA256Sums(client, server, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", server, err)
+		}
+	}
+
+	var diffs []ArtifactDiff
+	var common []string
+	for key, x := range sums[0] {
+		if y, ok := sums[1][key]; !ok {
+			diffs = append(diffs, ArtifactDiff{Key: key, Kind: DiffMissingInB, A: x})
+		} else if x != y {
+			diffs = append(diffs, ArtifactDiff{Key: key, Kind: DiffDivergent, A: x, B: y})
+		} else {
+			common = append(common, key)
+		}
+	}
+	for key, y := range sums[1] {
+		if _, ok := sums[0][key]; !ok {
+			diffs = append(diffs, ArtifactDiff{Key: key, Kind: DiffMissingInA, B: y})
+		}
+	}
+
+	if allRevisions {
+		ds, err := diffRevisions(client, a, b, common, concurrency)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, ds...)
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Key < diffs[j].Key
+	})
+	return diffs, nil
+}
+
+func diffRevisions(client *Client, a, b *url.URL, keys []string, concurrency int) ([]ArtifactDiff, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+		diffs    []ArtifactDiff
+		firstErr error
+		sem      = make(chan struct{}, concurrency)
+	)
+
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer func() { <-sem; wg.Done() }()
+
+			var lists [2]RevisionList
+			for i, server := range []*url.URL{a, b} {
+				var err error
+				lists[i], err = FetchRevisions(client, server, key)
+				if err != nil {
+					lock.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("%s%s: %w", server, key, err)
+					}
+					lock.Unlock()
+					return
+				}
+			}
+
+			onlyInA := revisionsNotIn(lists[0], lists[1])
+			onlyInB := revisionsNotIn(lists[1], lists[0])
+			if len(onlyInA) > 0 || len(onlyInB) > 0 {
+				lock.Lock()
+				diffs = append(diffs, ArtifactDiff{Key: key, Kind: DiffRevisions, OnlyInA: onlyInA, OnlyInB: onlyInB})
+				lock.Unlock()
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	return diffs, firstErr
+}
+
+// revisionsNotIn returns revisions in x that y does not have the same content.
+// Contents are compared by MD5, because revisions published by old versions do not have SHA256 in metadata.
+func revisionsNotIn(x, y RevisionList) []int {
+	hashes := make(map[string]bool)
+	for _, r := range y.Revisions {
+		hashes[r.Hash] = true
+	}
+
+	var revs []int
+	for _, r := range x.Revisions {
+		if !hashes[r.Hash] {
+			revs = append(revs, r.Revision)
+		}
+	}
+	return revs
+}
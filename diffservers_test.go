@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestDiffServers(t *testing.T) {
+	put := func(store Store, key, body string) {
+		t.Helper()
+		if _, err := store.Put(key, bytes.NewBufferString(body), PutOptions{}); err != nil {
+			t.Fatalf("failed to put %s: %s", key, err)
+		}
+	}
+
+	storeA := &LocalStore{Path: t.TempDir()}
+	put(storeA, "release/same.txt", "same")
+	put(storeA, "release/history.txt", "old")
+	put(storeA, "release/history.txt", "new")
+	put(storeA, "release/divergent.txt", "hello")
+	put(storeA, "release/only-a.txt", "a")
+	put(storeA, "nightly/ignored.txt", "a")
+
+	storeB := &LocalStore{Path: t.TempDir()}
+	put(storeB, "release/same.txt", "same")
+	put(storeB, "release/history.txt", "new")
+	put(storeB, "release/divergent.txt", "world")
+	put(storeB, "release/only-b.txt", "b")
+
+	tsA := httptest.NewServer(Server{Store: storeA})
+	defer tsA.Close()
+	tsB := httptest.NewServer(Server{Store: storeB})
+	defer tsB.Close()
+
+	a, _ := url.Parse(tsA.URL + "/")
+	b, _ := url.Parse(tsB.URL + "/")
+	client := &Client{HTTP: http.DefaultClient, Retry: RetryPolicy{MaxAttempts: 1}}
+
+	diffs, err := DiffServers(client, a, b, "release/", false, 2)
+	if err != nil {
+		t.Fatalf("failed to compare: %s", err)
+	}
+
+	var kinds []string
+	for _, d := range diffs {
+		kinds = append(kinds, d.Key+" "+string(d.Kind))
+	}
+	if expected := []string{"release/divergent.txt divergent", "release/only-a.txt missing_in_b", "release/only-b.txt missing_in_a"}; !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("unexpected diffs:\nexpected: %v\n but got: %v", expected, kinds)
+	}
+	if diffs[0].A == "" || diffs[0].B == "" || diffs[0].A == diffs[0].B {
+		t.Errorf("divergent diff should have both digests: %#v", diffs[0])
+	}
+
+	diffs, err = DiffServers(client, a, b, "release/", true, 2)
+	if err != nil {
+		t.Fatalf("failed to compare: %s", err)
+	}
+	if len(diffs) != 4 || diffs[1].Key != "release/history.txt" || diffs[1].Kind != DiffRevisions {
+		t.Fatalf("unexpected diffs: %#v", diffs)
+	}
+	if !reflect.DeepEqual(diffs[1].OnlyInA, []int{1}) || len(diffs[1].OnlyInB) != 0 {
+		t.Errorf("unexpected revisions: %#v", diffs[1])
+	}
+	if text := diffs[1].Text(a, b); text != "revisions differ: release/history.txt: only in "+tsA.URL+"/: #1, only in "+tsB.URL+"/: none" {
+		t.Errorf("unexpected text: %s", text)
+	}
+}
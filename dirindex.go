@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DirectoryIndex is a listing of keys right under a prefix, by "GET /PREFIX/".
+type DirectoryIndex struct {
+	Prefix      string         `json:"prefix"`
+	Directories []string       `json:"directories"`
+	Artifacts   []ArtifactInfo `json:"artifacts"`
+}
+
+var directoryIndexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"base": func(prefix, key string) string {
+		return strings.TrimPrefix(key, prefix)
+	},
+	"size": func(n int) string {
+		return FormatSize(int64(n))
+	},
+	"time": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of /{{.Prefix}}</title></head>
+<body>
+<h1>Index of /{{.Prefix}}</h1>
+<table>
+<tr><th>Name</th><th>Last modified</th><th>Size</th></tr>
+<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{- range .Directories}}
+<tr><td><a href="{{base $.Prefix .}}">{{base $.Prefix .}}</a></td><td></td><td>-</td></tr>
+{{- end}}
+{{- range .Artifacts}}
+<tr><td><a href="{{base $.Prefix .Key}}">{{base $.Prefix .Key}}</a></td><td>{{time .Timestamp}}</td><td>{{size .Size}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// serveDirectoryIndex lists keys right under the prefix like autoindex of classic web servers.
+// It responds JSON if the client accepts application/json, otherwise HTML.
+//
+// The index is only for public prefixes, because browsers do not send token.
+func (s Server) serveDirectoryIndex(prefix string, w http.ResponseWriter, r *http.Request) {
+	if s.isPrivate(prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, ErrNoSuchArtifact)
+		return
+	}
+
+	metas, err := ListLatest(s.Store, prefix)
+	if err == ErrCircuitOpen {
+		s.unavailable(w)
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	index := DirectoryIndex{Prefix: prefix, Directories: []string{}, Artifacts: []ArtifactInfo{}}
+	seen := make(map[string]bool)
+	for _, meta := range metas {
+		if s.isPrivate(meta.Key) {
+			continue
+		}
+		if i := strings.Index(meta.Key[len(prefix):], "/"); i >= 0 {
+			dir := meta.Key[:len(prefix)+i+1]
+			if !seen[dir] {
+				seen[dir] = true
+				index.Directories = append(index.Directories, dir)
+			}
+		} else {
+			index.Artifacts = append(index.Artifacts, NewArtifactInfo(meta))
+		}
+	}
+	sort.Strings(index.Directories)
+	sort.Slice(index.Artifacts, func(i, j int) bool {
+		return index.Artifacts[i].Key < index.Artifacts[j].Key
+	})
+	if len(index.Directories) == 0 && len(index.Artifacts) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, ErrNoSuchArtifact)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Cache-Control", "public, no-cache")
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, index)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := directoryIndexTemplate.Execute(w, index); err != nil {
+		PrintErr("ERROR", "%s", err)
+	}
+}
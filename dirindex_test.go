@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDirectoryIndex(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	store.Put("shared/readme.txt", strings.NewReader("hello"), PutOptions{})
+	store.Put("shared/tools/a.zip", strings.NewReader("a"), PutOptions{})
+	store.Put("shared/tools/b.zip", strings.NewReader("b"), PutOptions{})
+	store.Put("shared/secret/key.pem", strings.NewReader("secret"), PutOptions{})
+	store.Put("shared/<b>.txt", strings.NewReader("escape"), PutOptions{})
+
+	s := Server{Store: store, Private: []string{"shared/secret/", "private/"}}
+
+	t.Run("json", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/shared/", nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d: %s", w.Code, w.Body.String())
+		}
+
+		var index DirectoryIndex
+		if err := json.Unmarshal(w.Body.Bytes(), &index); err != nil {
+			t.Fatalf("failed to parse: %s", err)
+		}
+		if len(index.Directories) != 1 || index.Directories[0] != "shared/tools/" {
+			t.Errorf("unexpected directories: %v", index.Directories)
+		}
+		if len(index.Artifacts) != 2 || index.Artifacts[0].Key != "shared/<b>.txt" || index.Artifacts[1].Key != "shared/readme.txt" || index.Artifacts[1].Size != 5 {
+			t.Errorf("unexpected artifacts: %v", index.Artifacts)
+		}
+	})
+
+	t.Run("html", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/shared/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d: %s", w.Code, w.Body.String())
+		}
+		if typ := w.Header().Get("Content-Type"); typ != "text/html; charset=utf-8" {
+			t.Errorf("unexpected Content-Type: %q", typ)
+		}
+		body := w.Body.String()
+		for _, s := range []string{`href="tools/"`, `href="readme.txt"`, "&lt;b&gt;.txt"} {
+			if !strings.Contains(body, s) {
+				t.Errorf("index should contain %s:\n%s", s, body)
+			}
+		}
+		if strings.Contains(body, "secret") {
+			t.Errorf("private keys should not be listed:\n%s", body)
+		}
+	})
+
+	for _, path := range []string{"/missing/", "/private/"} {
+		t.Run(path, func(t *testing.T) {
+			r := httptest.NewRequest("GET", path, nil)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != http.StatusNotFound && w.Code != http.StatusForbidden {
+				t.Errorf("unexpected status: %d", w.Code)
+			}
+		})
+	}
+}
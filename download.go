@@ -0,0 +1,367 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DefaultChunkSize is the size of each Range request of ParallelDownload.
+const DefaultChunkSize = 8 * 1024 * 1024
+
+// partialSuffix is the suffix of the state file of an unfinished download.
+const partialSuffix = ".artistore-partial"
+
+// partSuffix is the suffix of the file that is being downloaded.
+// It is renamed to the output file after the download is finished and verified, so that the output never has a broken content.
+const partSuffix = ".part"
+
+var (
+	ErrRangeNotSupported = errors.New("The server does not support Range requests for this artifact.")
+	ErrDownloadCorrupted = errors.New("Downloaded file does not match to the ETag of the artifact.")
+)
+
+// ParallelDownload downloads an artifact into Output by concurrent Range requests.
+//
+// The progress is saved into the state file next to Output, so that an interrupted download can be resumed by running it again.
+type ParallelDownload struct {
+	Client    *Client
+	URL       *url.URL
+	Output    string
+	Workers   int
+	ChunkSize int64
+}
+
+// downloadState is the progress of a download. ChunkSize is 0 if it is a single stream download by ContinueDownload.
+type downloadState struct {
+	URL       string `json:"url"`
+	ETag      string `json:"etag"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+	Done      []bool `json:"done"`
+}
+
+// loadDownloadState reads the state file of output.
+func loadDownloadState(output string) (downloadState, bool) {
+	var st downloadState
+
+	data, err := os.ReadFile(output + partialSuffix)
+	if err != nil {
+		return st, false
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		PrintWarn("WARN", "%s%s: ignore broken state file: %s", output, partialSuffix, err)
+		return st, false
+	}
+	return st, true
+}
+
+func saveDownloadState(output string, st downloadState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	tmp := output + partialSuffix + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, output+partialSuffix)
+}
+
+// probe sends HEAD request, and returns the URL pinned to the revision and the state for a new download.
+func (d ParallelDownload) probe() (*url.URL, downloadState, error) {
+	resp, err := d.Client.Do(func() (*http.Request, error) {
+		req, err := http.NewRequest("HEAD", d.URL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept-Encoding", "identity")
+		return req, nil
+	})
+	if err != nil {
+		return nil, downloadState{}, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, downloadState{}, HTTPError{resp.StatusCode, resp.Status}
+	}
+
+	rev := resp.Header.Get("X-Artistore-Revision")
+	if resp.Header.Get("Accept-Ranges") != "bytes" || resp.ContentLength < 0 || rev == "" {
+		return nil, downloadState{}, ErrRangeNotSupported
+	}
+
+	// Pin the revision, so that all chunks come from the same revision even if a new one is published meanwhile.
+	u := *resp.Request.URL
+	q := u.Query()
+	q.Set("rev", rev)
+	u.RawQuery = q.Encode()
+
+	st := downloadState{
+		URL:       u.String(),
+		ETag:      resp.Header.Get("Etag"),
+		Size:      resp.ContentLength,
+		ChunkSize: d.ChunkSize,
+	}
+	st.Done = make([]bool, (st.Size+st.ChunkSize-1)/st.ChunkSize)
+
+	return &u, st, nil
+}
+
+// Run downloads the artifact, and returns the URL pinned to the downloaded revision.
+// It resumes the previous download if the state file matches to the artifact.
+func (d ParallelDownload) Run() (*url.URL, error) {
+	if d.Workers < 1 {
+		d.Workers = 1
+	}
+	if d.ChunkSize <= 0 {
+		d.ChunkSize = DefaultChunkSize
+	}
+
+	u, st, err := d.probe()
+	if err != nil {
+		return nil, err
+	}
+
+	flag := os.O_RDWR | os.O_CREATE
+	if prev, ok := loadDownloadState(d.Output); ok && prev.URL == st.URL && prev.ETag == st.ETag && prev.Size == st.Size && len(prev.Done) > 0 {
+		st = prev
+	} else {
+		flag |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(d.Output+partSuffix, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := f.Truncate(st.Size); err != nil {
+		return nil, err
+	}
+
+	var (
+		lock     sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	chunks := make(chan int)
+
+	for i := 0; i < d.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range chunks {
+				lock.Lock()
+				failed := firstErr != nil
+				lock.Unlock()
+				if failed {
+					continue
+				}
+
+				err := d.fetchChunk(u, f, st, i)
+				if err == nil {
+					err = f.Sync()
+				}
+
+				lock.Lock()
+				if err == nil {
+					st.Done[i] = true
+					err = saveDownloadState(d.Output, st)
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	for i, done := range st.Done {
+		if !done {
+			chunks <- i
+		}
+	}
+	close(chunks)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return u, finishDownload(f, d.Output, st.ETag)
+}
+
+func (d ParallelDownload) fetchChunk(u *url.URL, f *os.File, st downloadState, i int) error {
+	start := int64(i) * st.ChunkSize
+	end := start + st.ChunkSize
+	if end > st.Size {
+		end = st.Size
+	}
+
+	resp, err := d.Client.Do(func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept-Encoding", "identity")
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		msg, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusOK {
+			return ErrRangeNotSupported
+		}
+		return HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+
+	n, err := io.Copy(&offsetWriter{f, start}, io.LimitReader(resp.Body, end-start))
+	if err != nil {
+		return err
+	}
+	if n != end-start {
+		return fmt.Errorf("Chunk %d is truncated: expected %d bytes but got %d bytes.", i, end-start, n)
+	}
+	return nil
+}
+
+// ContinueDownload downloads an artifact into output through the part file.
+// If the part file is a part of the same artifact that is downloaded before, it requests only the remainder and appends it.
+// It returns the URL pinned to the downloaded revision.
+func ContinueDownload(client *Client, u *url.URL, output string) (*url.URL, error) {
+	var offset int64
+	st, ok := loadDownloadState(output)
+	if info, err := os.Stat(output + partSuffix); ok && st.ChunkSize == 0 && st.ETag != "" && err == nil && info.Size() <= st.Size {
+		offset = info.Size()
+	}
+
+	resp, err := client.Do(func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		// Transport compression changes the bytes and weakens ETag, so that the file can not be resumed.
+		req.Header.Set("Accept-Encoding", "identity")
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", st.ETag)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	loc := *resp.Request.URL
+	if rev := resp.Header.Get("X-Artistore-Revision"); rev != "" {
+		q := loc.Query()
+		q.Set("rev", rev)
+		loc.RawQuery = q.Encode()
+	}
+
+	flag := os.O_RDWR | os.O_CREATE
+	var body io.Reader = resp.Body
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		flag |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset == st.Size:
+		// The previous download was finished but not verified.
+		body = nil
+		flag |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		flag |= os.O_TRUNC
+		offset = 0
+		st = downloadState{URL: u.String(), ETag: resp.Header.Get("Etag"), Size: resp.ContentLength}
+		if st.Size >= 0 {
+			if err := saveDownloadState(output, st); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+
+	f, err := os.OpenFile(output+partSuffix, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if body != nil {
+		if _, err := io.Copy(f, body); err != nil {
+			return nil, err
+		}
+	}
+
+	return &loc, finishDownload(f, output, st.ETag)
+}
+
+// finishDownload verifies the part file f, and renames it to output.
+// The part file and the state are removed if it is corrupted, so that the next run starts from the beginning.
+func finishDownload(f *os.File, output, etag string) error {
+	if err := verifyDownload(f, etag); err != nil {
+		f.Close()
+		os.Remove(output + partSuffix)
+		os.Remove(output + partialSuffix)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(output+partSuffix, output); err != nil {
+		return err
+	}
+	return os.Remove(output + partialSuffix)
+}
+
+type offsetWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// verifyDownload checks MD5 of the downloaded file with the ETag of the artifact.
+func verifyDownload(f *os.File, etag string) error {
+	expect := strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+	if len(expect) != md5.Size*2 {
+		return nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != expect {
+		return ErrDownloadCorrupted
+	}
+	return nil
+}
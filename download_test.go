@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallelDownload(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	content := make([]byte, 100*1024+123)
+	rand.Read(content)
+	if _, err := store.Put("large.bin", bytes.NewReader(content), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	var ranges, failAfter int32
+	failAfter = 3
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			if n, limit := atomic.AddInt32(&ranges, 1), atomic.LoadInt32(&failAfter); limit > 0 && n > limit {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		Server{Store: store}.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/large.bin")
+	output := filepath.Join(t.TempDir(), "large.bin")
+	d := ParallelDownload{
+		Client:    &Client{HTTP: &http.Client{}, Retry: RetryPolicy{MaxAttempts: 1}},
+		URL:       u,
+		Output:    output,
+		Workers:   1,
+		ChunkSize: 10 * 1024,
+	}
+
+	if _, err := d.Run(); err == nil {
+		t.Fatalf("expected error but got nil")
+	}
+	if _, err := os.Stat(output + partialSuffix); err != nil {
+		t.Fatalf("state file should be kept after failure: %s", err)
+	}
+
+	atomic.StoreInt32(&ranges, 0)
+	atomic.StoreInt32(&failAfter, 0)
+	d.Workers = 4
+	if _, err := d.Run(); err != nil {
+		t.Fatalf("failed to resume: %s", err)
+	}
+
+	// 11 chunks in total, and 3 chunks are already downloaded by the first run.
+	if n := atomic.LoadInt32(&ranges); n != 8 {
+		t.Errorf("expected 8 range requests on resume but got %d", n)
+	}
+
+	if data, err := os.ReadFile(output); err != nil {
+		t.Errorf("failed to read output: %s", err)
+	} else if !bytes.Equal(data, content) {
+		t.Errorf("downloaded content does not match")
+	}
+
+	if _, err := os.Stat(output + partialSuffix); !os.IsNotExist(err) {
+		t.Errorf("state file should be removed after download: %v", err)
+	}
+}
+
+func TestContinueDownload(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	content := make([]byte, 64*1024)
+	rand.Read(content)
+	if _, err := store.Put("large.bin", bytes.NewReader(content), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	var interrupt int32 = 1
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if atomic.LoadInt32(&interrupt) == 1 && r.URL.Query().Has("rev") {
+			w.Header().Set("Etag", `"`+md5Hex(content)+`"`)
+			w.Header().Set("Content-Length", "65536")
+			w.WriteHeader(http.StatusOK)
+			w.Write(content[:1000])
+			return
+		}
+		Server{Store: store}.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL + "/large.bin")
+	output := filepath.Join(t.TempDir(), "large.bin")
+	client := &Client{HTTP: &http.Client{}, Retry: RetryPolicy{MaxAttempts: 1}}
+
+	if _, err := ContinueDownload(client, u, output); err == nil {
+		t.Fatalf("expected error but got nil")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("output should not exist until the download is finished: %v", err)
+	}
+	if info, err := os.Stat(output + partSuffix); err != nil || info.Size() != 1000 {
+		t.Fatalf("expected partial output of 1000 bytes: %v", err)
+	}
+
+	atomic.StoreInt32(&interrupt, 0)
+	ranges = nil
+	if _, err := ContinueDownload(client, u, output); err != nil {
+		t.Fatalf("failed to continue: %s", err)
+	}
+	if len(ranges) == 0 || ranges[len(ranges)-1] != "bytes=1000-" {
+		t.Errorf("expected range request from 1000 but got %v", ranges)
+	}
+
+	if data, err := os.ReadFile(output); err != nil {
+		t.Errorf("failed to read output: %s", err)
+	} else if !bytes.Equal(data, content) {
+		t.Errorf("downloaded content does not match")
+	}
+	if _, err := os.Stat(output + partialSuffix); !os.IsNotExist(err) {
+		t.Errorf("state file should be removed after download: %v", err)
+	}
+}
+
+func md5Hex(data []byte) string {
+	h := md5.Sum(data)
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressOptions is the configuration of transport compression.
+type CompressOptions struct {
+	ETag ETagFormat
+
+	// BrotliQuality is the quality level of brotli from 0 to 11. Negative value disables brotli.
+	BrotliQuality int
+
+	// ZstdLevel is the compression level of zstd from 1 to 22. 0 disables zstd.
+	ZstdLevel int
+}
+
+// isTextType checks if the content type is worth to compress by brotli or zstd.
+func isTextType(contentType string) bool {
+	typ, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(typ, "text/"):
+		return true
+	case strings.HasSuffix(typ, "+json"), strings.HasSuffix(typ, "+xml"):
+		return true
+	}
+	switch typ {
+	case "application/javascript", "application/ecmascript", "application/x-javascript", "application/json", "application/xml", "application/wasm":
+		return true
+	}
+	return false
+}
+
+// acceptEncodingQuality returns q-value of the encoding in Accept-Encoding header.
+func acceptEncodingQuality(header, encoding string) float64 {
+	wildcard := 0.0
+	for _, x := range strings.Split(header, ",") {
+		xs := strings.Split(x, ";")
+		name := strings.ToLower(strings.TrimSpace(xs[0]))
+
+		q := 1.0
+		for _, param := range xs[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		if name == encoding {
+			return q
+		} else if name == "*" {
+			wildcard = q
+		}
+	}
+	return wildcard
+}
+
+// negotiateEncoding chooses brotli or zstd for the request. It returns empty string if the client accepts neither of them.
+func (o CompressOptions) negotiateEncoding(r *http.Request) string {
+	header := r.Header.Get("Accept-Encoding")
+	if header == "" {
+		return ""
+	}
+
+	var br, zst float64
+	if o.BrotliQuality >= 0 {
+		br = acceptEncodingQuality(header, "br")
+	}
+	if o.ZstdLevel > 0 {
+		zst = acceptEncodingQuality(header, "zstd")
+	}
+
+	switch {
+	case br > 0 && br >= zst:
+		return "br"
+	case zst > 0:
+		return "zstd"
+	default:
+		return ""
+	}
+}
+
+var (
+	brotliPools sync.Map // map[int]*sync.Pool
+	zstdPools   sync.Map // map[int]*sync.Pool
+)
+
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+func (o CompressOptions) newEncoder(encoding string, w io.Writer) (resetWriter, *sync.Pool) {
+	var pools *sync.Map
+	var level int
+	var newEncoder func() interface{}
+
+	switch encoding {
+	case "br":
+		pools, level = &brotliPools, o.BrotliQuality
+		newEncoder = func() interface{} { return brotli.NewWriterLevel(nil, level) }
+	case "zstd":
+		pools, level = &zstdPools, o.ZstdLevel
+		newEncoder = func() interface{} {
+			z, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+			return z
+		}
+	}
+
+	p, _ := pools.LoadOrStore(level, &sync.Pool{New: newEncoder})
+	pool := p.(*sync.Pool)
+
+	enc := pool.Get().(resetWriter)
+	enc.Reset(w)
+	return enc, pool
+}
+
+// encodingWriter compresses text responses by brotli or zstd.
+// It sets Content-Encoding before writing the header, so gziphandler in front of it does not compress the response again.
+type encodingWriter struct {
+	http.ResponseWriter
+
+	options  CompressOptions
+	encoding string
+
+	wroteHeader bool
+	enc         resetWriter
+	pool        *sync.Pool
+}
+
+func (w *encodingWriter) start(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if code != http.StatusOK || h.Get("Content-Encoding") != "" || !isTextType(h.Get("Content-Type")) {
+		return
+	}
+	if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil && cl < gziphandler.DefaultMinSize {
+		return
+	}
+
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	w.enc, w.pool = w.options.newEncoder(w.encoding, w.ResponseWriter)
+}
+
+func (w *encodingWriter) WriteHeader(code int) {
+	w.start(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *encodingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *encodingWriter) Close() error {
+	if w.enc == nil {
+		return nil
+	}
+
+	err := w.enc.Close()
+	w.enc.Reset(nil)
+	w.pool.Put(w.enc)
+	w.enc = nil
+	return err
+}
+
+// encodingHandler compresses text responses by brotli or zstd if the client accepts them.
+func (o CompressOptions) encodingHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := o.negotiateEncoding(r)
+		if encoding == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		ew := &encodingWriter{ResponseWriter: w, options: o, encoding: encoding}
+		defer ew.Close()
+		h.ServeHTTP(ew, r)
+	})
+}
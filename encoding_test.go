@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestAcceptEncodingQuality(t *testing.T) {
+	tests := []struct {
+		Header   string
+		Encoding string
+		Quality  float64
+	}{
+		{"gzip, br", "br", 1},
+		{"gzip, br;q=0.5", "br", 0.5},
+		{"gzip, br; q=0", "br", 0},
+		{"gzip", "br", 0},
+		{"gzip, *;q=0.1", "zstd", 0.1},
+		{"BR", "br", 1},
+	}
+
+	for _, tt := range tests {
+		if q := acceptEncodingQuality(tt.Header, tt.Encoding); q != tt.Quality {
+			t.Errorf("%q %s: expected %v but got %v", tt.Header, tt.Encoding, tt.Quality, q)
+		}
+	}
+}
+
+func TestCompressHandlerEncoding(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	text := strings.Repeat("hello world\n", 1000)
+	if _, err := store.Put("hello.txt", strings.NewReader(text), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	binary := "\x00\x01\x02\xff" + text
+	if _, err := store.Put("hello.bin", strings.NewReader(binary), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"": func(r io.Reader) (io.Reader, error) { return r, nil },
+		"br": func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		},
+		"zstd": func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		},
+		"gzip": func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+	}
+
+	tests := []struct {
+		Key      string
+		Options  CompressOptions
+		Accept   string
+		Encoding string
+	}{
+		{"hello.txt", CompressOptions{BrotliQuality: 5, ZstdLevel: 3}, "gzip, br, zstd", "br"},
+		{"hello.txt", CompressOptions{BrotliQuality: 5, ZstdLevel: 3}, "gzip, br;q=0.5, zstd", "zstd"},
+		{"hello.txt", CompressOptions{BrotliQuality: -1, ZstdLevel: 3}, "gzip, br, zstd", "zstd"},
+		{"hello.txt", CompressOptions{BrotliQuality: -1, ZstdLevel: 0}, "gzip, br, zstd", "gzip"},
+		{"hello.txt", CompressOptions{BrotliQuality: 5, ZstdLevel: 3}, "gzip", "gzip"},
+		{"hello.txt", CompressOptions{BrotliQuality: 5, ZstdLevel: 3}, "", ""},
+		{"hello.bin", CompressOptions{BrotliQuality: 5, ZstdLevel: 3}, "gzip, br, zstd", "gzip"},
+		{"hello.bin", CompressOptions{BrotliQuality: 5, ZstdLevel: 3}, "br", ""},
+	}
+
+	for _, tt := range tests {
+		h := CompressHandler(tt.Options, Server{Store: store})
+
+		r := httptest.NewRequest("GET", "/"+tt.Key+"?rev=1", nil)
+		r.Header.Set("Accept-Encoding", tt.Accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if enc := w.Header().Get("Content-Encoding"); enc != tt.Encoding {
+			t.Errorf("%s %q: expected Content-Encoding %q but got %q", tt.Key, tt.Accept, tt.Encoding, enc)
+			continue
+		}
+		if etag := w.Header().Get("Etag"); (tt.Encoding != "") != strings.HasPrefix(etag, "W/") {
+			t.Errorf("%s %q: unexpected ETag for encoding %q: %s", tt.Key, tt.Accept, tt.Encoding, etag)
+		}
+
+		dec, err := decoders[tt.Encoding](w.Body)
+		if err != nil {
+			t.Errorf("%s %q: failed to decode: %s", tt.Key, tt.Accept, err)
+			continue
+		}
+		var body bytes.Buffer
+		if _, err := io.Copy(&body, dec); err != nil {
+			t.Errorf("%s %q: failed to decode: %s", tt.Key, tt.Accept, err)
+		}
+		if expect := map[string]string{"hello.txt": text, "hello.bin": binary}[tt.Key]; body.String() != expect {
+			t.Errorf("%s %q: unexpected body", tt.Key, tt.Accept)
+		}
+	}
+}
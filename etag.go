@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/NYTimes/gziphandler"
+)
+
+// ETagFormat is the policy to emit ETag of artifacts.
+type ETagFormat string
+
+const (
+	// ETagAuto uses strong ETag for identity responses, and weak ETag for compressed responses.
+	ETagAuto ETagFormat = "auto"
+
+	// ETagStrong disables transport compression, so that ETag is always strong.
+	ETagStrong ETagFormat = "strong"
+
+	// ETagWeak always uses weak ETag.
+	ETagWeak ETagFormat = "weak"
+)
+
+func ParseETagFormat(s string) (ETagFormat, error) {
+	switch f := ETagFormat(strings.ToLower(s)); f {
+	case ETagAuto, ETagStrong, ETagWeak:
+		return f, nil
+	default:
+		return "", fmt.Errorf("Invalid ETag format: %q: it should be auto, strong, or weak.", s)
+	}
+}
+
+// ETag returns ETag header value for the hash.
+func (f ETagFormat) ETag(hash string) string {
+	if f == ETagWeak {
+		return `W/"` + hash + `"`
+	}
+	return `"` + hash + `"`
+}
+
+// CompressHandler wraps the handler with transport compression that fits to the ETag format.
+// Text responses are compressed by brotli or zstd if the client accepts them, and others are compressed by gzip.
+//
+// Byte ranges of a compressed response does not mean the same with ones of identity response,
+// so Range requests are always served without compression.
+func CompressHandler(opts CompressOptions, h http.Handler) http.Handler {
+	if opts.ETag == ETagStrong {
+		return h
+	}
+
+	gz := gziphandler.GzipHandler(opts.encodingHandler(h))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			w.Header().Add("Vary", "Accept-Encoding")
+			h.ServeHTTP(w, r)
+			return
+		}
+		gz.ServeHTTP(&etagWriter{ResponseWriter: w, request: r}, r)
+	})
+}
+
+// etagWriter weakens strong ETag if the response is compressed.
+type etagWriter struct {
+	http.ResponseWriter
+
+	request     *http.Request
+	wroteHeader bool
+}
+
+func (w *etagWriter) fixETag(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	etag := h.Get("Etag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return
+	}
+
+	if h.Get("Content-Encoding") != "" {
+		h.Set("Etag", "W/"+etag)
+	} else if code == http.StatusNotModified {
+		// 304 response should have the same ETag with the representation that the client has.
+		if m, ok := matchETag(w.request.Header.Get("If-None-Match"), etag, weakETagMatch); ok && strings.HasPrefix(m, "W/") {
+			h.Set("Etag", "W/"+etag)
+		}
+	}
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	w.fixETag(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *etagWriter) Write(p []byte) (int, error) {
+	w.fixETag(http.StatusOK)
+	return w.ResponseWriter.Write(p)
+}
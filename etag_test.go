@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressHandlerETag(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	body := strings.Repeat("hello world\n", 1000)
+	if _, err := store.Put("hello.txt", strings.NewReader(body), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	meta, err := store.Metadata("hello.txt", 1)
+	if err != nil {
+		t.Fatalf("failed to get metadata: %s", err)
+	}
+	strong := `"` + meta.Hash + `"`
+	weak := `W/"` + meta.Hash + `"`
+
+	tests := []struct {
+		Format   ETagFormat
+		Header   map[string]string
+		Status   int
+		ETag     string
+		Encoding string
+	}{
+		{ETagAuto, map[string]string{}, http.StatusOK, strong, ""},
+		{ETagAuto, map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, weak, "gzip"},
+		{ETagAuto, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-4"}, http.StatusPartialContent, strong, ""},
+		{ETagAuto, map[string]string{"Accept-Encoding": "gzip", "If-None-Match": weak}, http.StatusNotModified, weak, ""},
+		{ETagAuto, map[string]string{"Accept-Encoding": "gzip", "If-None-Match": strong}, http.StatusNotModified, strong, ""},
+		{ETagStrong, map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, strong, ""},
+		{ETagWeak, map[string]string{}, http.StatusOK, weak, ""},
+		{ETagWeak, map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, weak, "gzip"},
+	}
+
+	for _, tt := range tests {
+		s := Server{Store: store, ETagFormat: tt.Format}
+		h := CompressHandler(CompressOptions{ETag: tt.Format, BrotliQuality: -1}, s)
+
+		r := httptest.NewRequest("GET", "/hello.txt?rev=1", nil)
+		for k, v := range tt.Header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.Status {
+			t.Errorf("%s %v: expected status %d but got %d", tt.Format, tt.Header, tt.Status, w.Code)
+		}
+		if etag := w.Header().Get("Etag"); etag != tt.ETag {
+			t.Errorf("%s %v: expected ETag %s but got %s", tt.Format, tt.Header, tt.ETag, etag)
+		}
+		if enc := w.Header().Get("Content-Encoding"); enc != tt.Encoding {
+			t.Errorf("%s %v: expected Content-Encoding %q but got %q", tt.Format, tt.Header, tt.Encoding, enc)
+		}
+
+		vary := w.Header().Get("Vary") == "Accept-Encoding"
+		if tt.Format != ETagStrong && !vary {
+			t.Errorf("%s %v: expected Vary: Accept-Encoding but got %q", tt.Format, tt.Header, w.Header().Get("Vary"))
+		} else if tt.Format == ETagStrong && vary {
+			t.Errorf("%s %v: unexpected Vary header", tt.Format, tt.Header)
+		}
+	}
+}
+
+func TestParseETagFormat(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output ETagFormat
+		Error  bool
+	}{
+		{"auto", ETagAuto, false},
+		{"Strong", ETagStrong, false},
+		{"weak", ETagWeak, false},
+		{"", "", true},
+		{"never", "", true},
+	}
+
+	for _, tt := range tests {
+		f, err := ParseETagFormat(tt.Input)
+		if (err != nil) != tt.Error {
+			t.Errorf("%q: unexpected error: %v", tt.Input, err)
+		}
+		if f != tt.Output {
+			t.Errorf("%q: expected %q but got %q", tt.Input, tt.Output, f)
+		}
+	}
+}
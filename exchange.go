@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	ErrTokenExpired = errors.New("This token has been expired.")
+)
+
+// MaxExchangedKeysSize is the maximum total length of keys in an exchanged token, to keep the token small enough for HTTP headers.
+const MaxExchangedKeysSize = 1024
+
+// DefaultExchangeTTL is the lifetime of exchanged tokens if not specified.
+const DefaultExchangeTTL = 15 * time.Minute
+
+var exchangeTokenCmd = &cobra.Command{
+	Use:   "exchange-token KEY...",
+	Short: "Get a short-lived read-only token",
+	Long: `Get a short-lived read-only token for specific keys.
+
+The current token is exchanged by the server for a new token that can only read the KEYs until it expires.
+KEYs that end with slash work as prefixes, as the same as tokens generated by 'artistore token'.
+The current token has to be allowed to publish all KEYs.
+
+This is useful to hand over access to downstream CI jobs without leaking the publish token.
+If the secret is set instead of a token, the token is generated locally without asking the server.
+
+With --output json, the token is printed together with the keys and the expiry.`,
+	Example: `  $ export ARTISTORE_TOKEN=$(artistore token release/)
+  $ artistore exchange-token --ttl 10m release/v1.0.0/app.tar.gz`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		if ttl <= 0 {
+			fmt.Fprintln(os.Stderr, "--ttl should be positive.")
+			os.Exit(2)
+		}
+		if err := verifyExchangeKeys(args); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		t, err := NewTokenHandler()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		var result ExchangedToken
+		if t.Token == nil {
+			expires := time.Now().Add(ttl).Truncate(time.Second)
+			token, err := NewExchangedToken(t.Secret, args, expires)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			result = ExchangedToken{token.String(), args, expires}
+		} else {
+			u, err := GetAPIURL("v1/tokens/exchange")
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+
+			client, err := NewClient()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+
+			req := TokenExchangeRequest{Keys: args, TTL: ttl.String()}
+			if err := client.CallAPI("POST", u, t.Token, req, &result); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+
+		if format == OutputJSON {
+			printJSON(result)
+		} else {
+			fmt.Println(result.Token)
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(exchangeTokenCmd)
+
+	exchangeTokenCmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	exchangeTokenCmd.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+	exchangeTokenCmd.Flags().String("token", "", "Token to exchange. See also 'artistore help token'.")
+	exchangeTokenCmd.Flags().Duration("ttl", DefaultExchangeTTL, "Lifetime of the new token. The server may limit it by --exchange-max-ttl.")
+	addOutputFlags(exchangeTokenCmd)
+}
+
+// Exchanged tokens (t3:) are read-only tokens that are limited to specific keys and expire.
+//
+// It is 1 byte version, 4 bytes salt, 8 bytes expiry in unix seconds, 28 bytes signature, and the keys joined by newline.
+// The keys are included in the token because the signature can not be checked against the requested key alone as version 1 and 2.
+const exchangedTokenHeaderSize = 1 + 4 + 8 + sha256.Size224
+
+func signExchangedToken(s Secret, salt []byte, expires time.Time, keys string) []byte {
+	var exp [8]byte
+	binary.BigEndian.PutUint64(exp[:], uint64(expires.Unix()))
+
+	h := newTokenHash(s, salt, 3)
+	writeTokenField(h, exp[:])
+	writeTokenField(h, []byte(keys))
+	return h.Sum(nil)
+}
+
+func verifyExchangeKeys(keys []string) error {
+	if len(keys) == 0 {
+		return errors.New("At least one key is required.")
+	}
+	if len(strings.Join(keys, "\n")) > MaxExchangedKeysSize {
+		return fmt.Errorf("Too many keys: they should be %d bytes or less in total.", MaxExchangedKeysSize)
+	}
+	for _, k := range keys {
+		if err := VerifyKey(k); err == ErrSlashKey && k[0] != '/' {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("%q: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// NewExchangedToken makes a read-only token for the keys that expires at expires.
+func NewExchangedToken(s Secret, keys []string, expires time.Time) (Token, error) {
+	if err := verifyExchangeKeys(keys); err != nil {
+		return nil, err
+	}
+
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	joined := strings.Join(keys, "\n")
+
+	buf := make([]byte, 0, exchangedTokenHeaderSize+len(joined))
+	buf = append(buf, 3)
+	buf = append(buf, salt...)
+	var exp [8]byte
+	binary.BigEndian.PutUint64(exp[:], uint64(expires.Unix()))
+	buf = append(buf, exp[:]...)
+	buf = append(buf, signExchangedToken(s, salt, expires, joined)...)
+	buf = append(buf, joined...)
+	return Token(buf), nil
+}
+
+func isExchangedToken(t Token) bool {
+	return len(t) > exchangedTokenHeaderSize && t[0] == 3 && !IsJWT(string(t))
+}
+
+// Expires returns the expiry of exchanged token. It is zero for other tokens.
+func (t Token) Expires() time.Time {
+	if t.Version() != 3 {
+		return time.Time{}
+	}
+	return time.Unix(int64(binary.BigEndian.Uint64(t[5:13])), 0)
+}
+
+// Keys returns the keys that exchanged token can read. It is nil for other tokens.
+func (t Token) Keys() []string {
+	if t.Version() != 3 {
+		return nil
+	}
+	return strings.Split(string(t[exchangedTokenHeaderSize:]), "\n")
+}
+
+// verifyExchangedToken checks the signature and the expiry of t, and whether t has the key.
+func verifyExchangedToken(s Secret, t Token, key string) error {
+	sig := signExchangedToken(s, t[1:5], t.Expires(), string(t[exchangedTokenHeaderSize:]))
+	if !hmac.Equal(sig, t[13:exchangedTokenHeaderSize]) {
+		return ErrInvalidToken
+	}
+	if time.Now().After(t.Expires()) {
+		return ErrTokenExpired
+	}
+	for _, k := range t.Keys() {
+		if k == key || strings.HasSuffix(k, "/") && strings.HasPrefix(key, k) {
+			return nil
+		}
+	}
+	return ErrInvalidToken
+}
+
+type TokenExchangeRequest struct {
+	Keys []string `json:"keys"`
+
+	// TTL is the lifetime of the token, such as "10m". DefaultExchangeTTL is used if empty.
+	TTL string `json:"ttl,omitempty"`
+}
+
+type ExchangedToken struct {
+	Token   string    `json:"token"`
+	Keys    []string  `json:"keys"`
+	Expires time.Time `json:"expires"`
+}
+
+// ExchangeToken issues a read-only token for keys that the requesting token can publish.
+func (s Server) ExchangeToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	var req TokenExchangeRequest
+	if err := readJSON(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Invalid request:", err)
+		return
+	}
+	if err := verifyExchangeKeys(req.Keys); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	ttl := DefaultExchangeTTL
+	if req.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid TTL: it should be a positive duration such as \"10m\".")
+			return
+		}
+	}
+	if s.ExchangeMaxTTL > 0 && ttl > s.ExchangeMaxTTL {
+		ttl = s.ExchangeMaxTTL
+	}
+
+	secret := s.secretFor(req.Keys[0])
+	for _, k := range req.Keys {
+		// Exchanged tokens can not be exchanged again, because they do not have publish scope.
+		if !s.authorize(k, ScopePublish, w, r) {
+			return
+		}
+		if !hmac.Equal(s.secretFor(k), secret) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Keys in different namespaces can not be in one token.")
+			return
+		}
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	token, err := NewExchangedToken(secret, req.Keys, expires)
+	if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	PrintImportant("EXCHANGE", "%s %s: issued read-only token %s until %s", strings.Join(req.Keys, ","), r.RemoteAddr, token.Fingerprint(), expires.Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, ExchangedToken{token.String(), req.Keys, expires})
+}
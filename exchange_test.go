@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExchangedToken(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	other, _ := NewSecret()
+
+	keys := []string{"release/app.tar.gz", "docs/"}
+	token, err := NewExchangedToken(sec, keys, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to make token: %s", err)
+	}
+
+	parsed, err := ParseToken(token.String())
+	if err != nil {
+		t.Fatalf("failed to parse token: %s", err)
+	}
+	if !strings.HasPrefix(token.String(), "t3:") || parsed.Version() != 3 || parsed.Scope() != ScopeRead {
+		t.Fatalf("unexpected token: %s version=%d scope=%s", token, parsed.Version(), parsed.Scope())
+	}
+	if !reflect.DeepEqual(parsed.Keys(), keys) {
+		t.Errorf("unexpected keys: %v", parsed.Keys())
+	}
+
+	tests := []struct {
+		Secret Secret
+		Key    string
+		Expect bool
+	}{
+		{sec, "release/app.tar.gz", true},
+		{sec, "release/other.tar.gz", false},
+		{sec, "docs/index.html", true},
+		{sec, "docs", false},
+		{other, "release/app.tar.gz", false},
+	}
+	for _, tt := range tests {
+		if got := IsCorrentToken(tt.Secret, parsed, tt.Key); got != tt.Expect {
+			t.Errorf("%s: expected %v but got %v", tt.Key, tt.Expect, got)
+		}
+	}
+
+	expired, _ := NewExchangedToken(sec, keys, time.Now().Add(-time.Second))
+	if err := verifyExchangedToken(sec, expired, "release/app.tar.gz"); err != ErrTokenExpired {
+		t.Errorf("expired token should be rejected: %v", err)
+	}
+
+	tampered := append(Token{}, token...)
+	tampered[len(tampered)-1] = 'x'
+	if IsCorrentToken(sec, tampered, "docs/x") {
+		t.Errorf("tampered token should be rejected")
+	}
+
+	if _, err := NewExchangedToken(sec, []string{"/invalid"}, time.Now()); err == nil {
+		t.Errorf("invalid key should be rejected")
+	}
+}
+
+func TestExchangeTokenAPI(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	store := &LocalStore{Path: t.TempDir()}
+	s := Server{Secret: sec, Store: store, Private: []string{"internal/"}, ExchangeMaxTTL: time.Hour}
+
+	for _, key := range []string{"internal/a.bin", "internal/b.bin"} {
+		if _, err := store.Put(key, strings.NewReader("hello"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	request := func(method, path string, token Token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != nil {
+			r.Header.Set("Authorization", "bearer "+token.String())
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	publish, _ := NewToken(sec, "internal/")
+	other, _ := NewToken(sec, "other/")
+
+	if w := request("POST", "/_api/v1/tokens/exchange", other, `{"keys": ["internal/a.bin"]}`); w.Code != http.StatusForbidden {
+		t.Errorf("token for other prefix should not be exchanged: %d", w.Code)
+	}
+	if w := request("POST", "/_api/v1/tokens/exchange", publish, `{"keys": []}`); w.Code != http.StatusBadRequest {
+		t.Errorf("empty keys should be rejected: %d", w.Code)
+	}
+
+	start := time.Now()
+	w := request("POST", "/_api/v1/tokens/exchange", publish, `{"keys": ["internal/a.bin"], "ttl": "48h"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to exchange token: %d: %s", w.Code, w.Body.String())
+	}
+
+	var result ExchangedToken
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to parse response: %s", err)
+	}
+	if result.Expires.After(start.Add(time.Hour)) {
+		t.Errorf("TTL should be limited by ExchangeMaxTTL: %s", result.Expires)
+	}
+
+	token, err := ParseToken(result.Token)
+	if err != nil {
+		t.Fatalf("failed to parse exchanged token: %s", err)
+	}
+
+	if w := request("GET", "/internal/a.bin?rev=1", token, ""); w.Code != http.StatusOK {
+		t.Errorf("exchanged token should read the key: %d", w.Code)
+	}
+	if w := request("GET", "/internal/b.bin?rev=1", token, ""); w.Code != http.StatusForbidden {
+		t.Errorf("exchanged token should not read other keys: %d", w.Code)
+	}
+	if w := request("POST", "/internal/a.bin", token, "hacked"); w.Code != http.StatusForbidden {
+		t.Errorf("exchanged token should not publish: %d", w.Code)
+	}
+	if w := request("POST", "/_api/v1/tokens/exchange", token, `{"keys": ["internal/a.bin"]}`); w.Code != http.StatusForbidden {
+		t.Errorf("exchanged token should not be exchanged again: %d", w.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrDigestMismatch    = errors.New("Digest of the artifact does not match to the expected digest.")
+	ErrInvalidDigest     = errors.New("Invalid digest: md5 or sha256 digest in hex is required.")
+	ErrNoSuchExpectation = errors.New("No expectation for this key.")
+)
+
+type Expectation struct {
+	MD5    string `json:"md5,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+func (e Expectation) Normalize() (Expectation, error) {
+	e.MD5 = strings.ToLower(strings.TrimSpace(e.MD5))
+	e.SHA256 = strings.ToLower(strings.TrimSpace(e.SHA256))
+
+	if e.MD5 == "" && e.SHA256 == "" {
+		return e, ErrInvalidDigest
+	}
+	if e.MD5 != "" {
+		if b, err := hex.DecodeString(e.MD5); err != nil || len(b) != 16 {
+			return e, ErrInvalidDigest
+		}
+	}
+	if e.SHA256 != "" {
+		if b, err := hex.DecodeString(e.SHA256); err != nil || len(b) != 32 {
+			return e, ErrInvalidDigest
+		}
+	}
+
+	return e, nil
+}
+
+func (e Expectation) Match(meta Metadata) bool {
+	if e.MD5 != "" && e.MD5 != meta.Hash {
+		return false
+	}
+	if e.SHA256 != "" && e.SHA256 != meta.SHA256 {
+		return false
+	}
+	return true
+}
+
+// ExpectationStore records expected digests of the next publish of each key.
+// Expectations are kept only in memory, so they are lost when the server restarts.
+type ExpectationStore struct {
+	lock sync.Mutex
+	m    map[string]Expectation
+}
+
+func NewExpectationStore() *ExpectationStore {
+	return &ExpectationStore{m: make(map[string]Expectation)}
+}
+
+func (s *ExpectationStore) Get(key string) (Expectation, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.m[key]
+	return e, ok
+}
+
+func (s *ExpectationStore) Set(key string, e Expectation) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.m[key] = e
+}
+
+func (s *ExpectationStore) Delete(key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, ok := s.m[key]
+	delete(s.m, key)
+	return ok
+}
+
+// Fulfill removes the expectation for key if it is still the same as e.
+func (s *ExpectationStore) Fulfill(key string, e Expectation) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.m[key] == e {
+		delete(s.m, key)
+	}
+}
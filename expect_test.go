@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExpectationNormalize(t *testing.T) {
+	md5 := "5eb63bbbe01eeed093cb22bb8f5acdc3"
+	sha256 := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+	tests := []struct {
+		Input  Expectation
+		Output Expectation
+		Error  bool
+	}{
+		{Expectation{MD5: md5}, Expectation{MD5: md5}, false},
+		{Expectation{SHA256: sha256}, Expectation{SHA256: sha256}, false},
+		{Expectation{MD5: " " + strings.ToUpper(md5) + "\n", SHA256: strings.ToUpper(sha256)}, Expectation{MD5: md5, SHA256: sha256}, false},
+		{Expectation{}, Expectation{}, true},
+		{Expectation{MD5: "  "}, Expectation{}, true},
+		{Expectation{MD5: md5[:30]}, Expectation{}, true},
+		{Expectation{MD5: "zz" + md5[2:]}, Expectation{}, true},
+		{Expectation{SHA256: md5}, Expectation{}, true},
+		{Expectation{MD5: md5, SHA256: sha256 + "00"}, Expectation{}, true},
+	}
+
+	for _, tt := range tests {
+		e, err := tt.Input.Normalize()
+		if tt.Error {
+			if err != ErrInvalidDigest {
+				t.Errorf("%v: expected ErrInvalidDigest but got %v", tt.Input, err)
+			}
+		} else if err != nil {
+			t.Errorf("%v: unexpected error: %s", tt.Input, err)
+		} else if e != tt.Output {
+			t.Errorf("%v: expected %v but got %v", tt.Input, tt.Output, e)
+		}
+	}
+}
+
+func TestExpectationStore(t *testing.T) {
+	s := NewExpectationStore()
+	a := Expectation{MD5: "5eb63bbbe01eeed093cb22bb8f5acdc3"}
+	b := Expectation{MD5: "6f5902ac237024bdd0c176cb93063dc4"}
+
+	if _, ok := s.Get("foo.txt"); ok {
+		t.Errorf("empty store has an expectation")
+	}
+
+	s.Set("foo.txt", a)
+	if e, ok := s.Get("foo.txt"); !ok || e != a {
+		t.Errorf("unexpected expectation: %v %v", e, ok)
+	}
+
+	// Fulfill does not remove the expectation that is replaced while publishing.
+	s.Set("foo.txt", b)
+	s.Fulfill("foo.txt", a)
+	if e, ok := s.Get("foo.txt"); !ok || e != b {
+		t.Errorf("replaced expectation is removed: %v %v", e, ok)
+	}
+
+	s.Fulfill("foo.txt", b)
+	if _, ok := s.Get("foo.txt"); ok {
+		t.Errorf("fulfilled expectation remains")
+	}
+
+	s.Set("foo.txt", a)
+	if !s.Delete("foo.txt") {
+		t.Errorf("failed to delete expectation")
+	}
+	if s.Delete("foo.txt") {
+		t.Errorf("deleted expectation is deleted again")
+	}
+}
+
+func TestExpectationAPI(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewToken(sec, "p/")
+	read, _ := NewScopedToken(sec, "p/", ScopeRead)
+
+	s := Server{Secret: sec, Store: &LocalStore{Path: t.TempDir()}, Expectations: NewExpectationStore(), Uploads: NewUploadTracker()}
+
+	md5 := "5eb63bbbe01eeed093cb22bb8f5acdc3"
+
+	tests := []struct {
+		Method string
+		Path   string
+		Token  Token
+		Body   string
+		Status int
+		MD5    string
+	}{
+		{"GET", "/_api/v1/expectations/p/foo.txt", token, "", http.StatusNotFound, ""},
+		{"PUT", "/_api/v1/expectations/p/foo.txt", nil, `{"md5": "` + md5 + `"}`, http.StatusForbidden, ""},
+		{"PUT", "/_api/v1/expectations/p/foo.txt", read, `{"md5": "` + md5 + `"}`, http.StatusForbidden, ""},
+		{"PUT", "/_api/v1/expectations/other/foo.txt", token, `{"md5": "` + md5 + `"}`, http.StatusForbidden, ""},
+		{"PUT", "/_api/v1/expectations//p/foo.txt", token, `{"md5": "` + md5 + `"}`, http.StatusBadRequest, ""},
+		{"PUT", "/_api/v1/expectations/p/foo.txt", token, `{"md5": "xyz"}`, http.StatusBadRequest, ""},
+		{"PUT", "/_api/v1/expectations/p/foo.txt", token, `{}`, http.StatusBadRequest, ""},
+		{"PUT", "/_api/v1/expectations/p/foo.txt", token, `not a json`, http.StatusBadRequest, ""},
+		{"PUT", "/_api/v1/expectations/p/foo.txt", token, `{"md5": "` + strings.ToUpper(md5) + `"}`, http.StatusOK, md5},
+		{"GET", "/_api/v1/expectations/p/foo.txt", nil, "", http.StatusForbidden, ""},
+		{"GET", "/_api/v1/expectations/p/foo.txt", token, "", http.StatusOK, md5},
+		{"PATCH", "/_api/v1/expectations/p/foo.txt", token, "", http.StatusMethodNotAllowed, ""},
+		{"DELETE", "/_api/v1/expectations/p/foo.txt", token, "", http.StatusNoContent, ""},
+		{"DELETE", "/_api/v1/expectations/p/foo.txt", token, "", http.StatusNotFound, ""},
+		{"GET", "/_api/v1/expectations/p/foo.txt", token, "", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.Method, tt.Path, strings.NewReader(tt.Body))
+		if tt.Token != nil {
+			r.Header.Set("Authorization", "bearer "+tt.Token.String())
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != tt.Status {
+			t.Errorf("%s %s: expected status %d but got %d: %s", tt.Method, tt.Path, tt.Status, w.Code, w.Body)
+			continue
+		}
+		if tt.MD5 != "" {
+			var e Expectation
+			if err := json.NewDecoder(w.Body).Decode(&e); err != nil || e.MD5 != tt.MD5 {
+				t.Errorf("%s %s: unexpected response: %v: %v", tt.Method, tt.Path, e, err)
+			}
+		}
+	}
+}
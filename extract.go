@@ -0,0 +1,316 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// MaxExtractEntries is the maximum number of files in an archive to extract on publish.
+var MaxExtractEntries = 10000
+
+var (
+	ErrTooManyEntries = fmt.Errorf("Too many files in the archive: it should be %d or less.", MaxExtractEntries)
+	ErrEmptyArchive   = errors.New("No files in the archive.")
+	ErrInvalidArchive = errors.New("Invalid archive")
+)
+
+// errResponded is returned from callbacks of eachArchiveEntry when the error response has already been written.
+var errResponded = errors.New("already responded")
+
+// detectPrefixArchiveFormat guesses the format from the magic bytes of the archive.
+func detectPrefixArchiveFormat(f io.ReadSeeker) (PrefixArchiveFormat, error) {
+	var head [4]byte
+	n, err := io.ReadFull(f, head[:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(head[:n], []byte{0x1f, 0x8b}):
+		return PrefixArchiveTarGz, nil
+	case bytes.HasPrefix(head[:n], []byte("PK\x03\x04")):
+		return PrefixArchiveZip, nil
+	default:
+		return PrefixArchiveTar, nil
+	}
+}
+
+// eachArchiveEntry calls fn for each regular file in the archive. Directories and links are ignored.
+// The name is cleaned, so that it can not go out of the prefix by "..".
+func eachArchiveEntry(format PrefixArchiveFormat, f *os.File, size int64, fn func(name string, size int64, r io.Reader) error) error {
+	count := 0
+	visit := func(name string, size int64, r io.Reader) error {
+		count++
+		if count > MaxExtractEntries {
+			return ErrTooManyEntries
+		}
+		return fn(cleanEntryName(name), size, r)
+	}
+
+	if format == PrefixArchiveZip {
+		zr, err := zip.NewReader(f, size)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+		}
+		for _, e := range zr.File {
+			if !e.Mode().IsRegular() {
+				continue
+			}
+			r, err := e.Open()
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+			}
+			err = visit(e.Name, int64(e.UncompressedSize64), r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var r io.Reader = f
+	if format == PrefixArchiveTarGz {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := visit(h.Name, h.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// extractArchive publishes each file in the uploaded archive as PREFIX/NAME, by "POST /PREFIX/?extract=FORMAT".
+// The format is detected from the content if FORMAT is empty or "1".
+//
+// Files are staged into a transaction and published all together, so that clients never see a half of the archive.
+func (s Server) extractArchive(prefix string, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !strings.HasSuffix(prefix, "/") {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Archive can only be extracted to a prefix that ends with slash.")
+		return
+	}
+	if s.Transactions == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "Transactions are not enabled on this server.")
+		return
+	}
+	if !s.authorize(prefix, ScopePublish, w, r) {
+		return
+	}
+
+	var format PrefixArchiveFormat
+	if v := r.URL.Query().Get("extract"); v != "" && v != "1" {
+		var err error
+		if format, err = ParsePrefixArchiveFormat(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
+			return
+		}
+	}
+
+	labels, err := parseLabelHeaders(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	// The archive is saved into a file first, because zip can not be read as a stream.
+	f, err := os.CreateTemp(s.Transactions.Dir, "artistore-extract-")
+	if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, r.Body)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err == nil && format == "" {
+		format, err = detectPrefixArchiveFormat(f)
+	}
+	if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	t := s.Transactions.Begin(prefix)
+	committing := false
+	defer func() {
+		if !committing {
+			s.Transactions.Abort(t)
+		}
+	}()
+
+	err = eachArchiveEntry(format, f, size, func(name string, size int64, body io.Reader) error {
+		key := prefix + name
+		if err := VerifyKey(key); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if !s.authorize(key, ScopePublish, w, r) || !s.checkPolicy(key, labels, size, w, r) {
+			return errResponded
+		}
+
+		if s.Validator != nil {
+			member := r.Clone(r.Context())
+			member.Body = io.NopCloser(body)
+			member.ContentLength = size
+
+			var err error
+			body, err = s.Validator.Validate(key, member)
+			var verr ValidationError
+			if errors.As(err, &verr) {
+				PrintWarn("REJECT", "%s %s: %s", key, r.RemoteAddr, verr.Message)
+				w.WriteHeader(verr.Status)
+				fmt.Fprintln(w, verr.Message)
+				return errResponded
+			} else if err != nil {
+				return err
+			}
+		}
+
+		body, finishScan, err := s.scanBody(key, body)
+		if err != nil {
+			return err
+		}
+		defer finishScan()
+
+		_, err = s.Transactions.Stage(t, key, labels, body)
+		var detection ScanDetection
+		if errors.As(err, &detection) {
+			s.rejectInfected(key, detection, w, r)
+			return errResponded
+		}
+		return err
+	})
+	if err == errResponded {
+		return
+	} else if errors.Is(err, ErrInvalidArchive) || errors.Is(err, ErrInvalidKey) || errors.Is(err, ErrSlashKey) || err == ErrTooManyEntries {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	if len(t.Status().Artifacts) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, ErrEmptyArchive)
+		return
+	}
+
+	committing = true
+	committed, err := s.commitTransaction(t)
+	if err != nil {
+		writeCommitError(t, err, w, r)
+		return
+	}
+
+	PrintImportant("EXTRACT", "%s %d artifacts from %s archive by %s", prefix, len(committed), format, r.RemoteAddr)
+	writeJSON(w, http.StatusCreated, TransactionResult{t.ID, committed})
+}
+
+// PublishExtract uploads the archive file to be extracted into the prefix on the server.
+func PublishExtract(client *Client, t TokenHandler, prefix, file string) ([]ArtifactResult, error) {
+	if !strings.HasSuffix(prefix, "/") {
+		return nil, fmt.Errorf("Invalid --prefix: %q should end with slash to extract archive.", prefix)
+	}
+	u, err := GetPrefixURL(prefix)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("extract", "1")
+	if format, err := PrefixArchiveFormatOf(file); err == nil {
+		q.Set("extract", string(format))
+	}
+	u.RawQuery = q.Encode()
+
+	token, err := t.TokenFor(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	resp, err := client.Do(func() (*http.Request, error) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest("POST", u.String(), io.NopCloser(f))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Authorization", "bearer "+token.String())
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+
+	var result TransactionResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	results := make([]ArtifactResult, len(result.Artifacts))
+	for i, a := range result.Artifacts {
+		loc, err := u.Parse(a.Location)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = ArtifactResult{Key: a.Key, Revision: a.Revision, URL: loc.String()}
+	}
+	return results, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestExtractArchive(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	_, request := newTransactionServer(t, store)
+
+	tests := []struct {
+		Name  string
+		Query string
+		Body  []byte
+	}{
+		{"tar.gz", "?extract=tar.gz", makeTarGz(t, map[string]string{"index.html": "<h1>hello</h1>", "assets/app.js": "app"})},
+		{"auto", "?extract=1", makeTarGz(t, map[string]string{"index.html": "<h1>hello</h1>", "assets/app.js": "app"})},
+		{"zip", "?extract=zip", makeZip(t, map[string]string{"index.html": "<h1>hello</h1>", "assets/app.js": "app"})},
+		{"tar", "?extract=tar", makeTar(t, map[string]string{"index.html": "<h1>hello</h1>", "assets/app.js": "app"})},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			w := request("POST", "/release/site/"+tt.Query, string(tt.Body))
+			if w.Code != http.StatusCreated {
+				t.Fatalf("unexpected status: %d: %s", w.Code, w.Body.String())
+			}
+
+			var result TransactionResult
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("failed to parse result: %s", err)
+			}
+			if len(result.Artifacts) != 2 {
+				t.Fatalf("unexpected artifacts: %v", result.Artifacts)
+			}
+
+			for key, content := range map[string]string{"release/site/index.html": "<h1>hello</h1>", "release/site/assets/app.js": "app"} {
+				rev, err := store.Latest(key)
+				if err != nil || rev != i+1 {
+					t.Fatalf("%s: unexpected latest revision: %d: %v", key, rev, err)
+				}
+				f, _, err := store.Get(key, rev)
+				if err != nil {
+					t.Fatalf("%s: failed to get: %s", key, err)
+				}
+				b, _ := io.ReadAll(f)
+				f.Close()
+				if string(b) != content {
+					t.Errorf("%s: unexpected content: %q", key, b)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractArchive_Invalid(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	_, request := newTransactionServer(t, store)
+
+	tests := []struct {
+		Name string
+		Path string
+		Body []byte
+		Code int
+	}{
+		{"not prefix", "/release/site?extract=1", makeZip(t, map[string]string{"a.txt": "a"}), http.StatusBadRequest},
+		{"broken", "/release/site/?extract=zip", []byte("not a zip"), http.StatusBadRequest},
+		{"empty", "/release/site/?extract=zip", makeZip(t, nil), http.StatusBadRequest},
+		{"unknown format", "/release/site/?extract=rar", makeZip(t, map[string]string{"a.txt": "a"}), http.StatusBadRequest},
+		{"out of scope", "/other/?extract=zip", makeZip(t, map[string]string{"a.txt": "a"}), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if w := request("POST", tt.Path, string(tt.Body)); w.Code != tt.Code {
+				t.Errorf("expected %d but got %d: %s", tt.Code, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if keys, _ := store.List(""); len(keys) != 0 {
+		t.Errorf("nothing should be published but got %v", keys)
+	}
+}
+
+func TestExtractArchive_TraversalIsContained(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	_, request := newTransactionServer(t, store)
+
+	body := makeTarGz(t, map[string]string{"../../evil.txt": "evil"})
+	if w := request("POST", "/release/site/?extract=tar.gz", string(body)); w.Code != http.StatusCreated {
+		t.Fatalf("unexpected status: %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := store.Latest("release/site/evil.txt"); err != nil {
+		t.Errorf("entry should be published under the prefix: %s", err)
+	}
+}
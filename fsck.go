@@ -0,0 +1,448 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Verify integrity of stored artifacts",
+	Long: `Verify integrity of stored artifacts.
+
+This command reads every revision in the store, and checks its gzip stream, size, MD5, and SHA-256.
+With --quarantine flag, broken files are renamed to "REVISION.corrupt" so that the server does not serve them again.
+Temporary files left by interrupted publishes are also removed with --quarantine flag.
+The metadata index of each key is rebuilt from revision files as well.
+
+By default, this command checks the local data directory specified by --store.
+If --server is specified, it asks the server to check its store instead. This requires admin token.`,
+	Example: `  $ artistore fsck --store /var/lib/artistore
+  $ artistore fsck --server https://artifacts.example.com --token $(artistore token --admin)`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		quarantine, _ := cmd.Flags().GetBool("quarantine")
+		verbose, _ := cmd.Flags().GetBool("verbose")
+
+		var report CheckReport
+		if viper.GetString("server") != "" {
+			t, err := NewTokenHandler()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+
+			token, err := t.TokenFor(APIPrefix)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+
+			u, err := GetAPIURL("v1/fsck")
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			if quarantine {
+				u.RawQuery = "quarantine=1"
+			}
+
+			client, err := NewClient()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+
+			if err := client.CallAPI("POST", u, token, nil, &report); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		} else {
+			store, err := NewLocalStore(viper.GetString("store"), RetainPolicy{})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if n, _ := cmd.Flags().GetInt("concurrency"); n > 1 {
+				store.HashPool = NewHashPool(n, n)
+			}
+
+			report, err = store.Check(quarantine, func(r CheckResult) {
+				if verbose && r.Error == "" {
+					fmt.Printf("OK %s#%d\n", r.Key, r.Revision)
+				}
+			})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+
+		for _, r := range report.Corrupted {
+			if r.Quarantined {
+				fmt.Printf("CORRUPT %s#%d: %s (quarantined)\n", r.Key, r.Revision, r.Error)
+			} else {
+				fmt.Printf("CORRUPT %s#%d: %s\n", r.Key, r.Revision, r.Error)
+			}
+		}
+		fmt.Printf("%d revisions checked, %d corrupted.\n", report.Checked, len(report.Corrupted))
+
+		if len(report.Corrupted) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(fsckCmd)
+
+	fsckCmd.Flags().String("store", "/var/lib/artistore", "Path to data directory.")
+	fsckCmd.Flags().String("server", "", "URL for Artistore server to check remotely.")
+	fsckCmd.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+	fsckCmd.Flags().String("token", "", "Admin token. See also 'artistore help token'.")
+	fsckCmd.Flags().Bool("quarantine", false, "Rename corrupted files so that the server does not use them.")
+	fsckCmd.Flags().BoolP("verbose", "v", false, "Show healthy revisions too.")
+	fsckCmd.Flags().IntP("concurrency", "j", 1, "Number of revisions to verify in parallel. It is used only for the local data directory.")
+}
+
+type CheckResult struct {
+	Key         string `json:"key"`
+	Revision    int    `json:"revision"`
+	Error       string `json:"error,omitempty"`
+	Quarantined bool   `json:"quarantined,omitempty"`
+}
+
+type CheckReport struct {
+	Checked   int           `json:"checked"`
+	Corrupted []CheckResult `json:"corrupted"`
+}
+
+// verifyRevision verifies the header of the revision file, and the content too if full is true.
+func verifyRevision(fname, key string, rev int, full bool) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("invalid gzip header: %s", err)
+	}
+
+	meta, err := decodeMetadata(z.Extra)
+	if err != nil {
+		return err
+	}
+	if z.Name != key {
+		return fmt.Errorf("key mismatch: recorded %q", z.Name)
+	}
+	if meta.Revision != rev {
+		return fmt.Errorf("revision mismatch: recorded %d", meta.Revision)
+	}
+	if !full {
+		return nil
+	}
+
+	m := md5.New()
+	s := sha256.New()
+	size, err := io.Copy(io.MultiWriter(m, s), z)
+	if err != nil {
+		return fmt.Errorf("broken data: %s", err)
+	}
+
+	if size != int64(meta.Size) {
+		return fmt.Errorf("size mismatch: recorded %d but actual %d", meta.Size, size)
+	}
+	if h := fmt.Sprintf("%032x", m.Sum(nil)); h != meta.Hash {
+		return fmt.Errorf("md5 mismatch: recorded %s but actual %s", meta.Hash, h)
+	}
+	if h := fmt.Sprintf("%064x", s.Sum(nil)); meta.SHA256 != "" && h != meta.SHA256 {
+		return fmt.Errorf("sha256 mismatch: recorded %s but actual %s", meta.SHA256, h)
+	}
+
+	return nil
+}
+
+// StaleTempFileAge is the age to consider the temporary file is left by crash.
+var StaleTempFileAge = time.Hour
+
+func (s *LocalStore) removeStaleTempFile(key, fname string) {
+	stat, err := os.Stat(fname)
+	if err != nil || time.Since(stat.ModTime()) < StaleTempFileAge {
+		return
+	}
+
+	if err := os.Remove(fname); err != nil {
+		PrintErr("ERROR", "failed to remove stale temporary file of %s: %s", key, err)
+	} else {
+		PrintWarn("FSCK", "removed stale temporary file of %s: %s", key, filepath.Base(fname))
+	}
+}
+
+// CorruptSuffix is the suffix of quarantined revision files.
+const CorruptSuffix = ".corrupt"
+
+func (s *LocalStore) quarantine(fname string) error {
+	return os.Rename(fname, fname+CorruptSuffix)
+}
+
+// quarantineCorrupt quarantines the revision that turned out to be corrupted while reading, and removes it from the index.
+func (s *LocalStore) quarantineCorrupt(key string, revision int, cause error) {
+	err := s.quarantine(filepath.Join(s.keyDir(key), revisionFileName(revision)))
+	if errors.Is(err, os.ErrNotExist) {
+		// Another request has already quarantined it.
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "failed to quarantine %s#%d: %s", key, revision, err)
+		return
+	}
+
+	atomic.AddInt64(&s.corrupted, 1)
+	PrintErr("CORRUPT", "%s#%d: %s (quarantined)", key, revision, cause)
+
+	if err := s.updateIndex(key, func(idx *storeIndex) { idx.Remove(revision) }); err != nil {
+		PrintErr("ERROR", "failed to update index of %s: %s", key, err)
+	}
+}
+
+// CorruptRevisions returns the number of revisions quarantined while reading since the store opened.
+func (s *LocalStore) CorruptRevisions() int64 {
+	return atomic.LoadInt64(&s.corrupted)
+}
+
+// verifyRevisions verifies revisions of the key in HashPool as background jobs.
+// Revisions are verified concurrently as far as the pool allows, and the errors are returned in the same order as revs.
+// Only headers are verified without HashPool if full is false.
+func (s *LocalStore) verifyRevisions(key string, revs []int, full bool) []error {
+	errs := make([]error, len(revs))
+	if s.HashPool == nil || !full {
+		for i, rev := range revs {
+			errs[i] = verifyRevision(filepath.Join(s.keyDir(key), revisionFileName(rev)), key, rev, full)
+		}
+		return errs
+	}
+
+	var wg sync.WaitGroup
+	for i, rev := range revs {
+		wg.Add(1)
+		go func(i, rev int) {
+			defer wg.Done()
+			errs[i] = s.HashPool.Do(HashBackground, func() error {
+				return verifyRevision(filepath.Join(s.keyDir(key), revisionFileName(rev)), key, rev, true)
+			})
+		}(i, rev)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// Check verifies all revisions in the store, and rebuilds the index.
+// The callback is called for each revisions, both of healthy and corrupted.
+func (s *LocalStore) Check(quarantine bool, callback func(CheckResult)) (CheckReport, error) {
+	return s.check(quarantine, true, callback)
+}
+
+func (s *LocalStore) check(quarantine, full bool, callback func(CheckResult)) (CheckReport, error) {
+	report := CheckReport{Corrupted: []CheckResult{}}
+
+	keys, err := s.List("")
+	if err != nil {
+		return report, err
+	}
+
+	for _, key := range keys {
+		dirname := s.keyDir(key)
+
+		xs, err := os.ReadDir(dirname)
+		if err != nil {
+			return report, err
+		}
+
+		var revs []int
+		for _, x := range xs {
+			rev, temp, err := parseRevisionFile(x.Name())
+			if err != nil {
+				continue
+			}
+			if !temp {
+				revs = append(revs, rev)
+			} else if quarantine {
+				s.removeStaleTempFile(key, filepath.Join(dirname, x.Name()))
+			}
+		}
+		sort.Ints(revs)
+
+		errs := s.verifyRevisions(key, revs, full)
+
+		for i, rev := range revs {
+			report.Checked++
+
+			fname := filepath.Join(dirname, revisionFileName(rev))
+			result := CheckResult{Key: key, Revision: rev}
+
+			if err := errs[i]; err != nil {
+				result.Error = err.Error()
+
+				if quarantine {
+					if err := s.quarantine(fname); err != nil {
+						PrintErr("ERROR", "failed to quarantine %s#%d: %s", key, rev, err)
+					} else {
+						result.Quarantined = true
+					}
+				}
+
+				report.Corrupted = append(report.Corrupted, result)
+			}
+
+			if callback != nil {
+				callback(result)
+			}
+		}
+
+		if err := s.RebuildIndex(key); err != nil {
+			PrintErr("ERROR", "failed to rebuild index of %s: %s", key, err)
+		}
+	}
+
+	return report, nil
+}
+
+// Modes of --verify-on-start.
+const (
+	// VerifyQuick checks the layout of the data directory and headers of revision files.
+	VerifyQuick = "quick"
+
+	// VerifyFull checks digests of all revisions in addition to VerifyQuick.
+	VerifyFull = "full"
+)
+
+// checkLayout reports entries in the data directory that are not a part of the store.
+// They are only reported and never removed, because they may be put by the administrator.
+func (s *LocalStore) checkLayout() (anomalies []string, err error) {
+	shards, err := os.ReadDir(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, a := range shards {
+		if a.Name() == LayoutFileName || a.Name() == TerraformLockDirName {
+			continue
+		}
+		if !a.IsDir() || !shardRegexp.MatchString(a.Name()) {
+			anomalies = append(anomalies, fmt.Sprintf("unknown entry: %s", a.Name()))
+			continue
+		}
+
+		subs, err := os.ReadDir(filepath.Join(s.Path, a.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range subs {
+			if !b.IsDir() || !shardRegexp.MatchString(b.Name()) {
+				anomalies = append(anomalies, fmt.Sprintf("unknown entry: %s", filepath.Join(a.Name(), b.Name())))
+				continue
+			}
+
+			xs, err := os.ReadDir(filepath.Join(s.Path, a.Name(), b.Name()))
+			if err != nil {
+				return nil, err
+			}
+			for _, x := range xs {
+				name := filepath.Join(a.Name(), b.Name(), x.Name())
+				key := s.unescape(x.Name())
+				switch {
+				case !x.IsDir():
+					anomalies = append(anomalies, fmt.Sprintf("unknown entry: %s", name))
+				case VerifyKey(key) != nil:
+					anomalies = append(anomalies, fmt.Sprintf("invalid key: %s", name))
+				case filepath.Join(a.Name(), b.Name()) != s.shard(key):
+					anomalies = append(anomalies, fmt.Sprintf("key in wrong shard: %s", name))
+				}
+			}
+		}
+	}
+
+	return anomalies, nil
+}
+
+// VerifyOnStart checks the data directory before the server starts, and quarantines corrupted revisions.
+// It returns the number of anomalies found.
+func (s *LocalStore) VerifyOnStart(mode string) (int, error) {
+	if mode != VerifyQuick && mode != VerifyFull {
+		return 0, fmt.Errorf("Invalid --verify-on-start: %q: it should be %q or %q.", mode, VerifyQuick, VerifyFull)
+	}
+
+	start := time.Now()
+	PrintImportant("VERIFY", "checking data directory %s (%s)", s.Path, mode)
+
+	anomalies, err := s.checkLayout()
+	if err != nil {
+		return 0, err
+	}
+	for _, a := range anomalies {
+		PrintWarn("VERIFY", "%s", a)
+	}
+
+	report, err := s.check(true, mode == VerifyFull, nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range report.Corrupted {
+		if c.Quarantined {
+			PrintErr("CORRUPT", "%s#%d: %s (quarantined)", c.Key, c.Revision, c.Error)
+		} else {
+			PrintErr("CORRUPT", "%s#%d: %s", c.Key, c.Revision, c.Error)
+		}
+	}
+
+	PrintImportant("VERIFY", "%d revisions checked, %d corrupted, %d other anomalies in %s", report.Checked, len(report.Corrupted), len(anomalies), time.Since(start).Round(time.Millisecond))
+	return len(report.Corrupted) + len(anomalies), nil
+}
+
+func (s Server) Fsck(path string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+		return
+	}
+
+	quarantine := r.URL.Query().Get("quarantine") == "1"
+	PrintImportant("FSCK", "started by %s (quarantine=%v)", r.RemoteAddr, quarantine)
+
+	report, err := s.Store.Check(quarantine, nil)
+	if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	for _, c := range report.Corrupted {
+		PrintErr("CORRUPT", "%s#%d: %s", c.Key, c.Revision, c.Error)
+	}
+	PrintImportant("FSCK", "%d revisions checked, %d corrupted", report.Checked, len(report.Corrupted))
+
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStoreCheck(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.Put("hello", bytes.NewBufferString("hello world, this is a test\n"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	fname := filepath.Join(store.keyDir("hello"), revisionFileName(2))
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatalf("failed to read revision file: %s", err)
+	}
+	if err := os.WriteFile(fname, data[:len(data)-6], 0644); err != nil {
+		t.Fatalf("failed to break revision file: %s", err)
+	}
+
+	report, err := store.Check(false, nil)
+	if err != nil {
+		t.Fatalf("failed to check: %s", err)
+	}
+	if report.Checked != 3 || len(report.Corrupted) != 1 || report.Corrupted[0].Revision != 2 {
+		t.Fatalf("unexpected report: %#v", report)
+	}
+
+	report, err = store.Check(true, nil)
+	if err != nil {
+		t.Fatalf("failed to check: %s", err)
+	}
+	if len(report.Corrupted) != 1 || !report.Corrupted[0].Quarantined {
+		t.Fatalf("corrupted revision should be quarantined: %#v", report)
+	}
+	if _, err := os.Stat(fname + ".corrupt"); err != nil {
+		t.Errorf("quarantined file not found: %s", err)
+	}
+
+	report, err = store.Check(false, nil)
+	if err != nil {
+		t.Fatalf("failed to check: %s", err)
+	}
+	if report.Checked != 2 || len(report.Corrupted) != 0 {
+		t.Fatalf("unexpected report after quarantine: %#v", report)
+	}
+}
+
+func TestLocalStoreQuarantineOnRead(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+
+	for i := 0; i < 2; i++ {
+		if _, err := store.Put("hello", bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	fname := filepath.Join(store.keyDir("hello"), revisionFileName(2))
+	if err := os.WriteFile(fname, []byte("broken"), 0644); err != nil {
+		t.Fatalf("failed to break revision file: %s", err)
+	}
+
+	s := Server{Store: store}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/hello?rev=latest", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("corrupted revision should be 404 but got %d: %s", w.Code, w.Body)
+	}
+
+	if _, err := os.Stat(fname + CorruptSuffix); err != nil {
+		t.Errorf("corrupted revision should be quarantined: %s", err)
+	}
+	if n := store.CorruptRevisions(); n != 1 {
+		t.Errorf("unexpected number of corrupt revisions: %d", n)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/hello?rev=latest", nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-Artistore-Revision") != "1" {
+		t.Errorf("latest should fall back to revision 1: %d %s", w.Code, w.Header().Get("X-Artistore-Revision"))
+	}
+
+	if _, _, err := store.Get("hello", 2); err != ErrNoSuchArtifact {
+		t.Errorf("expected ErrNoSuchArtifact but got %v", err)
+	}
+	if n := store.CorruptRevisions(); n != 1 {
+		t.Errorf("quarantined revision should be counted only once: %d", n)
+	}
+
+	if rev, err := store.Put("hello", bytes.NewBufferString("hello again"), PutOptions{}); err != nil || rev != 3 {
+		t.Errorf("revision number of the quarantined revision should not be reused: %d: %v", rev, err)
+	}
+}
+
+func TestLocalStoreVerifyOnStart(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.Put("hello", bytes.NewBufferString("hello world, this is a test\n"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	// Revision 2 has a valid header but broken content, and revision 3 is broken entirely.
+	fname2 := filepath.Join(store.keyDir("hello"), revisionFileName(2))
+	data, err := os.ReadFile(fname2)
+	if err != nil {
+		t.Fatalf("failed to read revision file: %s", err)
+	}
+	if err := os.WriteFile(fname2, data[:len(data)-6], 0644); err != nil {
+		t.Fatalf("failed to break revision file: %s", err)
+	}
+	fname3 := filepath.Join(store.keyDir("hello"), revisionFileName(3))
+	if err := os.WriteFile(fname3, []byte("broken"), 0644); err != nil {
+		t.Fatalf("failed to break revision file: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(store.Path, "unknown.txt"), nil, 0644); err != nil {
+		t.Fatalf("failed to write unknown file: %s", err)
+	}
+
+	if _, err := store.VerifyOnStart("everything"); err == nil {
+		t.Errorf("expected error for invalid mode")
+	}
+
+	n, err := store.VerifyOnStart(VerifyQuick)
+	if err != nil {
+		t.Fatalf("failed to verify: %s", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 anomalies but got %d", n)
+	}
+	if _, err := os.Stat(fname3 + CorruptSuffix); err != nil {
+		t.Errorf("revision 3 should be quarantined: %s", err)
+	}
+	if _, err := os.Stat(fname2); err != nil {
+		t.Errorf("quick mode should not check content of revision 2: %s", err)
+	}
+	if latest, err := store.Latest("hello"); err != nil || latest != 2 {
+		t.Errorf("latest should be 2 but got %d: %v", latest, err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Path, "unknown.txt")); err != nil {
+		t.Errorf("unknown file should be kept: %s", err)
+	}
+
+	n, err = store.VerifyOnStart(VerifyFull)
+	if err != nil {
+		t.Fatalf("failed to verify: %s", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 anomalies but got %d", n)
+	}
+	if _, err := os.Stat(fname2 + CorruptSuffix); err != nil {
+		t.Errorf("revision 2 should be quarantined in full mode: %s", err)
+	}
+	if latest, err := store.Latest("hello"); err != nil || latest != 1 {
+		t.Errorf("latest should be 1 but got %d: %v", latest, err)
+	}
+}
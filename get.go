@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -14,10 +16,33 @@ import (
 var getCmd = &cobra.Command{
 	Use:   "get FILE_KEY",
 	Short: "Get an artifact from Artistore",
-	Long:  "Get an artifact from Artistore.",
-	Args:  cobra.ExactArgs(1),
+	Long: `Get an artifact from Artistore.
+
+With --output-file, the artifact is downloaded into "FILE` + partSuffix + `" first, and renamed to FILE after the digest is verified.
+If the download is interrupted, run the same command again to resume it by Range requests from the saved state in "FILE` + partialSuffix + `".
+Ctrl+C aborts the transfer but keeps these files to resume it; an incomplete --archive is removed.
+
+With --archive, the latest revisions of all artifacts under the prefix are downloaded as an archive.
+The format is chosen by the extension of the file name: .tar, .tar.gz, .tgz, or .zip.
+
+With --parallel, the artifact is downloaded by concurrent Range requests instead of a single connection.
+
+With --output json, the key, revision, URL, and MD5 of the downloaded artifact are printed as JSON after the download.
+With --quiet, only the URL of the downloaded revision is printed. Both of them require --output-file.`,
+	Example: `  $ artistore get hello.txt
+  $ artistore get -o large.iso large.iso
+  $ artistore get -o large.iso --parallel 4 large.iso
+  $ artistore get -o large.iso --output json large.iso
+  $ artistore get --archive release.zip release/1.0/`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		u, err := GetURL(args[0])
+		archive, _ := cmd.Flags().GetString("archive")
+		getURL := GetURL
+		if archive != "" {
+			getURL = GetPrefixURL
+		}
+
+		u, err := getURL(args[0])
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(2)
@@ -32,31 +57,118 @@ var getCmd = &cobra.Command{
 			u.RawQuery = q.Encode()
 		}
 
-		resp, err := http.Get(u.String())
+		client, err := NewClient()
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Failed to fetch:", err)
-			os.Exit(1)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			io.Copy(os.Stdout, resp.Body)
-			os.Exit(1)
+		client.Context = interruptContext()
+
+		// Token is optional because only keys under --private prefixes of the server require it.
+		if t, err := NewTokenHandler(); err == nil {
+			client.ReadToken, err = t.ScopedTokenFor(args[0], ScopeRead)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		} else if err != ErrNoClientCredential {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		if archive != "" {
+			if u.Query().Has("rev") {
+				fmt.Fprintln(os.Stderr, "--archive can not be used with --revision.")
+				os.Exit(2)
+			}
+			if err := DownloadPrefixArchive(client, u, archive); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to fetch:", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		fname, _ := cmd.Flags().GetString("output-file")
+
+		// --output was the output file name before it became the output format, so other values are still taken as a file name.
+		if raw, _ := cmd.Flags().GetString("output"); fname == "" && raw != string(OutputText) && raw != string(OutputJSON) {
+			PrintWarn("WARN", "--output FILE is deprecated; use -o or --output-file instead.")
+			fname = raw
+			cmd.Flags().Set("output", string(OutputText))
 		}
 
-		output := os.Stdout
-		if fname, err := cmd.Flags().GetString("output"); err != nil {
+		format, err := getOutputFormat(cmd)
+		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(2)
-		} else if fname != "" {
-			output, err = os.Create(fname)
+		}
+		if format != OutputText && fname == "" {
+			fmt.Fprintln(os.Stderr, "--output json and --quiet require --output-file.")
+			os.Exit(2)
+		}
+
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		if parallel < 1 {
+			fmt.Fprintln(os.Stderr, "Invalid --parallel: it should be 1 or more.")
+			os.Exit(2)
+		}
+		if parallel > 1 {
+			if fname == "" {
+				fmt.Fprintln(os.Stderr, "--parallel requires --output-file.")
+				os.Exit(2)
+			}
+
+			raw, _ := cmd.Flags().GetString("chunk-size")
+			chunkSize, err := ParseSize(raw)
+			if err != nil || chunkSize <= 0 {
+				fmt.Fprintf(os.Stderr, "Invalid --chunk-size: %s\n", raw)
+				os.Exit(2)
+			}
+
+			loc, err := ParallelDownload{
+				Client:    client,
+				URL:       u,
+				Output:    fname,
+				Workers:   parallel,
+				ChunkSize: chunkSize,
+			}.Run()
+			if err == nil {
+				printDownload(format, args[0], loc, fname)
+				return
+			} else if err != ErrRangeNotSupported {
+				fmt.Fprintln(os.Stderr, "Failed to fetch:", err)
+				os.Exit(1)
+			}
+			PrintWarn("WARN", "%s; fall back to a single connection.", strings.TrimSuffix(err.Error(), "."))
+		}
+
+		if fname != "" {
+			loc, err := ContinueDownload(client, u, fname)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, "Failed to create output file:", err)
+				fmt.Fprintln(os.Stderr, "Failed to fetch:", err)
 				os.Exit(1)
 			}
+			printDownload(format, args[0], loc, fname)
+			return
+		}
+
+		resp, err := client.Get(u)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to fetch:", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(os.Stdout, resp.Body)
+			os.Exit(1)
 		}
 
-		io.Copy(output, resp.Body)
+		if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to fetch:", err)
+			os.Exit(1)
+		}
 	},
 }
 
@@ -66,6 +178,42 @@ func init() {
 	getCmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
 	viper.BindPFlag("server", getCmd.Flags().Lookup("server"))
 
+	getCmd.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+	getCmd.Flags().String("token", "", "Client token with read scope, to get artifacts under --private prefixes of the server. See also 'artistore help token'.")
+
 	getCmd.Flags().IntP("revision", "r", 0, "Revision of the artifact. (default latest)")
-	getCmd.Flags().StringP("output", "o", "", "Output file name. (default stdout)")
+	getCmd.Flags().StringP("output-file", "o", "", "Output file name. (default stdout)")
+	getCmd.Flags().BoolP("continue", "c", false, "Resume the download into --output-file if it is interrupted before.")
+	getCmd.Flags().MarkDeprecated("continue", "downloads into --output-file are always resumed.")
+	getCmd.Flags().Int("parallel", 1, "Number of concurrent Range requests. It requires --output-file.")
+	getCmd.Flags().String("chunk-size", "8MB", "Size of each Range request for --parallel.")
+	getCmd.Flags().String("archive", "", "Download all artifacts under the prefix into the archive file, such as release.tar.gz or release.zip.")
+	addOutputFlags(getCmd)
+
+	addRateLimitFlag(getCmd)
+	addTimeoutFlags(getCmd)
+	addTLSFlags(getCmd)
+	addRetryFlags(getCmd)
+}
+
+// printDownload prints the result of the download into fname, if the format is not text.
+func printDownload(format OutputFormat, key string, loc *url.URL, fname string) {
+	if format == OutputText {
+		return
+	}
+
+	result := ArtifactResult{Key: key, URL: loc.String()}
+	result.Revision, _ = strconv.Atoi(loc.Query().Get("rev"))
+
+	var err error
+	if result.Hash, err = fileMD5(fname); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if format == OutputJSON {
+		printJSON(result)
+	} else {
+		fmt.Println(result.URL)
+	}
 }
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrAssetChecksumMismatch = errors.New("SHA256 of the downloaded asset does not match to the checksum of the release.")
+	ErrNoChecksum            = errors.New("No checksum for the asset in the release.")
+)
+
+var gitHubRepoRegexp = regexp.MustCompile(`^[^/]+/[^/]+$`)
+
+var importGitHubCmd = &cobra.Command{
+	Use:   "import-github",
+	Short: "Publish assets of a GitHub release",
+	Long: `Publish assets of a GitHub release.
+
+Each asset is downloaded and published as PREFIX/ASSET_NAME.
+The SHA256 of each asset is verified by the digest that GitHub reports, or by checksum files in the release such as "SHA256SUMS", "checksums.txt", or "ASSET_NAME.sha256".
+Assets that do not match the checksum are not published.
+Assets that have no checksum are published with a warning, or fail if --require-checksum is set.
+
+The GitHub token is read from --github-token flag or GITHUB_TOKEN environment variable. It is required only for private repositories.`,
+	Example: `  $ artistore import-github --repo macrat/artistore --tag v1.2.3 --prefix releases/v1.2.3/`,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		repo, _ := cmd.Flags().GetString("repo")
+		tag, _ := cmd.Flags().GetString("tag")
+		if !gitHubRepoRegexp.MatchString(repo) {
+			fmt.Fprintln(os.Stderr, "Invalid --repo: it should be like OWNER/NAME.")
+			os.Exit(2)
+		}
+		if tag == "" {
+			fmt.Fprintln(os.Stderr, "--tag is required.")
+			os.Exit(2)
+		}
+
+		rawAPI, _ := cmd.Flags().GetString("github-api")
+		api, err := url.Parse(rawAPI)
+		if err != nil || api.Scheme == "" || api.Host == "" {
+			fmt.Fprintf(os.Stderr, "Invalid --github-api: %s\n", rawAPI)
+			os.Exit(2)
+		}
+
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		t, err := NewTokenHandler()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		ghToken, _ := cmd.Flags().GetString("github-token")
+		if ghToken == "" {
+			ghToken = os.Getenv("GITHUB_TOKEN")
+		}
+		requireChecksum, _ := cmd.Flags().GetBool("require-checksum")
+
+		importer := GitHubImporter{
+			Client:          client,
+			API:             api,
+			Token:           strings.TrimSpace(ghToken),
+			Repo:            repo,
+			Tag:             tag,
+			Prefix:          viper.GetString("prefix"),
+			RequireChecksum: requireChecksum,
+		}
+
+		results, err := importer.Import(t)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to import:", err)
+			os.Exit(1)
+		}
+
+		printResults(format, results)
+		for _, r := range results {
+			if r.Error != "" {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(importGitHubCmd)
+
+	importGitHubCmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	importGitHubCmd.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+	importGitHubCmd.Flags().String("token", "", "Client token. See also 'artistore help token'.")
+	importGitHubCmd.Flags().String("prefix", "", "Prefix for key.")
+
+	importGitHubCmd.Flags().String("repo", "", "GitHub repository like OWNER/NAME.")
+	importGitHubCmd.Flags().String("tag", "", "Tag of the release.")
+	importGitHubCmd.Flags().String("github-token", "", "GitHub token to read private repositories. (default $GITHUB_TOKEN)")
+	importGitHubCmd.Flags().String("github-api", githubAPI, "URL of GitHub API, for GitHub Enterprise Server.")
+	importGitHubCmd.Flags().Bool("require-checksum", false, "Do not publish assets that have no checksum.")
+
+	addOutputFlags(importGitHubCmd)
+	addRetryFlags(importGitHubCmd)
+}
+
+// GitHubImporter downloads assets of a GitHub release and publishes them.
+type GitHubImporter struct {
+	Client *Client
+	API    *url.URL
+
+	// Token is the GitHub token. It can be empty for public repositories.
+	Token string
+
+	Repo            string
+	Tag             string
+	Prefix          string
+	RequireChecksum bool
+}
+
+func (g GitHubImporter) newRequest(rawURL, accept string) func() (*http.Request, error) {
+	return func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", accept)
+		if g.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+g.Token)
+		}
+		return req, nil
+	}
+}
+
+// get sends GET request to GitHub. The caller has to close the body.
+func (g GitHubImporter) get(rawURL, accept string) (io.ReadCloser, error) {
+	resp, err := g.Client.Do(g.newRequest(rawURL, accept))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	return resp.Body, nil
+}
+
+// download starts downloading the asset. The caller has to close the body.
+func (g GitHubImporter) download(a ReleaseAsset) (io.ReadCloser, error) {
+	if a.APIURL != "" {
+		return g.get(a.APIURL, "application/octet-stream")
+	}
+	return g.get(a.URL, "application/octet-stream")
+}
+
+// Release fetches the release of the tag.
+func (g GitHubImporter) Release() (Release, error) {
+	var release Release
+
+	u, err := g.API.Parse(path.Join(g.API.Path, "repos", g.Repo, "releases", "tags", url.PathEscape(g.Tag)))
+	if err != nil {
+		return release, err
+	}
+
+	body, err := g.get(u.String(), "application/vnd.github+json")
+	if err != nil {
+		return release, err
+	}
+	defer body.Close()
+
+	err = json.NewDecoder(body).Decode(&release)
+	return release, err
+}
+
+// isChecksumFile reports whether the asset looks like a file of SHA256 checksums.
+func isChecksumFile(name string) bool {
+	lower := strings.ToLower(name)
+	return lower == "sha256sums" || lower == "sha256sums.txt" || strings.HasSuffix(lower, "checksums.txt") || strings.HasSuffix(lower, ".sha256")
+}
+
+// parseAssetChecksums parses the checksum file. A file that has only a digest, that is common in "ASSET.sha256", is for the asset without the extension.
+func parseAssetChecksums(name string, data []byte) map[string]string {
+	if fields := strings.Fields(string(data)); len(fields) == 1 {
+		return map[string]string{strings.TrimSuffix(name, path.Ext(name)): strings.ToLower(fields[0])}
+	}
+
+	sums := parseChecksums(data)
+	for name, sum := range sums {
+		// Some releases have paths like "dist/app.tar.gz" in the checksum file.
+		if base := path.Base(name); base != name {
+			sums[base] = sum
+		}
+	}
+	return sums
+}
+
+// checksums collects SHA256 of assets from the digests and checksum files in the release.
+func (g GitHubImporter) checksums(release Release) (map[string]string, error) {
+	sums := make(map[string]string)
+
+	for _, a := range release.Assets {
+		if !isChecksumFile(a.Name) {
+			continue
+		}
+
+		body, err := g.download(a)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", a.Name, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(body, 1024*1024))
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", a.Name, err)
+		}
+		for name, sum := range parseAssetChecksums(a.Name, data) {
+			sums[name] = sum
+		}
+	}
+
+	// The digest that GitHub calculated is more trustworthy than files uploaded by someone.
+	for _, a := range release.Assets {
+		if strings.HasPrefix(a.Digest, "sha256:") {
+			sums[a.Name] = strings.ToLower(strings.TrimPrefix(a.Digest, "sha256:"))
+		}
+	}
+
+	return sums, nil
+}
+
+// Import publishes all assets of the release, and returns the results in the order of the release.
+// Failures of each asset are reported in the results, and the error is only for failures of the release itself.
+func (g GitHubImporter) Import(t TokenHandler) ([]ArtifactResult, error) {
+	release, err := g.Release()
+	if err != nil {
+		return nil, err
+	}
+	if len(release.Assets) == 0 {
+		return nil, fmt.Errorf("The release %s of %s has no assets.", g.Tag, g.Repo)
+	}
+
+	sums, err := g.checksums(release)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ArtifactResult, len(release.Assets))
+	for i, a := range release.Assets {
+		r := &results[i]
+		r.Key = path.Join(g.Prefix, a.Name)
+
+		sum, ok := sums[a.Name]
+		if !ok && !isChecksumFile(a.Name) {
+			if g.RequireChecksum {
+				r.Error = ErrNoChecksum.Error()
+				continue
+			}
+			PrintWarn("WARN", "%s: %s", a.Name, strings.TrimSuffix(ErrNoChecksum.Error(), "."))
+		}
+
+		if err := g.importAsset(t, a, sum, r); err != nil {
+			r.Error = strings.TrimSpace(err.Error())
+		}
+	}
+	return results, nil
+}
+
+// importAsset downloads the asset into a temporary file, verifies it by sha256 if not empty, and publishes it.
+func (g GitHubImporter) importAsset(t TokenHandler, a ReleaseAsset, sha256 string, result *ArtifactResult) error {
+	u, err := GetURL(result.Key)
+	if err != nil {
+		return err
+	}
+	token, err := t.TokenFor(result.Key)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp("", "artistore-import-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	body, err := g.download(a)
+	if err != nil {
+		return err
+	}
+	d := NewDigester()
+	_, err = io.Copy(io.MultiWriter(f, d), body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	if sha256 != "" && d.SHA256() != sha256 {
+		return ErrAssetChecksumMismatch
+	}
+	result.Hash = d.Hash()
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	result.URL, err = g.Client.PostArtifact(u, token, f)
+	if err != nil {
+		return err
+	}
+
+	result.Revision, err = locationRevision(result.URL)
+	return err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func sha256Hex(data string) string {
+	h := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(h[:])
+}
+
+func TestParseAssetChecksums(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Data   string
+		Expect map[string]string
+	}{
+		{"SHA256SUMS", sha256Hex("a") + "  a.txt\n" + sha256Hex("b") + " *dist/b.txt\n", map[string]string{
+			"a.txt":      sha256Hex("a"),
+			"b.txt":      sha256Hex("b"),
+			"dist/b.txt": sha256Hex("b"),
+		}},
+		{"app.tar.gz.sha256", sha256Hex("app") + "\n", map[string]string{
+			"app.tar.gz": sha256Hex("app"),
+		}},
+	}
+
+	for _, tt := range tests {
+		if sums := parseAssetChecksums(tt.Name, []byte(tt.Data)); !reflect.DeepEqual(sums, tt.Expect) {
+			t.Errorf("%s: expected %v but got %v", tt.Name, tt.Expect, sums)
+		}
+	}
+}
+
+func TestGitHubImporter(t *testing.T) {
+	assets := map[string]string{
+		"app.tar.gz": "content of app",
+		"lib.zip":    "content of lib",
+		"bad.bin":    "tampered content",
+		"SHA256SUMS": sha256Hex("content of app") + "  app.tar.gz\n" + sha256Hex("original content") + "  bad.bin\n",
+	}
+
+	var gh *httptest.Server
+	gh = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path == "/repos/example/app/releases/tags/v1.0.0" {
+			json.NewEncoder(w).Encode(Release{
+				TagName: "v1.0.0",
+				Assets: []ReleaseAsset{
+					{Name: "app.tar.gz", APIURL: gh.URL + "/assets/app.tar.gz"},
+					{Name: "lib.zip", APIURL: gh.URL + "/assets/lib.zip", Digest: "sha256:" + sha256Hex("content of lib")},
+					{Name: "bad.bin", APIURL: gh.URL + "/assets/bad.bin"},
+					{Name: "SHA256SUMS", APIURL: gh.URL + "/assets/SHA256SUMS"},
+				},
+			})
+			return
+		}
+
+		if data, ok := assets[r.URL.Path[len("/assets/"):]]; ok && r.Header.Get("Accept") == "application/octet-stream" {
+			w.Write([]byte(data))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer gh.Close()
+
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	store := &LocalStore{Path: t.TempDir()}
+	ts := httptest.NewServer(Server{
+		Secret:       sec,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+	})
+	defer ts.Close()
+
+	viper.Set("server", ts.URL)
+	defer viper.Set("server", "")
+
+	api, _ := url.Parse(gh.URL)
+	client := &Client{HTTP: &http.Client{}, Retry: RetryPolicy{MaxAttempts: 1}}
+	importer := GitHubImporter{
+		Client: client,
+		API:    api,
+		Token:  "gh-token",
+		Repo:   "example/app",
+		Tag:    "v1.0.0",
+		Prefix: "releases/v1.0.0/",
+	}
+
+	results, err := importer.Import(TokenHandler{Secret: sec})
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	failures := make(map[string]string)
+	for _, r := range results {
+		failures[r.Key] = r.Error
+	}
+	expect := map[string]string{
+		"releases/v1.0.0/app.tar.gz": "",
+		"releases/v1.0.0/lib.zip":    "",
+		"releases/v1.0.0/bad.bin":    ErrAssetChecksumMismatch.Error(),
+		"releases/v1.0.0/SHA256SUMS": "",
+	}
+	if !reflect.DeepEqual(failures, expect) {
+		t.Errorf("unexpected results: %v", results)
+	}
+
+	if _, err := store.Latest("releases/v1.0.0/bad.bin"); err != ErrNoSuchArtifact {
+		t.Errorf("tampered asset should not be published: %v", err)
+	}
+	meta, err := store.Metadata("releases/v1.0.0/app.tar.gz", 1)
+	if err != nil {
+		t.Fatalf("failed to get metadata: %s", err)
+	}
+	if meta.Hash != results[0].Hash || results[0].Revision != 1 {
+		t.Errorf("unexpected result: %v", results[0])
+	}
+
+	importer.RequireChecksum = true
+	importer.Prefix = "again/"
+	assets["SHA256SUMS"] = ""
+	results, err = importer.Import(TokenHandler{Secret: sec})
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+	if results[0].Error != ErrNoChecksum.Error() || results[1].Error != "" {
+		t.Errorf("only assets with checksum should be published with --require-checksum: %v", results)
+	}
+}
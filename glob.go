@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// hasGlobMeta reports whether pattern contains any special character of glob.
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// matchGlob reports whether the slash separated name matches pattern.
+// Each segment of pattern is matched by path.Match, and "**" matches zero or more directories.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// mayContainMatch reports whether the directory dir can contain files that match pattern, to skip walking into unrelated directories.
+func mayContainMatch(pattern, dir string) bool {
+	ps := strings.Split(pattern, "/")
+	for _, d := range strings.Split(dir, "/") {
+		if len(ps) <= 1 {
+			return false
+		}
+		if ps[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(ps[0], d); !ok {
+			return false
+		}
+		ps = ps[1:]
+	}
+	return true
+}
+
+// FileSelector finds files to publish by glob patterns.
+type FileSelector struct {
+	// Excludes are glob patterns of files and directories to ignore.
+	// A pattern without "/" is matched against each segment of the path, otherwise against the whole path.
+	Excludes []string
+}
+
+// Excluded reports whether the slash separated name matches any exclusion pattern.
+func (s FileSelector) Excluded(name string) bool {
+	for _, p := range s.Excludes {
+		if strings.Contains(p, "/") {
+			if matchGlob(path.Clean(p), name) {
+				return true
+			}
+			continue
+		}
+		for _, seg := range strings.Split(name, "/") {
+			if ok, _ := path.Match(p, seg); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Glob returns files that match pattern and are not excluded, in lexical order.
+func (s FileSelector) Glob(pattern string) ([]string, error) {
+	pattern = path.Clean(filepath.ToSlash(pattern))
+
+	segs := strings.Split(pattern, "/")
+	var base []string
+	for _, seg := range segs[:len(segs)-1] {
+		if hasGlobMeta(seg) {
+			break
+		}
+		base = append(base, seg)
+	}
+	root := strings.Join(base, "/")
+	if root == "" && len(base) > 0 {
+		root = "/"
+	} else if root == "" {
+		root = "."
+	}
+
+	var files []string
+	err := filepath.WalkDir(filepath.FromSlash(root), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == filepath.FromSlash(root) && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipDir
+			}
+			return err
+		}
+
+		name := filepath.ToSlash(p)
+		if name != root && s.Excluded(name) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			if name != root && !mayContainMatch(pattern, name) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if matchGlob(pattern, name) {
+			files = append(files, name)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Walk returns all files in the directory dir that are not excluded, in lexical order.
+func (s FileSelector) Walk(dir string) ([]string, error) {
+	return s.Glob(path.Join(filepath.ToSlash(dir), "**", "*"))
+}
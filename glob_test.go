@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		Pattern string
+		Name    string
+		Match   bool
+	}{
+		{"*.js", "app.js", true},
+		{"*.js", "lib/app.js", false},
+		{"dist/*.js", "dist/app.js", true},
+		{"dist/**/*.js", "dist/app.js", true},
+		{"dist/**/*.js", "dist/a/b/app.js", true},
+		{"dist/**/*.js", "dist/a/b/app.css", false},
+		{"dist/**", "dist/a/b", true},
+		{"**/*.map", "a/b/app.js.map", true},
+		{"dist/?.js", "dist/ab.js", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.Pattern, tt.Name); got != tt.Match {
+			t.Errorf("%q %q: expected %v but got %v", tt.Pattern, tt.Name, tt.Match, got)
+		}
+	}
+}
+
+func TestFileSelector(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"dist/app.js", "dist/app.js.map", "dist/lib/util.js", "dist/lib/util.css", "dist/vendor/x.js", "src/main.js"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+
+	tests := []struct {
+		Pattern  string
+		Excludes []string
+		Files    []string
+	}{
+		{"dist/**/*.js", nil, []string{"dist/app.js", "dist/lib/util.js", "dist/vendor/x.js"}},
+		{"dist/**/*.js", []string{"vendor"}, []string{"dist/app.js", "dist/lib/util.js"}},
+		{"dist/*", []string{"*.map"}, []string{"dist/app.js"}},
+		{"*/main.js", nil, []string{"src/main.js"}},
+		{"dist/lib/*.css", []string{"dist/lib/*"}, nil},
+		{"missing/*.js", nil, nil},
+	}
+
+	for _, tt := range tests {
+		files, err := FileSelector{Excludes: tt.Excludes}.Glob(tt.Pattern)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.Pattern, err)
+		} else if !reflect.DeepEqual(files, tt.Files) {
+			t.Errorf("%s %v: expected %v but got %v", tt.Pattern, tt.Excludes, tt.Files, files)
+		}
+	}
+
+	files, err := FileSelector{Excludes: []string{"*.map", "*.css"}}.Walk("dist")
+	expected := []string{"dist/app.js", "dist/lib/util.js", "dist/vendor/x.js"}
+	if err != nil || !reflect.DeepEqual(files, expected) {
+		t.Errorf("unexpected result of walk: %v, %v", files, err)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// DefaultGrepLimit is the default maximum number of matched lines of the grep API.
+const DefaultGrepLimit = 100
+
+// TextIndex is an in-memory inverted index of the latest revisions of small text artifacts under Prefixes.
+// It only knows which artifacts contain which words; matched lines are read from the store at search time.
+// All methods do nothing on nil.
+type TextIndex struct {
+	Prefixes []string
+	MaxSize  int
+
+	lock  sync.RWMutex
+	terms map[string]map[string]struct{}
+	docs  map[string]textDoc
+}
+
+type textDoc struct {
+	Revision int
+	Terms    []string
+}
+
+func NewTextIndex(prefixes []string, maxSize int) *TextIndex {
+	return &TextIndex{
+		Prefixes: prefixes,
+		MaxSize:  maxSize,
+		terms:    make(map[string]map[string]struct{}),
+		docs:     make(map[string]textDoc),
+	}
+}
+
+// isSearchableType reports whether the content type is worth indexing.
+// It is mostly the same as isTextType for compression, but without binary formats.
+func isSearchableType(contentType string) bool {
+	typ, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch typ {
+	case "application/wasm":
+		return false
+	case "application/yaml", "application/x-yaml", "application/toml":
+		return true
+	}
+	return isTextType(typ)
+}
+
+// splitTerms splits text into lower-cased words of letters and digits.
+func splitTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Indexable reports whether the revision should be indexed.
+func (idx *TextIndex) Indexable(meta Metadata) bool {
+	return idx != nil && HasAnyPrefix(meta.Key, idx.Prefixes) && meta.Size <= idx.MaxSize && isSearchableType(meta.Type)
+}
+
+// Add indexes the revision read from r, replacing older revision of the same key.
+func (idx *TextIndex) Add(meta Metadata, r io.Reader) error {
+	if !idx.Indexable(meta) {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, int64(idx.MaxSize)+1))
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	var terms []string
+	for _, t := range splitTerms(string(data)) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	if old, ok := idx.docs[meta.Key]; ok {
+		if old.Revision > meta.Revision {
+			return nil
+		}
+		idx.remove(meta.Key, old)
+	}
+
+	for _, t := range terms {
+		set, ok := idx.terms[t]
+		if !ok {
+			set = make(map[string]struct{})
+			idx.terms[t] = set
+		}
+		set[meta.Key] = struct{}{}
+	}
+	idx.docs[meta.Key] = textDoc{meta.Revision, terms}
+
+	return nil
+}
+
+// remove drops the document from the index. The caller has to hold the lock.
+func (idx *TextIndex) remove(key string, doc textDoc) {
+	for _, t := range doc.Terms {
+		delete(idx.terms[t], key)
+		if len(idx.terms[t]) == 0 {
+			delete(idx.terms, t)
+		}
+	}
+	delete(idx.docs, key)
+}
+
+// Candidates returns keys under prefix that contain all words in the query, with the indexed revision.
+func (idx *TextIndex) Candidates(query, prefix string) map[string]int {
+	result := make(map[string]int)
+	if idx == nil {
+		return result
+	}
+
+	terms := splitTerms(query)
+	if len(terms) == 0 {
+		return result
+	}
+
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	for key := range idx.terms[terms[0]] {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		ok := true
+		for _, t := range terms[1:] {
+			if _, found := idx.terms[t][key]; !found {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			result[key] = idx.docs[key].Revision
+		}
+	}
+	return result
+}
+
+// Build indexes the latest revisions of all artifacts under the prefixes.
+func (idx *TextIndex) Build(store Store) error {
+	if idx == nil {
+		return nil
+	}
+
+	for _, prefix := range idx.Prefixes {
+		metas, err := ListLatest(store, prefix)
+		if err != nil {
+			return err
+		}
+		for _, meta := range metas {
+			if err := idx.index(store, meta); err != nil {
+				PrintWarn("GREP", "%s#%d: %s", meta.Key, meta.Revision, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (idx *TextIndex) index(store Store, meta Metadata) error {
+	if !idx.Indexable(meta) {
+		return nil
+	}
+
+	f, _, err := store.Get(meta.Key, meta.Revision)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return idx.Add(meta, f)
+}
+
+type GrepMatch struct {
+	Key      string `json:"key"`
+	Revision int    `json:"revision"`
+	Line     int    `json:"line"`
+	Text     string `json:"text"`
+}
+
+type GrepResult struct {
+	Matches []GrepMatch `json:"matches"`
+
+	// Truncated is true if there are more matches than the limit.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// grep returns lines that contain the query case-insensitively, from the artifacts that the index found.
+func (s Server) grep(query, prefix string, limit int) (GrepResult, error) {
+	result := GrepResult{Matches: []GrepMatch{}}
+
+	candidates := s.TextIndex.Candidates(query, prefix)
+	keys := make([]string, 0, len(candidates))
+	for key := range candidates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	q := strings.ToLower(query)
+	for _, key := range keys {
+		rev := candidates[key]
+
+		f, _, err := s.Store.Get(key, rev)
+		if err == ErrNoSuchArtifact || err == ErrRevisionDeleted {
+			continue
+		} else if err != nil {
+			return result, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, s.TextIndex.MaxSize+1)
+		for line := 1; scanner.Scan(); line++ {
+			if !strings.Contains(strings.ToLower(scanner.Text()), q) {
+				continue
+			}
+			if len(result.Matches) >= limit {
+				result.Truncated = true
+				f.Close()
+				return result, nil
+			}
+			result.Matches = append(result.Matches, GrepMatch{key, rev, line, scanner.Text()})
+		}
+		f.Close()
+	}
+
+	return result, nil
+}
+
+// Grep serves GET /_api/v1/grep?q=QUERY, that returns lines of indexed artifacts that contain QUERY.
+//
+// QUERY is a literal text such as "db.example.com:5432", matched case-insensitively; there are no operators or wildcards.
+// The words in QUERY are looked up in the index, so QUERY should consist of whole words; "example.co" does not find "example.com".
+// Results can be narrowed by ?prefix=, and ?limit= (default DefaultGrepLimit) limits the number of lines.
+func (s Server) Grep(path string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+		return
+	}
+
+	if s.TextIndex == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "Full-text index is not enabled on this server.")
+		return
+	}
+
+	query := r.URL.Query()
+	q := query.Get("q")
+	if len(splitTerms(q)) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Query q is required, and it should contain at least one letter or digit.")
+		return
+	}
+
+	limit := DefaultGrepLimit
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > MaxQueryLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid limit: it should be between 1 and %d.\n", MaxQueryLimit)
+			return
+		}
+		limit = n
+	}
+
+	result, err := s.grep(q, query.Get("prefix"), limit)
+	if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
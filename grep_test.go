@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitTerms(t *testing.T) {
+	got := splitTerms("host: DB.example.com:5432\n")
+	expected := []string{"host", "db", "example", "com", "5432"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v but got %v", expected, got)
+	}
+}
+
+func TestGrep(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	admin, _ := NewToken(sec, APIPrefix)
+
+	store := &LocalStore{Path: t.TempDir()}
+	store.Put("config/old.yaml", strings.NewReader("host: db.example.com\n"), PutOptions{})
+
+	s := Server{
+		Secret:       sec,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+		TextIndex:    NewTextIndex([]string{"config/"}, 1024),
+	}
+	if err := s.TextIndex.Build(store); err != nil {
+		t.Fatalf("failed to build index: %s", err)
+	}
+
+	request := func(method, path, body string, token Token) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "bearer "+token.String())
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+	publish := func(key, body string) {
+		token, _ := NewToken(sec, key)
+		if w := request("POST", "/"+key, body, token); w.Code != http.StatusCreated {
+			t.Fatalf("failed to publish %s: %d: %s", key, w.Code, w.Body.String())
+		}
+	}
+	grep := func(query string) GrepResult {
+		w := request("GET", "/_api/v1/grep?"+query, "", admin)
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to grep: %d: %s", w.Code, w.Body.String())
+		}
+		var r GrepResult
+		json.Unmarshal(w.Body.Bytes(), &r)
+		return r
+	}
+
+	publish("config/app.txt", "name: app\nupstream: DB.example.com:5432\n")
+	publish("config/web.txt", "name: web\nupstream: cache.example.com\n")
+	publish("other/app.txt", "upstream: db.example.com\n")
+	publish("config/big.txt", strings.Repeat("db.example.com\n", 100))
+
+	r := grep("q=db.example.com")
+	if len(r.Matches) != 2 || r.Matches[0].Key != "config/app.txt" || r.Matches[0].Line != 2 || r.Matches[1].Key != "config/old.yaml" {
+		t.Errorf("unexpected matches: %v", r.Matches)
+	}
+
+	if r := grep("q=example.com&limit=1"); len(r.Matches) != 1 || !r.Truncated {
+		t.Errorf("unexpected limited matches: %v", r)
+	}
+
+	// "example db" has the same words, but it is not in the text.
+	if r := grep("q=example+db"); len(r.Matches) != 0 {
+		t.Errorf("lines without the phrase should not match: %v", r.Matches)
+	}
+
+	publish("config/app.txt", "name: app\nupstream: cache.example.com\n")
+	if r := grep("q=db.example.com&prefix=config/app"); len(r.Matches) != 0 {
+		t.Errorf("old revision should not be matched: %v", r.Matches)
+	}
+
+	if w := request("GET", "/_api/v1/grep?q=", "", admin); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for empty query but got %d", w.Code)
+	}
+}
+
+func TestGrepQuery(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	admin, _ := NewToken(sec, APIPrefix)
+
+	store := &LocalStore{Path: t.TempDir()}
+	store.Put("config/app.txt", strings.NewReader("name: app\nupstream: DB.example.com:5432\ncache: cache.example.com\n"), PutOptions{})
+	store.Put("config/web.txt", strings.NewReader("name: web\nupstream: cache.example.com\n"), PutOptions{})
+	store.Put("config/sub/db.txt", strings.NewReader("host: db.example.com\n"), PutOptions{})
+
+	s := Server{
+		Secret:       sec,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+		TextIndex:    NewTextIndex([]string{"config/"}, 1024),
+	}
+	if err := s.TextIndex.Build(store); err != nil {
+		t.Fatalf("failed to build index: %s", err)
+	}
+
+	tests := []struct {
+		Query  string
+		Status int
+		Expect []string
+	}{
+		{"q=db.example.com", http.StatusOK, []string{"config/app.txt:2", "config/sub/db.txt:1"}},
+		{"q=DB.EXAMPLE.COM", http.StatusOK, []string{"config/app.txt:2", "config/sub/db.txt:1"}},
+		{"q=db.example.com:5432", http.StatusOK, []string{"config/app.txt:2"}},
+		{"q=upstream", http.StatusOK, []string{"config/app.txt:2", "config/web.txt:2"}},
+		{"q=name:+web", http.StatusOK, []string{"config/web.txt:1"}},
+		{"q=cache.example.com&prefix=config/w", http.StatusOK, []string{"config/web.txt:2"}},
+		{"q=cache.example.com&limit=2", http.StatusOK, []string{"config/app.txt:3", "config/web.txt:2"}},
+
+		// Only whole words are looked up.
+		{"q=example.co", http.StatusOK, []string{}},
+
+		// Words have to be in the same order as the text.
+		{"q=example+db", http.StatusOK, []string{}},
+
+		// Operators and wildcards are matched literally.
+		{"q=%2Bdb+-cache", http.StatusOK, []string{}},
+		{"q=db.*", http.StatusOK, []string{}},
+		{"q=host:db*", http.StatusOK, []string{}},
+
+		{"q=", http.StatusBadRequest, nil},
+		{"q=...", http.StatusBadRequest, nil},
+		{"q=db&limit=0", http.StatusBadRequest, nil},
+		{"q=db&limit=abc", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/_api/v1/grep?"+tt.Query, nil)
+		r.Header.Set("Authorization", "bearer "+admin.String())
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != tt.Status {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.Query, tt.Status, w.Code, w.Body)
+			continue
+		}
+		if tt.Status != http.StatusOK {
+			continue
+		}
+
+		var result GrepResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Errorf("%s: failed to parse response: %s", tt.Query, err)
+			continue
+		}
+		got := []string{}
+		for _, m := range result.Matches {
+			got = append(got, fmt.Sprintf("%s:%d", m.Key, m.Line))
+		}
+		if !reflect.DeepEqual(got, tt.Expect) {
+			t.Errorf("%s: expected %v but got %v", tt.Query, tt.Expect, got)
+		}
+	}
+}
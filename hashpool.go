@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+)
+
+type HashPriority int
+
+const (
+	// HashForeground is for hashing that a client is waiting for, such as the sha256sums API.
+	HashForeground HashPriority = iota
+
+	// HashBackground is for integrity checks such as fsck, that nobody is waiting for.
+	HashBackground
+)
+
+// HashPool bounds the number of hash computations running at once, so that background integrity work does not take all CPUs from requests.
+//
+// Foreground jobs can use all Workers. Background jobs can use up to BackgroundWorkers, and they wait while any foreground job is waiting.
+// A nil HashPool runs jobs immediately without limit.
+type HashPool struct {
+	Workers           int
+	BackgroundWorkers int
+
+	lock       sync.Mutex
+	cond       *sync.Cond
+	running    int
+	background int
+	waiting    int
+}
+
+func NewHashPool(workers, background int) *HashPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if background < 1 || background > workers {
+		background = workers
+	}
+
+	p := &HashPool{Workers: workers, BackgroundWorkers: background}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+func (p *HashPool) acquire(priority HashPriority) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if priority == HashForeground {
+		p.waiting++
+		for p.running >= p.Workers {
+			p.cond.Wait()
+		}
+		p.waiting--
+	} else {
+		for p.running >= p.Workers || p.background >= p.BackgroundWorkers || p.waiting > 0 {
+			p.cond.Wait()
+		}
+		p.background++
+	}
+	p.running++
+}
+
+func (p *HashPool) release(priority HashPriority) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.running--
+	if priority == HashBackground {
+		p.background--
+	}
+	p.cond.Broadcast()
+}
+
+// Do runs f when a worker is available for the priority, and returns the error of f.
+func (p *HashPool) Do(priority HashPriority, f func() error) error {
+	if p == nil {
+		return f()
+	}
+
+	p.acquire(priority)
+	defer p.release(priority)
+	return f()
+}
+
+// HashPoolStats is the number of running jobs in HashPool.
+type HashPoolStats struct {
+	Workers    int `json:"workers"`
+	Running    int `json:"running"`
+	Background int `json:"background"`
+	Waiting    int `json:"waiting_foreground"`
+}
+
+func (p *HashPool) Stats() HashPoolStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return HashPoolStats{
+		Workers:    p.Workers,
+		Running:    p.running,
+		Background: p.background,
+		Waiting:    p.waiting,
+	}
+}
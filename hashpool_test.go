@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHashPool_Limit(t *testing.T) {
+	p := NewHashPool(4, 2)
+
+	var running, maxForeground, maxBackground int32
+	observe := func(max *int32) func() error {
+		return func() error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(max)
+				if n <= m || atomic.CompareAndSwapInt32(max, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			return nil
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Do(HashBackground, observe(&maxBackground))
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Do(HashForeground, observe(&maxForeground))
+		}()
+	}
+	wg.Wait()
+
+	if maxBackground != 2 {
+		t.Errorf("background jobs should run up to 2 at once but %d", maxBackground)
+	}
+	if maxForeground != 4 {
+		t.Errorf("foreground jobs should run up to 4 at once but %d", maxForeground)
+	}
+	if s := p.Stats(); s.Running != 0 || s.Background != 0 || s.Waiting != 0 {
+		t.Errorf("unexpected stats after all jobs: %#v", s)
+	}
+}
+
+func TestHashPool_Priority(t *testing.T) {
+	p := NewHashPool(1, 1)
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	go p.Do(HashBackground, func() error {
+		close(started)
+		<-block
+		return nil
+	})
+	<-started
+
+	var lock sync.Mutex
+	var order []HashPriority
+	record := func(priority HashPriority) func() error {
+		return func() error {
+			lock.Lock()
+			defer lock.Unlock()
+			order = append(order, priority)
+			return nil
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.Do(HashBackground, record(HashBackground))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		defer wg.Done()
+		p.Do(HashForeground, record(HashForeground))
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	close(block)
+	wg.Wait()
+
+	if len(order) != 2 || order[0] != HashForeground {
+		t.Errorf("foreground job should run before waiting background job: %v", order)
+	}
+}
+
+func TestHashPool_Nil(t *testing.T) {
+	var p *HashPool
+	want := errors.New("hello")
+	if err := p.Do(HashBackground, func() error { return want }); err != want {
+		t.Errorf("nil pool should run the job immediately: %v", err)
+	}
+}
+
+func TestLocalStoreCheck_HashPool(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir(), HashPool: NewHashPool(2, 2)}
+	for i := 0; i < 5; i++ {
+		if _, err := store.Put("hello", bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	var revs []int
+	report, err := store.Check(false, func(r CheckResult) {
+		revs = append(revs, r.Revision)
+	})
+	if err != nil {
+		t.Fatalf("failed to check: %s", err)
+	}
+	if report.Checked != 5 || len(report.Corrupted) != 0 {
+		t.Errorf("unexpected report: %#v", report)
+	}
+	for i, rev := range revs {
+		if rev != i+1 {
+			t.Fatalf("callback should be called in order of revisions: %v", revs)
+		}
+	}
+}
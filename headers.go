@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// protectedHeaders are response headers that can not be overridden by HeaderRule, because the server needs them to send the content correctly.
+var protectedHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Encoding":  true,
+	"Content-Length":    true,
+	"Content-Range":     true,
+	"Etag":              true,
+	"Location":          true,
+	"Transfer-Encoding": true,
+}
+
+// HeaderRule is a set of extra response headers for artifacts under Prefix.
+type HeaderRule struct {
+	Prefix string `yaml:"prefix"`
+
+	// Types limit the rule to artifacts of the content types, such as "text/html" or "image/*".
+	Types []string `yaml:"types,omitempty"`
+
+	Headers map[string]string `yaml:"headers"`
+}
+
+// HeaderRules is applied to responses of artifacts.
+// All rules that match the key are applied in order, so later rules override headers of earlier rules.
+type HeaderRules struct {
+	Rules []HeaderRule `yaml:"rules"`
+}
+
+func ParseHeaderRules(data []byte) (*HeaderRules, error) {
+	var h HeaderRules
+	if err := yaml.UnmarshalStrict(data, &h); err != nil {
+		return nil, fmt.Errorf("Invalid headers: %s", err)
+	}
+
+	for i := range h.Rules {
+		r := &h.Rules[i]
+
+		if err := verifyACLPrefix(r.Prefix); err != nil {
+			return nil, fmt.Errorf("Invalid headers: prefix %q: %s", r.Prefix, err)
+		}
+
+		for _, t := range r.Types {
+			if _, _, err := mime.ParseMediaType(t); err != nil && !strings.HasSuffix(t, "/*") {
+				return nil, fmt.Errorf("Invalid headers: prefix %q: invalid type %q.", r.Prefix, t)
+			}
+		}
+
+		if len(r.Headers) == 0 {
+			return nil, fmt.Errorf("Invalid headers: prefix %q: no headers.", r.Prefix)
+		}
+
+		headers := make(map[string]string, len(r.Headers))
+		for name, value := range r.Headers {
+			canonical := http.CanonicalHeaderKey(name)
+			if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+				return nil, fmt.Errorf("Invalid headers: prefix %q: invalid header name %q.", r.Prefix, name)
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf("Invalid headers: prefix %q: value of %s can not contain newline.", r.Prefix, name)
+			}
+			if protectedHeaders[canonical] {
+				return nil, fmt.Errorf("Invalid headers: prefix %q: %s can not be set.", r.Prefix, canonical)
+			}
+			headers[canonical] = value
+		}
+		r.Headers = headers
+	}
+
+	return &h, nil
+}
+
+func LoadHeaderRules(path string) (*HeaderRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseHeaderRules(data)
+}
+
+// Apply sets headers of the rules that match to the key and the content type.
+func (h *HeaderRules) Apply(header http.Header, key, typ string) {
+	if h == nil {
+		return
+	}
+
+	for _, r := range h.Rules {
+		if !strings.HasPrefix(key, r.Prefix) || (len(r.Types) > 0 && !matchTypes(r.Types, typ)) {
+			continue
+		}
+		for name, value := range r.Headers {
+			header.Set(name, value)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseHeaderRules_Invalid(t *testing.T) {
+	tests := []string{
+		`rules: [{prefix: "/abc", headers: {X-Foo: bar}}]`,
+		`rules: [{prefix: "abc/"}]`,
+		`rules: [{prefix: "abc/", headers: {"X Foo": bar}}]`,
+		`rules: [{prefix: "abc/", headers: {X-Foo: "a\r\nSet-Cookie: b"}}]`,
+		`rules: [{prefix: "abc/", headers: {content-length: "1"}}]`,
+		`rules: [{prefix: "abc/", types: ["text/"], headers: {X-Foo: bar}}]`,
+		`rules: [{prefix: "abc/", unknown: 1, headers: {X-Foo: bar}}]`,
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseHeaderRules([]byte(tt)); err == nil {
+			t.Errorf("expected error but got nil: %s", tt)
+		}
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	rules, err := ParseHeaderRules([]byte(`
+rules:
+  - prefix: ""
+    headers:
+      x-robots-tag: noindex
+  - prefix: site/assets/
+    headers:
+      Cache-Control: public, max-age=86400
+  - prefix: site/
+    types: [text/html]
+    headers:
+      Content-Security-Policy: default-src 'self'
+`))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+
+	store := &LocalStore{Path: t.TempDir()}
+	store.Put("site/index.html", strings.NewReader("<h1>hello</h1>"), PutOptions{})
+	store.Put("site/assets/app.js", strings.NewReader("console.log(1)"), PutOptions{})
+	s := Server{Store: store, Headers: rules}
+
+	tests := []struct {
+		Path    string
+		Headers map[string]string
+	}{
+		{"/site/index.html?rev=1", map[string]string{
+			"X-Robots-Tag":            "noindex",
+			"Cache-Control":           "public, max-age=31536000, immutable",
+			"Content-Security-Policy": "default-src 'self'",
+		}},
+		{"/site/assets/app.js?rev=1", map[string]string{
+			"X-Robots-Tag":            "noindex",
+			"Cache-Control":           "public, max-age=86400",
+			"Content-Security-Policy": "",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", tt.Path, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status: %d: %s", w.Code, w.Body.String())
+			}
+			for name, value := range tt.Headers {
+				if got := w.Header().Get(name); got != value {
+					t.Errorf("%s: expected %q but got %q", name, value, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IDGenerator makes identifiers for requests, upload sessions, transactions, and events.
+//
+// IDs start with the timestamp in milliseconds so that they are sortable by creation time, and the rest is random so that IDs made by different replicas do not collide.
+// IDs made by the same generator are strictly increasing even if they are made in the same millisecond.
+type IDGenerator interface {
+	NewID() string
+}
+
+var IDFormats = map[string]func() IDGenerator{
+	"ulid":   func() IDGenerator { return &ULIDGenerator{} },
+	"uuidv7": func() IDGenerator { return &UUIDv7Generator{} },
+}
+
+var (
+	idGenerator     IDGenerator = &ULIDGenerator{}
+	idGeneratorLock sync.RWMutex
+)
+
+// SetIDFormat changes the generator for NewID.
+func SetIDFormat(name string) error {
+	f, ok := IDFormats[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("Unknown ID format: %q. Please use ulid or uuidv7.", name)
+	}
+
+	idGeneratorLock.Lock()
+	defer idGeneratorLock.Unlock()
+	idGenerator = f()
+
+	return nil
+}
+
+// NewID makes a new ID by the generator that is set by SetIDFormat.
+func NewID() string {
+	idGeneratorLock.RLock()
+	defer idGeneratorLock.RUnlock()
+	return idGenerator.NewID()
+}
+
+// monotonicEntropy is the random part of IDs, that is split into hi and lo because it is longer than 64 bits.
+// It is increased instead of re-generated if the timestamp is not advanced.
+type monotonicEntropy struct {
+	HiBits, LoBits uint
+
+	lock sync.Mutex
+	last int64
+	hi   uint64
+	lo   uint64
+}
+
+func (m *monotonicEntropy) randomize() {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %s", err))
+	}
+	m.hi = binary.BigEndian.Uint64(buf[:8]) & bitMask(m.HiBits)
+	m.lo = binary.BigEndian.Uint64(buf[8:]) & bitMask(m.LoBits)
+}
+
+func bitMask(bits uint) uint64 {
+	return uint64(1)<<bits - 1
+}
+
+func (m *monotonicEntropy) next() (ms int64, hi, lo uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if now := time.Now().UnixMilli(); now > m.last {
+		m.last = now
+		m.randomize()
+	} else {
+		// The clock is not advanced or went back. Use the last timestamp to keep IDs increasing.
+		switch {
+		case m.lo < bitMask(m.LoBits):
+			m.lo++
+		case m.hi < bitMask(m.HiBits):
+			m.lo = 0
+			m.hi++
+		default:
+			m.last++
+			m.randomize()
+		}
+	}
+
+	return m.last, m.hi, m.lo
+}
+
+// ULIDGenerator makes IDs in ULID, such as "01ARZ3NDEKTSV4RRFFQ69G5FAV".
+type ULIDGenerator struct {
+	once    sync.Once
+	entropy monotonicEntropy
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func (g *ULIDGenerator) NewID() string {
+	g.once.Do(func() {
+		g.entropy.HiBits = 16
+		g.entropy.LoBits = 64
+	})
+	ms, hi, lo := g.entropy.next()
+
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(ms)<<16|hi)
+	binary.BigEndian.PutUint64(buf[8:], lo)
+
+	digits := new(big.Int).SetBytes(buf[:]).Text(32)
+	id := make([]byte, 26)
+	for i := range id {
+		id[i] = '0'
+	}
+	for i, d := range []byte(digits) {
+		n := d - '0'
+		if d >= 'a' {
+			n = d - 'a' + 10
+		}
+		id[len(id)-len(digits)+i] = crockfordBase32[n]
+	}
+	return string(id)
+}
+
+// UUIDv7Generator makes IDs in UUID version 7, such as "01890a5d-ac96-774b-bcce-b302099a8057".
+type UUIDv7Generator struct {
+	once    sync.Once
+	entropy monotonicEntropy
+}
+
+func (g *UUIDv7Generator) NewID() string {
+	g.once.Do(func() {
+		g.entropy.HiBits = 12
+		g.entropy.LoBits = 62
+	})
+	ms, hi, lo := g.entropy.next()
+
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(ms)<<16|0x7000|hi)
+	binary.BigEndian.PutUint64(buf[8:], 0x8000000000000000|lo)
+
+	s := hex.EncodeToString(buf[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
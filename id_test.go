@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestIDGenerator(t *testing.T) {
+	tests := []struct {
+		Format  string
+		Pattern *regexp.Regexp
+	}{
+		{"ulid", regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)},
+		{"uuidv7", regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Format, func(t *testing.T) {
+			g := IDFormats[tt.Format]()
+
+			ids := make([]string, 10000)
+			seen := make(map[string]bool)
+			for i := range ids {
+				ids[i] = g.NewID()
+				if !tt.Pattern.MatchString(ids[i]) {
+					t.Fatalf("unexpected format: %s", ids[i])
+				}
+				if seen[ids[i]] {
+					t.Fatalf("duplicated ID: %s", ids[i])
+				}
+				seen[ids[i]] = true
+			}
+
+			if !sort.StringsAreSorted(ids) {
+				t.Errorf("IDs should be sorted in order of generation")
+			}
+		})
+	}
+}
+
+func TestMonotonicEntropyOverflow(t *testing.T) {
+	m := &monotonicEntropy{HiBits: 1, LoBits: 1}
+	ms, _, _ := m.next()
+
+	m.hi, m.lo = 1, 1
+	if next, _, _ := m.next(); next != ms+1 {
+		t.Errorf("timestamp should be advanced when entropy overflows: %d -> %d", ms, next)
+	}
+}
+
+func TestSetIDFormat(t *testing.T) {
+	defer SetIDFormat("ulid")
+
+	if err := SetIDFormat("unknown"); err == nil {
+		t.Errorf("unknown format should be rejected")
+	}
+
+	if err := SetIDFormat("UUIDv7"); err != nil {
+		t.Fatalf("failed to set format: %s", err)
+	}
+
+	ts := httptest.NewServer(Server{Store: &LocalStore{Path: t.TempDir()}})
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/hello")
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+	resp.Body.Close()
+
+	if id := resp.Header.Get("X-Request-Id"); !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7`).MatchString(id) {
+		t.Errorf("unexpected request ID: %q", id)
+	}
+}
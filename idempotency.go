@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// IdempotencyRetention is the duration to remember Idempotency-Key of publishes.
+var IdempotencyRetention = time.Hour
+
+var ErrIdempotencyInProgress = errors.New("Another request with the same Idempotency-Key is in progress.")
+
+type idempotencyKey struct {
+	Key            string
+	IdempotencyKey string
+}
+
+type idempotencyEntry struct {
+	// Revision is 0 while the publish is in progress.
+	Revision int
+	Expires  time.Time
+}
+
+// IdempotencyStore remembers Idempotency-Key of recent publishes for each artifact key, so that a retried publish does not create a duplicated revision.
+// Keys are kept only in memory, so they are lost when the server restarts.
+// All methods do nothing on nil.
+type IdempotencyStore struct {
+	lock sync.Mutex
+	m    map[idempotencyKey]idempotencyEntry
+}
+
+func NewIdempotencyStore() *IdempotencyStore {
+	return &IdempotencyStore{m: make(map[idempotencyKey]idempotencyEntry)}
+}
+
+// Begin starts a publish of key with the Idempotency-Key.
+// It returns the revision if the publish has already been done.
+// Otherwise it marks the Idempotency-Key as in progress, and the caller has to call Finish.
+func (s *IdempotencyStore) Begin(key, idempotency string) (revision int, err error) {
+	if s == nil || idempotency == "" {
+		return 0, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.expire()
+
+	k := idempotencyKey{key, idempotency}
+	if e, ok := s.m[k]; ok {
+		if e.Revision == 0 {
+			return 0, ErrIdempotencyInProgress
+		}
+		return e.Revision, nil
+	}
+
+	s.m[k] = idempotencyEntry{Expires: time.Now().Add(IdempotencyRetention)}
+	return 0, nil
+}
+
+// Finish records the result of the publish that is started by Begin. revision is 0 if the publish failed.
+func (s *IdempotencyStore) Finish(key, idempotency string, revision int) {
+	if s == nil || idempotency == "" {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	k := idempotencyKey{key, idempotency}
+	if revision > 0 {
+		s.m[k] = idempotencyEntry{revision, time.Now().Add(IdempotencyRetention)}
+	} else {
+		delete(s.m, k)
+	}
+}
+
+func (s *IdempotencyStore) expire() {
+	now := time.Now()
+	for k, e := range s.m {
+		// In-progress entries are kept until Finish, even if it takes longer than the retention.
+		if e.Revision > 0 && now.After(e.Expires) {
+			delete(s.m, k)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	s := NewIdempotencyStore()
+
+	if rev, err := s.Begin("hello.txt", "abc"); rev != 0 || err != nil {
+		t.Fatalf("unexpected result of the first begin: %d, %v", rev, err)
+	}
+	if _, err := s.Begin("hello.txt", "abc"); err != ErrIdempotencyInProgress {
+		t.Errorf("expected ErrIdempotencyInProgress but got %v", err)
+	}
+	if rev, err := s.Begin("world.txt", "abc"); rev != 0 || err != nil {
+		t.Errorf("the same Idempotency-Key for another artifact should be independent: %d, %v", rev, err)
+	}
+
+	s.Finish("hello.txt", "abc", 3)
+	if rev, err := s.Begin("hello.txt", "abc"); rev != 3 || err != nil {
+		t.Errorf("expected revision 3 but got %d, %v", rev, err)
+	}
+
+	s.Finish("world.txt", "abc", 0)
+	if rev, err := s.Begin("world.txt", "abc"); rev != 0 || err != nil {
+		t.Errorf("failed publish should be forgotten: %d, %v", rev, err)
+	}
+
+	var nilStore *IdempotencyStore
+	if rev, err := nilStore.Begin("hello.txt", "abc"); rev != 0 || err != nil {
+		t.Errorf("nil store should do nothing: %d, %v", rev, err)
+	}
+}
+
+func TestPostIdempotency(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewToken(sec, "hello.txt")
+
+	store := &LocalStore{Path: t.TempDir()}
+	s := Server{
+		Secret:       sec,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+		Idempotency:  NewIdempotencyStore(),
+	}
+
+	post := func(idempotency string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/hello.txt", strings.NewReader("hello world"))
+		r.Header.Set("Authorization", "bearer "+token.String())
+		r.Header.Set("Idempotency-Key", idempotency)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	first := post("abc")
+	second := post("abc")
+	other := post("def")
+
+	for _, w := range []*httptest.ResponseRecorder{first, second, other} {
+		if w.Code != http.StatusCreated {
+			t.Fatalf("unexpected status: %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	if first.Header().Get("Location") != "/hello.txt?rev=1" || second.Header().Get("Location") != "/hello.txt?rev=1" {
+		t.Errorf("retried publish should return the original location: %s, %s", first.Header().Get("Location"), second.Header().Get("Location"))
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retried publish should be marked as replayed")
+	}
+	if other.Header().Get("Location") != "/hello.txt?rev=2" {
+		t.Errorf("another Idempotency-Key should create a new revision: %s", other.Header().Get("Location"))
+	}
+
+	if latest, _ := store.Latest("hello.txt"); latest != 2 {
+		t.Errorf("expected 2 revisions but got %d", latest)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// IndexFileName is the name of the per-key index file.
+// The index caches metadata of all revisions, so that the store does not have to open every gzip files.
+// It is rebuilt from revision files if it is missing or broken, and revisions newer than the index are added on load.
+const IndexFileName = "index.json"
+
+// errStaleIndex means that the index does not have the latest committed revision.
+// It happens if the server crashed between committing a revision and writing the index.
+var errStaleIndex = errors.New("Index does not have the latest revision.")
+
+type indexEntry struct {
+	Metadata
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type storeIndex struct {
+	Revisions []indexEntry `json:"revisions"`
+}
+
+func (idx storeIndex) Latest() int {
+	if len(idx.Revisions) == 0 {
+		return 0
+	}
+	return idx.Revisions[len(idx.Revisions)-1].Revision
+}
+
+func (idx storeIndex) Find(revision int) (Metadata, bool) {
+	i := sort.Search(len(idx.Revisions), func(i int) bool {
+		return idx.Revisions[i].Revision >= revision
+	})
+	if i < len(idx.Revisions) && idx.Revisions[i].Revision == revision {
+		return idx.Revisions[i].metadata(), true
+	}
+	return Metadata{}, false
+}
+
+func (idx *storeIndex) Add(meta Metadata) {
+	idx.Remove(meta.Revision)
+	idx.Revisions = append(idx.Revisions, indexEntry{meta, meta.Timestamp})
+	sort.Slice(idx.Revisions, func(i, j int) bool {
+		return idx.Revisions[i].Revision < idx.Revisions[j].Revision
+	})
+}
+
+func (idx *storeIndex) Remove(revision int) {
+	for i, e := range idx.Revisions {
+		if e.Revision == revision {
+			idx.Revisions = append(idx.Revisions[:i], idx.Revisions[i+1:]...)
+			return
+		}
+	}
+}
+
+// clone makes a copy of the index that can be modified without affecting the original.
+func (idx storeIndex) clone() storeIndex {
+	xs := make([]indexEntry, len(idx.Revisions))
+	for i, e := range idx.Revisions {
+		if e.Labels != nil {
+			labels := make(map[string]string, len(e.Labels))
+			for k, v := range e.Labels {
+				labels[k] = v
+			}
+			e.Labels = labels
+		}
+		if e.Tags != nil {
+			e.Tags = append([]string(nil), e.Tags...)
+		}
+		xs[i] = e
+	}
+	return storeIndex{Revisions: xs}
+}
+
+func (e indexEntry) metadata() Metadata {
+	meta := e.Metadata
+	meta.Timestamp = e.Timestamp
+	return meta
+}
+
+func (s *LocalStore) indexPath(key string) string {
+	return filepath.Join(s.keyDir(key), IndexFileName)
+}
+
+func (s *LocalStore) readIndex(key string) (storeIndex, error) {
+	var idx storeIndex
+
+	fname := s.indexPath(key)
+	info, err := os.Stat(fname)
+	if err != nil {
+		s.indexes.remove(key)
+		return idx, err
+	}
+	if idx, ok := s.indexes.get(key, info); ok {
+		return idx, nil
+	}
+
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return idx, err
+	}
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return idx, err
+	}
+
+	for i := range idx.Revisions {
+		idx.Revisions[i].Key = key
+	}
+
+	if latest, err := s.committedLatest(key); err != nil {
+		return idx, err
+	} else if latest > idx.Latest() {
+		return idx, errStaleIndex
+	}
+
+	s.indexes.put(key, info, idx)
+	return idx, nil
+}
+
+func (s *LocalStore) writeIndex(key string, idx storeIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	fname := s.indexPath(key)
+	f, err := os.OpenFile(fname+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(fname+".tmp", fname); err != nil {
+		return err
+	}
+
+	// Sync the directory too, the same as committing revisions.
+	if dir, err := os.Open(filepath.Dir(fname)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	if info, err := os.Stat(fname); err == nil {
+		s.indexes.put(key, info, idx)
+	}
+	return nil
+}
+
+// committedLatest returns the latest revision that has a committed revision file.
+// Unlike latestFile, it does not include revisions that are being written, quarantined, or moved.
+func (s *LocalStore) committedLatest(key string) (latest int, err error) {
+	xs, err := os.ReadDir(s.keyDir(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrNoSuchArtifact
+	} else if err != nil {
+		return 0, err
+	}
+
+	for _, x := range xs {
+		rev, temp, err := parseRevisionFile(x.Name())
+		if err == nil && !temp && rev > latest {
+			latest = rev
+		}
+	}
+	return latest, nil
+}
+
+// scanIndex makes index from revision files.
+func (s *LocalStore) scanIndex(key string) (storeIndex, error) {
+	var idx storeIndex
+	err := s.scanNewer(key, &idx)
+	return idx, err
+}
+
+// scanNewer adds revision files that are newer than the latest revision in idx.
+func (s *LocalStore) scanNewer(key string, idx *storeIndex) error {
+	xs, err := os.ReadDir(s.keyDir(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNoSuchArtifact
+	} else if err != nil {
+		return err
+	}
+
+	after := idx.Latest()
+	for _, x := range xs {
+		rev, err := strconv.Atoi(x.Name())
+		if err != nil || rev <= after {
+			continue
+		}
+
+		f, err := s.open(key, rev)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			PrintWarn("INDEX", "failed to read %s#%d: %s", key, rev, err)
+			continue
+		}
+		meta, err := f.Metadata()
+		f.Close()
+		if err != nil {
+			PrintWarn("INDEX", "failed to read %s#%d: %s", key, rev, err)
+			continue
+		}
+
+		idx.Add(meta)
+	}
+
+	return nil
+}
+
+// repairIndex makes index that has all committed revisions.
+// A stale index gets only the missing revisions, and a missing or broken index is scanned from all revision files.
+// The caller has to hold the lock of the key.
+func (s *LocalStore) repairIndex(key string) (storeIndex, error) {
+	idx, err := s.readIndex(key)
+	if err == nil {
+		return idx, nil
+	}
+	if err != errStaleIndex {
+		idx = storeIndex{}
+	}
+
+	err = s.scanNewer(key, &idx)
+	return idx, err
+}
+
+// RebuildIndex remakes index of the key from revision files.
+func (s *LocalStore) RebuildIndex(key string) error {
+	defer s.indexLock.Lock(key)()
+
+	_, err := s.rebuildIndex(key)
+	return err
+}
+
+func (s *LocalStore) rebuildIndex(key string) (storeIndex, error) {
+	idx, err := s.scanIndex(key)
+	if err != nil {
+		return idx, err
+	}
+
+	if err := s.writeIndex(key, idx); err != nil {
+		PrintWarn("INDEX", "failed to write index of %s: %s", key, err)
+	}
+	return idx, nil
+}
+
+func (s *LocalStore) loadIndex(key string) (storeIndex, error) {
+	if idx, err := s.readIndex(key); err == nil {
+		return idx, nil
+	}
+
+	defer s.indexLock.Lock(key)()
+
+	idx, err := s.repairIndex(key)
+	if err != nil {
+		return idx, err
+	}
+
+	if err := s.writeIndex(key, idx); err != nil {
+		PrintWarn("INDEX", "failed to write index of %s: %s", key, err)
+	}
+	return idx, nil
+}
+
+func (s *LocalStore) updateIndex(key string, update func(idx *storeIndex)) error {
+	defer s.indexLock.Lock(key)()
+
+	idx, err := s.repairIndex(key)
+	if err != nil {
+		return err
+	}
+
+	update(&idx)
+
+	return s.writeIndex(key, idx)
+}
+
+// indexLockStripes is the number of mutexes in indexLocks.
+const indexLockStripes = 64
+
+// indexLocks is a striped mutex to update indexes per key.
+// Keys are spread over stripes by their hash, so that updates of different keys rarely wait for each other.
+type indexLocks [indexLockStripes]sync.Mutex
+
+func (l *indexLocks) stripe(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % indexLockStripes)
+}
+
+// Lock locks indexes of the keys, and returns the function to unlock them.
+// Stripes are always locked in the same order, so that locking multiple keys does not deadlock.
+func (l *indexLocks) Lock(keys ...string) (unlock func()) {
+	stripes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		stripes = append(stripes, l.stripe(key))
+	}
+	sort.Ints(stripes)
+
+	locked := stripes[:0]
+	for i, x := range stripes {
+		if i == 0 || x != stripes[i-1] {
+			l[x].Lock()
+			locked = append(locked, x)
+		}
+	}
+
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			l[locked[i]].Unlock()
+		}
+	}
+}
+
+// maxCachedIndexes is the maximum number of parsed indexes that indexCache keeps.
+const maxCachedIndexes = 4096
+
+type cachedIndex struct {
+	info os.FileInfo
+	idx  storeIndex
+}
+
+// indexCache keeps parsed indexes, so that readIndex does not parse index.json every time.
+// Entries are used only while the file is the same, so that changes by other processes are noticed.
+type indexCache struct {
+	lock sync.Mutex
+	m    map[string]cachedIndex
+}
+
+func (c *indexCache) get(key string, info os.FileInfo) (storeIndex, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.m[key]
+	if !ok || !os.SameFile(e.info, info) || !e.info.ModTime().Equal(info.ModTime()) || e.info.Size() != info.Size() {
+		return storeIndex{}, false
+	}
+	return e.idx.clone(), true
+}
+
+func (c *indexCache) put(key string, info os.FileInfo, idx storeIndex) {
+	idx = idx.clone()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.m == nil {
+		c.m = make(map[string]cachedIndex)
+	}
+	if _, ok := c.m[key]; !ok && len(c.m) >= maxCachedIndexes {
+		// Drop a random entry. Map iteration order is random enough for this.
+		for k := range c.m {
+			delete(c.m, k)
+			break
+		}
+	}
+	c.m[key] = cachedIndex{info, idx}
+}
+
+func (c *indexCache) remove(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.m, key)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocalStoreIndexRebuild(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Break func(fname string) error
+	}{
+		{"missing", func(fname string) error { return os.Remove(fname) }},
+		{"empty", func(fname string) error { return os.WriteFile(fname, nil, 0644) }},
+		{"corrupt", func(fname string) error { return os.WriteFile(fname, []byte("broken"), 0644) }},
+		{"truncated", func(fname string) error { return os.WriteFile(fname, []byte(`{"revisions": [{"revision": 1,`), 0644) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			store := &LocalStore{Path: t.TempDir()}
+			for _, body := range []string{"v1", "v2"} {
+				if _, err := store.Put("foo/bar.txt", strings.NewReader(body), PutOptions{Labels: map[string]string{"body": body}}); err != nil {
+					t.Fatalf("failed to publish: %s", err)
+				}
+			}
+
+			fname := store.indexPath("foo/bar.txt")
+			if err := tt.Break(fname); err != nil {
+				t.Fatalf("failed to break index: %s", err)
+			}
+
+			revs, err := store.Revisions("foo/bar.txt")
+			if err != nil {
+				t.Fatalf("failed to get revisions: %s", err)
+			}
+			if len(revs) != 2 || revs[0].Labels["body"] != "v1" || revs[1].Labels["body"] != "v2" {
+				t.Errorf("unexpected revisions: %v", revs)
+			}
+
+			idx, err := store.readIndex("foo/bar.txt")
+			if err != nil {
+				t.Fatalf("index is not rebuilt: %s", err)
+			}
+			if idx.Latest() != 2 {
+				t.Errorf("unexpected latest revision in rebuilt index: %d", idx.Latest())
+			}
+
+			// Updates on the broken index rebuild it too, instead of dropping other revisions.
+			if err := tt.Break(fname); err != nil {
+				t.Fatalf("failed to break index: %s", err)
+			}
+			if _, err := store.Put("foo/bar.txt", strings.NewReader("v3"), PutOptions{}); err != nil {
+				t.Fatalf("failed to publish: %s", err)
+			}
+			if revs, _ := store.Revisions("foo/bar.txt"); len(revs) != 3 {
+				t.Errorf("unexpected revisions after update: %v", revs)
+			}
+		})
+	}
+
+	store := &LocalStore{Path: t.TempDir()}
+	if err := store.RebuildIndex("missing.txt"); err != ErrNoSuchArtifact {
+		t.Errorf("expected ErrNoSuchArtifact but got %v", err)
+	}
+}
+
+func TestLocalStoreIndexStale(t *testing.T) {
+	dir := t.TempDir()
+	store := &LocalStore{Path: dir}
+	if _, err := store.Put("foo.txt", strings.NewReader("v1"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	old, err := os.ReadFile(store.indexPath("foo.txt"))
+	if err != nil {
+		t.Fatalf("failed to read index: %s", err)
+	}
+	for _, body := range []string{"v2", "v3"} {
+		if _, err := store.Put("foo.txt", strings.NewReader(body), PutOptions{Labels: map[string]string{"body": body}}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	// Simulate a crash after committing revisions 2 and 3 but before writing the index.
+	if err := os.WriteFile(store.indexPath("foo.txt"), old, 0644); err != nil {
+		t.Fatalf("failed to write index: %s", err)
+	}
+
+	restarted := &LocalStore{Path: dir}
+	if latest, err := restarted.Latest("foo.txt"); err != nil || latest != 3 {
+		t.Errorf("expected latest revision 3 but got %d (error=%v)", latest, err)
+	}
+	if meta, err := restarted.Metadata("foo.txt", 2); err != nil || meta.Labels["body"] != "v2" {
+		t.Errorf("unexpected metadata of revision 2: %v (error=%v)", meta, err)
+	}
+
+	// The repaired index is written back.
+	if idx, err := (&LocalStore{Path: dir}).readIndex("foo.txt"); err != nil || idx.Latest() != 3 || len(idx.Revisions) != 3 {
+		t.Errorf("index is not repaired: %v (error=%v)", idx, err)
+	}
+
+	// Updates on the stale index keep the missing revisions too.
+	if err := os.WriteFile(store.indexPath("foo.txt"), old, 0644); err != nil {
+		t.Fatalf("failed to write index: %s", err)
+	}
+	restarted = &LocalStore{Path: dir}
+	if _, err := restarted.Put("foo.txt", strings.NewReader("v4"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if revs, err := restarted.Revisions("foo.txt"); err != nil || len(revs) != 4 {
+		t.Errorf("unexpected revisions after update: %v (error=%v)", revs, err)
+	}
+}
+
+func TestLocalStoreIndexCache(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	if _, err := store.Put("foo.txt", strings.NewReader("v1"), PutOptions{Labels: map[string]string{"a": "1"}}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	idx, err := store.readIndex("foo.txt")
+	if err != nil {
+		t.Fatalf("failed to read index: %s", err)
+	}
+
+	// Modifying the returned index does not affect the cache.
+	idx.Revisions[0].Labels["a"] = "modified"
+	idx.Add(Metadata{Revision: 2})
+	if again, _ := store.readIndex("foo.txt"); again.Latest() != 1 || again.Revisions[0].Labels["a"] != "1" {
+		t.Errorf("cached index is modified: %v", again)
+	}
+
+	// Changes by other processes are noticed even if the index is cached.
+	other := &LocalStore{Path: store.Path}
+	time.Sleep(10 * time.Millisecond)
+	if _, err := other.Put("foo.txt", strings.NewReader("v2"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if latest, err := store.Latest("foo.txt"); err != nil || latest != 2 {
+		t.Errorf("expected latest revision 2 but got %d (error=%v)", latest, err)
+	}
+
+	if err := os.Remove(store.indexPath("foo.txt")); err != nil {
+		t.Fatalf("failed to remove index: %s", err)
+	}
+	if _, err := store.readIndex("foo.txt"); err == nil {
+		t.Errorf("removed index is read from cache")
+	}
+}
+
+func TestIndexLocks(t *testing.T) {
+	var l indexLocks
+
+	// Find two keys in the same stripe, to check that locking both of them does not deadlock.
+	a, b := "key-0", ""
+	for i := 1; b == ""; i++ {
+		if k := "key-" + strings.Repeat("x", i); l.stripe(k) == l.stripe(a) {
+			b = k
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		unlock := l.Lock(a, b)
+		unlock()
+		unlock = l.Lock(b, "other", a)
+		unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("locking keys in the same stripe deadlocked")
+	}
+
+	// Updates of different keys run concurrently, and updates of the same key never lose revisions.
+	store := &LocalStore{Path: t.TempDir()}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		for _, key := range []string{"a.txt", "b.txt"} {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				if _, err := store.Put(key, strings.NewReader("hello"), PutOptions{}); err != nil {
+					t.Errorf("failed to publish: %s", err)
+				}
+			}(key)
+		}
+	}
+	wg.Wait()
+
+	for _, key := range []string{"a.txt", "b.txt"} {
+		if revs, err := store.Revisions(key); err != nil || len(revs) != 4 {
+			t.Errorf("%s: unexpected revisions: %v (error=%v)", key, revs, err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var infoCmd = &cobra.Command{
+	Use:   "info KEY",
+	Short: "Show metadata of an artifact",
+	Long: `Show metadata of a revision of an artifact, such as the size, hashes, labels, tags, and notes.
+
+With --output json, the metadata is printed as JSON together with the URL of the revision.
+With --quiet, only the URL of the revision is printed.`,
+	Example: `  $ artistore info release/app.zip
+  $ artistore info release/app.zip --revision 4 --output json`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		rev, _ := cmd.Flags().GetInt("revision")
+		if rev < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid --revision: %d\n", rev)
+			os.Exit(2)
+		}
+
+		if err := VerifyKey(args[0]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		client.Context = interruptContext()
+
+		// Token is optional because only keys under --private prefixes of the server require it.
+		if t, err := NewTokenHandler(); err == nil {
+			client.ReadToken, err = t.ScopedTokenFor(args[0], ScopeRead)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		} else if err != ErrNoClientCredential {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		info, err := FetchArtifactInfo(client, args[0], rev)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to fetch:", err)
+			os.Exit(1)
+		}
+
+		u, err := revisionURL(info.Key, info.Revision)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		switch format {
+		case OutputJSON:
+			printJSON(InfoResult{info, u.String()})
+		case OutputQuiet:
+			fmt.Println(u)
+		default:
+			printInfo(info, u)
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(infoCmd)
+
+	infoCmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	viper.BindPFlag("server", infoCmd.Flags().Lookup("server"))
+
+	infoCmd.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+	infoCmd.Flags().String("token", "", "Client token with read scope, to show artifacts under --private prefixes of the server. See also 'artistore help token'.")
+
+	infoCmd.Flags().IntP("revision", "r", 0, "Revision of the artifact. (default latest)")
+	addOutputFlags(infoCmd)
+
+	addTimeoutFlags(infoCmd)
+	addTLSFlags(infoCmd)
+	addRetryFlags(infoCmd)
+}
+
+// InfoResult is the output of `artistore info --output json`.
+type InfoResult struct {
+	ArtifactInfo
+	URL string `json:"url"`
+}
+
+// FetchArtifactInfo fetches the metadata of the revision of the key by the revisions API.
+// The latest revision is fetched if rev is 0.
+func FetchArtifactInfo(client *Client, key string, rev int) (ArtifactInfo, error) {
+	u, err := GetAPIURL("v1/revisions/" + key)
+	if err != nil {
+		return ArtifactInfo{}, err
+	}
+
+	cursor := ""
+	for {
+		values := url.Values{
+			"sort":  {"-revision"},
+			"limit": {strconv.Itoa(MaxQueryLimit)},
+		}
+		if cursor != "" {
+			values.Set("cursor", cursor)
+		}
+		u.RawQuery = values.Encode()
+
+		var list RevisionList
+		if err := client.CallAPI("GET", u, nil, nil, &list); err != nil {
+			return ArtifactInfo{}, err
+		}
+		if rev == 0 {
+			rev = list.Latest
+		}
+
+		for _, x := range list.Revisions {
+			if x.Revision == rev {
+				return x, nil
+			}
+		}
+
+		if list.NextCursor == "" {
+			return ArtifactInfo{}, fmt.Errorf("No such revision: %d", rev)
+		}
+		cursor = list.NextCursor
+	}
+}
+
+// printInfo prints the metadata in the human-readable format.
+func printInfo(info ArtifactInfo, u *url.URL) {
+	fmt.Printf("Key:       %s\n", info.Key)
+	fmt.Printf("Revision:  %d\n", info.Revision)
+	fmt.Printf("URL:       %s\n", u)
+	fmt.Printf("Type:      %s\n", info.Type)
+	fmt.Printf("Size:      %s\n", FormatSize(int64(info.Size)))
+	fmt.Printf("MD5:       %s\n", info.Hash)
+	if info.SHA256 != "" {
+		fmt.Printf("SHA256:    %s\n", info.SHA256)
+	}
+	fmt.Printf("Published: %s\n", info.Timestamp.Format(time.RFC3339))
+
+	if len(info.Labels) > 0 {
+		labels := make([]string, 0, len(info.Labels))
+		for k, v := range info.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		fmt.Printf("Labels:    %s\n", strings.Join(labels, ", "))
+	}
+	if len(info.Tags) > 0 {
+		fmt.Printf("Tags:      %s\n", strings.Join(info.Tags, ", "))
+	}
+	if info.Notes != "" {
+		fmt.Printf("\n%s\n", strings.TrimRight(info.Notes, "\n"))
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestFetchArtifactInfo(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+
+	store := &LocalStore{Path: t.TempDir()}
+	for i, body := range []string{"v1", "v2", "v3"} {
+		opts := PutOptions{Labels: map[string]string{"build": string(rune('1' + i))}}
+		if _, err := store.Put("secret/foo.txt", strings.NewReader(body), opts); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	if _, err := store.Patch("secret/foo.txt", 2, MetadataPatch{SetTags: true, Tags: []string{"stable"}}); err != nil {
+		t.Fatalf("failed to patch: %s", err)
+	}
+
+	ts := httptest.NewServer(Server{Secret: sec, Store: store, Private: []string{"secret/"}})
+	defer ts.Close()
+
+	viper.Set("server", ts.URL)
+	defer viper.Set("server", "")
+
+	token, _ := NewScopedToken(sec, "secret/", ScopeRead)
+	client := &Client{HTTP: &http.Client{}, Retry: RetryPolicy{MaxAttempts: 1}, ReadToken: token}
+
+	tests := []struct {
+		Revision int
+		Expected int
+		Tags     []string
+		Error    bool
+	}{
+		{0, 3, nil, false},
+		{2, 2, []string{"stable"}, false},
+		{4, 0, nil, true},
+	}
+	for _, tt := range tests {
+		info, err := FetchArtifactInfo(client, "secret/foo.txt", tt.Revision)
+		if tt.Error {
+			if err == nil {
+				t.Errorf("%d: expected error but got %v", tt.Revision, info)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: failed to fetch: %s", tt.Revision, err)
+			continue
+		}
+		if info.Key != "secret/foo.txt" || info.Revision != tt.Expected || !reflect.DeepEqual(info.Tags, tt.Tags) {
+			t.Errorf("%d: unexpected info: %v", tt.Revision, info)
+		}
+		if build := string(rune('0' + tt.Expected)); info.Labels["build"] != build {
+			t.Errorf("%d: unexpected labels: %v", tt.Revision, info.Labels)
+		}
+	}
+
+	client.ReadToken = nil
+	if _, err := FetchArtifactInfo(client, "secret/foo.txt", 0); err == nil {
+		t.Errorf("private artifact is shown without token")
+	}
+}
@@ -7,9 +7,10 @@ import (
 )
 
 var (
-	ErrEmptyKey   = errors.New("Invalid key: can not use empty key.")
-	ErrSlashKey   = errors.New("Invalid key: slash can not be the first or the last character of key.")
-	ErrInvalidKey = errors.New("Invalid key: this key contains invalid character.")
+	ErrEmptyKey    = errors.New("Invalid key: can not use empty key.")
+	ErrSlashKey    = errors.New("Invalid key: slash can not be the first or the last character of key.")
+	ErrInvalidKey  = errors.New("Invalid key: this key contains invalid character.")
+	ErrReservedKey = errors.New("Invalid key: keys start with \"" + APIPrefix + "\" are reserved.")
 
 	keyRegexp = regexp.MustCompile(`^[-._~!$&'()*+,;=:@%/a-zA-Z0-9]+$`)
 )
@@ -27,6 +28,10 @@ func VerifyKey(key string) error {
 		return ErrInvalidKey
 	}
 
+	if strings.HasPrefix(key, APIPrefix) {
+		return ErrReservedKey
+	}
+
 	return nil
 }
 
@@ -47,3 +52,12 @@ func KeyPrefixes(key string) []string {
 
 	return results
 }
+
+func HasAnyPrefix(key string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if p == "*" || strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
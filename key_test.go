@@ -23,3 +23,23 @@ func TestKeyPrefixes(t *testing.T) {
 		}
 	}
 }
+
+func TestHasAnyPrefix(t *testing.T) {
+	tests := []struct {
+		Key      string
+		Prefixes []string
+		Expect   bool
+	}{
+		{"hello/world", []string{"hello/"}, true},
+		{"hello/world", []string{"world/", "hello/"}, true},
+		{"hello/world", []string{"world/"}, false},
+		{"hello/world", []string{"*"}, true},
+		{"hello/world", nil, false},
+	}
+
+	for _, tt := range tests {
+		if ok := HasAnyPrefix(tt.Key, tt.Prefixes); ok != tt.Expect {
+			t.Errorf("%q %v: expected %v but got %v", tt.Key, tt.Prefixes, tt.Expect, ok)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// keyringService is the service name of the credentials in the OS keyring.
+const keyringService = "artistore"
+
+var (
+	ErrNoCredential           = errors.New("No credential for the server.")
+	ErrNoKeyringPassphrase    = errors.New("Passphrase for the credentials file is required.\nPlease set ARTISTORE_KEYRING_PASSPHRASE environment variable, or install secret-tool to use the OS keyring.")
+	ErrWrongKeyringPassphrase = errors.New("Failed to decrypt the credentials file. The passphrase may be wrong.")
+)
+
+// CredentialStore saves tokens for each server.
+type CredentialStore interface {
+	// Get returns the token for the server, or ErrNoCredential.
+	Get(server string) (string, error)
+
+	Set(server, token string) error
+
+	// Delete removes the token for the server. It returns ErrNoCredential if there is no token.
+	Delete(server string) error
+}
+
+// normalizeServer makes the server URL into the key of CredentialStore, so that "HTTPS://example.com/" and "https://example.com" share the same token.
+func normalizeServer(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("Invalid server URL: %s", raw)
+	}
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host) + strings.TrimSuffix(u.Path, "/"), nil
+}
+
+// NewCredentialStore selects the store by ARTISTORE_KEYRING environment variable, that is "system", "file", or empty for auto.
+// The OS keyring is used if available, otherwise the credentials file next to the config file is used.
+func NewCredentialStore(v *viper.Viper) (CredentialStore, error) {
+	mode := os.Getenv("ARTISTORE_KEYRING")
+
+	if mode == "" || mode == "system" {
+		if k, ok := systemKeyring(); ok {
+			return k, nil
+		} else if mode == "system" {
+			return nil, errors.New("OS keyring is not available on this system.")
+		}
+	} else if mode != "file" {
+		return nil, errors.New(`Invalid ARTISTORE_KEYRING: it should be "system" or "file".`)
+	}
+
+	path, err := configPath(v)
+	if err != nil {
+		return nil, err
+	}
+	return FileCredentials{
+		Path:       filepath.Join(filepath.Dir(path), "credentials"),
+		Passphrase: os.Getenv("ARTISTORE_KEYRING_PASSPHRASE"),
+	}, nil
+}
+
+// LookupCredential returns the token that saved by "artistore login" for the server in v.
+func LookupCredential(v *viper.Viper) (string, error) {
+	server, err := normalizeServer(v.GetString("server"))
+	if err != nil {
+		// The invalid URL is reported when connecting to the server.
+		return "", ErrNoCredential
+	}
+	s, err := NewCredentialStore(v)
+	if err != nil {
+		return "", err
+	}
+	return s.Get(server)
+}
+
+// systemKeyring returns the OS keyring if the command to access it is installed.
+func systemKeyring() (CredentialStore, bool) {
+	switch runtime.GOOS {
+	case "darwin":
+		if _, err := exec.LookPath("security"); err == nil {
+			return macKeychain{}, true
+		}
+	case "linux", "freebsd", "openbsd", "netbsd":
+		// secret-tool needs D-Bus session, that usually does not exist on servers.
+		if _, err := exec.LookPath("secret-tool"); err == nil && os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
+			return secretService{}, true
+		}
+	}
+	return nil, false
+}
+
+// macKeychain is the keychain of macOS that accessed by security command.
+type macKeychain struct{}
+
+func (macKeychain) Get(server string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", server, "-w").Output()
+	if err != nil {
+		// security exits with 44 if the item is not found.
+		if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 44 {
+			return "", ErrNoCredential
+		}
+		return "", fmt.Errorf("Failed to read keychain: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (macKeychain) Set(server, token string) error {
+	// The token is passed through stdin of the interactive mode, so that it does not appear in the process list.
+	c := exec.Command("security", "-i")
+	c.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %q -a %q -w %q\n", keyringService, server, token))
+	if out, err := c.CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to write keychain: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (macKeychain) Delete(server string) error {
+	err := exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", server).Run()
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 44 {
+		return ErrNoCredential
+	}
+	return err
+}
+
+// secretService is the keyring of freedesktop.org Secret Service such as GNOME Keyring, that accessed by secret-tool command.
+type secretService struct{}
+
+func (secretService) Get(server string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keyringService, "server", server).Output()
+	if err != nil {
+		// secret-tool exits with 1 and prints nothing if the item is not found.
+		if e, ok := err.(*exec.ExitError); ok && len(e.Stderr) == 0 {
+			return "", ErrNoCredential
+		}
+		return "", fmt.Errorf("Failed to read keyring: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (secretService) Set(server, token string) error {
+	c := exec.Command("secret-tool", "store", "--label", "Artistore token for "+server, "service", keyringService, "server", server)
+	c.Stdin = strings.NewReader(token)
+	if out, err := c.CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to write keyring: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (s secretService) Delete(server string) error {
+	if _, err := s.Get(server); err != nil {
+		return err
+	}
+	return exec.Command("secret-tool", "clear", "service", keyringService, "server", server).Run()
+}
+
+// pbkdf2Iterations is the number of iterations to derive the key of FileCredentials.
+const pbkdf2Iterations = 200000
+
+// pbkdf2 is PBKDF2 of RFC 8018.
+func pbkdf2(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+
+		t := make([]byte, len(u))
+		copy(t, u)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// FileCredentials is a file of tokens that encrypted by AES-256-GCM with a key derived from the passphrase.
+// It is used if the OS keyring is not available.
+type FileCredentials struct {
+	Path       string
+	Passphrase string
+}
+
+// credentialsFile is the content of FileCredentials.
+type credentialsFile struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+func (f FileCredentials) aead(salt []byte) (cipher.AEAD, error) {
+	if f.Passphrase == "" {
+		return nil, ErrNoKeyringPassphrase
+	}
+	block, err := aes.NewCipher(pbkdf2([]byte(f.Passphrase), salt, pbkdf2Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// load decrypts the file. It returns an empty map if the file does not exist.
+func (f FileCredentials) load() (map[string]string, error) {
+	tokens := make(map[string]string)
+
+	raw, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var file credentialsFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("Invalid credentials file %s: %s", f.Path, err)
+	}
+
+	aead, err := f.aead(file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("Invalid credentials file %s: invalid nonce", f.Path)
+	}
+	plain, err := aead.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, ErrWrongKeyringPassphrase
+	}
+
+	if err := json.Unmarshal(plain, &tokens); err != nil {
+		return nil, fmt.Errorf("Invalid credentials file %s: %s", f.Path, err)
+	}
+	return tokens, nil
+}
+
+// save encrypts tokens with new salt and nonce, and writes the file.
+func (f FileCredentials) save(tokens map[string]string) error {
+	plain, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+
+	file := credentialsFile{Salt: make([]byte, 16)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	aead, err := f.aead(file.Salt)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Data = aead.Seal(nil, file.Nonce, plain, nil)
+
+	raw, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(f.Path+".tmp", raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(f.Path+".tmp", f.Path)
+}
+
+func (f FileCredentials) Get(server string) (string, error) {
+	// Do not ask the passphrase if the user never logged in.
+	if _, err := os.Stat(f.Path); errors.Is(err, os.ErrNotExist) {
+		return "", ErrNoCredential
+	}
+
+	tokens, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	t, ok := tokens[server]
+	if !ok {
+		return "", ErrNoCredential
+	}
+	return t, nil
+}
+
+func (f FileCredentials) Set(server, token string) error {
+	tokens, err := f.load()
+	if err != nil {
+		return err
+	}
+	tokens[server] = token
+	return f.save(tokens)
+}
+
+func (f FileCredentials) Delete(server string) error {
+	tokens, err := f.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := tokens[server]; !ok {
+		return ErrNoCredential
+	}
+	delete(tokens, server)
+	return f.save(tokens)
+}
+
+// readSecretInput reads a line from stdin, with the prompt if stdin is a terminal.
+func readSecretInput(prompt string) (string, error) {
+	if isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		fmt.Fprint(os.Stderr, prompt)
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+var loginCmd = &cobra.Command{
+	Use:   "login SERVER_URL",
+	Short: "Save token for a server",
+	Long: `Save token for a server.
+
+The token is read from stdin instead of arguments, so that it does not remain in the shell history.
+It is saved in the OS keyring, that is the keychain on macOS or the Secret Service such as GNOME Keyring on Linux.
+
+If the OS keyring is not available, the token is saved in "credentials" file next to the config file, that encrypted with ARTISTORE_KEYRING_PASSPHRASE environment variable.
+Set ARTISTORE_KEYRING=file to always use the file.
+
+The saved token is used by publish, get, and other commands when neither --token nor --secret is given, by the URL of --server.`,
+	Example: `  $ artistore login https://artifacts.example.com
+  Token: t2:...
+
+  $ echo "$TOKEN" | artistore login https://artifacts.example.com`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		server, err := normalizeServer(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		token, err := readSecretInput("Token: ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to read token:", err)
+			os.Exit(2)
+		}
+		if _, err := ParseToken(token); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		s, err := NewCredentialStore(viper.GetViper())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if err := s.Set(server, token); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to save token:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "Saved token for", server)
+	},
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout SERVER_URL",
+	Short: "Remove token for a server",
+	Long:  `Remove token that saved by 'artistore login'.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		server, err := normalizeServer(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		s, err := NewCredentialStore(viper.GetViper())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if err := s.Delete(server); errors.Is(err, ErrNoCredential) {
+			fmt.Fprintln(os.Stderr, "Not logged in to", server)
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to remove token:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(loginCmd)
+	cmd.AddCommand(logoutCmd)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// The test vector from RFC 7914.
+	key := pbkdf2([]byte("passwd"), []byte("salt"), 1, 64, sha256.New)
+	expect := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if h := hex.EncodeToString(key); h != expect {
+		t.Errorf("unexpected key: %s", h)
+	}
+}
+
+func TestNormalizeServer(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output string
+		Error  bool
+	}{
+		{"https://example.com", "https://example.com", false},
+		{"HTTPS://Example.COM/", "https://example.com", false},
+		{"http://localhost:3000/artifacts/", "http://localhost:3000/artifacts", false},
+		{"example.com", "", true},
+		{"ftp://example.com", "", true},
+	}
+
+	for _, tt := range tests {
+		out, err := normalizeServer(tt.Input)
+		if tt.Error {
+			if err == nil {
+				t.Errorf("%s: expected error but got %q", tt.Input, out)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.Input, err)
+		} else if out != tt.Output {
+			t.Errorf("%s: expected %q but got %q", tt.Input, tt.Output, out)
+		}
+	}
+}
+
+func TestFileCredentials(t *testing.T) {
+	f := FileCredentials{Path: filepath.Join(t.TempDir(), "credentials"), Passphrase: "hello"}
+
+	if _, err := f.Get("https://example.com"); !errors.Is(err, ErrNoCredential) {
+		t.Fatalf("expected ErrNoCredential but got %v", err)
+	}
+
+	if err := f.Set("https://example.com", "t2:foo"); err != nil {
+		t.Fatalf("failed to set: %s", err)
+	}
+	if err := f.Set("https://example.net", "t2:bar"); err != nil {
+		t.Fatalf("failed to set: %s", err)
+	}
+
+	if tok, err := f.Get("https://example.com"); err != nil || tok != "t2:foo" {
+		t.Errorf("unexpected result: %q, %v", tok, err)
+	}
+
+	raw, _ := os.ReadFile(f.Path)
+	if len(raw) == 0 || strings.Contains(string(raw), "t2:foo") {
+		t.Errorf("token should be encrypted: %s", raw)
+	}
+	if info, err := os.Stat(f.Path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected permission of credentials file: %v, %v", info, err)
+	}
+
+	if _, err := (FileCredentials{Path: f.Path, Passphrase: "wrong"}).Get("https://example.com"); !errors.Is(err, ErrWrongKeyringPassphrase) {
+		t.Errorf("expected ErrWrongKeyringPassphrase but got %v", err)
+	}
+	if _, err := (FileCredentials{Path: f.Path}).Get("https://example.com"); !errors.Is(err, ErrNoKeyringPassphrase) {
+		t.Errorf("expected ErrNoKeyringPassphrase but got %v", err)
+	}
+
+	if err := f.Delete("https://example.com"); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+	if err := f.Delete("https://example.com"); !errors.Is(err, ErrNoCredential) {
+		t.Errorf("expected ErrNoCredential but got %v", err)
+	}
+	if tok, err := f.Get("https://example.net"); err != nil || tok != "t2:bar" {
+		t.Errorf("unexpected result: %q, %v", tok, err)
+	}
+}
+
+func TestNewTokenHandlerFromLogin(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	tok, err := NewToken(sec, "hello/")
+	if err != nil {
+		t.Fatalf("failed to generate token: %s", err)
+	}
+
+	dir := t.TempDir()
+	os.Setenv("ARTISTORE_KEYRING", "file")
+	os.Setenv("ARTISTORE_KEYRING_PASSPHRASE", "hello")
+	defer os.Unsetenv("ARTISTORE_KEYRING")
+	defer os.Unsetenv("ARTISTORE_KEYRING_PASSPHRASE")
+
+	viper.Set("config", filepath.Join(dir, "config.yaml"))
+	viper.Set("server", "https://example.com/")
+	viper.Set("token", "")
+	viper.Set("secret", "")
+	defer viper.Set("config", "")
+	defer viper.Set("server", "")
+
+	if _, err := NewTokenHandler(); err == nil {
+		t.Fatalf("expected error before login")
+	}
+
+	f := FileCredentials{Path: filepath.Join(dir, "credentials"), Passphrase: "hello"}
+	if err := f.Set("https://example.com", tok.String()); err != nil {
+		t.Fatalf("failed to save token: %s", err)
+	}
+
+	h, err := NewTokenHandler()
+	if err != nil {
+		t.Fatalf("failed to make token handler: %s", err)
+	}
+	if h.Token == nil || h.Token.String() != tok.String() {
+		t.Errorf("unexpected token: %v", h.Token)
+	}
+
+	viper.Set("server", "https://example.net")
+	if _, err := NewTokenHandler(); err == nil {
+		t.Errorf("token for another server should not be used")
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LayoutFileName is the name of the file that records on-disk layout of LocalStore.
+const LayoutFileName = "layout"
+
+const (
+	layoutSharded = "sharded-v1"
+
+	// layoutPadded is layoutSharded with zero-padded revision file names.
+	layoutPadded = "sharded-v2"
+)
+
+// revisionDigits is the width of revision file names.
+// File names are zero-padded, so that external tools such as backup software sort revisions in the numeric order, not "10" before "2".
+const revisionDigits = 10
+
+// migrationDirName is the directory to move keys into while migrating from the flat layout.
+// It can not conflict with any key, because url.PathEscape always escapes "!".
+const migrationDirName = "migrating!"
+
+var shardRegexp = regexp.MustCompile(`^[0-9a-f]{2}$`)
+
+// NewLocalStore makes LocalStore, and migrates the data directory to the sharded layout if needed.
+func NewLocalStore(path string, retain RetainPolicy) (*LocalStore, error) {
+	s := &LocalStore{Path: path, Retain: retain}
+	if err := s.Migrate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// revisionFileName returns the file name of the revision in the key directory.
+func revisionFileName(revision int) string {
+	return fmt.Sprintf("%0*d", revisionDigits, revision)
+}
+
+func (s *LocalStore) shard(key string) string {
+	h := sha256.Sum256([]byte(key))
+	x := hex.EncodeToString(h[:2])
+	return filepath.Join(x[:2], x[2:])
+}
+
+// keyDir returns the directory for the key.
+// Keys are sharded into 2-level hash prefix directories like "STORE/ab/cd/KEY", to keep directories small.
+func (s *LocalStore) keyDir(key string) string {
+	return filepath.Join(s.Path, s.shard(key), s.escape(key))
+}
+
+// isShardDir checks if the directory looks like a shard directory, not a key in the flat layout.
+func isShardDir(path string) bool {
+	if !shardRegexp.MatchString(filepath.Base(path)) {
+		return false
+	}
+
+	xs, err := os.ReadDir(path)
+	if err != nil {
+		return false
+	}
+	for _, x := range xs {
+		if !x.IsDir() || !shardRegexp.MatchString(x.Name()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Migrate moves the data directory into the latest layout.
+// Keys in the flat layout, that is "STORE/KEY", are moved into the sharded layout, and then revision files are renamed to zero-padded names.
+// This is safe to run again if the previous migration was interrupted.
+func (s *LocalStore) Migrate() error {
+	marker := filepath.Join(s.Path, LayoutFileName)
+
+	var layout string
+	if data, err := os.ReadFile(marker); err == nil {
+		layout = strings.TrimSpace(string(data))
+	}
+
+	switch layout {
+	case layoutPadded:
+		return nil
+	case layoutSharded:
+	default:
+		if err := s.migrateFlat(marker); err != nil {
+			return err
+		}
+	}
+
+	if err := s.padRevisionFiles(); err != nil {
+		return err
+	}
+	return os.WriteFile(marker, []byte(layoutPadded+"\n"), 0644)
+}
+
+// migrateFlat moves keys in the flat layout into the sharded layout.
+// Keys are moved into migrationDirName at first, and then moved into shards.
+func (s *LocalStore) migrateFlat(marker string) error {
+	if err := os.MkdirAll(s.Path, 0755); err != nil {
+		return err
+	}
+
+	xs, err := os.ReadDir(s.Path)
+	if err != nil {
+		return err
+	}
+
+	staging := filepath.Join(s.Path, migrationDirName)
+	for _, x := range xs {
+		if !x.IsDir() || x.Name() == migrationDirName || isShardDir(filepath.Join(s.Path, x.Name())) {
+			continue
+		}
+
+		if err := os.MkdirAll(staging, 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(s.Path, x.Name()), filepath.Join(staging, x.Name())); err != nil {
+			return err
+		}
+	}
+
+	xs, err = os.ReadDir(staging)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, x := range xs {
+		dest := s.keyDir(s.unescape(x.Name()))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(staging, x.Name()), dest); err != nil {
+			return err
+		}
+		PrintLog("MIGRATE", "%s", s.unescape(x.Name()))
+	}
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+
+	return os.WriteFile(marker, []byte(layoutSharded+"\n"), 0644)
+}
+
+// padRevisionFiles renames revision files and their sidecar files such as "2.meta" to zero-padded names.
+// Temporary files are left as is, because parseRevisionFile reads both names.
+func (s *LocalStore) padRevisionFiles() error {
+	keys, err := s.List("")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		dir := s.keyDir(key)
+		xs, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		renamed := 0
+		for _, x := range xs {
+			for _, suffix := range []string{"", patchFileSuffix, archiveIndexSuffix} {
+				rev, err := strconv.Atoi(strings.TrimSuffix(x.Name(), suffix))
+				if err != nil || (suffix != "" && !strings.HasSuffix(x.Name(), suffix)) {
+					continue
+				}
+				if name := revisionFileName(rev) + suffix; name != x.Name() {
+					if err := os.Rename(filepath.Join(dir, x.Name()), filepath.Join(dir, name)); err != nil {
+						return err
+					}
+					renamed++
+				}
+				break
+			}
+		}
+		if renamed > 0 {
+			PrintLog("MIGRATE", "%s: %d files", key, renamed)
+		}
+	}
+
+	return nil
+}
+
+// walkKeys calls fn for each key directories in the store.
+func (s *LocalStore) walkKeys(fn func(key string)) error {
+	shards, err := os.ReadDir(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, a := range shards {
+		if !a.IsDir() || !shardRegexp.MatchString(a.Name()) {
+			continue
+		}
+
+		subs, err := os.ReadDir(filepath.Join(s.Path, a.Name()))
+		if err != nil {
+			return err
+		}
+		for _, b := range subs {
+			if !b.IsDir() || !shardRegexp.MatchString(b.Name()) {
+				continue
+			}
+
+			xs, err := os.ReadDir(filepath.Join(s.Path, a.Name(), b.Name()))
+			if err != nil {
+				return err
+			}
+			for _, x := range xs {
+				if x.IsDir() {
+					fn(s.unescape(x.Name()))
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestLocalStoreMigrate(t *testing.T) {
+	dir := t.TempDir()
+	keys := []string{"ab", "hello/world", "hello.txt"}
+
+	// Make the flat layout by moving keys of a sharded store.
+	old := &LocalStore{Path: dir}
+	for _, key := range keys {
+		if _, err := old.Put(key, bytes.NewBufferString(key), PutOptions{}); err != nil {
+			t.Fatalf("%s: failed to publish: %s", key, err)
+		}
+		if err := os.Rename(old.keyDir(key), filepath.Join(dir, old.escape(key))); err != nil {
+			t.Fatalf("%s: failed to make flat layout: %s", key, err)
+		}
+	}
+
+	store, err := NewLocalStore(dir, RetainPolicy{})
+	if err != nil {
+		t.Fatalf("failed to migrate: %s", err)
+	}
+
+	list, err := store.List("")
+	if err != nil {
+		t.Fatalf("failed to list keys: %s", err)
+	}
+	if expect := []string{"ab", "hello.txt", "hello/world"}; !reflect.DeepEqual(list, expect) {
+		t.Errorf("expected keys %v but got %v", expect, list)
+	}
+
+	for _, key := range keys {
+		f, _, err := store.Get(key, 1)
+		if err != nil {
+			t.Errorf("%s: failed to get: %s", key, err)
+			continue
+		}
+		data, _ := io.ReadAll(f)
+		f.Close()
+		if string(data) != key {
+			t.Errorf("%s: unexpected content: %q", key, data)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, migrationDirName)); !os.IsNotExist(err) {
+		t.Errorf("migration directory should be removed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, LayoutFileName)); err != nil || string(data) != layoutPadded+"\n" {
+		t.Errorf("unexpected layout file: %q (error=%v)", data, err)
+	}
+
+	if _, err := NewLocalStore(dir, RetainPolicy{}); err != nil {
+		t.Errorf("failed to open migrated store: %s", err)
+	}
+}
+
+func TestLocalStoreMigratePadding(t *testing.T) {
+	dir := t.TempDir()
+
+	// Make the unpadded layout by renaming files of a padded store.
+	old := &LocalStore{Path: dir}
+	for i := 1; i <= 11; i++ {
+		if _, err := old.Put("hello", bytes.NewBufferString(strconv.Itoa(i)), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+		if err := os.Rename(filepath.Join(old.keyDir("hello"), revisionFileName(i)), filepath.Join(old.keyDir("hello"), strconv.Itoa(i))); err != nil {
+			t.Fatalf("failed to make unpadded layout: %s", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(old.keyDir("hello"), "2"+patchFileSuffix), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, LayoutFileName), []byte(layoutSharded+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewLocalStore(dir, RetainPolicy{})
+	if err != nil {
+		t.Fatalf("failed to migrate: %s", err)
+	}
+
+	xs, err := os.ReadDir(store.keyDir("hello"))
+	if err != nil {
+		t.Fatalf("failed to read key directory: %s", err)
+	}
+	var names []string
+	for _, x := range xs {
+		if x.Name() != IndexFileName {
+			names = append(names, x.Name())
+		}
+	}
+	expect := []string{"0000000001", "0000000002", "0000000002" + patchFileSuffix, "0000000003", "0000000004", "0000000005", "0000000006", "0000000007", "0000000008", "0000000009", "0000000010", "0000000011"}
+	if !reflect.DeepEqual(names, expect) {
+		t.Errorf("expected files %v but got %v", expect, names)
+	}
+
+	f, _, err := store.Get("hello", 10)
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "10" {
+		t.Errorf("unexpected content: %q", data)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, LayoutFileName)); err != nil || string(data) != layoutPadded+"\n" {
+		t.Errorf("unexpected layout file: %q (error=%v)", data, err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list [PREFIX]",
+	Short: "List artifacts in Artistore",
+	Long: `List the latest revisions of artifacts under the prefix.
+
+With --output json, the key, revision, URL, and MD5 of each artifact are printed as a JSON array.
+With --quiet, only the URLs of the latest revisions are printed.
+
+Artifacts under --private prefixes of the server are never shown.`,
+	Example: `  $ artistore list release/
+  $ artistore list release/ --output json`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		prefix := ""
+		if len(args) > 0 {
+			prefix = args[0]
+		}
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		client.Context = interruptContext()
+
+		infos, err := ListArtifacts(client, prefix)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to list:", err)
+			os.Exit(1)
+		}
+
+		if format == OutputText {
+			for _, x := range infos {
+				fmt.Printf("%s#%d\t%s\t%s\n", x.Key, x.Revision, FormatSize(int64(x.Size)), x.Timestamp.Format(time.RFC3339))
+			}
+			return
+		}
+
+		results := make([]ArtifactResult, len(infos))
+		for i, x := range infos {
+			results[i] = ArtifactResult{Key: x.Key, Revision: x.Revision, Hash: x.Hash}
+			if u, err := revisionURL(x.Key, x.Revision); err != nil {
+				results[i].Error = err.Error()
+			} else {
+				results[i].URL = u.String()
+			}
+		}
+		printResults(format, results)
+	},
+}
+
+func init() {
+	cmd.AddCommand(listCmd)
+
+	listCmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	viper.BindPFlag("server", listCmd.Flags().Lookup("server"))
+
+	addOutputFlags(listCmd)
+
+	addTimeoutFlags(listCmd)
+	addTLSFlags(listCmd)
+	addRetryFlags(listCmd)
+}
+
+// ListArtifacts fetches the latest revisions of all artifacts under the prefix, by searching "**" and following pages.
+func ListArtifacts(client *Client, prefix string) ([]ArtifactInfo, error) {
+	u, err := GetAPIURL("v1/search")
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []ArtifactInfo
+	cursor := ""
+	for {
+		values := url.Values{
+			"q":      {"**"},
+			"prefix": {prefix},
+			"limit":  {strconv.Itoa(MaxQueryLimit)},
+		}
+		if cursor != "" {
+			values.Set("cursor", cursor)
+		}
+		u.RawQuery = values.Encode()
+
+		var result SearchResult
+		if err := client.CallAPI("GET", u, nil, nil, &result); err != nil {
+			return nil, err
+		}
+		infos = append(infos, result.Results...)
+
+		if result.NextCursor == "" {
+			return infos, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// revisionURL returns the URL of the revision of the key on the server.
+func revisionURL(key string, rev int) (*url.URL, error) {
+	u, err := GetURL(key)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = url.Values{"rev": {strconv.Itoa(rev)}}.Encode()
+	return u, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestListArtifacts(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	for _, x := range []struct{ Key, Body string }{
+		{"release/a.txt", "a1"},
+		{"release/a.txt", "a2"},
+		{"release/b.txt", "b1"},
+		{"secret/c.txt", "c1"},
+		{"other/d.txt", "d1"},
+	} {
+		if _, err := store.Put(x.Key, strings.NewReader(x.Body), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	ts := httptest.NewServer(Server{Store: store, Private: []string{"secret/"}})
+	defer ts.Close()
+
+	viper.Set("server", ts.URL)
+	defer viper.Set("server", "")
+
+	client := &Client{HTTP: &http.Client{}, Retry: RetryPolicy{MaxAttempts: 1}}
+
+	tests := []struct {
+		Prefix string
+		Keys   []string
+		Revs   []int
+	}{
+		{"release/", []string{"release/a.txt", "release/b.txt"}, []int{2, 1}},
+		{"", []string{"other/d.txt", "release/a.txt", "release/b.txt"}, []int{1, 2, 1}},
+		{"secret/", nil, nil},
+	}
+	for _, tt := range tests {
+		infos, err := ListArtifacts(client, tt.Prefix)
+		if err != nil {
+			t.Errorf("%q: failed to list: %s", tt.Prefix, err)
+			continue
+		}
+		if len(infos) != len(tt.Keys) {
+			t.Errorf("%q: unexpected result: %v", tt.Prefix, infos)
+			continue
+		}
+		for i, x := range infos {
+			if x.Key != tt.Keys[i] || x.Revision != tt.Revs[i] || x.Hash == "" {
+				t.Errorf("%q: unexpected result[%d]: %v", tt.Prefix, i, x)
+			}
+		}
+	}
+
+	if u, err := revisionURL("release/a.txt", 2); err != nil || u.String() != ts.URL+"/release/a.txt?rev=2" {
+		t.Errorf("unexpected URL: %v: %v", u, err)
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"math/rand"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -26,9 +29,25 @@ var cmd = &cobra.Command{
   $ artistore publish bundle.js
 
   # 4. Use your artifact via http://localhost:3000/bundle.js`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Some flags such as --server are defined on several commands, so bind the flags of running command again.
+		viper.BindPFlags(cmd.Flags())
+
+		// Config commands have to work even if the current profile is broken, to fix it.
+		if cmd.Parent() != configCmd {
+			if err := applyProfile(viper.GetViper()); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		}
+
+		applyServerHint(viper.GetViper())
+	},
 }
 
 func init() {
+	rand.Seed(time.Now().UnixNano())
+
 	viper.SetEnvPrefix("artistore")
 	viper.AutomaticEnv()
 }
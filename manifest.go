@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Manifest is a list of artifacts to publish at once, that is read by `artistore publish --manifest`.
+type Manifest struct {
+	Artifacts []ManifestArtifact `yaml:"artifacts"`
+}
+
+type ManifestArtifact struct {
+	// Path is the local file to publish. A relative path is relative to the manifest file.
+	Path string `yaml:"path"`
+
+	// Key is the key to publish to. It is the same as Path if empty.
+	Key string `yaml:"key"`
+
+	Type   string            `yaml:"type"`
+	Labels map[string]string `yaml:"labels"`
+	Tags   []string          `yaml:"tags"`
+
+	// NotesFile is the file of release notes to attach. A relative path is relative to the manifest file.
+	NotesFile string `yaml:"notes_file"`
+	Notes     string `yaml:"-"`
+}
+
+// ReadManifest reads and validates the manifest file.
+// All files and keys are checked before publishing, so that a typo in the manifest does not leave a half-published release.
+func ReadManifest(file, prefix string) (Manifest, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	var m Manifest
+	if err := yaml.UnmarshalStrict(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("Invalid manifest: %s", err)
+	}
+	if len(m.Artifacts) == 0 {
+		return Manifest{}, errors.New("Invalid manifest: no artifacts.")
+	}
+
+	dir := filepath.Dir(file)
+	seen := make(map[string]bool)
+	for i := range m.Artifacts {
+		a := &m.Artifacts[i]
+
+		if a.Path == "" {
+			return Manifest{}, fmt.Errorf("Invalid manifest: artifacts[%d]: path is required.", i)
+		}
+		if a.Key == "" {
+			a.Key = filepath.ToSlash(a.Path)
+		}
+		a.Key = path.Join(prefix, path.Clean(a.Key))
+		if !filepath.IsAbs(a.Path) {
+			a.Path = filepath.Join(dir, a.Path)
+		}
+
+		if err := VerifyKey(a.Key); err != nil {
+			return Manifest{}, fmt.Errorf("Invalid manifest: artifacts[%d]: %s", i, err)
+		}
+		if seen[a.Key] {
+			return Manifest{}, fmt.Errorf("Invalid manifest: artifacts[%d]: %s is listed twice.", i, a.Key)
+		}
+		seen[a.Key] = true
+
+		if stat, err := os.Stat(a.Path); err != nil {
+			return Manifest{}, fmt.Errorf("Invalid manifest: artifacts[%d]: %s", i, err)
+		} else if stat.IsDir() {
+			return Manifest{}, fmt.Errorf("Invalid manifest: artifacts[%d]: %s is directory.", i, a.Path)
+		}
+
+		for name := range a.Labels {
+			if name == "" {
+				return Manifest{}, fmt.Errorf("Invalid manifest: artifacts[%d]: label name can not be empty.", i)
+			}
+		}
+		for _, tag := range a.Tags {
+			if tag == "" {
+				return Manifest{}, fmt.Errorf("Invalid manifest: artifacts[%d]: tag can not be empty.", i)
+			}
+		}
+
+		if a.NotesFile != "" {
+			if !filepath.IsAbs(a.NotesFile) {
+				a.NotesFile = filepath.Join(dir, a.NotesFile)
+			}
+			if a.Notes, err = ReadNotes(a.NotesFile); err != nil {
+				return Manifest{}, fmt.Errorf("Invalid manifest: artifacts[%d]: %s", i, err)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// ManifestResult is the machine-readable summary of `artistore publish --manifest`.
+type ManifestResult struct {
+	OK        bool                     `json:"ok"`
+	Artifacts []ManifestArtifactResult `json:"artifacts"`
+}
+
+type ManifestArtifactResult struct {
+	Path     string `json:"path"`
+	Key      string `json:"key"`
+	Status   string `json:"status"`
+	Revision int    `json:"revision,omitempty"`
+	Location string `json:"location,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Publish publishes artifacts in the order of the manifest.
+// It stops at the first failure, and the rest of artifacts are reported as "skipped".
+func (m Manifest) Publish(client *Client, t TokenHandler) ManifestResult {
+	result := ManifestResult{OK: true, Artifacts: make([]ManifestArtifactResult, len(m.Artifacts))}
+
+	for i, a := range m.Artifacts {
+		r := &result.Artifacts[i]
+		r.Path = a.Path
+		r.Key = a.Key
+
+		if !result.OK {
+			r.Status = "skipped"
+			continue
+		}
+
+		location, rev, err := a.publish(client, t)
+		r.Location = location
+		r.Revision = rev
+		if err != nil {
+			r.Status = "failed"
+			r.Error = strings.TrimSpace(err.Error())
+			result.OK = false
+		} else {
+			r.Status = "published"
+		}
+	}
+
+	return result
+}
+
+func (a ManifestArtifact) publish(client *Client, t TokenHandler) (location string, revision int, err error) {
+	u, err := GetURL(a.Key)
+	if err != nil {
+		return "", 0, err
+	}
+
+	token, err := t.TokenFor(a.Key)
+	if err != nil {
+		return "", 0, err
+	}
+
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	location, err = client.PostArtifact(u, token, f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	revision, err = locationRevision(location)
+	if err != nil {
+		return location, 0, err
+	}
+
+	if patch := a.patch(); patch != nil {
+		q := u.Query()
+		q.Set("rev", strconv.Itoa(revision))
+		u.RawQuery = q.Encode()
+
+		if err := client.CallAPI("PATCH", u, token, patch, nil); err != nil {
+			return location, revision, fmt.Errorf("Published but failed to set metadata: %s", err)
+		}
+	}
+
+	return location, revision, nil
+}
+
+// patch returns JSON merge patch to set metadata of the artifact, or nil if nothing to set.
+func (a ManifestArtifact) patch() map[string]interface{} {
+	p := make(map[string]interface{})
+	if a.Type != "" {
+		p["type"] = a.Type
+	}
+	if len(a.Labels) > 0 {
+		p["labels"] = a.Labels
+	}
+	if len(a.Tags) > 0 {
+		p["tags"] = a.Tags
+	}
+	if a.Notes != "" {
+		p["notes"] = a.Notes
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
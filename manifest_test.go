@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func writeManifest(t *testing.T, dir, manifest string, files ...string) string {
+	t.Helper()
+	for _, name := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte("content of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := filepath.Join(dir, "artifacts.yaml")
+	if err := os.WriteFile(p, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestReadManifest(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Manifest string
+		Error    string
+	}{
+		{"valid", "artifacts:\n  - path: dist/app.js\n    key: app.js\n", ""},
+		{"empty", "artifacts: []\n", "no artifacts"},
+		{"unknown-field", "artifacts:\n  - path: dist/app.js\n    revision: 1\n", "Invalid manifest"},
+		{"no-path", "artifacts:\n  - key: app.js\n", "path is required"},
+		{"missing-file", "artifacts:\n  - path: dist/missing.js\n", "artifacts[0]"},
+		{"duplicated", "artifacts:\n  - path: dist/app.js\n    key: app.js\n  - path: dist/lib.js\n    key: app.js\n", "listed twice"},
+		{"directory", "artifacts:\n  - path: dist\n", "is directory"},
+		{"empty-tag", "artifacts:\n  - path: dist/app.js\n    tags: ['']\n", "tag can not be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			p := writeManifest(t, t.TempDir(), tt.Manifest, "dist/app.js", "dist/lib.js")
+			m, err := ReadManifest(p, "release/")
+			if tt.Error == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if m.Artifacts[0].Key != "release/app.js" || m.Artifacts[0].Path != filepath.Join(filepath.Dir(p), "dist", "app.js") {
+					t.Errorf("unexpected artifact: %v", m.Artifacts[0])
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.Error) {
+				t.Errorf("expected error contains %q but got %v", tt.Error, err)
+			}
+		})
+	}
+}
+
+func TestManifestPublish(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewToken(sec, "release/")
+
+	store := &LocalStore{Path: t.TempDir()}
+	ts := httptest.NewServer(Server{
+		Secret:       sec,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+	})
+	defer ts.Close()
+
+	viper.Set("server", ts.URL)
+	defer viper.Set("server", "")
+
+	p := writeManifest(t, t.TempDir(), `artifacts:
+  - path: app.js
+    type: text/javascript
+    labels: {commit: abc}
+    tags: [stable]
+  - path: lib.js
+    key: ../outside.js
+  - path: app.js
+    key: never.js
+`, "app.js", "lib.js")
+
+	m, err := ReadManifest(p, "release/")
+	if err != nil {
+		t.Fatalf("failed to read manifest: %s", err)
+	}
+
+	client := &Client{HTTP: &http.Client{}, Retry: RetryPolicy{MaxAttempts: 1}}
+	result := m.Publish(client, TokenHandler{Token: token})
+
+	if result.OK {
+		t.Errorf("publish to outside of the token should fail")
+	}
+	var statuses []string
+	for _, a := range result.Artifacts {
+		statuses = append(statuses, a.Status)
+	}
+	if !reflect.DeepEqual(statuses, []string{"published", "failed", "skipped"}) {
+		t.Errorf("unexpected statuses: %v", result.Artifacts)
+	}
+	if result.Artifacts[0].Revision != 1 || result.Artifacts[1].Error == "" {
+		t.Errorf("unexpected result: %v", result.Artifacts)
+	}
+
+	meta, err := store.Metadata("release/app.js", 1)
+	if err != nil {
+		t.Fatalf("failed to get metadata: %s", err)
+	}
+	if meta.Type != "text/javascript" || meta.Labels["commit"] != "abc" || !reflect.DeepEqual(meta.Tags, []string{"stable"}) {
+		t.Errorf("metadata is not applied: %v", meta)
+	}
+	if _, err := store.Latest("release/never.js"); err != ErrNoSuchArtifact {
+		t.Errorf("artifacts after the failure should not be published: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MetadataFormat is the version of metadata in the extra field of gzip header.
+// Metadata without the format field was written by old versions, and is treated as the same as the current format.
+const MetadataFormat = 1
+
+// MaxMetadataSize is the maximum size of the extra field of gzip header.
+// gzip allows up to 64KiB, but metadata written by Artistore is much smaller because labels, tags, and notes are stored in the patch file.
+const MaxMetadataSize = 16 * 1024
+
+// MaxMetadataTypeSize is the maximum length of the content type in metadata.
+const MaxMetadataTypeSize = 256
+
+var (
+	ErrInvalidMetadata = errors.New("Invalid metadata in the store file.")
+)
+
+var (
+	md5Pattern    = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// storedMetadata is the metadata in the extra field of gzip header.
+type storedMetadata struct {
+	Format int `json:"format,omitempty"`
+	Metadata
+}
+
+// encodeMetadata encodes metadata for the extra field of gzip header.
+func encodeMetadata(meta Metadata) ([]byte, error) {
+	data, err := json.Marshal(storedMetadata{MetadataFormat, meta})
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxMetadataSize {
+		return nil, fmt.Errorf("%w: it is %d bytes but should be %d bytes or less.", ErrInvalidMetadata, len(data), MaxMetadataSize)
+	}
+	return data, nil
+}
+
+// decodeMetadata decodes and validates the extra field of gzip header.
+// Store files can be written by old versions or external tools, so nothing in them is trusted.
+func decodeMetadata(extra []byte) (Metadata, error) {
+	if len(extra) > MaxMetadataSize {
+		return Metadata{}, fmt.Errorf("%w: it is %d bytes but should be %d bytes or less.", ErrInvalidMetadata, len(extra), MaxMetadataSize)
+	}
+
+	var m storedMetadata
+	dec := json.NewDecoder(bytes.NewReader(extra))
+	if err := dec.Decode(&m); err != nil {
+		return Metadata{}, fmt.Errorf("%w: %s", ErrInvalidMetadata, err)
+	}
+	if dec.More() {
+		return Metadata{}, fmt.Errorf("%w: unexpected data after metadata.", ErrInvalidMetadata)
+	}
+
+	if m.Format < 0 || m.Format > MetadataFormat {
+		return Metadata{}, fmt.Errorf("%w: unsupported format %d. Please upgrade Artistore.", ErrInvalidMetadata, m.Format)
+	}
+	if err := validateMetadata(m.Metadata); err != nil {
+		return Metadata{}, err
+	}
+
+	return m.Metadata, nil
+}
+
+func validateMetadata(meta Metadata) error {
+	switch {
+	case meta.Revision <= 0:
+		return fmt.Errorf("%w: invalid revision %d.", ErrInvalidMetadata, meta.Revision)
+	case meta.Size < 0:
+		return fmt.Errorf("%w: invalid size %d.", ErrInvalidMetadata, meta.Size)
+	case !md5Pattern.MatchString(meta.Hash):
+		return fmt.Errorf("%w: invalid md5 %q.", ErrInvalidMetadata, meta.Hash)
+	case meta.SHA256 != "" && !sha256Pattern.MatchString(meta.SHA256):
+		return fmt.Errorf("%w: invalid sha256 %q.", ErrInvalidMetadata, meta.SHA256)
+	case len(meta.Type) > MaxMetadataTypeSize || strings.ContainsAny(meta.Type, "\r\n\x00"):
+		// The type is sent as Content-Type header, so it must not break the response.
+		return fmt.Errorf("%w: invalid type.", ErrInvalidMetadata)
+	case len(meta.Notes) > MaxNotesSize:
+		return fmt.Errorf("%w: notes are too long.", ErrInvalidMetadata)
+	}
+
+	for name := range meta.Labels {
+		if name == "" {
+			return fmt.Errorf("%w: label name can not be empty.", ErrInvalidMetadata)
+		}
+	}
+	for _, tag := range meta.Tags {
+		if tag == "" {
+			return fmt.Errorf("%w: tag can not be empty.", ErrInvalidMetadata)
+		}
+	}
+
+	return nil
+}
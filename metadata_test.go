@@ -0,0 +1,84 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeMetadata(t *testing.T) {
+	md5 := strings.Repeat("0", 32)
+
+	tests := []struct {
+		Input string
+		Error bool
+	}{
+		{`{"revision":1,"type":"text/plain","size":5,"md5":"` + md5 + `"}`, false},
+		{`{"format":1,"revision":1,"type":"text/plain","size":5,"md5":"` + md5 + `"}    `, false},
+		{`{"format":2,"revision":1,"type":"text/plain","size":5,"md5":"` + md5 + `"}`, true},
+		{`{"revision":0,"type":"text/plain","size":5,"md5":"` + md5 + `"}`, true},
+		{`{"revision":1,"type":"text/plain","size":-1,"md5":"` + md5 + `"}`, true},
+		{`{"revision":1,"type":"text/plain","size":5,"md5":"abc"}`, true},
+		{`{"revision":1,"type":"text/plain","size":5,"md5":"` + md5 + `","sha256":"abc"}`, true},
+		{`{"revision":1,"type":"text/plain\r\nX-Injected: 1","size":5,"md5":"` + md5 + `"}`, true},
+		{`{"revision":1,"type":"text/plain","size":5,"md5":"` + md5 + `","labels":{"":"x"}}`, true},
+		{`{"revision":1,"type":"text/plain","size":5,"md5":"` + md5 + `"}{}`, true},
+		{`{"revision":1,"type":"text/plain","size":5,"md5":"` + md5 + `","notes":"` + strings.Repeat("a", MaxMetadataSize) + `"}`, true},
+		{`not json`, true},
+	}
+
+	for _, tt := range tests {
+		_, err := decodeMetadata([]byte(tt.Input))
+		if tt.Error && !errors.Is(err, ErrInvalidMetadata) {
+			t.Errorf("%.80s: expected ErrInvalidMetadata but got %v", tt.Input, err)
+		} else if !tt.Error && err != nil {
+			t.Errorf("%.80s: unexpected error: %s", tt.Input, err)
+		}
+	}
+}
+
+func TestEncodeMetadata(t *testing.T) {
+	meta := Metadata{Key: "hello", Revision: 2, Type: "text/plain", Size: 5, Hash: strings.Repeat("a", 32)}
+
+	data, err := encodeMetadata(meta)
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+	if !strings.Contains(string(data), `"format":1`) {
+		t.Errorf("format version should be recorded: %s", data)
+	}
+
+	decoded, err := decodeMetadata(data)
+	if err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	if decoded.Revision != 2 || decoded.Hash != meta.Hash || decoded.Key != "" {
+		t.Errorf("unexpected metadata: %#v", decoded)
+	}
+
+	meta.Labels = map[string]string{"huge": strings.Repeat("a", MaxMetadataSize)}
+	if _, err := encodeMetadata(meta); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("too large metadata should be rejected: %v", err)
+	}
+}
+
+func TestVerifyRevisionWithMaliciousExtra(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "1.gz")
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+	z := gzip.NewWriter(f)
+	z.Name = "hello"
+	z.Extra = []byte(strings.Repeat(" ", MaxMetadataSize) + `{"revision":1}`)
+	z.Write([]byte("hello"))
+	z.Close()
+	f.Close()
+
+	if err := verifyRevision(fname, "hello", 1, true); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("expected ErrInvalidMetadata but got %v", err)
+	}
+}
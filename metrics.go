@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of metrics. They are also used by dashboards and alert rules generated by 'artistore observability export'.
+const (
+	MetricFirstByte  = "artistore_first_byte_seconds"
+	MetricThroughput = "artistore_transfer_bytes_per_second"
+	MetricIngress    = "artistore_ingress_bytes_total"
+	MetricEgress     = "artistore_egress_bytes_total"
+
+	MetricCorruptRevisions = "artistore_corrupt_revisions_total"
+)
+
+var (
+	// firstByteBuckets are upper bounds in seconds of the time-to-first-byte histogram.
+	firstByteBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+	// throughputBuckets are upper bounds in bytes per second of the transfer speed histogram.
+	throughputBuckets = []float64{1e5, 1e6, 1e7, 5e7, 1e8, 2.5e8, 5e8, 1e9}
+)
+
+// Histogram counts observations in cumulative buckets, in the same way as Prometheus histograms.
+type Histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+type metricLabels struct {
+	Prefix string
+	Size   string
+}
+
+// metricPrefix is the label for the key. It is the first directory of the key, so that the number of labels does not grow too much.
+func metricPrefix(key string) string {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i+1]
+	}
+	return "/"
+}
+
+// metricSizeBucket is the label for the artifact size.
+func metricSizeBucket(size int) string {
+	switch {
+	case size < 1024*1024:
+		return "0-1MB"
+	case size < 100*1024*1024:
+		return "1MB-100MB"
+	default:
+		return "100MB+"
+	}
+}
+
+// Metrics records time-to-first-byte and transfer speed of artifact responses, labeled by prefix and size.
+// All methods do nothing on nil, so that a Server without metrics works as well.
+type Metrics struct {
+	lock       sync.Mutex
+	firstByte  map[metricLabels]*Histogram
+	throughput map[metricLabels]*Histogram
+	bandwidth  map[bandwidthLabels]*Bandwidth
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		firstByte:  make(map[metricLabels]*Histogram),
+		throughput: make(map[metricLabels]*Histogram),
+		bandwidth:  make(map[bandwidthLabels]*Bandwidth),
+	}
+}
+
+// ObserveTransfer records a response of the artifact in size bytes.
+// firstByte is the time until the first byte is written, and transfer is the time from the first byte to the end.
+func (m *Metrics) ObserveTransfer(key string, size int, firstByte, transfer time.Duration, written int64) {
+	if m == nil {
+		return
+	}
+
+	l := metricLabels{metricPrefix(key), metricSizeBucket(size)}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	h, ok := m.firstByte[l]
+	if !ok {
+		h = NewHistogram(firstByteBuckets)
+		m.firstByte[l] = h
+	}
+	h.Observe(firstByte.Seconds())
+
+	if transfer > 0 && written > 0 {
+		h, ok := m.throughput[l]
+		if !ok {
+			h = NewHistogram(throughputBuckets)
+			m.throughput[l] = h
+		}
+		h.Observe(float64(written) / transfer.Seconds())
+	}
+}
+
+// WriteTo writes metrics in Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var sb strings.Builder
+	writeHistograms(&sb, MetricFirstByte, "Time to the first byte of artifact responses.", m.firstByte)
+	writeHistograms(&sb, MetricThroughput, "Transfer speed of artifact responses after the first byte.", m.throughput)
+	writeBandwidth(&sb, m.bandwidth)
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+func writeHistograms(sb *strings.Builder, name, help string, hs map[metricLabels]*Histogram) {
+	fmt.Fprintf(sb, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(sb, "# TYPE %s histogram\n", name)
+
+	labels := make([]metricLabels, 0, len(hs))
+	for l := range hs {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].Prefix != labels[j].Prefix {
+			return labels[i].Prefix < labels[j].Prefix
+		}
+		return labels[i].Size < labels[j].Size
+	})
+
+	for _, l := range labels {
+		h := hs[l]
+		ls := fmt.Sprintf("prefix=%s,size=%s", strconv.Quote(l.Prefix), strconv.Quote(l.Size))
+		for i, b := range h.bounds {
+			fmt.Fprintf(sb, "%s_bucket{%s,le=\"%s\"} %d\n", name, ls, strconv.FormatFloat(b, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(sb, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, ls, h.count)
+		fmt.Fprintf(sb, "%s_sum{%s} %s\n", name, ls, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(sb, "%s_count{%s} %d\n", name, ls, h.count)
+	}
+}
+
+// transferRecorder measures the time to the first byte and the written bytes of a response.
+type transferRecorder struct {
+	http.ResponseWriter
+
+	status    int
+	firstByte time.Time
+	written   int64
+}
+
+func (r *transferRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *transferRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.firstByte.IsZero() {
+		r.firstByte = time.Now()
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.written += int64(n)
+	return n, err
+}
+
+func (s Server) ServeMetrics(path string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+		return
+	}
+
+	if s.Metrics == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "Metrics are not enabled on this server.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	s.Metrics.WriteTo(w)
+	writeStoreMetrics(w, s.Store)
+}
+
+// writeStoreMetrics writes metrics that the store counts by itself.
+func writeStoreMetrics(w io.Writer, store Store) {
+	for _, l := range storeLayers(store) {
+		if c, ok := l.(CorruptionCounter); ok {
+			fmt.Fprintf(w, "# HELP %s Revisions quarantined because they were corrupted when read.\n", MetricCorruptRevisions)
+			fmt.Fprintf(w, "# TYPE %s counter\n", MetricCorruptRevisions)
+			fmt.Fprintf(w, "%s %d\n", MetricCorruptRevisions, c.CorruptRevisions())
+			return
+		}
+	}
+}
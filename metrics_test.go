@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{1, 10})
+	for _, v := range []float64{0.5, 1, 5, 100} {
+		h.Observe(v)
+	}
+
+	if h.counts[0] != 2 || h.counts[1] != 3 || h.count != 4 || h.sum != 106.5 {
+		t.Errorf("unexpected histogram: %v count=%d sum=%v", h.counts, h.count, h.sum)
+	}
+}
+
+func TestMetricsLabels(t *testing.T) {
+	tests := []struct {
+		Key    string
+		Prefix string
+	}{
+		{"hello.txt", "/"},
+		{"release/app.tar.gz", "release/"},
+		{"release/1.0/app.tar.gz", "release/"},
+	}
+	for _, tt := range tests {
+		if p := metricPrefix(tt.Key); p != tt.Prefix {
+			t.Errorf("%s: expected %q but got %q", tt.Key, tt.Prefix, p)
+		}
+	}
+
+	sizes := map[int]string{0: "0-1MB", 1024 * 1024: "1MB-100MB", 200 * 1024 * 1024: "100MB+"}
+	for size, expect := range sizes {
+		if b := metricSizeBucket(size); b != expect {
+			t.Errorf("%d bytes: expected %q but got %q", size, expect, b)
+		}
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	admin, _ := NewToken(sec, APIPrefix)
+
+	store := &LocalStore{Path: t.TempDir()}
+	if _, err := store.Put("release/hello.txt", strings.NewReader("hello world"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	s := Server{Secret: sec, Store: store, Metrics: NewMetrics()}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/release/hello.txt?rev=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to get artifact: %d", w.Code)
+	}
+
+	s.Metrics.ObserveTransfer("release/hello.txt", 11, time.Millisecond, time.Second, 1000)
+
+	r := httptest.NewRequest("GET", "/_api/v1/metrics", nil)
+	r.Header.Set("Authorization", "bearer "+admin.String())
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to get metrics: %d: %s", w.Code, w.Body.String())
+	}
+
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE artistore_first_byte_seconds histogram",
+		`artistore_first_byte_seconds_count{prefix="release/",size="0-1MB"} 2`,
+		`artistore_transfer_bytes_per_second_bucket{prefix="release/",size="0-1MB",le="1e+06"}`,
+		`artistore_first_byte_seconds_bucket{prefix="release/",size="0-1MB",le="+Inf"} 2`,
+		"artistore_corrupt_revisions_total 0",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("metrics should contain %q\n%s", line, body)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/_api/v1/metrics", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("metrics should require admin token but got %d", w.Code)
+	}
+}
+
+func TestServeBandwidth(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	admin, _ := NewToken(sec, APIPrefix)
+	token, _ := NewToken(sec, "release/")
+	other, _ := NewToken(sec, "other/")
+
+	s := Server{
+		Secret:       sec,
+		Store:        &LocalStore{Path: t.TempDir()},
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+		Metrics:      NewMetrics(),
+	}
+
+	request := func(method, path, body string, token Token) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != nil {
+			r.Header.Set("Authorization", "bearer "+token.String())
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request("POST", "/release/hello.txt", "hello world", token); w.Code != http.StatusCreated {
+		t.Fatalf("failed to publish: %d: %s", w.Code, w.Body.String())
+	}
+	request("GET", "/release/hello.txt?rev=1", "", token)
+	request("GET", "/release/hello.txt?rev=1", "", nil)
+	request("GET", "/release/hello.txt?rev=1", "", other)
+
+	w := request("GET", "/_api/v1/bandwidth?prefix=release/", "", admin)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to get bandwidth: %d: %s", w.Code, w.Body.String())
+	}
+
+	var list BandwidthList
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("failed to parse response: %s", err)
+	}
+
+	expected := []Bandwidth{
+		{Token: AnonymousToken, Prefix: "release/", Egress: 22},
+		{Token: token.Fingerprint(), Prefix: "release/", Ingress: 11, Egress: 11},
+	}
+	if token.Fingerprint() < AnonymousToken {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	if !reflect.DeepEqual(list.Bandwidth, expected) {
+		t.Errorf("unexpected bandwidth: %v", list.Bandwidth)
+	}
+
+	w = request("GET", "/_api/v1/metrics", "", admin)
+	line := `artistore_ingress_bytes_total{token="` + token.Fingerprint() + `",prefix="release/"} 11`
+	if !strings.Contains(w.Body.String(), line) {
+		t.Errorf("metrics should contain %q\n%s", line, w.Body.String())
+	}
+}
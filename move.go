@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrKeyExists = errors.New("The destination key already has revisions.")
+
+// MovedSuffix is the suffix of marker files that are left in the directory of a moved key.
+// The marker is named after the latest revision that was moved, and its content is the destination key.
+// The revision number is reserved by the marker, so that new revisions of the old key never reuse the numbers of moved revisions.
+const MovedSuffix = ".moved"
+
+// gzip header flags.
+const (
+	gzipFlagHCRC    = 1 << 1
+	gzipFlagExtra   = 1 << 2
+	gzipFlagName    = 1 << 3
+	gzipFlagComment = 1 << 4
+)
+
+// rewriteGzipName copies a gzip stream from r to w, replacing the file name in the header.
+// The extra field stays at gzipExtraOffset, and the compressed data is copied as is because the CRC covers only the content.
+func rewriteGzipName(w io.Writer, r io.Reader, name string) error {
+	br := bufio.NewReader(r)
+
+	head := make([]byte, 10)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return fmt.Errorf("invalid gzip header: %s", err)
+	}
+	if head[0] != 0x1f || head[1] != 0x8b {
+		return errors.New("invalid gzip header: bad magic number")
+	}
+	flags := head[3]
+	// The header checksum would be wrong after rewriting, and Go never writes it.
+	head[3] = (flags | gzipFlagName) &^ gzipFlagHCRC
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+
+	if flags&gzipFlagExtra != 0 {
+		xlen := make([]byte, 2)
+		if _, err := io.ReadFull(br, xlen); err != nil {
+			return fmt.Errorf("invalid gzip header: %s", err)
+		}
+		if _, err := w.Write(xlen); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, br, int64(xlen[0])|int64(xlen[1])<<8); err != nil {
+			return fmt.Errorf("invalid gzip header: %s", err)
+		}
+	}
+
+	if flags&gzipFlagName != 0 {
+		if _, err := br.ReadBytes(0); err != nil {
+			return fmt.Errorf("invalid gzip header: %s", err)
+		}
+	}
+	if _, err := w.Write(append([]byte(name), 0)); err != nil {
+		return err
+	}
+
+	if flags&gzipFlagComment != 0 {
+		comment, err := br.ReadBytes(0)
+		if err != nil {
+			return fmt.Errorf("invalid gzip header: %s", err)
+		}
+		if _, err := w.Write(comment); err != nil {
+			return err
+		}
+	}
+	if flags&gzipFlagHCRC != 0 {
+		if _, err := br.Discard(2); err != nil {
+			return fmt.Errorf("invalid gzip header: %s", err)
+		}
+	}
+
+	_, err := io.Copy(w, br)
+	return err
+}
+
+// copyRevisionFile writes the revision file src as a revision of key into the temporary file dst.
+// dst is created exclusively, so that it reserves the revision number against concurrent Put.
+func copyRevisionFile(dst, src, key string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return ErrKeyExists
+	} else if err != nil {
+		return err
+	}
+
+	if err := rewriteGzipName(out, in, key); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// Move moves all revisions of src to dst, with their numbers, tags, labels, and other metadata.
+// It returns the latest revision that was moved.
+//
+// dst must not have any revision, including deleted or quarantined ones, otherwise it returns ErrKeyExists.
+// If redirect is true, a marker is left in src so that MovedTo can find dst. Otherwise src becomes a deleted key.
+// Revisions that are being published to src while moving stay in src.
+func (s *LocalStore) Move(src, dst string, redirect bool) (latest int, err error) {
+	defer s.indexLock.Lock(src, dst)()
+
+	if n, err := s.latestFile(dst); err != nil && err != ErrNoSuchArtifact {
+		return 0, err
+	} else if n > 0 {
+		return 0, ErrKeyExists
+	}
+
+	srcDir, dstDir := s.keyDir(src), s.keyDir(dst)
+	xs, err := os.ReadDir(srcDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrNoSuchArtifact
+	} else if err != nil {
+		return 0, err
+	}
+
+	var revs []int
+	var sidecars []string
+	for _, x := range xs {
+		name := x.Name()
+		if rev, temp, err := parseRevisionFile(name); err == nil {
+			if !temp {
+				revs = append(revs, rev)
+			}
+			continue
+		}
+		for _, suffix := range []string{patchFileSuffix, archiveIndexSuffix, CorruptSuffix} {
+			if rev, err := strconv.Atoi(strings.TrimSuffix(name, suffix)); err == nil && strings.HasSuffix(name, suffix) {
+				sidecars = append(sidecars, name)
+				if suffix == CorruptSuffix && rev > latest {
+					latest = rev
+				}
+				break
+			}
+		}
+	}
+	for _, rev := range revs {
+		if rev > latest {
+			latest = rev
+		}
+	}
+	if len(revs) == 0 {
+		return 0, ErrNoSuchArtifact
+	}
+
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return 0, err
+	}
+
+	// All revisions are written to temporary files first, so that dst never has a part of revisions.
+	var temps []string
+	cleanup := func() {
+		for _, t := range temps {
+			os.Remove(t)
+		}
+	}
+	for _, rev := range revs {
+		name := revisionFileName(rev)
+		if _, err := os.Stat(filepath.Join(dstDir, name)); err == nil {
+			cleanup()
+			return 0, ErrKeyExists
+		}
+
+		temp := filepath.Join(dstDir, tempFilePrefix+name)
+		if err := copyRevisionFile(temp, filepath.Join(srcDir, name), dst); err != nil {
+			cleanup()
+			return 0, err
+		}
+		temps = append(temps, temp)
+	}
+
+	for _, name := range sidecars {
+		if err := os.Rename(filepath.Join(srcDir, name), filepath.Join(dstDir, name)); err != nil {
+			cleanup()
+			return 0, err
+		}
+	}
+	for _, rev := range revs {
+		name := revisionFileName(rev)
+		if err := os.Rename(filepath.Join(dstDir, tempFilePrefix+name), filepath.Join(dstDir, name)); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := s.rebuildIndex(dst); err != nil {
+		return 0, err
+	}
+
+	// dst is complete here. A crash below leaves revisions in both keys, but never loses them.
+	// The marker is written before removing revisions, so that a concurrent Put to src never reuses their numbers.
+	if redirect {
+		if err := os.WriteFile(filepath.Join(srcDir, revisionFileName(latest)+MovedSuffix), []byte(dst+"\n"), 0644); err != nil {
+			return 0, err
+		}
+	} else if err := os.WriteFile(filepath.Join(srcDir, revisionFileName(latest)+DeletedSuffix), nil, 0644); err != nil {
+		return 0, err
+	}
+	for _, rev := range revs {
+		if err := os.Remove(filepath.Join(srcDir, revisionFileName(rev))); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+	if _, err := s.rebuildIndex(src); err != nil {
+		return 0, err
+	}
+
+	s.sweeper.lock.Lock()
+	delete(s.sweeper.state, src)
+	s.sweeper.lock.Unlock()
+
+	return latest, nil
+}
+
+// MovedTo returns the key that the revision of key has been moved to by Move with redirect.
+// The revision 0 means the latest revision.
+func (s *LocalStore) MovedTo(key string, revision int) (string, bool) {
+	xs, err := os.ReadDir(s.keyDir(key))
+	if err != nil {
+		return "", false
+	}
+
+	var markers []int
+	for _, x := range xs {
+		if !strings.HasSuffix(x.Name(), MovedSuffix) {
+			continue
+		}
+		if rev, err := strconv.Atoi(strings.TrimSuffix(x.Name(), MovedSuffix)); err == nil {
+			markers = append(markers, rev)
+		}
+	}
+	if len(markers) == 0 {
+		return "", false
+	}
+	sort.Ints(markers)
+
+	// Each marker covers revisions after the previous marker.
+	i := len(markers) - 1
+	if revision > 0 {
+		i = sort.SearchInts(markers, revision)
+		if i == len(markers) {
+			return "", false
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.keyDir(key), revisionFileName(markers[i])+MovedSuffix))
+	if err != nil {
+		return "", false
+	}
+	dst := strings.TrimSpace(string(data))
+	if VerifyKey(dst) != nil {
+		return "", false
+	}
+	return dst, true
+}
+
+// moveKey moves all revisions of another key to key, for "POST /KEY?move-from=SRC".
+//
+// The token has to be allowed to publish key, and to delete SRC.
+// If redirect=true is set, SRC and its revisions are redirected to key with 301 Moved Permanently until SRC is published again.
+func (s Server) moveKey(key string, w http.ResponseWriter, r *http.Request) {
+	src := r.URL.Query().Get("move-from")
+	if err := VerifyKey(src); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid move-from: %s\n", err)
+		return
+	}
+	if src == key {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Can not move a key to itself.")
+		return
+	}
+
+	redirect := false
+	if v := r.URL.Query().Get("redirect"); v != "" {
+		var err error
+		if redirect, err = strconv.ParseBool(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid redirect: it should be true or false.")
+			return
+		}
+	}
+
+	if !s.authorize(key, ScopePublish, w, r) || !s.authorize(src, ScopeDelete, w, r) {
+		return
+	}
+
+	var mover Mover
+	for _, l := range storeLayers(s.Store) {
+		if m, ok := l.(Mover); ok {
+			mover = m
+			break
+		}
+	}
+	if mover == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "This store does not support moving keys.")
+		return
+	}
+
+	latest, err := mover.Move(src, key, redirect)
+	switch err {
+	case nil:
+	case ErrNoSuchArtifact:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, err)
+		return
+	case ErrKeyExists:
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(w, err)
+		return
+	case ErrCircuitOpen:
+		s.unavailable(w)
+		return
+	default:
+		PrintErr("ERROR", "move %s to %s: %s", src, key, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	PrintImportant("MOVE", "%s to %s (latest revision %d) %s", src, key, latest, r.RemoteAddr)
+	s.Webhooks.Send("move", Metadata{Key: key, Revision: latest})
+	s.Purge.Published(src, latest)
+	s.Purge.Published(key, latest)
+
+	w.Header().Set("Location", s.pathTo(key, latest))
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, "http://"+r.Host+s.pathTo(key, latest))
+}
+
+// redirectMoved sends 301 Moved Permanently if the revision of key has been moved to another key.
+// The revision 0 means the latest revision. It returns false without sending anything if the revision has not been moved.
+func (s Server) redirectMoved(key string, rev int, trace *Trace, w http.ResponseWriter, r *http.Request) bool {
+	var dst string
+	for _, l := range storeLayers(s.Store) {
+		if m, ok := l.(Mover); ok {
+			var moved bool
+			if dst, moved = m.MovedTo(key, rev); !moved {
+				return false
+			}
+			break
+		}
+	}
+	if dst == "" {
+		return false
+	}
+
+	s.setSurrogateKeys(w, key, rev)
+
+	location := "/" + dst
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+
+	visibility := "public"
+	if s.isPrivate(key) {
+		visibility = "private"
+	}
+	if rev > 0 {
+		trace.Printf("revision %d has been moved to %s; 301 Moved Permanently", rev, dst)
+		w.Header().Set("Cache-Control", visibility+", max-age=31536000, immutable")
+	} else {
+		// The key can be published again, so the redirect of the latest is revalidated.
+		trace.Printf("the key has been moved to %s; 301 Moved Permanently", dst)
+		w.Header().Set("Cache-Control", visibility+", no-cache")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusMovedPermanently)
+	fmt.Fprintln(w, "http://"+r.Host+location)
+	return true
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRewriteGzipName(t *testing.T) {
+	var src bytes.Buffer
+	z := gzip.NewWriter(&src)
+	z.Name = "old/key.txt"
+	z.Extra = []byte("metadata")
+	z.Comment = "comment"
+	z.Write([]byte("hello world"))
+	z.Close()
+
+	var dst bytes.Buffer
+	if err := rewriteGzipName(&dst, &src, "new/longer/key.txt"); err != nil {
+		t.Fatalf("failed to rewrite: %s", err)
+	}
+
+	r, err := gzip.NewReader(&dst)
+	if err != nil {
+		t.Fatalf("failed to read rewritten stream: %s", err)
+	}
+	if r.Name != "new/longer/key.txt" {
+		t.Errorf("unexpected name: %q", r.Name)
+	}
+	if string(r.Extra) != "metadata" || r.Comment != "comment" {
+		t.Errorf("unexpected header: extra=%q comment=%q", r.Extra, r.Comment)
+	}
+	if body, err := io.ReadAll(r); err != nil || string(body) != "hello world" {
+		t.Errorf("unexpected content: %q: %v", body, err)
+	}
+
+	if err := rewriteGzipName(io.Discard, strings.NewReader("not a gzip stream"), "foo"); err == nil {
+		t.Errorf("expected error for invalid stream")
+	}
+}
+
+func TestLocalStoreMove(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	for i, body := range []string{"v1", "v2", "v3"} {
+		opts := PutOptions{Labels: map[string]string{"build": string(rune('1' + i))}}
+		if _, err := store.Put("old/foo.txt", strings.NewReader(body), opts); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	if err := store.Delete("old/foo.txt", 1); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+	if _, err := store.Patch("old/foo.txt", 2, MetadataPatch{SetTags: true, Tags: []string{"stable"}}); err != nil {
+		t.Fatalf("failed to patch: %s", err)
+	}
+	if _, err := store.Put("taken/foo.txt", strings.NewReader("other"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	if _, err := store.Move("old/foo.txt", "taken/foo.txt", false); err != ErrKeyExists {
+		t.Errorf("expected ErrKeyExists but got %v", err)
+	}
+	if _, err := store.Move("missing/foo.txt", "new/foo.txt", false); err != ErrNoSuchArtifact {
+		t.Errorf("expected ErrNoSuchArtifact but got %v", err)
+	}
+
+	latest, err := store.Move("old/foo.txt", "new/foo.txt", true)
+	if err != nil {
+		t.Fatalf("failed to move: %s", err)
+	}
+	if latest != 3 {
+		t.Errorf("unexpected latest revision: %d", latest)
+	}
+
+	revs, err := store.Revisions("new/foo.txt")
+	if err != nil {
+		t.Fatalf("failed to get revisions: %s", err)
+	}
+	if len(revs) != 2 || revs[0].Revision != 2 || revs[1].Revision != 3 {
+		t.Fatalf("unexpected revisions: %v", revs)
+	}
+	if !reflect.DeepEqual(revs[0].Tags, []string{"stable"}) || revs[1].Labels["build"] != "3" {
+		t.Errorf("metadata is not moved: %v", revs)
+	}
+
+	f, meta, err := store.Get("new/foo.txt", 2)
+	if err != nil {
+		t.Fatalf("failed to get moved revision: %s", err)
+	}
+	body, _ := io.ReadAll(f)
+	f.Close()
+	if string(body) != "v2" || meta.Labels["build"] != "2" {
+		t.Errorf("unexpected revision: %q %v", body, meta)
+	}
+
+	for _, rev := range []int{2, 3} {
+		fname := filepath.Join(store.keyDir("new/foo.txt"), revisionFileName(rev))
+		if err := verifyRevision(fname, "new/foo.txt", rev, true); err != nil {
+			t.Errorf("revision %d is broken after move: %s", rev, err)
+		}
+	}
+
+	if keys, _ := store.List(""); !reflect.DeepEqual(keys, []string{"new/foo.txt", "taken/foo.txt"}) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if _, err := store.Metadata("old/foo.txt", 3); err != ErrRevisionDeleted && err != ErrNoSuchArtifact {
+		t.Errorf("old key still has revision 3: %v", err)
+	}
+
+	for _, rev := range []int{0, 1, 3} {
+		if dst, ok := store.MovedTo("old/foo.txt", rev); !ok || dst != "new/foo.txt" {
+			t.Errorf("MovedTo(%d) = %q, %v", rev, dst, ok)
+		}
+	}
+	if _, ok := store.MovedTo("old/foo.txt", 4); ok {
+		t.Errorf("revision 4 should not be moved")
+	}
+
+	// New revisions of the old key never reuse numbers of moved revisions.
+	rev, err := store.Put("old/foo.txt", strings.NewReader("v4"), PutOptions{})
+	if err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	} else if rev != 4 {
+		t.Errorf("unexpected revision: %d", rev)
+	}
+
+	if _, err := store.Move("taken/foo.txt", "gone/foo.txt", false); err != nil {
+		t.Fatalf("failed to move: %s", err)
+	}
+	if _, ok := store.MovedTo("taken/foo.txt", 0); ok {
+		t.Errorf("moved without redirect, but MovedTo reports it")
+	}
+	if keys, _ := store.ListDeleted(""); !reflect.DeepEqual(keys, []string{"taken/foo.txt"}) {
+		t.Errorf("key moved without redirect should be deleted: %v", keys)
+	}
+	if rev, err := store.Put("taken/foo.txt", strings.NewReader("again"), PutOptions{}); err != nil || rev != 2 {
+		t.Errorf("moved revision number is reused: %d (error=%v)", rev, err)
+	}
+}
+
+func TestMoveKey(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	for _, body := range []string{"v1", "v2"} {
+		if _, err := store.Put("p/old/foo.txt", strings.NewReader(body), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	if _, err := store.Put("p/taken/foo.txt", strings.NewReader("other"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewScopedToken(sec, "p/", ScopePublish|ScopeDelete)
+	publishOnly, _ := NewScopedToken(sec, "p/", ScopePublish)
+
+	s := Server{Secret: sec, Store: store, Expectations: NewExpectationStore(), Uploads: NewUploadTracker()}
+	request := func(method, path string, token Token) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if token != nil {
+			r.Header.Set("Authorization", "bearer "+token.String())
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		Path   string
+		Token  Token
+		Status int
+	}{
+		{"/p/new/foo.txt?move-from=p/old/foo.txt", publishOnly, http.StatusForbidden},
+		{"/p/new/foo.txt?move-from=p/old/foo.txt", nil, http.StatusForbidden},
+		{"/p/new/foo.txt?move-from=/p/old/foo.txt", token, http.StatusBadRequest},
+		{"/p/old/foo.txt?move-from=p/old/foo.txt", token, http.StatusBadRequest},
+		{"/p/new/foo.txt?move-from=p/old/foo.txt&redirect=maybe", token, http.StatusBadRequest},
+		{"/p/new/foo.txt?move-from=p/missing/foo.txt", token, http.StatusNotFound},
+		{"/p/taken/foo.txt?move-from=p/old/foo.txt", token, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if w := request("POST", tt.Path, tt.Token); w.Code != tt.Status {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.Path, tt.Status, w.Code, w.Body)
+		}
+	}
+
+	w := request("POST", "/p/new/foo.txt?move-from=p/old/foo.txt&redirect=true", token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("failed to move: %d: %s", w.Code, w.Body)
+	}
+	if loc := w.Header().Get("Location"); loc != "/p/new/foo.txt?rev=2" {
+		t.Errorf("unexpected location: %q", loc)
+	}
+
+	redirects := []struct {
+		Path     string
+		Location string
+	}{
+		{"/p/old/foo.txt", "/p/new/foo.txt"},
+		{"/p/old/foo.txt?rev=1", "/p/new/foo.txt?rev=1"},
+		{"/p/old/foo.txt?rev=latest", "/p/new/foo.txt?rev=latest"},
+	}
+	for _, tt := range redirects {
+		w := request("GET", tt.Path, nil)
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%s: expected 301 but got %d: %s", tt.Path, w.Code, w.Body)
+		} else if loc := w.Header().Get("Location"); loc != tt.Location {
+			t.Errorf("%s: unexpected location: %q", tt.Path, loc)
+		}
+	}
+	if w := request("GET", "/p/old/foo.txt?rev=3", nil); w.Code != http.StatusNotFound {
+		t.Errorf("revision 3 was not moved, but got %d", w.Code)
+	}
+
+	w = request("GET", "/p/new/foo.txt?rev=1", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "v1" {
+		t.Errorf("unexpected response of moved revision: %d: %q", w.Code, w.Body)
+	}
+
+	w = request("POST", "/p/taken/foo.txt?move-from=p/new/foo.txt", token)
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected conflict but got %d", w.Code)
+	}
+}
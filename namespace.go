@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Namespace is a top-level prefix for a tenant, that has its own secret, retention policy, and quota.
+//
+// Tokens for keys in a namespace have to be made by the secret of the namespace, so that a tenant can not publish to other namespaces.
+// The server secret is still used for administration APIs.
+type Namespace struct {
+	Prefix string `yaml:"prefix"`
+
+	// Secret is the secret of the namespace. SecretEnv is the name of an environment variable that has the secret instead.
+	Secret    string `yaml:"secret,omitempty"`
+	SecretEnv string `yaml:"secret-env,omitempty"`
+
+	// RetainNum and RetainPeriod override --retain-num and --retain-period of the server if set.
+	RetainNum    *int           `yaml:"retain-num,omitempty"`
+	RetainPeriod *time.Duration `yaml:"retain-period,omitempty"`
+
+	MaxSize     string `yaml:"max-size,omitempty"`
+	MaxKeys     int    `yaml:"max-keys,omitempty"`
+	SoftMaxSize string `yaml:"soft-max-size,omitempty"`
+	SoftMaxKeys int    `yaml:"soft-max-keys,omitempty"`
+
+	secret Secret
+}
+
+type Namespaces []Namespace
+
+func ParseNamespaces(data []byte) (Namespaces, error) {
+	var conf struct {
+		Namespaces Namespaces `yaml:"namespaces"`
+	}
+	if err := yaml.UnmarshalStrict(data, &conf); err != nil {
+		return nil, fmt.Errorf("Invalid namespaces: %s", err)
+	}
+	ns := conf.Namespaces
+
+	for i := range ns {
+		n := &ns[i]
+
+		if !strings.HasSuffix(n.Prefix, "/") {
+			return nil, fmt.Errorf("Invalid namespaces: prefix %q: it should end with slash.", n.Prefix)
+		}
+		if err := verifyACLPrefix(n.Prefix); err != nil {
+			return nil, fmt.Errorf("Invalid namespaces: prefix %q: %s", n.Prefix, err)
+		}
+		if strings.HasPrefix(n.Prefix, APIPrefix) {
+			return nil, fmt.Errorf("Invalid namespaces: prefix %q: it can not be under %s.", n.Prefix, APIPrefix)
+		}
+
+		raw := n.Secret
+		if n.SecretEnv != "" {
+			if raw != "" {
+				return nil, fmt.Errorf("Invalid namespaces: prefix %q: secret and secret-env can not be used together.", n.Prefix)
+			}
+			raw = os.Getenv(n.SecretEnv)
+			if raw == "" {
+				return nil, fmt.Errorf("Invalid namespaces: prefix %q: environment variable %s is not set.", n.Prefix, n.SecretEnv)
+			}
+		}
+		if raw == "" {
+			return nil, fmt.Errorf("Invalid namespaces: prefix %q: secret or secret-env is required.", n.Prefix)
+		}
+		secret, err := ParseSecret(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid namespaces: prefix %q: %s", n.Prefix, err)
+		}
+		n.secret = secret
+
+		if n.RetainNum != nil && *n.RetainNum < 0 {
+			return nil, fmt.Errorf("Invalid namespaces: prefix %q: retain-num can not be negative.", n.Prefix)
+		}
+		if n.RetainPeriod != nil && *n.RetainPeriod < 0 {
+			return nil, fmt.Errorf("Invalid namespaces: prefix %q: retain-period can not be negative.", n.Prefix)
+		}
+
+		if _, err := (ACL{Quotas: []ACLQuota{n.quota()}}).Normalize(); err != nil {
+			return nil, fmt.Errorf("Invalid namespaces: prefix %q: %s", n.Prefix, strings.TrimPrefix(err.Error(), "Invalid ACL: "))
+		}
+	}
+
+	// Nested namespaces are rejected, because it is not clear which tenant owns keys in the inner one.
+	sort.Slice(ns, func(i, j int) bool {
+		return ns[i].Prefix < ns[j].Prefix
+	})
+	for i := 1; i < len(ns); i++ {
+		if strings.HasPrefix(ns[i].Prefix, ns[i-1].Prefix) {
+			return nil, fmt.Errorf("Invalid namespaces: prefix %q can not be in %q.", ns[i].Prefix, ns[i-1].Prefix)
+		}
+	}
+
+	return ns, nil
+}
+
+func LoadNamespaces(path string) (Namespaces, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := ParseNamespaces(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(ns) == 0 {
+		return nil, errors.New("Invalid namespaces: no namespaces.")
+	}
+	return ns, nil
+}
+
+func (ns Namespaces) Find(key string) (Namespace, bool) {
+	for _, n := range ns {
+		if strings.HasPrefix(key, n.Prefix) {
+			return n, true
+		}
+	}
+	return Namespace{}, false
+}
+
+// SecretFor returns the secret to verify tokens for the key.
+func (ns Namespaces) SecretFor(s Secret, key string) Secret {
+	if n, ok := ns.Find(key); ok {
+		return n.secret
+	}
+	return s
+}
+
+// RetainPolicies returns retention policies of namespaces for LocalStore.PrefixRetain.
+// Fields that are not set in the namespace are taken from def.
+func (ns Namespaces) RetainPolicies(def RetainPolicy) []PrefixRetainPolicy {
+	var ps []PrefixRetainPolicy
+	for _, n := range ns {
+		if n.RetainNum == nil && n.RetainPeriod == nil {
+			continue
+		}
+
+		p := PrefixRetainPolicy{n.Prefix, def}
+		if n.RetainNum != nil {
+			p.Num = *n.RetainNum
+		}
+		if n.RetainPeriod != nil {
+			p.Period = *n.RetainPeriod
+		}
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+func (n Namespace) quota() ACLQuota {
+	return ACLQuota{n.Prefix, n.MaxSize, n.MaxKeys, n.SoftMaxSize, n.SoftMaxKeys}
+}
+
+// CheckQuota checks the quota of the namespace of the key, in the same way as ACL.CheckQuota.
+func (ns Namespaces) CheckQuota(store Store, key string, size int) (warnings []string, err error) {
+	n, ok := ns.Find(key)
+	if !ok {
+		return nil, nil
+	}
+	return ACL{Quotas: []ACLQuota{n.quota()}}.CheckQuota(store, key, size)
+}
+
+// secretFor returns the secret to verify tokens for the key.
+func (s Server) secretFor(key string) Secret {
+	return s.Namespaces.SecretFor(s.Secret, key)
+}
+
+// checkQuota checks quotas of both of the ACL and the namespace of the key.
+func (s Server) checkQuota(acl ACL, key string, size int) ([]string, error) {
+	warnings, err := acl.CheckQuota(s.Store, key, size)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := s.Namespaces.CheckQuota(s.Store, key, size)
+	if err != nil {
+		return nil, err
+	}
+	return append(warnings, ws...), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNamespaces(t *testing.T) {
+	a, _ := NewSecret()
+	b, _ := NewSecret()
+	t.Setenv("TEAM_B_SECRET", b.String())
+
+	tests := []struct {
+		Input string
+		Error bool
+	}{
+		{"namespaces: [{prefix: team-a/, secret: invalid}]", true},
+		{"namespaces: [{prefix: team-a/, secret: " + a.String() + "}, {prefix: team-b/, secret-env: TEAM_B_SECRET}]", false},
+		{"namespaces: [{prefix: team-a/, secret: " + a.String() + ", retain-num: 3, retain-period: 24h, max-size: 1GB}]", false},
+		{"namespaces: [{prefix: team-a, secret: " + a.String() + "}]", true},
+		{"namespaces: [{prefix: team-a/}]", true},
+		{"namespaces: [{prefix: team-a/, secret: " + a.String() + ", secret-env: TEAM_B_SECRET}]", true},
+		{"namespaces: [{prefix: team-a/, secret-env: NO_SUCH_ENV}]", true},
+		{"namespaces: [{prefix: team-a/, secret: " + a.String() + "}, {prefix: team-a/sub/, secret: " + b.String() + "}]", true},
+		{"namespaces: [{prefix: _api/, secret: " + a.String() + "}]", true},
+		{"namespaces: [{prefix: team-a/, secret: " + a.String() + ", max-size: abc}]", true},
+		{"namespaces: [{prefix: team-a/, secret: " + a.String() + ", retain-num: -1}]", true},
+		{"namespaces: [{prefix: team-a/, secret: " + a.String() + ", unknown: 1}]", true},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseNamespaces([]byte(tt.Input))
+		if tt.Error && err == nil {
+			t.Errorf("%s: expected error but got nil", tt.Input)
+		} else if !tt.Error && err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.Input, err)
+		}
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	global, _ := NewSecret()
+	teamA, _ := NewSecret()
+
+	ns, err := ParseNamespaces([]byte(`
+namespaces:
+- prefix: team-a/
+  secret: ` + teamA.String() + `
+  retain-num: 1
+  max-size: 10B
+`))
+	if err != nil {
+		t.Fatalf("failed to parse namespaces: %s", err)
+	}
+
+	store := &LocalStore{Path: t.TempDir()}
+	store.PrefixRetain = ns.RetainPolicies(store.Retain)
+
+	ts := httptest.NewServer(Server{
+		Secret:       global,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+		Namespaces:   ns,
+	})
+	defer ts.Close()
+
+	publish := func(secret Secret, key, body string) int {
+		t.Helper()
+		token, _ := NewToken(secret, key)
+		req, _ := http.NewRequest("POST", ts.URL+"/"+key, strings.NewReader(body))
+		req.Header.Set("Authorization", "bearer "+token.String())
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := publish(global, "team-a/hello", "hello"); status != http.StatusForbidden {
+		t.Errorf("token by the server secret should be rejected in namespace but got %d", status)
+	}
+	if status := publish(teamA, "other/hello", "hello"); status != http.StatusForbidden {
+		t.Errorf("token by the namespace secret should be rejected out of namespace but got %d", status)
+	}
+	if status := publish(global, "other/hello", "hello"); status != http.StatusCreated {
+		t.Errorf("token by the server secret should be accepted out of namespace but got %d", status)
+	}
+
+	for i := 0; i < 2; i++ {
+		if status := publish(teamA, "team-a/hello", "hello"); status != http.StatusCreated {
+			t.Fatalf("token by the namespace secret should be accepted but got %d", status)
+		}
+	}
+	if status := publish(teamA, "team-a/world", "hello world"); status != http.StatusInsufficientStorage {
+		t.Errorf("namespace quota should be applied but got %d", status)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := store.Put("other/hello", bytes.NewBufferString("hello"), PutOptions{}); err != nil {
+			t.Fatalf("failed to put: %s", err)
+		}
+	}
+	store.sweepKey("team-a/hello", time.Now(), false)
+	store.sweepKey("other/hello", time.Now(), false)
+	if _, err := store.Metadata("team-a/hello", 1); err != ErrRevisionDeleted {
+		t.Errorf("retention of namespace should be applied: error=%v", err)
+	}
+	if _, err := store.Metadata("other/hello", 1); err != nil {
+		t.Errorf("retention of namespace should not be applied to other keys: %s", err)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ObservabilityGrafana         = "grafana"
+	ObservabilityPrometheusRules = "prometheus-rules"
+
+	DefaultLatencyThreshold    = time.Second
+	DefaultThroughputThreshold = "1MB"
+)
+
+var observabilityCmd = &cobra.Command{
+	Use:   "observability",
+	Short: "Export dashboards and alert rules for monitoring",
+	Long: `Export dashboards and alert rules for monitoring.
+
+The server generates them from its metric names and the prefixes it serves, so that they always match to the metrics of the server.
+The metrics are served at /` + APIPrefix + `v1/metrics with admin token.
+
+These commands require admin token. See also 'artistore help token'.`,
+}
+
+var observabilityExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export Grafana dashboard or Prometheus alert rules",
+	Long: `Export Grafana dashboard or Prometheus alert rules.
+
+  grafana           Dashboard JSON to import into Grafana.
+  prometheus-rules  Alert rules in YAML to load by rule_files of Prometheus.
+
+Alert rules are generated for each prefix, that is the first directory of keys the same as the prefix label of metrics.`,
+	Example: `  $ export ARTISTORE_TOKEN=$(artistore token --admin)
+  $ artistore observability export --format grafana > artistore-dashboard.json
+  $ artistore observability export --format prometheus-rules --latency-threshold 500ms > artistore-rules.yaml`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		if format != ObservabilityGrafana && format != ObservabilityPrometheusRules {
+			fmt.Fprintf(os.Stderr, "Invalid --format: %q. It should be %s or %s.\n", format, ObservabilityGrafana, ObservabilityPrometheusRules)
+			os.Exit(2)
+		}
+
+		latency, _ := cmd.Flags().GetDuration("latency-threshold")
+		throughput, _ := cmd.Flags().GetString("throughput-threshold")
+		if _, err := ParseSize(throughput); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid --throughput-threshold:", err)
+			os.Exit(2)
+		}
+
+		t, err := NewTokenHandler()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		token, err := t.TokenFor(APIPrefix)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		u, err := GetAPIURL("v1/observability")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		q := u.Query()
+		q.Set("format", format)
+		q.Set("latency-threshold", latency.String())
+		q.Set("throughput-threshold", throughput)
+		u.RawQuery = q.Encode()
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		resp, err := client.Do(func() (*http.Request, error) {
+			req, err := http.NewRequest("GET", u.String(), nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "bearer "+token.String())
+			return req, nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(resp.Body)
+			fmt.Fprint(os.Stderr, string(msg))
+			os.Exit(1)
+		}
+		io.Copy(os.Stdout, resp.Body)
+	},
+}
+
+func init() {
+	cmd.AddCommand(observabilityCmd)
+	observabilityCmd.AddCommand(observabilityExportCmd)
+
+	observabilityExportCmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	observabilityExportCmd.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+	observabilityExportCmd.Flags().String("token", "", "Admin token. See also 'artistore help token'.")
+	observabilityExportCmd.Flags().String("format", ObservabilityGrafana, "Output format: grafana or prometheus-rules.")
+	observabilityExportCmd.Flags().Duration("latency-threshold", DefaultLatencyThreshold, "Alert if the 95th percentile of time to the first byte exceeds this.")
+	observabilityExportCmd.Flags().String("throughput-threshold", DefaultThroughputThreshold, "Alert if the median of transfer speed per second falls below this.")
+}
+
+// observedPrefixes returns values of the prefix label of metrics, that are made from keys in the store and prefixes in the server config.
+func (s Server) observedPrefixes() ([]string, error) {
+	keys, err := s.Store.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	configured := append(append(append([]string{}, s.Private...), s.DirectLatest...), s.Terraform...)
+	acl := s.ACL.Get()
+	for _, p := range acl.Prefixes {
+		configured = append(configured, p.Prefix)
+	}
+	for _, q := range acl.Quotas {
+		configured = append(configured, q.Prefix)
+	}
+	for _, p := range configured {
+		if p != "" && p != "*" {
+			keys = append(keys, p)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var prefixes []string
+	for _, k := range keys {
+		p := metricPrefix(k)
+		if !seen[p] {
+			seen[p] = true
+			prefixes = append(prefixes, p)
+		}
+	}
+	sort.Strings(prefixes)
+	return prefixes, nil
+}
+
+type PrometheusRules struct {
+	Groups []PrometheusRuleGroup `yaml:"groups"`
+}
+
+type PrometheusRuleGroup struct {
+	Name  string           `yaml:"name"`
+	Rules []PrometheusRule `yaml:"rules"`
+}
+
+type PrometheusRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// NewPrometheusRules makes alert rules for each prefix.
+// latency is the threshold of 95th percentile of time to the first byte, and throughput is the threshold of median of transfer speed in bytes per second.
+func NewPrometheusRules(prefixes []string, latency time.Duration, throughput int64) PrometheusRules {
+	var rules []PrometheusRule
+	for _, p := range prefixes {
+		selector := "{prefix=" + strconv.Quote(p) + "}"
+		labels := map[string]string{"severity": "warning", "prefix": p}
+
+		rules = append(rules, PrometheusRule{
+			Alert:  "ArtistoreSlowFirstByte",
+			Expr:   fmt.Sprintf("histogram_quantile(0.95, sum by (le) (rate(%s_bucket%s[5m]))) > %s", MetricFirstByte, selector, strconv.FormatFloat(latency.Seconds(), 'g', -1, 64)),
+			For:    "10m",
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("Artistore responds slowly under %s", p),
+				"description": fmt.Sprintf("95th percentile of time to the first byte under %s is {{ $value | humanizeDuration }}, that exceeds %s.", p, latency),
+			},
+		}, PrometheusRule{
+			Alert:  "ArtistoreSlowTransfer",
+			Expr:   fmt.Sprintf("histogram_quantile(0.5, sum by (le) (rate(%s_bucket%s[5m]))) < %d", MetricThroughput, selector, throughput),
+			For:    "15m",
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("Artistore transfers slowly under %s", p),
+				"description": fmt.Sprintf("Median of transfer speed under %s is {{ $value | humanize1024 }}B/s, that is below %d B/s.", p, throughput),
+			},
+		})
+	}
+
+	return PrometheusRules{[]PrometheusRuleGroup{{"artistore", rules}}}
+}
+
+// NewGrafanaDashboard makes a dashboard model of Grafana, that has a variable to choose prefixes.
+func NewGrafanaDashboard(prefixes []string) map[string]interface{} {
+	selector := `{prefix=~"$prefix"}`
+
+	panel := func(i int, title, unit, expr, legend string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":         i + 1,
+			"type":       "timeseries",
+			"title":      title,
+			"datasource": map[string]string{"type": "prometheus", "uid": "${datasource}"},
+			"gridPos":    map[string]int{"h": 8, "w": 12, "x": (i % 2) * 12, "y": (i / 2) * 8},
+			"fieldConfig": map[string]interface{}{
+				"defaults":  map[string]string{"unit": unit},
+				"overrides": []interface{}{},
+			},
+			"targets": []map[string]string{{
+				"refId":        "A",
+				"expr":         expr,
+				"legendFormat": legend,
+			}},
+		}
+	}
+
+	panels := []map[string]interface{}{
+		panel(0, "Time to first byte (p95)", "s", fmt.Sprintf("histogram_quantile(0.95, sum by (le, prefix) (rate(%s_bucket%s[5m])))", MetricFirstByte, selector), "{{prefix}}"),
+		panel(1, "Transfer speed (median)", "Bps", fmt.Sprintf("histogram_quantile(0.5, sum by (le, prefix) (rate(%s_bucket%s[5m])))", MetricThroughput, selector), "{{prefix}}"),
+		panel(2, "Downloads", "reqps", fmt.Sprintf("sum by (prefix) (rate(%s_count%s[5m]))", MetricFirstByte, selector), "{{prefix}}"),
+		panel(3, "Ingress", "Bps", fmt.Sprintf("sum by (prefix) (rate(%s%s[5m]))", MetricIngress, selector), "{{prefix}}"),
+		panel(4, "Egress", "Bps", fmt.Sprintf("sum by (prefix) (rate(%s%s[5m]))", MetricEgress, selector), "{{prefix}}"),
+		panel(5, "Egress by token", "Bps", fmt.Sprintf("sum by (token) (rate(%s%s[5m]))", MetricEgress, selector), "{{token}}"),
+	}
+
+	options := []map[string]interface{}{{"text": "All", "value": "$__all", "selected": true}}
+	for _, p := range prefixes {
+		options = append(options, map[string]interface{}{"text": p, "value": p, "selected": false})
+	}
+
+	return map[string]interface{}{
+		"uid":           "artistore",
+		"title":         "Artistore",
+		"tags":          []string{"artistore"},
+		"schemaVersion": 36,
+		"refresh":       "1m",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"templating": map[string]interface{}{
+			"list": []map[string]interface{}{
+				{
+					"name":  "datasource",
+					"label": "Data source",
+					"type":  "datasource",
+					"query": "prometheus",
+				},
+				{
+					"name":       "prefix",
+					"label":      "Prefix",
+					"type":       "custom",
+					"query":      strings.Join(prefixes, ","),
+					"options":    options,
+					"current":    map[string]interface{}{"text": "All", "value": "$__all"},
+					"multi":      true,
+					"includeAll": true,
+					"allValue":   ".*",
+				},
+			},
+		},
+		"panels": panels,
+	}
+}
+
+func (s Server) ServeObservability(path string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	latency := DefaultLatencyThreshold
+	if v := query.Get("latency-threshold"); v != "" {
+		var err error
+		if latency, err = time.ParseDuration(v); err != nil || latency <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid latency-threshold.")
+			return
+		}
+	}
+
+	throughputRaw := DefaultThroughputThreshold
+	if v := query.Get("throughput-threshold"); v != "" {
+		throughputRaw = v
+	}
+	throughput, err := ParseSize(throughputRaw)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Invalid throughput-threshold.")
+		return
+	}
+
+	prefixes, err := s.observedPrefixes()
+	if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	switch query.Get("format") {
+	case ObservabilityGrafana, "":
+		data, err := json.MarshalIndent(NewGrafanaDashboard(prefixes), "", "  ")
+		if err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(data, '\n'))
+	case ObservabilityPrometheusRules:
+		data, err := yaml.Marshal(NewPrometheusRules(prefixes, latency, throughput))
+		if err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid format: it should be %s or %s.\n", ObservabilityGrafana, ObservabilityPrometheusRules)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestServeObservability(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+
+	store := &LocalStore{Path: t.TempDir()}
+	for _, key := range []string{"release/app.js", "release/1.0/app.js", "nightly/app.js", "top.txt"} {
+		if _, err := store.Put(key, bytes.NewBufferString("hello"), PutOptions{}); err != nil {
+			t.Fatalf("failed to put %s: %s", key, err)
+		}
+	}
+
+	ts := httptest.NewServer(Server{
+		Secret:  sec,
+		Store:   store,
+		Private: []string{"secret/"},
+		Metrics: NewMetrics(),
+	})
+	defer ts.Close()
+
+	token, _ := NewScopedToken(sec, APIPrefix, ScopeAdmin)
+	get := func(query string) (int, []byte) {
+		req, _ := http.NewRequest("GET", ts.URL+"/"+APIPrefix+"v1/observability?"+query, nil)
+		req.Header.Set("Authorization", "bearer "+token.String())
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to get: %s", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+
+	status, body := get("format=prometheus-rules&latency-threshold=500ms&throughput-threshold=2MB")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	var rules PrometheusRules
+	if err := yaml.UnmarshalStrict(body, &rules); err != nil {
+		t.Fatalf("failed to parse rules: %s", err)
+	}
+
+	var prefixes []string
+	for _, r := range rules.Groups[0].Rules {
+		if r.Alert == "ArtistoreSlowFirstByte" {
+			prefixes = append(prefixes, r.Labels["prefix"])
+			if !strings.HasPrefix(r.Expr, "histogram_quantile(0.95, sum by (le) (rate("+MetricFirstByte+"_bucket{prefix=") || !strings.HasSuffix(r.Expr, " > 0.5") {
+				t.Errorf("unexpected expr: %s", r.Expr)
+			}
+		} else if !strings.HasSuffix(r.Expr, " < 2097152") {
+			t.Errorf("unexpected expr: %s", r.Expr)
+		}
+	}
+	if strings.Join(prefixes, " ") != "/ nightly/ release/ secret/" {
+		t.Errorf("unexpected prefixes: %v", prefixes)
+	}
+
+	status, body = get("format=grafana")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	var dashboard struct {
+		Panels []struct {
+			Targets []struct {
+				Expr string `json:"expr"`
+			} `json:"targets"`
+		} `json:"panels"`
+	}
+	if err := json.Unmarshal(body, &dashboard); err != nil {
+		t.Fatalf("failed to parse dashboard: %s", err)
+	}
+	for _, name := range []string{MetricFirstByte, MetricThroughput, MetricIngress, MetricEgress} {
+		found := false
+		for _, p := range dashboard.Panels {
+			for _, t := range p.Targets {
+				found = found || strings.Contains(t.Expr, name)
+			}
+		}
+		if !found {
+			t.Errorf("dashboard does not use %s", name)
+		}
+	}
+
+	if status, _ := get("format=unknown"); status != http.StatusBadRequest {
+		t.Errorf("unknown format should be rejected but got %d", status)
+	}
+	if status, _ := get("format=grafana&latency-threshold=abc"); status != http.StatusBadRequest {
+		t.Errorf("invalid threshold should be rejected but got %d", status)
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// OIDCClockSkew is the allowed difference between the clocks of the server and the OIDC provider.
+	OIDCClockSkew = time.Minute
+
+	// OIDCKeysRefreshInterval is the minimum interval to fetch keys of a provider again when a token has an unknown key ID.
+	OIDCKeysRefreshInterval = time.Minute
+
+	// OIDCKeysMaxAge is the period to use fetched keys of a provider without fetching again.
+	OIDCKeysMaxAge = time.Hour
+)
+
+var (
+	ErrInvalidJWT     = errors.New("Invalid JWT.")
+	ErrUnknownIssuer  = errors.New("Invalid JWT: the issuer is not trusted.")
+	ErrNoMatchedRules = errors.New("No OIDC rules allow this token.")
+)
+
+// OIDCConfig is the configuration of OIDC authentication, that is read from the file of --oidc.
+//
+// It lets CI services such as GitHub Actions or GitLab CI publish artifacts with their identity tokens, instead of long-lived Artistore tokens.
+type OIDCConfig struct {
+	Issuers []OIDCIssuer `yaml:"issuers"`
+}
+
+type OIDCIssuer struct {
+	Issuer string `yaml:"issuer"`
+
+	// Audience is required, so that tokens issued for other services can not be used for Artistore.
+	Audience string `yaml:"audience"`
+
+	// JWKSURL is the URL of the keys of the issuer. It is discovered from the issuer if empty.
+	JWKSURL string `yaml:"jwks-url,omitempty"`
+
+	Rules []OIDCRule `yaml:"rules"`
+}
+
+// OIDCRule allows the scopes under the prefix, if all claims of the token matched.
+// Values of claims are glob patterns of path.Match, such as "refs/tags/*".
+type OIDCRule struct {
+	Claims map[string]string `yaml:"claims"`
+	Prefix string            `yaml:"prefix"`
+	Scopes []string          `yaml:"scopes"`
+
+	scope Scope
+}
+
+func ParseOIDCConfig(data []byte) (OIDCConfig, error) {
+	var c OIDCConfig
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return OIDCConfig{}, fmt.Errorf("Invalid OIDC config: %s", err)
+	}
+
+	if len(c.Issuers) == 0 {
+		return OIDCConfig{}, errors.New("Invalid OIDC config: no issuers.")
+	}
+
+	seen := make(map[string]bool)
+	for i := range c.Issuers {
+		iss := &c.Issuers[i]
+
+		if iss.Issuer == "" {
+			return OIDCConfig{}, fmt.Errorf("Invalid OIDC config: issuers[%d]: issuer is required.", i)
+		}
+		if seen[iss.Issuer] {
+			return OIDCConfig{}, fmt.Errorf("Invalid OIDC config: issuer %q is duplicated.", iss.Issuer)
+		}
+		seen[iss.Issuer] = true
+
+		if iss.Audience == "" {
+			return OIDCConfig{}, fmt.Errorf("Invalid OIDC config: issuer %q: audience is required.", iss.Issuer)
+		}
+
+		for j := range iss.Rules {
+			rule := &iss.Rules[j]
+
+			// A rule without claims would allow anyone who can get a token from the issuer, such as any repository on GitHub.
+			if len(rule.Claims) == 0 {
+				return OIDCConfig{}, fmt.Errorf("Invalid OIDC config: issuer %q: rules[%d]: at least one claim is required.", iss.Issuer, j)
+			}
+			for name, pattern := range rule.Claims {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return OIDCConfig{}, fmt.Errorf("Invalid OIDC config: issuer %q: rules[%d]: claim %q: %s", iss.Issuer, j, name, err)
+				}
+			}
+
+			if err := verifyACLPrefix(rule.Prefix); err != nil {
+				return OIDCConfig{}, fmt.Errorf("Invalid OIDC config: issuer %q: rules[%d]: %s", iss.Issuer, j, err)
+			}
+
+			scope, err := ParseScope(strings.Join(rule.Scopes, ","))
+			if err != nil {
+				return OIDCConfig{}, fmt.Errorf("Invalid OIDC config: issuer %q: rules[%d]: %s", iss.Issuer, j, err)
+			}
+			rule.scope = scope
+		}
+	}
+
+	return c, nil
+}
+
+func (r OIDCRule) match(claims map[string]interface{}) bool {
+	for name, pattern := range r.Claims {
+		v, ok := claims[name]
+		if !ok {
+			return false
+		}
+		if ok, _ := path.Match(pattern, fmt.Sprint(v)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// OIDCAuthenticator verifies JWTs issued by the OIDC providers in the config.
+type OIDCAuthenticator struct {
+	Config OIDCConfig
+	HTTP   *http.Client
+
+	lock sync.Mutex
+	keys map[string]*oidcKeySet
+}
+
+type oidcKeySet struct {
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func NewOIDCAuthenticator(config OIDCConfig) *OIDCAuthenticator {
+	return &OIDCAuthenticator{
+		Config: config,
+		HTTP:   &http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]*oidcKeySet),
+	}
+}
+
+func LoadOIDCAuthenticator(path string) (*OIDCAuthenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := ParseOIDCConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewOIDCAuthenticator(config), nil
+}
+
+// IsJWT reports whether the raw token looks like a JWT rather than an Artistore token.
+func IsJWT(raw string) bool {
+	return len(raw) > 47 && strings.HasPrefix(raw, "eyJ") && strings.Count(raw, ".") == 2
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrInvalidJWT
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrInvalidJWT
+	}
+	return nil
+}
+
+// Verify checks the signature and the standard claims of the JWT, and returns the claims and the issuer config.
+func (a *OIDCAuthenticator) Verify(raw string) (map[string]interface{}, OIDCIssuer, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, OIDCIssuer{}, ErrInvalidJWT
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, OIDCIssuer{}, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, OIDCIssuer{}, err
+	}
+
+	// The issuer is checked before the signature, because keys are fetched only from trusted issuers.
+	issuer, ok := a.issuer(claims["iss"])
+	if !ok {
+		return nil, OIDCIssuer{}, ErrUnknownIssuer
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, OIDCIssuer{}, ErrInvalidJWT
+	}
+
+	key, err := a.key(issuer, header.KeyID)
+	if err != nil {
+		return nil, OIDCIssuer{}, err
+	}
+	if err := verifyJWTSignature(header.Algorithm, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, OIDCIssuer{}, err
+	}
+
+	if !hasAudience(claims["aud"], issuer.Audience) {
+		return nil, OIDCIssuer{}, fmt.Errorf("%w The audience should be %q.", ErrInvalidJWT, issuer.Audience)
+	}
+
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(OIDCClockSkew)) {
+		return nil, OIDCIssuer{}, fmt.Errorf("%w The token has been expired.", ErrInvalidJWT)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(OIDCClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, OIDCIssuer{}, fmt.Errorf("%w The token is not valid yet.", ErrInvalidJWT)
+	}
+
+	return claims, issuer, nil
+}
+
+// Scope returns the scopes that the JWT has for the key.
+// Scopes of all matched rules are merged.
+func (a *OIDCAuthenticator) Scope(raw, key string) (Scope, error) {
+	claims, issuer, err := a.Verify(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	var scope Scope
+	for _, rule := range issuer.Rules {
+		if strings.HasPrefix(key, rule.Prefix) && rule.match(claims) {
+			scope |= rule.scope
+		}
+	}
+	if scope == 0 {
+		return 0, ErrNoMatchedRules
+	}
+	return scope, nil
+}
+
+func (a *OIDCAuthenticator) issuer(iss interface{}) (OIDCIssuer, bool) {
+	s, ok := iss.(string)
+	if !ok {
+		return OIDCIssuer{}, false
+	}
+	for _, x := range a.Config.Issuers {
+		if x.Issuer == s {
+			return x, true
+		}
+	}
+	return OIDCIssuer{}, false
+}
+
+func hasAudience(aud interface{}, expected string) bool {
+	switch x := aud.(type) {
+	case string:
+		return x == expected
+	case []interface{}:
+		for _, a := range x {
+			if a == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the public key of the issuer.
+// Keys are fetched again if the key ID is unknown, because providers rotate their keys.
+func (a *OIDCAuthenticator) key(issuer OIDCIssuer, kid string) (crypto.PublicKey, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	set, ok := a.keys[issuer.Issuer]
+	if ok && time.Since(set.fetched) < OIDCKeysMaxAge {
+		if key, ok := set.keys[kid]; ok {
+			return key, nil
+		}
+	}
+
+	if !ok || time.Since(set.fetched) >= OIDCKeysRefreshInterval {
+		keys, err := a.fetchKeys(issuer)
+		if err != nil {
+			PrintWarn("OIDC", "failed to fetch keys of %s: %s", issuer.Issuer, err)
+			if !ok {
+				return nil, errors.New("Failed to get keys of the issuer.")
+			}
+		} else {
+			set = &oidcKeySet{keys, time.Now()}
+			a.keys[issuer.Issuer] = set
+		}
+	}
+
+	if key, ok := set.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w The key %q is not found.", ErrInvalidJWT, kid)
+}
+
+func (a *OIDCAuthenticator) getJSON(u string, v interface{}) error {
+	resp, err := a.HTTP.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func (a *OIDCAuthenticator) fetchKeys(issuer OIDCIssuer) (map[string]crypto.PublicKey, error) {
+	u := issuer.JWKSURL
+	if u == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := a.getJSON(strings.TrimSuffix(issuer.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.Issuer != issuer.Issuer {
+			return nil, fmt.Errorf("the discovery document is for %q", discovery.Issuer)
+		}
+		if discovery.JWKSURI == "" {
+			return nil, errors.New("the discovery document has no jwks_uri")
+		}
+		u = discovery.JWKSURI
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := a.getJSON(u, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			PrintWarn("OIDC", "ignore key %q of %s: %s", k.KeyID, issuer.Issuer, err)
+			continue
+		}
+		keys[k.KeyID] = key
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid number")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("invalid point")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.KeyType)
+	}
+}
+
+// verifyJWTSignature supports RS256 and ES256, that are used by major CI services.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "RS256":
+		if k, ok := key.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	case "ES256":
+		if k, ok := key.(*ecdsa.PublicKey); ok && len(sig) == 64 {
+			r := new(big.Int).SetBytes(sig[:32])
+			s := new(big.Int).SetBytes(sig[32:])
+			if ecdsa.Verify(k, digest[:], r, s) {
+				return nil
+			}
+		}
+	default:
+		return fmt.Errorf("%w The algorithm %q is not supported.", ErrInvalidJWT, alg)
+	}
+	return fmt.Errorf("%w The signature is not correct.", ErrInvalidJWT)
+}
+
+func (s Server) authorizeOIDC(token Token, key string, scope Scope, w http.ResponseWriter, r *http.Request) bool {
+	if s.OIDC == nil {
+		PrintWarn("FORBIDDEN", "%s %s %s: OIDC is not enabled", scope, key, r.RemoteAddr)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, "OIDC authentication is not enabled on this server.")
+		return false
+	}
+
+	allowed, err := s.OIDC.Scope(token.String(), key)
+	if err != nil {
+		PrintWarn("FORBIDDEN", "%s %s %s: %s", scope, key, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, "Invalid authorization token.")
+		return false
+	} else if !allowed.Has(scope) {
+		PrintWarn("FORBIDDEN", "%s %s %s: token has only %s scope", scope, key, r.RemoteAddr, allowed)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "This token is not allowed to %s.\n", scope)
+		return false
+	} else if err := s.ACL.Get().Allow(token, key, scope); err != nil {
+		PrintWarn("FORBIDDEN", "%s %s %s: %s", scope, key, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, err)
+		return false
+	}
+	return true
+}
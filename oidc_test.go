@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testOIDCProvider struct {
+	*httptest.Server
+	Key *rsa.PrivateKey
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+
+	p := &testOIDCProvider{Key: key}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			writeJSON(w, http.StatusOK, map[string]string{
+				"issuer":   p.URL,
+				"jwks_uri": p.URL + "/keys",
+			})
+		case "/keys":
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"keys": []map[string]string{{
+					"kty": "RSA",
+					"kid": "test-key",
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				}},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return p
+}
+
+func (p *testOIDCProvider) Sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.Key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign: %s", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCAuthentication(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	defer provider.Close()
+
+	config, err := ParseOIDCConfig([]byte(`
+issuers:
+- issuer: ` + provider.URL + `
+  audience: artistore
+  rules:
+  - claims: {repository: macrat/artistore, ref: "refs/tags/*"}
+    prefix: release/
+    scopes: [publish]
+  - claims: {repository: macrat/artistore}
+    prefix: nightly/
+    scopes: [publish, delete]
+`))
+	if err != nil {
+		t.Fatalf("failed to parse config: %s", err)
+	}
+
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+
+	ts := httptest.NewServer(Server{
+		Secret:       sec,
+		Store:        &LocalStore{Path: t.TempDir()},
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+		OIDC:         NewOIDCAuthenticator(config),
+	})
+	defer ts.Close()
+
+	exp := float64(time.Now().Add(10 * time.Minute).Unix())
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":        provider.URL,
+			"aud":        "artistore",
+			"exp":        exp,
+			"repository": "macrat/artistore",
+			"ref":        "refs/tags/v1.0.0",
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	// The payload allows release/ but the signature is for another payload.
+	parts := strings.Split(provider.Sign(t, "test-key", claims(nil)), ".")
+	tampered := parts[0] + "." + parts[1] + "." + strings.Split(provider.Sign(t, "test-key", claims(map[string]interface{}{"ref": "refs/heads/main"})), ".")[2]
+
+	tests := []struct {
+		Name   string
+		Key    string
+		Token  string
+		Status int
+	}{
+		{"tag", "release/app.js", provider.Sign(t, "test-key", claims(nil)), http.StatusCreated},
+		{"nightly", "nightly/app.js", provider.Sign(t, "test-key", claims(map[string]interface{}{"ref": "refs/heads/main"})), http.StatusCreated},
+		{"audience-list", "release/app.js", provider.Sign(t, "test-key", claims(map[string]interface{}{"aud": []string{"other", "artistore"}})), http.StatusCreated},
+		{"branch", "release/app.js", provider.Sign(t, "test-key", claims(map[string]interface{}{"ref": "refs/heads/main"})), http.StatusForbidden},
+		{"other-prefix", "other/app.js", provider.Sign(t, "test-key", claims(nil)), http.StatusForbidden},
+		{"other-repo", "release/app.js", provider.Sign(t, "test-key", claims(map[string]interface{}{"repository": "evil/artistore"})), http.StatusForbidden},
+		{"no-claim", "release/app.js", provider.Sign(t, "test-key", claims(map[string]interface{}{"repository": nil})), http.StatusForbidden},
+		{"audience", "release/app.js", provider.Sign(t, "test-key", claims(map[string]interface{}{"aud": "other"})), http.StatusForbidden},
+		{"expired", "release/app.js", provider.Sign(t, "test-key", claims(map[string]interface{}{"exp": float64(time.Now().Add(-time.Hour).Unix())})), http.StatusForbidden},
+		{"issuer", "release/app.js", provider.Sign(t, "test-key", claims(map[string]interface{}{"iss": "https://example.com"})), http.StatusForbidden},
+		{"unknown-key", "release/app.js", provider.Sign(t, "other-key", claims(nil)), http.StatusForbidden},
+		{"tampered", "release/app.js", tampered, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", ts.URL+"/"+tt.Key, strings.NewReader("hello"))
+		req.Header.Set("Authorization", "bearer "+tt.Token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: failed to post: %s", tt.Name, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.Status {
+			t.Errorf("%s: expected status %d but got %d", tt.Name, tt.Status, resp.StatusCode)
+		}
+	}
+
+	token, err := ParseToken(provider.Sign(t, "test-key", claims(nil)))
+	if err != nil {
+		t.Fatalf("failed to parse JWT as token: %s", err)
+	}
+	req, _ := http.NewRequest("DELETE", ts.URL+"/release/app.js?rev=1", nil)
+	req.Header.Set("Authorization", "bearer "+token.String())
+	if resp, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	} else if resp.Body.Close(); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("token without delete scope should not delete but got status %d", resp.StatusCode)
+	}
+}
+
+func TestParseOIDCConfig(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Config string
+	}{
+		{"no-issuers", `issuers: []`},
+		{"no-audience", `{issuers: [{issuer: "https://example.com", rules: []}]}`},
+		{"no-claims", `{issuers: [{issuer: "https://example.com", audience: artistore, rules: [{prefix: a/, scopes: [publish]}]}]}`},
+		{"no-scopes", `{issuers: [{issuer: "https://example.com", audience: artistore, rules: [{claims: {sub: x}, prefix: a/}]}]}`},
+		{"bad-pattern", `{issuers: [{issuer: "https://example.com", audience: artistore, rules: [{claims: {sub: "["}, prefix: a/, scopes: [publish]}]}]}`},
+		{"unknown-field", `{issuers: [{issuer: "https://example.com", audience: artistore, foo: bar}]}`},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseOIDCConfig([]byte(tt.Config)); err == nil {
+			t.Errorf("%s: expected error but got nil", tt.Name)
+		}
+	}
+}
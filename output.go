@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// OutputFormat is how CLI commands print their results.
+type OutputFormat string
+
+const (
+	// OutputText is the human-readable output, with progress bars if the command has them.
+	OutputText OutputFormat = "text"
+
+	// OutputJSON prints the result as JSON for scripts, without progress bars.
+	OutputJSON OutputFormat = "json"
+
+	// OutputQuiet prints only URLs of the results, without progress bars.
+	OutputQuiet OutputFormat = "quiet"
+)
+
+// ArtifactResult is the machine-readable result of a command about an artifact.
+type ArtifactResult struct {
+	Key      string `json:"key"`
+	Revision int    `json:"revision,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Hash     string `json:"md5,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// addOutputFlags adds --output and --quiet flags to the command.
+// --format is kept as a deprecated alias of --output.
+func addOutputFlags(c *cobra.Command) {
+	c.Flags().String("output", string(OutputText), `Output format: "text" or "json".`)
+	c.Flags().String("format", string(OutputText), `Output format: "text" or "json".`)
+	c.Flags().MarkDeprecated("format", "use --output instead.")
+	c.Flags().BoolP("quiet", "q", false, "Print only URLs of the results.")
+}
+
+// getOutputFormat reads the flags that added by addOutputFlags.
+func getOutputFormat(c *cobra.Command) (OutputFormat, error) {
+	raw, _ := c.Flags().GetString("output")
+	if c.Flags().Changed("format") && !c.Flags().Changed("output") {
+		raw, _ = c.Flags().GetString("format")
+	}
+	quiet, _ := c.Flags().GetBool("quiet")
+
+	switch f := OutputFormat(raw); {
+	case f != OutputText && f != OutputJSON:
+		return "", errors.New(`Invalid --output: it should be "text" or "json".`)
+	case quiet && f == OutputJSON:
+		return "", errors.New("--quiet can not be used with --output json.")
+	case quiet:
+		return OutputQuiet, nil
+	default:
+		return f, nil
+	}
+}
+
+// printJSON prints v to stdout as indented JSON.
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// printResults prints results in the format. The text format is the same as quiet, plus errors on stderr.
+func printResults(format OutputFormat, results []ArtifactResult) {
+	if format == OutputJSON {
+		printJSON(results)
+		return
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", r.Key, r.Error)
+		} else if r.URL != "" {
+			fmt.Println(r.URL)
+		}
+	}
+}
+
+// locationRevision returns the revision in the location that is returned by the server.
+func locationRevision(location string) (int, error) {
+	loc, err := url.Parse(location)
+	if err == nil {
+		var rev int
+		rev, err = strconv.Atoi(loc.Query().Get("rev"))
+		if err == nil {
+			return rev, nil
+		}
+	}
+	return 0, fmt.Errorf("Unexpected location from server: %s", location)
+}
+
+// fileMD5 returns the MD5 of the file in the same format as Metadata.Hash.
+func fileMD5(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func TestGetOutputFormat(t *testing.T) {
+	tests := []struct {
+		Args   []string
+		Format OutputFormat
+		Error  bool
+	}{
+		{nil, OutputText, false},
+		{[]string{"--output", "json"}, OutputJSON, false},
+		{[]string{"--format", "json"}, OutputJSON, false},
+		{[]string{"--format", "json", "--output", "text"}, OutputText, false},
+		{[]string{"-q"}, OutputQuiet, false},
+		{[]string{"--output", "yaml"}, "", true},
+		{[]string{"--output", "json", "--quiet"}, "", true},
+	}
+
+	for _, tt := range tests {
+		c := &cobra.Command{}
+		addOutputFlags(c)
+		if err := c.ParseFlags(tt.Args); err != nil {
+			t.Fatalf("%v: failed to parse flags: %s", tt.Args, err)
+		}
+
+		format, err := getOutputFormat(c)
+		if tt.Error {
+			if err == nil {
+				t.Errorf("%v: expected error but got nil", tt.Args)
+			}
+		} else if err != nil {
+			t.Errorf("%v: unexpected error: %s", tt.Args, err)
+		} else if format != tt.Format {
+			t.Errorf("%v: expected %q but got %q", tt.Args, tt.Format, format)
+		}
+	}
+}
+
+func TestPublishFiles(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+
+	store := &LocalStore{Path: t.TempDir()}
+	ts := httptest.NewServer(Server{
+		Secret:       sec,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+	})
+	defer ts.Close()
+
+	viper.Set("server", ts.URL)
+	defer viper.Set("server", "")
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(t.TempDir())
+	os.WriteFile("hello.txt", []byte("hello world"), 0644)
+
+	client := &Client{HTTP: &http.Client{}, Retry: RetryPolicy{MaxAttempts: 1}}
+	results := PublishFiles(client, TokenHandler{Secret: sec}, "release/", []string{"hello.txt", "missing.txt"}, "## v1.0\n- first release\n", 2)
+
+	if len(results) != 2 {
+		t.Fatalf("unexpected results: %v", results)
+	}
+
+	r := results[0]
+	if r.Error != "" || r.Key != "release/hello.txt" || r.Revision != 1 || r.URL != ts.URL+"/release/hello.txt?rev=1" || r.Hash != "5eb63bbbe01eeed093cb22bb8f5acdc3" {
+		t.Errorf("unexpected result: %v", r)
+	}
+
+	if meta, err := store.Metadata("release/hello.txt", 1); err != nil || meta.Notes != "## v1.0\n- first release\n" {
+		t.Errorf("notes are not attached: %#v (error=%v)", meta, err)
+	}
+
+	if results[1].Key != "release/missing.txt" || results[1].Error == "" {
+		t.Errorf("publishing missing file should fail: %v", results[1])
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// patchFileSuffix is the suffix of files that store patched metadata of revisions.
+// Metadata in the gzip header can not be changed without rewriting whole file, so patched fields are stored in "REVISION.meta" next to the revision file.
+const patchFileSuffix = ".meta"
+
+// MaxNotesSize is the maximum size of release notes of a revision in bytes.
+const MaxNotesSize = 64 * 1024
+
+var (
+	ErrInvalidPatch = errors.New("Invalid patch: JSON merge patch that has only type, labels, tags, or notes is required.")
+)
+
+// MetadataPatch is a JSON merge patch (RFC 7396) for mutable fields of Metadata.
+type MetadataPatch struct {
+	Type   string
+	Labels map[string]*string
+
+	// ResetLabels is true if the patch has null for labels.
+	ResetLabels bool
+
+	// SetTags is true if the patch has tags, including null.
+	SetTags bool
+	Tags    []string
+
+	// SetNotes is true if the patch has notes, including null.
+	SetNotes bool
+	Notes    string
+}
+
+func ParseMetadataPatch(data []byte) (MetadataPatch, error) {
+	var p MetadataPatch
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil || raw == nil {
+		return p, ErrInvalidPatch
+	}
+
+	for k, v := range raw {
+		null := string(v) == "null"
+
+		switch k {
+		case "type":
+			if null || json.Unmarshal(v, &p.Type) != nil || strings.TrimSpace(p.Type) == "" {
+				return p, fmt.Errorf("%w: type should be a non-empty string.", ErrInvalidPatch)
+			}
+		case "labels":
+			if null {
+				p.ResetLabels = true
+			} else if err := json.Unmarshal(v, &p.Labels); err != nil {
+				return p, fmt.Errorf("%w: labels should be an object of strings.", ErrInvalidPatch)
+			}
+			for name := range p.Labels {
+				if name == "" {
+					return p, fmt.Errorf("%w: label name can not be empty.", ErrInvalidPatch)
+				}
+			}
+		case "tags":
+			p.SetTags = true
+			if !null && json.Unmarshal(v, &p.Tags) != nil {
+				return p, fmt.Errorf("%w: tags should be an array of strings.", ErrInvalidPatch)
+			}
+			for _, tag := range p.Tags {
+				if tag == "" {
+					return p, fmt.Errorf("%w: tag can not be empty.", ErrInvalidPatch)
+				}
+			}
+		case "notes":
+			p.SetNotes = true
+			if !null && json.Unmarshal(v, &p.Notes) != nil {
+				return p, fmt.Errorf("%w: notes should be a string.", ErrInvalidPatch)
+			}
+			if len(p.Notes) > MaxNotesSize {
+				return p, fmt.Errorf("%w: notes should be %d bytes or less.", ErrInvalidPatch, MaxNotesSize)
+			}
+		default:
+			return p, fmt.Errorf("%w: %s can not be changed.", ErrInvalidPatch, k)
+		}
+	}
+
+	return p, nil
+}
+
+// Apply returns patched metadata. The original meta is not changed.
+func (p MetadataPatch) Apply(meta Metadata) Metadata {
+	if p.Type != "" {
+		meta.Type = p.Type
+	}
+
+	labels := make(map[string]string)
+	if !p.ResetLabels {
+		for k, v := range meta.Labels {
+			labels[k] = v
+		}
+	}
+	for k, v := range p.Labels {
+		if v == nil {
+			delete(labels, k)
+		} else {
+			labels[k] = *v
+		}
+	}
+	meta.Labels = nil
+	if len(labels) > 0 {
+		meta.Labels = labels
+	}
+
+	if p.SetTags {
+		meta.Tags = nil
+		seen := make(map[string]bool)
+		for _, tag := range p.Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				meta.Tags = append(meta.Tags, tag)
+			}
+		}
+	}
+
+	if p.SetNotes {
+		meta.Notes = p.Notes
+	}
+
+	return meta
+}
+
+// metadataOverride is the content of the patch file.
+type metadataOverride struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Tags   []string          `json:"tags,omitempty"`
+	Notes  string            `json:"notes,omitempty"`
+}
+
+func (o metadataOverride) apply(meta Metadata) Metadata {
+	meta.Type = o.Type
+	meta.Labels = o.Labels
+	meta.Tags = o.Tags
+	meta.Notes = o.Notes
+	return meta
+}
+
+func (s *LocalStore) patchPath(key string, revision int) string {
+	return filepath.Join(s.keyDir(key), revisionFileName(revision)+patchFileSuffix)
+}
+
+func readMetadataOverride(fname string) (metadataOverride, bool) {
+	var o metadataOverride
+
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return o, false
+	}
+	if err := json.Unmarshal(data, &o); err != nil {
+		PrintWarn("PATCH", "failed to read %s: %s", fname, err)
+		return o, false
+	}
+	return o, true
+}
+
+func writeMetadataOverride(fname string, meta Metadata) error {
+	data, err := json.Marshal(metadataOverride{meta.Type, meta.Labels, meta.Tags, meta.Notes})
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(fname+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(fname+".tmp", fname)
+}
+
+// Patch updates mutable metadata of the revision without rewriting the artifact.
+func (s *LocalStore) Patch(key string, revision int, patch MetadataPatch) (Metadata, error) {
+	var result Metadata
+	var perr error
+
+	err := s.updateIndex(key, func(idx *storeIndex) {
+		meta, ok := idx.Find(revision)
+		if !ok {
+			if revision < idx.Latest() {
+				perr = ErrRevisionDeleted
+			} else {
+				perr = ErrNoSuchArtifact
+			}
+			return
+		}
+
+		meta = patch.Apply(meta)
+		if perr = writeMetadataOverride(s.patchPath(key, revision), meta); perr != nil {
+			return
+		}
+
+		idx.Add(meta)
+		result = meta
+	})
+	if perr != nil {
+		return Metadata{}, perr
+	}
+	return result, err
+}
+
+func (s Server) Patch(key string, w http.ResponseWriter, r *http.Request) {
+	if !r.URL.Query().Has("rev") {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Revision is required to patch.")
+		return
+	}
+
+	rev, err := strconv.Atoi(r.URL.Query().Get("rev"))
+	if err != nil || rev < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Invalid revision.")
+		return
+	}
+
+	if !s.authorize(key, ScopePublish, w, r) {
+		return
+	}
+
+	if typ := r.Header.Get("Content-Type"); typ != "" && !strings.HasPrefix(typ, "application/merge-patch+json") && !strings.HasPrefix(typ, "application/json") {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		fmt.Fprintln(w, "Content-Type should be application/merge-patch+json.")
+		return
+	}
+
+	var raw json.RawMessage
+	if err := readJSON(r, &raw); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, ErrInvalidPatch)
+		return
+	}
+	patch, err := ParseMetadataPatch(raw)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	meta, err := s.Store.Patch(key, rev, patch)
+	switch err {
+	case nil:
+		PrintImportant("PATCH", "%s#%d %s", key, rev, r.RemoteAddr)
+		for _, rp := range s.Replicators {
+			rp.EnqueueMetadata(key, rev)
+		}
+		writeJSON(w, http.StatusOK, NewArtifactInfo(meta))
+	case ErrNoSuchArtifact:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, err)
+	case ErrRevisionDeleted:
+		w.WriteHeader(http.StatusGone)
+		fmt.Fprintln(w, err)
+	default:
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMetadataPatchApply(t *testing.T) {
+	base := Metadata{
+		Type:   "text/plain",
+		Labels: map[string]string{"a": "1", "b": "2"},
+		Tags:   []string{"x"},
+	}
+
+	tests := []struct {
+		Patch  string
+		Error  bool
+		Type   string
+		Labels map[string]string
+		Tags   []string
+	}{
+		{`{}`, false, "text/plain", map[string]string{"a": "1", "b": "2"}, []string{"x"}},
+		{`{"type": "application/json"}`, false, "application/json", map[string]string{"a": "1", "b": "2"}, []string{"x"}},
+		{`{"labels": {"a": "3", "b": null, "c": "4"}}`, false, "text/plain", map[string]string{"a": "3", "c": "4"}, []string{"x"}},
+		{`{"labels": null}`, false, "text/plain", nil, []string{"x"}},
+		{`{"tags": ["y", "z", "y"]}`, false, "text/plain", map[string]string{"a": "1", "b": "2"}, []string{"y", "z"}},
+		{`{"tags": null}`, false, "text/plain", map[string]string{"a": "1", "b": "2"}, nil},
+		{`{"notes": "fixed a bug"}`, false, "text/plain", map[string]string{"a": "1", "b": "2"}, []string{"x"}},
+		{`{"type": null}`, true, "", nil, nil},
+		{`{"type": ""}`, true, "", nil, nil},
+		{`{"size": 10}`, true, "", nil, nil},
+		{`{"labels": {"a": 1}}`, true, "", nil, nil},
+		{`{"tags": [""]}`, true, "", nil, nil},
+		{`{"notes": 1}`, true, "", nil, nil},
+		{`[]`, true, "", nil, nil},
+		{`null`, true, "", nil, nil},
+	}
+
+	for _, tt := range tests {
+		p, err := ParseMetadataPatch([]byte(tt.Patch))
+		if tt.Error {
+			if !errors.Is(err, ErrInvalidPatch) {
+				t.Errorf("%s: expected ErrInvalidPatch but got %v", tt.Patch, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.Patch, err)
+			continue
+		}
+
+		meta := p.Apply(base)
+		if meta.Type != tt.Type || !reflect.DeepEqual(meta.Labels, tt.Labels) || !reflect.DeepEqual(meta.Tags, tt.Tags) {
+			t.Errorf("%s: unexpected result: %#v", tt.Patch, meta)
+		}
+	}
+
+	if !reflect.DeepEqual(base.Labels, map[string]string{"a": "1", "b": "2"}) {
+		t.Errorf("original metadata should not be changed: %v", base.Labels)
+	}
+}
+
+func TestLocalStorePatch(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+
+	for i := 0; i < 2; i++ {
+		if _, err := store.Put("hello.txt", bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	p, err := ParseMetadataPatch([]byte(`{"type": "text/markdown", "labels": {"env": "prod"}, "tags": ["stable"], "notes": "first release"}`))
+	if err != nil {
+		t.Fatalf("failed to parse patch: %s", err)
+	}
+	if _, err := store.Patch("hello.txt", 1, p); err != nil {
+		t.Fatalf("failed to patch: %s", err)
+	}
+
+	check := func(name string) {
+		t.Helper()
+
+		meta, err := store.Metadata("hello.txt", 1)
+		if err != nil {
+			t.Fatalf("%s: failed to get metadata: %s", name, err)
+		}
+		if meta.Type != "text/markdown" || meta.Labels["env"] != "prod" || !reflect.DeepEqual(meta.Tags, []string{"stable"}) || meta.Notes != "first release" {
+			t.Errorf("%s: metadata is not patched: %#v", name, meta)
+		}
+
+		f, meta, err := store.Get("hello.txt", 1)
+		if err != nil {
+			t.Fatalf("%s: failed to get artifact: %s", name, err)
+		}
+		f.Close()
+		if meta.Type != "text/markdown" {
+			t.Errorf("%s: Get returned unpatched metadata: %#v", name, meta)
+		}
+
+		if meta, err := store.Metadata("hello.txt", 2); err != nil || meta.Type != "text/plain; charset=utf-8" || meta.Labels != nil {
+			t.Errorf("%s: other revision should not be changed: %#v (error=%v)", name, meta, err)
+		}
+	}
+
+	check("patched")
+
+	if err := os.Remove(store.indexPath("hello.txt")); err != nil {
+		t.Fatalf("failed to remove index: %s", err)
+	}
+	check("rebuilt")
+
+	if _, err := store.Patch("hello.txt", 3, p); err != ErrNoSuchArtifact {
+		t.Errorf("expected ErrNoSuchArtifact but got %v", err)
+	}
+	if _, err := store.Patch("world.txt", 1, p); err != ErrNoSuchArtifact {
+		t.Errorf("expected ErrNoSuchArtifact but got %v", err)
+	}
+}
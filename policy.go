@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LabelHeader is the request header to set labels on publish, such as "X-Artistore-Label: commit=0123abc".
+// It can be given multiple times.
+const LabelHeader = "X-Artistore-Label"
+
+// MaxPublishLabelsSize is the maximum total size of labels on publish.
+// They are stored in the metadata of the revision file, so they have to be smaller than MaxMetadataSize.
+const MaxPublishLabelsSize = 8 * 1024
+
+// PolicyRule is a set of constraints for uploads under Prefix.
+// All rules that match the key are applied.
+type PolicyRule struct {
+	Prefix string `yaml:"prefix"`
+
+	// MaxSize is the maximum size of each artifact, such as "100MB".
+	MaxSize string `yaml:"max-size,omitempty"`
+
+	// Types are allowed content types, such as "application/json" or "text/*".
+	Types []string `yaml:"types,omitempty"`
+
+	// Patterns are allowed keys relative to Prefix in glob, such as "*.js" or "**/*.tar.gz".
+	Patterns []string `yaml:"patterns,omitempty"`
+
+	// RequiredLabels are names of labels that have to be set by LabelHeader.
+	RequiredLabels []string `yaml:"required-labels,omitempty"`
+
+	maxSize int64
+}
+
+// Policy validates uploads before they enter the store.
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+func ParsePolicy(data []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.UnmarshalStrict(data, &p); err != nil {
+		return nil, fmt.Errorf("Invalid policy: %s", err)
+	}
+
+	for i := range p.Rules {
+		r := &p.Rules[i]
+
+		if err := verifyACLPrefix(r.Prefix); err != nil {
+			return nil, fmt.Errorf("Invalid policy: prefix %q: %s", r.Prefix, err)
+		}
+
+		if r.MaxSize != "" {
+			size, err := ParseSize(r.MaxSize)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid policy: prefix %q: %s", r.Prefix, err)
+			}
+			r.maxSize = size
+		}
+
+		for _, t := range r.Types {
+			if _, _, err := mime.ParseMediaType(t); err != nil && !strings.HasSuffix(t, "/*") {
+				return nil, fmt.Errorf("Invalid policy: prefix %q: invalid type %q.", r.Prefix, t)
+			}
+		}
+
+		for _, pattern := range r.Patterns {
+			if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+				return nil, fmt.Errorf("Invalid policy: prefix %q: invalid pattern %q.", r.Prefix, pattern)
+			}
+		}
+
+		for _, name := range r.RequiredLabels {
+			if name == "" {
+				return nil, fmt.Errorf("Invalid policy: prefix %q: label name can not be empty.", r.Prefix)
+			}
+		}
+	}
+
+	return &p, nil
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data)
+}
+
+func (p *Policy) rulesFor(key string) []PolicyRule {
+	if p == nil {
+		return nil
+	}
+
+	var rs []PolicyRule
+	for _, r := range p.Rules {
+		if strings.HasPrefix(key, r.Prefix) {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// CheckRequest validates the key, labels, and Content-Length of the request before receiving the content.
+// size is -1 if unknown.
+func (p *Policy) CheckRequest(key string, labels map[string]string, size int64) error {
+	for _, r := range p.rulesFor(key) {
+		if len(r.Patterns) > 0 {
+			rel := strings.TrimPrefix(key, r.Prefix)
+			matched := false
+			for _, pattern := range r.Patterns {
+				matched = matched || matchGlob(pattern, rel)
+			}
+			if !matched {
+				return ValidationError{http.StatusUnprocessableEntity, fmt.Sprintf("Rejected by policy: keys under %q should match %s.", r.Prefix, strings.Join(r.Patterns, ", "))}
+			}
+		}
+
+		var missing []string
+		for _, name := range r.RequiredLabels {
+			if _, ok := labels[name]; !ok {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return ValidationError{http.StatusUnprocessableEntity, fmt.Sprintf("Rejected by policy: labels %s are required for keys under %q. Please set them by %s header.", strings.Join(missing, ", "), r.Prefix, LabelHeader)}
+		}
+
+		if r.maxSize > 0 && size > r.maxSize {
+			return ValidationError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Rejected by policy: artifacts under %q should be %s or smaller.", r.Prefix, r.MaxSize)}
+		}
+	}
+	return nil
+}
+
+// CheckContent validates the size and the type of the received content.
+func (p *Policy) CheckContent(meta Metadata) error {
+	for _, r := range p.rulesFor(meta.Key) {
+		if r.maxSize > 0 && int64(meta.Size) > r.maxSize {
+			return ValidationError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Rejected by policy: artifacts under %q should be %s or smaller.", r.Prefix, r.MaxSize)}
+		}
+
+		if len(r.Types) > 0 && !matchTypes(r.Types, meta.Type) {
+			return ValidationError{http.StatusUnsupportedMediaType, fmt.Sprintf("Rejected by policy: type of artifacts under %q should be %s, but got %s.", r.Prefix, strings.Join(r.Types, ", "), meta.Type)}
+		}
+	}
+	return nil
+}
+
+func matchTypes(types []string, contentType string) bool {
+	typ, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, t := range types {
+		if strings.HasSuffix(t, "/*") {
+			if strings.HasPrefix(typ, strings.TrimSuffix(t, "*")) {
+				return true
+			}
+		} else if base, _, _ := mime.ParseMediaType(t); base == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLabelHeaders reads labels from LabelHeader of the request.
+func parseLabelHeaders(r *http.Request) (map[string]string, error) {
+	values := r.Header.Values(LabelHeader)
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	labels := make(map[string]string)
+	size := 0
+	for _, v := range values {
+		xs := strings.SplitN(v, "=", 2)
+		name := strings.TrimSpace(xs[0])
+		if len(xs) != 2 || name == "" {
+			return nil, fmt.Errorf("Invalid %s header: %q: it should be NAME=VALUE.", LabelHeader, v)
+		}
+		labels[name] = strings.TrimSpace(xs[1])
+		size += len(name) + len(xs[1])
+	}
+	if size > MaxPublishLabelsSize {
+		return nil, fmt.Errorf("Invalid %s header: labels should be %d bytes or less in total.", LabelHeader, MaxPublishLabelsSize)
+	}
+
+	return labels, nil
+}
+
+// checkPolicy responds an error and returns false if the request is rejected by the policy.
+func (s Server) checkPolicy(key string, labels map[string]string, size int64, w http.ResponseWriter, r *http.Request) bool {
+	err := s.Policy.CheckRequest(key, labels, size)
+	if err == nil {
+		return true
+	}
+
+	verr := err.(ValidationError)
+	PrintWarn("REJECT", "%s %s: %s", key, r.RemoteAddr, verr.Message)
+	w.WriteHeader(verr.Status)
+	fmt.Fprintln(w, verr.Message)
+	return false
+}
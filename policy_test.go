@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		Input string
+		Error bool
+	}{
+		{"rules: [{prefix: release/, max-size: 10MB, types: [text/*, application/json], patterns: ['**/*.js'], required-labels: [commit]}]", false},
+		{"rules: [{prefix: release/, max-size: abc}]", true},
+		{"rules: [{prefix: release/, types: ['not a type']}]", true},
+		{"rules: [{prefix: release/, patterns: ['[']}]", true},
+		{"rules: [{prefix: release/, required-labels: ['']}]", true},
+		{"rules: [{prefix: /release/}]", true},
+		{"rules: [{prefix: release/, unknown: 1}]", true},
+	}
+
+	for _, tt := range tests {
+		_, err := ParsePolicy([]byte(tt.Input))
+		if tt.Error && err == nil {
+			t.Errorf("%s: expected error but got nil", tt.Input)
+		} else if !tt.Error && err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.Input, err)
+		}
+	}
+}
+
+func TestServePolicy(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`
+rules:
+- prefix: release/
+  max-size: 10B
+  types: [text/*]
+  patterns: ["*.txt", "*.json", "docs/**/*.md"]
+  required-labels: [commit]
+`))
+	if err != nil {
+		t.Fatalf("failed to parse policy: %s", err)
+	}
+
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	store := &LocalStore{Path: t.TempDir()}
+	ts := httptest.NewServer(Server{
+		Secret:       sec,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+		Policy:       policy,
+	})
+	defer ts.Close()
+
+	tests := []struct {
+		Key    string
+		Body   string
+		Labels []string
+		Status int
+		Chunk  bool
+	}{
+		{"release/hello.txt", "hello", []string{"commit=abc", "branch = main"}, http.StatusCreated, false},
+		{"release/docs/a/b.md", "hello", []string{"commit=abc"}, http.StatusCreated, false},
+		{"release/hello.txt", "hello", nil, http.StatusUnprocessableEntity, false},
+		{"release/hello.txt", "hello", []string{"invalid"}, http.StatusBadRequest, false},
+		{"release/hello.exe", "hello", []string{"commit=abc"}, http.StatusUnprocessableEntity, false},
+		{"release/hello.txt", "hello world!", []string{"commit=abc"}, http.StatusRequestEntityTooLarge, false},
+		{"release/hello.txt", "hello world!", []string{"commit=abc"}, http.StatusRequestEntityTooLarge, true},
+		{"release/hello.txt", "\x00\x01\x02\x03", []string{"commit=abc"}, http.StatusCreated, false},
+		{"release/data.json", "{}", []string{"commit=abc"}, http.StatusUnsupportedMediaType, false},
+		{"nightly/hello.exe", "hello world!", nil, http.StatusCreated, false},
+	}
+
+	for _, tt := range tests {
+		token, _ := NewToken(sec, tt.Key)
+		var body io.Reader = strings.NewReader(tt.Body)
+		if tt.Chunk {
+			body = io.MultiReader(body)
+		}
+		req, _ := http.NewRequest("POST", ts.URL+"/"+tt.Key, body)
+		req.Header.Set("Authorization", "bearer "+token.String())
+		for _, l := range tt.Labels {
+			req.Header.Add(LabelHeader, l)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+		msg, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.Status {
+			t.Errorf("%s %q: expected %d but got %d: %s", tt.Key, tt.Body, tt.Status, resp.StatusCode, msg)
+		}
+	}
+
+	meta, err := store.Metadata("release/hello.txt", 1)
+	if err != nil {
+		t.Fatalf("failed to get metadata: %s", err)
+	}
+	if meta.Labels["commit"] != "abc" || meta.Labels["branch"] != "main" {
+		t.Errorf("labels should be set on publish: %#v", meta.Labels)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+var (
+	ErrPreconditionFailed = errors.New("Precondition failed: the latest revision has been changed by another publisher.")
+)
+
+// PublishPrecondition is the state of the latest revision that a publisher expects, by If-Match, If-None-Match, or If-Unmodified-Since headers.
+type PublishPrecondition struct {
+	// Conditional is false if the request has no conditional headers.
+	Conditional bool
+
+	// Latest is the latest revision when the conditions are checked. It is 0 if the key does not exist.
+	Latest int
+}
+
+// Check reports whether rev is the revision right after the checked latest revision.
+// Otherwise, someone else published between the check and the commit.
+func (p PublishPrecondition) Check(rev int) error {
+	if p.Conditional && rev != p.Latest+1 {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// checkPublishPrecondition evaluates conditional headers of a publish request against the current latest revision.
+// It writes 412 Precondition Failed with the ETag of the current latest revision and returns false if the conditions do not match.
+//
+// ETags are compared weakly even for If-Match, because compressed responses have weak ETags of the same content.
+func (s Server) checkPublishPrecondition(key string, w http.ResponseWriter, r *http.Request) (PublishPrecondition, bool) {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	ifUnmodifiedSince := r.Header.Get("If-Unmodified-Since")
+	if ifMatch == "" && ifNoneMatch == "" && ifUnmodifiedSince == "" {
+		return PublishPrecondition{}, true
+	}
+
+	p := PublishPrecondition{Conditional: true}
+
+	var meta Metadata
+	latest, err := s.Store.Latest(key)
+	if err == nil {
+		meta, err = s.Store.Metadata(key, latest)
+	}
+	if err == ErrNoSuchArtifact {
+		latest = 0
+	} else if err == ErrCircuitOpen {
+		s.unavailable(w)
+		return p, false
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return p, false
+	}
+	p.Latest = latest
+
+	var etag string
+	if latest > 0 {
+		etag = s.ETagFormat.ETag(meta.Hash)
+	}
+
+	failed := ""
+	if ifMatch != "" {
+		if latest == 0 {
+			failed = "If-Match is set but the key does not exist."
+		} else if _, ok := matchETag(ifMatch, etag, weakETagMatch); !ok {
+			failed = "If-Match does not match to the latest revision."
+		}
+	}
+	if failed == "" && ifNoneMatch != "" && latest > 0 {
+		if _, ok := matchETag(ifNoneMatch, etag, weakETagMatch); ok {
+			failed = "If-None-Match matches to the latest revision."
+		}
+	}
+	if failed == "" && ifMatch == "" && ifUnmodifiedSince != "" && latest > 0 {
+		if modified, ok := modifiedSince(meta.Timestamp, ifUnmodifiedSince); !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid If-Unmodified-Since header.")
+			return p, false
+		} else if modified {
+			failed = "The latest revision has been modified since If-Unmodified-Since."
+		}
+	}
+
+	if failed != "" {
+		PrintWarn("PRECONDITION", "%s %s: %s", key, r.RemoteAddr, failed)
+		if latest > 0 {
+			w.Header().Set("Etag", etag)
+			w.Header().Set("X-Artistore-Revision", strconv.Itoa(latest))
+		}
+		w.WriteHeader(http.StatusPreconditionFailed)
+		fmt.Fprintln(w, "Precondition failed:", failed)
+		return p, false
+	}
+
+	return p, true
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublishPrecondition(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewToken(sec, "hello.txt")
+	s := Server{Secret: sec, Store: &LocalStore{Path: t.TempDir()}, Expectations: NewExpectationStore(), Uploads: NewUploadTracker()}
+
+	publish := func(key, body string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/"+key, strings.NewReader(body))
+		r.Header.Set("Authorization", "bearer "+token.String())
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := publish("hello.txt", "first", map[string]string{"If-Match": "*"}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-Match should fail if the key does not exist: %d", w.Code)
+	}
+	if w := publish("hello.txt", "first", map[string]string{"If-None-Match": "*"}); w.Code != http.StatusCreated {
+		t.Fatalf("If-None-Match: * should succeed if the key does not exist: %d: %s", w.Code, w.Body.String())
+	}
+	if w := publish("hello.txt", "first", map[string]string{"If-None-Match": "*"}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-None-Match: * should fail if the key exists: %d", w.Code)
+	}
+
+	r := httptest.NewRequest("GET", "/hello.txt?rev=1", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	etag := w.Header().Get("Etag")
+
+	if w := publish("hello.txt", "second", map[string]string{"If-Match": etag}); w.Code != http.StatusCreated {
+		t.Fatalf("If-Match with the latest ETag should succeed: %d: %s", w.Code, w.Body.String())
+	}
+
+	w = publish("hello.txt", "third", map[string]string{"If-Match": "W/" + etag})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("If-Match with an old ETag should fail: %d", w.Code)
+	}
+	if w.Header().Get("X-Artistore-Revision") != "2" || w.Header().Get("Etag") == etag {
+		t.Errorf("412 response should tell the current latest revision: %v", w.Header())
+	}
+
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if w := publish("hello.txt", "third", map[string]string{"If-Unmodified-Since": past}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-Unmodified-Since before the latest should fail: %d", w.Code)
+	}
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if w := publish("hello.txt", "third", map[string]string{"If-Unmodified-Since": future}); w.Code != http.StatusCreated {
+		t.Errorf("If-Unmodified-Since after the latest should succeed: %d", w.Code)
+	}
+	if w := publish("hello.txt", "fourth", map[string]string{"If-Unmodified-Since": "yesterday"}); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid If-Unmodified-Since should be rejected: %d", w.Code)
+	}
+
+	if latest, _ := s.Store.Latest("hello.txt"); latest != 3 {
+		t.Errorf("unexpected latest revision: %d", latest)
+	}
+}
+
+func TestPublishPreconditionCheck(t *testing.T) {
+	tests := []struct {
+		Precondition PublishPrecondition
+		Revision     int
+		Expect       error
+	}{
+		{PublishPrecondition{}, 5, nil},
+		{PublishPrecondition{true, 0}, 1, nil},
+		{PublishPrecondition{true, 3}, 4, nil},
+		{PublishPrecondition{true, 3}, 5, ErrPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		if err := tt.Precondition.Check(tt.Revision); err != tt.Expect {
+			t.Errorf("%#v %d: expected %v but got %v", tt.Precondition, tt.Revision, tt.Expect, err)
+		}
+	}
+}
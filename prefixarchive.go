@@ -0,0 +1,269 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// PrefixArchiveFormat is the format of archives of all artifacts under a prefix, by "GET /PREFIX/?archive=FORMAT".
+type PrefixArchiveFormat string
+
+const (
+	PrefixArchiveTar   PrefixArchiveFormat = "tar"
+	PrefixArchiveTarGz PrefixArchiveFormat = "tar.gz"
+	PrefixArchiveZip   PrefixArchiveFormat = "zip"
+)
+
+func ParsePrefixArchiveFormat(s string) (PrefixArchiveFormat, error) {
+	switch strings.ToLower(s) {
+	case "tar":
+		return PrefixArchiveTar, nil
+	case "tar.gz", "tgz":
+		return PrefixArchiveTarGz, nil
+	case "zip":
+		return PrefixArchiveZip, nil
+	default:
+		return "", fmt.Errorf("Invalid archive format: %q: it should be tar, tar.gz, or zip.", s)
+	}
+}
+
+// PrefixArchiveFormatOf guesses the archive format from the file name.
+func PrefixArchiveFormatOf(name string) (PrefixArchiveFormat, error) {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		return PrefixArchiveTarGz, nil
+	case strings.HasSuffix(name, ".tar"):
+		return PrefixArchiveTar, nil
+	case strings.HasSuffix(name, ".zip"):
+		return PrefixArchiveZip, nil
+	default:
+		return "", fmt.Errorf("Unknown archive format of %q: the name should end with .tar, .tar.gz, .tgz, or .zip.", name)
+	}
+}
+
+func (f PrefixArchiveFormat) ContentType() string {
+	switch f {
+	case PrefixArchiveTarGz:
+		return "application/gzip"
+	case PrefixArchiveZip:
+		return "application/zip"
+	default:
+		return "application/x-tar"
+	}
+}
+
+// prefixArchiveWriter writes entries into an archive of the format.
+type prefixArchiveWriter struct {
+	tar *tar.Writer
+	gz  *gzip.Writer
+	zip *zip.Writer
+}
+
+func newPrefixArchiveWriter(format PrefixArchiveFormat, w io.Writer) *prefixArchiveWriter {
+	switch format {
+	case PrefixArchiveZip:
+		return &prefixArchiveWriter{zip: zip.NewWriter(w)}
+	case PrefixArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &prefixArchiveWriter{tar: tar.NewWriter(gz), gz: gz}
+	default:
+		return &prefixArchiveWriter{tar: tar.NewWriter(w)}
+	}
+}
+
+func (a *prefixArchiveWriter) Add(name string, meta Metadata, r io.Reader) error {
+	if a.zip != nil {
+		w, err := a.zip.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: meta.Timestamp,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		return err
+	}
+
+	err := a.tar.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(meta.Size),
+		Mode:     0644,
+		ModTime:  meta.Timestamp,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(a.tar, r)
+	return err
+}
+
+func (a *prefixArchiveWriter) Close() error {
+	if a.zip != nil {
+		return a.zip.Close()
+	}
+	if err := a.tar.Close(); err != nil {
+		return err
+	}
+	if a.gz != nil {
+		return a.gz.Close()
+	}
+	return nil
+}
+
+// servePrefixArchive streams an archive of the latest revisions of all keys under the prefix.
+// Entry names are relative to the prefix. Redirect artifacts are not included.
+func (s Server) servePrefixArchive(prefix string, w http.ResponseWriter, r *http.Request) {
+	format, err := ParsePrefixArchiveFormat(r.URL.Query().Get("archive"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Archive download is only for prefixes that end with slash.")
+		return
+	}
+
+	keys, err := s.Store.List(prefix)
+	if err == ErrCircuitOpen {
+		s.unavailable(w)
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	// Revisions are fixed before sending, so that the archive is a snapshot even if someone publishes during the download.
+	var metas []Metadata
+	for _, key := range keys {
+		if s.isPrivate(key) && !s.authorize(key, ScopeRead, w, r) {
+			return
+		}
+
+		rev, err := s.latest(key)
+		if err == ErrNoSuchArtifact {
+			continue
+		}
+		var meta Metadata
+		if err == nil {
+			meta, err = s.Store.Metadata(key, rev)
+		}
+		if err == ErrCircuitOpen {
+			s.unavailable(w)
+			return
+		} else if err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+		if meta.Type != RedirectType {
+			metas = append(metas, meta)
+		}
+	}
+	if len(metas) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, ErrNoSuchArtifact)
+		return
+	}
+
+	name := path.Base(strings.TrimSuffix(prefix, "/")) + "." + string(format)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	if s.isPrivate(prefix) {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, no-cache")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == "HEAD" {
+		return
+	}
+
+	PrintLog("ARCHIVE", "%s %d artifacts as %s to %s", prefix, len(metas), format, r.RemoteAddr)
+
+	a := newPrefixArchiveWriter(format, w)
+	for _, meta := range metas {
+		f, _, err := s.Store.Get(meta.Key, meta.Revision)
+		if err != nil {
+			PrintErr("ARCHIVE", "%s#%d: %s", meta.Key, meta.Revision, err)
+			return
+		}
+		err = a.Add(strings.TrimPrefix(meta.Key, prefix), meta, f)
+		f.Close()
+		if err != nil {
+			PrintErr("ARCHIVE", "%s#%d: %s", meta.Key, meta.Revision, err)
+			return
+		}
+	}
+	if err := a.Close(); err != nil {
+		PrintErr("ARCHIVE", "%s: %s", prefix, err)
+	}
+}
+
+// GetPrefixURL returns the URL of the prefix on the server. The prefix has to end with slash.
+func GetPrefixURL(prefix string) (*url.URL, error) {
+	if !strings.HasSuffix(prefix, "/") {
+		return nil, fmt.Errorf("Invalid prefix: %q should end with slash.", prefix)
+	}
+	if err := VerifyKey(strings.TrimSuffix(prefix, "/")); err != nil {
+		return nil, err
+	}
+	return getServerURL(prefix)
+}
+
+// DownloadPrefixArchive downloads the archive of the prefix at u into output.
+// The archive is written into the part file first, and renamed to output after the download is finished.
+func DownloadPrefixArchive(client *Client, u *url.URL, output string) error {
+	format, err := PrefixArchiveFormatOf(output)
+	if err != nil {
+		return err
+	}
+
+	au := *u
+	q := au.Query()
+	q.Set("archive", string(format))
+	au.RawQuery = q.Encode()
+
+	resp, err := client.Get(&au)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+
+	f, err := os.Create(output + partSuffix)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(output + partSuffix)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(output + partSuffix)
+		return err
+	}
+	return os.Rename(output+partSuffix, output)
+}
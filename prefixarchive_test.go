@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readTarEntries(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	entries := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		} else if err != nil {
+			t.Fatalf("failed to read tar: %s", err)
+		}
+		data, _ := io.ReadAll(tr)
+		entries[h.Name] = string(data)
+	}
+}
+
+func readZipEntries(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("failed to read zip: %s", err)
+	}
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		r, _ := f.Open()
+		data, _ := io.ReadAll(r)
+		r.Close()
+		entries[f.Name] = string(data)
+	}
+	return entries
+}
+
+func TestPrefixArchive(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	for _, x := range [][2]string{
+		{"release/1.0/app.js", "old"},
+		{"release/1.0/app.js", "new"},
+		{"release/1.0/docs/index.html", "<h1>hello</h1>"},
+		{"release/1.0/secret/key.pem", "secret"},
+		{"release/2.0/app.js", "next"},
+	} {
+		if _, err := store.Put(x[0], strings.NewReader(x[1]), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	if _, err := store.Put("release/1.0/link", strings.NewReader("https://example.com"), PutOptions{Type: RedirectType}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	sec, _ := NewSecret()
+	s := Server{Secret: sec, Store: store}
+
+	get := func(path string, token Token) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if token != nil {
+			r.Header.Set("Authorization", "bearer "+token.String())
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	expected := map[string]string{
+		"app.js":          "new",
+		"docs/index.html": "<h1>hello</h1>",
+		"secret/key.pem":  "secret",
+	}
+
+	w := get("/release/1.0/?archive=tar.gz", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "application/gzip" || w.Header().Get("Content-Disposition") != `attachment; filename="1.0.tar.gz"` {
+		t.Errorf("unexpected headers: %v", w.Header())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("failed to read gzip: %s", err)
+	}
+	if entries := readTarEntries(t, gz); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("unexpected entries of tar.gz: %v", entries)
+	}
+
+	w = get("/release/1.0/?archive=tar", nil)
+	if entries := readTarEntries(t, w.Body); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("unexpected entries of tar: %v", entries)
+	}
+
+	w = get("/release/1.0/?archive=zip", nil)
+	if entries := readZipEntries(t, w.Body.Bytes()); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("unexpected entries of zip: %v", entries)
+	}
+
+	if w := get("/release/1.0/?archive=rar", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown format should be rejected: %d", w.Code)
+	}
+	if w := get("/release/1.0/app.js?archive=zip", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("archive of a key should be rejected: %d", w.Code)
+	}
+	if w := get("/missing/?archive=zip", nil); w.Code != http.StatusNotFound {
+		t.Errorf("empty prefix should be not found: %d", w.Code)
+	}
+
+	s.Private = []string{"release/1.0/secret/"}
+	if w := get("/release/1.0/?archive=zip", nil); w.Code != http.StatusForbidden {
+		t.Errorf("private keys should require token: %d", w.Code)
+	}
+	token, _ := NewScopedToken(sec, "release/", ScopeRead)
+	if w := get("/release/1.0/?archive=zip", token); w.Code != http.StatusOK {
+		t.Errorf("private keys should be included with token: %d", w.Code)
+	}
+}
+
+func TestDownloadPrefixArchive(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	if _, err := store.Put("release/app.js", strings.NewReader("hello"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	server := httptest.NewServer(Server{Store: store})
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/release/")
+	c := &Client{HTTP: &http.Client{}, Retry: RetryPolicy{MaxAttempts: 1}}
+
+	output := filepath.Join(t.TempDir(), "release.zip")
+	if err := DownloadPrefixArchive(c, u, output); err != nil {
+		t.Fatalf("failed to download: %s", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("failed to read output: %s", err)
+	}
+	if entries := readZipEntries(t, data); entries["app.js"] != "hello" {
+		t.Errorf("unexpected entries: %v", entries)
+	}
+
+	if err := DownloadPrefixArchive(c, u, filepath.Join(t.TempDir(), "release.rar")); err == nil {
+		t.Errorf("unknown extension should be rejected")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gosuri/uiprogress"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// ProgressBar shows progress bars that are redrawn in place.
+	ProgressBar = "bar"
+
+	// ProgressPlain prints progress as plain lines periodically, for CI logs that can not handle redrawing.
+	ProgressPlain = "plain"
+
+	// ProgressNone prints only the results.
+	ProgressNone = "none"
+)
+
+// PlainProgressInterval is how often ProgressPlain prints the progress of running jobs.
+var PlainProgressInterval = 10 * time.Second
+
+// Progress shows the progress of jobs that are known from the beginning.
+type Progress interface {
+	// Update sets the progress of the i-th job in percent.
+	Update(i, percent int)
+
+	// Done marks the i-th job as finished with the message, such as the location or the error.
+	Done(i int, msg string)
+
+	// Stop finishes showing progress. It should be called after all jobs are done.
+	Stop()
+}
+
+// addProgressFlags adds --progress and --no-progress flags to the command.
+func addProgressFlags(c *cobra.Command) {
+	c.Flags().String("progress", "", `How to show progress: "bar", "plain", or "none". (default "bar" if stdout is a terminal, otherwise "plain")`)
+	c.Flags().Bool("no-progress", false, `Do not show progress. The same as --progress none.`)
+}
+
+// getProgressMode reads the flags that added by addProgressFlags.
+func getProgressMode(c *cobra.Command) (string, error) {
+	if no, _ := c.Flags().GetBool("no-progress"); no {
+		return ProgressNone, nil
+	}
+
+	switch mode, _ := c.Flags().GetString("progress"); mode {
+	case ProgressBar, ProgressPlain, ProgressNone:
+		return mode, nil
+	case "":
+		if isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd()) {
+			return ProgressBar, nil
+		}
+		return ProgressPlain, nil
+	default:
+		return "", errors.New(`Invalid --progress: it should be "bar", "plain", or "none".`)
+	}
+}
+
+// NewProgress starts showing progress of jobs named names in the mode.
+func NewProgress(mode string, names []string) Progress {
+	switch mode {
+	case ProgressBar:
+		return newBarProgress(names)
+	case ProgressPlain:
+		return newPlainProgress(os.Stdout, names, PlainProgressInterval)
+	default:
+		return newPlainProgress(os.Stdout, names, 0)
+	}
+}
+
+type barProgress struct {
+	bars []*uiprogress.Bar
+	msgs []string
+}
+
+func newBarProgress(names []string) *barProgress {
+	uiprogress.Start()
+
+	p := &barProgress{
+		bars: make([]*uiprogress.Bar, len(names)),
+		msgs: make([]string, len(names)),
+	}
+
+	// Bars for all jobs are shown from the beginning, so that the user can see how many jobs are waiting.
+	for i, name := range names {
+		i, name := i, name
+		p.msgs[i] = "waiting"
+		p.bars[i] = uiprogress.AddBar(100).PrependFunc(func(b *uiprogress.Bar) string {
+			return fmt.Sprintf("%20s", name)
+		}).AppendFunc(func(b *uiprogress.Bar) string {
+			if p.msgs[i] != "" {
+				return p.msgs[i]
+			} else {
+				return fmt.Sprintf("%d%%", b.Current())
+			}
+		})
+		p.bars[i].Width = 20
+	}
+
+	return p
+}
+
+func (p *barProgress) Update(i, percent int) {
+	p.msgs[i] = ""
+	p.bars[i].Set(percent)
+}
+
+func (p *barProgress) Done(i int, msg string) {
+	p.msgs[i] = msg
+}
+
+func (p *barProgress) Stop() {
+	uiprogress.Stop()
+}
+
+// Special values of plainProgress.percent.
+const (
+	plainWaiting = -1
+	plainDone    = -2
+)
+
+// plainProgress prints a line for each finished job, and lines for running jobs every interval if interval is not 0.
+type plainProgress struct {
+	out   io.Writer
+	names []string
+
+	lock    sync.Mutex
+	percent []int
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func newPlainProgress(out io.Writer, names []string, interval time.Duration) *plainProgress {
+	p := &plainProgress{
+		out:     out,
+		names:   names,
+		percent: make([]int, len(names)),
+		stop:    make(chan struct{}),
+	}
+	for i := range p.percent {
+		p.percent[i] = plainWaiting
+	}
+
+	if interval > 0 {
+		p.stopped.Add(1)
+		go func() {
+			defer p.stopped.Done()
+
+			tick := time.NewTicker(interval)
+			defer tick.Stop()
+
+			for {
+				select {
+				case <-p.stop:
+					return
+				case <-tick.C:
+					p.print()
+				}
+			}
+		}()
+	}
+
+	return p
+}
+
+// print prints the progress of running jobs.
+func (p *plainProgress) print() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i, percent := range p.percent {
+		if percent >= 0 {
+			fmt.Fprintf(p.out, "%s: %d%%\n", p.names[i], percent)
+		}
+	}
+}
+
+func (p *plainProgress) Update(i, percent int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.percent[i] != plainDone {
+		p.percent[i] = percent
+	}
+}
+
+func (p *plainProgress) Done(i int, msg string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.percent[i] = plainDone
+	fmt.Fprintf(p.out, "%s: %s\n", p.names[i], msg)
+}
+
+func (p *plainProgress) Stop() {
+	close(p.stop)
+	p.stopped.Wait()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestGetProgressMode(t *testing.T) {
+	tests := []struct {
+		Args  []string
+		Mode  string
+		Error bool
+	}{
+		{[]string{"--progress", "bar"}, ProgressBar, false},
+		{[]string{"--progress", "plain"}, ProgressPlain, false},
+		{[]string{"--no-progress"}, ProgressNone, false},
+		{[]string{"--progress", "bar", "--no-progress"}, ProgressNone, false},
+		{[]string{"--progress", "fancy"}, "", true},
+
+		// Stdout is not a terminal in tests.
+		{nil, ProgressPlain, false},
+	}
+
+	for _, tt := range tests {
+		c := &cobra.Command{}
+		addProgressFlags(c)
+		if err := c.ParseFlags(tt.Args); err != nil {
+			t.Fatalf("%v: failed to parse flags: %s", tt.Args, err)
+		}
+
+		mode, err := getProgressMode(c)
+		if tt.Error {
+			if err == nil {
+				t.Errorf("%v: expected error but got nil", tt.Args)
+			}
+		} else if err != nil {
+			t.Errorf("%v: unexpected error: %s", tt.Args, err)
+		} else if mode != tt.Mode {
+			t.Errorf("%v: expected %q but got %q", tt.Args, tt.Mode, mode)
+		}
+	}
+}
+
+func TestPlainProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := newPlainProgress(&buf, []string{"a.txt", "b.txt", "c.txt"}, 10*time.Millisecond)
+
+	p.Update(0, 42)
+	p.Done(1, "error: something wrong")
+	time.Sleep(35 * time.Millisecond)
+	p.Done(0, "http://example.com/a.txt?rev=1")
+	p.Stop()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if lines[0] != "b.txt: error: something wrong" {
+		t.Errorf("unexpected first line: %q", lines[0])
+	}
+	if lines[len(lines)-1] != "a.txt: http://example.com/a.txt?rev=1" {
+		t.Errorf("unexpected last line: %q", lines[len(lines)-1])
+	}
+	for _, l := range lines[1 : len(lines)-1] {
+		if l != "a.txt: 42%" {
+			t.Errorf("unexpected progress line: %q", l)
+		}
+	}
+	if len(lines) < 3 {
+		t.Errorf("expected periodic progress lines but got %q", lines)
+	}
+}
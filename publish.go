@@ -4,56 +4,253 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 
-	"github.com/gosuri/uiprogress"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var publishCmd = &cobra.Command{
-	Use:   "publish KEY...",
+	Use:   "publish [KEY...]",
 	Short: "Publish an artifact to Artistore",
-	Long:  "Publish an artifact to Artistore.",
+	Long: `Publish an artifact to Artistore.
+
+KEY can be a glob pattern that is expanded by artistore itself, so quote it to avoid the expansion by shell.
+"**" in the pattern matches any number of directories.
+Files that match --exclude are not published. A pattern without "/" in --exclude matches the name of any file or directory.
+
+With --manifest, artifacts listed in the YAML file are published in the listed order instead of KEY, and the result is printed as JSON.
+All files and keys in the manifest are checked before publishing anything, and publishing stops at the first failure.
+
+    artifacts:
+      - path: dist/app.js       # relative to the manifest file
+        key: app.js             # optional; the same as path if omitted
+        type: text/javascript   # optional
+        labels: {commit: abc}   # optional
+        tags: [stable]          # optional
+        notes_file: CHANGES.md  # optional; relative to the manifest file
+
+With --atomic, all files are uploaded into a transaction on the server first, and published at once only if all uploads succeeded.
+Clients never see a mix of old and new revisions of the files.
+
+With --notes-file, the content of the file is attached to the published revisions as release notes.
+The notes are shown in the revisions API, so that consumers can see what changed.
+
+With --delta, only the difference from the latest revision on the server is uploaded, as the same as rsync.
+The whole file is uploaded if the difference is not small enough, or if the key does not exist yet.
+
+With --extract, each argument is an archive file (.tar, .tar.gz, .tgz, or .zip) that is unpacked on the server.
+Each file in the archive is published as its own key under --prefix, all at once as the same as --atomic.
+It is useful to upload a web bundle as one file but serve it as individual assets.
+
+With --redirect, KEY is published as a redirect artifact that sends GET requests to the URL instead of a file.
+The URL has to be allowed by --redirect-allow of the server.
+
+With --output json, the key, revision, URL, and MD5 of each artifact are printed as a JSON array instead of progress bars.
+With --quiet, only the URLs of published artifacts are printed.
+
+Progress is shown as bars if stdout is a terminal, otherwise as plain lines every ` + PlainProgressInterval.String() + `, so that CI logs are readable.
+Use --progress or --no-progress to choose it explicitly.`,
 	Example: `  $ artistore publish library.js
-  $ artistore publish build/* --prefix=library/`,
-	Args: cobra.MinimumNArgs(1),
+  $ artistore publish build/* --prefix=library/
+  $ artistore publish 'dist/**/*.js' --exclude '*.map'
+  $ artistore publish dist --recursive --exclude node_modules
+  $ artistore publish build/* --prefix=library/ --atomic
+  $ artistore publish build/* --prefix=library/ --notes-file CHANGELOG.md
+  $ artistore publish --manifest artifacts.yaml --prefix=release/1.0/
+  $ artistore publish site.tar.gz --extract --prefix=site/1.2.3/
+  $ artistore publish --redirect https://cdn.example.com/library.js library.js
+  $ artistore publish build/* --output json`,
 	Run: func(cmd *cobra.Command, args []string) {
+		manifest, _ := cmd.Flags().GetString("manifest")
+		if manifest != "" && len(args) > 0 {
+			fmt.Fprintln(os.Stderr, "KEY can not be specified with --manifest.")
+			os.Exit(2)
+		} else if manifest == "" && len(args) == 0 {
+			cmd.Usage()
+			os.Exit(2)
+		}
+
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		progress, err := getProgressMode(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
 		t, err := NewTokenHandler()
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(2)
 		}
 
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		client.Context = interruptContext()
+		client.Delta, _ = cmd.Flags().GetBool("delta")
+
 		prefix := viper.GetString("prefix")
 
-		var keys []string
-		for _, key := range args {
-			key = path.Clean(key)
+		notes, err := readNotesFile(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
 
-			if err := VerifyKey(path.Join(prefix, key)); err != nil {
+		if manifest != "" {
+			if notes != "" {
+				fmt.Fprintln(os.Stderr, "--notes-file can not be specified with --manifest. Use notes_file in the manifest instead.")
+				os.Exit(2)
+			}
+
+			m, err := ReadManifest(manifest, prefix)
+			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(2)
 			}
 
-			if stat, err := os.Stat(key); err != nil {
+			result := m.Publish(client, t)
+
+			if format == OutputQuiet {
+				for _, a := range result.Artifacts {
+					if a.Status == "published" {
+						fmt.Println(a.Location)
+					}
+				}
+			} else {
+				printJSON(result)
+			}
+
+			if !result.OK {
+				os.Exit(1)
+			}
+			return
+		}
+
+		if target, _ := cmd.Flags().GetString("redirect"); target != "" {
+			if len(args) != 1 {
+				fmt.Fprintln(os.Stderr, "--redirect requires exactly one KEY.")
+				os.Exit(2)
+			}
+			result, err := PublishRedirect(client, t, path.Join(prefix, args[0]), target, notes)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to publish:", err)
+				os.Exit(1)
+			}
+			printResults(format, []ArtifactResult{result})
+			return
+		}
+
+		if extract, _ := cmd.Flags().GetBool("extract"); extract {
+			var results []ArtifactResult
+			for _, file := range args {
+				rs, err := PublishExtract(client, t, prefix, file)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to publish %s: %s\n", file, err)
+					os.Exit(1)
+				}
+				results = append(results, rs...)
+			}
+			printResults(format, results)
+			return
+		}
+
+		excludes, _ := cmd.Flags().GetStringArray("exclude")
+		recursive, _ := cmd.Flags().GetBool("recursive")
+		selector := FileSelector{Excludes: excludes}
+
+		var keys []string
+		seen := make(map[string]bool)
+		for _, arg := range args {
+			arg = path.Clean(filepath.ToSlash(arg))
+
+			var files []string
+			if stat, err := os.Stat(arg); err == nil && stat.IsDir() {
+				if !recursive {
+					fmt.Fprintln(os.Stderr, "skip "+arg+" because it is directory. Use --recursive to publish files in it.")
+					continue
+				}
+				files, err = selector.Walk(arg)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(2)
+				}
+			} else if err == nil {
+				if selector.Excluded(arg) {
+					continue
+				}
+				files = []string{arg}
+			} else if hasGlobMeta(arg) {
+				files, err = selector.Glob(arg)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(2)
+				} else if len(files) == 0 {
+					fmt.Fprintf(os.Stderr, "No files match to %s.\n", arg)
+					os.Exit(2)
+				}
+			} else {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(2)
-			} else if stat.IsDir() {
-				fmt.Fprintln(os.Stderr, "skip "+key+" because it is directory.")
-				continue
 			}
 
-			keys = append(keys, key)
+			for _, key := range files {
+				if err := VerifyKey(path.Join(prefix, key)); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(2)
+				}
+				if !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			}
+		}
+
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		if concurrency < 1 {
+			fmt.Fprintln(os.Stderr, "Invalid --concurrency: it should be 1 or more.")
+			os.Exit(2)
+		}
+
+		if atomic, _ := cmd.Flags().GetBool("atomic"); atomic {
+			results, err := PublishAtomic(client, t, prefix, keys, notes)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to publish:", err)
+				os.Exit(1)
+			}
+			printResults(format, results)
+			return
+		}
+
+		if format == OutputText {
+			if ok := PublishAll(client, t, prefix, keys, notes, concurrency, progress); !ok {
+				os.Exit(1)
+			}
+			return
 		}
 
-		if ok := PublishAll(t, prefix, keys); !ok {
-			os.Exit(1)
+		results := PublishFiles(client, t, prefix, keys, notes, concurrency)
+		printResults(format, results)
+		for _, r := range results {
+			if r.Error != "" {
+				os.Exit(1)
+			}
 		}
 	},
 }
@@ -72,6 +269,23 @@ func init() {
 
 	publishCmd.Flags().String("prefix", "", "Prefix for key.")
 	viper.BindPFlag("prefix", publishCmd.Flags().Lookup("prefix"))
+
+	publishCmd.Flags().IntP("concurrency", "j", 4, "Number of files to publish at the same time.")
+	publishCmd.Flags().StringArray("exclude", nil, "Glob pattern of files to not publish. Can be specified multiple times.")
+	publishCmd.Flags().BoolP("recursive", "r", false, "Publish files in directories recursively.")
+	publishCmd.Flags().Bool("atomic", false, "Publish all files at once, or nothing if any of them failed.")
+	publishCmd.Flags().String("manifest", "", "Publish artifacts listed in the YAML file.")
+	publishCmd.Flags().String("redirect", "", "Publish KEY as a redirect to the URL, instead of a file.")
+	publishCmd.Flags().String("notes-file", "", "Attach the content of the file to published revisions as release notes.")
+	publishCmd.Flags().Bool("delta", false, "Upload only the difference from the latest revision, if it is small enough. It saves bandwidth for large files that change slightly.")
+	publishCmd.Flags().Bool("extract", false, "Publish each file in the archive as its own key under --prefix, instead of the archive itself.")
+	addOutputFlags(publishCmd)
+	addProgressFlags(publishCmd)
+
+	addRateLimitFlag(publishCmd)
+	addTimeoutFlags(publishCmd)
+	addTLSFlags(publishCmd)
+	addRetryFlags(publishCmd)
 }
 
 type TokenHandler struct {
@@ -79,6 +293,8 @@ type TokenHandler struct {
 	Token  Token
 }
 
+var ErrNoClientCredential = errors.New("Either secret or token is required.\nPlease set at least one of --token flag, ARTISTORE_TOKEN environment variable (recommended), --secret flag, ARTISTORE_SECRET environment variable, or use 'artistore login'.")
+
 func NewTokenHandler() (h TokenHandler, err error) {
 	if t := viper.GetString("token"); strings.TrimSpace(t) != "" {
 		h.Token, err = ParseToken(strings.TrimSpace(t))
@@ -90,8 +306,15 @@ func NewTokenHandler() (h TokenHandler, err error) {
 		if err != nil {
 			return
 		}
+	} else if t, lerr := LookupCredential(viper.GetViper()); lerr == nil {
+		h.Token, err = ParseToken(t)
+		if err != nil {
+			return
+		}
+	} else if !errors.Is(lerr, ErrNoCredential) {
+		return h, fmt.Errorf("Failed to read token saved by 'artistore login': %w", lerr)
 	} else {
-		return h, errors.New("Either secret or token is required.\nPlease set at least one of --token flag, ARTISTORE_TOKEN environment variable (recommended), --secret flag, or ARTISTORE_SECRET environment variable.")
+		return h, ErrNoClientCredential
 	}
 	return
 }
@@ -103,6 +326,14 @@ func (h TokenHandler) TokenFor(key string) (Token, error) {
 	return NewToken(h.Secret, key)
 }
 
+// ScopedTokenFor returns the token, or generates a token that has the scope by the secret.
+func (h TokenHandler) ScopedTokenFor(key string, scope Scope) (Token, error) {
+	if h.Token != nil {
+		return h.Token, nil
+	}
+	return NewScopedToken(h.Secret, key, scope)
+}
+
 type ProgressRecorder struct {
 	Current  int64
 	Total    int64
@@ -117,7 +348,55 @@ func (r *ProgressRecorder) Read(p []byte) (n int, err error) {
 	return
 }
 
-func PublishArtifact(token Token, prefix, key string, progress func(current, total int64)) (location string, err error) {
+func (r *ProgressRecorder) Seek(offset int64, whence int) (int64, error) {
+	s, ok := r.Upstream.(io.Seeker)
+	if !ok {
+		return 0, errors.New("upstream is not seekable")
+	}
+
+	pos, err := s.Seek(offset, whence)
+	if err == nil {
+		r.Current = pos
+		r.Report(r.Current, r.Total)
+	}
+	return pos, err
+}
+
+// readNotesFile reads the file of --notes-file. It returns an empty string if the flag is not set.
+func readNotesFile(cmd *cobra.Command) (string, error) {
+	name, _ := cmd.Flags().GetString("notes-file")
+	if name == "" {
+		return "", nil
+	}
+	return ReadNotes(name)
+}
+
+// ReadNotes reads release notes from the file, and checks the size.
+func ReadNotes(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > MaxNotesSize {
+		return "", fmt.Errorf("%s: notes should be %d bytes or less.", name, MaxNotesSize)
+	}
+	return string(data), nil
+}
+
+// AttachNotes sets release notes to the revision of the artifact at u.
+func AttachNotes(client *Client, token Token, u *url.URL, revision int, notes string) error {
+	u2 := *u
+	q := u2.Query()
+	q.Set("rev", strconv.Itoa(revision))
+	u2.RawQuery = q.Encode()
+
+	if err := client.CallAPI("PATCH", &u2, token, map[string]string{"notes": notes}, nil); err != nil {
+		return fmt.Errorf("Published but failed to set notes: %s", err)
+	}
+	return nil
+}
+
+func PublishArtifact(client *Client, token Token, prefix, key, notes string, progress func(current, total int64)) (location string, err error) {
 	u, err := GetURL(path.Join(prefix, key))
 	if err != nil {
 		return "", err
@@ -129,85 +408,304 @@ func PublishArtifact(token Token, prefix, key string, progress func(current, tot
 	}
 	defer f.Close()
 
-	r := &ProgressRecorder{Upstream: f, Report: progress}
-
 	stat, err := f.Stat()
 	if err != nil {
 		return "", err
 	}
-	r.Total = stat.Size()
 
-	req, err := http.NewRequest("POST", u.String(), r)
+	delta := false
+	if client.Delta {
+		location, delta, err = publishDelta(client, token, u, f, stat.Size(), progress)
+		if err != nil {
+			return "", err
+		}
+	}
+	if !delta {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		r := &ProgressRecorder{Upstream: f, Total: stat.Size(), Report: progress}
+		location, err = client.PostArtifact(u, token, r)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if notes != "" {
+		rev, err := locationRevision(location)
+		if err != nil {
+			return location, err
+		}
+		if err := AttachNotes(client, token, u, rev, notes); err != nil {
+			return location, err
+		}
+	}
+
+	progress(stat.Size(), stat.Size())
+	return location, nil
+}
+
+// PublishAtomic publishes files through a transaction, so that all of them are published at once or nothing is published.
+func PublishAtomic(client *Client, t TokenHandler, prefix string, keys []string, notes string) (results []ArtifactResult, err error) {
+	txPrefix := commonDir(prefix, keys)
+	token, err := t.TokenFor(txPrefix)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/octet-stream")
-	req.Header.Set("Authorization", "bearer "+token.String())
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	api, err := GetAPIURL("v1/transactions")
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	var tx TransactionStatus
+	if err := client.CallAPI("POST", api, token, map[string]string{"prefix": txPrefix}, &tx); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	txURL, err := GetAPIURL("v1/transactions/" + tx.ID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	// The server discards the transaction by itself if the commit failed.
+	committing := false
+	defer func() {
+		if err != nil && !committing {
+			// The transaction has to be aborted even if the publish is interrupted by Ctrl+C.
+			cleanup := *client
+			cleanup.Context = nil
+			if aerr := cleanup.CallAPI("DELETE", txURL, token, nil, nil); aerr != nil {
+				PrintWarn("WARN", "failed to abort transaction %s: %s", tx.ID, aerr)
+			}
+		}
+	}()
+
+	hashes := make(map[string]string)
+	for _, key := range keys {
+		fullKey := path.Join(prefix, key)
+		u, err := GetAPIURL("v1/transactions/" + tx.ID + "/files/" + fullKey)
+		if err != nil {
+			return nil, err
+		}
+		token, err := t.TokenFor(fullKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if hashes[fullKey], err = fileMD5(key); err != nil {
+			return nil, err
+		}
 
-	if resp.StatusCode != http.StatusCreated {
-		return "", errors.New(string(body))
+		f, err := os.Open(key)
+		if err != nil {
+			return nil, err
+		}
+		err = client.StageArtifact(u, token, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		fmt.Fprintf(os.Stderr, "staged %s\n", fullKey)
 	}
 
-	progress(stat.Size(), stat.Size())
-	return strings.TrimSpace(string(body)), nil
+	commit, err := GetAPIURL("v1/transactions/" + tx.ID + "/commit")
+	if err != nil {
+		return nil, err
+	}
+	committing = true
+	var result TransactionResult
+	if err := client.CallAPI("POST", commit, token, nil, &result); err != nil {
+		return nil, err
+	}
+
+	for _, a := range result.Artifacts {
+		u, err := GetURL(a.Key)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, ArtifactResult{
+			Key:      a.Key,
+			Revision: a.Revision,
+			URL:      u.Scheme + "://" + u.Host + a.Location,
+			Hash:     hashes[a.Key],
+		})
+	}
+
+	// Notes are attached after the commit, because staged files do not have revisions yet.
+	if notes != "" {
+		for _, r := range results {
+			u, err := GetURL(r.Key)
+			if err != nil {
+				return results, err
+			}
+			token, err := t.TokenFor(r.Key)
+			if err != nil {
+				return results, err
+			}
+			if err := AttachNotes(client, token, u, r.Revision, notes); err != nil {
+				return results, fmt.Errorf("%s: %w", r.Key, err)
+			}
+		}
+	}
+
+	return results, nil
 }
 
-func PublishAll(t TokenHandler, prefix string, keys []string) (ok bool) {
-	uiprogress.Start()
-	defer uiprogress.Stop()
+// commonDir returns the deepest directory that contains all keys under prefix.
+func commonDir(prefix string, keys []string) string {
+	var dir string
+	for i, key := range keys {
+		d := path.Dir(path.Join(prefix, key)) + "/"
+		if d == "./" {
+			d = ""
+		}
+		if i == 0 {
+			dir = d
+			continue
+		}
+		for !strings.HasPrefix(d, dir) {
+			dir = path.Dir(strings.TrimSuffix(dir, "/")) + "/"
+			if dir == "./" {
+				dir = ""
+			}
+		}
+	}
+	return dir
+}
 
-	okStore := atomic.Value{}
-	okStore.Store(true)
+func PublishRedirect(client *Client, t TokenHandler, key, target, notes string) (ArtifactResult, error) {
+	result := ArtifactResult{Key: key}
+
+	if err := VerifyKey(key); err != nil {
+		return result, err
+	}
+
+	u, err := GetURL(key)
+	if err != nil {
+		return result, err
+	}
+
+	token, err := t.TokenFor(key)
+	if err != nil {
+		return result, err
+	}
+
+	result.URL, err = client.PostRedirect(u, token, target)
+	if err != nil {
+		return result, err
+	}
+	if result.Revision, err = locationRevision(result.URL); err != nil {
+		return result, err
+	}
+
+	if notes != "" {
+		err = AttachNotes(client, token, u, result.Revision, notes)
+	}
+	return result, err
+}
+
+// PublishFiles publishes keys without progress bars, and returns the results in the same order as keys.
+// At most concurrency files are sent at the same time.
+func PublishFiles(client *Client, t TokenHandler, prefix string, keys []string, notes string, concurrency int) []ArtifactResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]ArtifactResult, len(keys))
+	queue := make(chan int)
 
 	var wg sync.WaitGroup
-	for _, key := range keys {
+	for i := 0; i < concurrency; i++ {
 		wg.Add(1)
+		go func() {
+			defer wg.Done()
 
-		key := key
-		msg := ""
-		bar := uiprogress.AddBar(100).PrependFunc(func(b *uiprogress.Bar) string {
-			return fmt.Sprintf("%20s", key)
-		}).AppendFunc(func(b *uiprogress.Bar) string {
-			if msg != "" {
-				return msg
-			} else {
-				return fmt.Sprintf("%d%%", b.Current())
+			for i := range queue {
+				if err := publishFile(client, t, prefix, keys[i], notes, &results[i]); err != nil {
+					results[i].Error = strings.TrimSpace(err.Error())
+				}
 			}
-		})
-		bar.Width = 20
+		}()
+	}
+
+	for i := range keys {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	return results
+}
+
+func publishFile(client *Client, t TokenHandler, prefix, key, notes string, result *ArtifactResult) (err error) {
+	result.Key = path.Join(prefix, key)
+
+	token, err := t.TokenFor(result.Key)
+	if err != nil {
+		return err
+	}
+
+	if result.Hash, err = fileMD5(key); err != nil {
+		return err
+	}
+
+	result.URL, err = PublishArtifact(client, token, prefix, key, notes, func(current, total int64) {})
+	if err != nil {
+		return err
+	}
+
+	result.Revision, err = locationRevision(result.URL)
+	return err
+}
 
+// PublishAll publishes keys with progress in the mode. At most concurrency files are sent at the same time.
+func PublishAll(client *Client, t TokenHandler, prefix string, keys []string, notes string, concurrency int, mode string) (ok bool) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	progress := NewProgress(mode, keys)
+	defer progress.Stop()
+
+	okStore := atomic.Value{}
+	okStore.Store(true)
+
+	queue := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
 		go func() {
 			defer wg.Done()
 
-			token, err := t.TokenFor(path.Join(prefix, key))
-			if err != nil {
-				msg = "error: " + strings.TrimSpace(err.Error())
-				okStore.CompareAndSwap(true, false)
-				return
-			}
-			msg, err = PublishArtifact(token, prefix, key, func(current, total int64) {
-				bar.Set(int(current * 100 / total))
-			})
-			if err != nil {
-				msg = "error: " + strings.TrimSpace(err.Error())
-				okStore.CompareAndSwap(true, false)
-				return
+			for i := range queue {
+				progress.Update(i, 0)
+
+				token, err := t.TokenFor(path.Join(prefix, keys[i]))
+				if err != nil {
+					progress.Done(i, "error: "+strings.TrimSpace(err.Error()))
+					okStore.CompareAndSwap(true, false)
+					continue
+				}
+				i := i
+				msg, err := PublishArtifact(client, token, prefix, keys[i], notes, func(current, total int64) {
+					if total > 0 {
+						progress.Update(i, int(current*100/total))
+					}
+				})
+				if err != nil {
+					msg = "error: " + strings.TrimSpace(err.Error())
+					okStore.CompareAndSwap(true, false)
+				}
+				progress.Done(i, msg)
 			}
 		}()
 	}
 
+	for i := range keys {
+		queue <- i
+	}
+	close(queue)
 	wg.Wait()
 
 	return okStore.Load().(bool)
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxQueryLimit is the maximum number of items in a page.
+const MaxQueryLimit = 1000
+
+var ErrInvalidCursor = errors.New("Invalid cursor.")
+
+// Query is the common query parameters of list APIs.
+//
+//	limit=N                 number of items in a page. (default all)
+//	cursor=CURSOR           the next_cursor of the previous page.
+//	sort=FIELD, sort=-FIELD sort order. "-" means descending order.
+//	label=NAME=VALUE        only items that have the label. Only NAME checks if the label exists.
+//	min_size=SIZE           only items that are SIZE or bigger, such as 1MB.
+//	max_size=SIZE           only items that are SIZE or smaller.
+//	min_age=DURATION        only items that are published DURATION or more ago, such as 24h.
+//	max_age=DURATION        only items that are published within DURATION.
+type Query struct {
+	Limit  int
+	Offset int
+	Sort   string
+	Desc   bool
+
+	Labels  map[string]string
+	MinSize int64
+	MaxSize int64
+	MinAge  time.Duration
+	MaxAge  time.Duration
+}
+
+// ParseQuery parses query parameters. sortFields is the list of fields that can sort, and the first one is the default.
+// Filters are rejected if filterable is false.
+func ParseQuery(values url.Values, sortFields []string, filterable bool) (Query, error) {
+	q := Query{Sort: sortFields[0], MaxSize: -1}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxQueryLimit {
+			return q, fmt.Errorf("Invalid limit: it should be between 1 and %d.", MaxQueryLimit)
+		}
+		q.Limit = n
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil || !strings.HasPrefix(string(raw), "o:") {
+			return q, ErrInvalidCursor
+		}
+		q.Offset, err = strconv.Atoi(string(raw[2:]))
+		if err != nil || q.Offset < 0 {
+			return q, ErrInvalidCursor
+		}
+	}
+
+	if v := values.Get("sort"); v != "" {
+		q.Desc = strings.HasPrefix(v, "-")
+		q.Sort = strings.TrimPrefix(v, "-")
+
+		ok := false
+		for _, f := range sortFields {
+			ok = ok || f == q.Sort
+		}
+		if !ok {
+			return q, fmt.Errorf("Invalid sort: it should be one of %s.", strings.Join(sortFields, ", "))
+		}
+	}
+
+	for _, name := range []string{"label", "min_size", "max_size", "min_age", "max_age"} {
+		if !filterable && values.Get(name) != "" {
+			return q, fmt.Errorf("%s is not supported by this API.", name)
+		}
+	}
+
+	for _, v := range values["label"] {
+		if q.Labels == nil {
+			q.Labels = make(map[string]string)
+		}
+		xs := strings.SplitN(v, "=", 2)
+		if xs[0] == "" {
+			return q, errors.New("Invalid label: it should be NAME=VALUE or NAME.")
+		}
+		if len(xs) == 2 {
+			q.Labels[xs[0]] = xs[1]
+		} else {
+			q.Labels[xs[0]] = ""
+		}
+	}
+
+	var err error
+	if v := values.Get("min_size"); v != "" {
+		if q.MinSize, err = ParseSize(v); err != nil {
+			return q, err
+		}
+	}
+	if v := values.Get("max_size"); v != "" {
+		if q.MaxSize, err = ParseSize(v); err != nil {
+			return q, err
+		}
+	}
+	if v := values.Get("min_age"); v != "" {
+		if q.MinAge, err = time.ParseDuration(v); err != nil {
+			return q, fmt.Errorf("Invalid min_age: %s", err)
+		}
+	}
+	if v := values.Get("max_age"); v != "" {
+		if q.MaxAge, err = time.ParseDuration(v); err != nil {
+			return q, fmt.Errorf("Invalid max_age: %s", err)
+		}
+	}
+
+	return q, nil
+}
+
+// NeedsMetadata checks if the query needs metadata, not only keys.
+func (q Query) NeedsMetadata() bool {
+	return q.Sort != "key" || q.Labels != nil || q.MinSize > 0 || q.MaxSize >= 0 || q.MinAge > 0 || q.MaxAge > 0
+}
+
+// Match checks if the metadata satisfies the filters.
+func (q Query) Match(meta Metadata, now time.Time) bool {
+	for k, v := range q.Labels {
+		if x, ok := meta.Labels[k]; !ok || (v != "" && x != v) {
+			return false
+		}
+	}
+
+	size := int64(meta.Size)
+	if size < q.MinSize || (q.MaxSize >= 0 && size > q.MaxSize) {
+		return false
+	}
+
+	age := now.Sub(meta.Timestamp)
+	if age < q.MinAge || (q.MaxAge > 0 && age > q.MaxAge) {
+		return false
+	}
+
+	return true
+}
+
+// Filter returns metadata that match the filters.
+func (q Query) Filter(metas []Metadata) []Metadata {
+	now := time.Now()
+
+	xs := make([]Metadata, 0, len(metas))
+	for _, m := range metas {
+		if q.Match(m, now) {
+			xs = append(xs, m)
+		}
+	}
+	return xs
+}
+
+// SortMetadata sorts metadata by the sort field.
+func (q Query) SortMetadata(metas []Metadata) {
+	q.SortBy(len(metas), func(i, j int) int {
+		a, b := metas[i], metas[j]
+		switch q.Sort {
+		case "size":
+			return a.Size - b.Size
+		case "timestamp":
+			return compareTime(a.Timestamp, b.Timestamp)
+		case "revision":
+			return a.Revision - b.Revision
+		default:
+			return strings.Compare(a.Key, b.Key)
+		}
+	}, func(i, j int) {
+		metas[i], metas[j] = metas[j], metas[i]
+	})
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// SortBy sorts n items by compare function in the order of the query. Items that are equal keep the original order.
+func (q Query) SortBy(n int, compare func(i, j int) int, swap func(i, j int)) {
+	sort.Stable(querySorter{n, compare, swap, q.Desc})
+}
+
+type querySorter struct {
+	n       int
+	compare func(i, j int) int
+	swap    func(i, j int)
+	desc    bool
+}
+
+func (s querySorter) Len() int      { return s.n }
+func (s querySorter) Swap(i, j int) { s.swap(i, j) }
+func (s querySorter) Less(i, j int) bool {
+	if s.desc {
+		return s.compare(i, j) > 0
+	}
+	return s.compare(i, j) < 0
+}
+
+// Page returns the range of the current page in total items, and the cursor for the next page.
+// The cursor is empty if this is the last page.
+func (q Query) Page(total int) (start, end int, next string) {
+	start = q.Offset
+	if start > total {
+		start = total
+	}
+
+	end = total
+	if q.Limit > 0 && start+q.Limit < total {
+		end = start + q.Limit
+		next = base64.RawURLEncoding.EncodeToString([]byte("o:" + strconv.Itoa(end)))
+	}
+
+	return start, end, next
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		Query string
+		Error bool
+	}{
+		{"", false},
+		{"limit=10&sort=-size", false},
+		{"label=env=prod&label=stable", false},
+		{"min_size=1KB&max_size=1MB&min_age=1h&max_age=24h", false},
+		{"limit=0", true},
+		{"limit=abc", true},
+		{"limit=100000", true},
+		{"cursor=broken", true},
+		{"sort=owner", true},
+		{"label==prod", true},
+		{"min_size=large", true},
+		{"max_age=1day", true},
+	}
+
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.Query)
+		_, err := ParseQuery(values, []string{"key", "size"}, true)
+		if (err != nil) != tt.Error {
+			t.Errorf("%s: unexpected error: %v", tt.Query, err)
+		}
+	}
+
+	values, _ := url.ParseQuery("label=env=prod")
+	if _, err := ParseQuery(values, []string{"key"}, false); err == nil {
+		t.Errorf("filters should be rejected if not filterable")
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	now := time.Now()
+	meta := Metadata{
+		Size:      100,
+		Labels:    map[string]string{"env": "prod"},
+		Timestamp: now.Add(-time.Hour),
+	}
+
+	tests := []struct {
+		Query  string
+		Expect bool
+	}{
+		{"", true},
+		{"label=env=prod", true},
+		{"label=env", true},
+		{"label=env=dev", false},
+		{"label=owner", false},
+		{"min_size=100B", true},
+		{"min_size=101B", false},
+		{"max_size=99B", false},
+		{"min_age=30m&max_age=2h", true},
+		{"min_age=2h", false},
+		{"max_age=30m", false},
+	}
+
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.Query)
+		q, err := ParseQuery(values, []string{"key"}, true)
+		if err != nil {
+			t.Fatalf("%s: failed to parse: %s", tt.Query, err)
+		}
+		if r := q.Match(meta, now); r != tt.Expect {
+			t.Errorf("%s: expected %v but got %v", tt.Query, tt.Expect, r)
+		}
+	}
+}
+
+func TestKeysQuery(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	s := Server{Store: store}
+
+	for _, x := range []struct{ Key, Body string }{
+		{"b.txt", "hello world"},
+		{"a.txt", "hello"},
+		{"c.txt", "hi"},
+		{"d.txt", "good morning"},
+	} {
+		if _, err := store.Put(x.Key, strings.NewReader(x.Body), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	if _, err := store.Put("e.txt", strings.NewReader("bye"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if err := store.Discard("e.txt", 1); err != nil {
+		t.Fatalf("failed to discard: %s", err)
+	}
+
+	get := func(query string) KeyList {
+		t.Helper()
+
+		r := httptest.NewRequest("GET", "/_api/v1/keys?"+query, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status: %d: %s", query, w.Code, w.Body.String())
+		}
+
+		var list KeyList
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatalf("%s: failed to parse response: %s", query, err)
+		}
+		return list
+	}
+
+	tests := []struct {
+		Query  string
+		Expect []string
+	}{
+		{"", []string{"a.txt", "b.txt", "c.txt", "d.txt"}},
+		{"sort=-key", []string{"d.txt", "c.txt", "b.txt", "a.txt"}},
+		{"sort=size", []string{"c.txt", "a.txt", "b.txt", "d.txt"}},
+		{"sort=-size&min_size=5B", []string{"d.txt", "b.txt", "a.txt"}},
+		{"max_size=5B", []string{"a.txt", "c.txt"}},
+		{"deleted=false", []string{"a.txt", "b.txt", "c.txt", "d.txt"}},
+		{"deleted=true", []string{"e.txt"}},
+		{"deleted=true&prefix=a", []string{}},
+	}
+	for _, tt := range tests {
+		if list := get(tt.Query); !reflect.DeepEqual(list.Keys, tt.Expect) {
+			t.Errorf("%s: expected %v but got %v", tt.Query, tt.Expect, list.Keys)
+		}
+	}
+
+	var keys []string
+	list := get("limit=3&sort=-key")
+	keys = append(keys, list.Keys...)
+	if list.NextCursor == "" {
+		t.Fatalf("expected next cursor")
+	}
+	list = get("limit=3&sort=-key&cursor=" + list.NextCursor)
+	keys = append(keys, list.Keys...)
+	if list.NextCursor != "" {
+		t.Errorf("expected no next cursor on the last page but got %q", list.NextCursor)
+	}
+	if expect := []string{"d.txt", "c.txt", "b.txt", "a.txt"}; !reflect.DeepEqual(keys, expect) {
+		t.Errorf("expected %v but got %v", expect, keys)
+	}
+
+	for _, query := range []string{"sort=revision", "deleted=maybe", "deleted=true&sort=size"} {
+		r := httptest.NewRequest("GET", "/_api/v1/keys?"+query, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected bad request but got %d", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// fixRangeHeader removes zero-length suffix ranges ("bytes=-0") from the Range header of r, before passing it to http.ServeContent.
+//
+// RFC 7233 says that a suffix range of zero length is not satisfiable, but http.ServeContent responds 206 with an empty and invalid Content-Range for it.
+// If no ranges remain, the header is replaced by a range that starts at the end of the content, so that http.ServeContent responds 416 with "Content-Range: bytes */SIZE".
+// If-Range is still evaluated by http.ServeContent.
+func fixRangeHeader(r *http.Request, size int64) {
+	header := r.Header.Get("Range")
+	if !strings.HasPrefix(header, "bytes=") {
+		return
+	}
+
+	specs := strings.Split(header[len("bytes="):], ",")
+	kept := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if strings.HasPrefix(spec, "-") {
+			if n, err := strconv.ParseInt(spec[1:], 10, 64); err == nil && n == 0 {
+				continue
+			}
+		}
+		kept = append(kept, spec)
+	}
+	if len(kept) == len(specs) {
+		return
+	}
+
+	if len(kept) == 0 {
+		r.Header.Set("Range", "bytes="+strconv.FormatInt(size, 10)+"-")
+	} else {
+		r.Header.Set("Range", "bytes="+strings.Join(kept, ","))
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// ParseRate parses a transfer rate such as "10MB/s" or "512KB". The unit is bytes per second.
+func ParseRate(s string) (int64, error) {
+	raw := strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	n, err := ParseSize(raw)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Invalid rate: %q: it should be a positive size per second, such as 10MB/s.", s)
+	}
+	return n, nil
+}
+
+// addRateLimitFlag adds --limit-rate flag to the command.
+func addRateLimitFlag(c *cobra.Command) {
+	c.Flags().String("limit-rate", "", "Maximum transfer rate, such as 10MB/s. Concurrent transfers share the limit. (default unlimited)")
+}
+
+// RateLimiter limits the total throughput of readers that share it.
+type RateLimiter struct {
+	rate int64
+
+	lock sync.Mutex
+	next time.Time
+}
+
+// NewRateLimiter makes a limiter of rate bytes per second.
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: rate}
+}
+
+// chunk is the maximum bytes to read at once, so that the transfer is smooth instead of bursting and pausing.
+func (l *RateLimiter) chunk() int {
+	if c := l.rate / 10; c > 1 {
+		return int(c)
+	}
+	return 1
+}
+
+// wait blocks until n bytes can be transferred.
+func (l *RateLimiter) wait(n int) {
+	l.lock.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	until := l.next
+	l.lock.Unlock()
+
+	time.Sleep(time.Until(until))
+}
+
+// Reader wraps r to be read in the rate limit.
+func (l *RateLimiter) Reader(r io.Reader) io.Reader {
+	return rateLimitedReader{r, l}
+}
+
+type rateLimitedReader struct {
+	r io.Reader
+	l *RateLimiter
+}
+
+func (r rateLimitedReader) Read(p []byte) (int, error) {
+	if c := r.l.chunk(); len(p) > c {
+		p = p[:c]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.l.wait(n)
+	}
+	return n, err
+}
+
+type rateLimitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// rateLimitTransport limits the rate of request and response bodies.
+type rateLimitTransport struct {
+	Base    http.RoundTripper
+	Limiter *RateLimiter
+}
+
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(req.Context())
+		req.Body = rateLimitedReadCloser{t.Limiter.Reader(req.Body), req.Body}
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = rateLimitedReadCloser{t.Limiter.Reader(resp.Body), resp.Body}
+	return resp, nil
+}
+
+// getRateLimiter returns the limiter by --limit-rate, or nil if it is not set.
+func getRateLimiter() (*RateLimiter, error) {
+	s := viper.GetString("limit-rate")
+	if s == "" {
+		return nil, nil
+	}
+	rate, err := ParseRate(s)
+	if err != nil {
+		return nil, err
+	}
+	return NewRateLimiter(rate), nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output int64
+		Error  bool
+	}{
+		{"10MB/s", 10 << 20, false},
+		{"512KiB", 512 * 1024, false},
+		{"100", 100, false},
+		{"0", 0, true},
+		{"fast", 0, true},
+	}
+
+	for _, tt := range tests {
+		n, err := ParseRate(tt.Input)
+		if (err != nil) != tt.Error {
+			t.Errorf("%s: unexpected error: %v", tt.Input, err)
+		} else if n != tt.Output {
+			t.Errorf("%s: expected %d but got %d", tt.Input, tt.Output, n)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(10000)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, l.Reader(bytes.NewReader(make([]byte, 3000))))
+	if err != nil || n != 3000 {
+		t.Fatalf("failed to read: %d: %v", n, err)
+	}
+	if took := time.Since(start); took < 250*time.Millisecond || took > 2*time.Second {
+		t.Errorf("3000 bytes in 10000 bytes/s should take about 300ms but took %s", took)
+	}
+}
+
+func TestRateLimiterChunk(t *testing.T) {
+	tests := []struct {
+		Rate  int64
+		Chunk int
+	}{
+		{10 << 20, 1 << 20},
+		{10000, 1000},
+		{20, 2},
+		{10, 1},
+		{1, 1},
+	}
+
+	for _, tt := range tests {
+		if c := NewRateLimiter(tt.Rate).chunk(); c != tt.Chunk {
+			t.Errorf("%d bytes/s: expected chunk %d but got %d", tt.Rate, tt.Chunk, c)
+		}
+	}
+}
+
+func TestRateLimiterShared(t *testing.T) {
+	l := NewRateLimiter(10000)
+
+	// Two readers share the limit, so 1500 bytes each take about 300ms in total.
+	start := time.Now()
+	done := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := io.Copy(io.Discard, l.Reader(bytes.NewReader(make([]byte, 1500))))
+			done <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("failed to read: %s", err)
+		}
+	}
+	if took := time.Since(start); took < 250*time.Millisecond || took > 2*time.Second {
+		t.Errorf("3000 bytes in 10000 bytes/s should take about 300ms but took %s", took)
+	}
+
+	// The limiter does not save unused time, so that a transfer after idle never bursts.
+	time.Sleep(100 * time.Millisecond)
+	start = time.Now()
+	io.Copy(io.Discard, l.Reader(bytes.NewReader(make([]byte, 2000))))
+	if took := time.Since(start); took < 150*time.Millisecond {
+		t.Errorf("2000 bytes in 10000 bytes/s should take about 200ms but took %s", took)
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: rateLimitTransport{http.DefaultTransport, NewRateLimiter(10000)}}
+
+	start := time.Now()
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader(strings.Repeat("a", 1500)))
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if len(body) != 1500 {
+		t.Errorf("unexpected body length: %d", len(body))
+	}
+	// Both of the upload and the download are limited, so it takes about 300ms.
+	if took := time.Since(start); took < 250*time.Millisecond {
+		t.Errorf("transfer should be limited but took only %s", took)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RedirectType is the content type of redirect artifacts.
+// The content of a redirect artifact is the URL, and GET of it is redirected to the URL.
+const RedirectType = "application/vnd.artistore.redirect"
+
+// MaxRedirectSize is the maximum size of the content of a redirect artifact.
+const MaxRedirectSize = 4096
+
+var (
+	ErrInvalidRedirect    = errors.New("Invalid redirect target: it should be an absolute http or https URL.")
+	ErrRedirectNotAllowed = errors.New("The redirect target is not allowed.")
+	ErrRedirectTooLarge   = fmt.Errorf("Redirect artifact should be %d bytes or less.", MaxRedirectSize)
+)
+
+// RedirectAllowlist is the list of URL prefixes that redirect artifacts can point to.
+type RedirectAllowlist []*url.URL
+
+func ParseRedirectAllowlist(prefixes []string) (RedirectAllowlist, error) {
+	var l RedirectAllowlist
+	for _, p := range prefixes {
+		u, err := parseRedirectURL(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid redirect allowlist: %q: it should be an absolute http or https URL.", p)
+		}
+		l = append(l, u)
+	}
+	return l, nil
+}
+
+func parseRedirectURL(s string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return nil, ErrInvalidRedirect
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u, nil
+}
+
+// Check parses the target URL, and checks it matches to any prefix in the allowlist.
+// Scheme and host have to be the same, and the path has to start with the path of the prefix.
+func (l RedirectAllowlist) Check(target string) (*url.URL, error) {
+	u, err := parseRedirectURL(target)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range l {
+		if u.Scheme == p.Scheme && strings.EqualFold(u.Host, p.Host) && strings.HasPrefix(u.Path, p.Path) {
+			return u, nil
+		}
+	}
+	return nil, ErrRedirectNotAllowed
+}
+
+// readRedirect reads the target URL of a redirect artifact from body, and validates it.
+func (s Server) readRedirect(body io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(body, MaxRedirectSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > MaxRedirectSize {
+		return "", ErrRedirectTooLarge
+	}
+
+	u, err := s.Redirects.Check(string(data))
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// serveRedirect sends 302 Found to the target of a redirect artifact.
+// The target is checked again, so that removing a prefix from the allowlist stops existing redirects too.
+func (s Server) serveRedirect(key string, rev int, f io.Reader, trace *Trace, w http.ResponseWriter) {
+	data, err := io.ReadAll(io.LimitReader(f, MaxRedirectSize))
+	if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	u, err := s.Redirects.Check(string(data))
+	if err != nil {
+		trace.Printf("revision %d is a redirect to %s, but it is not allowed; 403 Forbidden", rev, strings.TrimSpace(string(data)))
+		PrintWarn("REDIRECT", "%s#%d: %s", key, rev, err)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, ErrRedirectNotAllowed)
+		return
+	}
+
+	trace.Printf("revision %d is a redirect artifact; 302 Found to %s", rev, u)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Location", u.String())
+	w.WriteHeader(http.StatusFound)
+	fmt.Fprintln(w, u)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedirectAllowlist(t *testing.T) {
+	l, err := ParseRedirectAllowlist([]string{"https://cdn.example.com/assets/", "http://EXAMPLE.org"})
+	if err != nil {
+		t.Fatalf("failed to parse allowlist: %s", err)
+	}
+
+	tests := []struct {
+		Target string
+		Error  error
+	}{
+		{"https://cdn.example.com/assets/app.js", nil},
+		{" https://cdn.example.com/assets/app.js\n", nil},
+		{"https://CDN.example.com/assets/app.js", nil},
+		{"http://example.org", nil},
+		{"http://example.org/anything", nil},
+		{"https://cdn.example.com/other/app.js", ErrRedirectNotAllowed},
+		{"http://cdn.example.com/assets/app.js", ErrRedirectNotAllowed},
+		{"https://cdn.example.com.evil.com/assets/app.js", ErrRedirectNotAllowed},
+		{"https://user@cdn.example.com/assets/app.js", ErrInvalidRedirect},
+		{"ftp://cdn.example.com/assets/app.js", ErrInvalidRedirect},
+		{"/assets/app.js", ErrInvalidRedirect},
+	}
+
+	for _, tt := range tests {
+		if _, err := l.Check(tt.Target); err != tt.Error {
+			t.Errorf("%q: expected %v but got %v", tt.Target, tt.Error, err)
+		}
+	}
+
+	if _, err := ParseRedirectAllowlist([]string{"cdn.example.com"}); err == nil {
+		t.Errorf("allowlist without scheme should be rejected")
+	}
+}
+
+func TestServeRedirect(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewToken(sec, "app.js")
+
+	allowlist, _ := ParseRedirectAllowlist([]string{"https://cdn.example.com/"})
+	s := Server{
+		Secret:       sec,
+		Store:        &LocalStore{Path: t.TempDir()},
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+		Redirects:    allowlist,
+	}
+
+	post := func(target string) int {
+		r := httptest.NewRequest("POST", "/app.js", strings.NewReader(target))
+		r.Header.Set("Content-Type", RedirectType)
+		r.Header.Set("Authorization", "bearer "+token.String())
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := post("https://other.example.com/app.js"); code != http.StatusForbidden {
+		t.Errorf("expected 403 for not allowed target but got %d", code)
+	}
+	if code := post("not a url"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid target but got %d", code)
+	}
+	if code := post("https://cdn.example.com/app.js"); code != http.StatusCreated {
+		t.Fatalf("failed to publish redirect: %d", code)
+	}
+
+	r := httptest.NewRequest("GET", "/app.js?rev=1", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusFound {
+		t.Errorf("expected 302 but got %d", w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "https://cdn.example.com/app.js" {
+		t.Errorf("unexpected location: %s", loc)
+	}
+
+	s.Redirects = nil
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 after the target is removed from allowlist but got %d", w.Code)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	ReplicationQueueSize  = 10000
+	ReplicationRetryWait  = time.Second
+	ReplicationMaxBackoff = 5 * time.Minute
+)
+
+type ReplicationTask struct {
+	Key      string `json:"key"`
+	Revision int    `json:"revision"`
+
+	// MetadataOnly is true if the revision has been replicated already, and only type, labels, tags, and notes have to be updated.
+	MetadataOnly bool `json:"metadata_only,omitempty"`
+}
+
+// replicationJournalEntry is a line of the journal file.
+// The queue is restored by replaying "add" and "done" entries in order.
+type replicationJournalEntry struct {
+	Op string `json:"op"`
+	ReplicationTask
+}
+
+type Replicator struct {
+	// Label is used in logs. (default "REPLICATE")
+	Label  string
+	Target *url.URL
+	Tokens TokenHandler
+	Store  Store
+	Client *Client
+
+	lock     sync.Mutex
+	cond     *sync.Cond
+	queue    []ReplicationTask
+	inflight *ReplicationTask
+	journal  *os.File
+}
+
+func NewReplicator(target *url.URL, tokens TokenHandler, store Store) *Replicator {
+	r := &Replicator{
+		Label:  "REPLICATE",
+		Target: target,
+		Tokens: tokens,
+		Store:  store,
+		Client: &Client{
+			HTTP:  &http.Client{Timeout: 10 * time.Minute},
+			Retry: RetryPolicy{MaxAttempts: 1},
+		},
+	}
+	r.cond = sync.NewCond(&r.lock)
+	return r
+}
+
+// OpenJournal restores the queue from the journal file, and records changes of the queue to it after that.
+// Tasks that were being replicated when the server stopped are replicated again.
+func (r *Replicator) OpenJournal(path string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	queue, err := readReplicationJournal(path)
+	if err != nil {
+		return err
+	}
+	if len(queue) > ReplicationQueueSize {
+		PrintErr(r.Label, "journal for %s has %d tasks. drop %d oldest tasks", r.Target, len(queue), len(queue)-ReplicationQueueSize)
+		queue = queue[len(queue)-ReplicationQueueSize:]
+	}
+
+	// The journal is compacted to the pending tasks, so that it does not grow forever.
+	var buf bytes.Buffer
+	for _, t := range queue {
+		data, err := json.Marshal(replicationJournalEntry{"add", t})
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	if err := os.WriteFile(path+".tmp", buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.journal = f
+	r.queue = append(queue, r.queue...)
+	if len(queue) > 0 {
+		PrintLog(r.Label, "restored %d pending tasks for %s", len(queue), r.Target)
+		r.cond.Signal()
+	}
+	return nil
+}
+
+func readReplicationJournal(path string) ([]ReplicationTask, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var queue []ReplicationTask
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e replicationJournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// The last line can be broken if the server crashed while writing.
+			PrintWarn("REPLICATE", "%s: ignore broken line: %s", path, err)
+			continue
+		}
+
+		switch e.Op {
+		case "add":
+			queue = append(queue, e.ReplicationTask)
+		case "done":
+			for i, t := range queue {
+				if t == e.ReplicationTask {
+					queue = append(queue[:i], queue[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return queue, scanner.Err()
+}
+
+// record appends the change of the queue to the journal. It should be called with the lock.
+func (r *Replicator) record(op string, task ReplicationTask) {
+	if r.journal == nil {
+		return
+	}
+
+	data, err := json.Marshal(replicationJournalEntry{op, task})
+	if err == nil {
+		_, err = r.journal.Write(append(data, '\n'))
+	}
+	if err != nil {
+		PrintErr(r.Label, "failed to write journal for %s: %s", r.Target, err)
+	}
+}
+
+// Enqueue schedules replication of the revision.
+func (r *Replicator) Enqueue(key string, revision int) {
+	r.enqueue(ReplicationTask{Key: key, Revision: revision})
+}
+
+// EnqueueMetadata schedules update of the metadata of the revision, that has been patched after publish.
+func (r *Replicator) EnqueueMetadata(key string, revision int) {
+	r.enqueue(ReplicationTask{Key: key, Revision: revision, MetadataOnly: true})
+}
+
+func (r *Replicator) enqueue(task ReplicationTask) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.queue) >= ReplicationQueueSize {
+		dropped := r.queue[0]
+		r.queue = r.queue[1:]
+		r.record("done", dropped)
+		PrintErr(r.Label, "queue for %s is full. drop %s#%d", r.Target, dropped.Key, dropped.Revision)
+	}
+
+	r.queue = append(r.queue, task)
+	r.record("add", task)
+	r.cond.Signal()
+}
+
+// Pending returns the number of tasks that are not replicated yet, including the task being replicated.
+func (r *Replicator) Pending() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.inflight != nil {
+		return len(r.queue) + 1
+	}
+	return len(r.queue)
+}
+
+// next takes the oldest task from the queue.
+// The task is kept apart from the queue until done, so that dropping tasks from a full queue never drops it.
+func (r *Replicator) next() ReplicationTask {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for len(r.queue) == 0 {
+		r.cond.Wait()
+	}
+	task := r.queue[0]
+	r.queue = r.queue[1:]
+	r.inflight = &task
+	return task
+}
+
+func (r *Replicator) done(task ReplicationTask) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.inflight = nil
+	r.record("done", task)
+}
+
+func (r *Replicator) Start() {
+	go func() {
+		for {
+			r.process(r.next())
+		}
+	}()
+}
+
+// process replicates the task, and retries it with backoff until it succeeds or fails permanently.
+func (r *Replicator) process(task ReplicationTask) {
+	wait := ReplicationRetryWait
+
+	for {
+		err := r.replicate(task)
+		if err == nil {
+			r.done(task)
+			return
+		}
+
+		if herr, ok := err.(HTTPError); ok && herr.StatusCode < 500 && herr.StatusCode != http.StatusTooManyRequests {
+			PrintErr(r.Label, "%s#%d to %s: %s", task.Key, task.Revision, r.Target, err)
+			r.done(task)
+			return
+		} else if err == ErrNoSuchArtifact || err == ErrRevisionDeleted {
+			PrintWarn(r.Label, "%s#%d to %s: %s", task.Key, task.Revision, r.Target, err)
+			r.done(task)
+			return
+		}
+
+		PrintWarn(r.Label, "%s#%d to %s: %s (retry after %s, %d pending)", task.Key, task.Revision, r.Target, err, wait, r.Pending())
+		time.Sleep(wait)
+		wait *= 2
+		if wait > ReplicationMaxBackoff {
+			wait = ReplicationMaxBackoff
+		}
+	}
+}
+
+func (r *Replicator) replicate(task ReplicationTask) error {
+	token, err := r.Tokens.TokenFor(task.Key)
+	if err != nil {
+		return err
+	}
+
+	u, err := r.Target.Parse("/" + task.Key)
+	if err != nil {
+		return err
+	}
+
+	if task.MetadataOnly {
+		return r.replicateMetadata(task, token, u)
+	}
+
+	f, meta, err := r.Store.Get(task.Key, task.Revision)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Redirect artifacts have to stay redirects in the downstream.
+	contentType := "application/octet-stream"
+	if meta.Type == RedirectType {
+		contentType = RedirectType
+	}
+
+	location, err := r.Client.postArtifact(u, token, f, contentType)
+	if err != nil {
+		return err
+	}
+
+	PrintLog(r.Label, "%s#%d -> %s", task.Key, task.Revision, location)
+
+	// Retrying the task would publish the content again, so the metadata is retried as another task.
+	rev, err := locationRevision(location)
+	if err == nil {
+		err = r.patch(u, token, rev, replicationPatch(meta, nil))
+	}
+	if err != nil {
+		PrintWarn(r.Label, "%s#%d to %s: failed to set metadata: %s", task.Key, task.Revision, r.Target, err)
+		r.EnqueueMetadata(task.Key, task.Revision)
+	}
+	return nil
+}
+
+// replicateMetadata updates the metadata of the downstream revision that has the same content as the task.
+// Revision numbers of the downstream can be different, so the revision is found by the MD5 digest.
+func (r *Replicator) replicateMetadata(task ReplicationTask, token Token, u *url.URL) error {
+	meta, err := r.Store.Metadata(task.Key, task.Revision)
+	if err != nil {
+		return err
+	}
+
+	api, err := r.Target.Parse("/" + APIPrefix + "v1/revisions/" + task.Key)
+	if err != nil {
+		return err
+	}
+	api.RawQuery = url.Values{"sort": {"-revision"}, "limit": {strconv.Itoa(MaxQueryLimit)}}.Encode()
+
+	var list RevisionList
+	if err := r.Client.CallAPI("GET", api, token, nil, &list); err != nil {
+		return err
+	}
+
+	for _, x := range list.Revisions {
+		if x.Hash == meta.Hash {
+			if err := r.patch(u, token, x.Revision, replicationPatch(meta, x.Labels)); err != nil {
+				return err
+			}
+			PrintLog(r.Label, "%s#%d -> %s#%d (metadata)", task.Key, task.Revision, task.Key, x.Revision)
+			return nil
+		}
+	}
+
+	// The revision is replicated later by the task in the queue, together with the metadata at that time.
+	PrintWarn(r.Label, "%s#%d is not found in %s. skip updating metadata", task.Key, task.Revision, r.Target)
+	return nil
+}
+
+func (r *Replicator) patch(u *url.URL, token Token, revision int, patch map[string]interface{}) error {
+	pu := *u
+	pu.RawQuery = url.Values{"rev": {strconv.Itoa(revision)}}.Encode()
+	return r.Client.CallAPI("PATCH", &pu, token, patch, nil)
+}
+
+// replicationPatch makes JSON merge patch to make the metadata of the downstream revision the same as meta.
+// current is the labels of the downstream revision, that are removed if meta does not have them.
+func replicationPatch(meta Metadata, current map[string]string) map[string]interface{} {
+	labels := make(map[string]interface{})
+	for k := range current {
+		labels[k] = nil
+	}
+	for k, v := range meta.Labels {
+		labels[k] = v
+	}
+
+	patch := map[string]interface{}{
+		"type":   meta.Type,
+		"labels": labels,
+		"tags":   meta.Tags,
+		"notes":  nil,
+	}
+	if meta.Notes != "" {
+		patch["notes"] = meta.Notes
+	}
+	return patch
+}
+
+// startReplicators starts replicators for "--NAME-to" flag, with "--NAME-token" or "--NAME-secret" flag.
+func startReplicators(label, name string, store Store) ([]*Replicator, error) {
+	targets := viper.GetStringSlice(name + "-to")
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	var tokens TokenHandler
+	var err error
+	if t := strings.TrimSpace(viper.GetString(name + "-token")); t != "" {
+		tokens.Token, err = ParseToken(t)
+	} else if t := strings.TrimSpace(viper.GetString(name + "-secret")); t != "" {
+		tokens.Secret, err = ParseSecret(t)
+	} else {
+		err = fmt.Errorf("Either --%s-token or --%s-secret is required for --%s-to.", name, name, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []*Replicator
+	for _, target := range targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, errors.New("Invalid target URL: " + err.Error())
+		}
+
+		r := NewReplicator(u, tokens, store)
+		r.Label = label
+		if dir := viper.GetString(name + "-queue-dir"); dir != "" {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, err
+			}
+			if err := r.OpenJournal(filepath.Join(dir, url.PathEscape(u.Host+u.Path)+".jsonl")); err != nil {
+				return nil, fmt.Errorf("Failed to open replication journal: %s", err)
+			}
+		}
+		r.Start()
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReplicatorQueue(t *testing.T) {
+	defer func(size int) { ReplicationQueueSize = size }(ReplicationQueueSize)
+	ReplicationQueueSize = 3
+
+	r := NewReplicator(&url.URL{Scheme: "http", Host: "replica:3000", Path: "/"}, TokenHandler{}, nil)
+	r.Enqueue("a", 1)
+	r.Enqueue("b", 1)
+
+	inflight := r.next()
+	if inflight.Key != "a" {
+		t.Fatalf("unexpected first task: %v", inflight)
+	}
+
+	// The queue is full by c, d, and e, so b is dropped instead of a that is being replicated.
+	r.Enqueue("c", 1)
+	r.Enqueue("d", 1)
+	r.Enqueue("e", 1)
+	if n := r.Pending(); n != 4 {
+		t.Errorf("unexpected pending: %d", n)
+	}
+
+	r.done(inflight)
+	if n := r.Pending(); n != 3 {
+		t.Errorf("unexpected pending: %d", n)
+	}
+
+	var order []string
+	for i := 0; i < 3; i++ {
+		task := r.next()
+		order = append(order, task.Key)
+		r.done(task)
+	}
+	if !reflect.DeepEqual(order, []string{"c", "d", "e"}) {
+		t.Errorf("unexpected order: %v", order)
+	}
+}
+
+func TestReplicatorJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replica.jsonl")
+	target := &url.URL{Scheme: "http", Host: "replica:3000", Path: "/"}
+
+	r := NewReplicator(target, TokenHandler{}, nil)
+	if err := r.OpenJournal(path); err != nil {
+		t.Fatalf("failed to open journal: %s", err)
+	}
+	r.Enqueue("a", 1)
+	r.Enqueue("b", 2)
+	r.EnqueueMetadata("c", 3)
+	r.done(r.next())
+	r.next() // b is not done when the server stops.
+
+	restored := NewReplicator(target, TokenHandler{}, nil)
+	if err := restored.OpenJournal(path); err != nil {
+		t.Fatalf("failed to open journal: %s", err)
+	}
+	expected := []ReplicationTask{{Key: "b", Revision: 2}, {Key: "c", Revision: 3, MetadataOnly: true}}
+	if !reflect.DeepEqual(restored.queue, expected) {
+		t.Errorf("unexpected restored queue: %v", restored.queue)
+	}
+
+	// The journal is compacted on open, and keeps recording after that.
+	restored.done(restored.next())
+	again := NewReplicator(target, TokenHandler{}, nil)
+	if err := again.OpenJournal(path); err != nil {
+		t.Fatalf("failed to open journal: %s", err)
+	}
+	if !reflect.DeepEqual(again.queue, expected[1:]) {
+		t.Errorf("unexpected restored queue: %v", again.queue)
+	}
+}
+
+func TestReplicatorReplicate(t *testing.T) {
+	defer func(wait time.Duration) { ReplicationRetryWait = wait }(ReplicationRetryWait)
+	ReplicationRetryWait = time.Millisecond
+
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+
+	downstream := &LocalStore{Path: t.TempDir()}
+	handler := Server{Secret: sec, Store: downstream, Expectations: NewExpectationStore(), Uploads: NewUploadTracker()}
+	var failures int32 = 2
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	target, _ := url.Parse(ts.URL)
+
+	upstream := &LocalStore{Path: t.TempDir()}
+	if _, err := upstream.Put("foo/bar.txt", strings.NewReader("hello"), PutOptions{Labels: map[string]string{"build": "1", "channel": "beta"}}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if _, err := upstream.Patch("foo/bar.txt", 1, MetadataPatch{SetTags: true, Tags: []string{"v1"}, SetNotes: true, Notes: "first release"}); err != nil {
+		t.Fatalf("failed to patch: %s", err)
+	}
+
+	r := NewReplicator(target, TokenHandler{Secret: sec}, upstream)
+	r.Enqueue("foo/bar.txt", 1)
+	r.process(r.next())
+
+	if atomic.LoadInt32(&failures) >= 0 {
+		t.Errorf("replication was not retried")
+	}
+	if n := r.Pending(); n != 0 {
+		t.Errorf("unexpected pending: %d", n)
+	}
+
+	meta, err := downstream.Metadata("foo/bar.txt", 1)
+	if err != nil {
+		t.Fatalf("failed to get replicated revision: %s", err)
+	}
+	if !reflect.DeepEqual(meta.Labels, map[string]string{"build": "1", "channel": "beta"}) || !reflect.DeepEqual(meta.Tags, []string{"v1"}) || meta.Notes != "first release" {
+		t.Errorf("metadata is not replicated: %v", meta)
+	}
+
+	label := "stable"
+	if _, err := upstream.Patch("foo/bar.txt", 1, MetadataPatch{Labels: map[string]*string{"channel": &label, "build": nil}, SetNotes: true}); err != nil {
+		t.Fatalf("failed to patch: %s", err)
+	}
+	r.EnqueueMetadata("foo/bar.txt", 1)
+	r.process(r.next())
+
+	meta, err = downstream.Metadata("foo/bar.txt", 1)
+	if err != nil {
+		t.Fatalf("failed to get replicated revision: %s", err)
+	}
+	if !reflect.DeepEqual(meta.Labels, map[string]string{"channel": "stable"}) || meta.Notes != "" {
+		t.Errorf("patched metadata is not replicated: %v", meta)
+	}
+	if rev, _ := downstream.Latest("foo/bar.txt"); rev != 1 {
+		t.Errorf("metadata update published the content again: latest is %d", rev)
+	}
+}
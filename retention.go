@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var retentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Pause or resume sweeping old revisions",
+	Long: `Pause or resume sweeping old revisions.
+
+While retention is paused, the server does not remove any revision even if it exceeds --retain-num or --retain-period.
+This is useful to keep everything during incident investigations.
+
+These commands require admin token. See also 'artistore help token'.`,
+	Example: `  $ export ARTISTORE_TOKEN=$(artistore token --admin)
+  $ artistore retention pause
+  $ artistore retention status
+  $ artistore retention resume`,
+}
+
+func newRetentionCommand(use, short, method, path string) *cobra.Command {
+	c := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.ExactArgs(0),
+		Run: func(cmd *cobra.Command, args []string) {
+			t, err := NewTokenHandler()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+
+			token, err := t.TokenFor(APIPrefix)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+
+			u, err := GetAPIURL(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+
+			var status RetentionStatus
+			client, err := NewClient()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+
+			if err := client.CallAPI(method, u, token, nil, &status); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if status.Paused {
+				fmt.Println("Retention is paused.")
+			} else {
+				fmt.Println("Retention is active.")
+			}
+		},
+	}
+
+	c.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	c.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+	c.Flags().String("token", "", "Admin token. See also 'artistore help token'.")
+
+	return c
+}
+
+func init() {
+	cmd.AddCommand(retentionCmd)
+
+	retentionCmd.AddCommand(newRetentionCommand("pause", "Pause sweeping old revisions", "POST", "v1/retention/pause"))
+	retentionCmd.AddCommand(newRetentionCommand("resume", "Resume sweeping old revisions", "POST", "v1/retention/resume"))
+	retentionCmd.AddCommand(newRetentionCommand("status", "Show whether retention is paused", "GET", "v1/retention"))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetainFor(t *testing.T) {
+	store := &LocalStore{
+		Retain: RetainPolicy{Num: 10},
+		PrefixRetain: []PrefixRetainPolicy{
+			{"release/nightly/", RetainPolicy{Num: 3}},
+			{"release/", RetainPolicy{Period: 24 * time.Hour}},
+			{"rel", RetainPolicy{Num: 5}},
+			{"release/nightly/keep/", RetainPolicy{}},
+		},
+	}
+
+	tests := []struct {
+		Key    string
+		Expect RetainPolicy
+	}{
+		{"other/app.zip", RetainPolicy{Num: 10}},
+		{"release/app.zip", RetainPolicy{Period: 24 * time.Hour}},
+		{"release/nightly/app.zip", RetainPolicy{Num: 3}},
+		{"release/nightly/keep/app.zip", RetainPolicy{}},
+		{"relax/app.zip", RetainPolicy{Num: 5}},
+		{"release", RetainPolicy{Num: 5}},
+	}
+
+	for _, tt := range tests {
+		if retain := store.retainFor(tt.Key); retain != tt.Expect {
+			t.Errorf("%s: expected %+v but got %+v", tt.Key, tt.Expect, retain)
+		}
+	}
+}
+
+func TestRetentionKeepTagged(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Keep   bool
+		Retain RetainPolicy
+		Kept   []int
+	}{
+		{"num", true, RetainPolicy{Num: 1}, []int{1, 4}},
+		{"num without keep", false, RetainPolicy{Num: 1}, []int{4}},
+		{"period", true, RetainPolicy{Period: time.Nanosecond}, []int{1, 4}},
+		{"period without keep", false, RetainPolicy{Period: time.Nanosecond}, []int{4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			store := &LocalStore{Path: t.TempDir(), PrefixRetain: []PrefixRetainPolicy{{"release/", tt.Retain}}, KeepTagged: tt.Keep}
+
+			if _, err := store.Put("release/app.zip", strings.NewReader("v1"), PutOptions{}); err != nil {
+				t.Fatalf("failed to publish: %s", err)
+			}
+			if _, err := store.Patch("release/app.zip", 1, MetadataPatch{SetTags: true, Tags: []string{"v1.0.0"}}); err != nil {
+				t.Fatalf("failed to tag: %s", err)
+			}
+			for _, body := range []string{"v2", "v3", "v4"} {
+				if _, err := store.Put("release/app.zip", strings.NewReader(body), PutOptions{}); err != nil {
+					t.Fatalf("failed to publish: %s", err)
+				}
+			}
+			time.Sleep(10 * time.Millisecond) // Wait for goroutine of Put to remove old revisions.
+
+			if _, err := store.Sweep(SweepOptions{Full: true}); err != nil {
+				t.Fatalf("failed to sweep: %s", err)
+			}
+
+			revs, err := store.Revisions("release/app.zip")
+			if err != nil {
+				t.Fatalf("failed to get revisions: %s", err)
+			}
+			var kept []int
+			for _, r := range revs {
+				kept = append(kept, r.Revision)
+			}
+			if !reflect.DeepEqual(kept, tt.Kept) {
+				t.Errorf("expected revisions %v but got %v", tt.Kept, kept)
+			}
+		})
+	}
+}
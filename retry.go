@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+type RetryPolicy struct {
+	MaxAttempts int
+	Wait        time.Duration
+	MaxWait     time.Duration
+	Jitter      float64
+	RetryOn     []int
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Wait:        time.Second,
+	MaxWait:     30 * time.Second,
+	Jitter:      0.2,
+	RetryOn:     []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+}
+
+func addRetryFlags(c *cobra.Command) {
+	c.Flags().Int("retries", DefaultRetryPolicy.MaxAttempts-1, "Number of retries on temporary errors.")
+	c.Flags().Duration("retry-wait", DefaultRetryPolicy.Wait, "Wait time before the first retry. It is doubled for each retry.")
+	c.Flags().Duration("retry-max-wait", DefaultRetryPolicy.MaxWait, "Maximum wait time between retries.")
+	c.Flags().Float64("retry-jitter", DefaultRetryPolicy.Jitter, "Randomization factor of wait time between 0 and 1.")
+
+	var codes []string
+	for _, c := range DefaultRetryPolicy.RetryOn {
+		codes = append(codes, strconv.Itoa(c))
+	}
+	c.Flags().String("retry-on", strings.Join(codes, ","), "Comma separated HTTP status codes to retry.")
+}
+
+func GetRetryPolicy() (RetryPolicy, error) {
+	p := RetryPolicy{
+		MaxAttempts: viper.GetInt("retries") + 1,
+		Wait:        viper.GetDuration("retry-wait"),
+		MaxWait:     viper.GetDuration("retry-max-wait"),
+		Jitter:      viper.GetFloat64("retry-jitter"),
+	}
+
+	if p.MaxAttempts < 1 {
+		return p, fmt.Errorf("Invalid --retries: %d", p.MaxAttempts-1)
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return p, fmt.Errorf("Invalid --retry-jitter: %v", p.Jitter)
+	}
+
+	for _, x := range strings.Split(viper.GetString("retry-on"), ",") {
+		if x = strings.TrimSpace(x); x == "" {
+			continue
+		}
+		code, err := strconv.Atoi(x)
+		if err != nil || code < 100 || code > 599 {
+			return p, fmt.Errorf("Invalid status code in --retry-on: %q", x)
+		}
+		p.RetryOn = append(p.RetryOn, code)
+	}
+
+	return p, nil
+}
+
+func (p RetryPolicy) RetryableStatus(code int) bool {
+	for _, c := range p.RetryOn {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns wait time before the retry after the attempt-th attempt.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	wait := p.Wait
+	for i := 1; i < attempt && wait < p.MaxWait; i++ {
+		wait *= 2
+	}
+	if p.MaxWait > 0 && wait > p.MaxWait {
+		wait = p.MaxWait
+	}
+
+	if p.Jitter > 0 {
+		wait = time.Duration(float64(wait) * (1 - p.Jitter + 2*p.Jitter*rand.Float64()))
+	}
+
+	return wait
+}
+
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(v); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
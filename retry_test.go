@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		Policy  RetryPolicy
+		Attempt int
+		Expect  time.Duration
+	}{
+		{RetryPolicy{Wait: time.Second, MaxWait: 5 * time.Second}, 1, time.Second},
+		{RetryPolicy{Wait: time.Second, MaxWait: 5 * time.Second}, 2, 2 * time.Second},
+		{RetryPolicy{Wait: time.Second, MaxWait: 5 * time.Second}, 3, 4 * time.Second},
+		{RetryPolicy{Wait: time.Second, MaxWait: 5 * time.Second}, 4, 5 * time.Second},
+		{RetryPolicy{Wait: time.Second, MaxWait: 5 * time.Second}, 10, 5 * time.Second},
+		{RetryPolicy{Wait: 10 * time.Second, MaxWait: 5 * time.Second}, 1, 5 * time.Second},
+		{RetryPolicy{Wait: 0, MaxWait: 5 * time.Second}, 3, 0},
+	}
+
+	for _, tt := range tests {
+		if wait := tt.Policy.Backoff(tt.Attempt); wait != tt.Expect {
+			t.Errorf("%+v attempt %d: expected %s but got %s", tt.Policy, tt.Attempt, tt.Expect, wait)
+		}
+	}
+
+	p := RetryPolicy{Wait: time.Second, MaxWait: 5 * time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if wait := p.Backoff(1); wait < 500*time.Millisecond || wait > 1500*time.Millisecond {
+			t.Fatalf("expected wait between 500ms and 1.5s but got %s", wait)
+		}
+	}
+}
+
+func TestRetryableStatus(t *testing.T) {
+	tests := []struct {
+		Status int
+		Expect bool
+	}{
+		{http.StatusTooManyRequests, true},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusGatewayTimeout, true},
+		{http.StatusOK, false},
+		{http.StatusBadRequest, false},
+		{http.StatusInternalServerError, false},
+	}
+
+	for _, tt := range tests {
+		if ok := DefaultRetryPolicy.RetryableStatus(tt.Status); ok != tt.Expect {
+			t.Errorf("%d: expected %v but got %v", tt.Status, tt.Expect, ok)
+		}
+	}
+
+	if (RetryPolicy{}).RetryableStatus(http.StatusServiceUnavailable) {
+		t.Errorf("policy without RetryOn should not retry any status")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		Header string
+		Min    time.Duration
+		Max    time.Duration
+		OK     bool
+	}{
+		{"", 0, 0, false},
+		{"0", 0, 0, true},
+		{"120", 2 * time.Minute, 2 * time.Minute, true},
+		{"-1", 0, 0, false},
+		{"soon", 0, 0, false},
+		{now.Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute, true},
+		{now.Add(-time.Minute).UTC().Format(http.TimeFormat), -2 * time.Minute, -time.Minute + time.Second, true},
+	}
+
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.Header != "" {
+			resp.Header.Set("Retry-After", tt.Header)
+		}
+
+		wait, ok := retryAfter(resp)
+		if ok != tt.OK {
+			t.Errorf("%q: expected ok=%v but got %v", tt.Header, tt.OK, ok)
+		} else if wait < tt.Min || wait > tt.Max {
+			t.Errorf("%q: expected wait between %s and %s but got %s", tt.Header, tt.Min, tt.Max, wait)
+		}
+	}
+}
+
+func TestGetRetryPolicy(t *testing.T) {
+	defer viper.Set("retries", nil)
+	defer viper.Set("retry-jitter", nil)
+	defer viper.Set("retry-on", nil)
+
+	tests := []struct {
+		Retries int
+		Jitter  float64
+		RetryOn string
+		RetryOK []int
+		Error   bool
+	}{
+		{2, 0.2, "503, 429,", []int{503, 429}, false},
+		{0, 0, "", nil, false},
+		{-1, 0, "503", nil, true},
+		{2, 1.5, "503", nil, true},
+		{2, 0, "503,abc", nil, true},
+		{2, 0, "600", nil, true},
+	}
+
+	for _, tt := range tests {
+		viper.Set("retries", tt.Retries)
+		viper.Set("retry-jitter", tt.Jitter)
+		viper.Set("retry-on", tt.RetryOn)
+
+		p, err := GetRetryPolicy()
+		if tt.Error {
+			if err == nil {
+				t.Errorf("%+v: expected error but got %+v", tt, p)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %s", tt, err)
+		} else if p.MaxAttempts != tt.Retries+1 || len(p.RetryOn) != len(tt.RetryOK) {
+			t.Errorf("%+v: unexpected policy: %+v", tt, p)
+		}
+	}
+}
+
+func TestClientDoRetry(t *testing.T) {
+	var attempts, status int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		code := int(atomic.LoadInt32(&status))
+		if code == 0 {
+			// Drop the connection without response, as if the network is lost.
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.WriteHeader(code)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/hello.txt")
+	c := &Client{
+		HTTP:  &http.Client{},
+		Retry: RetryPolicy{MaxAttempts: 3, Wait: time.Millisecond, RetryOn: []int{http.StatusServiceUnavailable}},
+	}
+
+	tests := []struct {
+		Method   string
+		Status   int
+		Attempts int
+		Error    bool
+	}{
+		{"GET", 0, 3, true},
+		{"HEAD", 0, 3, true},
+		{"POST", 0, 1, true},
+		{"GET", http.StatusServiceUnavailable, 3, false},
+		{"POST", http.StatusServiceUnavailable, 3, false},
+		{"POST", http.StatusInternalServerError, 1, false},
+	}
+
+	for _, tt := range tests {
+		atomic.StoreInt32(&attempts, 0)
+		atomic.StoreInt32(&status, int32(tt.Status))
+
+		resp, err := c.Do(func() (*http.Request, error) {
+			return http.NewRequest(tt.Method, u.String(), nil)
+		})
+		if tt.Error {
+			if err == nil {
+				resp.Body.Close()
+				t.Errorf("%s %d: expected error but got %s", tt.Method, tt.Status, resp.Status)
+			}
+		} else if err != nil {
+			t.Errorf("%s %d: unexpected error: %s", tt.Method, tt.Status, err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode != tt.Status {
+				t.Errorf("%s %d: unexpected status: %s", tt.Method, tt.Status, resp.Status)
+			}
+		}
+		if n := atomic.LoadInt32(&attempts); int(n) != tt.Attempts {
+			t.Errorf("%s %d: expected %d attempts but got %d", tt.Method, tt.Status, tt.Attempts, n)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is SHA256 of empty body, that is used to sign requests without body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// unsignedPayload is used to sign requests with streaming body, because the digest can not be known before sending.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+var ErrNoS3Credentials = errors.New("AWS credentials are required.\nPlease set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.")
+
+// S3Location is an object in S3, that is written as "s3://BUCKET/KEY".
+type S3Location struct {
+	Bucket string
+	Key    string
+}
+
+func ParseS3URL(raw string) (S3Location, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return S3Location{}, fmt.Errorf("Invalid S3 URL: %s", err)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || u.Host == "" || key == "" {
+		return S3Location{}, fmt.Errorf("Invalid S3 URL: %s: URL should be like s3://bucket/key", raw)
+	}
+	return S3Location{Bucket: u.Host, Key: key}, nil
+}
+
+func (l S3Location) String() string {
+	return "s3://" + l.Bucket + "/" + l.Key
+}
+
+// S3Config is the credentials and the endpoint to access S3 or S3 compatible storages.
+type S3Config struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Region       string
+
+	// Endpoint is the URL of S3 compatible storage such as MinIO. Path-style URLs are used if it is set.
+	// Virtual-hosted-style URLs of AWS are used if it is nil.
+	Endpoint *url.URL
+}
+
+// S3ConfigFromEnv reads the standard environment variables of AWS CLI.
+func S3ConfigFromEnv(endpoint string) (S3Config, error) {
+	c := S3Config{
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		Region:       os.Getenv("AWS_REGION"),
+	}
+	if c.Region == "" {
+		c.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.AccessKey == "" || c.SecretKey == "" {
+		return c, ErrNoS3Credentials
+	}
+
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return c, fmt.Errorf("Invalid S3 endpoint: %s", endpoint)
+		}
+		c.Endpoint = u
+	}
+
+	return c, nil
+}
+
+// ObjectURL returns the HTTP URL of the object.
+// The path is escaped in the same way as the signature, so that S3 sees the same path as signed.
+func (c S3Config) ObjectURL(l S3Location) *url.URL {
+	u := &url.URL{Scheme: "https", Host: l.Bucket + ".s3." + c.Region + ".amazonaws.com", Path: "/" + l.Key}
+	if c.Endpoint != nil {
+		e := *c.Endpoint
+		e.Path = strings.TrimSuffix(e.Path, "/") + "/" + l.Bucket + "/" + l.Key
+		u = &e
+	}
+	u.RawPath = awsEscape(u.Path, true)
+	return u
+}
+
+// NewRequest makes a signed request to the object. payloadHash is SHA256 of the body in hex, or unsignedPayload.
+func (c S3Config) NewRequest(method string, l S3Location, body io.Reader, payloadHash string) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.ObjectURL(l).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	c.Sign(req, payloadHash, time.Now())
+	return req, nil
+}
+
+// awsEscape escapes s in the way of AWS Signature Version 4, that is different from url.PathEscape and url.QueryEscape.
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Sign adds the headers of AWS Signature Version 4 to req.
+// Host, Range, Content-Type, Content-MD5, and X-Amz-* headers are signed.
+func (c S3Config) Sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-type" || lower == "content-md5" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsEscape(path, true),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.Region + "/s3/aws4_request"
+	h := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(h[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), date)
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.AccessKey, scope, signedHeaders, signature))
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ScanDetection is returned when the content scanner found something in an upload.
+type ScanDetection struct {
+	Signature string
+}
+
+func (d ScanDetection) Error() string {
+	return "Rejected by content scanner: " + d.Signature
+}
+
+// ContentScanner scans uploads for viruses or other unwanted contents before they become visible.
+type ContentScanner interface {
+	// Scan starts scanning an upload of the key.
+	Scan(key string) (ScanSession, error)
+}
+
+// ScanSession receives the content of an upload by Write.
+// Finish returns ScanDetection if the scanner found something.
+// Close releases resources, and aborts the scan if it is not finished.
+type ScanSession interface {
+	io.Writer
+	Finish() error
+	Close() error
+}
+
+// NewContentScanner makes a scanner from --scan-command or --scan-clamd. It returns nil if both are empty.
+func NewContentScanner(command, clamd string) (ContentScanner, error) {
+	switch {
+	case command != "" && clamd != "":
+		return nil, errors.New("--scan-command and --scan-clamd can not be used together.")
+	case command != "":
+		return CommandScanner{command}, nil
+	case clamd != "":
+		return NewClamdScanner(clamd)
+	default:
+		return nil, nil
+	}
+}
+
+// scanReader passes the content to the scan session while reading, and returns the result of the scan instead of io.EOF.
+// So the upload fails before it becomes visible if the scanner found something.
+type scanReader struct {
+	r        io.Reader
+	session  ScanSession
+	finished bool
+}
+
+func (r *scanReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.session.Write(p[:n])
+	}
+	if err == io.EOF && !r.finished {
+		r.finished = true
+		if serr := r.session.Finish(); serr != nil {
+			return n, serr
+		}
+	}
+	return n, err
+}
+
+// scanBody wraps the body of the upload by the content scanner. The returned function should be called after the body is consumed.
+func (s Server) scanBody(key string, body io.Reader) (io.Reader, func(), error) {
+	if s.Scanner == nil {
+		return body, func() {}, nil
+	}
+
+	session, err := s.Scanner.Scan(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start content scanner: %w", err)
+	}
+	return &scanReader{r: body, session: session}, func() { session.Close() }, nil
+}
+
+// rejectInfected responds 422 for ScanDetection, and notifies it by webhooks.
+func (s Server) rejectInfected(key string, detection ScanDetection, w http.ResponseWriter, r *http.Request) {
+	PrintWarn("INFECTED", "%s %s: %s", key, r.RemoteAddr, detection.Signature)
+	s.Webhooks.SendRejected(key, detection.Error())
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	fmt.Fprintln(w, detection)
+}
+
+// CommandScanner runs the command by shell for each upload, and writes the content to its stdin.
+// The key is passed as ARTISTORE_KEY environment variable.
+//
+// The exit status 0 means clean, and 1 means detected, in the same way as clamscan and clamdscan.
+// The output of the command is used as the signature name on detection.
+type CommandScanner struct {
+	Command string
+}
+
+func (c CommandScanner) Scan(key string) (ScanSession, error) {
+	cmd := exec.Command("sh", "-c", c.Command)
+	cmd.Env = append(os.Environ(), "ARTISTORE_KEY="+key)
+
+	var out limitedBuffer
+	out.Limit = 4096
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &commandScanSession{cmd: cmd, stdin: stdin, out: &out}, nil
+}
+
+type commandScanSession struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	out   *limitedBuffer
+	done  bool
+}
+
+// Write always succeeds, because the command may exit before reading all content when it found something.
+func (s *commandScanSession) Write(p []byte) (int, error) {
+	s.stdin.Write(p)
+	return len(p), nil
+}
+
+func (s *commandScanSession) Finish() error {
+	s.done = true
+	s.stdin.Close()
+	err := s.cmd.Wait()
+
+	var exitErr *exec.ExitError
+	if err == nil {
+		return nil
+	} else if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		signature := strings.TrimSpace(s.out.String())
+		if signature == "" {
+			signature = "detected by scan command"
+		}
+		return ScanDetection{signature}
+	}
+	return fmt.Errorf("scan command failed: %s: %s", err, strings.TrimSpace(s.out.String()))
+}
+
+func (s *commandScanSession) Close() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	s.stdin.Close()
+	s.cmd.Process.Kill()
+	return s.cmd.Wait()
+}
+
+// limitedBuffer keeps only the first Limit bytes, and discards the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	Limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if rest := b.Limit - b.Len(); rest > 0 {
+		if len(p) > rest {
+			b.Buffer.Write(p[:rest])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// ClamdScanner sends uploads to clamd by INSTREAM command.
+type ClamdScanner struct {
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamdScanner parses the address of clamd, such as "tcp://localhost:3310" or "unix:///run/clamav/clamd.ctl".
+func NewClamdScanner(addr string) (ClamdScanner, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return ClamdScanner{}, fmt.Errorf("Invalid clamd address: %s", err)
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		if u.Host == "" {
+			return ClamdScanner{}, fmt.Errorf("Invalid clamd address: %s: host is required.", addr)
+		}
+		return ClamdScanner{"tcp", u.Host, time.Minute}, nil
+	case "unix":
+		if u.Path == "" {
+			return ClamdScanner{}, fmt.Errorf("Invalid clamd address: %s: path is required.", addr)
+		}
+		return ClamdScanner{"unix", u.Path, time.Minute}, nil
+	default:
+		return ClamdScanner{}, fmt.Errorf("Invalid clamd address: %s: it should start with tcp:// or unix://.", addr)
+	}
+}
+
+func (c ClamdScanner) Scan(key string) (ScanSession, error) {
+	conn, err := net.DialTimeout(c.Network, c.Address, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &clamdScanSession{conn: conn, timeout: c.Timeout}, nil
+}
+
+type clamdScanSession struct {
+	conn    net.Conn
+	timeout time.Duration
+	err     error
+}
+
+// Write sends p as a chunk of INSTREAM. Errors are reported by Finish, so that the response of clamd can be read even if clamd closed the stream.
+func (s *clamdScanSession) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return len(p), nil
+	}
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(p)))
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	if _, err := s.conn.Write(size[:]); err != nil {
+		s.err = err
+	} else if _, err := s.conn.Write(p); err != nil {
+		s.err = err
+	}
+	return len(p), nil
+}
+
+func (s *clamdScanSession) Finish() error {
+	defer s.conn.Close()
+
+	if s.err == nil {
+		s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+		_, s.err = s.conn.Write([]byte{0, 0, 0, 0})
+	}
+
+	s.conn.SetReadDeadline(time.Now().Add(s.timeout))
+	reply, err := bufio.NewReader(io.LimitReader(s.conn, 4096)).ReadString(0)
+	if err != nil && reply == "" {
+		if s.err != nil {
+			return fmt.Errorf("failed to send to clamd: %s", s.err)
+		}
+		return fmt.Errorf("failed to read reply from clamd: %s", err)
+	}
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return ScanDetection{strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")}
+	default:
+		return fmt.Errorf("clamd responded error: %s", reply)
+	}
+}
+
+func (s *clamdScanSession) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCommandScanner(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+
+	store := &LocalStore{Path: t.TempDir()}
+	ts := httptest.NewServer(Server{
+		Secret:       sec,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+		Scanner:      CommandScanner{`if grep -q EVIL; then echo "Test.Virus in $ARTISTORE_KEY"; exit 1; fi`},
+	})
+	defer ts.Close()
+
+	publish := func(key, body string) (int, string) {
+		t.Helper()
+		token, _ := NewToken(sec, key)
+		req, _ := http.NewRequest("POST", ts.URL+"/"+key, strings.NewReader(body))
+		req.Header.Set("Authorization", "bearer "+token.String())
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(msg)
+	}
+
+	if status, _ := publish("clean.txt", "hello world"); status != http.StatusCreated {
+		t.Errorf("clean upload should be published but got %d", status)
+	}
+
+	status, msg := publish("infected.txt", strings.Repeat("x", 1<<20)+"EVIL")
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("infected upload should be rejected but got %d", status)
+	}
+	if !strings.Contains(msg, "Test.Virus in infected.txt") {
+		t.Errorf("unexpected message: %s", msg)
+	}
+	if rev, _ := store.Latest("infected.txt"); rev != 0 {
+		t.Errorf("infected upload should not be visible: revision %d", rev)
+	}
+}
+
+func TestCommandScannerError(t *testing.T) {
+	session, err := CommandScanner{"exit 2"}.Scan("hello")
+	if err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	defer session.Close()
+
+	var detection ScanDetection
+	if err := session.Finish(); err == nil || errors.As(err, &detection) {
+		t.Errorf("failure of command should not be a detection: %v", err)
+	}
+}
+
+func fakeClamd(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				if cmd, err := r.ReadString(0); err != nil || cmd != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+
+				var data bytes.Buffer
+				for {
+					var size uint32
+					if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+						return
+					}
+				}
+
+				if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}(conn)
+		}
+	}()
+
+	return "tcp://" + l.Addr().String()
+}
+
+func TestClamdScanner(t *testing.T) {
+	scanner, err := NewClamdScanner(fakeClamd(t))
+	if err != nil {
+		t.Fatalf("failed to make scanner: %s", err)
+	}
+
+	scan := func(body string) error {
+		session, err := scanner.Scan("hello")
+		if err != nil {
+			t.Fatalf("failed to start: %s", err)
+		}
+		defer session.Close()
+
+		_, err = io.Copy(io.Discard, &scanReader{r: strings.NewReader(body), session: session})
+		return err
+	}
+
+	if err := scan("hello world"); err != nil {
+		t.Errorf("clean content should pass: %s", err)
+	}
+
+	var detection ScanDetection
+	if err := scan("hello EICAR world"); !errors.As(err, &detection) || detection.Signature != "Eicar-Test-Signature" {
+		t.Errorf("unexpected result: %v", err)
+	}
+
+	for _, addr := range []string{"localhost:3310", "tcp://", "http://localhost"} {
+		if _, err := NewClamdScanner(addr); err == nil {
+			t.Errorf("%s: expected error", addr)
+		}
+	}
+}
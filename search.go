@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search QUERY...",
+	Short: "Search artifacts by key pattern and labels",
+	Long: `Search artifacts by key pattern and labels.
+
+Each QUERY is one of the following, and artifacts that match all of them are shown.
+
+  PATTERN      the key matches the glob pattern, such as "release/*/app.zip". "**" matches any directories.
+               A pattern without wildcards matches keys that contain it.
+  /REGEXP/     the key matches the regular expression.
+  NAME=VALUE   the artifact has the label NAME with VALUE.
+
+Only the latest revisions are searched unless --all is set.
+With --quiet, only the URLs of the found revisions are printed.
+Artifacts under --private prefixes of the server are never shown.`,
+	Example: `  $ artistore search build=4812
+  $ artistore search --all 'release/**/*.zip' commit=abc123
+  $ artistore search '/^nightly\/[0-9]+\//'`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		query := strings.Join(args, " ")
+		if _, err := ParseSearchQuery(query); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		u, err := GetAPIURL("v1/search")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		values := url.Values{"q": {query}}
+		if prefix, _ := cmd.Flags().GetString("prefix"); prefix != "" {
+			values.Set("prefix", prefix)
+		}
+		if all, _ := cmd.Flags().GetBool("all"); all {
+			values.Set("all", "true")
+		}
+		if sort, _ := cmd.Flags().GetString("sort"); sort != "" {
+			values.Set("sort", sort)
+		}
+		if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 {
+			values.Set("limit", strconv.Itoa(limit))
+		}
+		u.RawQuery = values.Encode()
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		var result SearchResult
+		if err := client.CallAPI("GET", u, nil, nil, &result); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		switch format {
+		case OutputJSON:
+			printJSON(result)
+			return
+		case OutputQuiet:
+			for _, x := range result.Results {
+				if u, err := revisionURL(x.Key, x.Revision); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %s\n", x.Key, err)
+				} else {
+					fmt.Println(u)
+				}
+			}
+		default:
+			for _, x := range result.Results {
+				fmt.Printf("%s#%d\t%s\t%s\n", x.Key, x.Revision, FormatSize(int64(x.Size)), x.Timestamp.Format(time.RFC3339))
+			}
+		}
+		if result.NextCursor != "" {
+			fmt.Fprintln(os.Stderr, "More results are available. Use --limit to show more.")
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(searchCmd)
+
+	searchCmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	searchCmd.Flags().String("prefix", "", "Search only keys under the prefix.")
+	searchCmd.Flags().Bool("all", false, "Search all revisions, not only the latest revisions.")
+	searchCmd.Flags().String("sort", "", `Sort order: "key", "revision", "size", or "timestamp". "-" prefix means descending order. (default "key")`)
+	searchCmd.Flags().Int("limit", 100, fmt.Sprintf("Maximum number of results, up to %d.", MaxQueryLimit))
+	addOutputFlags(searchCmd)
+}
+
+// SearchQuery is the q parameter of the search API.
+// Terms are separated by spaces, and artifacts have to match all of them.
+type SearchQuery struct {
+	// Globs are glob patterns of keys. Patterns without wildcards match keys that contain them.
+	Globs []string
+
+	// Regexps are regular expressions of keys, written as "/REGEXP/".
+	Regexps []*regexp.Regexp
+
+	// Labels are labels written as "NAME=VALUE".
+	Labels map[string]string
+}
+
+func ParseSearchQuery(raw string) (SearchQuery, error) {
+	var q SearchQuery
+
+	for _, term := range strings.Fields(raw) {
+		switch {
+		case len(term) >= 2 && strings.HasPrefix(term, "/") && strings.HasSuffix(term, "/"):
+			re, err := regexp.Compile(term[1 : len(term)-1])
+			if err != nil {
+				return q, fmt.Errorf("Invalid regular expression: %s: %s", term, err)
+			}
+			q.Regexps = append(q.Regexps, re)
+		case strings.Contains(term, "="):
+			xs := strings.SplitN(term, "=", 2)
+			if xs[0] == "" {
+				return q, fmt.Errorf("Invalid label: %s: it should be NAME=VALUE.", term)
+			}
+			if q.Labels == nil {
+				q.Labels = make(map[string]string)
+			}
+			q.Labels[xs[0]] = xs[1]
+		default:
+			q.Globs = append(q.Globs, term)
+		}
+	}
+
+	if len(q.Globs) == 0 && len(q.Regexps) == 0 && len(q.Labels) == 0 {
+		return q, errors.New("Query q is required.")
+	}
+	return q, nil
+}
+
+// MatchKey checks if the key matches all key patterns.
+func (q SearchQuery) MatchKey(key string) bool {
+	for _, g := range q.Globs {
+		if hasGlobMeta(g) {
+			if !matchGlob(g, key) {
+				return false
+			}
+		} else if !strings.Contains(key, g) {
+			return false
+		}
+	}
+	for _, re := range q.Regexps {
+		if !re.MatchString(key) {
+			return false
+		}
+	}
+	return true
+}
+
+type SearchResult struct {
+	Results    []ArtifactInfo `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// search finds revisions under the prefix that match sq.
+// Only the latest revisions are searched by the metadata index unless all is true.
+func (s Server) search(sq SearchQuery, prefix string, all bool) ([]Metadata, error) {
+	if !all {
+		metas, err := ListLatest(s.Store, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		xs := make([]Metadata, 0, len(metas))
+		for _, m := range metas {
+			if sq.MatchKey(m.Key) && !s.isPrivate(m.Key) {
+				xs = append(xs, m)
+			}
+		}
+		return xs, nil
+	}
+
+	keys, err := s.Store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var metas []Metadata
+	for _, key := range s.publicKeys(keys) {
+		if !sq.MatchKey(key) {
+			continue
+		}
+		revs, err := s.Store.Revisions(key)
+		if err == ErrNoSuchArtifact {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, m := range revs {
+			m.Key = key
+			metas = append(metas, m)
+		}
+	}
+	return metas, nil
+}
+
+func (s Server) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	query := r.URL.Query()
+	q, err := ParseQuery(query, []string{"key", "revision", "size", "timestamp"}, true)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	sq, err := ParseSearchQuery(query.Get("q"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+	for k, v := range sq.Labels {
+		if q.Labels == nil {
+			q.Labels = make(map[string]string)
+		}
+		q.Labels[k] = v
+	}
+
+	all := false
+	if v := query.Get("all"); v != "" {
+		if all, err = strconv.ParseBool(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid all: it should be true or false.")
+			return
+		}
+	}
+
+	metas, err := s.search(sq, query.Get("prefix"), all)
+	if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	metas = q.Filter(metas)
+	q.SortMetadata(metas)
+	start, end, next := q.Page(len(metas))
+	metas = metas[start:end]
+
+	result := SearchResult{Results: make([]ArtifactInfo, len(metas)), NextCursor: next}
+	for i, meta := range metas {
+		result.Results[i] = NewArtifactInfo(meta)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
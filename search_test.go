@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	q, err := ParseSearchQuery("release/** /\\.zip$/ build=4812 commit=")
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if !reflect.DeepEqual(q.Globs, []string{"release/**"}) {
+		t.Errorf("unexpected globs: %v", q.Globs)
+	}
+	if len(q.Regexps) != 1 || q.Regexps[0].String() != `\.zip$` {
+		t.Errorf("unexpected regexps: %v", q.Regexps)
+	}
+	if !reflect.DeepEqual(q.Labels, map[string]string{"build": "4812", "commit": ""}) {
+		t.Errorf("unexpected labels: %v", q.Labels)
+	}
+
+	for _, raw := range []string{"", "  ", "/[/", "=value"} {
+		if _, err := ParseSearchQuery(raw); err == nil {
+			t.Errorf("%q: expected error", raw)
+		}
+	}
+}
+
+func TestSearchQuery_MatchKey(t *testing.T) {
+	tests := []struct {
+		Query string
+		Key   string
+		Match bool
+	}{
+		{"app", "release/app.zip", true},
+		{"app", "release/lib.zip", false},
+		{"release/*.zip", "release/app.zip", true},
+		{"release/*.zip", "release/v1/app.zip", false},
+		{"release/**/*.zip", "release/v1/app.zip", true},
+		{"/^nightly/[0-9]+//", "nightly/42/app.zip", true},
+		{"/^nightly/[0-9]+//", "nightly/latest/app.zip", false},
+		{"release /zip$/", "release/app.zip", true},
+		{"release /tar$/", "release/app.zip", false},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseSearchQuery(tt.Query)
+		if err != nil {
+			t.Fatalf("%q: failed to parse: %s", tt.Query, err)
+		}
+		if q.MatchKey(tt.Key) != tt.Match {
+			t.Errorf("%q %q: expected %v", tt.Query, tt.Key, tt.Match)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	put := func(key, build string) {
+		t.Helper()
+		if _, err := store.Put(key, strings.NewReader(key+build), PutOptions{Labels: map[string]string{"build": build}}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	put("release/app.zip", "4811")
+	put("release/app.zip", "4812")
+	put("release/lib.zip", "4812")
+	put("nightly/app.zip", "4810")
+	put("secret/app.zip", "4812")
+
+	s := Server{Store: store, Private: []string{"secret/"}}
+
+	tests := []struct {
+		Query  url.Values
+		Status int
+		Expect []string
+	}{
+		{url.Values{"q": {"build=4812"}}, http.StatusOK, []string{"release/app.zip#2", "release/lib.zip#1"}},
+		{url.Values{"q": {"build=4811"}}, http.StatusOK, []string{}},
+		{url.Values{"q": {"build=4811"}, "all": {"true"}}, http.StatusOK, []string{"release/app.zip#1"}},
+		{url.Values{"q": {"app.zip"}, "sort": {"-key"}}, http.StatusOK, []string{"release/app.zip#2", "nightly/app.zip#1"}},
+		{url.Values{"q": {"/^release/"}, "all": {"1"}, "sort": {"-revision"}, "limit": {"1"}}, http.StatusOK, []string{"release/app.zip#2"}},
+		{url.Values{"q": {"*/app.zip"}, "prefix": {"nightly/"}}, http.StatusOK, []string{"nightly/app.zip#1"}},
+		{url.Values{"q": {"**/*.zip"}, "label": {"build=4810"}}, http.StatusOK, []string{"nightly/app.zip#1"}},
+		{url.Values{}, http.StatusBadRequest, nil},
+		{url.Values{"q": {"/[/"}}, http.StatusBadRequest, nil},
+		{url.Values{"q": {"app"}, "all": {"maybe"}}, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/"+APIPrefix+"v1/search?"+tt.Query.Encode(), nil))
+
+		if w.Code != tt.Status {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.Query.Encode(), tt.Status, w.Code, w.Body)
+			continue
+		}
+		if tt.Status != http.StatusOK {
+			continue
+		}
+
+		var result SearchResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: failed to parse response: %s", tt.Query.Encode(), err)
+		}
+		got := []string{}
+		for _, x := range result.Results {
+			got = append(got, x.Key+"#"+strconv.Itoa(x.Revision))
+		}
+		if !reflect.DeepEqual(got, tt.Expect) {
+			t.Errorf("%s: unexpected results: %v", tt.Query.Encode(), got)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Run an end-to-end test with a temporary server",
+	Long: `Run an end-to-end test with a temporary server.
+
+This command starts a server in this process on a random port of localhost with a temporary store,
+and runs a scripted scenario of publish, get, range, conditional requests, and authorization against it.
+The result of each step is printed, and the exit status is 1 if any step failed.
+With --quiet, only failed steps are printed.
+
+It is useful as a smoke test of the binary after deployment, or as an integration test in CI.
+Nothing is written outside of the temporary directory, and the server is stopped after the test.`,
+	Example: `  $ artistore selftest
+  $ artistore selftest --output json`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		if verbose, _ := cmd.Flags().GetBool("verbose"); !verbose {
+			LogLock.Lock()
+			LogStream, ErrStream = io.Discard, io.Discard
+			LogLock.Unlock()
+		}
+
+		report, err := RunSelfTest()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to start self test:", err)
+			os.Exit(2)
+		}
+
+		switch format {
+		case OutputJSON:
+			printJSON(report)
+		case OutputQuiet:
+			for _, s := range report.Steps {
+				if !s.OK {
+					fmt.Printf("FAIL %s (%s): %s\n", s.Name, s.Duration, s.Error)
+				}
+			}
+		default:
+			for _, s := range report.Steps {
+				if s.OK {
+					fmt.Printf("PASS %s (%s)\n", s.Name, s.Duration)
+				} else {
+					fmt.Printf("FAIL %s (%s): %s\n", s.Name, s.Duration, s.Error)
+				}
+			}
+			fmt.Printf("%d passed, %d failed.\n", report.Passed, report.Failed)
+		}
+
+		if report.Failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(selftestCmd)
+
+	addOutputFlags(selftestCmd)
+	selftestCmd.Flags().BoolP("verbose", "v", false, "Show logs of the temporary server.")
+}
+
+type SelfTestStep struct {
+	Name     string        `json:"name"`
+	OK       bool          `json:"ok"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+type SelfTestReport struct {
+	Version string         `json:"version"`
+	Passed  int            `json:"passed"`
+	Failed  int            `json:"failed"`
+	Steps   []SelfTestStep `json:"steps"`
+}
+
+// selfTest is the state shared between steps of the scenario.
+type selfTest struct {
+	base    *url.URL
+	client  *http.Client
+	secret  Secret
+	publish Token
+	etag    string
+}
+
+func (t *selfTest) request(method, path string, token Token, body string, header map[string]string) (*http.Response, string, error) {
+	u, err := t.base.Parse(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req, err := http.NewRequest(method, u.String(), strings.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	if token != nil {
+		req.Header.Set("Authorization", "bearer "+token.String())
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	return resp, string(data), err
+}
+
+func expectStatus(resp *http.Response, body string, status int) error {
+	if resp.StatusCode != status {
+		return fmt.Errorf("expected status %d but got %d: %s", status, resp.StatusCode, strings.TrimSpace(body))
+	}
+	return nil
+}
+
+type selfTestScenario struct {
+	Name string
+	Run  func() error
+}
+
+func (t *selfTest) steps() []selfTestScenario {
+	return []selfTestScenario{
+		{"reject publish without token", func() error {
+			resp, body, err := t.request("POST", "/selftest/hello.txt", nil, "hello", nil)
+			if err != nil {
+				return err
+			}
+			return expectStatus(resp, body, http.StatusForbidden)
+		}},
+		{"reject publish with token for other key", func() error {
+			token, err := NewToken(t.secret, "other/")
+			if err != nil {
+				return err
+			}
+			resp, body, err := t.request("POST", "/selftest/hello.txt", token, "hello", nil)
+			if err != nil {
+				return err
+			}
+			return expectStatus(resp, body, http.StatusForbidden)
+		}},
+		{"publish first revision", func() error {
+			resp, body, err := t.request("POST", "/selftest/hello.txt", t.publish, "hello world", nil)
+			if err != nil {
+				return err
+			}
+			if err := expectStatus(resp, body, http.StatusCreated); err != nil {
+				return err
+			}
+			if loc := resp.Header.Get("Location"); loc != "/selftest/hello.txt?rev=1" {
+				return fmt.Errorf("unexpected location: %s", loc)
+			}
+			return nil
+		}},
+		{"publish second revision", func() error {
+			resp, body, err := t.request("POST", "/selftest/hello.txt", t.publish, "hello artistore", nil)
+			if err != nil {
+				return err
+			}
+			if err := expectStatus(resp, body, http.StatusCreated); err != nil {
+				return err
+			}
+			if loc := resp.Header.Get("Location"); loc != "/selftest/hello.txt?rev=2" {
+				return fmt.Errorf("unexpected location: %s", loc)
+			}
+			return nil
+		}},
+		{"redirect to latest revision", func() error {
+			resp, body, err := t.request("GET", "/selftest/hello.txt", nil, "", nil)
+			if err != nil {
+				return err
+			}
+			if err := expectStatus(resp, body, http.StatusSeeOther); err != nil {
+				return err
+			}
+			if loc := resp.Header.Get("Location"); loc != "/selftest/hello.txt?rev=2" {
+				return fmt.Errorf("unexpected location: %s", loc)
+			}
+			return nil
+		}},
+		{"get old revision", func() error {
+			resp, body, err := t.request("GET", "/selftest/hello.txt?rev=1", nil, "", nil)
+			if err != nil {
+				return err
+			}
+			if err := expectStatus(resp, body, http.StatusOK); err != nil {
+				return err
+			}
+			if body != "hello world" {
+				return fmt.Errorf("unexpected content: %q", body)
+			}
+			t.etag = resp.Header.Get("Etag")
+			if t.etag == "" {
+				return fmt.Errorf("no ETag header")
+			}
+			return nil
+		}},
+		{"get latest revision directly", func() error {
+			resp, body, err := t.request("GET", "/selftest/hello.txt?rev=latest", nil, "", nil)
+			if err != nil {
+				return err
+			}
+			if err := expectStatus(resp, body, http.StatusOK); err != nil {
+				return err
+			}
+			if body != "hello artistore" || resp.Header.Get("X-Artistore-Revision") != "2" {
+				return fmt.Errorf("unexpected content: revision %s: %q", resp.Header.Get("X-Artistore-Revision"), body)
+			}
+			return nil
+		}},
+		{"get range", func() error {
+			resp, body, err := t.request("GET", "/selftest/hello.txt?rev=1", nil, "", map[string]string{"Range": "bytes=6-10"})
+			if err != nil {
+				return err
+			}
+			if err := expectStatus(resp, body, http.StatusPartialContent); err != nil {
+				return err
+			}
+			if body != "world" {
+				return fmt.Errorf("unexpected content: %q", body)
+			}
+			return nil
+		}},
+		{"conditional get", func() error {
+			resp, body, err := t.request("GET", "/selftest/hello.txt?rev=1", nil, "", map[string]string{"If-None-Match": t.etag})
+			if err != nil {
+				return err
+			}
+			return expectStatus(resp, body, http.StatusNotModified)
+		}},
+		{"head", func() error {
+			resp, body, err := t.request("HEAD", "/selftest/hello.txt?rev=1", nil, "", nil)
+			if err != nil {
+				return err
+			}
+			if err := expectStatus(resp, body, http.StatusOK); err != nil {
+				return err
+			}
+			if resp.Header.Get("Content-Length") != "11" || body != "" {
+				return fmt.Errorf("unexpected response: Content-Length %s: %q", resp.Header.Get("Content-Length"), body)
+			}
+			return nil
+		}},
+		{"reject delete by publish token", func() error {
+			resp, body, err := t.request("DELETE", "/selftest/hello.txt?rev=1", t.publish, "", nil)
+			if err != nil {
+				return err
+			}
+			return expectStatus(resp, body, http.StatusForbidden)
+		}},
+		{"delete by scoped token", func() error {
+			token, err := NewScopedToken(t.secret, "selftest/", ScopeDelete)
+			if err != nil {
+				return err
+			}
+			resp, body, err := t.request("DELETE", "/selftest/hello.txt?rev=1", token, "", nil)
+			if err != nil {
+				return err
+			}
+			if err := expectStatus(resp, body, http.StatusNoContent); err != nil {
+				return err
+			}
+
+			resp, body, err = t.request("GET", "/selftest/hello.txt?rev=1", nil, "", nil)
+			if err != nil {
+				return err
+			}
+			return expectStatus(resp, body, http.StatusGone)
+		}},
+		{"not found", func() error {
+			resp, body, err := t.request("GET", "/selftest/missing.txt", nil, "", nil)
+			if err != nil {
+				return err
+			}
+			return expectStatus(resp, body, http.StatusNotFound)
+		}},
+	}
+}
+
+// RunSelfTest starts a temporary server and runs the scenario against it.
+// The error is returned only if failed to start the server. Failures of steps are reported in SelfTestReport.
+func RunSelfTest() (SelfTestReport, error) {
+	report := SelfTestReport{Version: version + " (" + commit + ")", Steps: []SelfTestStep{}}
+
+	dir, err := os.MkdirTemp("", "artistore-selftest-")
+	if err != nil {
+		return report, err
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalStore(dir+"/store", RetainPolicy{})
+	if err != nil {
+		return report, err
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		return report, err
+	}
+	publish, err := NewToken(secret, "selftest/")
+	if err != nil {
+		return report, err
+	}
+
+	s := Server{
+		Secret:       secret,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+		Metrics:      NewMetrics(),
+		Idempotency:  NewIdempotencyStore(),
+		Transactions: NewTransactionStore(dir + "/staging"),
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return report, err
+	}
+	server := &http.Server{Handler: CompressHandler(CompressOptions{ETag: ETagAuto}, s)}
+	go server.Serve(ln)
+	defer server.Close()
+
+	t := &selfTest{
+		base: &url.URL{Scheme: "http", Host: ln.Addr().String(), Path: "/"},
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		secret:  secret,
+		publish: publish,
+	}
+
+	for _, step := range t.steps() {
+		start := time.Now()
+		err := step.Run()
+		result := SelfTestStep{Name: step.Name, OK: err == nil, Duration: time.Since(start).Round(time.Microsecond)}
+		if err != nil {
+			result.Error = err.Error()
+			report.Failed++
+		} else {
+			report.Passed++
+		}
+		report.Steps = append(report.Steps, result)
+	}
+
+	return report, nil
+}
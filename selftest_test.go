@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRunSelfTest(t *testing.T) {
+	report, err := RunSelfTest()
+	if err != nil {
+		t.Fatalf("failed to start self test: %s", err)
+	}
+
+	for _, s := range report.Steps {
+		if !s.OK {
+			t.Errorf("%s: %s", s.Name, s.Error)
+		}
+	}
+	if report.Failed != 0 || report.Passed != len(report.Steps) || report.Passed == 0 {
+		t.Errorf("unexpected report: %d passed, %d failed in %d steps", report.Passed, report.Failed, len(report.Steps))
+	}
+}
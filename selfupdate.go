@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// releasePublicKey is base64 encoded ed25519 public key to verify SHA256SUMS of releases.
+	// It is set by -ldflags at release build. Signature is not verified if it is empty.
+	releasePublicKey = ""
+
+	releaseRepository = "macrat/artistore"
+	githubAPI         = "https://api.github.com"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("Checksum of the downloaded binary does not match.")
+	ErrInvalidSignature = errors.New("Signature of SHA256SUMS is invalid.")
+)
+
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Update artistore command to the latest release",
+	Long: `Update artistore command to the latest release.
+
+The new binary is downloaded from GitHub releases, and verified by SHA256SUMS of the release before replacing the current binary.
+If artistore is installed by a package manager such as Homebrew or Scoop, please use the package manager instead.`,
+	Example: `  $ artistore self-update
+  $ artistore self-update --check
+  $ artistore self-update --version 1.2.0`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		check, _ := cmd.Flags().GetBool("check")
+		force, _ := cmd.Flags().GetBool("force")
+		target, _ := cmd.Flags().GetString("version")
+
+		exe, err := os.Executable()
+		if err == nil {
+			exe, err = filepath.EvalSymlinks(exe)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to find the binary:", err)
+			os.Exit(1)
+		}
+		if pm := packageManager(exe); pm != "" && !check {
+			fmt.Fprintf(os.Stderr, "artistore is installed by %s. Please update via %s.\n", pm, pm)
+			os.Exit(2)
+		}
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		rel, err := FetchRelease(client, target)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to fetch release:", err)
+			os.Exit(1)
+		}
+
+		latest := strings.TrimPrefix(rel.TagName, "v")
+		if target == "" && !force {
+			if version == "HEAD" {
+				fmt.Fprintf(os.Stderr, "This is a development build. The latest release is %s. Please use --force to install it.\n", latest)
+				os.Exit(2)
+			}
+			if compareVersions(version, latest) >= 0 {
+				fmt.Printf("Already up to date: %s\n", version)
+				return
+			}
+		}
+
+		if check {
+			fmt.Printf("New version is available: %s -> %s\n", version, latest)
+			return
+		}
+
+		if err := InstallRelease(client, rel, exe); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to update:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Updated: %s -> %s\n", version, latest)
+	},
+}
+
+func init() {
+	cmd.AddCommand(selfUpdateCmd)
+
+	selfUpdateCmd.Flags().Bool("check", false, "Only check whether a new version is available.")
+	selfUpdateCmd.Flags().Bool("force", false, "Install the release even if the current version is the same or newer.")
+	selfUpdateCmd.Flags().String("version", "", "Version to install. (default latest)")
+
+	addRetryFlags(selfUpdateCmd)
+}
+
+type Release struct {
+	TagName string         `json:"tag_name"`
+	Assets  []ReleaseAsset `json:"assets"`
+}
+
+type ReleaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+
+	// APIURL returns the content if requested with "Accept: application/octet-stream". It works for private repositories too.
+	APIURL string `json:"url"`
+
+	// Digest is like "sha256:HEX". It is empty for assets uploaded before GitHub started to record digests.
+	Digest string `json:"digest"`
+}
+
+func (r Release) Asset(name string) (ReleaseAsset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return ReleaseAsset{}, false
+}
+
+// FetchRelease gets the release from GitHub. The latest release is returned if version is empty.
+func FetchRelease(client *Client, version string) (Release, error) {
+	var rel Release
+
+	path := "/repos/" + releaseRepository + "/releases/latest"
+	if version != "" {
+		path = "/repos/" + releaseRepository + "/releases/tags/v" + strings.TrimPrefix(version, "v")
+	}
+
+	u, err := url.Parse(githubAPI + path)
+	if err != nil {
+		return rel, err
+	}
+
+	err = client.CallAPI("GET", u, nil, nil, &rel)
+	return rel, err
+}
+
+// releaseAssetName is the name of binary for the running platform in releases.
+func releaseAssetName() string {
+	name := "artistore_" + runtime.GOOS + "_" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// packageManager detects package manager that installed the binary.
+func packageManager(exe string) string {
+	exe = filepath.ToSlash(exe)
+	switch {
+	case strings.Contains(exe, "/Cellar/") || strings.Contains(exe, "/homebrew/"):
+		return "Homebrew"
+	case strings.Contains(strings.ToLower(exe), "/scoop/apps/"):
+		return "Scoop"
+	default:
+		return ""
+	}
+}
+
+// compareVersions compares versions like "1.2.3". It returns negative value if a is older than b.
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// parseChecksums parses output of sha256sum command.
+func parseChecksums(data []byte) map[string]string {
+	sums := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+		}
+	}
+	return sums
+}
+
+// VerifySignature checks ed25519 signature of SHA256SUMS.
+func VerifySignature(sums, sig []byte, publicKey string) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("Invalid public key for releases.")
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(key), sums, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func download(client *Client, rawURL string, w io.Writer) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return HTTPError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func downloadAsset(client *Client, rel Release, name string) ([]byte, error) {
+	asset, ok := rel.Asset(name)
+	if !ok {
+		return nil, fmt.Errorf("Release %s does not have %s.", rel.TagName, name)
+	}
+
+	var buf bytes.Buffer
+	err := download(client, asset.URL, &buf)
+	return buf.Bytes(), err
+}
+
+// InstallRelease downloads the binary of the release, and replaces exe with it.
+func InstallRelease(client *Client, rel Release, exe string) error {
+	sums, err := downloadAsset(client, rel, "SHA256SUMS")
+	if err != nil {
+		return err
+	}
+
+	if releasePublicKey != "" {
+		sig, err := downloadAsset(client, rel, "SHA256SUMS.sig")
+		if err != nil {
+			return err
+		}
+		if err := VerifySignature(sums, sig, releasePublicKey); err != nil {
+			return err
+		}
+	}
+
+	name := releaseAssetName()
+	expect, ok := parseChecksums(sums)[name]
+	if !ok {
+		return fmt.Errorf("SHA256SUMS does not have checksum of %s.", name)
+	}
+	asset, ok := rel.Asset(name)
+	if !ok {
+		return fmt.Errorf("Release %s does not have %s.", rel.TagName, name)
+	}
+
+	// The temporary file is in the same directory, so that it can be renamed to exe atomically.
+	f, err := os.CreateTemp(filepath.Dir(exe), ".artistore-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	if err := download(client, asset.URL, io.MultiWriter(f, h)); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != expect {
+		return ErrChecksumMismatch
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), stat.Mode().Perm()|0111); err != nil {
+		return err
+	}
+
+	// Running binary can not be overwritten on Windows, but can be renamed.
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+	os.Remove(old)
+
+	return nil
+}
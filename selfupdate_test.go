@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		A, B   string
+		Result int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.0", "v1.0.0", 0},
+		{"1.0", "1.0.0", 0},
+		{"1.0.0", "1.0.1", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0.0", "1.99.99", 1},
+	}
+
+	for _, tt := range tests {
+		r := compareVersions(tt.A, tt.B)
+		if (r < 0 && tt.Result >= 0) || (r > 0 && tt.Result <= 0) || (r == 0 && tt.Result != 0) {
+			t.Errorf("compareVersions(%q, %q): expected %d but got %d", tt.A, tt.B, tt.Result, r)
+		}
+	}
+}
+
+func TestPackageManager(t *testing.T) {
+	tests := map[string]string{
+		"/usr/local/Cellar/artistore/1.0.0/bin/artistore":      "Homebrew",
+		"/opt/homebrew/bin/artistore":                          "Homebrew",
+		"C:/Users/user/scoop/apps/artistore/current/artistore": "Scoop",
+		"/usr/local/bin/artistore":                             "",
+	}
+
+	for exe, expect := range tests {
+		if pm := packageManager(exe); pm != expect {
+			t.Errorf("%s: expected %q but got %q", exe, expect, pm)
+		}
+	}
+}
+
+func TestInstallRelease(t *testing.T) {
+	binary := []byte("new binary")
+	hash := sha256.Sum256(binary)
+	sums := []byte(hex.EncodeToString(hash[:]) + "  " + releaseAssetName() + "\n")
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	sig := ed25519.Sign(priv, sums)
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/" + releaseRepository + "/releases/latest":
+			json.NewEncoder(w).Encode(Release{
+				TagName: "v1.2.3",
+				Assets: []ReleaseAsset{
+					{Name: releaseAssetName(), URL: ts.URL + "/binary"},
+					{Name: "SHA256SUMS", URL: ts.URL + "/SHA256SUMS"},
+					{Name: "SHA256SUMS.sig", URL: ts.URL + "/SHA256SUMS.sig"},
+				},
+			})
+		case "/binary":
+			w.Write(binary)
+		case "/SHA256SUMS":
+			w.Write(sums)
+		case "/SHA256SUMS.sig":
+			w.Write(sig)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	defer func(api, key string) {
+		githubAPI = api
+		releasePublicKey = key
+	}(githubAPI, releasePublicKey)
+	githubAPI = ts.URL
+
+	client, _ := NewClient()
+	rel, err := FetchRelease(client, "")
+	if err != nil {
+		t.Fatalf("failed to fetch release: %s", err)
+	}
+	if rel.TagName != "v1.2.3" {
+		t.Errorf("unexpected tag name: %s", rel.TagName)
+	}
+
+	exe := filepath.Join(t.TempDir(), "artistore")
+	if err := os.WriteFile(exe, []byte("old binary"), 0755); err != nil {
+		t.Fatalf("failed to write binary: %s", err)
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	releasePublicKey = base64.StdEncoding.EncodeToString(otherPub)
+	if err := InstallRelease(client, rel, exe); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature but got %v", err)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "old binary" {
+		t.Errorf("binary should not be replaced if signature is invalid: %q", data)
+	}
+
+	binary = []byte("tampered binary")
+	releasePublicKey = base64.StdEncoding.EncodeToString(pub)
+	if err := InstallRelease(client, rel, exe); err != ErrChecksumMismatch {
+		t.Errorf("expected ErrChecksumMismatch but got %v", err)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "old binary" {
+		t.Errorf("binary should not be replaced if checksum does not match: %q", data)
+	}
+
+	binary = []byte("new binary")
+	if err := InstallRelease(client, rel, exe); err != nil {
+		t.Fatalf("failed to install: %s", err)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "new binary" {
+		t.Errorf("binary is not replaced: %q", data)
+	}
+
+	xs, _ := os.ReadDir(filepath.Dir(exe))
+	if len(xs) != 1 {
+		t.Errorf("temporary files should be removed: %v", xs)
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/NYTimes/gziphandler"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -17,6 +21,10 @@ var (
 	InternalServerErrorMessage = "Internal server error.\nPlease check server log if you are server administrator."
 )
 
+// ResolveHeader is the request header to get the latest revision without redirect, by "X-Artistore-Resolve: direct".
+// It is the same as "?rev=latest".
+const ResolveHeader = "X-Artistore-Resolve"
+
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Start Artistore server",
@@ -29,18 +37,242 @@ var serveCmd = &cobra.Command{
 			os.Exit(2)
 		}
 
+		if err := SetIDFormat(viper.GetString("id-format")); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		store, err := NewLocalStore(viper.GetString("store"), RetainPolicy{viper.GetInt("retain-num"), viper.GetDuration("retain-period")})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open store: %s\n", err)
+			os.Exit(1)
+		}
+		store.KeepTagged = viper.GetBool("retain-tagged")
+		store.SweepWorkers = viper.GetInt("sweep-workers")
+		store.HashPool = NewHashPool(viper.GetInt("hash-workers"), viper.GetInt("hash-background-workers"))
+
+		if mode := viper.GetString("verify-on-start"); mode != "" {
+			if _, err := store.VerifyOnStart(mode); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to verify store: %s\n", err)
+				os.Exit(1)
+			}
+		}
+
+		var namespaces Namespaces
+		if path := viper.GetString("namespaces"); path != "" {
+			namespaces, err = LoadNamespaces(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load namespaces: %s\n", err)
+				os.Exit(2)
+			}
+			store.PrefixRetain = namespaces.RetainPolicies(store.Retain)
+		}
+
+		etag, err := ParseETagFormat(viper.GetString("etag"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		redirects, err := ParseRedirectAllowlist(viper.GetStringSlice("redirect-allow"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
 		s := Server{
-			Secret: sec,
-			Store: LocalStore{
-				viper.GetString("store"),
-				RetainPolicy{viper.GetInt("retain-num"), viper.GetDuration("retain-period")},
-			},
+			Secret:         sec,
+			Store:          store,
+			Expectations:   NewExpectationStore(),
+			Uploads:        NewUploadTracker(),
+			ReadOnly:       viper.GetBool("read-only"),
+			DirectLatest:   viper.GetStringSlice("direct-latest"),
+			LatestMaxAge:   viper.GetDuration("latest-max-age"),
+			Sites:          viper.GetStringSlice("site-mode"),
+			SiteFallback:   viper.GetString("site-fallback"),
+			SurrogateKeys:  viper.GetBool("surrogate-keys"),
+			StaleIfError:   viper.GetBool("stale-if-error"),
+			Private:        viper.GetStringSlice("private"),
+			Terraform:      viper.GetStringSlice("terraform-prefix"),
+			ETagFormat:     etag,
+			Redirects:      redirects,
+			Metrics:        NewMetrics(),
+			Idempotency:    NewIdempotencyStore(),
+			Transactions:   NewTransactionStore(viper.GetString("staging-dir")),
+			Namespaces:     namespaces,
+			HashPool:       store.HashPool,
+			ExchangeMaxTTL: viper.GetDuration("exchange-max-ttl"),
+		}
+
+		s.TerraformLocks, err = NewTerraformLockStore(filepath.Join(store.Path, TerraformLockDirName))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load Terraform locks: %s\n", err)
+			os.Exit(1)
+		}
+
+		if path := viper.GetString("acl"); path != "" {
+			s.ACL, err = LoadACLStore(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load ACL: %s\n", err)
+				os.Exit(2)
+			}
+		}
+
+		if path := viper.GetString("oidc"); path != "" {
+			s.OIDC, err = LoadOIDCAuthenticator(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load OIDC config: %s\n", err)
+				os.Exit(2)
+			}
+		}
+
+		if threshold := viper.GetInt("breaker-failures"); threshold > 0 {
+			s.Breaker = NewCircuitBreaker(threshold, viper.GetDuration("breaker-cooldown"), viper.GetDuration("breaker-slow"))
+			s.Store = BreakerStore{s.Store, s.Breaker}
+		} else if threshold < 0 {
+			fmt.Fprintln(os.Stderr, "--breaker-failures should be 0 or more.")
+			os.Exit(2)
+		}
+
+		if size, err := ParseSize(viper.GetString("cache-size")); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		} else if size > 0 {
+			s.Cache = NewArtifactCache(size)
+			s.Store = CachedStore{s.Store, s.Cache}
+		}
+		if s.StaleIfError && s.Cache == nil {
+			fmt.Fprintln(os.Stderr, "--stale-if-error requires --cache-size.")
+			os.Exit(2)
+		}
+
+		if prefixes := viper.GetStringSlice("grep-prefix"); len(prefixes) > 0 {
+			size, err := ParseSize(viper.GetString("grep-max-size"))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			s.TextIndex = NewTextIndex(prefixes, int(size))
+			go func() {
+				if err := s.TextIndex.Build(s.Store); err != nil {
+					PrintErr("ERROR", "failed to build full-text index: %s", err)
+				}
+			}()
+		}
+
+		webhookSecret := []byte(viper.GetString("webhook-secret"))
+
+		if u := viper.GetString("validation-webhook"); u != "" {
+			s.Validator = NewValidationWebhook(u, viper.GetInt("validation-webhook-bytes"))
+			s.Validator.Secret = webhookSecret
+		}
+
+		if path := viper.GetString("policy"); path != "" {
+			s.Policy, err = LoadPolicy(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load policy: %s\n", err)
+				os.Exit(2)
+			}
+		}
+
+		if path := viper.GetString("types"); path != "" {
+			types, err := LoadTypeMap(path)
+			if err == nil {
+				err = types.Register()
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load types: %s\n", err)
+				os.Exit(2)
+			}
+		}
+
+		if path := viper.GetString("headers"); path != "" {
+			s.Headers, err = LoadHeaderRules(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load headers: %s\n", err)
+				os.Exit(2)
+			}
+		}
+
+		s.Scanner, err = NewContentScanner(viper.GetString("scan-command"), viper.GetString("scan-clamd"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		if urls := viper.GetStringSlice("webhook"); len(urls) > 0 {
+			if len(webhookSecret) == 0 {
+				fmt.Fprintln(os.Stderr, "--webhook-secret is required for --webhook.")
+				os.Exit(2)
+			}
+			if viper.GetInt("webhook-max-attempts") < 1 {
+				fmt.Fprintln(os.Stderr, "--webhook-max-attempts should be 1 or more.")
+				os.Exit(2)
+			}
+			s.Webhooks, err = NewWebhooks(urls, webhookSecret, viper.GetInt("webhook-max-attempts"), viper.GetString("webhook-dead-letter"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load dead-letter log of webhooks: %s\n", err)
+				os.Exit(2)
+			}
+			s.Webhooks.Start()
+		}
+
+		if u := viper.GetString("purge-url"); u != "" {
+			if !s.SurrogateKeys {
+				fmt.Fprintln(os.Stderr, "--surrogate-keys is required for --purge-url, because purge requests are based on surrogate keys.")
+				os.Exit(2)
+			}
+			style, err := ParsePurgeStyle(viper.GetString("purge-style"))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			s.Purge, err = NewPurgeHook(u, style, viper.GetStringSlice("purge-header"))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		}
+
+		s.Replicators, err = startReplicators("REPLICATE", "replicate", s.Store)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		s.Mirrors, err = startReplicators("MIRROR", "mirror", s.Store)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		s.MirrorPercent = viper.GetFloat64("mirror-percent")
+		if s.MirrorPercent < 0 || s.MirrorPercent > 100 {
+			fmt.Fprintln(os.Stderr, "--mirror-percent should be between 0 and 100.")
+			os.Exit(2)
+		}
+
+		compress := CompressOptions{
+			ETag:          s.ETagFormat,
+			BrotliQuality: viper.GetInt("brotli-quality"),
+			ZstdLevel:     viper.GetInt("zstd-level"),
+		}
+		if compress.BrotliQuality > 11 {
+			fmt.Fprintln(os.Stderr, "--brotli-quality should be 11 or less.")
+			os.Exit(2)
+		}
+		if compress.ZstdLevel < 0 || compress.ZstdLevel > 22 {
+			fmt.Fprintln(os.Stderr, "--zstd-level should be between 0 and 22.")
+			os.Exit(2)
 		}
 
 		PrintLog("INFO", "Starting Artistore on %s", viper.GetString("listen"))
+		if s.ReadOnly {
+			PrintLog("INFO", "Read-only mode is enabled")
+		}
 
-		s.StartSweeper(5 * time.Minute)
-		http.ListenAndServe(viper.GetString("listen"), gziphandler.GzipHandler(s))
+		s.StartSweeper(viper.GetDuration("sweep-interval"))
+		s.StartSweepReporter(viper.GetDuration("sweep-report-interval"), viper.GetString("sweep-report-file"))
+		http.ListenAndServe(viper.GetString("listen"), CompressHandler(compress, s))
 	},
 }
 
@@ -56,19 +288,223 @@ func init() {
 	serveCmd.Flags().String("store", "/var/lib/artistore", "Path to data directory.")
 	viper.BindPFlag("store", serveCmd.Flags().Lookup("store"))
 
+	serveCmd.Flags().String("etag", string(ETagAuto), `ETag format of artifacts. "auto" uses weak ETag only for compressed responses, "strong" disables compression, and "weak" always uses weak ETag.`)
+	viper.BindPFlag("etag", serveCmd.Flags().Lookup("etag"))
+
+	serveCmd.Flags().Int("brotli-quality", 5, "Quality level of brotli compression for text artifacts, from 0 to 11. Set -1 to disable brotli.")
+	viper.BindPFlag("brotli-quality", serveCmd.Flags().Lookup("brotli-quality"))
+
+	serveCmd.Flags().Int("zstd-level", 3, "Compression level of zstd for text artifacts, from 1 to 22. Set 0 to disable zstd.")
+	viper.BindPFlag("zstd-level", serveCmd.Flags().Lookup("zstd-level"))
+
+	serveCmd.Flags().String("cache-size", "0", "Size of in-memory cache for hot artifacts, such as 512MB. Set 0 to disable cache.")
+	viper.BindPFlag("cache-size", serveCmd.Flags().Lookup("cache-size"))
+
+	serveCmd.Flags().Bool("stale-if-error", false, "Serve the cached copy of the requested revision with Warning header if the store fails, instead of 500. It requires --cache-size.")
+	viper.BindPFlag("stale-if-error", serveCmd.Flags().Lookup("stale-if-error"))
+
+	serveCmd.Flags().Int("breaker-failures", 0, "Number of consecutive store failures to stop calling the store for --breaker-cooldown. While stopped, reads are served from cache or redirected to --replicate target, and writes are responded 503. Set 0 to disable.")
+	viper.BindPFlag("breaker-failures", serveCmd.Flags().Lookup("breaker-failures"))
+
+	serveCmd.Flags().Duration("breaker-cooldown", 30*time.Second, "Duration to stop calling the store after --breaker-failures failures.")
+	viper.BindPFlag("breaker-cooldown", serveCmd.Flags().Lookup("breaker-cooldown"))
+
+	serveCmd.Flags().Duration("breaker-slow", 0, "Count store operations slower than this as failures, such as 5s. Set 0 to disable.")
+	viper.BindPFlag("breaker-slow", serveCmd.Flags().Lookup("breaker-slow"))
+
 	serveCmd.Flags().Int("retain-num", 0, "Number of to retain old revisions. (default retain all)")
 	viper.BindPFlag("retain-num", serveCmd.Flags().Lookup("retain-num"))
 
 	serveCmd.Flags().Duration("retain-period", 0, "Period of to retain old revisions. (default retain forever)")
 	viper.BindPFlag("retain-period", serveCmd.Flags().Lookup("retain-period"))
+
+	serveCmd.Flags().Bool("retain-tagged", true, "Keep revisions that have tags regardless of --retain-num and --retain-period.")
+	viper.BindPFlag("retain-tagged", serveCmd.Flags().Lookup("retain-tagged"))
+
+	serveCmd.Flags().String("verify-on-start", "", `Check the data directory before accepting requests, and quarantine corrupted revisions. "quick" checks the structure and headers of files, and "full" also checks digests of all contents. (default no check, "quick" if no value)`)
+	serveCmd.Flags().Lookup("verify-on-start").NoOptDefVal = VerifyQuick
+	viper.BindPFlag("verify-on-start", serveCmd.Flags().Lookup("verify-on-start"))
+
+	serveCmd.Flags().Duration("sweep-interval", 5*time.Minute, "Interval to sweep old revisions. Set 0 to disable periodic sweep.")
+	viper.BindPFlag("sweep-interval", serveCmd.Flags().Lookup("sweep-interval"))
+
+	serveCmd.Flags().Int("sweep-workers", 4, "Number of keys to sweep concurrently.")
+	viper.BindPFlag("sweep-workers", serveCmd.Flags().Lookup("sweep-workers"))
+
+	serveCmd.Flags().Int("hash-workers", runtime.NumCPU(), "Maximum number of hash computations for verification at once.")
+	viper.BindPFlag("hash-workers", serveCmd.Flags().Lookup("hash-workers"))
+
+	serveCmd.Flags().Int("hash-background-workers", 1, "Maximum number of --hash-workers that background integrity checks such as fsck can use. Requests are always prioritized over them.")
+	viper.BindPFlag("hash-background-workers", serveCmd.Flags().Lookup("hash-background-workers"))
+
+	serveCmd.Flags().Duration("sweep-report-interval", 0, "Interval to log revisions that would be removed by retention, without removing them. It works even while retention is paused. Set 0 to disable.")
+	viper.BindPFlag("sweep-report-interval", serveCmd.Flags().Lookup("sweep-report-interval"))
+
+	serveCmd.Flags().String("sweep-report-file", "", "Path to write the latest report of --sweep-report-interval in JSON.")
+	viper.BindPFlag("sweep-report-file", serveCmd.Flags().Lookup("sweep-report-file"))
+
+	serveCmd.Flags().String("validation-webhook", "", "URL to validate artifacts before publish. 4xx response from it rejects the publish.")
+	viper.BindPFlag("validation-webhook", serveCmd.Flags().Lookup("validation-webhook"))
+
+	serveCmd.Flags().Int("validation-webhook-bytes", 1024, "Number of bytes of the artifact head to send to the validation webhook.")
+	viper.BindPFlag("validation-webhook-bytes", serveCmd.Flags().Lookup("validation-webhook-bytes"))
+
+	serveCmd.Flags().String("policy", "", "Path to upload policy in YAML, that limits size, content type, key pattern, and required labels per prefix.")
+	viper.BindPFlag("policy", serveCmd.Flags().Lookup("policy"))
+
+	serveCmd.Flags().String("types", "", "Path to a mapping from file extensions to content types in YAML, that is used to detect the type on publish before sniffing the content.")
+	viper.BindPFlag("types", serveCmd.Flags().Lookup("types"))
+
+	serveCmd.Flags().String("headers", "", "Path to extra response headers in YAML, such as Cache-Control or Content-Security-Policy per prefix and content type.")
+	viper.BindPFlag("headers", serveCmd.Flags().Lookup("headers"))
+
+	serveCmd.Flags().String("scan-command", "", "Shell command to scan each upload before publish, such as \"clamdscan --no-summary -\". The content is given by stdin, and exit status 1 rejects the upload with 422.")
+	viper.BindPFlag("scan-command", serveCmd.Flags().Lookup("scan-command"))
+
+	serveCmd.Flags().String("scan-clamd", "", "Address of clamd to scan each upload before publish, such as tcp://localhost:3310 or unix:///run/clamav/clamd.ctl.")
+	viper.BindPFlag("scan-clamd", serveCmd.Flags().Lookup("scan-clamd"))
+
+	serveCmd.Flags().StringSlice("webhook", nil, "URL to notify publish and delete events. Events are retried with exponential backoff until delivered.")
+	viper.BindPFlag("webhook", serveCmd.Flags().Lookup("webhook"))
+
+	serveCmd.Flags().String("webhook-secret", "", "Secret to sign requests to webhooks with HMAC-SHA256 in "+WebhookSignatureHeader+" header.")
+	viper.BindPFlag("webhook-secret", serveCmd.Flags().Lookup("webhook-secret"))
+
+	serveCmd.Flags().Int("webhook-max-attempts", 8, "Number of attempts to deliver an event before recording it in the dead-letter log.")
+	viper.BindPFlag("webhook-max-attempts", serveCmd.Flags().Lookup("webhook-max-attempts"))
+
+	serveCmd.Flags().String("webhook-dead-letter", "", "Path to the dead-letter log of webhooks in JSON Lines. Failed deliveries can be replayed by the admin API. (default keep on memory)")
+	viper.BindPFlag("webhook-dead-letter", serveCmd.Flags().Lookup("webhook-dead-letter"))
+
+	serveCmd.Flags().StringSlice("replicate-to", nil, "URL for downstream Artistore servers to replicate published artifacts.")
+	viper.BindPFlag("replicate-to", serveCmd.Flags().Lookup("replicate-to"))
+
+	serveCmd.Flags().String("replicate-token", "", "Client token for the downstream servers.")
+	viper.BindPFlag("replicate-token", serveCmd.Flags().Lookup("replicate-token"))
+
+	serveCmd.Flags().String("replicate-secret", "", "Server secret of the downstream servers. It is used to generate token when --replicate-token is not set.")
+	viper.BindPFlag("replicate-secret", serveCmd.Flags().Lookup("replicate-secret"))
+
+	serveCmd.Flags().String("replicate-queue-dir", "", "Directory to keep the replication queue, so that pending replications survive restart. (default keep on memory)")
+	viper.BindPFlag("replicate-queue-dir", serveCmd.Flags().Lookup("replicate-queue-dir"))
+
+	serveCmd.Flags().StringSlice("mirror-to", nil, "URL for staging Artistore servers to mirror a part of published artifacts.")
+	viper.BindPFlag("mirror-to", serveCmd.Flags().Lookup("mirror-to"))
+
+	serveCmd.Flags().Float64("mirror-percent", 10, "Percentage of publishes to mirror.")
+	viper.BindPFlag("mirror-percent", serveCmd.Flags().Lookup("mirror-percent"))
+
+	serveCmd.Flags().String("mirror-token", "", "Client token for the staging servers.")
+	viper.BindPFlag("mirror-token", serveCmd.Flags().Lookup("mirror-token"))
+
+	serveCmd.Flags().String("mirror-secret", "", "Server secret of the staging servers. It is used to generate token when --mirror-token is not set.")
+	viper.BindPFlag("mirror-secret", serveCmd.Flags().Lookup("mirror-secret"))
+
+	serveCmd.Flags().Bool("read-only", false, "Reject publish and any other modification.")
+	viper.BindPFlag("read-only", serveCmd.Flags().Lookup("read-only"))
+
+	serveCmd.Flags().StringSlice("direct-latest", nil, "Key prefixes to serve the latest revision directly instead of redirect. Use * to apply for all keys.")
+	viper.BindPFlag("direct-latest", serveCmd.Flags().Lookup("direct-latest"))
+
+	serveCmd.Flags().StringSlice("site-mode", nil, "Key prefixes to serve as static websites: the latest revision is served directly, and \"GET /PREFIX/\" serves PREFIX/index.html. Use * to apply for all keys.")
+	viper.BindPFlag("site-mode", serveCmd.Flags().Lookup("site-mode"))
+
+	serveCmd.Flags().String("site-fallback", "", "File in the --site-mode prefix to serve for unknown keys, such as index.html for single page applications.")
+	viper.BindPFlag("site-fallback", serveCmd.Flags().Lookup("site-fallback"))
+
+	serveCmd.Flags().Duration("latest-max-age", 10*time.Second, "How long clients and CDNs can cache the redirect to the latest revision. Set 0 to make them revalidate every time.")
+	viper.BindPFlag("latest-max-age", serveCmd.Flags().Lookup("latest-max-age"))
+
+	serveCmd.Flags().Bool("surrogate-keys", false, "Send Surrogate-Key and Cache-Tag headers for CDNs that support tag-based purge. Tags are key:KEY, rev:KEY#REV, and prefix:PREFIX/ for each parent directory.")
+	viper.BindPFlag("surrogate-keys", serveCmd.Flags().Lookup("surrogate-keys"))
+
+	serveCmd.Flags().String("purge-url", "", "URL to purge CDN cache by surrogate keys when a new revision is published or deleted. Requires --surrogate-keys.")
+	viper.BindPFlag("purge-url", serveCmd.Flags().Lookup("purge-url"))
+
+	serveCmd.Flags().String("purge-style", string(PurgeJSON), "Request format of --purge-url: fastly, cloudflare, or json.")
+	viper.BindPFlag("purge-style", serveCmd.Flags().Lookup("purge-style"))
+
+	serveCmd.Flags().StringSlice("purge-header", nil, `Header to send to --purge-url, such as "Fastly-Key: TOKEN" or "Authorization: Bearer TOKEN".`)
+	viper.BindPFlag("purge-header", serveCmd.Flags().Lookup("purge-header"))
+
+	serveCmd.Flags().StringSlice("private", nil, "Key prefixes that require token with read scope to get. Use * to apply for all keys.")
+	viper.BindPFlag("private", serveCmd.Flags().Lookup("private"))
+
+	serveCmd.Flags().StringSlice("terraform-prefix", nil, "Key prefixes to serve as the HTTP backend of Terraform, that supports LOCK and UNLOCK methods. Locks are kept in the store, so that they survive restart. States under the prefixes require token with read scope to get.")
+	viper.BindPFlag("terraform-prefix", serveCmd.Flags().Lookup("terraform-prefix"))
+
+	serveCmd.Flags().StringSlice("redirect-allow", nil, "URL prefixes that redirect artifacts can point to, such as https://cdn.example.com/assets/. Redirect artifacts are rejected if not set.")
+	viper.BindPFlag("redirect-allow", serveCmd.Flags().Lookup("redirect-allow"))
+
+	serveCmd.Flags().StringSlice("grep-prefix", nil, "Prefixes of text artifacts to index for the grep API. Full-text index is disabled if not set.")
+	viper.BindPFlag("grep-prefix", serveCmd.Flags().Lookup("grep-prefix"))
+
+	serveCmd.Flags().String("grep-max-size", "1MB", "Maximum size of text artifacts to index for the grep API.")
+	viper.BindPFlag("grep-max-size", serveCmd.Flags().Lookup("grep-max-size"))
+
+	serveCmd.Flags().String("staging-dir", "", "Directory to keep artifacts uploaded into transactions until commit. The system temporary directory is used if empty.")
+	viper.BindPFlag("staging-dir", serveCmd.Flags().Lookup("staging-dir"))
+
+	serveCmd.Flags().String("acl", "", "Path to access control list in YAML. It is updated by 'artistore acl import'.")
+	viper.BindPFlag("acl", serveCmd.Flags().Lookup("acl"))
+
+	serveCmd.Flags().String("id-format", "ulid", "Format of IDs for requests, upload sessions, transactions, and webhook events. ulid or uuidv7.")
+	viper.BindPFlag("id-format", serveCmd.Flags().Lookup("id-format"))
+
+	serveCmd.Flags().String("namespaces", "", "Path to namespaces in YAML. Each namespace is a prefix that has its own secret, retention policy, and quota.")
+	viper.BindPFlag("namespaces", serveCmd.Flags().Lookup("namespaces"))
+
+	serveCmd.Flags().Duration("exchange-max-ttl", time.Hour, "Maximum lifetime of read-only tokens issued by token exchange. Set 0 for no limit.")
+	viper.BindPFlag("exchange-max-ttl", serveCmd.Flags().Lookup("exchange-max-ttl"))
+
+	serveCmd.Flags().String("oidc", "", "Path to OIDC config in YAML, to accept identity tokens of CI services such as GitHub Actions. See also 'artistore help token'.")
+	viper.BindPFlag("oidc", serveCmd.Flags().Lookup("oidc"))
 }
 
 type Server struct {
-	Secret Secret
-	Store  Store
+	Secret         Secret
+	Store          Store
+	Validator      *ValidationWebhook
+	Scanner        ContentScanner
+	Policy         *Policy
+	Replicators    []*Replicator
+	Mirrors        []*Replicator
+	MirrorPercent  float64
+	Expectations   *ExpectationStore
+	Uploads        *UploadTracker
+	ReadOnly       bool
+	DirectLatest   []string
+	LatestMaxAge   time.Duration
+	Headers        *HeaderRules
+	Sites          []string
+	SiteFallback   string
+	SurrogateKeys  bool
+	Purge          *PurgeHook
+	StaleIfError   bool
+	Private        []string
+	Terraform      []string
+	ACL            *ACLStore
+	ETagFormat     ETagFormat
+	Cache          *ArtifactCache
+	Breaker        *CircuitBreaker
+	Redirects      RedirectAllowlist
+	Metrics        *Metrics
+	Idempotency    *IdempotencyStore
+	Transactions   *TransactionStore
+	TerraformLocks *TerraformLockStore
+	TextIndex      *TextIndex
+	OIDC           *OIDCAuthenticator
+	Webhooks       *Webhooks
+	Namespaces     Namespaces
+	HashPool       *HashPool
+	ExchangeMaxTTL time.Duration
 }
 
 func (s Server) StartSweeper(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
 	go func() {
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
@@ -76,7 +512,16 @@ func (s Server) StartSweeper(interval time.Duration) {
 		for {
 			select {
 			case <-ticker.C:
-				go s.Store.Sweep()
+				go func() {
+					report, err := s.Store.Sweep(SweepOptions{})
+					if err == nil {
+						notifySweep(s.Store, report)
+					} else if err == ErrSweepRunning {
+						PrintWarn("SWEEP", "previous sweep is still running. skip this time")
+					} else if err != nil && err != ErrRetentionPaused {
+						PrintErr("ERROR", "failed to sweep: %s", err)
+					}
+				}()
 			}
 		}
 	}()
@@ -103,9 +548,11 @@ func (w HeadWriter) WriteHeader(code int) {
 }
 
 func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	PrintLog(r.Method, "%s %s", r.RequestURI, r.RemoteAddr)
+	requestID := NewID()
+	PrintLog(r.Method, "%s %s %s", r.RequestURI, r.RemoteAddr, requestID)
 
 	w.Header().Set("Server", "Artistore")
+	w.Header().Set("X-Request-Id", requestID)
 
 	key := strings.TrimLeft(r.URL.Path, "/")
 	if key == "" {
@@ -114,6 +561,27 @@ func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.ReadOnly && r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, "This server is read-only.")
+		return
+	}
+
+	if strings.HasPrefix(key, APIPrefix) {
+		s.ServeAPI(key, w, r)
+		return
+	}
+
+	if HasAnyPrefix(key, s.Terraform) {
+		s.ServeTerraform(key, w, r)
+		return
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS" && s.Breaker.State() == CircuitOpen {
+		s.unavailable(w)
+		return
+	}
+
 	switch r.Method {
 	case "GET":
 		s.Get(key, w, r)
@@ -121,6 +589,10 @@ func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		s.Post(key, w, r)
 	case "HEAD":
 		s.Get(key, HeadWriter{w}, r)
+	case "PATCH":
+		s.Patch(key, w, r)
+	case "DELETE":
+		s.Delete(key, w, r)
 	case "OPTIONS":
 		s.Options(key, w, r)
 	default:
@@ -130,104 +602,569 @@ func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s Server) Get(key string, w http.ResponseWriter, r *http.Request) {
-	if r.URL.Query().Has("rev") {
-		rev, err := strconv.Atoi(r.URL.Query().Get("rev"))
-		if err != nil || rev < 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintln(w, "Invalid revision.")
-			return
-		}
+	if !s.authorizeRead(key, w, r) {
+		return
+	}
 
-		meta, err := s.Store.Metadata(key, rev)
-		if err == ErrNoSuchArtifact {
-			w.WriteHeader(http.StatusNotFound)
-			fmt.Fprintln(w, err)
-			return
-		} else if err == ErrRevisionDeleted {
-			w.WriteHeader(http.StatusGone)
-			fmt.Fprintln(w, err)
-			return
-		} else if err != nil {
-			PrintErr("ERROR", "%s", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintln(w, InternalServerErrorMessage)
+	trace, ok := s.debugTrace(w, r)
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Has("archive") {
+		s.servePrefixArchive(key, w, r)
+		return
+	}
+
+	if r.URL.Query().Has("diff") {
+		s.serveDiff(key, w, r)
+		return
+	}
+
+	if !r.URL.Query().Has("rev") {
+		root, ok := s.siteRoot(key)
+		if !ok {
+			// "GET /PREFIX" is redirected to "GET /PREFIX/" to serve the index.
+			root, ok = s.siteRoot(key + "/")
+		}
+		if ok {
+			s.serveSite(root, key, trace, w, r)
 			return
 		}
 
-		w.Header().Set("Content-Type", meta.Type)
-
-		f, meta, err := s.Store.Get(key, rev)
-		if err != nil {
-			PrintErr("ERROR", "%s", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintln(w, InternalServerErrorMessage)
+		if strings.HasSuffix(key, "/") {
+			s.serveDirectoryIndex(key, w, r)
 			return
 		}
-		defer f.Close()
+	}
 
-		w.Header().Set("Etag", `"`+meta.Hash+`"`)
-		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	direct := r.URL.Query().Get("rev") == "latest" || strings.EqualFold(r.Header.Get(ResolveHeader), "direct")
 
-		if _, ok := w.(HeadWriter); ok {
+	if r.URL.Query().Has("rev") && r.URL.Query().Get("rev") != "latest" {
+		rev, err := strconv.Atoi(r.URL.Query().Get("rev"))
+		if err != nil || rev < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid revision.")
 			return
 		}
 
-		http.ServeContent(w, r, meta.Key, meta.Timestamp, f)
+		trace.Printf("revision %d is specified; cacheable as immutable", rev)
+		s.serveRevision(key, rev, true, trace, w, r)
 	} else {
-		rev, err := s.Store.Latest(key)
-		if err == ErrNoSuchArtifact {
+		// The response to the same URL depends on the header.
+		w.Header().Add("Vary", ResolveHeader)
+
+		rev, err := s.latest(key)
+		if (err == ErrNoSuchArtifact || err == nil && rev == 0) && s.redirectMoved(key, 0, trace, w, r) {
+			return
+		} else if err == ErrNoSuchArtifact {
+			s.setSurrogateKeys(w, key, 0)
 			w.WriteHeader(http.StatusNotFound)
 			fmt.Fprintln(w, err)
+		} else if err == ErrCircuitOpen {
+			if !s.replicaFallback(key, w, r) {
+				s.unavailable(w)
+			}
 		} else if err != nil {
 			PrintErr("ERROR", "%s", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintln(w, InternalServerErrorMessage)
+		} else if direct {
+			trace.Printf("latest revision is %d; served directly because it is requested by rev=latest or %s header, so client have to revalidate", rev, ResolveHeader)
+			w.Header().Set("Content-Location", s.pathTo(key, rev))
+			s.serveRevision(key, rev, false, trace, w, r)
+		} else if HasAnyPrefix(key, s.DirectLatest) {
+			trace.Printf("latest revision is %d; served directly because the key matches --direct-latest, so client have to revalidate", rev)
+			w.Header().Set("Content-Location", s.pathTo(key, rev))
+			s.serveRevision(key, rev, false, trace, w, r)
 		} else {
+			trace.Printf("latest revision is %d; redirect because the key does not match --direct-latest", rev)
+			s.setSurrogateKeys(w, key, 0)
 			path := s.pathTo(key, rev)
+			if trace.Enabled() {
+				path += "&debug=1"
+			}
 			w.Header().Set("Location", path)
+
+			// The redirect can be cached for a short time, and revalidated by the ETag of the target revision.
+			visibility := "public"
+			if s.isPrivate(key) {
+				visibility = "private"
+			}
+			if s.LatestMaxAge > 0 {
+				w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, int(s.LatestMaxAge.Seconds())))
+			} else {
+				w.Header().Set("Cache-Control", visibility+", no-cache")
+			}
+			if meta, err := s.Store.Metadata(key, rev); err == nil {
+				etag := s.ETagFormat.ETag(meta.Hash)
+				w.Header().Set("Etag", etag)
+				if _, ok := matchETag(r.Header.Get("If-None-Match"), etag, weakETagMatch); ok {
+					trace.Printf("If-None-Match matches to the ETag of revision %d; 304 Not Modified", rev)
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+
 			w.WriteHeader(http.StatusSeeOther)
 			fmt.Fprintln(w, "http://"+r.Host+path)
 		}
 	}
 }
 
-func (s Server) Post(key string, w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
+// serveRevision sends an artifact to the client.
+// The response can be cached forever if immutable is true, otherwise client have to revalidate it every time.
+func (s Server) serveRevision(key string, rev int, immutable bool, trace *Trace, w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	s.setSurrogateKeys(w, key, rev)
+
+	meta, err := s.Store.Metadata(key, rev)
+	if (err == ErrNoSuchArtifact || err == ErrRevisionDeleted) && s.redirectMoved(key, rev, trace, w, r) {
+		return
+	} else if err == ErrNoSuchArtifact {
+		trace.Printf("revision %d is not found; 404 Not Found", rev)
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, err)
+		return
+	} else if err == ErrRevisionDeleted {
+		trace.Printf("revision %d is older than latest but not found; 410 Gone", rev)
+		w.WriteHeader(http.StatusGone)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	// HEAD requests are answered by the metadata only, unless the headers depend on the content.
+	_, head := w.(HeadWriter)
+	needsBody := !head || meta.Type == RedirectType || r.URL.Query().Has("entry")
+
+	var f io.ReadSeekCloser
+	if err == nil && needsBody {
+		f, meta, err = s.Store.Get(key, rev)
+
+		// The revision can disappear after reading metadata, such as swept or quarantined because it was corrupted.
+		if err == ErrNoSuchArtifact || err == ErrRevisionDeleted {
+			trace.Printf("revision %d disappeared while reading: %s", rev, err)
+			status := http.StatusNotFound
+			if err == ErrRevisionDeleted {
+				status = http.StatusGone
+			}
+			w.WriteHeader(status)
+			fmt.Fprintln(w, err)
+			return
+		}
+	}
+	if err != nil {
+		var ok bool
+		f, meta, ok = s.staleCopy(key, rev, err, trace)
+		if !ok && err == ErrCircuitOpen {
+			s.unavailable(w)
+			return
+		} else if !ok {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+		w.Header().Set("Warning", `110 Artistore "Response is stale"`)
+	}
+	if f != nil {
+		defer f.Close()
+	}
+
+	w.Header().Set("Content-Type", meta.Type)
 
+	etag := s.ETagFormat.ETag(meta.Hash)
+	w.Header().Set("Etag", etag)
+	w.Header().Set("X-Artistore-Revision", strconv.Itoa(meta.Revision))
+	// Shared caches must not serve private artifacts to clients without token.
+	visibility := "public"
+	if s.isPrivate(key) {
+		visibility = "private"
+	}
+	if immutable {
+		w.Header().Set("Cache-Control", visibility+", max-age=31536000, immutable")
+	} else if _, ok := s.siteRoot(key); ok {
+		w.Header().Set("Cache-Control", s.siteCacheControl(visibility, meta))
+	} else {
+		w.Header().Set("Cache-Control", visibility+", no-cache")
+	}
+
+	s.Headers.Apply(w.Header(), key, meta.Type)
+
+	if meta.Type == RedirectType {
+		s.serveRedirect(key, rev, f, trace, w)
+		return
+	}
+
+	if r.URL.Query().Has("entry") {
+		s.serveEntry(meta, f, trace, w, r)
+		return
+	}
+
+	trace.Conditional(r, etag, meta.Timestamp)
+
+	if head {
+		// Clients such as get --parallel need the size and range support to plan Range requests.
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(meta.Size))
+		return
+	}
+
+	fixRangeHeader(r, int64(meta.Size))
+
+	rec := &transferRecorder{ResponseWriter: w}
+	http.ServeContent(rec, r, meta.Key, meta.Timestamp, f)
+	if (rec.status == http.StatusOK || rec.status == http.StatusPartialContent) && rec.written > 0 {
+		s.Metrics.ObserveTransfer(key, meta.Size, rec.firstByte.Sub(start), time.Since(rec.firstByte), rec.written)
+	}
+	s.Metrics.ObserveEgress(s.tokenLabel(key, r), key, rec.written)
+}
+
+func (s Server) authorize(key string, scope Scope, w http.ResponseWriter, r *http.Request) bool {
 	auth := r.Header.Get("Authorization")
 	if auth == "" {
 		w.WriteHeader(http.StatusForbidden)
-		fmt.Fprintln(w, "Authorization header is required to publish artifact.")
-		return
+		fmt.Fprintln(w, "Authorization header is required.")
+		return false
 	} else if !strings.HasPrefix(auth, "bearer ") {
 		w.WriteHeader(http.StatusForbidden)
 		fmt.Fprintln(w, "Authorization type should be bearer.")
-		return
-	} else if token, err := ParseToken(strings.TrimSpace(auth[len("bearer "):])); err != nil || !IsCorrentToken(s.Secret, token, key) {
-		PrintWarn("FORBIDDEN", "%s %s", key, r.RemoteAddr)
+		return false
+	} else if token, err := ParseToken(strings.TrimSpace(auth[len("bearer "):])); err == nil && token.Version() == 0 {
+		return s.authorizeOIDC(token, key, scope, w, r)
+	} else if err != nil || !IsCorrentToken(s.secretFor(key), token, key) {
+		PrintWarn("FORBIDDEN", "%s %s %s", scope, key, r.RemoteAddr)
 		w.WriteHeader(http.StatusForbidden)
 		fmt.Fprintln(w, "Invalid authorization token.")
+		return false
+	} else if !token.Scope().Has(scope) {
+		PrintWarn("FORBIDDEN", "%s %s %s: token has only %s scope", scope, key, r.RemoteAddr, token.Scope())
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "This token is not allowed to %s.\n", scope)
+		return false
+	} else if err := s.ACL.Get().Allow(token, key, scope); err != nil {
+		PrintWarn("FORBIDDEN", "%s %s %s: %s", scope, key, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, err)
+		return false
+	}
+	return true
+}
+
+// isPrivate reports whether the key requires token with read scope to get.
+func (s Server) isPrivate(key string) bool {
+	return HasAnyPrefix(key, s.Private) || HasAnyPrefix(key, s.Terraform)
+}
+
+// authorizeRead checks the read scope of the token if the key is private.
+// Other keys can be read without token.
+func (s Server) authorizeRead(key string, w http.ResponseWriter, r *http.Request) bool {
+	if !s.isPrivate(key) {
+		return true
+	}
+	return s.authorize(key, ScopeRead, w, r)
+}
+
+func (s Server) Post(key string, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if r.URL.Query().Has("extract") {
+		s.extractArchive(key, w, r)
+		return
+	}
+
+	if r.URL.Query().Has("copy-from") {
+		s.copyRevision(key, w, r)
+		return
+	}
+
+	if r.URL.Query().Has("move-from") {
+		s.moveKey(key, w, r)
+		return
+	}
+
+	if !s.authorize(key, ScopePublish, w, r) {
+		return
+	}
+
+	labels, err := parseLabelHeaders(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
 		return
 	}
+	if !s.checkPolicy(key, labels, r.ContentLength, w, r) {
+		return
+	}
+
+	idempotency := r.Header.Get("Idempotency-Key")
+	if rev, err := s.Idempotency.Begin(key, idempotency); err == ErrIdempotencyInProgress {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(w, err)
+		return
+	} else if rev > 0 {
+		PrintLog("PUBLISH", "%s#%d is already published with the same Idempotency-Key", key, rev)
+		w.Header().Set("Location", s.pathTo(key, rev))
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintln(w, "http://"+r.Host+s.pathTo(key, rev))
+		return
+	}
+	var published int
+	defer func() {
+		s.Idempotency.Finish(key, idempotency, published)
+	}()
+
+	precondition, ok := s.checkPublishPrecondition(key, w, r)
+	if !ok {
+		return
+	}
+
+	upload := s.Uploads.Start(uploadID(r.Header.Get("Idempotency-Key")), key, r.ContentLength)
+	w.Header().Set("X-Artistore-Upload-Id", upload.ID)
+
+	defer func() {
+		s.Uploads.Finish(upload, err)
+		s.Metrics.ObserveIngress(s.tokenLabel(key, r), key, upload.Status().Received)
+	}()
+
+	r.Body = upload.Body(r.Body)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), DeltaType) {
+		if r.URL.Query().Has("append") {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Delta can not be appended.")
+			return
+		}
+
+		// Validators and scanners see the applied content instead of the delta.
+		applied, base, ok := s.deltaBase(key, w, r)
+		if !ok {
+			return
+		}
+		defer base.Close()
+		r.Body = io.NopCloser(applied)
+		r.ContentLength = -1
+	}
+
+	var body io.Reader = r.Body
+	if s.Validator != nil {
+		body, err = s.Validator.Validate(key, r)
+		var verr ValidationError
+		if errors.As(err, &verr) {
+			PrintWarn("REJECT", "%s %s: %s", key, r.RemoteAddr, verr.Message)
+			w.WriteHeader(verr.Status)
+			fmt.Fprintln(w, verr.Message)
+			return
+		} else if err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+	}
 
-	rev, err := s.Store.Put(key, r.Body)
+	body, finishScan, err := s.scanBody(key, body)
 	if err != nil {
 		PrintErr("ERROR", "%s", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintln(w, InternalServerErrorMessage)
 		return
 	}
+	defer finishScan()
+
+	var typ string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), RedirectType) {
+		var target string
+		target, err = s.readRedirect(body)
+		if err == ErrRedirectNotAllowed {
+			PrintWarn("REJECT", "%s %s: %s", key, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(w, err)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
+			return
+		}
+		body = strings.NewReader(target)
+		typ = RedirectType
+	}
+
+	if r.URL.Query().Has("append") {
+		if typ == RedirectType {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, ErrAppendRedirect)
+			return
+		}
+
+		// The uploaded delta is validated and scanned alone, because the previous content has already been checked when it was published.
+		var base io.ReadSeekCloser
+		var baseMeta Metadata
+		base, baseMeta, precondition, ok = s.appendBase(key, precondition, w, r)
+		if !ok {
+			return
+		}
+		if base != nil {
+			defer base.Close()
+			body = io.MultiReader(base, body)
+			typ = baseMeta.Type
+		}
+	}
+
+	expect, expected := s.Expectations.Get(key)
+	acl := s.ACL.Get()
+	var warnings []string
+	var putMeta Metadata
+	opts := PutOptions{
+		Type:   typ,
+		Labels: labels,
+		Verify: func(meta Metadata) (err error) {
+			putMeta = meta
+			if err := precondition.Check(meta.Revision); err != nil {
+				return err
+			}
+			if expected && !expect.Match(meta) {
+				return ErrDigestMismatch
+			}
+			if err := s.Policy.CheckContent(meta); err != nil {
+				return err
+			}
+			warnings, err = s.checkQuota(acl, key, meta.Size)
+			return err
+		},
+	}
+
+	rev, err := s.Store.Put(key, body, opts)
+	var detection ScanDetection
+	var verr ValidationError
+	if errors.As(err, &detection) {
+		s.rejectInfected(key, detection, w, r)
+		return
+	} else if errors.As(err, &verr) {
+		PrintWarn("REJECT", "%s %s: %s", key, r.RemoteAddr, verr.Message)
+		w.WriteHeader(verr.Status)
+		fmt.Fprintln(w, verr.Message)
+		return
+	} else if errors.Is(err, ErrInvalidDelta) {
+		PrintWarn("DELTA", "%s %s: %s", key, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	} else if err == ErrPreconditionFailed {
+		PrintWarn("PRECONDITION", "%s %s: %s", key, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		fmt.Fprintln(w, err)
+		return
+	} else if err == ErrDigestMismatch {
+		PrintWarn("MISMATCH", "%s %s", key, r.RemoteAddr)
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(w, err)
+		return
+	} else if errors.Is(err, ErrQuotaExceeded) {
+		PrintWarn("QUOTA", "%s %s: %s", key, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusInsufficientStorage)
+		fmt.Fprintln(w, err)
+		return
+	} else if err == ErrCircuitOpen {
+		s.unavailable(w)
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
 
 	PrintImportant("PUBLISH", "%s#%d", key, rev)
+	upload.SetRevision(rev)
+	putMeta.Revision = rev
+	s.afterPublish(putMeta)
+	published = rev
+
+	for _, msg := range warnings {
+		PrintWarn("QUOTA", "%s#%d %s: %s", key, rev, r.RemoteAddr, msg)
+		w.Header().Add("Warning", "199 Artistore "+strconv.Quote(msg))
+	}
+
+	if expected {
+		s.Expectations.Fulfill(key, expect)
+	}
 
 	w.Header().Set("Location", s.pathTo(key, rev))
 	w.WriteHeader(http.StatusCreated)
 	fmt.Fprintln(w, "http://"+r.Host+s.pathTo(key, rev))
 }
 
+// afterPublish runs hooks, indexing, and replications of a newly published revision.
+func (s Server) afterPublish(meta Metadata) {
+	notifyPut(s.Store, meta.Key, meta.Revision)
+	s.indexArchive(meta)
+	if err := s.TextIndex.index(s.Store, meta); err != nil {
+		PrintWarn("GREP", "%s#%d: %s", meta.Key, meta.Revision, err)
+	}
+
+	for _, r := range s.Replicators {
+		r.Enqueue(meta.Key, meta.Revision)
+	}
+	s.Webhooks.Send("publish", meta)
+	s.Purge.Published(meta.Key, meta.Revision)
+	if len(s.Mirrors) > 0 && rand.Float64()*100 < s.MirrorPercent {
+		for _, r := range s.Mirrors {
+			r.Enqueue(meta.Key, meta.Revision)
+		}
+	}
+}
+
+func (s Server) Delete(key string, w http.ResponseWriter, r *http.Request) {
+	if !r.URL.Query().Has("rev") {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Revision is required to delete.")
+		return
+	}
+
+	rev, err := strconv.Atoi(r.URL.Query().Get("rev"))
+	if err != nil || rev < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Invalid revision.")
+		return
+	}
+
+	if !s.authorize(key, ScopeDelete, w, r) {
+		return
+	}
+
+	err = s.Store.Delete(key, rev)
+	switch err {
+	case nil:
+		PrintImportant("DELETE", "%s#%d %s", key, rev, r.RemoteAddr)
+		s.Webhooks.Send("delete", Metadata{Key: key, Revision: rev})
+		s.Purge.Deleted(key, rev)
+		w.WriteHeader(http.StatusNoContent)
+	case ErrNoSuchArtifact:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, err)
+	case ErrRevisionDeleted:
+		w.WriteHeader(http.StatusGone)
+		fmt.Fprintln(w, err)
+	case ErrDeleteLatest, ErrRetentionPaused:
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(w, err)
+	case ErrCircuitOpen:
+		s.unavailable(w)
+	default:
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+	}
+}
+
 func (s Server) Options(key string, w http.ResponseWriter, r *http.Request) {
-	if r.URL.Query().Has("rev") {
+	if s.ReadOnly {
 		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+	} else if r.URL.Query().Has("rev") {
+		w.Header().Set("Allow", "GET, HEAD, PATCH, DELETE, OPTIONS")
 	} else {
 		w.Header().Set("Allow", "GET, POST, HEAD, OPTIONS")
 	}
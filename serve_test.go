@@ -0,0 +1,209 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetLatestDirect(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	for _, body := range []string{"first", "second"} {
+		if _, err := store.Put("hello.txt", strings.NewReader(body), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	s := Server{Store: store}
+
+	tests := []struct {
+		Path    string
+		Resolve string
+		Status  int
+		Body    string
+	}{
+		{"/hello.txt", "", http.StatusSeeOther, ""},
+		{"/hello.txt?rev=latest", "", http.StatusOK, "second"},
+		{"/hello.txt", "direct", http.StatusOK, "second"},
+		{"/hello.txt", "Direct", http.StatusOK, "second"},
+		{"/hello.txt?rev=1", "direct", http.StatusOK, "first"},
+		{"/missing.txt?rev=latest", "", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.Path, nil)
+		if tt.Resolve != "" {
+			r.Header.Set(ResolveHeader, tt.Resolve)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != tt.Status {
+			t.Errorf("%s %s: expected status %d but got %d", tt.Path, tt.Resolve, tt.Status, w.Code)
+			continue
+		}
+		if tt.Body == "" {
+			continue
+		}
+		if w.Body.String() != tt.Body {
+			t.Errorf("%s %s: unexpected body: %q", tt.Path, tt.Resolve, w.Body.String())
+		}
+		if tt.Body == "second" {
+			if rev := w.Header().Get("X-Artistore-Revision"); rev != "2" {
+				t.Errorf("%s %s: unexpected revision header: %q", tt.Path, tt.Resolve, rev)
+			}
+			if loc := w.Header().Get("Content-Location"); loc != "/hello.txt?rev=2" {
+				t.Errorf("%s %s: unexpected Content-Location: %q", tt.Path, tt.Resolve, loc)
+			}
+			if cache := w.Header().Get("Cache-Control"); cache != "public, no-cache" {
+				t.Errorf("%s %s: latest should not be cached as immutable: %q", tt.Path, tt.Resolve, cache)
+			}
+			if vary := w.Header().Get("Vary"); !strings.Contains(vary, ResolveHeader) {
+				t.Errorf("%s %s: Vary should contain %s: %q", tt.Path, tt.Resolve, ResolveHeader, vary)
+			}
+		}
+	}
+}
+
+func TestLatestRedirectCache(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	if _, err := store.Put("hello.txt", strings.NewReader("first"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	s := Server{Store: store, LatestMaxAge: 10 * time.Second}
+
+	get := func(inm string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/hello.txt", nil)
+		if inm != "" {
+			r.Header.Set("If-None-Match", inm)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	if cache := w.Header().Get("Cache-Control"); cache != "public, max-age=10" {
+		t.Errorf("unexpected Cache-Control: %q", cache)
+	}
+	etag := w.Header().Get("Etag")
+	if etag == "" {
+		t.Fatalf("redirect should have ETag of the target revision")
+	}
+
+	r := httptest.NewRequest("GET", "/hello.txt?rev=1", nil)
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, r)
+	if got := rw.Header().Get("Etag"); got != etag {
+		t.Errorf("ETag of redirect should be the same as the target revision: %q != %q", etag, got)
+	}
+
+	if w := get(etag); w.Code != http.StatusNotModified || w.Header().Get("Location") != "/hello.txt?rev=1" {
+		t.Errorf("matched If-None-Match should be 304: %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := get("W/" + etag); w.Code != http.StatusNotModified {
+		t.Errorf("weak If-None-Match should be 304: %d", w.Code)
+	}
+
+	if _, err := store.Put("hello.txt", strings.NewReader("second"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if w := get(etag); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/hello.txt?rev=2" || w.Header().Get("Etag") == etag {
+		t.Errorf("new revision should be redirected: %d %q %q", w.Code, w.Header().Get("Location"), w.Header().Get("Etag"))
+	}
+
+	s.LatestMaxAge = 0
+	if w := get(""); w.Header().Get("Cache-Control") != "public, no-cache" {
+		t.Errorf("unexpected Cache-Control without max-age: %q", w.Header().Get("Cache-Control"))
+	}
+}
+
+// countingStore is a LocalStore that counts calls of Get.
+type countingStore struct {
+	*LocalStore
+	Gets int
+}
+
+func (s *countingStore) Get(key string, revision int) (io.ReadSeekCloser, Metadata, error) {
+	s.Gets++
+	return s.LocalStore.Get(key, revision)
+}
+
+func TestHeadWithoutBody(t *testing.T) {
+	store := &countingStore{LocalStore: &LocalStore{Path: t.TempDir()}}
+	store.Put("hello.txt", strings.NewReader("hello world"), PutOptions{})
+	store.Put("link", strings.NewReader("https://example.com/"), PutOptions{Type: RedirectType})
+	s := Server{Store: store}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("HEAD", "/hello.txt?rev=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	if w.Header().Get("Content-Length") != "11" || w.Header().Get("Etag") == "" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected headers: %v", w.Header())
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("HEAD should not have body: %q", w.Body.String())
+	}
+	if store.Gets != 0 {
+		t.Errorf("HEAD should not open the content but Get is called %d times", store.Gets)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("HEAD", "/link?rev=1", nil))
+	if store.Gets != 1 {
+		t.Errorf("HEAD of redirect should read the target but Get is called %d times", store.Gets)
+	}
+}
+
+func TestRangeRequest(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	store.Put("hello.txt", strings.NewReader("0123456789"), PutOptions{})
+	s := Server{Store: store}
+
+	tests := []struct {
+		Range        string
+		Code         int
+		ContentRange string
+		Body         []string
+	}{
+		{"bytes=2-4", http.StatusPartialContent, "bytes 2-4/10", []string{"234"}},
+		{"bytes=7-", http.StatusPartialContent, "bytes 7-9/10", []string{"789"}},
+		{"bytes=-3", http.StatusPartialContent, "bytes 7-9/10", []string{"789"}},
+		{"bytes=-20", http.StatusPartialContent, "bytes 0-9/10", []string{"0123456789"}},
+		{"bytes=0-1,5-6", http.StatusPartialContent, "", []string{"Content-Range: bytes 0-1/10\r\n", "\r\n\r\n01\r\n", "Content-Range: bytes 5-6/10\r\n", "\r\n\r\n56\r\n"}},
+		{"bytes=-0,8-", http.StatusPartialContent, "bytes 8-9/10", []string{"89"}},
+		{"bytes=10-", http.StatusRequestedRangeNotSatisfiable, "bytes */10", nil},
+		{"bytes=-0", http.StatusRequestedRangeNotSatisfiable, "bytes */10", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Range, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/hello.txt?rev=1", nil)
+			r.Header.Set("Range", tt.Range)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != tt.Code {
+				t.Fatalf("expected %d but got %d: %s", tt.Code, w.Code, w.Body.String())
+			}
+			if cr := w.Header().Get("Content-Range"); cr != tt.ContentRange {
+				t.Errorf("unexpected Content-Range: %q", cr)
+			}
+			for _, b := range tt.Body {
+				if !strings.Contains(w.Body.String(), b) {
+					t.Errorf("body should contain %q but got %q", b, w.Body.String())
+				}
+			}
+			if tt.Code == http.StatusPartialContent && tt.ContentRange == "" && !strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges; boundary=") {
+				t.Errorf("unexpected Content-Type for multiple ranges: %q", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ServerHintPrefix is the prefix of tokens that have the URL of the server, such as "th:aHR0cHM6Ly9hcnRpZmFjdHMuZXhhbXBsZS5jb20.t2:...".
+// The hint is not signed and not sent to the server. It is only the default of --server, so that CI jobs need only ARTISTORE_TOKEN.
+const ServerHintPrefix = "th:"
+
+// WithServerHint embeds the server URL into the token string.
+func WithServerHint(token Token, server string) (string, error) {
+	if err := verifyServerHint(server); err != nil {
+		return "", err
+	}
+	return ServerHintPrefix + base64.RawURLEncoding.EncodeToString([]byte(server)) + "." + token.String(), nil
+}
+
+// splitServerHint splits the token string into the token and the server URL.
+// ok is false if raw has no server hint.
+func splitServerHint(raw string) (token, server string, ok bool, err error) {
+	if !strings.HasPrefix(raw, ServerHintPrefix) {
+		return raw, "", false, nil
+	}
+
+	xs := strings.SplitN(raw[len(ServerHintPrefix):], ".", 2)
+	if len(xs) != 2 {
+		return "", "", false, ErrInvalidToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(xs[0])
+	if err != nil || verifyServerHint(string(b)) != nil {
+		return "", "", false, ErrInvalidToken
+	}
+	return xs[1], string(b), true, nil
+}
+
+func verifyServerHint(server string) error {
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid server URL: %q: it should be an absolute http or https URL.", server)
+	}
+	return nil
+}
+
+// applyServerHint sets the server URL in the token as --server, if the server is not set by flags, environment variables, or the profile.
+func applyServerHint(v *viper.Viper) {
+	if v.IsSet("server") {
+		return
+	}
+	if _, server, ok, err := splitServerHint(strings.TrimSpace(v.GetString("token"))); err == nil && ok {
+		v.Set("server", server)
+	}
+}
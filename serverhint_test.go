@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func TestServerHint(t *testing.T) {
+	sec, _ := NewSecret()
+	token, _ := NewToken(sec, "hello/")
+
+	raw, err := WithServerHint(token, "https://artifacts.example.com")
+	if err != nil {
+		t.Fatalf("failed to embed server: %s", err)
+	}
+
+	parsed, err := ParseToken(raw)
+	if err != nil {
+		t.Fatalf("failed to parse token with server hint: %s", err)
+	}
+	if parsed.String() != token.String() {
+		t.Errorf("unexpected token: %s", parsed)
+	}
+	if !IsCorrentToken(sec, parsed, "hello/") {
+		t.Errorf("token with server hint should be valid")
+	}
+
+	for _, server := range []string{"", "artifacts.example.com", "ftp://example.com", "/path"} {
+		if _, err := WithServerHint(token, server); err == nil {
+			t.Errorf("%q: expected error but got nil", server)
+		}
+	}
+
+	for _, raw := range []string{"th:", "th:invalid", "th:Zm9v." + token.String()} {
+		if _, err := ParseToken(raw); err == nil {
+			t.Errorf("%q: expected error but got nil", raw)
+		}
+	}
+}
+
+func TestApplyServerHint(t *testing.T) {
+	sec, _ := NewSecret()
+	token, _ := NewToken(sec, "hello/")
+	raw, _ := WithServerHint(token, "https://artifacts.example.com")
+
+	newViper := func(args ...string) *viper.Viper {
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.String("server", "http://localhost:3000", "")
+		fs.Parse(args)
+
+		v := viper.New()
+		v.BindPFlag("server", fs.Lookup("server"))
+		v.Set("token", raw)
+		return v
+	}
+
+	v := newViper()
+	applyServerHint(v)
+	if s := v.GetString("server"); s != "https://artifacts.example.com" {
+		t.Errorf("server hint should be used as the default but got %q", s)
+	}
+
+	v = newViper("--server", "https://other.example.com")
+	applyServerHint(v)
+	if s := v.GetString("server"); s != "https://other.example.com" {
+		t.Errorf("--server should take precedence but got %q", s)
+	}
+}
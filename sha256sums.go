@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// sha256Of returns SHA256 digest of the revision.
+// Revisions published by old versions do not have SHA256 in metadata, so it is calculated from the content in the pool.
+func sha256Of(store Store, pool *HashPool, meta Metadata) (digest string, err error) {
+	if meta.SHA256 != "" {
+		return meta.SHA256, nil
+	}
+
+	err = pool.Do(HashForeground, func() error {
+		f, _, err := store.Get(meta.Key, meta.Revision)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		digest = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return
+}
+
+// SHA256Sums serves checksums of the latest revisions under the prefix in the format of sha256sum command.
+// File names are relative to the directory of the prefix, so that clients can check downloaded files by "sha256sum -c".
+func (s Server) SHA256Sums(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+
+	metas, err := ListLatest(s.Store, prefix)
+	if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	var sb strings.Builder
+	for _, meta := range metas {
+		if s.isPrivate(meta.Key) {
+			continue
+		}
+
+		meta.SHA256, err = sha256Of(s.Store, s.HashPool, meta)
+		if err == ErrNoSuchArtifact || err == ErrRevisionDeleted {
+			continue
+		} else if err != nil {
+			PrintErr("ERROR", "%s#%d: %s", meta.Key, meta.Revision, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+
+		fmt.Fprintf(&sb, "%s  %s\n", meta.SHA256, strings.TrimPrefix(meta.Key, dir))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, sb.String())
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSHA256Sums(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	s := Server{Store: store}
+
+	sum := func(body string) string {
+		h := sha256.Sum256([]byte(body))
+		return hex.EncodeToString(h[:])
+	}
+
+	for _, x := range []struct{ Key, Body string }{
+		{"releases/1.2/app.tar.gz", "old"},
+		{"releases/1.2/app.tar.gz", "new"},
+		{"releases/1.2/docs/readme.txt", "readme"},
+		{"releases/1.3/app.tar.gz", "other"},
+	} {
+		if _, err := store.Put(x.Key, strings.NewReader(x.Body), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	tests := []struct {
+		Prefix string
+		Expect string
+	}{
+		{"releases/1.2/", sum("new") + "  app.tar.gz\n" + sum("readme") + "  docs/readme.txt\n"},
+		{"releases/1.2/app", sum("new") + "  app.tar.gz\n"},
+		{"releases/1.4/", ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/_api/v1/sha256sums?prefix="+tt.Prefix, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: unexpected status: %d", tt.Prefix, w.Code)
+		}
+		if w.Body.String() != tt.Expect {
+			t.Errorf("%s: unexpected response\nexpected:\n%s\nbut got:\n%s", tt.Prefix, tt.Expect, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SiteIndex is the file that is served for "GET /PREFIX/" in the site mode.
+const SiteIndex = "index.html"
+
+// siteRoot returns the longest prefix in --site-mode that key belongs to.
+func (s Server) siteRoot(key string) (string, bool) {
+	root, found := "", false
+	for _, p := range s.Sites {
+		if p == "*" {
+			found = true
+		} else if strings.HasPrefix(key, p) && len(p) > len(root) {
+			root, found = p, true
+		}
+	}
+	return root, found
+}
+
+// serveSite serves the latest revision of key as a static website under root, by --site-mode.
+//
+// Keys that end with slash serve the index file in it, and keys without slash that have the index file are redirected to add slash, so that relative links in the page work.
+// If the key does not exist and --site-fallback is set, the fallback file in root is served instead, for client side routing of single page applications.
+func (s Server) serveSite(root, key string, trace *Trace, w http.ResponseWriter, r *http.Request) {
+	file := key
+	if key == root || strings.HasSuffix(key, "/") {
+		file = key + SiteIndex
+	}
+
+	rev, err := s.latest(file)
+	if err == ErrNoSuchArtifact && file == key {
+		if _, err := s.latest(key + "/" + SiteIndex); err == nil {
+			trace.Printf("%s does not exist but %s/%s does; redirect to add slash", key, key, SiteIndex)
+			w.Header().Set("Location", "/"+key+"/")
+			w.WriteHeader(http.StatusMovedPermanently)
+			fmt.Fprintln(w, "http://"+r.Host+"/"+key+"/")
+			return
+		}
+	}
+	if err == ErrNoSuchArtifact && s.SiteFallback != "" && file != root+s.SiteFallback {
+		trace.Printf("%s does not exist; serve fallback %s%s", file, root, s.SiteFallback)
+		file = root + s.SiteFallback
+		rev, err = s.latest(file)
+	}
+
+	if err == ErrNoSuchArtifact {
+		s.setSurrogateKeys(w, file, 0)
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, err)
+	} else if err == ErrCircuitOpen {
+		if !s.replicaFallback(file, w, r) {
+			s.unavailable(w)
+		}
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+	} else {
+		trace.Printf("latest revision of %s is %d; served directly because the key matches --site-mode", file, rev)
+		w.Header().Set("Content-Location", s.pathTo(file, rev))
+		s.serveRevision(file, rev, false, trace, w, r)
+	}
+}
+
+// siteCacheControl returns Cache-Control for the latest revision of a site file.
+// Pages have to be revalidated every time so that a new release is visible at once, but other assets can be cached for --latest-max-age.
+func (s Server) siteCacheControl(visibility string, meta Metadata) string {
+	if s.LatestMaxAge > 0 && !strings.HasPrefix(meta.Type, "text/html") {
+		return fmt.Sprintf("%s, max-age=%d", visibility, int(s.LatestMaxAge.Seconds()))
+	}
+	return visibility + ", no-cache"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSiteMode(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	store.Put("docs/index.html", strings.NewReader("<h1>top</h1>"), PutOptions{})
+	store.Put("docs/guide/index.html", strings.NewReader("<h1>guide</h1>"), PutOptions{})
+	store.Put("docs/app.js", strings.NewReader("console.log(1)"), PutOptions{})
+	store.Put("other/index.html", strings.NewReader("<h1>other</h1>"), PutOptions{})
+
+	s := Server{
+		Store:        store,
+		Sites:        []string{"docs/"},
+		SiteFallback: "index.html",
+		LatestMaxAge: time.Minute,
+	}
+
+	tests := []struct {
+		Path         string
+		Code         int
+		Body         string
+		Location     string
+		CacheControl string
+	}{
+		{"/docs/", http.StatusOK, "<h1>top</h1>", "", "public, no-cache"},
+		{"/docs", http.StatusMovedPermanently, "", "/docs/", ""},
+		{"/docs/guide/", http.StatusOK, "<h1>guide</h1>", "", "public, no-cache"},
+		{"/docs/guide", http.StatusMovedPermanently, "", "/docs/guide/", ""},
+		{"/docs/app.js", http.StatusOK, "console.log(1)", "", "public, max-age=60"},
+		{"/docs/users/123", http.StatusOK, "<h1>top</h1>", "", "public, no-cache"},
+		{"/docs/app.js?rev=1", http.StatusOK, "console.log(1)", "", "public, max-age=31536000, immutable"},
+		{"/other/index.html", http.StatusSeeOther, "", "/other/index.html?rev=1", ""},
+		{"/other/", http.StatusOK, "", "", "public, no-cache"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", tt.Path, nil))
+
+			if w.Code != tt.Code {
+				t.Fatalf("expected %d but got %d: %s", tt.Code, w.Code, w.Body.String())
+			}
+			if tt.Body != "" && w.Body.String() != tt.Body {
+				t.Errorf("unexpected body: %q", w.Body.String())
+			}
+			if loc := w.Header().Get("Location"); loc != tt.Location {
+				t.Errorf("unexpected location: %q", loc)
+			}
+			if tt.CacheControl != "" && w.Header().Get("Cache-Control") != tt.CacheControl {
+				t.Errorf("unexpected Cache-Control: %q", w.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestSiteMode_NoFallback(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	store.Put("docs/index.html", strings.NewReader("<h1>top</h1>"), PutOptions{})
+
+	s := Server{Store: store, Sites: []string{"*"}}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/docs/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 but got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/docs/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "<h1>top</h1>" {
+		t.Errorf("unexpected response: %d: %s", w.Code, w.Body.String())
+	}
+	if typ := w.Header().Get("Content-Type"); !strings.HasPrefix(typ, "text/html") {
+		t.Errorf("unexpected Content-Type: %q", typ)
+	}
+}
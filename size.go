@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	Suffix string
+	Scale  int64
+}{
+	{"kib", 1 << 10},
+	{"mib", 1 << 20},
+	{"gib", 1 << 30},
+	{"tib", 1 << 40},
+	{"kb", 1 << 10},
+	{"mb", 1 << 20},
+	{"gb", 1 << 30},
+	{"tb", 1 << 40},
+	{"k", 1 << 10},
+	{"m", 1 << 20},
+	{"g", 1 << 30},
+	{"t", 1 << 40},
+	{"b", 1},
+}
+
+func ParseSize(s string) (int64, error) {
+	raw := strings.ToLower(strings.TrimSpace(s))
+
+	scale := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(raw, u.Suffix) {
+			raw = strings.TrimSpace(raw[:len(raw)-len(u.Suffix)])
+			scale = u.Scale
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(raw, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, fmt.Errorf("Invalid size: %q", s)
+	}
+
+	// Converting a float larger than MaxInt64 into int64 is not defined, so it is rejected here.
+	n *= float64(scale)
+	if n >= math.MaxInt64 {
+		return 0, fmt.Errorf("Invalid size: %q", s)
+	}
+
+	return int64(n), nil
+}
+
+func ParseSizeRange(s string) (min, max int64, err error) {
+	xs := strings.SplitN(s, "-", 2)
+
+	min, err = ParseSize(xs[0])
+	if err != nil {
+		return
+	}
+
+	if len(xs) == 1 {
+		return min, min, nil
+	}
+
+	max, err = ParseSize(xs[1])
+	if err != nil {
+		return
+	}
+
+	if min > max {
+		return 0, 0, fmt.Errorf("Invalid size range: %q", s)
+	}
+
+	return
+}
+
+func FormatSize(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return fmt.Sprintf("%d%s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", f, units[i])
+}
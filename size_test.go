@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output int64
+		Error  bool
+	}{
+		{"0", 0, false},
+		{"123", 123, false},
+		{"1k", 1024, false},
+		{"1K", 1024, false},
+		{"50m", 50 << 20, false},
+		{"512MB", 512 << 20, false},
+		{"1.5GiB", 3 << 29, false},
+		{"10 b", 10, false},
+		{"", 0, true},
+		{"-1k", 0, true},
+		{"hello", 0, true},
+		{"inf", 0, true},
+		{"+Inf", 0, true},
+		{"infinity k", 0, true},
+		{"nan", 0, true},
+		{"NaN MB", 0, true},
+		{"1e30", 0, true},
+		{"9e9 GiB", 0, true},
+	}
+
+	for _, tt := range tests {
+		n, err := ParseSize(tt.Input)
+		if tt.Error {
+			if err == nil {
+				t.Errorf("%q: expected error but got %d", tt.Input, n)
+			}
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.Input, err)
+		} else if n != tt.Output {
+			t.Errorf("%q: expected %d but got %d", tt.Input, tt.Output, n)
+		}
+	}
+}
+
+func TestParseSizeRange(t *testing.T) {
+	tests := []struct {
+		Input string
+		Min   int64
+		Max   int64
+		Error bool
+	}{
+		{"1k-50m", 1024, 50 << 20, false},
+		{"10k", 10 << 10, 10 << 10, false},
+		{"50m-1k", 0, 0, true},
+		{"1k-", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		min, max, err := ParseSizeRange(tt.Input)
+		if tt.Error {
+			if err == nil {
+				t.Errorf("%q: expected error but got %d-%d", tt.Input, min, max)
+			}
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.Input, err)
+		} else if min != tt.Min || max != tt.Max {
+			t.Errorf("%q: expected %d-%d but got %d-%d", tt.Input, tt.Min, tt.Max, min, max)
+		}
+	}
+}
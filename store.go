@@ -1,20 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"compress/gzip"
 	"crypto/md5"
-	"encoding/json"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"math"
 	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 )
@@ -22,15 +27,34 @@ import (
 var (
 	ErrRevisionDeleted = errors.New("This revesion has been deleted.")
 	ErrNoSuchArtifact  = errors.New("No such artifact on this server.")
+	ErrRetentionPaused = errors.New("Deleting revisions is paused by administrator.")
+	ErrDeleteLatest    = errors.New("Can not delete the latest revision. Please publish a new revision before delete it.")
+	ErrCorruptRevision = errors.New("Corrupted revision file.")
 )
 
 type Metadata struct {
-	Key       string    `json:"-"`
-	Revision  int       `json:"revision"`
-	Type      string    `json:"type"`
-	Size      int       `json:"size"`
-	Hash      string    `json:"md5"`
-	Timestamp time.Time `json:"-"`
+	Key       string            `json:"-"`
+	Revision  int               `json:"revision"`
+	Type      string            `json:"type"`
+	Size      int               `json:"size"`
+	Hash      string            `json:"md5"`
+	SHA256    string            `json:"sha256,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Notes     string            `json:"notes,omitempty"`
+	Timestamp time.Time         `json:"-"`
+}
+
+type PutOptions struct {
+	// Verify is called before the artifact is committed. Put will be aborted if it returns an error.
+	// The Revision of meta is the revision that will be committed.
+	Verify func(meta Metadata) error
+
+	// Type is the content type of the artifact. It is detected from the key and the content if empty.
+	Type string
+
+	// Labels are labels of the new revision.
+	Labels map[string]string
 }
 
 type RetainPolicy struct {
@@ -38,24 +62,67 @@ type RetainPolicy struct {
 	Period time.Duration
 }
 
+// PrefixRetainPolicy overrides the RetainPolicy of the store for keys under the prefix.
+type PrefixRetainPolicy struct {
+	Prefix string
+	RetainPolicy
+}
+
 type Store interface {
 	Latest(key string) (revision int, err error)
 	Metadata(key string, revision int) (Metadata, error)
 	Get(key string, revision int) (io.ReadSeekCloser, Metadata, error)
-	Put(key string, r io.Reader) (revision int, err error)
-	Sweep()
+	Put(key string, r io.Reader, opts PutOptions) (revision int, err error)
+	List(prefix string) (keys []string, err error)
+	Revisions(key string) ([]Metadata, error)
+	Delete(key string, revision int) error
+	Patch(key string, revision int, patch MetadataPatch) (Metadata, error)
+	Check(quarantine bool, callback func(CheckResult)) (CheckReport, error)
+	Sweep(opts SweepOptions) (SweepReport, error)
+	PauseRetention(paused bool)
+	RetentionPaused() bool
 }
 
 type LocalStore struct {
 	Path   string
 	Retain RetainPolicy
+
+	// PrefixRetain is policies for prefixes. The longest matched prefix is used instead of Retain.
+	PrefixRetain []PrefixRetainPolicy
+
+	// KeepTagged keeps revisions that have any tags regardless of the retain policies, so that tagged releases are never swept.
+	KeepTagged bool
+
+	// SweepWorkers is the number of keys to sweep concurrently. (default 1)
+	SweepWorkers int
+
+	// HashPool runs hash computations of Check. Check verifies revisions one by one if it is nil.
+	HashPool *HashPool
+
+	paused    int32
+	corrupted int64
+	indexLock indexLocks
+	indexes   indexCache
+	sweeper   sweeper
+}
+
+func (s *LocalStore) PauseRetention(paused bool) {
+	if paused {
+		atomic.StoreInt32(&s.paused, 1)
+	} else {
+		atomic.StoreInt32(&s.paused, 0)
+	}
 }
 
-func (s LocalStore) escape(key string) (path string) {
+func (s *LocalStore) RetentionPaused() bool {
+	return atomic.LoadInt32(&s.paused) != 0
+}
+
+func (s *LocalStore) escape(key string) (path string) {
 	return url.PathEscape(key)
 }
 
-func (s LocalStore) unescape(path string) (key string) {
+func (s *LocalStore) unescape(path string) (key string) {
 	x, err := url.PathUnescape(path)
 	if err != nil {
 		return path
@@ -63,8 +130,18 @@ func (s LocalStore) unescape(path string) (key string) {
 	return x
 }
 
-func (s LocalStore) Latest(key string) (revision int, err error) {
-	dir, err := os.Open(filepath.Join(s.Path, s.escape(key)))
+func (s *LocalStore) Latest(key string) (revision int, err error) {
+	idx, err := s.loadIndex(key)
+	if err != nil {
+		return 0, err
+	}
+	return idx.Latest(), nil
+}
+
+// latestFile finds the latest revision from files, not from the index.
+// It includes revisions that are still being written.
+func (s *LocalStore) latestFile(key string) (revision int, err error) {
+	dir, err := os.Open(s.keyDir(key))
 	if errors.Is(err, os.ErrNotExist) {
 		return 0, ErrNoSuchArtifact
 	} else if err != nil {
@@ -78,7 +155,12 @@ func (s LocalStore) Latest(key string) (revision int, err error) {
 	}
 
 	for _, x := range xs {
-		i, err := strconv.Atoi(x.Name())
+		// Numbers of quarantined, moved, or deleted revisions are never reused, because clients may have cached them as immutable.
+		name := x.Name()
+		for _, suffix := range []string{CorruptSuffix, MovedSuffix, DeletedSuffix} {
+			name = strings.TrimSuffix(name, suffix)
+		}
+		i, _, err := parseRevisionFile(name)
 		if err != nil {
 			continue
 		}
@@ -90,24 +172,64 @@ func (s LocalStore) Latest(key string) (revision int, err error) {
 	return
 }
 
+func (s *LocalStore) List(prefix string) ([]string, error) {
+	return s.listKeys(prefix, keyLive)
+}
+
+// ListDeleted returns keys under prefix that had revisions once, but all of them have been removed.
+func (s *LocalStore) ListDeleted(prefix string) ([]string, error) {
+	return s.listKeys(prefix, keyDeleted)
+}
+
+func (s *LocalStore) listKeys(prefix string, state keyDirState) ([]string, error) {
+	keys := []string{}
+	err := s.walkKeys(func(key string) {
+		if strings.HasPrefix(key, prefix) && readKeyDirState(s.keyDir(key)) == state {
+			keys = append(keys, key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (s *LocalStore) Revisions(key string) ([]Metadata, error) {
+	idx, err := s.loadIndex(key)
+	if err != nil {
+		return nil, err
+	}
+
+	metas := make([]Metadata, 0, len(idx.Revisions))
+	for _, e := range idx.Revisions {
+		metas = append(metas, e.metadata())
+	}
+
+	return metas, nil
+}
+
 type LocalFileReader struct {
-	f   *os.File
-	z   *gzip.Reader
-	pos int64
+	f     *os.File
+	z     *gzip.Reader
+	pos   int64
+	patch string
 }
 
-func (s LocalStore) open(key string, revision int) (*LocalFileReader, error) {
-	f, err := os.Open(filepath.Join(s.Path, s.escape(key), strconv.Itoa(revision)))
+func (s *LocalStore) open(key string, revision int) (*LocalFileReader, error) {
+	f, err := os.Open(filepath.Join(s.keyDir(key), revisionFileName(revision)))
 	if err != nil {
 		return nil, err
 	}
 
 	z, err := gzip.NewReader(f)
 	if err != nil {
-		return nil, err
+		f.Close()
+		return nil, fmt.Errorf("%w: invalid gzip header: %s", ErrCorruptRevision, err)
 	}
 
-	return &LocalFileReader{f, z, 0}, nil
+	return &LocalFileReader{f, z, 0, s.patchPath(key, revision)}, nil
 }
 
 func (f *LocalFileReader) Close() error {
@@ -115,15 +237,18 @@ func (f *LocalFileReader) Close() error {
 }
 
 func (f *LocalFileReader) Metadata() (Metadata, error) {
-	var meta Metadata
-
-	if err := json.Unmarshal(f.z.Extra, &meta); err != nil {
+	meta, err := decodeMetadata(f.z.Extra)
+	if err != nil {
 		return Metadata{}, err
 	}
 
 	meta.Key = f.z.Name
 	meta.Timestamp = f.z.ModTime
 
+	if o, ok := readMetadataOverride(f.patch); ok {
+		meta = o.apply(meta)
+	}
+
 	return meta, nil
 }
 
@@ -145,6 +270,13 @@ func (f *LocalFileReader) Seek(offset int64, whence int) (int64, error) {
 		if offset == f.pos {
 			return offset, nil
 		}
+		if offset > f.pos {
+			// Seeking forward does not need to decompress from the beginning again.
+			if _, err := io.CopyN(DummyWriter{}, f, offset-f.pos); err != nil {
+				return f.pos, err
+			}
+			return f.pos, nil
+		}
 
 		_, err := f.f.Seek(0, io.SeekStart)
 		if err != nil {
@@ -176,88 +308,208 @@ func (f *LocalFileReader) Seek(offset int64, whence int) (int64, error) {
 	return 0, nil
 }
 
-func (s LocalStore) Metadata(key string, revision int) (Metadata, error) {
-	f, err := s.open(key, revision)
-	if errors.Is(err, os.ErrNotExist) {
-		if latest, err := s.Latest(key); err == nil && revision < latest {
-			return Metadata{}, ErrRevisionDeleted
-		}
-		return Metadata{}, ErrNoSuchArtifact
-	} else if err != nil {
-		return Metadata{}, nil
+func (s *LocalStore) Metadata(key string, revision int) (Metadata, error) {
+	idx, err := s.loadIndex(key)
+	if err != nil {
+		return Metadata{}, err
 	}
-	defer f.Close()
 
-	return f.Metadata()
+	if meta, ok := idx.Find(revision); ok {
+		return meta, nil
+	}
+	if revision < idx.Latest() {
+		return Metadata{}, ErrRevisionDeleted
+	}
+	return Metadata{}, ErrNoSuchArtifact
 }
 
-func (s LocalStore) Get(key string, revision int) (io.ReadSeekCloser, Metadata, error) {
+func (s *LocalStore) Get(key string, revision int) (io.ReadSeekCloser, Metadata, error) {
 	f, err := s.open(key, revision)
+	if err == nil {
+		var meta Metadata
+		if meta, err = f.Metadata(); err == nil {
+			return f, meta, nil
+		}
+		f.Close()
+		err = fmt.Errorf("%w: %s", ErrCorruptRevision, err)
+	}
+
+	if errors.Is(err, ErrCorruptRevision) {
+		// The revision is never readable again, so the latest revision falls back to the previous one.
+		s.quarantineCorrupt(key, revision, err)
+		err = os.ErrNotExist
+	}
+
 	if errors.Is(err, os.ErrNotExist) {
 		if latest, err := s.Latest(key); err != nil {
 			return nil, Metadata{}, ErrNoSuchArtifact
 		} else if revision < latest {
 			return nil, Metadata{}, ErrRevisionDeleted
 		}
-	} else if err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, ErrNoSuchArtifact
 	}
+	return nil, Metadata{}, err
+}
 
-	meta, err := f.Metadata()
-	if err != nil {
-		return nil, Metadata{}, err
+// DeletedSuffix is the suffix of marker files that record the last revision of a key whose revisions are all removed.
+// The key is listed as deleted while it has no revision, and new revisions never reuse numbers before the marker.
+const DeletedSuffix = ".deleted"
+
+// tempFilePrefix is the prefix of files that are still being written.
+// The revision number follows the prefix, so that other Put can know the revision is already reserved.
+const tempFilePrefix = ".put-"
+
+// parseRevisionFile parses file name in the key directory, and returns revision number.
+// The second value is true if the file is a temporary file of not committed revision.
+func parseRevisionFile(name string) (revision int, temp bool, err error) {
+	if strings.HasPrefix(name, tempFilePrefix) {
+		revision, err = strconv.Atoi(name[len(tempFilePrefix):])
+		return revision, true, err
 	}
 
-	return f, meta, err
+	revision, err = strconv.Atoi(name)
+	return revision, false, err
 }
 
+// LocalFileWriter writes a revision into a temporary file, and moves it to the final path by Commit.
+// Readers never see partially written revision even if the server crashed while writing.
 type LocalFileWriter struct {
-	f *os.File
-	z *gzip.Writer
+	f      *os.File
+	z      *gzip.Writer
+	path   string
+	closed bool
 }
 
-func (s LocalStore) create(key string) (w LocalFileWriter, revision int, err error) {
-	revision, _ = s.Latest(key)
-	revision++
+func (s *LocalStore) create(key string) (w *LocalFileWriter, revision int, err error) {
+	revision, _ = s.latestFile(key)
 
-	if err := os.MkdirAll(filepath.Join(s.Path, s.escape(key)), 0755); err != nil {
-		return LocalFileWriter{}, 0, err
+	dir := s.keyDir(key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, 0, err
 	}
 
-	f, err := os.Create(filepath.Join(s.Path, s.escape(key), strconv.Itoa(revision)))
-	if err != nil {
-		return LocalFileWriter{}, 0, err
-	}
-	f.Sync()
+	// The temporary file is created exclusively, so that concurrent Put can never get the same revision.
+	for {
+		revision++
 
-	z := gzip.NewWriter(f)
+		fname := filepath.Join(dir, revisionFileName(revision))
+		f, err := os.OpenFile(filepath.Join(dir, tempFilePrefix+revisionFileName(revision)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		} else if errors.Is(err, os.ErrNotExist) {
+			// Sweep removed the directory because it was empty.
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, 0, err
+			}
+			revision--
+			continue
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		// Another Put may have committed the same revision after latestFile.
+		if _, err := os.Stat(fname); err == nil {
+			f.Close()
+			os.Remove(f.Name())
+			continue
+		}
 
-	return LocalFileWriter{f, z}, revision, nil
+		return &LocalFileWriter{f: f, z: gzip.NewWriter(f), path: fname}, revision, nil
+	}
 }
 
-func (f LocalFileWriter) Close() error {
+func (f *LocalFileWriter) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+
 	if err := f.z.Close(); err != nil {
+		f.f.Close()
 		return err
 	}
 	return f.f.Close()
 }
 
-func (f LocalFileWriter) SetMetadata(meta Metadata) (err error) {
+// gzipExtraOffset is the offset of the extra field in gzip header, that is after ID1, ID2, CM, FLG, MTIME, XFL, OS, and XLEN.
+const gzipExtraOffset = 12
+
+// placeholderMetadata makes metadata that has the longest size and digests.
+// It is written in the gzip header before the content, and then overwritten by FinalizeMetadata.
+func placeholderMetadata(meta Metadata) Metadata {
+	// MaxInt64 overflows int on 32-bit platforms, and sizes there never exceed MaxInt anyway.
+	meta.Size = math.MaxInt
+	meta.Hash = strings.Repeat("0", md5.Size*2)
+	meta.SHA256 = strings.Repeat("0", sha256.Size*2)
+	return meta
+}
+
+// SetMetadata writes metadata to the gzip header. It should be called before Write.
+func (f *LocalFileWriter) SetMetadata(meta Metadata) (err error) {
 	f.z.Name = meta.Key
-	f.z.ModTime = time.Now()
-	f.z.Extra, err = json.Marshal(meta)
+	f.z.ModTime = meta.Timestamp
+	f.z.Extra, err = encodeMetadata(placeholderMetadata(meta))
 	return
 }
 
-func (f LocalFileWriter) Write(p []byte) (int, error) {
+// FinalizeMetadata overwrites the metadata in the gzip header, after all content was written.
+// gzip header has no checksum, so the extra field can be replaced by a JSON in the same length.
+func (f *LocalFileWriter) FinalizeMetadata(meta Metadata) error {
+	if err := f.z.Close(); err != nil {
+		return err
+	}
+
+	extra, err := encodeMetadata(meta)
+	if err != nil {
+		return err
+	}
+	if len(extra) > len(f.z.Extra) {
+		return errors.New("Metadata is longer than the placeholder.")
+	}
+	extra = append(extra, bytes.Repeat([]byte(" "), len(f.z.Extra)-len(extra))...)
+
+	_, err = f.f.WriteAt(extra, gzipExtraOffset)
+	return err
+}
+
+func (f *LocalFileWriter) Write(p []byte) (int, error) {
 	return f.z.Write(p)
 }
 
-func (f LocalFileWriter) Remove() error {
+// Commit flushes the gzip stream and fsync it, and then moves it to the final path.
+func (f *LocalFileWriter) Commit() error {
+	if err := f.z.Close(); err != nil {
+		return err
+	}
+	if err := f.f.Sync(); err != nil {
+		return err
+	}
 	if err := f.Close(); err != nil {
 		return err
 	}
-	return os.Remove(f.f.Name())
+
+	if err := os.Rename(f.f.Name(), f.path); err != nil {
+		return err
+	}
+
+	// Sync the directory too, to make sure that the rename is persisted.
+	if dir, err := os.Open(filepath.Dir(f.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return nil
+}
+
+// Remove discards the revision, both of committed or not.
+func (f *LocalFileWriter) Remove() error {
+	f.Close()
+
+	err := os.Remove(f.f.Name())
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.Remove(f.path)
+	}
+	return err
 }
 
 func detectContentType(key string, data []byte) string {
@@ -278,10 +530,10 @@ func detectContentType(key string, data []byte) string {
 	return typ
 }
 
-func (s LocalStore) Put(key string, r io.Reader) (revision int, err error) {
+func (s *LocalStore) Put(key string, r io.Reader, opts PutOptions) (revision int, err error) {
 	var head [512]byte
-	n, err := r.Read(head[:])
-	if err != nil && err != io.EOF {
+	n, err := io.ReadFull(r, head[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		return 0, err
 	}
 
@@ -291,194 +543,140 @@ func (s LocalStore) Put(key string, r io.Reader) (revision int, err error) {
 	}
 	defer f.Close()
 
-	temp, err := NewTempFile()
-	if err != nil {
-		f.Remove()
-		return 0, err
+	meta := Metadata{
+		Key:      key,
+		Revision: revision,
+		Type:     opts.Type,
+		Labels:   opts.Labels,
+		// Gzip header can only store timestamp in seconds.
+		Timestamp: time.Now().Truncate(time.Second),
 	}
-	defer temp.Close()
-
-	if _, err = temp.Write(head[:n]); err != nil {
+	if meta.Type == "" {
+		meta.Type = detectContentType(key, head[:n])
+	}
+	if err = f.SetMetadata(meta); err != nil {
 		f.Remove()
 		return 0, err
 	}
 
-	_, err = io.Copy(temp, r)
-	if err != nil {
+	// The content is written into the revision file directly while calculating digests, instead of buffering it in another file.
+	d := NewDigester()
+	w := io.MultiWriter(f, d)
+	if _, err = w.Write(head[:n]); err != nil {
 		f.Remove()
 		return 0, err
 	}
-
-	f.SetMetadata(Metadata{
-		Key:      key,
-		Revision: revision,
-		Type:     detectContentType(key, head[:n]),
-		Size:     temp.Size(),
-		Hash:     temp.Hash(),
-	})
-
-	if err = temp.CopyTo(f); err != nil {
+	if _, err = io.Copy(w, r); err != nil {
 		f.Remove()
 		return 0, err
 	}
 
-	go s.sweepByNum(key, revision)
-
-	return revision, nil
-}
-
-func (s LocalStore) sweepByNum(key string, latest int) {
-	if s.Retain.Num <= 0 {
-		return
-	}
-
-	dirname := filepath.Join(s.Path, s.escape(key))
-	dir, err := os.Open(dirname)
-	if err != nil {
-		return
-	}
-	defer dir.Close()
+	meta.Size = d.Size()
+	meta.Hash = d.Hash()
+	meta.SHA256 = d.SHA256()
 
-	xs, err := dir.ReadDir(0)
-	if err != nil {
-		return
-	}
-
-	for _, x := range xs {
-		rev, err := strconv.Atoi(x.Name())
-		if err != nil {
-			continue
-		}
-		if rev <= latest-s.Retain.Num {
-			err = os.Remove(filepath.Join(dirname, x.Name()))
-			if err != nil {
-				PrintErr("ERROR", "failed to sweep old revision %s#%d: %s", key, rev, err)
-			} else {
-				PrintImportant("SWEEP", "%s#%d", key, rev)
-			}
+	if opts.Verify != nil {
+		if err = opts.Verify(meta); err != nil {
+			f.Remove()
+			return 0, err
 		}
 	}
-}
 
-func (s LocalStore) sweepByTime(key string) {
-	if s.Retain.Period == 0 {
-		return
-	}
-
-	dirname := filepath.Join(s.Path, s.escape(key))
-	dir, err := os.Open(dirname)
-	if err != nil {
-		return
+	if err = f.FinalizeMetadata(meta); err != nil {
+		f.Remove()
+		return 0, err
 	}
-	defer dir.Close()
 
-	xs, err := dir.ReadDir(0)
-	if err != nil {
-		return
+	if err = f.Commit(); err != nil {
+		f.Remove()
+		return 0, err
 	}
 
-	latest, err := s.Latest(key)
-	if err != nil {
-		return
+	if err = s.updateIndex(key, func(idx *storeIndex) { idx.Add(meta) }); err != nil {
+		f.Remove()
+		return 0, err
 	}
 
-	for _, x := range xs {
-		rev, err := strconv.Atoi(x.Name())
-		if err != nil {
-			continue
-		}
-
-		if rev == latest {
-			continue
-		}
-
-		meta, err := s.Metadata(key, rev)
-		if err != nil {
-			continue
-		}
+	go s.sweepByNum(key, revision)
 
-		if meta.Timestamp.Add(s.Retain.Period).Before(time.Now()) {
-			err = os.Remove(filepath.Join(dirname, x.Name()))
-			if err != nil {
-				PrintErr("ERROR", "failed to sweep old revision %s#%d: %s", key, rev, err)
-			} else {
-				PrintImportant("SWEEP", "%s#%d", key, rev)
-			}
-		}
-	}
+	return revision, nil
 }
 
-func (s LocalStore) Sweep() {
-	dir, err := os.Open(s.Path)
-	if err != nil {
-		return
+func (s *LocalStore) Delete(key string, revision int) error {
+	if s.RetentionPaused() {
+		return ErrRetentionPaused
 	}
-	defer dir.Close()
 
-	xs, err := dir.ReadDir(0)
+	latest, err := s.Latest(key)
 	if err != nil {
-		return
+		return err
+	}
+	if revision == latest {
+		return ErrDeleteLatest
 	}
 
-	for _, x := range xs {
-		s.sweepByTime(s.unescape(x.Name()))
+	err = s.remove(key, revision)
+	if errors.Is(err, os.ErrNotExist) {
+		if revision < latest {
+			return ErrRevisionDeleted
+		}
+		return ErrNoSuchArtifact
 	}
+	return err
 }
 
-type TempFile struct {
-	file *os.File
-	hash hash.Hash
-	size int
+// Discard removes the revision regardless of the retention, to roll back a failed transaction.
+func (s *LocalStore) Discard(key string, revision int) error {
+	return s.remove(key, revision)
 }
 
-func NewTempFile() (*TempFile, error) {
-	f, err := os.CreateTemp("", "artistore-temp")
-	if err != nil {
-		return nil, err
+// remove deletes a revision file and its index entry.
+// If it is the latest revision, a DeletedSuffix marker is written before removing it, so that a concurrent Put never reuses the number.
+func (s *LocalStore) remove(key string, revision int) error {
+	if latest, err := s.latestFile(key); err == nil && latest == revision {
+		if err := os.WriteFile(filepath.Join(s.keyDir(key), revisionFileName(revision)+DeletedSuffix), nil, 0644); err != nil {
+			return err
+		}
 	}
-	return &TempFile{f, md5.New(), 0}, nil
-}
 
-func (f *TempFile) PrepareToRead() error {
-	if err := f.file.Sync(); err != nil {
+	err := os.Remove(filepath.Join(s.keyDir(key), revisionFileName(revision)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
-	_, err := f.file.Seek(0, os.SEEK_SET)
-	return err
-}
+	os.Remove(s.patchPath(key, revision))
+	os.Remove(s.archiveIndexPath(key, revision))
 
-func (f *TempFile) Write(p []byte) (n int, err error) {
-	n, err = f.file.Write(p)
-	if err != nil {
-		return
+	if ierr := s.updateIndex(key, func(idx *storeIndex) { idx.Remove(revision) }); ierr != nil {
+		return ierr
 	}
-	f.size += n
+	return err
+}
 
-	_, err = f.hash.Write(p)
-	return
+// Digester calculates size and digests of written data.
+type Digester struct {
+	hash   hash.Hash
+	sha256 hash.Hash
+	size   int
 }
 
-func (f *TempFile) Read(p []byte) (int, error) {
-	return f.file.Read(p)
+func NewDigester() *Digester {
+	return &Digester{md5.New(), sha256.New(), 0}
 }
 
-func (f *TempFile) CopyTo(w io.Writer) error {
-	if err := f.PrepareToRead(); err != nil {
-		return err
-	}
-	_, err := io.Copy(w, f.file)
-	return err
+func (d *Digester) Write(p []byte) (int, error) {
+	d.size += len(p)
+	d.hash.Write(p)
+	return d.sha256.Write(p)
 }
 
-func (f *TempFile) Size() int {
-	return f.size
+func (d *Digester) Size() int {
+	return d.size
 }
 
-func (f *TempFile) Hash() string {
-	return fmt.Sprintf("%032x", f.hash.Sum(nil))
+func (d *Digester) Hash() string {
+	return fmt.Sprintf("%032x", d.hash.Sum(nil))
 }
 
-func (f *TempFile) Close() error {
-	f.file.Close()
-	return os.Remove(f.file.Name())
+func (d *Digester) SHA256() string {
+	return fmt.Sprintf("%064x", d.sha256.Sum(nil))
 }
@@ -2,13 +2,20 @@ package main
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestLocalStore(t *testing.T) {
-	store := LocalStore{t.TempDir(), RetainPolicy{2, 0}}
+	store := &LocalStore{Path: t.TempDir(), Retain: RetainPolicy{2, 0}}
 
 	tests := []struct {
 		Key      string
@@ -61,7 +68,7 @@ func TestLocalStore(t *testing.T) {
 	// --- revision 1 ---
 
 	for _, tt := range tests {
-		rev, err := store.Put(tt.Key, bytes.NewBuffer(tt.Data[len(tt.Data)-1]))
+		rev, err := store.Put(tt.Key, bytes.NewBuffer(tt.Data[len(tt.Data)-1]), PutOptions{})
 		if err != nil {
 			t.Fatalf("%s#%d: failed to publish: %s", tt.Key, tt.Revision, err)
 		}
@@ -119,3 +126,278 @@ func TestLocalStore(t *testing.T) {
 		}
 	}
 }
+
+func TestLocalStoreVerify(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+
+	expect := Expectation{SHA256: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"}
+	verify := func(meta Metadata) error {
+		if !expect.Match(meta) {
+			return ErrDigestMismatch
+		}
+		return nil
+	}
+
+	if _, err := store.Put("hello", bytes.NewBufferString("hello world!"), PutOptions{Verify: verify}); err != ErrDigestMismatch {
+		t.Fatalf("expected digest mismatch but got %v", err)
+	}
+
+	if _, err := store.Latest("hello"); err != nil {
+		t.Fatalf("failed to get latest revision: %s", err)
+	}
+	if _, err := store.Metadata("hello", 1); err != ErrNoSuchArtifact {
+		t.Fatalf("rejected revision should not be stored: error=%v", err)
+	}
+
+	rev, err := store.Put("hello", bytes.NewBufferString("hello world"), PutOptions{Verify: verify})
+	if err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if rev != 1 {
+		t.Fatalf("revision should be 1 but got %d", rev)
+	}
+
+	meta, err := store.Metadata("hello", rev)
+	if err != nil {
+		t.Fatalf("failed to get metadata: %s", err)
+	}
+	if meta.SHA256 != expect.SHA256 {
+		t.Errorf("unexpected sha256: %s", meta.SHA256)
+	}
+}
+
+func TestLocalStorePauseRetention(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir(), Retain: RetainPolicy{1, 0}}
+	store.PauseRetention(true)
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.Put("hello", bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	store.Sweep(SweepOptions{})
+	time.Sleep(10 * time.Millisecond)
+
+	for rev := 1; rev <= 3; rev++ {
+		if _, err := store.Metadata("hello", rev); err != nil {
+			t.Errorf("revision %d should be retained while paused: %s", rev, err)
+		}
+	}
+
+	store.PauseRetention(false)
+	store.Sweep(SweepOptions{})
+
+	for rev := 1; rev <= 2; rev++ {
+		if _, err := store.Metadata("hello", rev); err != ErrRevisionDeleted {
+			t.Errorf("revision %d should be removed after resume: error=%v", rev, err)
+		}
+	}
+	if _, err := store.Metadata("hello", 3); err != nil {
+		t.Errorf("latest revision should be retained: %s", err)
+	}
+}
+
+func TestLocalStoreList(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir(), Retain: RetainPolicy{2, 0}}
+
+	for _, key := range []string{"b/world", "a/hello", "b/hello", "b/hello", "b/hello"} {
+		if _, err := store.Put(key, bytes.NewBufferString(key), PutOptions{}); err != nil {
+			t.Fatalf("%s: failed to publish: %s", key, err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond) // Wait for goroutine to remove old revisions.
+
+	keys, err := store.List("b/")
+	if err != nil {
+		t.Fatalf("failed to list keys: %s", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"b/hello", "b/world"}) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	metas, err := store.Revisions("b/hello")
+	if err != nil {
+		t.Fatalf("failed to list revisions: %s", err)
+	}
+	var revs []int
+	for _, meta := range metas {
+		revs = append(revs, meta.Revision)
+	}
+	if !reflect.DeepEqual(revs, []int{2, 3}) {
+		t.Errorf("unexpected revisions: %v", revs)
+	}
+
+	if _, err := store.Revisions("c/hello"); err != ErrNoSuchArtifact {
+		t.Errorf("expected ErrNoSuchArtifact but got %v", err)
+	}
+}
+
+func TestLocalStoreIndex(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir(), Retain: RetainPolicy{2, 0}}
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.Put("hello", bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond) // Wait for goroutine to remove old revisions.
+
+	fname := filepath.Join(store.keyDir("hello"), IndexFileName)
+	if _, err := os.Stat(fname); err != nil {
+		t.Fatalf("index file should be created: %s", err)
+	}
+
+	expect, err := store.Revisions("hello")
+	if err != nil {
+		t.Fatalf("failed to list revisions: %s", err)
+	}
+	if len(expect) != 2 || expect[0].Revision != 2 || expect[1].Revision != 3 {
+		t.Fatalf("unexpected revisions: %v", expect)
+	}
+
+	if err := os.Remove(fname); err != nil {
+		t.Fatalf("failed to remove index: %s", err)
+	}
+
+	rebuilt, err := store.Revisions("hello")
+	if err != nil {
+		t.Fatalf("failed to list revisions after removing index: %s", err)
+	}
+	for i := range rebuilt {
+		if rebuilt[i].Timestamp.Equal(expect[i].Timestamp) {
+			rebuilt[i].Timestamp = expect[i].Timestamp
+		}
+	}
+	if !reflect.DeepEqual(rebuilt, expect) {
+		t.Errorf("rebuilt index is different from original one\nexpected: %v\n but got: %v", expect, rebuilt)
+	}
+	if _, err := os.Stat(fname); err != nil {
+		t.Errorf("index file should be rebuilt: %s", err)
+	}
+
+	if err := os.WriteFile(fname, []byte("broken"), 0644); err != nil {
+		t.Fatalf("failed to break index: %s", err)
+	}
+	if latest, err := store.Latest("hello"); err != nil || latest != 3 {
+		t.Errorf("expected latest revision 3 from broken index but got %d (error=%v)", latest, err)
+	}
+	if _, err := store.Metadata("hello", 1); err != ErrRevisionDeleted {
+		t.Errorf("expected ErrRevisionDeleted but got %v", err)
+	}
+}
+
+func TestLocalStoreAtomicPut(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+
+	if _, err := store.Put("hello", bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	dir := store.keyDir("hello")
+	xs, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %s", err)
+	}
+	for _, x := range xs {
+		if _, temp, _ := parseRevisionFile(x.Name()); temp {
+			t.Errorf("temporary file should be removed after publish: %s", x.Name())
+		}
+	}
+
+	// Simulate a crash while writing revision 2.
+	if err := os.WriteFile(filepath.Join(dir, tempFilePrefix+"2"), []byte("partial"), 0644); err != nil {
+		t.Fatalf("failed to write temporary file: %s", err)
+	}
+
+	if latest, err := store.Latest("hello"); err != nil || latest != 1 {
+		t.Errorf("expected latest revision 1 but got %d (error=%v)", latest, err)
+	}
+	if _, _, err := store.Get("hello", 2); err != ErrNoSuchArtifact {
+		t.Errorf("expected ErrNoSuchArtifact but got %v", err)
+	}
+
+	rev, err := store.Put("hello", bytes.NewBufferString("hello again"), PutOptions{})
+	if err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if rev != 3 {
+		t.Errorf("expected revision 3 but got %d", rev)
+	}
+}
+
+func TestLocalStoreConcurrentPut(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+
+	const n = 20
+
+	var wg sync.WaitGroup
+	revs := make(chan int, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			rev, err := store.Put("hello", bytes.NewBufferString(fmt.Sprintf("hello %d", i)), PutOptions{})
+			if err != nil {
+				t.Errorf("failed to publish: %s", err)
+				return
+			}
+			revs <- rev
+		}(i)
+	}
+	wg.Wait()
+	close(revs)
+
+	seen := make(map[int]bool)
+	for rev := range revs {
+		if seen[rev] {
+			t.Errorf("revision %d is allocated twice", rev)
+		}
+		seen[rev] = true
+	}
+
+	metas, err := store.Revisions("hello")
+	if err != nil {
+		t.Fatalf("failed to list revisions: %s", err)
+	}
+	if len(metas) != n {
+		t.Fatalf("expected %d revisions but got %d", n, len(metas))
+	}
+	for i, meta := range metas {
+		if meta.Revision != i+1 {
+			t.Errorf("expected revision %d but got %d", i+1, meta.Revision)
+		}
+	}
+}
+
+func TestLocalStorePutMetadataHeader(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+
+	body := bytes.Repeat([]byte("hello world\n"), 10000)
+	if _, err := store.Put("hello.txt", bytes.NewReader(body), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	f, err := store.open("hello.txt", 1)
+	if err != nil {
+		t.Fatalf("failed to open: %s", err)
+	}
+	defer f.Close()
+
+	meta, err := f.Metadata()
+	if err != nil {
+		t.Fatalf("failed to read metadata from gzip header: %s", err)
+	}
+	if meta.Size != len(body) || meta.Hash != fmt.Sprintf("%x", md5.Sum(body)) || meta.SHA256 != fmt.Sprintf("%x", sha256.Sum256(body)) {
+		t.Errorf("metadata in gzip header is not finalized: %#v", meta)
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("failed to read content: %s", err)
+	}
+	if !bytes.Equal(data, body) {
+		t.Errorf("unexpected content")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+// Optional capabilities of Store.
+//
+// The Store interface is enough to run the server, but some backends can do a few operations much more efficiently than emulating them through the basic methods, for example listing objects with their sizes in one request on S3.
+// Such backends implement the interfaces below, and the server uses them if available.
+
+// Stater is implemented by stores that can read the latest metadata of a key in one operation.
+type Stater interface {
+	// Stat returns the metadata of the latest revision of key, with Key set.
+	// It returns ErrNoSuchArtifact if key has no revision, and ErrRevisionDeleted if the latest revision has been deleted.
+	Stat(key string) (Metadata, error)
+}
+
+// MetadataLister is implemented by stores that can list keys together with their latest metadata.
+type MetadataLister interface {
+	// ListMetadata returns the latest metadata of each key starting with prefix, with Key set, sorted by key.
+	// Keys that have no available revision are omitted.
+	ListMetadata(prefix string) ([]Metadata, error)
+}
+
+// DeletedLister is implemented by stores that remember keys whose revisions are all removed.
+type DeletedLister interface {
+	// ListDeleted returns keys starting with prefix that had revisions once but have no revision now, sorted by key.
+	ListDeleted(prefix string) ([]string, error)
+}
+
+// PutHook is implemented by stores that want to know when a revision is published through the server, for example to update an external index.
+type PutHook interface {
+	// OnPut is called after the revision is committed and before the response is sent.
+	OnPut(meta Metadata)
+}
+
+// SweepHook is implemented by stores that want to know the result of sweeps, for example to sync lifecycle rules of the backend.
+type SweepHook interface {
+	// OnSweep is called after a sweep that is not a dry-run.
+	OnSweep(report SweepReport)
+}
+
+// Discarder is implemented by stores that can remove a revision even if it is the latest, to roll back a failed transaction.
+type Discarder interface {
+	Discard(key string, revision int) error
+}
+
+// DiskUsager is implemented by stores that can report the size of a revision on the disk, which is smaller than Metadata.Size if compressed.
+type DiskUsager interface {
+	DiskSize(key string, revision int) (int64, error)
+}
+
+// CorruptionCounter is implemented by stores that quarantine corrupted revisions found while reading.
+type CorruptionCounter interface {
+	// CorruptRevisions returns the number of revisions quarantined since the store opened.
+	CorruptRevisions() int64
+}
+
+// Mover is implemented by stores that can move all revisions of a key to another key.
+type Mover interface {
+	// Move moves all revisions of src to dst keeping their numbers and metadata, and returns the latest revision.
+	// It returns ErrNoSuchArtifact if src has no revision, and ErrKeyExists if dst has any revision.
+	// If redirect is true, MovedTo of src reports dst until src is moved again.
+	Move(src, dst string, redirect bool) (latest int, err error)
+
+	// MovedTo returns the key that the revision of key has been moved to. The revision 0 means the latest revision.
+	MovedTo(key string, revision int) (dst string, ok bool)
+}
+
+// StoreWrapper is implemented by stores that wrap another store, such as CachedStore.
+// Capabilities of the wrapped store are used through the wrapper.
+type StoreWrapper interface {
+	Unwrap() Store
+}
+
+// storeLayers returns store and all stores wrapped by it, from outer to inner.
+func storeLayers(store Store) []Store {
+	layers := []Store{store}
+	for {
+		w, ok := store.(StoreWrapper)
+		if !ok {
+			return layers
+		}
+		store = w.Unwrap()
+		layers = append(layers, store)
+	}
+}
+
+// StatLatest returns the metadata of the latest revision of key, using Stater if store implements it.
+func StatLatest(store Store, key string) (Metadata, error) {
+	for _, l := range storeLayers(store) {
+		if s, ok := l.(Stater); ok {
+			return s.Stat(key)
+		}
+	}
+
+	latest, err := store.Latest(key)
+	if err != nil {
+		return Metadata{}, err
+	} else if latest == 0 {
+		return Metadata{}, ErrNoSuchArtifact
+	}
+
+	meta, err := store.Metadata(key, latest)
+	if err != nil {
+		return Metadata{}, err
+	}
+	meta.Key = key
+	return meta, nil
+}
+
+// ListLatest returns the latest metadata of each key starting with prefix, using MetadataLister if store implements it.
+func ListLatest(store Store, prefix string) ([]Metadata, error) {
+	for _, l := range storeLayers(store) {
+		if s, ok := l.(MetadataLister); ok {
+			return s.ListMetadata(prefix)
+		}
+	}
+
+	keys, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	metas := make([]Metadata, 0, len(keys))
+	for _, key := range keys {
+		meta, err := StatLatest(store, key)
+		if err == ErrNoSuchArtifact || err == ErrRevisionDeleted {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// notifyPut calls PutHook of store if implemented.
+func notifyPut(store Store, key string, revision int) {
+	for _, l := range storeLayers(store) {
+		h, ok := l.(PutHook)
+		if !ok {
+			continue
+		}
+
+		meta, err := store.Metadata(key, revision)
+		if err != nil {
+			PrintErr("ERROR", "%s#%d: %s", key, revision, err)
+			return
+		}
+		meta.Key = key
+		h.OnPut(meta)
+	}
+}
+
+// notifySweep calls SweepHook of store if implemented.
+func notifySweep(store Store, report SweepReport) {
+	for _, l := range storeLayers(store) {
+		if h, ok := l.(SweepHook); ok {
+			h.OnSweep(report)
+		}
+	}
+}
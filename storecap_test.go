@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// hookedStore is a LocalStore with all optional capabilities, that records calls.
+type hookedStore struct {
+	*LocalStore
+
+	stats  int
+	lists  int
+	puts   []Metadata
+	sweeps []SweepReport
+}
+
+func (s *hookedStore) Stat(key string) (Metadata, error) {
+	s.stats++
+	latest, err := s.Latest(key)
+	if err != nil {
+		return Metadata{}, err
+	}
+	meta, err := s.Metadata(key, latest)
+	meta.Key = key
+	return meta, err
+}
+
+func (s *hookedStore) ListMetadata(prefix string) ([]Metadata, error) {
+	s.lists++
+	keys, err := s.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var metas []Metadata
+	for _, key := range keys {
+		latest, _ := s.Latest(key)
+		meta, _ := s.Metadata(key, latest)
+		meta.Key = key
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+func (s *hookedStore) OnPut(meta Metadata) {
+	s.puts = append(s.puts, meta)
+}
+
+func (s *hookedStore) OnSweep(report SweepReport) {
+	s.sweeps = append(s.sweeps, report)
+}
+
+func TestStoreCapabilities(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewToken(sec, "hello/")
+	admin, _ := NewToken(sec, APIPrefix)
+
+	hooked := &hookedStore{LocalStore: &LocalStore{Path: t.TempDir()}}
+	s := Server{
+		Secret:       sec,
+		Store:        CachedStore{hooked, NewArtifactCache(1024)},
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+	}
+
+	request := func(method, path, body string, token Token) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "bearer "+token.String())
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, key := range []string{"hello/a.txt", "hello/b.txt"} {
+		if w := request("POST", "/"+key, "hello "+key, token); w.Code != http.StatusCreated {
+			t.Fatalf("failed to publish %s: %d: %s", key, w.Code, w.Body.String())
+		}
+	}
+	if len(hooked.puts) != 2 || hooked.puts[1].Key != "hello/b.txt" || hooked.puts[1].Revision != 1 {
+		t.Errorf("unexpected OnPut calls: %v", hooked.puts)
+	}
+
+	if w := request("GET", "/_api/v1/keys?sort=size", "", admin); w.Code != http.StatusOK {
+		t.Errorf("failed to list keys: %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("GET", "/_api/v1/sha256sums?prefix=hello/", "", admin); w.Code != http.StatusOK || strings.Count(w.Body.String(), "\n") != 2 {
+		t.Errorf("unexpected sha256sums: %d: %s", w.Code, w.Body.String())
+	}
+	if hooked.lists != 2 {
+		t.Errorf("ListMetadata should be used through CachedStore: %d calls", hooked.lists)
+	}
+
+	if _, err := StatLatest(s.Store, "hello/a.txt"); err != nil || hooked.stats != 1 {
+		t.Errorf("Stat should be used: %d calls: %v", hooked.stats, err)
+	}
+
+	request("POST", "/_api/v1/sweep?dry-run=1", "", admin)
+	request("POST", "/_api/v1/sweep", "", admin)
+	if len(hooked.sweeps) != 1 || hooked.sweeps[0].DryRun {
+		t.Errorf("OnSweep should be called only for non-dry-run sweep: %v", hooked.sweeps)
+	}
+}
+
+func TestStatLatestFallback(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+
+	if _, err := StatLatest(store, "hello.txt"); err != ErrNoSuchArtifact {
+		t.Errorf("expected ErrNoSuchArtifact but got %v", err)
+	}
+
+	store.Put("hello.txt", strings.NewReader("hello"), PutOptions{})
+	store.Put("hello.txt", strings.NewReader("hello world"), PutOptions{})
+
+	meta, err := StatLatest(store, "hello.txt")
+	if err != nil {
+		t.Fatalf("failed to stat: %s", err)
+	}
+	if meta.Key != "hello.txt" || meta.Revision != 2 || meta.Size != 11 {
+		t.Errorf("unexpected metadata: %v", meta)
+	}
+
+	metas, err := ListLatest(store, "")
+	if err != nil || len(metas) != 1 || metas[0].Revision != 2 {
+		t.Errorf("unexpected list: %v, %v", metas, err)
+	}
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var sweepCmd = &cobra.Command{
+	Use:   "sweep",
+	Short: "Remove old revisions now",
+	Long: `Remove old revisions now.
+
+The server sweeps old revisions periodically by --sweep-interval.
+This command asks the server to sweep all keys immediately, based on --retain-num and --retain-period of the server.
+Directories of keys that have never had a revision, such as keys whose first publish was rejected, are also removed.
+Keys whose revisions are all removed are kept as deleted keys, so that their revision numbers are never reused. They are listed by "GET /_api/v1/keys?deleted=true".
+Use --dry-run to see what would be removed and how much space would be reclaimed.
+Dry-run works even while retention is paused, so you can check policies before enabling retention in production.
+
+The server can also report it periodically by --sweep-report-interval.
+
+With --output json, the report is printed as JSON. With --quiet, only the removed revisions are printed.
+
+This command requires admin token. See also 'artistore help token'.`,
+	Example: `  $ export ARTISTORE_TOKEN=$(artistore token --admin)
+  $ artistore sweep --dry-run
+  $ artistore sweep`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		t, err := NewTokenHandler()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		token, err := t.TokenFor(APIPrefix)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		u, err := GetAPIURL("v1/sweep")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if dryRun {
+			u.RawQuery = "dry-run=1"
+		}
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		var report SweepReport
+		if err := client.CallAPI("POST", u, token, nil, &report); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		switch format {
+		case OutputJSON:
+			printJSON(report)
+		case OutputQuiet:
+			for _, x := range report.Swept {
+				fmt.Printf("%s#%d\n", x.Key, x.Revision)
+			}
+		default:
+			for _, x := range report.Swept {
+				fmt.Printf("%s#%d (%s, %s)\n", x.Key, x.Revision, x.Reason, FormatSize(x.Size))
+			}
+			fmt.Println(report.Summary())
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(sweepCmd)
+
+	sweepCmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	sweepCmd.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+	sweepCmd.Flags().String("token", "", "Admin token. See also 'artistore help token'.")
+	sweepCmd.Flags().Bool("dry-run", false, "Show revisions to be removed without removing them.")
+	addOutputFlags(sweepCmd)
+}
+
+var (
+	ErrSweepRunning = errors.New("Another sweep is running. Please retry later.")
+)
+
+type SweepOptions struct {
+	// DryRun reports revisions to be removed without removing them.
+	DryRun bool
+
+	// Full checks all keys even if they are not modified since the last sweep.
+	Full bool
+}
+
+type SweptRevision struct {
+	Key      string `json:"key"`
+	Revision int    `json:"revision"`
+	Reason   string `json:"reason"`
+
+	// Size is the size of files of the revision on disk.
+	Size int64 `json:"size"`
+}
+
+type SweepReport struct {
+	DryRun  bool            `json:"dry_run"`
+	Checked int             `json:"checked"`
+	Swept   []SweptRevision `json:"swept"`
+
+	// Reclaimed is the total size of swept revisions on disk.
+	Reclaimed int64 `json:"reclaimed"`
+
+	// RemovedKeys is the number of directories of keys that have never had a revision.
+	RemovedKeys int `json:"removed_keys"`
+}
+
+func (r SweepReport) Summary() string {
+	if r.DryRun {
+		return fmt.Sprintf("%d keys checked, %d revisions will be removed, %d empty keys will be removed, %s will be reclaimed. (dry run)", r.Checked, len(r.Swept), r.RemovedKeys, FormatSize(r.Reclaimed))
+	}
+	return fmt.Sprintf("%d keys checked, %d revisions removed, %d empty keys removed, %s reclaimed.", r.Checked, len(r.Swept), r.RemovedKeys, FormatSize(r.Reclaimed))
+}
+
+type sweepState struct {
+	modified time.Time
+	due      time.Time
+}
+
+// sweeper remembers the state of each keys, so that Sweep can skip keys that have nothing to do.
+type sweeper struct {
+	running int32
+
+	lock  sync.Mutex
+	state map[string]sweepState
+}
+
+func (s *LocalStore) indexModTime(key string) time.Time {
+	stat, err := os.Stat(s.indexPath(key))
+	if err != nil {
+		return time.Time{}
+	}
+	return stat.ModTime()
+}
+
+// needsSweep checks if the key has been modified since the last sweep, or has a revision that will expire.
+func (s *LocalStore) needsSweep(key string, now time.Time) bool {
+	s.sweeper.lock.Lock()
+	state, ok := s.sweeper.state[key]
+	s.sweeper.lock.Unlock()
+
+	if !ok || !state.modified.Equal(s.indexModTime(key)) {
+		return true
+	}
+	return !state.due.IsZero() && !now.Before(state.due)
+}
+
+// retainFor returns the RetainPolicy for the key.
+func (s *LocalStore) retainFor(key string) RetainPolicy {
+	retain := s.Retain
+	matched := -1
+	for _, p := range s.PrefixRetain {
+		if strings.HasPrefix(key, p.Prefix) && len(p.Prefix) > matched {
+			retain = p.RetainPolicy
+			matched = len(p.Prefix)
+		}
+	}
+	return retain
+}
+
+// hasRetainPolicy reports whether any key may have revisions to sweep.
+func (s *LocalStore) hasRetainPolicy() bool {
+	if s.Retain.Num > 0 || s.Retain.Period > 0 {
+		return true
+	}
+	for _, p := range s.PrefixRetain {
+		if p.Num > 0 || p.Period > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// keepTagged reports whether the revision is exempt from retention because of its tags.
+func (s *LocalStore) keepTagged(meta Metadata) bool {
+	return s.KeepTagged && len(meta.Tags) > 0
+}
+
+func (s *LocalStore) sweepByNum(key string, latest int) {
+	retain := s.retainFor(key)
+	if retain.Num <= 0 || s.RetentionPaused() {
+		return
+	}
+
+	idx, err := s.loadIndex(key)
+	if err != nil {
+		return
+	}
+
+	for _, e := range idx.Revisions {
+		if e.Revision <= latest-retain.Num && !s.keepTagged(e.Metadata) {
+			s.sweep(key, e.Revision)
+		}
+	}
+}
+
+func (s *LocalStore) sweep(key string, revision int) {
+	if err := s.remove(key, revision); err != nil {
+		PrintErr("ERROR", "failed to sweep old revision %s#%d: %s", key, revision, err)
+	} else {
+		PrintImportant("SWEEP", "%s#%d", key, revision)
+	}
+}
+
+// revisionDiskSize returns the total size of files of the revision.
+func (s *LocalStore) revisionDiskSize(key string, revision int) int64 {
+	var size int64
+	for _, p := range []string{filepath.Join(s.keyDir(key), revisionFileName(revision)), s.patchPath(key, revision), s.archiveIndexPath(key, revision)} {
+		if stat, err := os.Stat(p); err == nil {
+			size += stat.Size()
+		}
+	}
+	return size
+}
+
+// sweepKey removes old revisions of the key based on both of number and period.
+// It returns revisions to be removed instead of removing them if dryRun is true.
+func (s *LocalStore) sweepKey(key string, now time.Time, dryRun bool) (swept []SweptRevision) {
+	if s.RetentionPaused() && !dryRun {
+		return nil
+	}
+
+	idx, err := s.loadIndex(key)
+	if err != nil {
+		return nil
+	}
+
+	retain := s.retainFor(key)
+	latest := idx.Latest()
+	var due time.Time
+	for _, e := range idx.Revisions {
+		if e.Revision == latest || s.keepTagged(e.Metadata) {
+			continue
+		}
+
+		reason := ""
+		if retain.Num > 0 && e.Revision <= latest-retain.Num {
+			reason = "num"
+		} else if retain.Period > 0 {
+			expire := e.Timestamp.Add(retain.Period)
+			if expire.Before(now) {
+				reason = "period"
+			} else if due.IsZero() || expire.Before(due) {
+				due = expire
+			}
+		}
+		if reason == "" {
+			continue
+		}
+
+		size := s.revisionDiskSize(key, e.Revision)
+		if !dryRun {
+			if err := s.remove(key, e.Revision); err != nil {
+				PrintErr("ERROR", "failed to sweep old revision %s#%d: %s", key, e.Revision, err)
+				continue
+			}
+			PrintImportant("SWEEP", "%s#%d", key, e.Revision)
+		}
+		swept = append(swept, SweptRevision{key, e.Revision, reason, size})
+	}
+
+	if dryRun {
+		return swept
+	}
+
+	// Get modtime after the removals, because they update the index too.
+	// Revisions published during the sweep are not checked, but they are newer than the swept ones, and Put sweeps by number for them.
+	modified := s.indexModTime(key)
+
+	s.sweeper.lock.Lock()
+	defer s.sweeper.lock.Unlock()
+
+	if s.sweeper.state == nil {
+		s.sweeper.state = make(map[string]sweepState)
+	}
+	s.sweeper.state[key] = sweepState{modified, due}
+
+	return swept
+}
+
+// Sweep removes old revisions of all keys.
+// Keys that are not modified since the last sweep and have no expired revision are skipped unless opts.Full is true.
+// It returns ErrSweepRunning if the previous Sweep is still running.
+// Dry-run is allowed even while retention is paused.
+func (s *LocalStore) Sweep(opts SweepOptions) (SweepReport, error) {
+	report := SweepReport{DryRun: opts.DryRun, Swept: []SweptRevision{}}
+
+	if s.RetentionPaused() && !opts.DryRun {
+		return report, ErrRetentionPaused
+	}
+	if !atomic.CompareAndSwapInt32(&s.sweeper.running, 0, 1) {
+		return report, ErrSweepRunning
+	}
+	defer atomic.StoreInt32(&s.sweeper.running, 0)
+
+	if !s.hasRetainPolicy() {
+		var err error
+		report.RemovedKeys, err = s.removeEmptyKeys(opts.DryRun)
+		return report, err
+	}
+
+	workers := s.SweepWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	now := time.Now()
+	keys := make(chan string)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				swept := s.sweepKey(key, now, opts.DryRun)
+
+				lock.Lock()
+				report.Checked++
+				report.Swept = append(report.Swept, swept...)
+				for _, x := range swept {
+					report.Reclaimed += x.Size
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	// Old revisions by number are also removed here, because sweepByNum in Put is skipped while retention is paused.
+	err := s.walkKeys(func(key string) {
+		if opts.Full || s.needsSweep(key, now) {
+			keys <- key
+		}
+	})
+	close(keys)
+	wg.Wait()
+
+	sort.Slice(report.Swept, func(i, j int) bool {
+		a, b := report.Swept[i], report.Swept[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Revision < b.Revision
+	})
+
+	if err == nil {
+		report.RemovedKeys, err = s.removeEmptyKeys(opts.DryRun)
+	}
+
+	return report, err
+}
+
+// StartSweepReporter runs dry-run sweeps periodically, and logs what would be removed.
+// The report is also written to path in JSON if path is not empty.
+func (s Server) StartSweepReporter(interval time.Duration, path string) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			report, err := s.Store.Sweep(SweepOptions{DryRun: true, Full: true})
+			if err == ErrSweepRunning {
+				PrintWarn("SWEEP", "previous sweep is still running. skip report this time")
+				continue
+			} else if err != nil {
+				PrintErr("ERROR", "failed to make sweep report: %s", err)
+				continue
+			}
+
+			PrintLog("SWEEP", "report: %s", report.Summary())
+			if path != "" {
+				if err := writeSweepReport(path, report); err != nil {
+					PrintErr("ERROR", "failed to write sweep report: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// writeSweepReport writes the report to path atomically, so that readers never see a partial report.
+func writeSweepReport(path string, report SweepReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s Server) Sweep(path string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry-run") == "1"
+	PrintImportant("SWEEP", "started by %s (dry-run=%v)", r.RemoteAddr, dryRun)
+
+	report, err := s.Store.Sweep(SweepOptions{DryRun: dryRun, Full: true})
+	if err == ErrSweepRunning || err == ErrRetentionPaused {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(w, err)
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	if !dryRun {
+		notifySweep(s.Store, report)
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// keyDirState is the state of a key directory, that is told by files in it.
+type keyDirState int
+
+const (
+	// keyLive is a key that has revisions, including ones being published or quarantined.
+	keyLive keyDirState = iota
+
+	// keyEmpty is a key that has never had a committed revision, such as a key whose first Put failed.
+	keyEmpty
+
+	// keyDeleted is a key whose revisions are all removed, with a DeletedSuffix marker.
+	keyDeleted
+
+	// keyMoved is a key that was moved to another key with redirect.
+	keyMoved
+)
+
+// readKeyDirState reads the directory of a key once and tells its state.
+// It returns keyLive if the directory can not be read, so that callers never remove or hide it by mistake.
+func readKeyDirState(dir string) keyDirState {
+	xs, err := os.ReadDir(dir)
+	if err != nil {
+		return keyLive
+	}
+
+	moved, deleted := false, false
+	for _, x := range xs {
+		switch {
+		case x.Name() == IndexFileName:
+		case strings.HasSuffix(x.Name(), MovedSuffix):
+			moved = true
+		case strings.HasSuffix(x.Name(), DeletedSuffix):
+			deleted = true
+		default:
+			return keyLive
+		}
+	}
+
+	switch {
+	case moved:
+		return keyMoved
+	case deleted:
+		return keyDeleted
+	default:
+		return keyEmpty
+	}
+}
+
+// removeEmptyKeys removes directories of keys that have never had a committed revision, and then shard directories that became empty.
+// Directories of deleted keys are kept, because their markers keep revision numbers from being reused.
+// It returns the number of keys to be removed without removing them if dryRun is true.
+//
+// It is safe to run concurrently with Put, because directories are removed by os.Remove that never removes non-empty directories, and create makes the directory again if it is removed.
+func (s *LocalStore) removeEmptyKeys(dryRun bool) (removed int, err error) {
+	var keys []string
+	err = s.walkKeys(func(key string) {
+		if readKeyDirState(s.keyDir(key)) == keyEmpty {
+			keys = append(keys, key)
+		}
+	})
+	if err != nil || dryRun {
+		return len(keys), err
+	}
+
+	for _, key := range keys {
+		dir := s.keyDir(key)
+
+		unlock := s.indexLock.Lock(key)
+		os.Remove(s.indexPath(key))
+		s.indexes.remove(key)
+		err := os.Remove(dir)
+		unlock()
+
+		if err != nil {
+			continue
+		}
+		PrintImportant("SWEEP", "%s: removed empty key", key)
+		removed++
+
+		s.sweeper.lock.Lock()
+		delete(s.sweeper.state, key)
+		s.sweeper.lock.Unlock()
+
+		// Shard directories are removed only if they are empty.
+		if os.Remove(filepath.Dir(dir)) == nil {
+			os.Remove(filepath.Dir(filepath.Dir(dir)))
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLocalStoreSweepIncremental(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir(), Retain: RetainPolicy{Period: time.Hour}, SweepWorkers: 2}
+
+	for i := 0; i < 2; i++ {
+		if _, err := store.Put("hello", bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	now := time.Now()
+	if !store.needsSweep("hello", now) {
+		t.Fatalf("new key should be swept")
+	}
+
+	store.sweepKey("hello", now, false)
+	if _, err := store.Metadata("hello", 1); err != nil {
+		t.Fatalf("revision 1 should not be expired yet: %s", err)
+	}
+	if store.needsSweep("hello", now) {
+		t.Errorf("key should be skipped until the revision expires")
+	}
+	if !store.needsSweep("hello", now.Add(2*time.Hour)) {
+		t.Errorf("key should be swept after the revision expired")
+	}
+
+	time.Sleep(10 * time.Millisecond) // Make sure that the removal by the sweep changes modtime of the index.
+	store.sweepKey("hello", now.Add(2*time.Hour), false)
+	if _, err := store.Metadata("hello", 1); err != ErrRevisionDeleted {
+		t.Errorf("revision 1 should be removed: error=%v", err)
+	}
+	if _, err := store.Metadata("hello", 2); err != nil {
+		t.Errorf("latest revision should be retained: %s", err)
+	}
+	if store.needsSweep("hello", now.Add(2*time.Hour)) {
+		t.Errorf("key should be skipped after the sweep, even though the sweep updated the index")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err := store.Put("hello", bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if !store.needsSweep("hello", now.Add(2*time.Hour)) {
+		t.Errorf("modified key should be swept again")
+	}
+}
+
+func TestLocalStoreSweepOverlap(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir(), Retain: RetainPolicy{Num: 1}}
+	store.PauseRetention(true)
+
+	for i := 0; i < 2; i++ {
+		if _, err := store.Put("hello", bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond) // Wait for goroutine of Put to skip removing old revisions.
+	store.PauseRetention(false)
+
+	atomic.StoreInt32(&store.sweeper.running, 1)
+	if _, err := store.Sweep(SweepOptions{}); err != ErrSweepRunning {
+		t.Errorf("expected ErrSweepRunning but got %v", err)
+	}
+	if _, err := store.Metadata("hello", 1); err != nil {
+		t.Fatalf("sweep should be skipped while another sweep is running: %s", err)
+	}
+
+	atomic.StoreInt32(&store.sweeper.running, 0)
+	store.Sweep(SweepOptions{})
+	if _, err := store.Metadata("hello", 1); err != ErrRevisionDeleted {
+		t.Errorf("revision 1 should be removed: error=%v", err)
+	}
+}
+
+func TestLocalStoreSweepDryRun(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir(), Retain: RetainPolicy{Num: 1}}
+	store.PauseRetention(true)
+
+	for _, key := range []string{"hello", "hello", "hello", "world"} {
+		if _, err := store.Put(key, bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond) // Wait for goroutine of Put to skip removing old revisions.
+
+	size := store.revisionDiskSize("hello", 1)
+	if size <= 0 {
+		t.Fatalf("unexpected disk size: %d", size)
+	}
+	expect := []SweptRevision{{"hello", 1, "num", size}, {"hello", 2, "num", size}}
+
+	report, err := store.Sweep(SweepOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run should work while retention is paused: %s", err)
+	}
+	if !report.DryRun || report.Checked != 2 || !reflect.DeepEqual(report.Swept, expect) || report.Reclaimed != 2*size {
+		t.Errorf("unexpected report: %#v", report)
+	}
+	if _, err := store.Metadata("hello", 1); err != nil {
+		t.Errorf("dry run should not remove revisions: %s", err)
+	}
+
+	if _, err := store.Sweep(SweepOptions{}); err != ErrRetentionPaused {
+		t.Errorf("sweep should fail while retention is paused: %v", err)
+	}
+	store.PauseRetention(false)
+
+	report, err = store.Sweep(SweepOptions{})
+	if err != nil {
+		t.Fatalf("failed to sweep: %s", err)
+	}
+	if !reflect.DeepEqual(report.Swept, expect) || report.Reclaimed != 2*size {
+		t.Errorf("unexpected report: %#v", report)
+	}
+	if _, err := store.Metadata("hello", 1); err != ErrRevisionDeleted {
+		t.Errorf("revision 1 should be removed: error=%v", err)
+	}
+}
+
+func TestLocalStoreSweepKeepTagged(t *testing.T) {
+	for _, keep := range []bool{true, false} {
+		store := &LocalStore{Path: t.TempDir(), Retain: RetainPolicy{Num: 1, Period: time.Hour}, KeepTagged: keep}
+		store.PauseRetention(true)
+
+		for i := 0; i < 3; i++ {
+			if _, err := store.Put("hello", bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+				t.Fatalf("failed to publish: %s", err)
+			}
+		}
+		time.Sleep(10 * time.Millisecond) // Wait for goroutine of Put to skip removing old revisions.
+
+		if _, err := store.Patch("hello", 1, MetadataPatch{SetTags: true, Tags: []string{"v1.0.0"}}); err != nil {
+			t.Fatalf("failed to tag: %s", err)
+		}
+		store.PauseRetention(false)
+
+		report, err := store.Sweep(SweepOptions{Full: true})
+		if err != nil {
+			t.Fatalf("failed to sweep: %s", err)
+		}
+
+		var swept []int
+		for _, x := range report.Swept {
+			swept = append(swept, x.Revision)
+		}
+		expect := []int{2}
+		if !keep {
+			expect = []int{1, 2}
+		}
+		if !reflect.DeepEqual(swept, expect) {
+			t.Errorf("keep=%v: unexpected swept revisions: %v", keep, swept)
+		}
+
+		_, err = store.Metadata("hello", 1)
+		if keep && err != nil {
+			t.Errorf("tagged revision should be kept: %s", err)
+		} else if !keep && err != ErrRevisionDeleted {
+			t.Errorf("tagged revision should be removed without KeepTagged: error=%v", err)
+		}
+	}
+}
+
+func TestLocalStoreSweepEmptyKeys(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+
+	for _, key := range []string{"hello", "discarded"} {
+		if _, err := store.Put(key, bytes.NewBufferString("hello world"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+	if err := store.Discard("discarded", 1); err != nil {
+		t.Fatalf("failed to discard: %s", err)
+	}
+	if _, err := store.Put("rejected", bytes.NewBufferString("hello world"), PutOptions{Verify: func(Metadata) error { return ErrDigestMismatch }}); err != ErrDigestMismatch {
+		t.Fatalf("expected digest mismatch but got %v", err)
+	}
+
+	if keys, err := store.List(""); err != nil || !reflect.DeepEqual(keys, []string{"hello"}) {
+		t.Errorf("keys without revisions should not be listed: %v: %v", keys, err)
+	}
+	if keys, err := store.ListDeleted(""); err != nil || !reflect.DeepEqual(keys, []string{"discarded"}) {
+		t.Errorf("only keys that had revisions should be deleted: %v: %v", keys, err)
+	}
+
+	report, err := store.Sweep(SweepOptions{DryRun: true})
+	if err != nil || report.RemovedKeys != 1 {
+		t.Errorf("unexpected dry run report: %#v: %v", report, err)
+	}
+	if _, err := os.Stat(store.keyDir("rejected")); err != nil {
+		t.Errorf("dry run should not remove directories: %s", err)
+	}
+
+	report, err = store.Sweep(SweepOptions{})
+	if err != nil || report.RemovedKeys != 1 {
+		t.Errorf("unexpected report: %#v: %v", report, err)
+	}
+	if _, err := os.Stat(store.keyDir("rejected")); !os.IsNotExist(err) {
+		t.Errorf("directory of the key that never had a revision should be removed: %v", err)
+	}
+	if dir := filepath.Dir(store.keyDir("rejected")); dir != filepath.Dir(store.keyDir("hello")) && dir != filepath.Dir(store.keyDir("discarded")) {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("empty shard directory should be removed: %v", err)
+		}
+	}
+	if keys, err := store.ListDeleted(""); err != nil || !reflect.DeepEqual(keys, []string{"discarded"}) {
+		t.Errorf("deleted key should be kept by sweep: %v: %v", keys, err)
+	}
+	if _, err := store.Metadata("hello", 1); err != nil {
+		t.Errorf("other keys should not be affected: %s", err)
+	}
+
+	// Revision numbers of the deleted key are never reused.
+	if rev, err := store.Put("discarded", bytes.NewBufferString("hello again"), PutOptions{}); err != nil || rev != 2 {
+		t.Errorf("failed to publish again: %d: %v", rev, err)
+	}
+	if keys, err := store.List(""); err != nil || !reflect.DeepEqual(keys, []string{"discarded", "hello"}) {
+		t.Errorf("published key should be listed again: %v: %v", keys, err)
+	}
+	if rev, err := store.Put("rejected", bytes.NewBufferString("hello"), PutOptions{}); err != nil || rev != 1 {
+		t.Errorf("failed to publish the key that never had a revision: %d: %v", rev, err)
+	}
+}
+
+func TestWriteSweepReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	report := SweepReport{DryRun: true, Checked: 1, Swept: []SweptRevision{{"hello", 1, "period", 123}}, Reclaimed: 123}
+
+	if err := writeSweepReport(path, report); err != nil {
+		t.Fatalf("failed to write report: %s", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read report: %s", err)
+	}
+	var got SweepReport
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("failed to parse report: %s", err)
+	}
+	if !reflect.DeepEqual(got, report) {
+		t.Errorf("unexpected report: %#v", got)
+	}
+}
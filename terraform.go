@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TerraformLock is a lock of Terraform state, that is the LockInfo of Terraform.
+type TerraformLock struct {
+	ID string `json:"ID"`
+
+	// Raw is the request body of LOCK, that is sent back to clients that failed to get the lock.
+	Raw json.RawMessage `json:"-"`
+}
+
+// TerraformLockDirName is the directory in the store to keep locks of Terraform states.
+// It can not conflict with any key, because url.PathEscape always escapes "!".
+const TerraformLockDirName = "terraform-locks!"
+
+// TerraformLockStore has locks of Terraform states.
+// Locks are also written into files under Dir if it is not empty, so that a restart of the server does not release locks while Terraform is applying.
+type TerraformLockStore struct {
+	Dir string
+
+	lock sync.Mutex
+	m    map[string]TerraformLock
+}
+
+// NewTerraformLockStore makes TerraformLockStore, and loads locks in the dir.
+// Locks are kept only on memory if dir is empty.
+func NewTerraformLockStore(dir string) (*TerraformLockStore, error) {
+	s := &TerraformLockStore{Dir: dir, m: make(map[string]TerraformLock)}
+	if dir == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	xs, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range xs {
+		name := strings.TrimSuffix(x.Name(), ".json")
+		if x.IsDir() || name == x.Name() {
+			continue
+		}
+		key, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+
+		raw, err := os.ReadFile(filepath.Join(dir, x.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var l TerraformLock
+		if err := json.Unmarshal(raw, &l); err != nil || l.ID == "" {
+			PrintWarn("WARN", "%s: broken Terraform lock file is ignored", x.Name())
+			continue
+		}
+		l.Raw = raw
+		s.m[key] = l
+	}
+	return s, nil
+}
+
+func (s *TerraformLockStore) path(key string) string {
+	return filepath.Join(s.Dir, url.PathEscape(key)+".json")
+}
+
+func (s *TerraformLockStore) Get(key string) (TerraformLock, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	l, ok := s.m[key]
+	return l, ok
+}
+
+// Lock gets the lock of the key. It returns the current lock and false if the key is locked by another ID.
+func (s *TerraformLockStore) Lock(key string, l TerraformLock) (TerraformLock, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if cur, ok := s.m[key]; ok && cur.ID != l.ID {
+		return cur, false, nil
+	}
+	if s.Dir != "" {
+		fname := s.path(key)
+		if err := os.WriteFile(fname+".tmp", l.Raw, 0600); err != nil {
+			return TerraformLock{}, false, err
+		}
+		if err := os.Rename(fname+".tmp", fname); err != nil {
+			return TerraformLock{}, false, err
+		}
+	}
+	s.m[key] = l
+	return l, true, nil
+}
+
+// Unlock releases the lock of the key. An empty id releases the lock regardless of the owner, that is for "terraform force-unlock".
+// It returns the current lock and false if the key is locked by another ID.
+func (s *TerraformLockStore) Unlock(key, id string) (TerraformLock, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if cur, ok := s.m[key]; ok && id != "" && cur.ID != id {
+		return cur, false, nil
+	}
+	if s.Dir != "" {
+		if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return TerraformLock{}, false, err
+		}
+	}
+	delete(s.m, key)
+	return TerraformLock{}, true, nil
+}
+
+// writeTerraformLocked responds 423 Locked with the current lock, so that Terraform can show who has the lock.
+func writeTerraformLocked(w http.ResponseWriter, l TerraformLock) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusLocked)
+	w.Write(l.Raw)
+}
+
+func readTerraformLock(r *http.Request) (TerraformLock, error) {
+	var l TerraformLock
+
+	raw, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		return l, err
+	}
+	if len(strings.TrimSpace(string(raw))) == 0 {
+		return l, nil
+	}
+	if err := json.Unmarshal(raw, &l); err != nil {
+		return l, err
+	}
+	l.Raw = raw
+	return l, nil
+}
+
+// ServeTerraform implements the HTTP backend of Terraform for keys under Terraform prefixes.
+//
+// GET returns the latest state, and POST publishes a new revision of the state, so that the history of the state is kept.
+// LOCK and UNLOCK methods manage the lock of the state.
+// Terraform sends the token as the password of basic authentication.
+func (s Server) ServeTerraform(key string, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if _, password, ok := r.BasicAuth(); ok {
+		r.Header.Set("Authorization", "bearer "+password)
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		// The state usually has secrets, so it is always private.
+		if !s.authorizeRead(key, w, r) {
+			return
+		}
+
+		trace, ok := s.debugTrace(w, r)
+		if !ok {
+			return
+		}
+
+		rev, err := s.latest(key)
+		if err == ErrNoSuchArtifact {
+			// Terraform considers 404 as no state yet.
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, err)
+		} else if err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+		} else {
+			s.serveRevision(key, rev, false, trace, w, r)
+		}
+	case "POST":
+		// Terraform sends the ID of the lock as a query if it has the lock.
+		if l, ok := s.TerraformLocks.Get(key); ok && l.ID != r.URL.Query().Get("ID") {
+			if s.authorize(key, ScopePublish, w, r) {
+				writeTerraformLocked(w, l)
+			}
+			return
+		}
+		s.Post(key, w, r)
+	case "LOCK":
+		if !s.authorize(key, ScopePublish, w, r) {
+			return
+		}
+
+		l, err := readTerraformLock(r)
+		if err != nil || l.ID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid lock info.")
+			return
+		}
+
+		if cur, ok, err := s.TerraformLocks.Lock(key, l); err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		} else if !ok {
+			PrintWarn("LOCKED", "%s %s: already locked by %s", key, r.RemoteAddr, cur.ID)
+			writeTerraformLocked(w, cur)
+			return
+		}
+		PrintImportant("LOCK", "%s %s", key, l.ID)
+		w.WriteHeader(http.StatusOK)
+	case "UNLOCK":
+		if !s.authorize(key, ScopePublish, w, r) {
+			return
+		}
+
+		l, err := readTerraformLock(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid lock info.")
+			return
+		}
+
+		if cur, ok, err := s.TerraformLocks.Unlock(key, l.ID); err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		} else if !ok {
+			writeTerraformLocked(w, cur)
+			return
+		}
+		PrintImportant("UNLOCK", "%s %s", key, l.ID)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+	}
+}
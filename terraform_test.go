@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTerraformBackend(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+
+	locks, err := NewTerraformLockStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to make lock store: %s", err)
+	}
+
+	ts := httptest.NewServer(Server{
+		Secret:         sec,
+		Store:          &LocalStore{Path: t.TempDir()},
+		Expectations:   NewExpectationStore(),
+		Uploads:        NewUploadTracker(),
+		Terraform:      []string{"terraform/"},
+		TerraformLocks: locks,
+	})
+	defer ts.Close()
+
+	token, _ := NewScopedToken(sec, "terraform/", ScopePublish|ScopeRead)
+
+	request := func(method, path, body string) (int, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.SetBasicAuth("artistore", token.String())
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	lockA := `{"ID":"aaa","Operation":"OperationTypeApply","Who":"alice@example"}`
+	lockB := `{"ID":"bbb","Operation":"OperationTypePlan","Who":"bob@example"}`
+
+	steps := []struct {
+		Method string
+		Path   string
+		Body   string
+		Status int
+		Expect string
+	}{
+		{"GET", "/terraform/prod.tfstate", "", http.StatusNotFound, ""},
+		{"LOCK", "/terraform/prod.tfstate", lockA, http.StatusOK, ""},
+		{"LOCK", "/terraform/prod.tfstate", lockA, http.StatusOK, ""},
+		{"LOCK", "/terraform/prod.tfstate", lockB, http.StatusLocked, lockA},
+		{"POST", "/terraform/prod.tfstate?ID=bbb", `{"version":4}`, http.StatusLocked, lockA},
+		{"POST", "/terraform/prod.tfstate?ID=aaa", `{"version":4}`, http.StatusCreated, ""},
+		{"GET", "/terraform/prod.tfstate", "", http.StatusOK, `{"version":4}`},
+		{"UNLOCK", "/terraform/prod.tfstate", lockB, http.StatusLocked, lockA},
+		{"UNLOCK", "/terraform/prod.tfstate", lockA, http.StatusOK, ""},
+		{"LOCK", "/terraform/prod.tfstate", lockB, http.StatusOK, ""},
+		{"UNLOCK", "/terraform/prod.tfstate", "", http.StatusOK, ""},
+		{"POST", "/terraform/prod.tfstate", `{"version":5}`, http.StatusCreated, ""},
+		{"GET", "/terraform/prod.tfstate", "", http.StatusOK, `{"version":5}`},
+		{"DELETE", "/terraform/prod.tfstate", "", http.StatusMethodNotAllowed, ""},
+	}
+
+	for i, tt := range steps {
+		status, body := request(tt.Method, tt.Path, tt.Body)
+		if status != tt.Status {
+			t.Fatalf("%d: %s %s: expected status %d but got %d: %s", i, tt.Method, tt.Path, tt.Status, status, body)
+		}
+		if tt.Expect != "" && body != tt.Expect {
+			t.Errorf("%d: %s %s: unexpected body: %s", i, tt.Method, tt.Path, body)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/terraform/prod.tfstate")
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("state should require token but got status %d", resp.StatusCode)
+	}
+}
+
+func TestTerraformLockStorePersist(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewTerraformLockStore(dir)
+	if err != nil {
+		t.Fatalf("failed to make lock store: %s", err)
+	}
+	lockA := TerraformLock{ID: "aaa", Raw: json.RawMessage(`{"ID":"aaa","Who":"alice@example"}`)}
+	lockB := TerraformLock{ID: "bbb", Raw: json.RawMessage(`{"ID":"bbb","Who":"bob@example"}`)}
+	for key, l := range map[string]TerraformLock{"terraform/prod.tfstate": lockA, "terraform/dev.tfstate": lockB} {
+		if _, ok, err := s.Lock(key, l); err != nil || !ok {
+			t.Fatalf("failed to lock %s: %v %v", key, ok, err)
+		}
+	}
+	if _, ok, err := s.Unlock("terraform/dev.tfstate", "bbb"); err != nil || !ok {
+		t.Fatalf("failed to unlock: %v %v", ok, err)
+	}
+	os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0600)
+
+	restored, err := NewTerraformLockStore(dir)
+	if err != nil {
+		t.Fatalf("failed to load lock store: %s", err)
+	}
+	if l, ok := restored.Get("terraform/prod.tfstate"); !ok || l.ID != "aaa" || string(l.Raw) != string(lockA.Raw) {
+		t.Errorf("lock is not restored: %v %v", l, ok)
+	}
+	if l, ok := restored.Get("terraform/dev.tfstate"); ok {
+		t.Errorf("released lock is restored: %v", l)
+	}
+	if cur, ok, _ := restored.Lock("terraform/prod.tfstate", lockB); ok || cur.ID != "aaa" {
+		t.Errorf("restored lock was taken by another ID: %v", cur)
+	}
+
+	if _, ok, err := restored.Unlock("terraform/prod.tfstate", ""); err != nil || !ok {
+		t.Fatalf("failed to force-unlock: %v %v", ok, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, url.PathEscape("terraform/prod.tfstate")+".json")); !os.IsNotExist(err) {
+		t.Errorf("lock file remains after unlock: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		Name   string
+		A, B   string
+		Expect string
+	}{
+		{"same", "a\nb\n", "a\nb\n", ""},
+		{"change", "a\nb\nc\n", "a\nB\nc\n", "--- A\n+++ B\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"insert into empty", "", "a\nb\n", "--- A\n+++ B\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"delete all", "a\n", "", "--- A\n+++ B\n@@ -1 +0,0 @@\n-a\n"},
+		{"no newline", "a\nb", "a\nb\n", "--- A\n+++ B\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+		{
+			"two hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"1\nX\n3\n4\n5\n6\n7\n8\n9\n10\n11\nY\n",
+			"--- A\n+++ B\n@@ -1,5 +1,5 @@\n 1\n-2\n+X\n 3\n 4\n 5\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+Y\n",
+		},
+		{
+			"merged hunk",
+			"1\n2\n3\n4\n5\n6\n7\n",
+			"1\nX\n3\n4\n5\nY\n7\n",
+			"--- A\n+++ B\n@@ -1,7 +1,7 @@\n 1\n-2\n+X\n 3\n 4\n 5\n-6\n+Y\n 7\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if got := UnifiedDiff("A", "B", tt.A, tt.B, 3); got != tt.Expect {
+				t.Errorf("unexpected diff:\n%s\nexpected:\n%s", got, tt.Expect)
+			}
+		})
+	}
+}
+
+func TestDiffLines_Minimal(t *testing.T) {
+	a := strings.Split("a b c a b b a", " ")
+	b := strings.Split("c b a b a c", " ")
+
+	changes := 0
+	for _, op := range diffLines(a, b) {
+		if op.Kind != diffEqual {
+			changes++
+		}
+	}
+	if changes != 5 {
+		t.Errorf("expected 5 changes but got %d", changes)
+	}
+}
+
+func TestIsText(t *testing.T) {
+	if !IsText([]byte("hello\nworld\n")) {
+		t.Errorf("text should be text")
+	}
+	if IsText([]byte("hello\x00world")) {
+		t.Errorf("NUL should not be text")
+	}
+	if IsText([]byte{0xff, 0xfe, 0x00}) {
+		t.Errorf("invalid UTF-8 should not be text")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var ErrInterrupted = errors.New("Interrupted.")
+
+// DefaultConnectTimeout is the default of --connect-timeout.
+const DefaultConnectTimeout = 30 * time.Second
+
+// addTimeoutFlags adds --timeout and --connect-timeout flags to the command.
+func addTimeoutFlags(c *cobra.Command) {
+	c.Flags().Duration("timeout", 0, "Maximum time of each HTTP request including the transfer. (default unlimited)")
+	c.Flags().Duration("connect-timeout", DefaultConnectTimeout, "Maximum time to connect to the server.")
+}
+
+var (
+	interruptOnce sync.Once
+	interruptCtx  context.Context
+)
+
+// interruptContext returns a context that is canceled by the first SIGINT, so that in-flight requests are aborted and commands can clean up partial files.
+// The second SIGINT kills the process as usual.
+func interruptContext() context.Context {
+	interruptOnce.Do(func() {
+		var cancel context.CancelFunc
+		interruptCtx, cancel = context.WithCancel(context.Background())
+
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt)
+		go func() {
+			<-ch
+			signal.Stop(ch)
+			PrintWarn("INTERRUPT", "abort requests. press Ctrl+C again to exit immediately")
+			cancel()
+		}()
+	})
+	return interruptCtx
+}
+
+// newTransport makes a transport by --connect-timeout and TLS flags.
+// Proxies are chosen by HTTPS_PROXY, HTTP_PROXY, and NO_PROXY environment variables as the same as http.DefaultTransport.
+func newTransport() (http.RoundTripper, error) {
+	timeout := viper.GetDuration("connect-timeout")
+	if timeout < 0 {
+		return nil, errors.New("Invalid --connect-timeout: it should be 0 or more.")
+	} else if timeout == 0 {
+		timeout = DefaultConnectTimeout
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	t.TLSHandshakeTimeout = timeout
+
+	tlsConf, err := newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConf != nil {
+		t.TLSClientConfig = tlsConf
+	}
+	return t, nil
+}
+
+// sleepContext waits for d, or returns ErrInterrupted if ctx is canceled before that.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ErrInterrupted
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	viper.Set("timeout", 100*time.Millisecond)
+	viper.Set("retries", 0)
+	defer viper.Set("timeout", nil)
+	defer viper.Set("retries", nil)
+
+	client, err := NewClient()
+	if err != nil {
+		t.Fatalf("failed to make client: %s", err)
+	}
+
+	u, _ := url.Parse(srv.URL)
+	start := time.Now()
+	if _, err := client.Get(u); err == nil {
+		t.Fatalf("expected timeout but succeeded")
+	}
+	if took := time.Since(start); took > 400*time.Millisecond {
+		t.Errorf("request should be aborted by --timeout but took %s", took)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &Client{
+		HTTP:    &http.Client{},
+		Retry:   RetryPolicy{MaxAttempts: 3, Wait: time.Second, RetryOn: []int{http.StatusServiceUnavailable}},
+		Context: ctx,
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	u, _ := url.Parse(srv.URL)
+	start := time.Now()
+	if _, err := client.Get(u); err != ErrInterrupted {
+		t.Errorf("expected ErrInterrupted but got %v", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("waiting for retry should be aborted by the context but took %s", took)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// addTLSFlags adds flags for TLS connections to the server to the command.
+func addTLSFlags(c *cobra.Command) {
+	c.Flags().String("ca-cert", "", "PEM file of CA certificates to verify the server, in addition to the system CAs.")
+	c.Flags().Bool("insecure-skip-verify", false, "Do not verify the certificate of the server. It is insecure; use --ca-cert if possible.")
+	c.Flags().String("client-cert", "", "PEM file of the client certificate for mutual TLS. It requires --client-key.")
+	c.Flags().String("client-key", "", "PEM file of the private key of --client-cert.")
+}
+
+// newTLSConfig makes TLS config by --ca-cert, --insecure-skip-verify, --client-cert, and --client-key.
+// It returns nil if none of them are set, to use the default config.
+func newTLSConfig() (*tls.Config, error) {
+	caCert := viper.GetString("ca-cert")
+	insecure := viper.GetBool("insecure-skip-verify")
+	clientCert := viper.GetString("client-cert")
+	clientKey := viper.GetString("client-key")
+
+	if caCert == "" && !insecure && clientCert == "" && clientKey == "" {
+		return nil, nil
+	}
+
+	conf := &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+
+	if caCert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --ca-cert: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("Invalid --ca-cert: no certificates in %s.", caCert)
+		}
+		conf.RootCAs = pool
+	}
+
+	if (clientCert == "") != (clientKey == "") {
+		return nil, errors.New("--client-cert and --client-key have to be set together.")
+	}
+	if clientCert != "" {
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --client-cert or --client-key: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	if insecure {
+		PrintWarn("WARN", "the certificate of the server is not verified because of --insecure-skip-verify")
+	}
+
+	return conf, nil
+}
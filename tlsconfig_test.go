@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestTLSConfig(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatalf("failed to write CA: %s", err)
+	}
+
+	u, _ := url.Parse(srv.URL)
+
+	tests := []struct {
+		Name     string
+		Settings map[string]interface{}
+		OK       bool
+	}{
+		{"default", nil, false},
+		{"ca-cert", map[string]interface{}{"ca-cert": caFile}, true},
+		{"insecure", map[string]interface{}{"insecure-skip-verify": true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			for k, v := range tt.Settings {
+				viper.Set(k, v)
+				defer viper.Set(k, nil)
+			}
+			viper.Set("retries", 0)
+			defer viper.Set("retries", nil)
+
+			client, err := NewClient()
+			if err != nil {
+				t.Fatalf("failed to make client: %s", err)
+			}
+
+			resp, err := client.Get(u)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != tt.OK {
+				t.Errorf("unexpected result: %v", err)
+			}
+		})
+	}
+}
+
+func TestTLSConfig_Invalid(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate"), 0644)
+
+	tests := []map[string]interface{}{
+		{"ca-cert": empty},
+		{"ca-cert": filepath.Join(t.TempDir(), "missing.pem")},
+		{"client-cert": empty},
+		{"client-cert": empty, "client-key": empty},
+	}
+
+	for _, tt := range tests {
+		for k, v := range tt {
+			viper.Set(k, v)
+		}
+		if _, err := newTLSConfig(); err == nil {
+			t.Errorf("expected error but got nil: %v", tt)
+		}
+		for k := range tt {
+			viper.Set(k, nil)
+		}
+	}
+}
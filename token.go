@@ -5,8 +5,11 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"os"
 	"strings"
 
@@ -50,22 +53,67 @@ Please set secret to ARTISTORE_SECRET environment variable of the server.`,
 }
 
 var tokenCmd = &cobra.Command{
-	Use:   "token KEY",
+	Use:   "token [KEY]",
 	Short: "Generate token",
 	Long: `Generate token to publish artifacts to the server.
 
 If the key has slash at the end, it will work as prefix of key.
-For example, token that generated for key "hello/" can publish artifacts starts with "hello/" such as "hello/world" or "hello/artistore".`,
+For example, token that generated for key "hello/" can publish artifacts starts with "hello/" such as "hello/world" or "hello/artistore".
+
+The token generated with --admin flag can use administration APIs such as pausing retention.
+
+By default, the token can only publish artifacts.
+It is a version 1 (t1:) token without a scope in it, that also works as an admin token if KEY is "` + APIPrefix + `", for compatibility with tokens made before scopes.
+Use --scope flag to generate token for other operations, such as "--scope delete" or "--scope publish,tag".
+The scopes are signed in the token and checked by the server for each operation, so that a leaked publish token can not delete history.
+
+  publish  Publish new revisions.
+  delete   Delete revisions.
+  read     Get artifacts under --private prefixes of the server.
+  admin    Use administration APIs. It is the default scope of --admin.
+
+With --output json, the token is printed as JSON together with the key, scope, and fingerprint.
+
+With --server-hint, the URL of the server is embedded in the token, and used as the default of --server by commands such as publish and get.
+The hint is not signed, and --server, ARTISTORE_SERVER, or the profile take precedence over it.
+
+To hand over read access to downstream jobs, use 'artistore exchange-token' to get a short-lived read-only token for specific keys.
+
+Instead of tokens generated by this command, the server started with --oidc accepts JWTs issued by OIDC providers, such as GitHub Actions or GitLab CI.
+Set the JWT to ARTISTORE_TOKEN as the same as a token. The allowed prefixes and scopes are decided by claims of the JWT.
+
+  issuers:
+  - issuer: https://token.actions.githubusercontent.com
+    audience: artistore
+    rules:
+    - claims: {repository: macrat/artistore, ref: "refs/tags/*"}
+      prefix: artistore/
+      scopes: [publish, tag]`,
 	Example: `  # Generate token for bundle.js by secret.
   $ export ARTISTORE_SECRET="your-secret-here"
   $ artistore token prefix/
 
   # And then, publish an artifact.
   $ artistore publish prefix/your-artifact.dat`,
-	Args: cobra.ExactArgs(1),
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		err := VerifyKey(args[0])
-		if err == ErrSlashKey {
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		admin, _ := cmd.Flags().GetBool("admin")
+		if admin {
+			if len(args) > 0 {
+				fmt.Fprintln(os.Stderr, "Can not use KEY with --admin flag.")
+				os.Exit(2)
+			}
+			args = []string{APIPrefix}
+		} else if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, "KEY is required.")
+			os.Exit(2)
+		} else if err := VerifyKey(args[0]); err == ErrSlashKey {
 			if args[0][0] == '/' {
 				fmt.Fprintln(os.Stderr, "Invalid key: slash can not be the first character of key for token.")
 				os.Exit(2)
@@ -81,12 +129,47 @@ For example, token that generated for key "hello/" can publish artifacts starts
 			os.Exit(2)
 		}
 
-		token, err := NewToken(secret, args[0])
+		var token Token
+		if raw, _ := cmd.Flags().GetString("scope"); raw == "" && admin {
+			token, err = NewScopedToken(secret, args[0], ScopeAdmin)
+		} else if raw == "" {
+			token, err = NewToken(secret, args[0])
+		} else {
+			scope, err := ParseScope(raw)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			token, err = NewScopedToken(secret, args[0], scope)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
-		fmt.Println(token)
+
+		raw := token.String()
+		if server, _ := cmd.Flags().GetString("server-hint"); server != "" {
+			raw, err = WithServerHint(token, server)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+		}
+
+		if format == OutputJSON {
+			printJSON(TokenResult{
+				Key:         args[0],
+				Token:       raw,
+				Scope:       token.Scope().String(),
+				Fingerprint: token.Fingerprint(),
+			})
+		} else {
+			fmt.Println(raw)
+		}
 	},
 }
 
@@ -96,6 +179,19 @@ func init() {
 
 	tokenCmd.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
 	viper.BindPFlag("secret", tokenCmd.Flags().Lookup("secret"))
+
+	tokenCmd.Flags().Bool("admin", false, "Generate token for administration APIs instead of artifacts.")
+	tokenCmd.Flags().String("scope", "", "Comma separated list of operations the token can do: publish, delete, tag, pin, read, and admin. (default publish)")
+	tokenCmd.Flags().String("server-hint", "", "URL of the server to embed in the token. Clients use it as the default of --server, so that only the token has to be configured.")
+	addOutputFlags(tokenCmd)
+}
+
+// TokenResult is the output of `artistore token --output json`.
+type TokenResult struct {
+	Key         string `json:"key"`
+	Token       string `json:"token"`
+	Scope       string `json:"scope"`
+	Fingerprint string `json:"fingerprint"`
 }
 
 type Secret []byte
@@ -132,6 +228,79 @@ func (s Secret) String() string {
 	return "s1:" + base64.RawURLEncoding.EncodeToString(s)
 }
 
+type Scope byte
+
+const (
+	ScopePublish Scope = 1 << iota
+	ScopeDelete
+	ScopeTag
+	ScopePin
+	ScopeRead
+	ScopeAdmin
+)
+
+var scopeNames = []struct {
+	Scope Scope
+	Name  string
+}{
+	{ScopePublish, "publish"},
+	{ScopeDelete, "delete"},
+	{ScopeTag, "tag"},
+	{ScopePin, "pin"},
+	{ScopeRead, "read"},
+	{ScopeAdmin, "admin"},
+}
+
+func ParseScope(raw string) (Scope, error) {
+	var s Scope
+	for _, x := range strings.Split(raw, ",") {
+		x = strings.ToLower(strings.TrimSpace(x))
+		if x == "" {
+			continue
+		}
+
+		found := false
+		for _, n := range scopeNames {
+			if n.Name == x {
+				s |= n.Scope
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("Invalid scope: %q", x)
+		}
+	}
+
+	if s == 0 {
+		return 0, errors.New("Invalid scope: at least one scope is required.")
+	}
+	return s, nil
+}
+
+func (s Scope) Has(x Scope) bool {
+	return s&x == x
+}
+
+func (s Scope) String() string {
+	var xs []string
+	for _, n := range scopeNames {
+		if s.Has(n.Scope) {
+			xs = append(xs, n.Name)
+		}
+	}
+	return strings.Join(xs, ",")
+}
+
+// Token is a signature of key made by secret.
+//
+// There are two versions of token.
+// Version 1 (t1:) is 4 bytes salt and 28 bytes signature. It has publish and admin scopes for compatibility, so that it can publish under the key, and use administration APIs if the key is APIPrefix.
+// Version 2 (t2:) is 4 bytes salt, 1 byte scope, and 28 bytes signature.
+// Version 3 (t3:) is a read-only token with expiry, that is issued by token exchange. See also NewExchangedToken.
+// Signatures of version 2 and 3 are labeled by their version, so that they never verify as another version.
+//
+// JWTs issued by OIDC providers are also held as Token, and its version is 0. See also OIDCAuthenticator.
 type Token []byte
 
 func NewTokenWithSalt(s Secret, key string, salt []byte) Token {
@@ -146,21 +315,81 @@ func NewTokenWithSalt(s Secret, key string, salt []byte) Token {
 	return Token(buf[:])
 }
 
-func NewToken(s Secret, key string) (Token, error) {
+// newTokenHash starts the signature of version 2 or later token.
+// The label of the version separates signatures of each version, so that a signature of one version can not be reused as another.
+// Version 1 does not use it, for compatibility with tokens that are already issued.
+func newTokenHash(s Secret, salt []byte, version int) hash.Hash {
+	h := sha256.New224()
+	h.Write(s)
+	h.Write(salt)
+	fmt.Fprintf(h, "artistore-t%d\x00", version)
+	return h
+}
+
+// writeTokenField writes data with its length, so that boundaries of fields are not ambiguous.
+func writeTokenField(h hash.Hash, data []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	h.Write(n[:])
+	h.Write(data)
+}
+
+func NewScopedTokenWithSalt(s Secret, key string, scope Scope, salt []byte) Token {
+	h := newTokenHash(s, salt, 2)
+	writeTokenField(h, []byte{byte(scope)})
+	writeTokenField(h, []byte(key))
+
+	var buf [33]byte
+	copy(buf[:4], salt)
+	buf[4] = byte(scope)
+	copy(buf[5:], h.Sum(nil))
+	return Token(buf[:])
+}
+
+func newSalt() ([]byte, error) {
 	var salt [4]byte
 	_, err := rand.Read(salt[:])
+	return salt[:], err
+}
+
+func NewToken(s Secret, key string) (Token, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTokenWithSalt(s, key, salt), nil
+}
+
+func NewScopedToken(s Secret, key string, scope Scope) (Token, error) {
+	salt, err := newSalt()
 	if err != nil {
 		return nil, err
 	}
 
-	return NewTokenWithSalt(s, key, salt[:]), nil
+	return NewScopedTokenWithSalt(s, key, scope, salt), nil
 }
 
 func ParseToken(raw string) (t Token, err error) {
+	raw, _, _, err = splitServerHint(raw)
+	if err != nil {
+		return nil, err
+	}
+
 	if strings.HasPrefix(raw, "s1:") {
 		return nil, ErrSeemsSecret
 	}
-	if len(raw) != 46 || !strings.HasPrefix(raw, "t1:") {
+	if IsJWT(raw) {
+		return Token(raw), nil
+	}
+	if strings.HasPrefix(raw, "t3:") {
+		tok, err := base64.RawURLEncoding.DecodeString(raw[3:])
+		if err != nil || !isExchangedToken(tok) {
+			return nil, ErrInvalidToken
+		}
+		return Token(tok), nil
+	}
+	if !(len(raw) == 46 && strings.HasPrefix(raw, "t1:")) && !(len(raw) == 47 && strings.HasPrefix(raw, "t2:")) {
 		return nil, ErrInvalidToken
 	}
 
@@ -168,22 +397,73 @@ func ParseToken(raw string) (t Token, err error) {
 	return Token(tok), err
 }
 
+func (t Token) Version() int {
+	if len(t) == 33 {
+		return 2
+	}
+	if IsJWT(string(t)) {
+		return 0
+	}
+	if isExchangedToken(t) {
+		return 3
+	}
+	return 1
+}
+
 func (t Token) String() string {
-	return "t1:" + base64.RawURLEncoding.EncodeToString(t)
+	if t.Version() == 0 {
+		return string(t)
+	}
+	return fmt.Sprintf("t%d:%s", t.Version(), base64.RawURLEncoding.EncodeToString(t))
 }
 
 func (t Token) Salt() []byte {
 	return t[:4]
 }
 
+func (t Token) Scope() Scope {
+	switch t.Version() {
+	case 2:
+		return Scope(t[4])
+	case 3:
+		return ScopeRead
+	}
+	// Version 1 tokens are made before scopes, when administration APIs were allowed by a token for the API prefix.
+	return ScopePublish | ScopeAdmin
+}
+
+// Fingerprint is an identifier of the token that can be written in ACL.
+// It does not leak the token itself.
+func (t Token) Fingerprint() string {
+	h := sha256.Sum256(t)
+	return hex.EncodeToString(h[:16])
+}
+
+func (t Token) resign(s Secret, key string) Token {
+	if t.Version() == 2 {
+		return NewScopedTokenWithSalt(s, key, t.Scope(), t.Salt())
+	}
+	return NewTokenWithSalt(s, key, t.Salt())
+}
+
 func IsCorrentToken(s Secret, t Token, key string) bool {
-	if hmac.Equal(NewTokenWithSalt(s, key, t.Salt()), t) {
+	if len(t) < 5 {
+		return false
+	}
+	if t.Version() == 3 {
+		return verifyExchangedToken(s, t, key) == nil
+	}
+	if hmac.Equal(t.resign(s, key), t) {
 		return true
 	}
 	for _, k := range KeyPrefixes(key) {
-		if hmac.Equal(NewTokenWithSalt(s, k, t.Salt()), t) {
+		if hmac.Equal(t.resign(s, k), t) {
 			return true
 		}
 	}
 	return false
 }
+
+func IsAllowedToken(s Secret, t Token, key string, scope Scope) bool {
+	return IsCorrentToken(s, t, key) && t.Scope().Has(scope)
+}
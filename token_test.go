@@ -2,7 +2,12 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSecret(t *testing.T) {
@@ -104,3 +109,199 @@ func TestToken(t *testing.T) {
 		t.Fatalf("unexpected error: %s", err)
 	}
 }
+
+func TestScopedToken(t *testing.T) {
+	s, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+
+	scope, err := ParseScope("delete, tag")
+	if err != nil {
+		t.Fatalf("failed to parse scope: %s", err)
+	}
+	if scope.String() != "delete,tag" {
+		t.Errorf("unexpected scope: %s", scope)
+	}
+
+	tok, err := NewScopedToken(s, "hello/", scope)
+	if err != nil {
+		t.Fatalf("failed to generate token: %s", err)
+	}
+
+	parsed, err := ParseToken(tok.String())
+	if err != nil {
+		t.Fatalf("failed to parse token: %s", err)
+	}
+	if parsed.String() != tok.String() || parsed.Scope() != scope {
+		t.Fatalf("parsed token is different\n%s\n%s", tok, parsed)
+	}
+
+	v1, err := NewToken(s, "hello/")
+	if err != nil {
+		t.Fatalf("failed to generate token: %s", err)
+	}
+
+	// Version 1 tokens are made before scopes, and keep publish and admin for compatibility.
+	if v1.Scope() != ScopePublish|ScopeAdmin {
+		t.Errorf("unexpected scope of v1 token: %s", v1.Scope())
+	}
+
+	tests := []struct {
+		name   string
+		tok    Token
+		key    string
+		scope  Scope
+		expect bool
+	}{
+		{"scoped", tok, "hello/world", ScopeDelete, true},
+		{"scoped", tok, "hello/world", ScopeTag, true},
+		{"scoped", tok, "hello/world", ScopeDelete | ScopeTag, true},
+		{"scoped", tok, "hello/world", ScopePublish, false},
+		{"scoped", tok, "world/hello", ScopeDelete, false},
+		{"v1", v1, "hello/world", ScopePublish, true},
+		{"v1", v1, "hello/world", ScopeDelete, false},
+		{"v1", v1, "hello/world", ScopeRead, false},
+		{"v1", v1, "hello/world", ScopeTag, false},
+		{"v1", v1, "hello/world", ScopePin, false},
+		{"v1", v1, "hello/world", ScopeAdmin, true},
+	}
+
+	for _, tt := range tests {
+		if ok := IsAllowedToken(s, tt.tok, tt.key, tt.scope); ok != tt.expect {
+			t.Errorf("%s - %q %s: expected %v but got %v", tt.name, tt.key, tt.scope, tt.expect, ok)
+		}
+	}
+
+	forged := append(Token{}, tok...)
+	forged[4] = byte(ScopePublish | ScopeDelete | ScopeTag | ScopePin)
+	if IsCorrentToken(s, forged, "hello/world") {
+		t.Errorf("token with modified scope should be invalid")
+	}
+
+	if _, err := ParseScope("publish,destroy"); err == nil {
+		t.Errorf("expected error for unknown scope")
+	}
+}
+
+func TestTokenVersionForgery(t *testing.T) {
+	s, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	salt := []byte{1, 2, 3, 4}
+	all := ScopePublish | ScopeDelete | ScopeTag | ScopePin | ScopeRead | ScopeAdmin
+
+	// A version 1 signature for "<scope byte>foo" should not be a version 2 token for "foo", and vice versa.
+	for _, scope := range []Scope{all, ScopePublish, ScopeRead} {
+		v1 := NewTokenWithSalt(s, string([]byte{byte(scope)})+"foo", salt)
+		forged := append(append(append(Token{}, salt...), byte(scope)), v1[4:]...)
+		if IsCorrentToken(s, forged, "foo") {
+			t.Errorf("version 2 token with scope %s is forged from version 1 token", scope)
+		}
+
+		v2 := NewScopedTokenWithSalt(s, "foo", scope, salt)
+		forged = append(append(Token{}, salt...), v2[5:]...)
+		if IsCorrentToken(s, forged, string([]byte{byte(scope)})+"foo") {
+			t.Errorf("version 1 token is forged from version 2 token with scope %s", scope)
+		}
+	}
+
+	// A version 1 signature should not be a version 3 token either.
+	expires := time.Now().Add(time.Hour)
+	var exp [8]byte
+	binary.BigEndian.PutUint64(exp[:], uint64(expires.Unix()))
+	v1 := NewTokenWithSalt(s, "\x03"+string(exp[:])+"foo", salt)
+	forged := append(append(append(append(Token{3}, salt...), exp[:]...), v1[4:]...), "foo"...)
+	if forged.Version() != 3 || IsCorrentToken(s, forged, "foo") {
+		t.Errorf("version 3 token is forged from version 1 token")
+	}
+}
+
+func TestAdminScope(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	s := Server{Secret: sec, Store: &LocalStore{Path: t.TempDir()}}
+
+	v1, _ := NewToken(sec, APIPrefix)
+	admin, _ := NewScopedToken(sec, APIPrefix, ScopeAdmin)
+	publish, _ := NewScopedToken(sec, APIPrefix, ScopePublish)
+
+	tests := []struct {
+		Name   string
+		Token  Token
+		Status int
+	}{
+		{"v1", v1, http.StatusOK},
+		{"admin", admin, http.StatusOK},
+		{"publish", publish, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/_api/v1/retention", nil)
+		r.Header.Set("Authorization", "bearer "+tt.Token.String())
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != tt.Status {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.Name, tt.Status, w.Code, w.Body)
+		}
+	}
+}
+
+func TestPrivatePrefix(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	store := &LocalStore{Path: t.TempDir()}
+	s := Server{Secret: sec, Store: store, Private: []string{"internal/"}}
+
+	for _, key := range []string{"internal/app.bin", "public/app.bin"} {
+		if _, err := store.Put(key, strings.NewReader("hello"), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	read, _ := NewScopedToken(sec, "internal/", ScopeRead)
+	publish, _ := NewToken(sec, "internal/")
+
+	tests := []struct {
+		Path   string
+		Token  Token
+		Status int
+		Cache  string
+	}{
+		{"/public/app.bin?rev=1", nil, http.StatusOK, "public, max-age=31536000, immutable"},
+		{"/internal/app.bin?rev=1", nil, http.StatusForbidden, ""},
+		{"/internal/app.bin?rev=1", publish, http.StatusForbidden, ""},
+		{"/internal/app.bin?rev=1", read, http.StatusOK, "private, max-age=31536000, immutable"},
+		{"/_api/v1/revisions/internal/app.bin", nil, http.StatusForbidden, ""},
+		{"/_api/v1/revisions/internal/app.bin", read, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.Path, nil)
+		if tt.Token != nil {
+			r.Header.Set("Authorization", "bearer "+tt.Token.String())
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != tt.Status {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.Path, tt.Status, w.Code, w.Body)
+		}
+		if cache := w.Header().Get("Cache-Control"); tt.Cache != "" && cache != tt.Cache {
+			t.Errorf("%s: unexpected Cache-Control: %s", tt.Path, cache)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/_api/v1/keys", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if body := w.Body.String(); strings.Contains(body, "internal/") || !strings.Contains(body, "public/app.bin") {
+		t.Errorf("private keys should not be listed: %s", body)
+	}
+}
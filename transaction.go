@@ -0,0 +1,566 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransactionTimeout is the duration that a transaction is kept without commit.
+var TransactionTimeout = time.Hour
+
+var (
+	ErrNoSuchTransaction     = errors.New("No such transaction on this server.")
+	ErrTransactionClosed     = errors.New("This transaction has already been committed or aborted.")
+	ErrTransactionEmpty      = errors.New("This transaction has no artifacts to commit.")
+	ErrTransactionOutOfScope = errors.New("The key is not in the prefix of this transaction.")
+	ErrTransactionCorrupted  = errors.New("Staged artifact has been changed before commit.")
+)
+
+const (
+	TransactionOpen       = "open"
+	TransactionCommitting = "committing"
+	TransactionCommitted  = "committed"
+	TransactionAborted    = "aborted"
+)
+
+// StagedArtifact is an artifact uploaded into a transaction, that is not published yet.
+type StagedArtifact struct {
+	Key    string            `json:"key"`
+	Size   int               `json:"size"`
+	Hash   string            `json:"md5"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	path string
+}
+
+// Transaction is a set of artifacts that are published all together.
+type Transaction struct {
+	lock sync.Mutex
+
+	ID        string
+	Prefix    string
+	Expires   time.Time
+	state     string
+	artifacts map[string]StagedArtifact
+}
+
+type TransactionStatus struct {
+	ID        string           `json:"id"`
+	Prefix    string           `json:"prefix"`
+	State     string           `json:"state"`
+	Expires   time.Time        `json:"expires"`
+	Artifacts []StagedArtifact `json:"artifacts"`
+}
+
+func (t *Transaction) Status() TransactionStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	st := TransactionStatus{
+		ID:        t.ID,
+		Prefix:    t.Prefix,
+		State:     t.state,
+		Expires:   t.Expires,
+		Artifacts: make([]StagedArtifact, 0, len(t.artifacts)),
+	}
+	for _, a := range t.artifacts {
+		st.Artifacts = append(st.Artifacts, a)
+	}
+	sort.Slice(st.Artifacts, func(i, j int) bool {
+		return st.Artifacts[i].Key < st.Artifacts[j].Key
+	})
+	return st
+}
+
+// discard removes all staged files. The caller has to hold the lock.
+func (t *Transaction) discard() {
+	for _, a := range t.artifacts {
+		os.Remove(a.path)
+	}
+	t.artifacts = nil
+}
+
+// TransactionStore keeps transactions in memory, and staged artifacts in Dir.
+//
+// While a transaction is being committed, the latest revisions of its keys are pinned to the revisions before the commit.
+// So clients see either all old revisions or all new revisions.
+type TransactionStore struct {
+	Dir string
+
+	lock   sync.Mutex
+	txs    map[string]*Transaction
+	pins   map[string]int
+	commit sync.Mutex
+}
+
+func NewTransactionStore(dir string) *TransactionStore {
+	return &TransactionStore{
+		Dir:  dir,
+		txs:  make(map[string]*Transaction),
+		pins: make(map[string]int),
+	}
+}
+
+// Begin starts a new transaction for keys in prefix.
+func (s *TransactionStore) Begin(prefix string) *Transaction {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.expire()
+
+	t := &Transaction{
+		ID:        NewID(),
+		Prefix:    prefix,
+		Expires:   time.Now().Add(TransactionTimeout),
+		state:     TransactionOpen,
+		artifacts: make(map[string]StagedArtifact),
+	}
+	s.txs[t.ID] = t
+	return t
+}
+
+func (s *TransactionStore) Get(id string) (*Transaction, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.expire()
+
+	t, ok := s.txs[id]
+	return t, ok
+}
+
+// expire discards transactions that are not committed in time. The caller has to hold the lock.
+func (s *TransactionStore) expire() {
+	now := time.Now()
+	for id, t := range s.txs {
+		t.lock.Lock()
+		if now.After(t.Expires) && t.state != TransactionCommitting {
+			if t.state == TransactionOpen {
+				PrintWarn("TRANSACTION", "%s is expired", id)
+			}
+			t.discard()
+			delete(s.txs, id)
+		}
+		t.lock.Unlock()
+	}
+}
+
+// Stage writes r into the transaction as key.
+func (s *TransactionStore) Stage(t *Transaction, key string, labels map[string]string, r io.Reader) (StagedArtifact, error) {
+	f, err := os.CreateTemp(s.Dir, "artistore-staging-")
+	if err != nil {
+		return StagedArtifact{}, err
+	}
+	defer f.Close()
+
+	d := NewDigester()
+	if _, err := io.Copy(io.MultiWriter(f, d), r); err != nil {
+		os.Remove(f.Name())
+		return StagedArtifact{}, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return StagedArtifact{}, err
+	}
+
+	a := StagedArtifact{Key: key, Size: d.Size(), Hash: d.Hash(), Labels: labels, path: f.Name()}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.state != TransactionOpen {
+		os.Remove(a.path)
+		return StagedArtifact{}, ErrTransactionClosed
+	}
+	if old, ok := t.artifacts[key]; ok {
+		os.Remove(old.path)
+	}
+	t.artifacts[key] = a
+	return a, nil
+}
+
+// Abort discards the transaction.
+func (s *TransactionStore) Abort(t *Transaction) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.state != TransactionOpen {
+		return ErrTransactionClosed
+	}
+	t.state = TransactionAborted
+	t.discard()
+	return nil
+}
+
+// Pinned returns the revision that clients should see as the latest of key while a transaction is being committed.
+func (s *TransactionStore) Pinned(key string) (revision int, ok bool) {
+	if s == nil {
+		return 0, false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	revision, ok = s.pins[key]
+	return
+}
+
+func (s *TransactionStore) pin(store Store, keys []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, key := range keys {
+		latest, err := store.Latest(key)
+		if err == ErrNoSuchArtifact {
+			latest = 0
+		} else if err != nil {
+			for _, k := range keys {
+				delete(s.pins, k)
+			}
+			return err
+		}
+		s.pins[key] = latest
+	}
+	return nil
+}
+
+func (s *TransactionStore) unpin(keys []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, key := range keys {
+		delete(s.pins, key)
+	}
+}
+
+// CommittedArtifact is an artifact that is published by a transaction.
+type CommittedArtifact struct {
+	Key      string `json:"key"`
+	Revision int    `json:"revision"`
+	Location string `json:"location"`
+}
+
+type TransactionResult struct {
+	ID        string              `json:"id"`
+	Artifacts []CommittedArtifact `json:"artifacts"`
+}
+
+// latest returns the latest revision of key that clients should see, considering transactions being committed.
+func (s Server) latest(key string) (int, error) {
+	if rev, ok := s.Transactions.Pinned(key); ok {
+		if rev == 0 {
+			return 0, ErrNoSuchArtifact
+		}
+		return rev, nil
+	}
+	return s.Store.Latest(key)
+}
+
+// commitTransaction publishes all artifacts in the transaction.
+// If any of them failed, revisions published by this transaction are removed again. The transaction is closed even if it failed.
+func (s Server) commitTransaction(t *Transaction) ([]CommittedArtifact, error) {
+	t.lock.Lock()
+	if t.state != TransactionOpen {
+		t.lock.Unlock()
+		return nil, ErrTransactionClosed
+	}
+	if len(t.artifacts) == 0 {
+		t.lock.Unlock()
+		return nil, ErrTransactionEmpty
+	}
+	t.state = TransactionCommitting
+	artifacts := make([]StagedArtifact, 0, len(t.artifacts))
+	for _, a := range t.artifacts {
+		artifacts = append(artifacts, a)
+	}
+	t.lock.Unlock()
+
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].Key < artifacts[j].Key
+	})
+
+	committed, err := s.publishStaged(artifacts)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if err != nil {
+		t.state = TransactionAborted
+	} else {
+		t.state = TransactionCommitted
+	}
+	t.discard()
+
+	return committed, err
+}
+
+func (s Server) publishStaged(artifacts []StagedArtifact) ([]CommittedArtifact, error) {
+	txs := s.Transactions
+	txs.commit.Lock()
+	defer txs.commit.Unlock()
+
+	acl := s.ACL.Get()
+	keys := make([]string, len(artifacts))
+	for i, a := range artifacts {
+		keys[i] = a.Key
+		if _, err := s.checkQuota(acl, a.Key, a.Size); err != nil {
+			return nil, fmt.Errorf("%s: %w", a.Key, err)
+		}
+	}
+
+	if err := txs.pin(s.Store, keys); err != nil {
+		return nil, err
+	}
+	defer txs.unpin(keys)
+
+	var committed []CommittedArtifact
+	var metas []Metadata
+	for _, a := range artifacts {
+		meta, err := s.putStaged(a)
+		if err != nil {
+			for _, c := range committed {
+				s.discardRevision(c.Key, c.Revision)
+			}
+			return nil, fmt.Errorf("%s: %w", a.Key, err)
+		}
+		committed = append(committed, CommittedArtifact{Key: a.Key, Revision: meta.Revision, Location: s.pathTo(a.Key, meta.Revision)})
+		metas = append(metas, meta)
+	}
+
+	for _, meta := range metas {
+		PrintImportant("PUBLISH", "%s#%d", meta.Key, meta.Revision)
+		s.afterPublish(meta)
+	}
+
+	return committed, nil
+}
+
+func (s Server) putStaged(a StagedArtifact) (Metadata, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer f.Close()
+
+	var meta Metadata
+	rev, err := s.Store.Put(a.Key, f, PutOptions{
+		Labels: a.Labels,
+		Verify: func(m Metadata) error {
+			if m.Hash != a.Hash {
+				return ErrTransactionCorrupted
+			}
+			meta = m
+			return s.Policy.CheckContent(m)
+		},
+	})
+	meta.Revision = rev
+	return meta, err
+}
+
+// discardRevision removes a revision that is published by a failed transaction.
+func (s Server) discardRevision(key string, revision int) {
+	if s.Cache != nil {
+		s.Cache.Remove(key, revision)
+	}
+	for _, l := range storeLayers(s.Store) {
+		if d, ok := l.(Discarder); ok {
+			if err := d.Discard(key, revision); err != nil {
+				PrintErr("ERROR", "failed to roll back %s#%d: %s", key, revision, err)
+			}
+			return
+		}
+	}
+	PrintErr("ERROR", "failed to roll back %s#%d: the store can not remove the latest revision", key, revision)
+}
+
+// ServeTransactions handles the staging API.
+//
+//	POST   v1/transactions              {"prefix": PREFIX} => TransactionStatus
+//	GET    v1/transactions/ID                              => TransactionStatus
+//	DELETE v1/transactions/ID                              => abort
+//	PUT    v1/transactions/ID/files/KEY BODY               => StagedArtifact
+//	POST   v1/transactions/ID/commit                       => TransactionResult
+func (s Server) ServeTransactions(path string, w http.ResponseWriter, r *http.Request) {
+	if s.Transactions == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "Transactions are not enabled on this server.")
+		return
+	}
+
+	if path == "v1/transactions" {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintln(w, "Method not allowed.")
+			return
+		}
+
+		var req struct {
+			Prefix string `json:"prefix"`
+		}
+		if err := readJSON(r, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid request: JSON with prefix is required.")
+			return
+		}
+		if !s.authorize(req.Prefix, ScopePublish, w, r) {
+			return
+		}
+
+		t := s.Transactions.Begin(req.Prefix)
+		PrintImportant("TRANSACTION", "%s begin for %q by %s", t.ID, t.Prefix, r.RemoteAddr)
+		writeJSON(w, http.StatusCreated, t.Status())
+		return
+	}
+
+	id, sub := strings.TrimPrefix(path, "v1/transactions/"), ""
+	if i := strings.Index(id, "/"); i >= 0 {
+		id, sub = id[:i], id[i+1:]
+	}
+
+	t, ok := s.Transactions.Get(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, ErrNoSuchTransaction)
+		return
+	}
+
+	switch {
+	case sub == "" && (r.Method == "GET" || r.Method == "HEAD"):
+		if !s.authorize(t.Prefix, ScopePublish, w, r) {
+			return
+		}
+		writeJSON(w, http.StatusOK, t.Status())
+	case sub == "" && r.Method == "DELETE":
+		if !s.authorize(t.Prefix, ScopePublish, w, r) {
+			return
+		}
+		if err := s.Transactions.Abort(t); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintln(w, err)
+			return
+		}
+		PrintImportant("TRANSACTION", "%s aborted by %s", t.ID, r.RemoteAddr)
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(sub, "files/") && r.Method == "PUT":
+		s.stageArtifact(t, strings.TrimPrefix(sub, "files/"), w, r)
+	case sub == "commit" && r.Method == "POST":
+		if !s.authorize(t.Prefix, ScopePublish, w, r) {
+			return
+		}
+
+		committed, err := s.commitTransaction(t)
+		if err != nil {
+			writeCommitError(t, err, w, r)
+			return
+		}
+
+		PrintImportant("TRANSACTION", "%s committed %d artifacts", t.ID, len(committed))
+		writeJSON(w, http.StatusOK, TransactionResult{t.ID, committed})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+	}
+}
+
+// writeCommitError responds the error of commitTransaction.
+func writeCommitError(t *Transaction, err error, w http.ResponseWriter, r *http.Request) {
+	if err == ErrTransactionClosed || err == ErrTransactionEmpty {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(w, err)
+	} else if errors.Is(err, ErrQuotaExceeded) {
+		PrintWarn("QUOTA", "transaction %s %s: %s", t.ID, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusInsufficientStorage)
+		fmt.Fprintln(w, err)
+	} else if verr := (ValidationError{}); errors.As(err, &verr) {
+		PrintWarn("REJECT", "transaction %s %s: %s", t.ID, r.RemoteAddr, err)
+		w.WriteHeader(verr.Status)
+		fmt.Fprintln(w, err)
+	} else {
+		PrintErr("ERROR", "transaction %s: %s", t.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+	}
+}
+
+func (s Server) stageArtifact(t *Transaction, key string, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if err := VerifyKey(key); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+	if !strings.HasPrefix(key, t.Prefix) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, ErrTransactionOutOfScope)
+		return
+	}
+	if !s.authorize(key, ScopePublish, w, r) {
+		return
+	}
+
+	labels, err := parseLabelHeaders(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+	if !s.checkPolicy(key, labels, r.ContentLength, w, r) {
+		return
+	}
+
+	var body io.Reader = r.Body
+	if s.Validator != nil {
+		body, err = s.Validator.Validate(key, r)
+		var verr ValidationError
+		if errors.As(err, &verr) {
+			PrintWarn("REJECT", "%s %s: %s", key, r.RemoteAddr, verr.Message)
+			w.WriteHeader(verr.Status)
+			fmt.Fprintln(w, verr.Message)
+			return
+		} else if err != nil {
+			PrintErr("ERROR", "%s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+	}
+
+	body, finishScan, err := s.scanBody(key, body)
+	if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+	defer finishScan()
+
+	a, err := s.Transactions.Stage(t, key, labels, body)
+	var detection ScanDetection
+	if errors.As(err, &detection) {
+		s.rejectInfected(key, detection, w, r)
+		return
+	} else if err == ErrTransactionClosed {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(w, err)
+		return
+	} else if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	PrintLog("STAGE", "%s in transaction %s", key, t.ID)
+	writeJSON(w, http.StatusCreated, a)
+}
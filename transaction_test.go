@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingStore is a LocalStore that fails to put a specific key.
+type failingStore struct {
+	*LocalStore
+	FailKey string
+}
+
+func (s failingStore) Put(key string, r io.Reader, opts PutOptions) (int, error) {
+	if key == s.FailKey {
+		return 0, errors.New("disk is full")
+	}
+	return s.LocalStore.Put(key, r, opts)
+}
+
+func newTransactionServer(t *testing.T, store Store) (Server, func(method, path, body string) *httptest.ResponseRecorder) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	token, _ := NewToken(sec, "release/")
+
+	s := Server{
+		Secret:       sec,
+		Store:        store,
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+		Transactions: NewTransactionStore(t.TempDir()),
+	}
+
+	return s, func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "bearer "+token.String())
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+}
+
+func beginTransaction(t *testing.T, request func(method, path, body string) *httptest.ResponseRecorder) string {
+	t.Helper()
+	w := request("POST", "/_api/v1/transactions", `{"prefix": "release/"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("failed to begin transaction: %d: %s", w.Code, w.Body.String())
+	}
+	var tx TransactionStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &tx); err != nil || tx.ID == "" || tx.State != TransactionOpen {
+		t.Fatalf("unexpected transaction: %s: %v", w.Body.String(), err)
+	}
+	return tx.ID
+}
+
+func TestTransaction(t *testing.T) {
+	store := &LocalStore{Path: t.TempDir()}
+	store.Put("release/app.js", strings.NewReader("old app"), PutOptions{})
+	s, request := newTransactionServer(t, store)
+
+	id := beginTransaction(t, request)
+	api := "/_api/v1/transactions/" + id
+
+	if w := request("PUT", api+"/files/release/app.js", "new app"); w.Code != http.StatusCreated {
+		t.Fatalf("failed to stage: %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("PUT", api+"/files/release/lib.js", "new lib"); w.Code != http.StatusCreated {
+		t.Fatalf("failed to stage: %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("PUT", api+"/files/other/lib.js", "other"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for key out of the prefix but got %d", w.Code)
+	}
+
+	if latest, _ := store.Latest("release/app.js"); latest != 1 {
+		t.Errorf("staged artifact should not be published before commit: latest=%d", latest)
+	}
+	if w := request("GET", "/release/lib.js", ""); w.Code != http.StatusNotFound {
+		t.Errorf("staged artifact should not be visible before commit: %d", w.Code)
+	}
+
+	w := request("POST", api+"/commit", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to commit: %d: %s", w.Code, w.Body.String())
+	}
+	var result TransactionResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if len(result.Artifacts) != 2 || result.Artifacts[0].Key != "release/app.js" || result.Artifacts[0].Revision != 2 || result.Artifacts[1].Location != "/release/lib.js?rev=1" {
+		t.Errorf("unexpected result: %s", w.Body.String())
+	}
+
+	if w := request("POST", api+"/commit", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for committed transaction but got %d", w.Code)
+	}
+
+	// The latest revisions are pinned to the old ones while a commit is in progress.
+	s.Transactions.pin(store, []string{"release/app.js", "release/new.js"})
+	if rev, err := s.latest("release/app.js"); rev != 2 || err != nil {
+		t.Errorf("unexpected pinned revision: %d, %v", rev, err)
+	}
+	if _, err := s.latest("release/new.js"); err != ErrNoSuchArtifact {
+		t.Errorf("new key should not be visible while committing: %v", err)
+	}
+	s.Transactions.unpin([]string{"release/app.js", "release/new.js"})
+
+	id = beginTransaction(t, request)
+	api = "/_api/v1/transactions/" + id
+	request("PUT", api+"/files/release/app.js", "aborted app")
+	if w := request("DELETE", api, ""); w.Code != http.StatusNoContent {
+		t.Errorf("failed to abort: %d: %s", w.Code, w.Body.String())
+	}
+	if w := request("PUT", api+"/files/release/app.js", "aborted app"); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for aborted transaction but got %d", w.Code)
+	}
+	if latest, _ := store.Latest("release/app.js"); latest != 2 {
+		t.Errorf("aborted transaction should not publish anything: latest=%d", latest)
+	}
+
+	if w := request("GET", "/_api/v1/transactions/unknown", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown transaction but got %d", w.Code)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	local := &LocalStore{Path: t.TempDir()}
+	local.Put("release/a.js", strings.NewReader("old a"), PutOptions{})
+	_, request := newTransactionServer(t, failingStore{local, "release/b.js"})
+
+	id := beginTransaction(t, request)
+	api := "/_api/v1/transactions/" + id
+	request("PUT", api+"/files/release/a.js", "new a")
+	request("PUT", api+"/files/release/b.js", "new b")
+
+	if w := request("POST", api+"/commit", ""); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 but got %d: %s", w.Code, w.Body.String())
+	}
+
+	if latest, _ := local.Latest("release/a.js"); latest != 1 {
+		t.Errorf("revision of failed transaction should be removed: latest=%d", latest)
+	}
+	if w := request("GET", api, ""); !strings.Contains(w.Body.String(), TransactionAborted) {
+		t.Errorf("failed transaction should be aborted: %s", w.Body.String())
+	}
+}
+
+func TestCommonDir(t *testing.T) {
+	tests := []struct {
+		Prefix string
+		Keys   []string
+		Dir    string
+	}{
+		{"release/", []string{"app.js", "lib/util.js"}, "release/"},
+		{"", []string{"release/1.0/app.js", "release/1.0/lib/util.js"}, "release/1.0/"},
+		{"", []string{"release/app.js", "docs/index.html"}, ""},
+		{"", []string{"app.js"}, ""},
+	}
+	for _, tt := range tests {
+		if d := commonDir(tt.Prefix, tt.Keys); d != tt.Dir {
+			t.Errorf("%q %v: expected %q but got %q", tt.Prefix, tt.Keys, tt.Dir, d)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// TypeMap is a mapping from file extensions to content types, that extends the system MIME database.
+//
+//	types:
+//	  .foo: application/x-foo
+//	  .log: text/plain; charset=shift_jis
+//
+// Text types without charset are treated as UTF-8.
+type TypeMap map[string]string
+
+func ParseTypeMap(data []byte) (TypeMap, error) {
+	var conf struct {
+		Types TypeMap `yaml:"types"`
+	}
+	if err := yaml.UnmarshalStrict(data, &conf); err != nil {
+		return nil, fmt.Errorf("Invalid types: %s", err)
+	}
+
+	m := make(TypeMap, len(conf.Types))
+	for ext, typ := range conf.Types {
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.ContainsAny(ext, "/ ") {
+			return nil, fmt.Errorf("Invalid types: extension %q: it should start with dot, such as \".txt\".", ext)
+		}
+		if _, _, err := mime.ParseMediaType(typ); err != nil {
+			return nil, fmt.Errorf("Invalid types: extension %q: invalid type %q.", ext, typ)
+		}
+		m[strings.ToLower(ext)] = typ
+	}
+	return m, nil
+}
+
+func LoadTypeMap(path string) (TypeMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTypeMap(data)
+}
+
+// Register adds the mapping to the MIME database of the process, so that the types are used on publish before detecting the type from the content.
+func (m TypeMap) Register() error {
+	exts := make([]string, 0, len(m))
+	for ext := range m {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	for _, ext := range exts {
+		if err := mime.AddExtensionType(ext, m[ext]); err != nil {
+			return fmt.Errorf("Invalid types: extension %q: %s", ext, err)
+		}
+	}
+	return nil
+}
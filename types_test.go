@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTypeMap_Invalid(t *testing.T) {
+	tests := []string{
+		`types: {foo: text/plain}`,
+		`types: {.: text/plain}`,
+		`types: {.foo: "text/"}`,
+		`others: {.foo: text/plain}`,
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseTypeMap([]byte(tt)); err == nil {
+			t.Errorf("expected error but got nil: %s", tt)
+		}
+	}
+}
+
+func TestTypeMap(t *testing.T) {
+	m, err := ParseTypeMap([]byte(`
+types:
+  .ArtistoreTestA: application/x-artistore-test
+  .artistoretestb: text/x-artistore-test
+  .artistoretestc: text/x-artistore-test; charset=shift_jis
+`))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if err := m.Register(); err != nil {
+		t.Fatalf("failed to register: %s", err)
+	}
+
+	tests := []struct {
+		Key  string
+		Type string
+	}{
+		{"data.artistoretesta", "application/x-artistore-test"},
+		{"data.artistoretestb", "text/x-artistore-test; charset=utf-8"},
+		{"data.artistoretestc", "text/x-artistore-test; charset=shift_jis"},
+		{"data.unknownext", "text/plain; charset=utf-8"},
+	}
+
+	store := &LocalStore{Path: t.TempDir()}
+	for _, tt := range tests {
+		rev, err := store.Put(tt.Key, strings.NewReader("hello world"), PutOptions{})
+		if err != nil {
+			t.Fatalf("%s: failed to put: %s", tt.Key, err)
+		}
+		meta, err := store.Metadata(tt.Key, rev)
+		if err != nil {
+			t.Fatalf("%s: failed to get metadata: %s", tt.Key, err)
+		}
+		if meta.Type != tt.Type {
+			t.Errorf("%s: expected %q but got %q", tt.Key, tt.Type, meta.Type)
+		}
+	}
+}
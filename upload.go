@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoSuchUpload = errors.New("No such upload on this server.")
+
+// UploadRetention is the duration to keep finished uploads in UploadTracker, so that dashboards can see the result.
+var UploadRetention = time.Minute
+
+// Upload is an artifact that is being received by the server.
+type Upload struct {
+	ID      string
+	Key     string
+	Total   int64
+	Started time.Time
+
+	received int64
+
+	lock     sync.Mutex
+	finished time.Time
+	err      error
+	revision int
+}
+
+// UploadStatus is the progress of an Upload.
+type UploadStatus struct {
+	ID       string     `json:"id"`
+	Key      string     `json:"key"`
+	State    string     `json:"state"`
+	Received int64      `json:"received"`
+	Total    int64      `json:"total"`
+	Started  time.Time  `json:"started_at"`
+	Finished *time.Time `json:"finished_at,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Revision int        `json:"revision,omitempty"`
+}
+
+type UploadList struct {
+	Uploads    []UploadStatus `json:"uploads"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type uploadBody struct {
+	io.ReadCloser
+	u *Upload
+}
+
+func (b uploadBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.u.received, int64(n))
+	return n, err
+}
+
+// Body wraps request body to count received bytes.
+func (u *Upload) Body(body io.ReadCloser) io.ReadCloser {
+	return uploadBody{body, u}
+}
+
+// Status reports the progress. Total is -1 if the size is unknown.
+func (u *Upload) Status() UploadStatus {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	s := UploadStatus{
+		ID:       u.ID,
+		Key:      u.Key,
+		State:    "receiving",
+		Received: atomic.LoadInt64(&u.received),
+		Total:    u.Total,
+		Started:  u.Started,
+	}
+	if !u.finished.IsZero() {
+		finished := u.finished
+		s.Finished = &finished
+		if u.err != nil {
+			s.State = "failed"
+			s.Error = u.err.Error()
+		} else {
+			s.State = "done"
+			s.Revision = u.revision
+		}
+	}
+	return s
+}
+
+// UploadTracker records progress of uploads in memory.
+type UploadTracker struct {
+	lock sync.Mutex
+	m    map[string]*Upload
+}
+
+func NewUploadTracker() *UploadTracker {
+	return &UploadTracker{m: make(map[string]*Upload)}
+}
+
+// uploadID uses the Idempotency-Key of the client if it is usable in URL, so that the client can know the ID before the upload finishes.
+func uploadID(idempotencyKey string) string {
+	if idempotencyKey != "" && len(idempotencyKey) <= 128 && !strings.ContainsAny(idempotencyKey, "/?#%") {
+		return idempotencyKey
+	}
+	return NewID()
+}
+
+// Start registers a new upload.
+func (t *UploadTracker) Start(id, key string, total int64) *Upload {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.expire()
+
+	u := &Upload{ID: id, Key: key, Total: total, Started: time.Now()}
+	t.m[id] = u
+	return u
+}
+
+// SetRevision records the revision that the upload published.
+func (u *Upload) SetRevision(revision int) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.revision = revision
+}
+
+// Finish marks the upload as finished. err is nil if the upload was published successfully.
+func (t *UploadTracker) Finish(u *Upload, err error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.finished = time.Now()
+	u.err = err
+}
+
+func (t *UploadTracker) Get(id string) (*Upload, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.expire()
+
+	u, ok := t.m[id]
+	return u, ok
+}
+
+// List returns all uploads in order of start time.
+func (t *UploadTracker) List() []UploadStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.expire()
+
+	xs := make([]UploadStatus, 0, len(t.m))
+	for _, u := range t.m {
+		xs = append(xs, u.Status())
+	}
+	sort.Slice(xs, func(i, j int) bool {
+		return xs[i].Started.Before(xs[j].Started)
+	})
+	return xs
+}
+
+func (t *UploadTracker) expire() {
+	for id, u := range t.m {
+		u.lock.Lock()
+		finished := u.finished
+		u.lock.Unlock()
+
+		if !finished.IsZero() && time.Since(finished) > UploadRetention {
+			delete(t.m, id)
+		}
+	}
+}
+
+func (s Server) ServeUploads(path string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	if path == "v1/uploads" {
+		if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+			return
+		}
+
+		q, err := ParseQuery(r.URL.Query(), []string{"started", "key"}, false)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
+			return
+		}
+
+		xs := s.Uploads.List()
+		q.SortBy(len(xs), func(i, j int) int {
+			if q.Sort == "key" {
+				return strings.Compare(xs[i].Key, xs[j].Key)
+			}
+			return compareTime(xs[i].Started, xs[j].Started)
+		}, func(i, j int) {
+			xs[i], xs[j] = xs[j], xs[i]
+		})
+
+		start, end, next := q.Page(len(xs))
+		writeJSON(w, http.StatusOK, UploadList{xs[start:end], next})
+		return
+	}
+
+	// Unknown uploads are reported without authorization, so that a publisher can know that the server did not receive the request.
+	// IDs are random enough, so this does not leak anything.
+	u, ok := s.Uploads.Get(strings.TrimPrefix(path, "v1/uploads/"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, ErrNoSuchUpload)
+		return
+	}
+
+	// The publisher can see its own upload by the token for the key, as well as admins.
+	key := u.Key
+	if token, err := ParseToken(strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "bearer "))); err == nil && IsCorrentToken(s.Secret, token, APIPrefix+path) {
+		key = APIPrefix + path
+	}
+	if !s.authorize(key, ScopePublish, w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, u.Status())
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadTracker(t *testing.T) {
+	tracker := NewUploadTracker()
+
+	u := tracker.Start("abc", "hello.txt", 11)
+	body := u.Body(io.NopCloser(strings.NewReader("hello world")))
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(body, buf); err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+
+	got, ok := tracker.Get("abc")
+	if !ok {
+		t.Fatalf("upload should be found")
+	}
+	if s := got.Status(); s.State != "receiving" || s.Received != 5 || s.Total != 11 || s.Finished != nil {
+		t.Errorf("unexpected status: %#v", s)
+	}
+
+	io.Copy(io.Discard, body)
+	tracker.Finish(u, nil)
+	if s := u.Status(); s.State != "done" || s.Received != 11 || s.Finished == nil {
+		t.Errorf("unexpected status: %#v", s)
+	}
+
+	failed := tracker.Start("def", "world.txt", -1)
+	tracker.Finish(failed, errors.New("something wrong"))
+	if s := failed.Status(); s.State != "failed" || s.Error != "something wrong" {
+		t.Errorf("unexpected status: %#v", s)
+	}
+
+	if xs := tracker.List(); len(xs) != 2 || xs[0].ID != "abc" || xs[1].ID != "def" {
+		t.Errorf("unexpected list: %#v", xs)
+	}
+
+	defer func(d time.Duration) { UploadRetention = d }(UploadRetention)
+	UploadRetention = 0
+	time.Sleep(time.Millisecond)
+
+	tracker.Start("ghi", "foo.txt", 0)
+	if xs := tracker.List(); len(xs) != 1 || xs[0].ID != "ghi" {
+		t.Errorf("finished uploads should be expired: %#v", xs)
+	}
+}
+
+func TestUploadID(t *testing.T) {
+	if id := uploadID("0123abcd"); id != "0123abcd" {
+		t.Errorf("expected Idempotency-Key is used as ID but got %q", id)
+	}
+
+	for _, key := range []string{"", "a/b", strings.Repeat("a", 129)} {
+		if id := uploadID(key); id == key || len(id) != 26 {
+			t.Errorf("%q: expected random ID but got %q", key, id)
+		}
+	}
+}
+
+func TestServeUpload(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	admin, _ := NewToken(sec, APIPrefix)
+	publisher, _ := NewToken(sec, "release/")
+	other, _ := NewToken(sec, "nightly/")
+
+	s := Server{Secret: sec, Uploads: NewUploadTracker()}
+	s.Uploads.Start("abc", "release/app.tar.gz", 100)
+
+	tests := []struct {
+		ID     string
+		Token  Token
+		Status int
+	}{
+		{"abc", admin, http.StatusOK},
+		{"abc", publisher, http.StatusOK},
+		{"abc", other, http.StatusForbidden},
+		{"abc", nil, http.StatusForbidden},
+		{"unknown", nil, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/_api/v1/uploads/"+tt.ID, nil)
+		if tt.Token != nil {
+			r.Header.Set("Authorization", "bearer "+tt.Token.String())
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != tt.Status {
+			t.Errorf("%s with %v: expected %d but got %d", tt.ID, tt.Token, tt.Status, w.Code)
+		}
+	}
+}
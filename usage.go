@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var duCmd = &cobra.Command{
+	Use:   "du [PREFIX]",
+	Short: "Show disk usage of artifacts",
+	Long: `Show disk usage of artifacts.
+
+This command shows the total size of all revisions under the PREFIX, and the breakdown by the directories under it.
+SIZE is the size of the original contents, and STORED is the size on the disk of the server after compression.
+STORED is shown as "-" if the store of the server can not report it.
+
+Use --depth to break down by deeper directories, such as "team/project/".
+With --quiet, the header line is omitted.
+This command requires admin token. See also 'artistore help token'.`,
+	Example: `  $ export ARTISTORE_TOKEN=$(artistore token --admin)
+  $ artistore du
+  $ artistore du --depth 2 release/`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		prefix := ""
+		if len(args) > 0 {
+			prefix = args[0]
+		}
+
+		format, err := getOutputFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		t, err := NewTokenHandler()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		token, err := t.TokenFor(APIPrefix)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		u, err := GetAPIURL("v1/usage")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		depth, _ := cmd.Flags().GetInt("depth")
+		u.RawQuery = url.Values{"prefix": {prefix}, "depth": {strconv.Itoa(depth)}}.Encode()
+
+		client, err := NewClient()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		var report UsageReport
+		if err := client.CallAPI("GET", u, token, nil, &report); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if format == OutputJSON {
+			printJSON(report)
+			return
+		}
+
+		if format != OutputQuiet {
+			fmt.Printf("%10s  %10s  %9s  %6s  %s\n", "SIZE", "STORED", "REVISIONS", "KEYS", "PREFIX")
+		}
+		for _, x := range append(report.Prefixes, report.Total) {
+			stored := "-"
+			if x.Stored != nil {
+				stored = FormatSize(*x.Stored)
+			}
+			name := x.Prefix
+			if name == "" {
+				name = "(total)"
+			}
+			fmt.Printf("%10s  %10s  %9d  %6d  %s\n", FormatSize(x.Size), stored, x.Revisions, x.Keys, name)
+		}
+	},
+}
+
+func init() {
+	cmd.AddCommand(duCmd)
+
+	duCmd.Flags().String("server", "http://localhost:3000", "URL for Artistore server.")
+	duCmd.Flags().String("secret", "", "Server secret. See also 'artistore help secret'.")
+	duCmd.Flags().String("token", "", "Admin token. See also 'artistore help token'.")
+	duCmd.Flags().Int("depth", 1, "Number of directory levels under the PREFIX to break down.")
+	addOutputFlags(duCmd)
+}
+
+func (s *LocalStore) DiskSize(key string, revision int) (int64, error) {
+	return s.revisionDiskSize(key, revision), nil
+}
+
+// Usage is the disk usage of keys under Prefix.
+type Usage struct {
+	Prefix    string `json:"prefix"`
+	Keys      int    `json:"keys"`
+	Revisions int    `json:"revisions"`
+
+	// Size is the total size of the original contents.
+	Size int64 `json:"size"`
+
+	// Stored is the total size on the disk. It is nil if the store does not implement DiskUsager.
+	Stored *int64 `json:"stored,omitempty"`
+}
+
+func (u *Usage) add(metas []Metadata, stored int64, hasStored bool) {
+	u.Keys++
+	u.Revisions += len(metas)
+	for _, m := range metas {
+		u.Size += int64(m.Size)
+	}
+	if hasStored {
+		if u.Stored == nil {
+			u.Stored = new(int64)
+		}
+		*u.Stored += stored
+	}
+}
+
+type UsageReport struct {
+	Total    Usage   `json:"total"`
+	Prefixes []Usage `json:"prefixes"`
+}
+
+// usagePrefix returns the prefix of key in depth directories under prefix.
+// It returns the key itself if the key is not in such deep directory.
+func usagePrefix(prefix, key string, depth int) string {
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+	rest := key[len(dir):]
+
+	end := 0
+	for i := 0; i < depth; i++ {
+		n := strings.Index(rest[end:], "/")
+		if n < 0 {
+			return key
+		}
+		end += n + 1
+	}
+	return dir + rest[:end]
+}
+
+// CalculateUsage sums up sizes of all revisions under prefix, and breaks down by directories in depth levels.
+func CalculateUsage(store Store, prefix string, depth int) (UsageReport, error) {
+	report := UsageReport{Total: Usage{Prefix: prefix}, Prefixes: []Usage{}}
+
+	var usager DiskUsager
+	for _, l := range storeLayers(store) {
+		if u, ok := l.(DiskUsager); ok {
+			usager = u
+			break
+		}
+	}
+
+	keys, err := store.List(prefix)
+	if err != nil {
+		return report, err
+	}
+
+	groups := make(map[string]*Usage)
+	for _, key := range keys {
+		metas, err := store.Revisions(key)
+		if err == ErrNoSuchArtifact {
+			continue
+		} else if err != nil {
+			return report, err
+		}
+
+		var stored int64
+		if usager != nil {
+			for _, m := range metas {
+				n, err := usager.DiskSize(key, m.Revision)
+				if err != nil {
+					return report, err
+				}
+				stored += n
+			}
+		}
+
+		report.Total.add(metas, stored, usager != nil)
+
+		if depth > 0 {
+			p := usagePrefix(prefix, key, depth)
+			g, ok := groups[p]
+			if !ok {
+				g = &Usage{Prefix: p}
+				groups[p] = g
+			}
+			g.add(metas, stored, usager != nil)
+		}
+	}
+
+	for _, g := range groups {
+		report.Prefixes = append(report.Prefixes, *g)
+	}
+	sort.Slice(report.Prefixes, func(i, j int) bool {
+		return report.Prefixes[i].Prefix < report.Prefixes[j].Prefix
+	})
+
+	return report, nil
+}
+
+func (s Server) Usage(path string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+		return
+	}
+
+	if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+		return
+	}
+
+	depth := 1
+	if raw := r.URL.Query().Get("depth"); raw != "" {
+		var err error
+		depth, err = strconv.Atoi(raw)
+		if err != nil || depth < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Invalid depth: it should be 0 or a positive integer.")
+			return
+		}
+	}
+
+	report, err := CalculateUsage(s.Store, r.URL.Query().Get("prefix"), depth)
+	if err != nil {
+		PrintErr("ERROR", "%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, InternalServerErrorMessage)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
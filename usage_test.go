@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUsagePrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		key    string
+		depth  int
+		want   string
+	}{
+		{"", "team-a/app/v1.tar.gz", 1, "team-a/"},
+		{"", "team-a/app/v1.tar.gz", 2, "team-a/app/"},
+		{"", "team-a/app/v1.tar.gz", 3, "team-a/app/v1.tar.gz"},
+		{"", "hello.txt", 1, "hello.txt"},
+		{"team-a/", "team-a/app/v1.tar.gz", 1, "team-a/app/"},
+		{"team-a/a", "team-a/app/v1.tar.gz", 1, "team-a/app/"},
+	}
+
+	for _, tt := range tests {
+		if got := usagePrefix(tt.prefix, tt.key, tt.depth); got != tt.want {
+			t.Errorf("usagePrefix(%q, %q, %d): expected %q but got %q", tt.prefix, tt.key, tt.depth, tt.want, got)
+		}
+	}
+}
+
+func TestUsageAPI(t *testing.T) {
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	admin, _ := NewToken(sec, APIPrefix)
+
+	store := &LocalStore{Path: t.TempDir()}
+	for _, key := range []string{"team-a/x.txt", "team-a/x.txt", "team-a/y.txt", "team-b/z.txt", "top.txt"} {
+		if _, err := store.Put(key, strings.NewReader(strings.Repeat("hello ", 100)), PutOptions{}); err != nil {
+			t.Fatalf("failed to publish %s: %s", key, err)
+		}
+	}
+
+	s := Server{Secret: sec, Store: store}
+	request := func(path string, token Token) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Authorization", "bearer "+token.String())
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	user, _ := NewToken(sec, "team-a/")
+	if w := request("/_api/v1/usage", user); w.Code != http.StatusForbidden {
+		t.Errorf("usage API should require admin token: %d", w.Code)
+	}
+	if w := request("/_api/v1/usage?depth=-1", admin); w.Code != http.StatusBadRequest {
+		t.Errorf("negative depth should be rejected: %d", w.Code)
+	}
+
+	w := request("/_api/v1/usage", admin)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to get usage: %d: %s", w.Code, w.Body.String())
+	}
+
+	var report UsageReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to parse response: %s", err)
+	}
+
+	if report.Total.Keys != 4 || report.Total.Revisions != 5 || report.Total.Size != 5*600 {
+		t.Errorf("unexpected total: %#v", report.Total)
+	}
+	if report.Total.Stored == nil || *report.Total.Stored <= 0 || *report.Total.Stored >= report.Total.Size {
+		t.Errorf("stored size should be smaller than content size because of compression: %v", report.Total.Stored)
+	}
+
+	var names []string
+	for _, p := range report.Prefixes {
+		names = append(names, p.Prefix)
+	}
+	if strings.Join(names, ",") != "team-a/,team-b/,top.txt" {
+		t.Fatalf("unexpected prefixes: %v", names)
+	}
+	if a := report.Prefixes[0]; a.Keys != 2 || a.Revisions != 3 || a.Size != 3*600 {
+		t.Errorf("unexpected usage of team-a/: %#v", a)
+	}
+
+	w = request("/_api/v1/usage?prefix=team-b/&depth=0", admin)
+	report = UsageReport{}
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if report.Total.Prefix != "team-b/" || report.Total.Keys != 1 || len(report.Prefixes) != 0 {
+		t.Errorf("unexpected usage of team-b/: %#v", report)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ValidationRequest struct {
+	Key        string `json:"key"`
+	Type       string `json:"type"`
+	Size       int64  `json:"size"`
+	Head       []byte `json:"head"`
+	RemoteAddr string `json:"remote_addr"`
+}
+
+type ValidationError struct {
+	Status  int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Message
+}
+
+type ValidationWebhook struct {
+	URL      string
+	HeadSize int
+	Client   *http.Client
+
+	// Secret signs requests in the same way as event webhooks if set. See also WebhookSignatureHeader.
+	Secret []byte
+}
+
+func NewValidationWebhook(url string, headSize int) *ValidationWebhook {
+	return &ValidationWebhook{
+		URL:      url,
+		HeadSize: headSize,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Validate asks the webhook whether the upload in r may be published as key.
+// The returned reader yields the whole body again, including the bytes that were sent to the webhook.
+func (v *ValidationWebhook) Validate(key string, r *http.Request) (io.Reader, error) {
+	head := make([]byte, v.HeadSize)
+	n, err := io.ReadFull(r.Body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	sniff := head
+	if len(sniff) > 512 {
+		sniff = sniff[:512]
+	}
+
+	payload, err := json.Marshal(ValidationRequest{
+		Key:        key,
+		Type:       detectContentType(key, sniff),
+		Size:       r.ContentLength,
+		Head:       head,
+		RemoteAddr: r.RemoteAddr,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", v.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(v.Secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, signWebhookPayload(v.Secret, payload))
+	}
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call validation webhook: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if len(bytes.TrimSpace(msg)) == 0 {
+			msg = []byte("Rejected by validation webhook.")
+		}
+		return nil, ValidationError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	} else if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("validation webhook responded unexpected status: %s", resp.Status)
+	}
+
+	return io.MultiReader(bytes.NewReader(head), r.Body), nil
+}
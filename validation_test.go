@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidationWebhook(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if sig := r.Header.Get(WebhookSignatureHeader); sig != signWebhookPayload([]byte("secret"), body) {
+			t.Errorf("unexpected signature: %s", sig)
+		}
+
+		var req ValidationRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("failed to decode request: %s", err)
+			return
+		}
+		if len(req.Head) > 4 {
+			t.Errorf("head should be at most 4 bytes but got %q", req.Head)
+		}
+		if strings.HasPrefix(req.Key, "forbidden/") {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, "forbidden prefix\n")
+		}
+	}))
+	defer hook.Close()
+
+	v := NewValidationWebhook(hook.URL, 4)
+	v.Secret = []byte("secret")
+
+	tests := []struct {
+		Key    string
+		Body   string
+		Status int
+	}{
+		{"hello.txt", "hello world", 0},
+		{"short.txt", "hi", 0},
+		{"forbidden/hello.txt", "hello world", http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/"+tt.Key, strings.NewReader(tt.Body))
+
+		body, err := v.Validate(tt.Key, r)
+		if tt.Status != 0 {
+			verr, ok := err.(ValidationError)
+			if !ok {
+				t.Errorf("%s: expected validation error but got %v", tt.Key, err)
+			} else if verr.Status != tt.Status || verr.Message != "forbidden prefix" {
+				t.Errorf("%s: unexpected error: %d %q", tt.Key, verr.Status, verr.Message)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: failed to validate: %s", tt.Key, err)
+		}
+
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("%s: failed to read body: %s", tt.Key, err)
+		}
+		if string(data) != tt.Body {
+			t.Errorf("%s: expected body %q but got %q", tt.Key, tt.Body, data)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	WebhookQueueSize  = 10000
+	WebhookRetryWait  = time.Second
+	WebhookMaxBackoff = 5 * time.Minute
+)
+
+var (
+	ErrNoSuchDelivery = errors.New("No such failed delivery.")
+)
+
+// WebhookSignatureHeader is the header that has HMAC-SHA256 of the request body by the webhook secret, such as "sha256=0123...".
+// Receivers should compare it with their own HMAC in constant time.
+const WebhookSignatureHeader = "X-Artistore-Signature-256"
+
+// WebhookEvent is the payload of event webhooks.
+type WebhookEvent struct {
+	// ID is the same for all deliveries of the event including retries and replays, so that receivers can drop duplicates.
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Key       string    `json:"key"`
+	Revision  int       `json:"revision"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// Reason is the reason of "rejected" events.
+	Reason string `json:"reason,omitempty"`
+}
+
+// FailedDelivery is an event that could not be delivered after all attempts.
+// It is recorded in the dead-letter log, and can be replayed by the admin API.
+type FailedDelivery struct {
+	URL      string       `json:"url"`
+	Event    WebhookEvent `json:"event"`
+	Attempts int          `json:"attempts"`
+	Error    string       `json:"error"`
+	FailedAt time.Time    `json:"failed_at"`
+}
+
+// signWebhookPayload returns the value of WebhookSignatureHeader.
+func signWebhookPayload(secret, payload []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write(payload)
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Webhooks delivers events to the URLs at least once.
+//
+// Each URL has its own queue, and events are sent in order.
+// Failed deliveries are retried with exponential backoff, and recorded in the dead-letter log after MaxAttempts attempts.
+type Webhooks struct {
+	Secret      []byte
+	MaxAttempts int
+	Client      *http.Client
+
+	// DeadLetter is the path to the dead-letter log in JSON Lines. Failed deliveries are kept only on memory if empty.
+	DeadLetter string
+
+	targets []*webhookTarget
+
+	lock   sync.Mutex
+	failed []FailedDelivery
+}
+
+type webhookTarget struct {
+	URL string
+
+	lock  sync.Mutex
+	cond  *sync.Cond
+	queue []WebhookEvent
+}
+
+// NewWebhooks makes Webhooks, and loads the dead-letter log if exists.
+// Call Start to start delivery.
+func NewWebhooks(urls []string, secret []byte, maxAttempts int, deadLetter string) (*Webhooks, error) {
+	w := &Webhooks{
+		Secret:      secret,
+		MaxAttempts: maxAttempts,
+		Client:      &http.Client{Timeout: 30 * time.Second},
+		DeadLetter:  deadLetter,
+	}
+	for _, u := range urls {
+		t := &webhookTarget{URL: u}
+		t.cond = sync.NewCond(&t.lock)
+		w.targets = append(w.targets, t)
+	}
+
+	if deadLetter != "" {
+		failed, err := readDeadLetter(deadLetter)
+		if err != nil {
+			return nil, err
+		}
+		w.failed = failed
+	}
+
+	return w, nil
+}
+
+func readDeadLetter(path string) ([]FailedDelivery, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var failed []FailedDelivery
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var d FailedDelivery
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		failed = append(failed, d)
+	}
+	return failed, scanner.Err()
+}
+
+func (w *Webhooks) Start() {
+	for _, t := range w.targets {
+		go w.run(t)
+	}
+}
+
+// Send enqueues the event to all URLs.
+func (w *Webhooks) Send(typ string, meta Metadata) {
+	if w == nil {
+		return
+	}
+
+	w.send(WebhookEvent{
+		ID:        NewID(),
+		Type:      typ,
+		Key:       meta.Key,
+		Revision:  meta.Revision,
+		Timestamp: time.Now(),
+	})
+}
+
+// SendRejected enqueues "rejected" event, that is an upload rejected before it becomes visible.
+func (w *Webhooks) SendRejected(key, reason string) {
+	if w == nil {
+		return
+	}
+
+	w.send(WebhookEvent{
+		ID:        NewID(),
+		Type:      "rejected",
+		Key:       key,
+		Timestamp: time.Now(),
+		Reason:    reason,
+	})
+}
+
+func (w *Webhooks) send(e WebhookEvent) {
+	for _, t := range w.targets {
+		t.enqueue(e)
+	}
+}
+
+func (t *webhookTarget) enqueue(e WebhookEvent) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.queue) >= WebhookQueueSize {
+		dropped := t.queue[0]
+		t.queue = t.queue[1:]
+		PrintErr("WEBHOOK", "queue for %s is full. drop %s %s#%d", t.URL, dropped.Type, dropped.Key, dropped.Revision)
+	}
+
+	t.queue = append(t.queue, e)
+	t.cond.Signal()
+}
+
+func (t *webhookTarget) next() WebhookEvent {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for len(t.queue) == 0 {
+		t.cond.Wait()
+	}
+	return t.queue[0]
+}
+
+func (t *webhookTarget) done() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.queue = t.queue[1:]
+}
+
+func (w *Webhooks) run(t *webhookTarget) {
+	for {
+		e := t.next()
+
+		wait := WebhookRetryWait
+		for attempt := 1; ; attempt++ {
+			err := w.deliver(t.URL, e)
+			if err == nil {
+				PrintLog("WEBHOOK", "%s %s#%d -> %s", e.Type, e.Key, e.Revision, t.URL)
+				break
+			}
+
+			if attempt >= w.MaxAttempts {
+				PrintErr("WEBHOOK", "%s %s#%d to %s: %s (give up after %d attempts)", e.Type, e.Key, e.Revision, t.URL, err, attempt)
+				w.addFailed(FailedDelivery{t.URL, e, attempt, err.Error(), time.Now()})
+				break
+			}
+
+			PrintWarn("WEBHOOK", "%s %s#%d to %s: %s (retry after %s)", e.Type, e.Key, e.Revision, t.URL, err, wait)
+			time.Sleep(wait)
+			wait *= 2
+			if wait > WebhookMaxBackoff {
+				wait = WebhookMaxBackoff
+			}
+		}
+
+		t.done()
+	}
+}
+
+func (w *Webhooks) deliver(url string, e WebhookEvent) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Artistore-Event", e.Type)
+	req.Header.Set("X-Artistore-Delivery", e.ID)
+	req.Header.Set(WebhookSignatureHeader, signWebhookPayload(w.Secret, payload))
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+func (w *Webhooks) addFailed(d FailedDelivery) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.failed = append(w.failed, d)
+
+	if w.DeadLetter == "" {
+		return
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		PrintErr("WEBHOOK", "failed to write dead-letter log: %s", err)
+		return
+	}
+
+	f, err := os.OpenFile(w.DeadLetter, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		PrintErr("WEBHOOK", "failed to write dead-letter log: %s", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		PrintErr("WEBHOOK", "failed to write dead-letter log: %s", err)
+	}
+}
+
+// Failed returns failed deliveries in the order of failure.
+func (w *Webhooks) Failed() []FailedDelivery {
+	if w == nil {
+		return []FailedDelivery{}
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return append([]FailedDelivery{}, w.failed...)
+}
+
+// Replay enqueues failed deliveries again, and removes them from the dead-letter log.
+// All failed deliveries are replayed if id is empty.
+func (w *Webhooks) Replay(id string) (replayed []FailedDelivery, err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var rest []FailedDelivery
+	for _, d := range w.failed {
+		if id == "" || d.Event.ID == id {
+			replayed = append(replayed, d)
+		} else {
+			rest = append(rest, d)
+		}
+	}
+	if id != "" && len(replayed) == 0 {
+		return nil, ErrNoSuchDelivery
+	}
+
+	if w.DeadLetter != "" {
+		var buf bytes.Buffer
+		for _, d := range rest {
+			data, err := json.Marshal(d)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(append(data, '\n'))
+		}
+		if err := os.WriteFile(w.DeadLetter+".tmp", buf.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(w.DeadLetter+".tmp", w.DeadLetter); err != nil {
+			return nil, err
+		}
+	}
+	w.failed = rest
+
+	for _, d := range replayed {
+		found := false
+		for _, t := range w.targets {
+			if t.URL == d.URL {
+				t.enqueue(d.Event)
+				found = true
+			}
+		}
+		if !found {
+			PrintWarn("WEBHOOK", "%s is not configured anymore. drop %s %s#%d", d.URL, d.Event.Type, d.Event.Key, d.Event.Revision)
+		}
+	}
+
+	return replayed, nil
+}
+
+type WebhookReplayResult struct {
+	Replayed []FailedDelivery `json:"replayed"`
+}
+
+func (s Server) ServeWebhooks(path string, w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(APIPrefix+path, ScopeAdmin, w, r) {
+		return
+	}
+
+	switch {
+	case path == "v1/webhooks/failed" && (r.Method == "GET" || r.Method == "HEAD"):
+		writeJSON(w, http.StatusOK, s.Webhooks.Failed())
+	case path == "v1/webhooks/replay" && r.Method == "POST":
+		if s.Webhooks == nil {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintln(w, "Webhooks are not enabled on this server. Please start server with --webhook flag.")
+			return
+		}
+
+		replayed, err := s.Webhooks.Replay(strings.TrimSpace(r.URL.Query().Get("id")))
+		if err == ErrNoSuchDelivery {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, err)
+			return
+		} else if err != nil {
+			PrintErr("ERROR", "failed to replay webhooks: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, InternalServerErrorMessage)
+			return
+		}
+
+		PrintImportant("WEBHOOK", "replay %d deliveries by %s", len(replayed), r.RemoteAddr)
+		if replayed == nil {
+			replayed = []FailedDelivery{}
+		}
+		writeJSON(w, http.StatusOK, WebhookReplayResult{replayed})
+	case path == "v1/webhooks/failed" || path == "v1/webhooks/replay":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(w, "Method not allowed.")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "No such API.")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	defer func(wait time.Duration) { WebhookRetryWait = wait }(WebhookRetryWait)
+	WebhookRetryWait = time.Millisecond
+
+	secret := []byte("webhook-secret")
+
+	var failing int32 = 1
+	var lock sync.Mutex
+	var received []WebhookEvent
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if sig := r.Header.Get(WebhookSignatureHeader); sig != signWebhookPayload(secret, body) {
+			t.Errorf("unexpected signature: %s", sig)
+		}
+
+		if atomic.LoadInt32(&failing) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var e WebhookEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Errorf("failed to decode event: %s", err)
+		}
+		if r.Header.Get("X-Artistore-Delivery") != e.ID || r.Header.Get("X-Artistore-Event") != e.Type {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+
+		lock.Lock()
+		received = append(received, e)
+		lock.Unlock()
+	}))
+	defer hook.Close()
+
+	deadLetter := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	webhooks, err := NewWebhooks([]string{hook.URL}, secret, 3, deadLetter)
+	if err != nil {
+		t.Fatalf("failed to make webhooks: %s", err)
+	}
+	webhooks.Start()
+
+	sec, err := NewSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %s", err)
+	}
+	ts := httptest.NewServer(Server{
+		Secret:       sec,
+		Store:        &LocalStore{Path: t.TempDir()},
+		Expectations: NewExpectationStore(),
+		Uploads:      NewUploadTracker(),
+		Webhooks:     webhooks,
+	})
+	defer ts.Close()
+
+	token, _ := NewToken(sec, "hello.txt")
+	req, _ := http.NewRequest("POST", ts.URL+"/hello.txt", strings.NewReader("hello"))
+	req.Header.Set("Authorization", "bearer "+token.String())
+	if resp, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	} else if resp.Body.Close(); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	waitFor := func(name string, cond func() bool) {
+		t.Helper()
+		for i := 0; !cond(); i++ {
+			if i > 200 {
+				t.Fatalf("timeout: %s", name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitFor("dead letter", func() bool { return len(webhooks.Failed()) == 1 })
+
+	failed := webhooks.Failed()[0]
+	if failed.URL != hook.URL || failed.Attempts != 3 || failed.Event.Type != "publish" || failed.Event.Key != "hello.txt" || failed.Event.Revision != 1 {
+		t.Errorf("unexpected failed delivery: %#v", failed)
+	}
+
+	if reloaded, err := NewWebhooks([]string{hook.URL}, secret, 3, deadLetter); err != nil {
+		t.Fatalf("failed to reload dead-letter log: %s", err)
+	} else if fs := reloaded.Failed(); len(fs) != 1 || fs[0].Event.ID != failed.Event.ID {
+		t.Errorf("dead-letter log is not persisted: %#v", fs)
+	}
+
+	admin, _ := NewScopedToken(sec, APIPrefix, ScopeAdmin)
+	replay := func(id string) int {
+		req, _ := http.NewRequest("POST", ts.URL+"/"+APIPrefix+"v1/webhooks/replay?id="+id, nil)
+		req.Header.Set("Authorization", "bearer "+admin.String())
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to replay: %s", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := replay("no-such-id"); status != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown delivery but got %d", status)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	if status := replay(failed.Event.ID); status != http.StatusOK {
+		t.Fatalf("unexpected status of replay: %d", status)
+	}
+
+	waitFor("replay", func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(received) == 1
+	})
+	if received[0].ID != failed.Event.ID {
+		t.Errorf("replayed event should have the same ID: %#v", received[0])
+	}
+	if fs := webhooks.Failed(); len(fs) != 0 {
+		t.Errorf("replayed delivery should be removed: %#v", fs)
+	}
+	if fs, err := readDeadLetter(deadLetter); err != nil || len(fs) != 0 {
+		t.Errorf("replayed delivery should be removed from dead-letter log: %#v (error=%v)", fs, err)
+	}
+}